@@ -0,0 +1,282 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/logging"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// accessLogLog logs the failures of the AccessLog
+var accessLogLog = logging.Named("AccessLog")
+
+// Formats of the access log
+const (
+	AccessLogFormatJSON     = "json"     // one JSON object per line, with the request id and optionally the request body
+	AccessLogFormatCombined = "combined" // the Apache combined log format, for the usual log analyzers
+)
+
+// redactedValue replaces the values of redacted fields
+const redactedValue = "[REDACTED]"
+
+// routeSampleRate is the share of the requests under a path prefix that are logged
+type routeSampleRate struct {
+	prefix string
+	rate   float64
+}
+
+// AccessLog writes a line per HTTP request, for traffic forensics. Requests of high-volume routes, e.g. /events,
+// can be sampled, requests answered with an error are always logged. Values of the redacted fields are replaced in
+// the logged query strings and request bodies, so that personal data does not end up in the log.
+type AccessLog struct {
+	format    string
+	out       io.Writer
+	file      *os.File // nil for stdout
+	rates     []routeSampleRate
+	bodyBytes int
+	redact    map[string]bool // lowercased field names
+
+	mu sync.Mutex // serializes the lines
+}
+
+// NewAccessLog creates the access log, nil if it is disabled
+func NewAccessLog(cfg *config.AccessLogConfig) (*AccessLog, error) {
+	if cfg.Format == "" {
+		return nil, nil
+	}
+	if cfg.Format != AccessLogFormatJSON && cfg.Format != AccessLogFormatCombined {
+		return nil, fmt.Errorf("unknown access log format %q, expected %s or %s", cfg.Format, AccessLogFormatJSON, AccessLogFormatCombined)
+	}
+	if cfg.BodyBytes < 0 {
+		return nil, fmt.Errorf("access log body bytes cannot be negative")
+	}
+	a := &AccessLog{format: cfg.Format, out: os.Stdout, bodyBytes: cfg.BodyBytes, redact: make(map[string]bool)}
+	for prefix, value := range cfg.SampleRates {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("route %q of the access log sample rates must start with /", prefix)
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid access log sample rate %q of route %s, expected a number between 0 and 1", value, prefix)
+		}
+		a.rates = append(a.rates, routeSampleRate{prefix: strings.TrimSuffix(prefix, "/"), rate: rate})
+	}
+	// The longest prefix is matched first
+	slices.SortFunc(a.rates, func(x, y routeSampleRate) int { return len(y.prefix) - len(x.prefix) })
+	for _, field := range strings.Split(cfg.RedactFields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			a.redact[strings.ToLower(field)] = true
+		}
+	}
+	if cfg.Output != "" && cfg.Output != "stdout" {
+		file, err := os.OpenFile(cfg.Output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %w", err)
+		}
+		a.out, a.file = file, file
+	}
+	return a, nil
+}
+
+// Handler logs the requests once they are handled
+func (a *AccessLog) Handler(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := responseStatus(c, err)
+	rate := a.sampleRate(c.Path())
+	if status < fiber.StatusBadRequest && rate < 1 && rand.Float64() >= rate {
+		return err
+	}
+
+	var line []byte
+	if a.format == AccessLogFormatJSON {
+		line = a.jsonLine(c, start, status, rate)
+	} else {
+		line = a.combinedLine(c, start, status)
+	}
+	a.mu.Lock()
+	_, writeErr := a.out.Write(line)
+	a.mu.Unlock()
+	if writeErr != nil {
+		accessLogLog.Warnf("Failed to write the access log: %v", writeErr)
+	}
+	return err
+}
+
+// Close closes the file of the log, it is safe to call on a nil log
+func (a *AccessLog) Close() error {
+	if a == nil || a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// sampleRate returns the share of the requests of a path that are logged
+func (a *AccessLog) sampleRate(path string) float64 {
+	for _, route := range a.rates {
+		if path == route.prefix || strings.HasPrefix(path, route.prefix+"/") || route.prefix == "" {
+			return route.rate
+		}
+	}
+	return 1
+}
+
+// accessLogEntry is a line of the json format
+type accessLogEntry struct {
+	Time          string          `json:"time"`
+	RequestID     string          `json:"request_id,omitempty"`
+	RemoteIP      string          `json:"remote_ip"`
+	Method        string          `json:"method"`
+	Path          string          `json:"path"`
+	Query         string          `json:"query,omitempty"`
+	Route         string          `json:"route"`
+	Status        int             `json:"status"`
+	DurationMS    float64         `json:"duration_ms"`
+	RequestBytes  int             `json:"request_bytes"`
+	ResponseBytes int             `json:"response_bytes"`
+	UserAgent     string          `json:"user_agent,omitempty"`
+	Referer       string          `json:"referer,omitempty"`
+	SampleRate    float64         `json:"sample_rate"`
+	RequestBody   json.RawMessage `json:"request_body,omitempty"`
+}
+
+func (a *AccessLog) jsonLine(c *fiber.Ctx, start time.Time, status int, rate float64) []byte {
+	requestID, _ := c.Locals(logging.RequestIDKey).(string)
+	route := c.Route().Path
+	if route == "/" && c.Path() != "/" {
+		route = "unmatched"
+	}
+	entry := accessLogEntry{
+		Time:          start.UTC().Format(time.RFC3339Nano),
+		RequestID:     requestID,
+		RemoteIP:      c.IP(),
+		Method:        c.Method(),
+		Path:          c.Path(),
+		Query:         a.redactQuery(c.Request().URI().QueryString()),
+		Route:         route,
+		Status:        status,
+		DurationMS:    float64(time.Since(start).Microseconds()) / 1000,
+		RequestBytes:  len(c.Request().Body()),
+		ResponseBytes: a.responseBytes(c),
+		UserAgent:     c.Get(fiber.HeaderUserAgent),
+		Referer:       c.Get(fiber.HeaderReferer),
+		SampleRate:    rate,
+	}
+	if a.bodyBytes > 0 {
+		entry.RequestBody = a.redactBody(c)
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line = []byte(`{"error":"cannot encode access log entry"}`)
+	}
+	return append(line, '\n')
+}
+
+// combinedLine formats a request in the Apache combined log format, the user is not known
+func (a *AccessLog) combinedLine(c *fiber.Ctx, start time.Time, status int) []byte {
+	uri := c.Path()
+	if query := a.redactQuery(c.Request().URI().QueryString()); query != "" {
+		uri += "?" + query
+	}
+	size := "-"
+	if n := a.responseBytes(c); n > 0 {
+		size = strconv.Itoa(n)
+	}
+	referer, userAgent := c.Get(fiber.HeaderReferer), c.Get(fiber.HeaderUserAgent)
+	if referer == "" {
+		referer = "-"
+	}
+	if userAgent == "" {
+		userAgent = "-"
+	}
+	return fmt.Appendf(nil, "%s - - [%s] \"%s %s %s\" %d %s %q %q\n",
+		c.IP(), start.Format("02/Jan/2006:15:04:05 -0700"), c.Method(), uri, c.Request().Header.Protocol(), status, size, referer, userAgent)
+}
+
+// responseBytes returns the size of the response body, 0 for streamed responses whose body is not written yet
+func (a *AccessLog) responseBytes(c *fiber.Ctx) int {
+	if c.Response().IsBodyStream() {
+		return 0
+	}
+	return len(c.Response().Body())
+}
+
+// redactQuery replaces the values of the redacted query parameters
+func (a *AccessLog) redactQuery(query []byte) string {
+	if len(query) == 0 {
+		return ""
+	}
+	values, err := url.ParseQuery(string(query))
+	if err != nil {
+		return redactedValue
+	}
+	redacted := false
+	for key, list := range values {
+		if a.redact[strings.ToLower(key)] {
+			for i := range list {
+				list[i] = redactedValue
+			}
+			redacted = true
+		}
+	}
+	if !redacted {
+		return string(query)
+	}
+	return values.Encode()
+}
+
+// redactBody returns the JSON request body with the values of the redacted fields replaced at any depth, e.g. in
+// the events of a bulk request and in their metadata, truncated to the logged bytes as a JSON string. Bodies that
+// are not JSON, e.g. compressed, are not logged.
+func (a *AccessLog) redactBody(c *fiber.Ctx) json.RawMessage {
+	body := c.Request().Body()
+	if len(body) == 0 || c.Get(fiber.HeaderContentEncoding) != "" {
+		return nil
+	}
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil
+	}
+	redacted, err := json.Marshal(a.redactValue(value))
+	if err != nil {
+		return nil
+	}
+	if len(redacted) <= a.bodyBytes {
+		return redacted
+	}
+	truncated, _ := json.Marshal(string(redacted[:a.bodyBytes]) + "...")
+	return truncated
+}
+
+func (a *AccessLog) redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if a.redact[strings.ToLower(key)] {
+				v[key] = redactedValue
+			} else {
+				v[key] = a.redactValue(field)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = a.redactValue(v[i])
+		}
+	}
+	return value
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ AdminHandler = &adminHandler{nil}
+
+type adminHandler struct {
+	adminService domain.AdminService
+}
+
+// GetColumnCompression returns compression statistics of the events table columns
+// @Summary Column compression statistics
+// @Description Report the codec, type and compressed/uncompressed size of each column of the events table
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} domain.ColumnCompressionResponse "Column compression retrieved successfully"
+// @Failure 500 {object} domain.ColumnCompressionResponse "Internal server error"
+// @Router /admin/compression [get]
+func (a adminHandler) GetColumnCompression(ctx *fiber.Ctx) error {
+	resp, err := a.adminService.GetColumnCompression(ctx.UserContext())
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.ColumnCompressionResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func NewAdminHandler(adminService domain.AdminService) AdminHandler {
+	return &adminHandler{adminService: adminService}
+}
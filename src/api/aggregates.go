@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ AggregateHandler = &aggregateHandler{nil}
+
+type aggregateHandler struct {
+	aggregateService domain.AggregateService
+}
+
+// GetAggregatePublisher reports the flush aggregate publisher and its downstream consumers
+// @Summary Flush aggregate publisher
+// @Description Report the flush aggregates published, failed and dropped by this instance, the lag between a flush and its publication, whether publishing is paused, and the downstream consumers: the length and consumer group lag of the stream in stream mode, the subscribers in pubsub mode
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} domain.AggregatePublisherResponse "Aggregate publisher retrieved successfully"
+// @Failure 404 {object} domain.AggregatePublisherResponse "Flush aggregates are not published"
+// @Failure 500 {object} domain.AggregatePublisherResponse "Internal server error"
+// @Router /admin/aggregates [get]
+func (a aggregateHandler) GetAggregatePublisher(ctx *fiber.Ctx) error {
+	resp, err := a.aggregateService.GetAggregatePublisher(ctx.UserContext())
+	if err != nil {
+		return ctx.Status(aggregateErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// PauseAggregates pauses publishing the flush aggregates
+// @Summary Pause publishing flush aggregates
+// @Description Hold the flush aggregates instead of publishing them, on all instances, to relieve downstream consumers. Other instances pick the pause up within FEATURE_FLAGS_REFRESH_INTERVAL_SECONDS
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} domain.AggregatePublisherResponse "Aggregate publishing paused"
+// @Failure 404 {object} domain.AggregatePublisherResponse "Flush aggregates are not published"
+// @Failure 500 {object} domain.AggregatePublisherResponse "Internal server error"
+// @Router /admin/aggregates/pause [post]
+func (a aggregateHandler) PauseAggregates(ctx *fiber.Ctx) error {
+	resp, err := a.aggregateService.PauseAggregates(ctx.UserContext())
+	if err != nil {
+		return ctx.Status(aggregateErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// ResumeAggregates resumes publishing the flush aggregates
+// @Summary Resume publishing flush aggregates
+// @Description Resume publishing the flush aggregates on all instances, the aggregates held while paused are published first, in order
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} domain.AggregatePublisherResponse "Aggregate publishing resumed"
+// @Failure 404 {object} domain.AggregatePublisherResponse "Flush aggregates are not published"
+// @Failure 500 {object} domain.AggregatePublisherResponse "Internal server error"
+// @Router /admin/aggregates/resume [post]
+func (a aggregateHandler) ResumeAggregates(ctx *fiber.Ctx) error {
+	resp, err := a.aggregateService.ResumeAggregates(ctx.UserContext())
+	if err != nil {
+		return ctx.Status(aggregateErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func aggregateErrorStatus(err error) int {
+	if errors.Is(err, services.ErrAggregatesDisabled) {
+		return fiber.StatusNotFound
+	}
+	return fiber.StatusInternalServerError
+}
+
+func NewAggregateHandler(aggregateService domain.AggregateService) AggregateHandler {
+	return &aggregateHandler{aggregateService: aggregateService}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ APIKeyHandler = &apiKeyHandler{nil}
+
+type apiKeyHandler struct {
+	keyService domain.APIKeyService
+}
+
+// ListAPIKeys lists the allowed API keys
+// @Summary List API keys
+// @Description List the API keys allowed to call /events and /metrics by producer id, with their effective limits and the events they stored today (UTC)
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} domain.APIKeysResponse "API keys retrieved successfully"
+// @Failure 500 {object} domain.APIKeysResponse "Internal server error"
+// @Router /admin/api-keys [get]
+func (a apiKeyHandler) ListAPIKeys(ctx *fiber.Ctx) error {
+	resp, err := a.keyService.ListAPIKeys(ctx.UserContext())
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// CreateAPIKey creates an API key
+// @Summary Create an API key
+// @Description Generate an API key, returned only once in the response, or allow an existing key by its producer id. Creating a key that exists replaces its limits.
+// @Description Other instances accept the key within AUTH_REFRESH_INTERVAL_SECONDS.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param key body domain.APIKeyRequest true "API key"
+// @Success 200 {object} domain.APIKeysResponse "API key created successfully"
+// @Failure 400 {object} domain.APIKeysResponse "Invalid request"
+// @Failure 409 {object} domain.APIKeysResponse "API key is configured in AUTH_API_KEYS"
+// @Failure 500 {object} domain.APIKeysResponse "Internal server error"
+// @Router /admin/api-keys [post]
+func (a apiKeyHandler) CreateAPIKey(ctx *fiber.Ctx) error {
+	var req domain.APIKeyRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.APIKeysResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	if err := validations.ValidateAPIKeyRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.APIKeysResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := a.keyService.CreateAPIKey(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(apiKeyErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteAPIKey revokes an API key
+// @Summary Revoke an API key
+// @Description Revoke an API key created at runtime, other instances reject it within AUTH_REFRESH_INTERVAL_SECONDS. Keys of AUTH_API_KEYS cannot be revoked at runtime.
+// @Tags Admin
+// @Produce json
+// @Param producer path string true "Producer id of the API key"
+// @Success 200 {object} domain.APIKeysResponse "API key revoked successfully"
+// @Failure 400 {object} domain.APIKeysResponse "Invalid producer id"
+// @Failure 404 {object} domain.APIKeysResponse "API key not found"
+// @Failure 409 {object} domain.APIKeysResponse "API key is configured in AUTH_API_KEYS"
+// @Failure 500 {object} domain.APIKeysResponse "Internal server error"
+// @Router /admin/api-keys/{producer} [delete]
+func (a apiKeyHandler) DeleteAPIKey(ctx *fiber.Ctx) error {
+	producer := ctx.Params("producer")
+	if err := validations.ValidateProducerID(producer); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.APIKeysResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := a.keyService.DeleteAPIKey(ctx.UserContext(), producer)
+	if err != nil {
+		return ctx.Status(apiKeyErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrAPIKeyNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrAPIKeyConfigured):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusInternalServerError
+}
+
+// NewAPIKeyMiddleware returns the middleware rejecting requests whose API key is missing or not allowed with 401,
+// and the ones beyond the key's request rate with 429. For ingestion requests, a key whose events stored today
+// reached its daily quota is rejected with 429 as well, and a read-only consumer key with 403. Requests with a client token are authenticated as the token's API key.
+// Requests of a key bound to a tenant belong to that tenant, see tenantID. An X-Tenant-ID header naming another tenant is rejected
+// with 403, unless the key has the admin role: only admin keys act on behalf of any tenant.
+func NewAPIKeyMiddleware(keyService domain.APIKeyService, ingestion bool) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		tenant, role, err := keyService.Authenticate(ctx.UserContext(), producerID(ctx), ingestion)
+		if err == nil {
+			if header := ctx.Get(TenantHeader); header != "" && header != tenant && role != domain.APIKeyRoleAdmin {
+				return ctx.Status(fiber.StatusForbidden).JSON(domain.EventResponse{
+					Success: false,
+					Message: TenantHeader + " must be the tenant the API key is bound to",
+				})
+			}
+			if tenant != "" {
+				ctx.Locals(apiKeyTenantKey, tenant)
+			}
+			ctx.Locals(apiKeyRoleKey, role)
+			return ctx.Next()
+		}
+		status := fiber.StatusUnauthorized
+		switch {
+		case errors.Is(err, services.ErrAPIKeyRateLimited) || errors.Is(err, services.ErrAPIKeyQuotaExceeded):
+			status = fiber.StatusTooManyRequests
+		case errors.Is(err, services.ErrAPIKeyReadOnly):
+			status = fiber.StatusForbidden
+		}
+		return ctx.Status(status).JSON(domain.EventResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+}
+
+// NewAdminMiddleware returns the middleware rejecting requests whose API key does not have the admin role with 403,
+// it follows the API key middleware
+func NewAdminMiddleware() fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if apiKeyRole(ctx) != domain.APIKeyRoleAdmin {
+			return ctx.Status(fiber.StatusForbidden).JSON(domain.EventResponse{
+				Success: false,
+				Message: "an API key with the admin role is required",
+			})
+		}
+		return ctx.Next()
+	}
+}
+
+func NewAPIKeyHandler(keyService domain.APIKeyService) APIKeyHandler {
+	return &apiKeyHandler{keyService: keyService}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ BackfillHandler = &backfillHandler{nil}
+
+type backfillHandler struct {
+	backfillService domain.BackfillService
+}
+
+// PostBackfill uploads a chunk of historical events to a backfill job
+// @Summary Upload a backfill chunk
+// @Description Upload a chunk of historical events as newline delimited JSON, one event per line, to the backfill job of the id, created by its first chunk.
+// @Description The events are spooled to disk by day; nothing is written to ClickHouse until the job is started with POST /events/backfill/{id}/start.
+// @Tags Events
+// @Accept plain
+// @Produce json
+// @Param id path string true "Backfill job id, 1 to 64 letters, digits, '-' or '_'"
+// @Param X-Tenant-ID header string false "Tenant of the events"
+// @Param Content-Encoding header string false "Compression of the body, gzip or zstd"
+// @Param events body string true "Newline delimited JSON events"
+// @Success 200 {object} domain.BackfillResponse "Backfill chunk received"
+// @Failure 400 {object} domain.BackfillResponse "Invalid request"
+// @Failure 409 {object} domain.BackfillResponse "Backfill job was started"
+// @Failure 413 {object} domain.BackfillResponse "Body too large once decompressed"
+// @Failure 415 {object} domain.BackfillResponse "Unsupported Content-Encoding"
+// @Failure 501 {object} domain.BackfillResponse "Backfills are not enabled"
+// @Failure 500 {object} domain.BackfillResponse "Internal server error"
+// @Router /events/backfill/{id} [post]
+func (b backfillHandler) PostBackfill(ctx *fiber.Ctx) error {
+	req := domain.BackfillRequest{ID: ctx.Params("id")}
+	requestBodyBytes.WithLabelValues("/events/backfill").Observe(float64(len(ctx.Body())))
+
+	producer, tenant, agent, browser, ip := producerID(ctx), tenantID(ctx), userAgent(ctx), clientToken(ctx) != nil, clientIP(ctx)
+	maxEvents, _ := validations.MaxBulkEvents(producer, tenant)
+	events, err := parseNDJSON(ctx.Body(), false, maxEvents)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.BackfillResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+			JobID:   req.ID,
+		})
+	}
+	for i := range events {
+		events[i].Ingest.Producer = producer
+		events[i].Ingest.Tenant = tenant
+		events[i].Ingest.Source = domain.SourceBackfill
+		events[i].Ingest.UserAgent, events[i].Ingest.Browser = agent, browser
+		events[i].Ingest.ClientIP = ip
+	}
+	req.Tenant = tenant
+	req.Events = events
+
+	if err := validations.ValidateBackfillRequest(&req, maxEvents); err != nil {
+		resp := domain.BackfillResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+			JobID:   req.ID,
+			Count:   len(req.Events),
+		}
+		var violations *validations.BulkValidationError
+		if errors.As(err, &violations) {
+			resp.Errors = violations.Violations
+		}
+		return ctx.Status(fiber.StatusBadRequest).JSON(resp)
+	}
+
+	resp, err := b.backfillService.AppendBackfill(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(backfillErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// StartBackfill starts writing the events of a backfill job
+// @Summary Start a backfill job
+// @Description Stop receiving events and write the events of the job to ClickHouse in the background, day partition by day partition,
+// @Description BACKFILL_WORKERS days in parallel. Starting a failed job resumes it from the checkpoints of its days; a started job is left as is.
+// @Tags Events
+// @Produce json
+// @Param id path string true "Backfill job id"
+// @Param X-Tenant-ID header string false "Tenant of the job"
+// @Success 202 {object} domain.BackfillResponse "Backfill job started"
+// @Failure 400 {object} domain.BackfillResponse "Invalid request"
+// @Failure 404 {object} domain.BackfillResponse "Backfill job not found"
+// @Failure 501 {object} domain.BackfillResponse "Backfills are not enabled"
+// @Failure 500 {object} domain.BackfillResponse "Internal server error"
+// @Router /events/backfill/{id}/start [post]
+func (b backfillHandler) StartBackfill(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	if err := validations.ValidateBackfillID(id); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.BackfillResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+			JobID:   id,
+		})
+	}
+
+	resp, err := b.backfillService.StartBackfill(ctx.UserContext(), tenantID(ctx), id)
+	if err != nil {
+		return ctx.Status(backfillErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusAccepted).JSON(resp)
+}
+
+// GetBackfill returns the state of a backfill job
+// @Summary Get a backfill job
+// @Description Get the state of a backfill job and the progress of its day partitions: their events, the events written or skipped as already stored so far
+// @Tags Events
+// @Produce json
+// @Param id path string true "Backfill job id"
+// @Param X-Tenant-ID header string false "Tenant of the job"
+// @Success 200 {object} domain.BackfillResponse "Backfill job retrieved successfully"
+// @Failure 400 {object} domain.BackfillResponse "Invalid request"
+// @Failure 404 {object} domain.BackfillResponse "Backfill job not found"
+// @Failure 501 {object} domain.BackfillResponse "Backfills are not enabled"
+// @Router /events/backfill/{id} [get]
+func (b backfillHandler) GetBackfill(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	if err := validations.ValidateBackfillID(id); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.BackfillResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+			JobID:   id,
+		})
+	}
+
+	resp, err := b.backfillService.GetBackfill(ctx.UserContext(), tenantID(ctx), id)
+	if err != nil {
+		return ctx.Status(backfillErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// backfillErrorStatus maps an error of the BackfillService to its status code
+func backfillErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrBackfillNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrBackfillNotReceiving):
+		return fiber.StatusConflict
+	case errors.Is(err, services.ErrBackfillsDisabled):
+		return fiber.StatusNotImplemented
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+func NewBackfillHandler(backfillService domain.BackfillService) BackfillHandler {
+	return &backfillHandler{backfillService: backfillService}
+}
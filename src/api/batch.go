@@ -0,0 +1,56 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetMetricsBatch runs the metric queries of a dashboard in a single request
+// @Summary Batch of metric queries
+// @Description Run up to 50 metric queries, each with the parameters of GET /metrics, concurrently with bounded parallelism.
+// @Description Results are returned in the order of the queries with their index. A failed query does not fail the others:
+// @Description the batch is answered with 200 and success false, the failed results carry their error message.
+// @Description With pin true the batch is pinned to the current ingestion watermark, returned as as_of, so that all queries count
+// @Description the same snapshot; as_of pins it to an earlier watermark. Queries with their own as_of keep it.
+// @Tags Metrics
+// @Accept json
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant whose events are queried"
+// @Param queries body domain.MetricBatchRequest true "Metric queries"
+// @Success 200 {object} domain.MetricBatchResponse "Results of the queries"
+// @Failure 400 {object} domain.MetricBatchResponse "Invalid request"
+// @Failure 403 {object} domain.MetricBatchResponse "The role of the API key cannot group or filter by a column (code column_denied)"
+// @Failure 500 {object} domain.MetricBatchResponse "Failed to read the ingestion watermark of a pinned batch"
+// @Router /metrics/batch [post]
+func (e eventHandler) GetMetricsBatch(ctx *fiber.Ctx) error {
+	var req domain.MetricBatchRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricBatchResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+
+	tenant, role := tenantID(ctx), apiKeyRole(ctx)
+	for i := range req.Queries {
+		req.Queries[i].Tenant = tenant
+		req.Queries[i].Role = role
+	}
+	if err := validations.ValidateMetricBatchRequest(&req); err != nil {
+		status, code := validationFailure(err)
+		return ctx.Status(status).JSON(domain.MetricBatchResponse{
+			Success: false,
+			Code:    code,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	// Failed queries are reported in their results, only a failure to pin the batch fails it as a whole
+	resp, err := e.eventService.GetMetricsBatch(ctx.UserContext(), &req)
+	if err != nil && resp.Results == nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
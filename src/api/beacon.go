@@ -0,0 +1,173 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// transparentGIF is a 1x1 transparent GIF returned by the tracking pixel
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// beaconMetadataPrefix marks the parameters of a beacon event holding metadata, e.g. meta.plan=pro
+const beaconMetadataPrefix = "meta."
+
+// PixelEvent tracks an event sent as the query string of an image request
+// @Summary Tracking pixel
+// @Description Track an event from the query string of an image request, e.g. an <img> tag in an email or web page. channel defaults to web, campaign_id to none and a missing or future timestamp to the time the event is received. Metadata values are strings. The 1x1 transparent GIF is returned in any case, the status code tells whether the event was accepted.
+// @Tags Events
+// @Produce image/gif
+// @Param event_name query string true "Event name"
+// @Param user_id query string true "User id"
+// @Param channel query string false "Channel, web by default"
+// @Param campaign_id query string false "Campaign id, none by default"
+// @Param timestamp query string false "Unix seconds, or milliseconds (auto-detected), or an RFC 3339 date-time"
+// @Param tags query string false "Comma separated tags"
+// @Param meta.key query string false "Metadata value of key, any parameter starting with meta."
+// @Param tenant query string false "Tenant of the event, as images cannot send the X-Tenant-ID header"
+// @Param token query string false "Client token, as images cannot send the X-Client-Token header"
+// @Success 200 {file} file "Event accepted"
+// @Failure 400 {file} file "Invalid event"
+// @Failure 429 {file} file "Monthly quota of the tenant exceeded"
+// @Failure 503 {file} file "Service unavailable (buffer full)"
+// @Router /pixel.gif [get]
+func (e eventHandler) PixelEvent(ctx *fiber.Ctx) error {
+	values, err := url.ParseQuery(string(ctx.Request().URI().QueryString()))
+	status := fiber.StatusOK
+	if err != nil {
+		status = fiber.StatusBadRequest
+	} else {
+		status, _ = e.postBeacon(ctx, values, domain.SourcePixel)
+	}
+
+	// Pixels must never be cached, every load is an event
+	ctx.Set(fiber.HeaderCacheControl, "no-store, no-cache, must-revalidate")
+	ctx.Set(fiber.HeaderContentType, "image/gif")
+	return ctx.Status(status).Send(transparentGIF)
+}
+
+// PostBeacon tracks an event sent with navigator.sendBeacon
+// @Summary Post a beacon event
+// @Description Track a single event sent as a form-encoded body (as sent by navigator.sendBeacon with URLSearchParams or FormData strings) or as the query string. The parameters and defaults are the ones of /pixel.gif, body parameters take precedence over the query string.
+// @Tags Events
+// @Accept x-www-form-urlencoded
+// @Accept plain
+// @Produce plain
+// @Param event_name formData string true "Event name"
+// @Param user_id formData string true "User id"
+// @Param channel formData string false "Channel, web by default"
+// @Param campaign_id formData string false "Campaign id, none by default"
+// @Param timestamp formData string false "Unix seconds, or milliseconds (auto-detected), or an RFC 3339 date-time"
+// @Param tags formData string false "Comma separated tags"
+// @Param tenant formData string false "Tenant of the event, X-Tenant-ID takes precedence"
+// @Param X-Tenant-ID header string false "Tenant of the event"
+// @Param token query string false "Client token, as beacons cannot send the X-Client-Token header"
+// @Success 204 "Event accepted"
+// @Failure 400 {string} string "Invalid event"
+// @Failure 413 {string} string "Beacon body too large"
+// @Failure 429 {string} string "Monthly quota of the tenant exceeded"
+// @Failure 503 {string} string "Service unavailable (buffer full)"
+// @Router /beacon [post]
+func (e eventHandler) PostBeacon(ctx *fiber.Ctx) error {
+	if len(ctx.Body()) > validations.MaxBeaconBytes {
+		return ctx.Status(fiber.StatusRequestEntityTooLarge).
+			SendString(fmt.Sprintf("beacon body cannot be larger than %d bytes", validations.MaxBeaconBytes))
+	}
+	requestBodyBytes.WithLabelValues("/beacon").Observe(float64(len(ctx.Body())))
+
+	values, err := url.ParseQuery(string(ctx.Request().URI().QueryString()))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid query string: " + err.Error())
+	}
+	// sendBeacon sends strings as text/plain, the body is parsed as form-encoded whatever its content type
+	body, err := url.ParseQuery(string(ctx.Body()))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body: " + err.Error())
+	}
+	for key, value := range body {
+		values[key] = value
+	}
+
+	status, message := e.postBeacon(ctx, values, domain.SourceBeacon)
+	if status != fiber.StatusOK {
+		return ctx.Status(status).SendString(message)
+	}
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// postBeacon validates and tracks the event of a pixel or beacon, returning the status code and the error message if any
+func (e eventHandler) postBeacon(ctx *fiber.Ctx, values url.Values, source string) (int, string) {
+	req, err := beaconEvent(values)
+	if err != nil {
+		return fiber.StatusBadRequest, "Invalid event: " + err.Error()
+	}
+	req.Ingest.Producer = producerID(ctx)
+	req.Ingest.Tenant = tenantID(ctx)
+	if req.Ingest.Tenant == "" && clientToken(ctx) == nil {
+		req.Ingest.Tenant = values.Get("tenant")
+	}
+	req.Ingest.Source = source
+	req.Ingest.UserAgent, req.Ingest.Browser = userAgent(ctx), true
+	req.Ingest.ClientIP = clientIP(ctx)
+	req.Ingest.RawBytes = len(ctx.Body()) + len(ctx.Request().URI().QueryString())
+
+	if err := validations.ValidateBeaconEventRequest(&req, time.Now()); err != nil {
+		return fiber.StatusBadRequest, "Validation failed: " + err.Error()
+	}
+
+	if _, err := e.eventService.PostEvents(ctx.UserContext(), &req); err != nil {
+		switch {
+		case errors.Is(err, services.ErrBufferFull):
+			return fiber.StatusServiceUnavailable, "Service temporarily unavailable, please try again later"
+		case errors.Is(err, services.ErrQuotaExceeded):
+			return fiber.StatusTooManyRequests, err.Error()
+		default:
+			return fiber.StatusInternalServerError, "Internal server error: " + err.Error()
+		}
+	}
+	return fiber.StatusOK, ""
+}
+
+// beaconEvent builds an event from the parameters of a pixel or beacon
+func beaconEvent(values url.Values) (domain.EventRequest, error) {
+	req := domain.EventRequest{
+		EventName:  values.Get("event_name"),
+		Channel:    values.Get("channel"),
+		CampaignID: values.Get("campaign_id"),
+		UserID:     values.Get("user_id"),
+	}
+	if timestamp := values.Get("timestamp"); timestamp != "" {
+		var err error
+		if req.Timestamp, err = strconv.ParseInt(timestamp, 10, 64); err != nil {
+			t, err := domain.ParseTimestamp(timestamp)
+			if err != nil {
+				return req, err
+			}
+			req.SetTime(t)
+		}
+	}
+	if tags := values.Get("tags"); tags != "" {
+		req.Tags = strings.Split(tags, ",")
+	}
+	for key := range values {
+		if name, ok := strings.CutPrefix(key, beaconMetadataPrefix); ok {
+			if req.Metadata == nil {
+				req.Metadata = map[string]any{}
+			}
+			req.Metadata[name] = values.Get(key)
+		}
+	}
+	return req, nil
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+	"net/url"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ CatalogHandler = &catalogHandler{nil}
+
+type catalogHandler struct {
+	catalogService domain.CatalogService
+}
+
+// ListCatalog lists the documented event names of the tenant
+// @Summary List the event catalog
+// @Description List the documented event names of the tenant with their owner, description and expected metadata keys,
+// @Description cross-referenced with the events ingested in the last days: their number, the last day they were seen,
+// @Description the expected metadata keys no event carried and the keys carried but not documented.
+// @Tags Catalog
+// @Produce json
+// @Param days query int false "Ingestion days including today the documentation is compared with (default 30)"
+// @Param X-Tenant-ID header string false "Tenant whose catalog is listed"
+// @Success 200 {object} domain.CatalogResponse "Event catalog retrieved successfully"
+// @Failure 400 {object} domain.CatalogResponse "Invalid request"
+// @Failure 500 {object} domain.CatalogResponse "Internal server error"
+// @Router /catalog [get]
+func (c catalogHandler) ListCatalog(ctx *fiber.Ctx) error {
+	req, err := catalogRequest(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.CatalogResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+
+	resp, err := c.catalogService.ListCatalog(ctx.UserContext(), req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// GetCatalogEntry returns the documentation of an event name of the tenant
+// @Summary Get an event catalog entry
+// @Description Get the documentation of an event name, cross-referenced with its events ingested in the last days
+// @Tags Catalog
+// @Produce json
+// @Param event_name path string true "Event name"
+// @Param days query int false "Ingestion days including today the documentation is compared with (default 30)"
+// @Param X-Tenant-ID header string false "Tenant whose catalog is read"
+// @Success 200 {object} domain.CatalogResponse "Event catalog retrieved successfully"
+// @Failure 400 {object} domain.CatalogResponse "Invalid request"
+// @Failure 404 {object} domain.CatalogResponse "Event name is not documented"
+// @Failure 500 {object} domain.CatalogResponse "Internal server error"
+// @Router /catalog/{event_name} [get]
+func (c catalogHandler) GetCatalogEntry(ctx *fiber.Ctx) error {
+	req, err := catalogRequest(ctx)
+	if err == nil {
+		req.EventName, err = catalogEventName(ctx)
+	}
+	if err == nil {
+		err = validations.ValidateCatalogEventName(req.Tenant, req.EventName)
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.CatalogResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+
+	resp, err := c.catalogService.ListCatalog(ctx.UserContext(), req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	if len(resp.Entries) == 0 {
+		return ctx.Status(fiber.StatusNotFound).JSON(domain.CatalogResponse{
+			Success: false,
+			Message: "Event name " + req.EventName + " is not documented",
+			Days:    resp.Days,
+			Entries: resp.Entries,
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// SetCatalogEntry documents an event name of the tenant
+// @Summary Document an event name
+// @Description Document an event name with its owner, description and expected metadata keys, replacing its earlier documentation
+// @Tags Catalog
+// @Accept json
+// @Produce json
+// @Param event_name path string true "Event name"
+// @Param X-Tenant-ID header string false "Tenant whose event name is documented"
+// @Param entry body domain.CatalogEntryRequest true "Documentation of the event name"
+// @Success 200 {object} domain.CatalogResponse "Event catalog retrieved successfully"
+// @Failure 400 {object} domain.CatalogResponse "Invalid request"
+// @Failure 500 {object} domain.CatalogResponse "Internal server error"
+// @Router /catalog/{event_name} [put]
+func (c catalogHandler) SetCatalogEntry(ctx *fiber.Ctx) error {
+	var req domain.CatalogEntryRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.CatalogResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	req.Tenant = tenantID(ctx)
+	eventName, err := catalogEventName(ctx)
+	if err == nil {
+		req.EventName = eventName
+		err = validations.ValidateCatalogEntryRequest(&req)
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.CatalogResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := c.catalogService.SetCatalogEntry(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteCatalogEntry removes the documentation of an event name of the tenant
+// @Summary Delete an event catalog entry
+// @Description Remove the documentation of an event name, its events are not affected
+// @Tags Catalog
+// @Produce json
+// @Param event_name path string true "Event name"
+// @Param X-Tenant-ID header string false "Tenant whose event name is deleted"
+// @Success 200 {object} domain.CatalogResponse "Event documentation deleted successfully"
+// @Failure 400 {object} domain.CatalogResponse "Invalid request"
+// @Failure 404 {object} domain.CatalogResponse "Event name is not documented"
+// @Failure 500 {object} domain.CatalogResponse "Internal server error"
+// @Router /catalog/{event_name} [delete]
+func (c catalogHandler) DeleteCatalogEntry(ctx *fiber.Ctx) error {
+	tenant := tenantID(ctx)
+	eventName, err := catalogEventName(ctx)
+	if err == nil {
+		err = validations.ValidateCatalogEventName(tenant, eventName)
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.CatalogResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := c.catalogService.DeleteCatalogEntry(ctx.UserContext(), tenant, eventName)
+	if err != nil {
+		if errors.Is(err, services.ErrCatalogEntryNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// GetCatalogCoverage reports the observed event names of the tenant that are not documented
+// @Summary Event catalog coverage
+// @Description Report the event names ingested in the last days without documentation, most frequent first, the share of the events
+// @Description whose name is documented, and the documented event names without events in the last days.
+// @Tags Catalog
+// @Produce json
+// @Param days query int false "Ingestion days including today (default 30)"
+// @Param X-Tenant-ID header string false "Tenant whose catalog is checked"
+// @Success 200 {object} domain.CatalogCoverageResponse "Catalog coverage retrieved successfully"
+// @Failure 400 {object} domain.CatalogCoverageResponse "Invalid request"
+// @Failure 500 {object} domain.CatalogCoverageResponse "Internal server error"
+// @Router /catalog/coverage [get]
+func (c catalogHandler) GetCatalogCoverage(ctx *fiber.Ctx) error {
+	req, err := catalogRequest(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.CatalogCoverageResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+
+	resp, err := c.catalogService.GetCatalogCoverage(ctx.UserContext(), req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// catalogRequest parses and validates the tenant and days of a catalog request
+func catalogRequest(ctx *fiber.Ctx) (*domain.CatalogRequest, error) {
+	req := &domain.CatalogRequest{Tenant: tenantID(ctx)}
+	if str := ctx.Query("days"); str != "" {
+		days, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, errors.New("Invalid 'days' parameter: " + err.Error())
+		}
+		req.Days = days
+	}
+	if err := validations.ValidateCatalogRequest(req); err != nil {
+		return nil, errors.New("Validation failed: " + err.Error())
+	}
+	return req, nil
+}
+
+// catalogEventName returns the event name of the path, which may be escaped
+func catalogEventName(ctx *fiber.Ctx) (string, error) {
+	eventName, err := url.PathUnescape(ctx.Params("event_name"))
+	if err != nil {
+		return "", fiber.NewError(fiber.StatusBadRequest, "invalid event_name: "+err.Error())
+	}
+	return eventName, nil
+}
+
+func NewCatalogHandler(catalogService domain.CatalogService) CatalogHandler {
+	return &catalogHandler{catalogService: catalogService}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bytes"
+	"kucukaslan/clickhouse/proxy"
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// clientIPKey is the fiber.Ctx local holding the resolved client address of the request
+const clientIPKey = "client_ip"
+
+// NewClientIPMiddleware resolves the client address of requests relayed by trusted proxies from the header they append to,
+// e.g. X-Forwarded-For: client, proxy1, proxy2. The addresses are read from right to left, skipping trusted proxies,
+// so that addresses made up by the client itself are ignored. Requests from other peers keep the peer's address.
+func NewClientIPMiddleware(header string, trusted proxy.Networks) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		ip := ctx.Context().RemoteIP()
+		if trusted.Contains(ip) {
+			// A header repeated by several proxies is a single list
+			values := ctx.Request().Header.PeekAll(header)
+			if forwarded := forwardedClient(string(bytes.Join(values, []byte(","))), trusted); forwarded != nil {
+				ip = forwarded
+			}
+		}
+		ctx.Locals(clientIPKey, ip.String())
+		return ctx.Next()
+	}
+}
+
+// forwardedClient returns the rightmost address of a forwarded chain that is not a trusted proxy,
+// the leftmost one if all are trusted, nil if the chain is empty or holds an invalid address
+func forwardedClient(chain string, trusted proxy.Networks) net.IP {
+	addresses := strings.Split(chain, ",")
+	var client net.IP
+	for i := len(addresses) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(addresses[i])
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			address = host
+		}
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil
+		}
+		client = ip
+		if !trusted.Contains(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// clientIP returns the address of the request's client, behind trusted proxies the one they forwarded
+func clientIP(ctx *fiber.Ctx) string {
+	if ip, ok := ctx.Locals(clientIPKey).(string); ok {
+		return ip
+	}
+	return ctx.Context().RemoteIP().String()
+}
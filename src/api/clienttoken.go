@@ -0,0 +1,145 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// clientTokenKey is the fiber.Ctx local holding the verified claims of the request's client token
+const clientTokenKey = "client_token"
+
+var _ ClientTokenHandler = &clientTokenHandler{nil}
+
+type clientTokenHandler struct {
+	tokenService domain.ClientTokenService
+}
+
+// IssueToken issues a short-lived client token for the caller's API key
+// @Summary Issue a client token
+// @Description Issue a short-lived signed token for a web or mobile client, to be requested by the client's backend so that the API key never reaches browsers.
+// @Description Clients send it in the X-Client-Token header, or the token query parameter for /pixel.gif and /beacon, and their events are attributed to this API key and tenant.
+// @Description With replay protection, every request with the token carries X-Request-Timestamp (Unix seconds), a single-use X-Request-Nonce and X-Request-Signature,
+// @Description the hex encoded HMAC-SHA256 of "<timestamp>.<nonce>" keyed with the returned signing_key (or the ts, nonce and sig query parameters).
+// @Tags Events
+// @Accept json
+// @Produce json
+// @Param X-API-Key header string true "API key of the producer"
+// @Param X-Tenant-ID header string false "Tenant of the client's events, with AUTH_ENABLED=1 only the one the API key is bound to or any for admin keys"
+// @Param request body domain.ClientTokenRequest false "Token lifetime"
+// @Success 200 {object} domain.ClientTokenResponse "Token issued successfully"
+// @Failure 400 {object} domain.ClientTokenResponse "Invalid request"
+// @Failure 401 {object} domain.ClientTokenResponse "Missing API key"
+// @Failure 501 {object} domain.ClientTokenResponse "Client tokens are not enabled"
+// @Router /tokens [post]
+func (t clientTokenHandler) IssueToken(ctx *fiber.Ctx) error {
+	// A client token cannot be exchanged for another one, the API key is required
+	producer := domain.ProducerID(ctx.Get(APIKeyHeader))
+	if producer == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(domain.ClientTokenResponse{
+			Success: false,
+			Message: APIKeyHeader + " header is required",
+		})
+	}
+
+	var req domain.ClientTokenRequest
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&req); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.ClientTokenResponse{
+				Success: false,
+				Message: "Invalid request body: " + err.Error(),
+			})
+		}
+	}
+	req.Producer = producer
+	// The tenant of an API key bound to one, X-Tenant-ID is rejected if it names another
+	req.Tenant = tenantID(ctx)
+
+	if err := validations.ValidateClientTokenRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.ClientTokenResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := t.tokenService.IssueToken(ctx.UserContext(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrClientTokensDisabled) {
+			return ctx.Status(fiber.StatusNotImplemented).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// NewClientTokenMiddleware returns the middleware verifying the client token of ingestion requests,
+// sent in the X-Client-Token header or the token query parameter. The events of a request with a valid token
+// are attributed to the token's producer and tenant. If required, requests need either an API key or a token.
+// With replay protection, token requests also need a fresh signed nonce, replayed requests are answered 409 Conflict.
+func NewClientTokenMiddleware(tokenService domain.ClientTokenService, required bool) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		token := ctx.Get(ClientTokenHeader)
+		if token == "" {
+			// Images and beacons cannot send headers
+			token = ctx.Query("token")
+		}
+		if token == "" {
+			if required && ctx.Get(APIKeyHeader) == "" {
+				return ctx.Status(fiber.StatusUnauthorized).JSON(domain.EventResponse{
+					Success: false,
+					Message: APIKeyHeader + " or " + ClientTokenHeader + " header is required",
+				})
+			}
+			return ctx.Next()
+		}
+
+		claims, err := tokenService.VerifyToken(token)
+		if err != nil {
+			return ctx.Status(fiber.StatusUnauthorized).JSON(domain.EventResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		if err := tokenService.CheckReplay(ctx.UserContext(), token, claims, requestProof(ctx)); err != nil {
+			status := fiber.StatusUnauthorized
+			switch {
+			case errors.Is(err, services.ErrReplayedRequest):
+				status = fiber.StatusConflict
+			case !errors.Is(err, services.ErrInvalidRequestProof) && !errors.Is(err, services.ErrStaleRequest):
+				status = fiber.StatusInternalServerError
+			}
+			return ctx.Status(status).JSON(domain.EventResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		ctx.Locals(clientTokenKey, claims)
+		return ctx.Next()
+	}
+}
+
+// requestProof returns the timestamp, nonce and signature of a request sent with a client token,
+// from the X-Request-* headers or the ts, nonce and sig query parameters
+func requestProof(ctx *fiber.Ctx) domain.ClientRequestProof {
+	proof := domain.ClientRequestProof{
+		Nonce:     ctx.Get(RequestNonceHeader, ctx.Query("nonce")),
+		Signature: ctx.Get(RequestSignatureHeader, ctx.Query("sig")),
+	}
+	// A malformed timestamp is left 0 and rejected
+	proof.Timestamp, _ = strconv.ParseInt(ctx.Get(RequestTimestampHeader, ctx.Query("ts")), 10, 64)
+	return proof
+}
+
+// clientToken returns the verified claims of the request's client token, nil if it has none
+func clientToken(ctx *fiber.Ctx) *domain.ClientTokenClaims {
+	claims, _ := ctx.Locals(clientTokenKey).(*domain.ClientTokenClaims)
+	return claims
+}
+
+func NewClientTokenHandler(tokenService domain.ClientTokenService) ClientTokenHandler {
+	return &clientTokenHandler{tokenService: tokenService}
+}
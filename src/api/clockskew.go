@@ -0,0 +1,61 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/services"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// ServerTimeHeader holds the time of the server (Unix milliseconds) in the responses of ingestion requests,
+	// so that SDKs can measure the skew of the device's clock
+	ServerTimeHeader = "X-Server-Time"
+	// ClientTimeHeader holds the time of the client's clock (Unix milliseconds) when it sent an ingestion request
+	ClientTimeHeader = "X-Client-Time"
+)
+
+// clockSkewKey is the fiber.Ctx local holding the skew the event times of the request are corrected by
+const clockSkewKey = "clock_skew"
+
+// minClockSkew is the smallest skew corrected, below it the measurement is dominated by the network latency
+const minClockSkew = time.Second
+
+// NewClockSkewMiddleware returns the middleware answering ingestion requests with the server time in X-Server-Time,
+// and measuring the skew of the client's clock from X-Client-Time: the server's time at receipt minus the client's
+// time at sending, which includes the network latency. Skews are tracked per API key. With correct set, the events
+// of the request are moved by its skew, or by the tracked skew of its API key without X-Client-Time, see clockSkew.
+// Skews are corrected in whole seconds, so that the retries of an event, measured a few milliseconds apart, keep its
+// deduplication key.
+func NewClockSkewMiddleware(tracker *services.ClockSkewTracker, correct bool) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		now := time.Now()
+		ctx.Set(ServerTimeHeader, strconv.FormatInt(now.UnixMilli(), 10))
+
+		producer := producerID(ctx)
+		skew, measured := time.Duration(0), false
+		if header := ctx.Get(ClientTimeHeader); header != "" {
+			if clientTime, err := strconv.ParseInt(header, 10, 64); err == nil && clientTime > 0 {
+				skew, measured = now.Sub(time.UnixMilli(clientTime)), true
+				tracker.Observe(producer, skew)
+			}
+		}
+		if correct {
+			if !measured {
+				skew, measured = tracker.Skew(producer)
+			}
+			skew = skew.Round(time.Second)
+			if measured && (skew >= minClockSkew || skew <= -minClockSkew) {
+				ctx.Locals(clockSkewKey, skew)
+			}
+		}
+		return ctx.Next()
+	}
+}
+
+// clockSkew returns the skew the event times of the request are corrected by, false if they are not corrected
+func clockSkew(ctx *fiber.Ctx) (time.Duration, bool) {
+	skew, ok := ctx.Locals(clockSkewKey).(time.Duration)
+	return skew, ok
+}
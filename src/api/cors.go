@@ -0,0 +1,31 @@
+package api
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// NewCORS returns the middleware answering preflight requests and adding the CORS headers for the configured origins,
+// so that browser SDKs can call the API from web apps
+func NewCORS(cfg *config.CORSConfig) (fiber.Handler, error) {
+	origins := strings.Split(cfg.AllowedOrigins, ",")
+	for i, origin := range origins {
+		origins[i] = strings.TrimSpace(origin)
+		if cfg.AllowCredentials && origins[i] == "*" {
+			// Browsers reject credentials for wildcard origins, list the origins instead
+			return nil, fmt.Errorf("CORS credentials cannot be allowed for any origin")
+		}
+	}
+	return cors.New(cors.Config{
+		AllowOrigins:     strings.Join(origins, ","),
+		AllowMethods:     cfg.AllowedMethods,
+		AllowHeaders:     cfg.AllowedHeaders,
+		ExposeHeaders:    cfg.ExposedHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           cfg.MaxAgeSeconds,
+	}), nil
+}
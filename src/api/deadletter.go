@@ -0,0 +1,59 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ DeadLetterHandler = &deadLetterHandler{nil}
+
+type deadLetterHandler struct {
+	deadLetterService domain.DeadLetterService
+}
+
+// ListDeadLetters lists the dead-lettered events of the tenant
+// @Summary List dead-lettered events
+// @Description List the events of the tenant whose flush still failed after EVENT_FLUSH_MAX_RETRIES retries, oldest first, with the error of the last attempt.
+// @Description Reprocess them with POST /admin/reprocess and source dead_letter once ClickHouse is back.
+// @Description With sink, the events an optional sink (clickhouse_secondary, kafka or s3) failed to write are listed instead, reprocessed with the same sink.
+// @Tags Admin
+// @Produce json
+// @Param limit query int false "Maximum number of events (default 100)"
+// @Param sink query string false "Optional sink whose dead-lettered events are listed, e.g. kafka"
+// @Param X-Tenant-ID header string false "Tenant whose events are listed"
+// @Success 200 {object} domain.DeadLetterResponse "Dead-lettered events retrieved successfully"
+// @Failure 400 {object} domain.DeadLetterResponse "Invalid request"
+// @Failure 500 {object} domain.DeadLetterResponse "Internal server error"
+// @Router /admin/dead-letter [get]
+func (d deadLetterHandler) ListDeadLetters(ctx *fiber.Ctx) error {
+	req := domain.DeadLetterRequest{Tenant: tenantID(ctx), Sink: ctx.Query("sink")}
+	if str := ctx.Query("limit"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.DeadLetterResponse{
+				Success: false,
+				Message: "Invalid 'limit' parameter: " + err.Error(),
+			})
+		}
+		req.Limit = limit
+	}
+	if err := validations.ValidateDeadLetterRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.DeadLetterResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := d.deadLetterService.ListDeadLetters(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func NewDeadLetterHandler(deadLetterService domain.DeadLetterService) DeadLetterHandler {
+	return &deadLetterHandler{deadLetterService: deadLetterService}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/telemetry"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings of the request bodies accepted by the ingestion endpoints
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+var (
+	decompressedRequestsTotal = telemetry.NewCounterVec("http_decompressed_requests_total",
+		"Number of compressed request bodies by encoding and result (ok, invalid, too_large, unsupported)", "encoding", "result")
+	decompressedRatio = telemetry.NewHistogramVec("http_request_compression_ratio",
+		"Ratio of the decompressed to the compressed size of request bodies by encoding",
+		[]float64{1, 2, 4, 8, 16, 32, 64, 128}, "encoding")
+)
+
+// NewDecompressMiddleware decompresses the request bodies sent with a Content-Encoding of gzip or zstd, so that the
+// handlers read them as if they were sent uncompressed. Decompression stops at REQUEST_MAX_DECOMPRESSED_BYTES and
+// answers 413, so that a small compressed body cannot expand beyond the memory of the instance (zip bombs).
+func NewDecompressMiddleware(cfg *config.ServerConfig) (fiber.Handler, error) {
+	if cfg.MaxDecompressedBytes <= 0 {
+		return nil, fmt.Errorf("maximum decompressed request size must be positive, got %d", cfg.MaxDecompressedBytes)
+	}
+	maxBytes := cfg.MaxDecompressedBytes
+
+	return func(c *fiber.Ctx) error {
+		encoding := strings.ToLower(strings.TrimSpace(c.Get(fiber.HeaderContentEncoding)))
+		if encoding == "" || encoding == "identity" {
+			return c.Next()
+		}
+		if encoding != EncodingGzip && encoding != EncodingZstd {
+			decompressedRequestsTotal.WithLabelValues("other", "unsupported").Inc()
+			return c.Status(fiber.StatusUnsupportedMediaType).JSON(domain.EventResponse{
+				Success: false,
+				Message: fmt.Sprintf("Unsupported Content-Encoding %q, expected %s or %s", encoding, EncodingGzip, EncodingZstd),
+			})
+		}
+
+		compressed := c.Request().Body()
+		body, err := decompress(encoding, compressed, maxBytes)
+		if errors.Is(err, errDecompressedTooLarge) {
+			decompressedRequestsTotal.WithLabelValues(encoding, "too_large").Inc()
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(domain.EventResponse{
+				Success: false,
+				Message: fmt.Sprintf("Decompressed request body exceeds %d bytes", maxBytes),
+			})
+		}
+		if err != nil {
+			decompressedRequestsTotal.WithLabelValues(encoding, "invalid").Inc()
+			return c.Status(fiber.StatusBadRequest).JSON(domain.EventResponse{
+				Success: false,
+				Message: fmt.Sprintf("Invalid %s request body: %v", encoding, err),
+			})
+		}
+		decompressedRequestsTotal.WithLabelValues(encoding, "ok").Inc()
+		if len(compressed) > 0 {
+			decompressedRatio.WithLabelValues(encoding).Observe(float64(len(body)) / float64(len(compressed)))
+		}
+
+		// The handlers see an uncompressed body, whose size is the one counted by the ingestion metrics
+		c.Request().SetBodyRaw(body)
+		c.Request().Header.Del(fiber.HeaderContentEncoding)
+		c.Request().Header.SetContentLength(len(body))
+		return c.Next()
+	}, nil
+}
+
+// errDecompressedTooLarge is returned for bodies exceeding the maximum once decompressed
+var errDecompressedTooLarge = errors.New("decompressed body too large")
+
+// decompress decodes a body, reading at most maxBytes of its decompressed content
+func decompress(encoding string, compressed []byte, maxBytes int64) ([]byte, error) {
+	var reader io.Reader
+	switch encoding {
+	case EncodingGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	case EncodingZstd:
+		// The window is bounded as well, a frame cannot make the decoder allocate more than the body may hold
+		window := uint64(max(maxBytes, zstd.MinWindowSize))
+		zr, err := zstd.NewReader(bytes.NewReader(compressed), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(window))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		reader = zr
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(reader, maxBytes+1))
+	if err != nil {
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, errDecompressedTooLarge
+		}
+		return nil, err
+	}
+	if n > maxBytes {
+		return nil, errDecompressedTooLarge
+	}
+	return buf.Bytes(), nil
+}
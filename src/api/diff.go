@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetMetricsDiff compares the metrics of two windows
+// @Summary GET metrics diff between two windows
+// @Description Compare the metrics of two windows for the same filters and grouping, e.g. the days before and after a release.
+// @Description Each bucket of either window is reported with its counts in both, their deltas and its status: common, new (only in the compared window)
+// @Description or disappeared (only in the base window). Windows are bounded like the range of GET /metrics and counted without realtime counts and new users.
+// @Description Time buckets differ between the windows, diffs are grouped by a field or not at all.
+// @Tags Metrics
+// @Produce json
+// @Param base_from query int true "Start of the base window (Unix seconds)"
+// @Param base_to query int true "End of the base window (Unix seconds)"
+// @Param compare_from query int true "Start of the compared window (Unix seconds)"
+// @Param compare_to query int true "End of the compared window (Unix seconds)"
+// @Param event_name query string false "Event name filter"
+// @Param tag query string false "Only events with this tag, and the tags under it if it ends with /"
+// @Param group_by query string false "Group by field (channel, campaign_id, user_id, event_name, tags)"
+// @Param tag_depth query int false "With group_by=tags, roll the tags up to their first levels"
+// @Param max_abuse_score query int false "Leave out the events whose abuse score (0-100) is above it"
+// @Param X-Tenant-ID header string false "Tenant whose events are queried"
+// @Success 200 {object} domain.MetricDiffResponse "Metrics compared successfully"
+// @Failure 400 {object} domain.MetricDiffResponse "Invalid request or window too long (code range_too_long)"
+// @Failure 403 {object} domain.MetricDiffResponse "The role of the API key cannot group or filter by a column (code column_denied)"
+// @Failure 500 {object} domain.MetricDiffResponse "Internal server error"
+// @Router /metrics/diff [get]
+func (e eventHandler) GetMetricsDiff(ctx *fiber.Ctx) error {
+	req := domain.MetricDiffRequest{
+		Filter: domain.MetricRequest{Tenant: tenantID(ctx), Role: apiKeyRole(ctx)},
+	}
+	if eventName := ctx.Query("event_name"); eventName != "" {
+		req.Filter.EventName = &eventName
+	}
+	if tag := ctx.Query("tag"); tag != "" {
+		req.Filter.Tag = &tag
+	}
+	if groupBy := ctx.Query("group_by"); groupBy != "" {
+		req.Filter.GroupBy = &groupBy
+	}
+	windows := map[string]*int64{
+		"base_from": &req.BaseFrom, "base_to": &req.BaseTo, "compare_from": &req.CompareFrom, "compare_to": &req.CompareTo,
+	}
+	for name, value := range windows {
+		if str := ctx.Query(name); str != "" {
+			n, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricDiffResponse{
+					Success: false,
+					Message: "Invalid '" + name + "' parameter: " + err.Error(),
+				})
+			}
+			*value = n
+		}
+	}
+	for name, value := range map[string]**int{"tag_depth": &req.Filter.TagDepth, "max_abuse_score": &req.Filter.MaxAbuseScore} {
+		if str := ctx.Query(name); str != "" {
+			n, err := strconv.Atoi(str)
+			if err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricDiffResponse{
+					Success: false,
+					Message: "Invalid '" + name + "' parameter: " + err.Error(),
+				})
+			}
+			*value = &n
+		}
+	}
+
+	if err := validations.ValidateMetricDiffRequest(&req); err != nil {
+		status, code := validationFailure(err)
+		return ctx.Status(status).JSON(domain.MetricDiffResponse{
+			Success: false,
+			Code:    code,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := e.eventService.GetMetricsDiff(ctx.UserContext(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrRangeTooLong) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.MetricDiffResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
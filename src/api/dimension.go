@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ DimensionHandler = &dimensionHandler{nil}
+
+type dimensionHandler struct {
+	dimensionService domain.DimensionService
+}
+
+// UploadDimension uploads the rows of a dimension as CSV
+// @Summary Upload a dimension
+// @Description Replace the rows of a dimension from a CSV file with a header row. The first column is the key matched against the buckets of /metrics?enrich={name}, the other columns are attributes. Rows are stored in a table and served by a ClickHouse dictionary through dictGet.
+// @Tags Admin
+// @Accept text/csv
+// @Produce json
+// @Param name path string true "Dimension name"
+// @Param rows body string true "CSV rows with a header row, e.g. campaign_id,owner,budget"
+// @Success 200 {object} domain.DimensionResponse "Dimension uploaded successfully"
+// @Failure 400 {object} domain.DimensionResponse "Invalid request"
+// @Failure 500 {object} domain.DimensionResponse "Internal server error"
+// @Router /admin/dimensions/{name} [put]
+func (d dimensionHandler) UploadDimension(ctx *fiber.Ctx) error {
+	records, err := csv.NewReader(bytes.NewReader(ctx.Body())).ReadAll()
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.DimensionResponse{
+			Success: false,
+			Message: "Invalid CSV body: " + err.Error(),
+		})
+	}
+	if len(records) == 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.DimensionResponse{
+			Success: false,
+			Message: "CSV body must start with a header row",
+		})
+	}
+	req := domain.DimensionUploadRequest{
+		Name:    ctx.Params("name"),
+		Columns: records[0],
+		Rows:    records[1:],
+	}
+
+	if err := validations.ValidateDimensionUploadRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.DimensionResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := d.dimensionService.UploadDimension(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.DimensionResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// SetDimensionURL loads a dimension from a URL
+// @Summary Load a dimension from a URL
+// @Description Create or replace the dictionary of a dimension loading its rows from a file ClickHouse downloads from the URL and refreshes every lifetime_seconds. The file must start with a header row naming the columns, the first column is the key.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param name path string true "Dimension name"
+// @Param source body domain.DimensionURLRequest true "URL, format and columns of the dimension"
+// @Success 200 {object} domain.DimensionResponse "Dimension created successfully"
+// @Failure 400 {object} domain.DimensionResponse "Invalid request"
+// @Failure 500 {object} domain.DimensionResponse "Internal server error, e.g. the file could not be loaded"
+// @Router /admin/dimensions/{name}/url [put]
+func (d dimensionHandler) SetDimensionURL(ctx *fiber.Ctx) error {
+	var req domain.DimensionURLRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.DimensionResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	req.Name = ctx.Params("name")
+
+	if err := validations.ValidateDimensionURLRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.DimensionResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := d.dimensionService.SetDimensionURL(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// ListDimensions lists the dimensions
+// @Summary List dimensions
+// @Description List the dimensions with the state of their dictionaries: rows loaded, memory, source, refresh interval, last load and last error
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} domain.DimensionListResponse "Dimensions retrieved successfully"
+// @Failure 500 {object} domain.DimensionListResponse "Internal server error"
+// @Router /admin/dimensions [get]
+func (d dimensionHandler) ListDimensions(ctx *fiber.Ctx) error {
+	resp, err := d.dimensionService.ListDimensions(ctx.UserContext())
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.DimensionListResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// GetDimension returns a dimension
+// @Summary Get a dimension
+// @Description Get a dimension with the state of its dictionary
+// @Tags Admin
+// @Produce json
+// @Param name path string true "Dimension name"
+// @Success 200 {object} domain.DimensionResponse "Dimension retrieved successfully"
+// @Failure 400 {object} domain.DimensionResponse "Invalid dimension name"
+// @Failure 404 {object} domain.DimensionResponse "Dimension not found"
+// @Failure 500 {object} domain.DimensionResponse "Internal server error"
+// @Router /admin/dimensions/{name} [get]
+func (d dimensionHandler) GetDimension(ctx *fiber.Ctx) error {
+	return d.byName(ctx, d.dimensionService.GetDimension)
+}
+
+// ReloadDimension reloads a dimension from its source
+// @Summary Reload a dimension
+// @Description Reload the dictionary of a dimension from its uploaded rows or URL now, instead of waiting for its refresh interval
+// @Tags Admin
+// @Produce json
+// @Param name path string true "Dimension name"
+// @Success 200 {object} domain.DimensionResponse "Dimension reloaded successfully"
+// @Failure 400 {object} domain.DimensionResponse "Invalid dimension name"
+// @Failure 404 {object} domain.DimensionResponse "Dimension not found"
+// @Failure 500 {object} domain.DimensionResponse "Internal server error, e.g. the source could not be loaded"
+// @Router /admin/dimensions/{name}/reload [post]
+func (d dimensionHandler) ReloadDimension(ctx *fiber.Ctx) error {
+	return d.byName(ctx, d.dimensionService.ReloadDimension)
+}
+
+// DeleteDimension deletes a dimension
+// @Summary Delete a dimension
+// @Description Drop the dictionary of a dimension and its uploaded rows
+// @Tags Admin
+// @Produce json
+// @Param name path string true "Dimension name"
+// @Success 200 {object} domain.DimensionResponse "Dimension deleted successfully"
+// @Failure 400 {object} domain.DimensionResponse "Invalid dimension name"
+// @Failure 404 {object} domain.DimensionResponse "Dimension not found"
+// @Failure 500 {object} domain.DimensionResponse "Internal server error"
+// @Router /admin/dimensions/{name} [delete]
+func (d dimensionHandler) DeleteDimension(ctx *fiber.Ctx) error {
+	return d.byName(ctx, d.dimensionService.DeleteDimension)
+}
+
+// byName validates the dimension name of the path and responds with the result of the service call
+func (d dimensionHandler) byName(ctx *fiber.Ctx, call func(context.Context, string) (*domain.DimensionResponse, error)) error {
+	name := ctx.Params("name")
+	if err := validations.ValidateDimensionName(name); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.DimensionResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := call(ctx.UserContext(), name)
+	if err != nil {
+		if errors.Is(err, services.ErrDimensionNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func NewDimensionHandler(dimensionService domain.DimensionService) DimensionHandler {
+	return &dimensionHandler{dimensionService: dimensionService}
+}
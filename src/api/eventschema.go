@@ -0,0 +1,198 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ EventSchemaHandler = &eventSchemaHandler{nil}
+
+type eventSchemaHandler struct {
+	eventSchemaService domain.EventSchemaService
+}
+
+// ListEventSchemas lists the JSON Schemas the metadata of events is validated against
+// @Summary List event schemas
+// @Description List the JSON Schemas the metadata of events is validated against, by event name.
+// @Description The schemas are served from the cache of the instance, which reloads them within a second of a change on any instance.
+// @Tags Schemas
+// @Produce json
+// @Success 200 {object} domain.EventSchemaResponse "Event schemas retrieved successfully"
+// @Failure 409 {object} domain.EventSchemaResponse "Schemas are read from EVENT_SCHEMA_FILE"
+// @Router /schemas [get]
+func (h eventSchemaHandler) ListEventSchemas(ctx *fiber.Ctx) error {
+	resp, err := h.eventSchemaService.ListEventSchemas(ctx.UserContext(), "")
+	if err != nil {
+		return ctx.Status(eventSchemaErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// GetEventSchema returns the JSON Schema of an event name
+// @Summary Get an event schema
+// @Description Get the JSON Schema the metadata of the events of a name is validated against
+// @Tags Schemas
+// @Produce json
+// @Param event_name path string true "Event name"
+// @Success 200 {object} domain.EventSchemaResponse "Event schemas retrieved successfully"
+// @Failure 400 {object} domain.EventSchemaResponse "Invalid request"
+// @Failure 404 {object} domain.EventSchemaResponse "Event name has no schema"
+// @Failure 409 {object} domain.EventSchemaResponse "Schemas are read from EVENT_SCHEMA_FILE"
+// @Router /schemas/{event_name} [get]
+func (h eventSchemaHandler) GetEventSchema(ctx *fiber.Ctx) error {
+	eventName, err := eventSchemaName(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.EventSchemaResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := h.eventSchemaService.ListEventSchemas(ctx.UserContext(), eventName)
+	if err != nil {
+		return ctx.Status(eventSchemaErrorStatus(err)).JSON(resp)
+	}
+	if len(resp.Schemas) == 0 {
+		return ctx.Status(fiber.StatusNotFound).JSON(domain.EventSchemaResponse{
+			Success: false,
+			Message: "Event name " + eventName + " has no schema",
+			Schemas: resp.Schemas,
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// CreateEventSchema registers the JSON Schema of an event name
+// @Summary Create an event schema
+// @Description Register the JSON Schema the metadata of the events of a name is validated against.
+// @Description The schema must compile; it applies to the events ingested by every instance within a second.
+// @Tags Schemas
+// @Accept json
+// @Produce json
+// @Param schema body domain.EventSchemaRequest true "Event name and its JSON Schema"
+// @Success 201 {object} domain.EventSchemaResponse "Event schema created successfully"
+// @Failure 400 {object} domain.EventSchemaResponse "Invalid request"
+// @Failure 409 {object} domain.EventSchemaResponse "Event name already has a schema or schemas are read from EVENT_SCHEMA_FILE"
+// @Failure 500 {object} domain.EventSchemaResponse "Internal server error"
+// @Router /schemas [post]
+func (h eventSchemaHandler) CreateEventSchema(ctx *fiber.Ctx) error {
+	var req domain.EventSchemaRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.EventSchemaResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	if err := validations.ValidateEventSchemaRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.EventSchemaResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := h.eventSchemaService.CreateEventSchema(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(eventSchemaErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusCreated).JSON(resp)
+}
+
+// UpdateEventSchema replaces the JSON Schema of an event name
+// @Summary Update an event schema
+// @Description Replace the JSON Schema the metadata of the events of a name is validated against.
+// @Description The schema must compile; it applies to the events ingested by every instance within a second.
+// @Tags Schemas
+// @Accept json
+// @Produce json
+// @Param event_name path string true "Event name"
+// @Param schema body domain.EventSchemaRequest true "JSON Schema of the event name, whose event_name is taken from the path"
+// @Success 200 {object} domain.EventSchemaResponse "Event schema updated successfully"
+// @Failure 400 {object} domain.EventSchemaResponse "Invalid request"
+// @Failure 404 {object} domain.EventSchemaResponse "Event name has no schema"
+// @Failure 409 {object} domain.EventSchemaResponse "Schemas are read from EVENT_SCHEMA_FILE"
+// @Failure 500 {object} domain.EventSchemaResponse "Internal server error"
+// @Router /schemas/{event_name} [put]
+func (h eventSchemaHandler) UpdateEventSchema(ctx *fiber.Ctx) error {
+	var req domain.EventSchemaRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.EventSchemaResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	eventName, err := eventSchemaName(ctx)
+	if err == nil {
+		req.EventName = eventName
+		err = validations.ValidateEventSchemaRequest(&req)
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.EventSchemaResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := h.eventSchemaService.UpdateEventSchema(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(eventSchemaErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteEventSchema removes the JSON Schema of an event name
+// @Summary Delete an event schema
+// @Description Remove the JSON Schema of an event name, whose events are no longer validated
+// @Tags Schemas
+// @Produce json
+// @Param event_name path string true "Event name"
+// @Success 200 {object} domain.EventSchemaResponse "Event schema deleted successfully"
+// @Failure 400 {object} domain.EventSchemaResponse "Invalid request"
+// @Failure 404 {object} domain.EventSchemaResponse "Event name has no schema"
+// @Failure 409 {object} domain.EventSchemaResponse "Schemas are read from EVENT_SCHEMA_FILE"
+// @Failure 500 {object} domain.EventSchemaResponse "Internal server error"
+// @Router /schemas/{event_name} [delete]
+func (h eventSchemaHandler) DeleteEventSchema(ctx *fiber.Ctx) error {
+	eventName, err := eventSchemaName(ctx)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.EventSchemaResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := h.eventSchemaService.DeleteEventSchema(ctx.UserContext(), eventName)
+	if err != nil {
+		return ctx.Status(eventSchemaErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// eventSchemaName returns the validated event name of the path, which may be escaped
+func eventSchemaName(ctx *fiber.Ctx) (string, error) {
+	eventName, err := url.PathUnescape(ctx.Params("event_name"))
+	if err != nil {
+		return "", fiber.NewError(fiber.StatusBadRequest, "invalid event_name: "+err.Error())
+	}
+	return eventName, validations.ValidateEventSchemaName(eventName)
+}
+
+// eventSchemaErrorStatus maps an error of the EventSchemaService to its status code
+func eventSchemaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrEventSchemaNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrEventSchemaExists), errors.Is(err, services.ErrEventSchemasReadOnly):
+		return fiber.StatusConflict
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+func NewEventSchemaHandler(eventSchemaService domain.EventSchemaService) EventSchemaHandler {
+	return &eventSchemaHandler{eventSchemaService: eventSchemaService}
+}
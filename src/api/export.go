@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/buildinfo"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/telemetry"
+	"kucukaslan/clickhouse/validations"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/parquet-go/parquet-go"
+)
+
+// exportLog logs the failures of exports whose response already started
+var exportLog = logging.Named("Export")
+
+// parquetMIME is the content type of Parquet files
+const parquetMIME = "application/vnd.apache.parquet"
+
+// exportRowGroupRows is the number of events per row group of an exported Parquet file. A row group is held in memory
+// until it is complete, then written to the client.
+const exportRowGroupRows = 50000
+
+// exportWriteRows is the number of events handed to the Parquet writer at once
+const exportWriteRows = 1000
+
+var exportedEventsTotal = telemetry.NewCounterVec("events_exported_total",
+	"Number of events exported by GET /events/export by format", "format")
+
+// parquetEvent is a row of an exported Parquet file
+type parquetEvent struct {
+	EventName  string    `parquet:"event_name,dict"`
+	Channel    string    `parquet:"channel,dict"`
+	CampaignID string    `parquet:"campaign_id,dict"`
+	UserID     string    `parquet:"user_id"`
+	Timestamp  time.Time `parquet:"timestamp,timestamp(millisecond:utc)"`
+	Tags       []string  `parquet:"tags,list"`
+	Metadata   string    `parquet:"metadata,json"`
+	AbuseScore int32     `parquet:"abuse_score"`
+	Source     string    `parquet:"source,dict"`
+	IngestedAt time.Time `parquet:"ingested_at,timestamp(millisecond:utc)"`
+}
+
+// ExportEvents streams the events matching the filters of GET /metrics as a file
+// @Summary Export raw events
+// @Description Stream the events matching the filters of GET /metrics, in time order, as a Parquet file generated by the service,
+// @Description so that analysts can load them into Spark or pandas without access to ClickHouse. The range is bounded like the one of /metrics.
+// @Description Events are sent as they are read, a failure after the response started leaves the file without its footer, which readers reject.
+// @Description Client tokens cannot export, and neither can API keys whose role is denied one of the exported columns.
+// @Tags Events
+// @Produce application/vnd.apache.parquet
+// @Produce json
+// @Param format query string false "File format, parquet (default)"
+// @Param event_name query string false "Event name filter"
+// @Param tag query string false "Only events with this tag"
+// @Param from query int false "Start timestamp (Unix seconds), the default range (7 days) before to if omitted"
+// @Param to query int false "End timestamp (Unix seconds), now if omitted"
+// @Param max_abuse_score query int false "Leave out the events whose abuse score (0-100) is above it"
+// @Param as_of query int false "Ingestion watermark (Unix timestamp), only events stored at or before it are exported"
+// @Param pin query bool false "Pin the export to the current ingestion watermark"
+// @Param X-Tenant-ID header string false "Tenant whose events are exported"
+// @Success 200 {file} file "Parquet file of the events"
+// @Failure 400 {object} domain.ExportResponse "Invalid request or range too long (code range_too_long)"
+// @Failure 403 {object} domain.ExportResponse "Client token, or an API key whose role is denied an exported column (code column_denied)"
+// @Failure 500 {object} domain.ExportResponse "Internal server error"
+// @Router /events/export [get]
+func (e eventHandler) ExportEvents(ctx *fiber.Ctx) error {
+	if clientToken(ctx) != nil {
+		return ctx.Status(fiber.StatusForbidden).JSON(domain.ExportResponse{
+			Success: false,
+			Message: "Client tokens cannot export events, use an API key",
+		})
+	}
+
+	req := domain.ExportRequest{
+		Format: ctx.Query("format", domain.ExportFormatParquet),
+		Filter: domain.MetricRequest{Tenant: tenantID(ctx), Role: apiKeyRole(ctx), Pin: ctx.QueryBool("pin")},
+	}
+	if eventName := ctx.Query("event_name"); eventName != "" {
+		req.Filter.EventName = &eventName
+	}
+	if tag := ctx.Query("tag"); tag != "" {
+		req.Filter.Tag = &tag
+	}
+	for name, value := range map[string]**int64{"from": &req.Filter.From, "to": &req.Filter.To, "as_of": &req.Filter.AsOf} {
+		if str := ctx.Query(name); str != "" {
+			n, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(domain.ExportResponse{
+					Success: false,
+					Message: "Invalid '" + name + "' parameter: " + err.Error(),
+				})
+			}
+			*value = &n
+		}
+	}
+	if str := ctx.Query("max_abuse_score"); str != "" {
+		score, err := strconv.Atoi(str)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.ExportResponse{
+				Success: false,
+				Message: "Invalid 'max_abuse_score' parameter: " + err.Error(),
+			})
+		}
+		req.Filter.MaxAbuseScore = &score
+	}
+
+	if err := validations.ValidateExportRequest(&req); err != nil {
+		status, code := validationFailure(err)
+		return ctx.Status(status).JSON(domain.ExportResponse{
+			Success: false,
+			Code:    code,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	// The body is written after the handler returns, when the request's context is no longer valid
+	stream, err := e.eventService.ExportEvents(context.Background(), &req)
+	if err != nil {
+		resp := domain.ExportResponse{
+			Success: false,
+			Message: "Failed to export events: " + err.Error(),
+		}
+		if errors.Is(err, services.ErrRangeTooLong) {
+			resp.Code = domain.MetricCodeRangeTooLong
+			return ctx.Status(fiber.StatusBadRequest).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+
+	ctx.Set(fiber.HeaderContentType, parquetMIME)
+	ctx.Attachment(fmt.Sprintf("events-%d.parquet", time.Now().Unix()))
+	ctx.Status(fiber.StatusOK).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// Closing reads and discards the rest of the result if the client went away
+		defer stream.Close()
+		if err := writeParquetEvents(w, stream); err != nil {
+			exportLog.Warnf("Export of tenant %q stopped, the file is incomplete: %v", req.Filter.Tenant, err)
+		}
+	})
+	return nil
+}
+
+// writeParquetEvents writes the events of a stream as a Parquet file, a row group at a time
+func writeParquetEvents(w *bufio.Writer, stream domain.EventStream) error {
+	info := buildinfo.GetInfo()
+	writer := parquet.NewGenericWriter[parquetEvent](w,
+		parquet.Compression(&parquet.Zstd),
+		parquet.MaxRowsPerRowGroup(exportRowGroupRows),
+		parquet.CreatedBy("clickhouse-events", info.Version, info.Commit))
+	rows := make([]parquetEvent, 0, exportWriteRows)
+	written := 0
+	write := func() error {
+		if _, err := writer.Write(rows); err != nil {
+			return err
+		}
+		exportedEventsTotal.WithLabelValues(domain.ExportFormatParquet).Add(float64(len(rows)))
+		written += len(rows)
+		rows = rows[:0]
+		if written%exportRowGroupRows == 0 {
+			// The row group is complete, it is sent to the client
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			return w.Flush()
+		}
+		return nil
+	}
+
+	for stream.Next() {
+		event := stream.Event()
+		rows = append(rows, parquetEvent{
+			EventName:  event.EventName,
+			Channel:    event.Channel,
+			CampaignID: event.CampaignID,
+			UserID:     event.UserID,
+			Timestamp:  event.Timestamp,
+			Tags:       event.Tags,
+			Metadata:   event.Metadata,
+			AbuseScore: int32(event.AbuseScore),
+			Source:     event.Source,
+			IngestedAt: event.IngestedAt,
+		})
+		if len(rows) == exportWriteRows {
+			if err := write(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		if err := write(); err != nil {
+			return err
+		}
+	}
+	// The footer holds the schema and the offsets of the row groups, without it the file cannot be read
+	return writer.Close()
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/expression"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ ExpressionHandler = &expressionHandler{}
+
+type expressionHandler struct{}
+
+// TestExpression compiles an expression and evaluates it on sample events
+// @Summary Test an expression
+// @Description Check that an expression of data quality rules, the drop transformation plugin or the sink filters compiles, and evaluate it on up to 100 sample events.
+// @Description Expressions are CEL over event_name, channel, campaign_id, user_id, timestamp, tags, metadata, tenant, source and producer; the sample events have the tenant of the request.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant of the sample events"
+// @Param request body domain.ExpressionTestRequest true "Expression and sample events"
+// @Success 200 {object} domain.ExpressionTestResponse "Expression tested, valid reports whether it compiles"
+// @Failure 400 {object} domain.ExpressionTestResponse "Invalid request"
+// @Router /admin/expressions/test [post]
+func (e expressionHandler) TestExpression(ctx *fiber.Ctx) error {
+	var req domain.ExpressionTestRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.ExpressionTestResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	req.Tenant = tenantID(ctx)
+	if err := validations.ValidateExpressionTestRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.ExpressionTestResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	expr, err := expression.Compile(req.Expression)
+	if err != nil {
+		return ctx.Status(fiber.StatusOK).JSON(domain.ExpressionTestResponse{
+			Success: true,
+			Message: "Expression is invalid",
+			Error:   err.Error(),
+		})
+	}
+	results := make([]domain.ExpressionResult, len(req.Events))
+	for i := range req.Events {
+		req.Events[i].Ingest.Tenant = req.Tenant
+		matched, err := expr.Match(&req.Events[i])
+		results[i].Matched = matched
+		if err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+	return ctx.Status(fiber.StatusOK).JSON(domain.ExpressionTestResponse{
+		Success: true,
+		Message: "Expression is valid",
+		Valid:   true,
+		Results: results,
+	})
+}
+
+func NewExpressionHandler() ExpressionHandler {
+	return &expressionHandler{}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ FeatureFlagHandler = &featureFlagHandler{nil}
+
+type featureFlagHandler struct {
+	flagService domain.FeatureFlagService
+}
+
+// ListFlags returns the feature flags
+// @Summary List feature flags
+// @Description List the feature flags with their defaults from the environment, runtime overrides and effective values
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} domain.FeatureFlagsResponse "Feature flags retrieved successfully"
+// @Failure 500 {object} domain.FeatureFlagsResponse "Internal server error"
+// @Router /admin/flags [get]
+func (f featureFlagHandler) ListFlags(ctx *fiber.Ctx) error {
+	resp, err := f.flagService.ListFlags(ctx.UserContext())
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.FeatureFlagsResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// SetFlag overrides a feature flag at runtime
+// @Summary Override a feature flag
+// @Description Override a feature flag on all instances, other instances pick the change up within FEATURE_FLAGS_REFRESH_INTERVAL_SECONDS
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param name path string true "Flag name"
+// @Param flag body domain.FeatureFlagRequest true "Flag value"
+// @Success 200 {object} domain.FeatureFlagsResponse "Feature flag set successfully"
+// @Failure 400 {object} domain.FeatureFlagsResponse "Invalid request"
+// @Failure 404 {object} domain.FeatureFlagsResponse "Unknown feature flag"
+// @Failure 500 {object} domain.FeatureFlagsResponse "Internal server error"
+// @Router /admin/flags/{name} [put]
+func (f featureFlagHandler) SetFlag(ctx *fiber.Ctx) error {
+	var req domain.FeatureFlagRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.FeatureFlagsResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	if req.Enabled == nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.FeatureFlagsResponse{
+			Success: false,
+			Message: "Validation failed: enabled is required",
+		})
+	}
+
+	resp, err := f.flagService.SetFlag(ctx.UserContext(), ctx.Params("name"), *req.Enabled)
+	if err != nil {
+		return ctx.Status(flagErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// ResetFlag removes the runtime override of a feature flag
+// @Summary Reset a feature flag
+// @Description Remove the runtime override of a feature flag, reverting it to its default from the environment
+// @Tags Admin
+// @Produce json
+// @Param name path string true "Flag name"
+// @Success 200 {object} domain.FeatureFlagsResponse "Feature flag reset successfully"
+// @Failure 404 {object} domain.FeatureFlagsResponse "Unknown feature flag"
+// @Failure 500 {object} domain.FeatureFlagsResponse "Internal server error"
+// @Router /admin/flags/{name} [delete]
+func (f featureFlagHandler) ResetFlag(ctx *fiber.Ctx) error {
+	resp, err := f.flagService.ResetFlag(ctx.UserContext(), ctx.Params("name"))
+	if err != nil {
+		return ctx.Status(flagErrorStatus(err)).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func flagErrorStatus(err error) int {
+	if errors.Is(err, services.ErrUnknownFlag) {
+		return fiber.StatusNotFound
+	}
+	return fiber.StatusInternalServerError
+}
+
+func NewFeatureFlagHandler(flagService domain.FeatureFlagService) FeatureFlagHandler {
+	return &featureFlagHandler{flagService: flagService}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetForecast forecasts the event counts of the next buckets
+// @Summary GET event count forecast
+// @Description Fits an additive Holt-Winters model on the event counts of the last complete buckets and forecasts the next ones, for capacity planning and alert baselines. Hourly counts have a daily season and daily counts a weekly one.
+// @Tags Metrics
+// @Produce json
+// @Param event_name query string false "Event name filter"
+// @Param tag query string false "Only events with this tag"
+// @Param group_by query string false "Bucket size, hour or day (default: day)"
+// @Param history query int false "Past buckets the model is fitted on, at least two seasons (default: eight seasons)"
+// @Param horizon query int false "Future buckets to forecast (default: one season)"
+// @Param X-Tenant-ID header string false "Tenant whose events are queried"
+// @Success 200 {object} domain.ForecastResponse "Forecast computed successfully"
+// @Failure 400 {object} domain.ForecastResponse "Invalid request"
+// @Failure 403 {object} domain.ForecastResponse "The role of the API key cannot group or filter by a column (code column_denied)"
+// @Failure 500 {object} domain.ForecastResponse "Internal server error"
+// @Router /metrics/forecast [get]
+func (e eventHandler) GetForecast(ctx *fiber.Ctx) error {
+	req := domain.ForecastRequest{
+		GroupBy: ctx.Query("group_by", "day"),
+		Tenant:  tenantID(ctx),
+		Role:    apiKeyRole(ctx),
+	}
+
+	if eventName := ctx.Query("event_name"); eventName != "" {
+		req.EventName = &eventName
+	}
+	if tag := ctx.Query("tag"); tag != "" {
+		req.Tag = &tag
+	}
+	for name, value := range map[string]*int{"history": &req.History, "horizon": &req.Horizon} {
+		if str := ctx.Query(name); str != "" {
+			n, err := strconv.Atoi(str)
+			if err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(domain.ForecastResponse{
+					Success: false,
+					Message: "Invalid '" + name + "' parameter: " + err.Error(),
+				})
+			}
+			*value = n
+		}
+	}
+
+	if err := validations.ValidateForecastRequest(&req); err != nil {
+		status, code := validationFailure(err)
+		return ctx.Status(status).JSON(domain.ForecastResponse{
+			Success: false,
+			Code:    code,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := e.eventService.GetForecast(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.ForecastResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
@@ -7,5 +7,22 @@ import (
 type EventHandler interface {
 	PostEvent(ctx *fiber.Ctx) error
 	PostEventsBulk(ctx *fiber.Ctx) error
+	GetBulkLimits(ctx *fiber.Ctx) error
+	PixelEvent(ctx *fiber.Ctx) error
+	PostBeacon(ctx *fiber.Ctx) error
 	GetMetrics(ctx *fiber.Ctx) error
+	GetMetricsBatch(ctx *fiber.Ctx) error
+	GetRealtimeMetrics(ctx *fiber.Ctx) error
+	GetWatermark(ctx *fiber.Ctx) error
+	GetMetricsDiff(ctx *fiber.Ctx) error
+	GetForecast(ctx *fiber.Ctx) error
+	GetPacing(ctx *fiber.Ctx) error
+	GetIntervals(ctx *fiber.Ctx) error
+	GetUserSummary(ctx *fiber.Ctx) error
+	GetSchemaDrift(ctx *fiber.Ctx) error
+	GetColumnStats(ctx *fiber.Ctx) error
+	PostEventStream(ctx *fiber.Ctx) error
+	EventWebSocket(ctx *fiber.Ctx) error
+	GetStreamCheckpoint(ctx *fiber.Ctx) error
+	ExportEvents(ctx *fiber.Ctx) error
 }
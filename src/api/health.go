@@ -2,7 +2,10 @@ package api
 
 import (
 	"context"
+	"fmt"
+	"kucukaslan/clickhouse/config"
 	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
 	"time"
 
 	"kucukaslan/clickhouse/buildinfo"
@@ -11,15 +14,31 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+var _ HealthHandler = &healthHandler{}
+
+type healthHandler struct {
+	eventService domain.EventService
+	cfg          *config.HealthConfig
+	drainer      *services.Drainer
+	schema       *services.SchemaCoordinator
+	parts        *services.PartsGuard
+	deadLetters  *services.DeadLetterQueue
+}
+
 // HealthCheck handles the /health endpoint
 // @Summary Health check endpoint
-// @Description Check the health status of the service and its dependencies
+// @Description Check the health status of the service, its dependencies and the ingestion pipeline.
+// @Description The service is degraded when the event buffer is nearly full or pending events have not been flushed for too long.
+// @Description It is also degraded while schema migrations are pending, so that instances of a new version only receive traffic once the columns they write exist.
+// @Description It is draining once /admin/prestop was called, so that readiness probes take the instance out of rotation.
+// @Description It is degraded as well once more events than HEALTH_MAX_DEAD_LETTER_EVENTS wait in the dead-letter queue, reported in ingestion.dead_letter_events.
+// @Description services.parts reports whether inserts are slowed or paused because the events tables have too many active parts, without degrading the service.
 // @Tags Health
 // @Produce json
 // @Success 200 {object} domain.HealthResponse "Service is healthy"
-// @Success 503 {object} domain.HealthResponse "Service is unhealthy"
+// @Success 503 {object} domain.HealthResponse "Service is unhealthy or degraded"
 // @Router /health [get]
-func HealthCheck(c *fiber.Ctx) error {
+func (h healthHandler) HealthCheck(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -57,12 +76,73 @@ func HealthCheck(c *fiber.Ctx) error {
 		}
 	}
 
+	// Check schema migrations, the events table lacks columns inserts write until they are applied
+	schemaCurrent := true
+	if version, latest, pending := h.schema.Pending(ctx); pending {
+		schemaCurrent = false
+		response.Services.Schema = domain.ServiceStatus{
+			Status:  "pending",
+			Message: fmt.Sprintf("schema version %d is behind %d, apply the migrations with POST /admin/migrations", version, latest),
+		}
+	} else {
+		response.Services.Schema = domain.ServiceStatus{
+			Status: "healthy",
+		}
+	}
+
+	// Report the parts guard without degrading the instance, every instance sees the same parts and taking them all
+	// out of rotation would turn slowed inserts into an outage
+	response.Services.Parts = h.parts.Status()
+
+	// Check ingestion lag
+	response.Ingestion = h.ingestionHealth(ctx, response.Timestamp)
+
 	// Determine overall status
-	if clickhouseHealthy && redisHealthy {
+	switch {
+	case h.drainer.Draining():
+		response.Status = "draining"
+	case !clickhouseHealthy || !redisHealthy:
+		response.Status = "unhealthy"
+	case response.Ingestion.Status != "healthy" || !schemaCurrent:
+		response.Status = "degraded"
+	default:
 		response.Status = "healthy"
 		return c.Status(fiber.StatusOK).JSON(response)
 	}
 
-	response.Status = "unhealthy"
+	// Degraded and draining instances also report 503 so load balancers stop routing to them
 	return c.Status(fiber.StatusServiceUnavailable).JSON(response)
 }
+
+func (h healthHandler) ingestionHealth(ctx context.Context, now time.Time) domain.IngestionHealth {
+	stats := h.eventService.GetIngestionStats()
+
+	health := domain.IngestionHealth{
+		Status:                "healthy",
+		PendingEvents:         stats.BufferedEvents + stats.BatchEvents + stats.OverflowEvents,
+		SecondsSinceLastFlush: now.Sub(stats.LastFlushTime).Seconds(),
+	}
+	health.DeadLetterEvents = h.deadLetters.Depth()
+	if stats.BufferCapacity > 0 {
+		health.BufferUtilizationPercent = float64(stats.BufferedEvents) * 100 / float64(stats.BufferCapacity)
+	}
+
+	// An idle instance has nothing to flush, lag only matters while events are waiting or flushes fail
+	lagging := health.PendingEvents > 0 || stats.LastFlushFailed
+	switch {
+	case health.BufferUtilizationPercent >= h.cfg.MaxBufferUtilizationPercent:
+		health.Status = "degraded"
+		health.Message = fmt.Sprintf("event buffer is %.1f%% full", health.BufferUtilizationPercent)
+	case lagging && health.SecondsSinceLastFlush > float64(h.cfg.MaxFlushLagSeconds):
+		health.Status = "degraded"
+		health.Message = fmt.Sprintf("no successful flush for %.0f seconds", health.SecondsSinceLastFlush)
+	case h.cfg.MaxDeadLetterEvents > 0 && health.DeadLetterEvents > h.cfg.MaxDeadLetterEvents:
+		health.Status = "degraded"
+		health.Message = fmt.Sprintf("%d events are dead-lettered", health.DeadLetterEvents)
+	}
+	return health
+}
+
+func NewHealthHandler(eventService domain.EventService, cfg *config.HealthConfig, drainer *services.Drainer, schema *services.SchemaCoordinator, parts *services.PartsGuard, deadLetters *services.DeadLetterQueue) HealthHandler {
+	return &healthHandler{eventService: eventService, cfg: cfg, drainer: drainer, schema: schema, parts: parts, deadLetters: deadLetters}
+}
@@ -1,19 +1,32 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
 	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/telemetry"
 	"kucukaslan/clickhouse/validations"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-var _ EventHandler = &eventHandler{nil}
+// eventsLog logs the messages of the event handlers
+var eventsLog = logging.Named("Events")
+
+var (
+	unacknowledgedEventsTotal = telemetry.NewCounterVec("unacknowledged_events_total",
+		"Number of events posted with ack=none by the result of their ingestion after the response (ok or error)", "result")
+)
+
+var _ EventHandler = &eventHandler{}
 
 type eventHandler struct {
 	eventService domain.EventService
+	rawPayloads  bool // keep the JSON of events as received, see services.RawPayloadStore
 }
 
 // PostEvent handles posting events
@@ -22,13 +35,30 @@ type eventHandler struct {
 // @Tags Events
 // @Accept json
 // @Produce json
+// @Param X-Tenant-ID header string false "Tenant of the event"
+// @Param X-Client-Time header int false "Time of the client's clock when sending (Unix milliseconds), its skew from the X-Server-Time of the response is measured and corrected with CLOCK_SKEW_CORRECTION"
+// @Param Content-Encoding header string false "Compression of the body, gzip or zstd"
+// @Param ack query string false "Acknowledgment level: none answers 204 right away and ingests the event in the background, buffered (default) once it is buffered, flushed once it is stored" Enums(none, buffered, flushed)
 // @Param event body domain.EventRequest true "Event data"
 // @Success 200 {object} domain.EventResponse "Event posted successfully"
+// @Success 204 "Event accepted with ack=none, failures are not reported"
 // @Failure 400 {object} domain.EventResponse "Invalid request"
+// @Failure 413 {object} domain.EventResponse "Body too large once decompressed"
+// @Failure 415 {object} domain.EventResponse "Unsupported Content-Encoding"
+// @Failure 429 {object} domain.EventResponse "Monthly quota of the tenant exceeded"
 // @Failure 503 {object} domain.EventResponse "Service unavailable (buffer full)"
+// @Failure 504 {object} domain.EventResponse "Event not flushed in time with ack=flushed"
 // @Failure 500 {object} domain.EventResponse "Internal server error"
 // @Router /events [post]
 func (e eventHandler) PostEvent(ctx *fiber.Ctx) error {
+	ack := ctx.Query("ack", domain.AckBuffered)
+	if err := validations.ValidateAck(ack); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.EventResponse{
+			Success: false,
+			Message: "Invalid 'ack' parameter: " + err.Error(),
+		})
+	}
+
 	// Parse request body
 	var req domain.EventRequest
 	if err := ctx.BodyParser(&req); err != nil {
@@ -38,6 +68,21 @@ func (e eventHandler) PostEvent(ctx *fiber.Ctx) error {
 		})
 	}
 
+	req.Ingest.Producer = producerID(ctx)
+	req.Ingest.Tenant = tenantID(ctx)
+	req.Ingest.Source = domain.SourceEvents
+	req.Ingest.UserAgent, req.Ingest.Browser = userAgent(ctx), clientToken(ctx) != nil
+	req.Ingest.ClientIP = clientIP(ctx)
+	req.Ingest.RawBytes = len(ctx.Body())
+	if skew, ok := clockSkew(ctx); ok {
+		req.ShiftTime(skew)
+	}
+	if e.rawPayloads {
+		// The body is reused once the handler returns, while the event waits in the buffer
+		req.Ingest.Raw = bytes.Clone(ctx.Body())
+	}
+	requestBodyBytes.WithLabelValues("/events").Observe(float64(len(ctx.Body())))
+
 	// Validate request
 	if err := validations.ValidateEventRequest(&req); err != nil {
 		return ctx.Status(fiber.StatusBadRequest).JSON(domain.EventResponse{
@@ -46,7 +91,14 @@ func (e eventHandler) PostEvent(ctx *fiber.Ctx) error {
 		})
 	}
 
-	resp, err := e.eventService.PostEvents(ctx.Context(), &req)
+	req.Ingest.Ack = ack
+	if ack == domain.AckNone {
+		// Ingested after the response, without the cancellation of the request
+		go e.postEventInBackground(context.WithoutCancel(ctx.UserContext()), req)
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}
+
+	resp, err := e.eventService.PostEvents(ctx.UserContext(), &req)
 	if err != nil {
 		// Check if buffer is full and return 503 Service Unavailable
 		if errors.Is(err, services.ErrBufferFull) {
@@ -55,6 +107,12 @@ func (e eventHandler) PostEvent(ctx *fiber.Ctx) error {
 				Message: "Service temporarily unavailable, please try again later",
 			})
 		}
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			return ctx.Status(fiber.StatusTooManyRequests).JSON(resp)
+		}
+		if errors.Is(err, services.ErrFlushTimeout) {
+			return ctx.Status(fiber.StatusGatewayTimeout).JSON(resp)
+		}
 		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.EventResponse{
 			Success: false,
 			Message: "Internal server error: " + err.Error(),
@@ -63,28 +121,75 @@ func (e eventHandler) PostEvent(ctx *fiber.Ctx) error {
 	return ctx.Status(fiber.StatusOK).JSON(resp)
 }
 
+// postEventInBackground ingests an event posted with ack=none, whose producer is already answered.
+// Failures are counted in unacknowledged_events_total and logged, the producer does not learn about them.
+func (e eventHandler) postEventInBackground(ctx context.Context, req domain.EventRequest) {
+	_, err := e.eventService.PostEvents(ctx, &req)
+	if err != nil {
+		unacknowledgedEventsTotal.WithLabelValues("error").Inc()
+		eventsLog.Ctx(ctx).Warnf("Failed to ingest event %s posted with ack=none: %v", req.GetUniqueKey(), err)
+		return
+	}
+	unacknowledgedEventsTotal.WithLabelValues("ok").Inc()
+}
+
 // GetMetrics retrieves aggregated metrics
 // @Summary GET aggregated metrics
-// @Description Query aggregated event metrics with filtering and grouping
+// @Description Query aggregated event metrics with filtering and grouping.
+// @Description With group_by=user_id and Accept: application/x-ndjson the buckets are streamed as newline delimited JSON, one MetricResult per line,
+// @Description without realtime counts and new users. A failure after the response started is reported as a last line with success false.
+// @Description Queries pinned with pin or as_of count the events stored up to the watermark, without realtime counts and new users,
+// @Description so that follow-up queries passing the returned as_of agree with each other while events keep arriving.
+// @Description annotate_anomalies scores each bucket of an hour or day grouping against the same bucket of the previous seasons, see anomaly_score of the buckets.
+// @Description top_k estimates the top buckets with approx_top_count instead of counting every bucket, its accuracy is described by top_k of the response.
+// @Description format=csv or format=jsonl returns the buckets alone as an attachment, a CSV line or a MetricResult per bucket; errors are still answered as JSON.
 // @Tags Metrics
 // @Produce json
+// @Produce application/x-ndjson
+// @Produce text/csv
 // @Param event_name query string false "Event name filter"
-// @Param from query int false "Start timestamp (Unix seconds)"
-// @Param to query int false "End timestamp (Unix seconds)"
-// @Param group_by query string false "Group by field (hour, day, week, month, year, channel, campaign_id, user_id, event_name)"
+// @Param tag query string false "Only events with this tag, and the tags under it if it ends with / (e.g. category/electronics/)"
+// @Param from query int false "Start timestamp (Unix seconds), the default range (7 days) before to if omitted"
+// @Param to query int false "End timestamp (Unix seconds), now if omitted"
+// @Param X-Tenant-ID header string false "Tenant whose events are queried"
+// @Param group_by query string false "Group by field (hour, day, week, month, year, channel, campaign_id, user_id, event_name, tags)"
+// @Param tag_depth query int false "With group_by=tags, roll the tags up to their first levels (e.g. 2 counts category/electronics/phones as category/electronics)"
+// @Param top_k query int false "Only the approximate top buckets by events (1-1000), estimated in a single pass for long ranges; requires group_by channel, campaign_id, user_id, event_name or tags"
+// @Param annotate_anomalies query bool false "Score the events of each bucket against the same bucket of the previous 4 days (hour) or weeks (day), requires group_by hour or day"
+// @Param enrich query string false "Dimension whose attributes are added to the buckets, requires group_by channel, campaign_id, user_id or event_name"
+// @Param max_abuse_score query int false "Leave out the events whose abuse score (0-100) is above it"
+// @Param as_of query int false "Ingestion watermark (Unix timestamp) returned by a pinned query, only events stored at or before it are counted"
+// @Param pin query bool false "Pin the query to the current ingestion watermark, returned as as_of"
+// @Param as_ingested_before query int false "Only count the events stored before this time (Unix seconds), to reproduce what a dashboard showed then; replaces as_of"
+// @Param format query string false "Response format, json (default), csv or jsonl" Enums(json, csv, jsonl)
 // @Success 200 {object} domain.MetricResponse "Metrics retrieved successfully"
-// @Failure 400 {object} domain.MetricResponse "Invalid request"
+// @Failure 400 {object} domain.MetricResponse "Invalid request, unknown dimension or range too long (code range_too_long)"
+// @Failure 403 {object} domain.MetricResponse "The role of the API key cannot group or filter by a column (code column_denied)"
 // @Failure 500 {object} domain.MetricResponse "Internal server error"
 // @Router /metrics [get]
 func (e eventHandler) GetMetrics(ctx *fiber.Ctx) error {
 	// Parse query parameters
 	var req domain.MetricRequest
 
+	format := ctx.Query("format", MetricFormatJSON)
+	if !validMetricFormat(format) {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricResponse{
+			Success: false,
+			Message: "Invalid 'format' parameter, expected json, csv or jsonl",
+			Metrics: nil,
+		})
+	}
+
 	// Parse event_name
 	if eventName := ctx.Query("event_name"); eventName != "" {
 		req.EventName = &eventName
 	}
 
+	// Parse tag
+	if tag := ctx.Query("tag"); tag != "" {
+		req.Tag = &tag
+	}
+
 	// Parse from timestamp
 	if fromStr := ctx.Query("from"); fromStr != "" {
 		from, err := strconv.ParseInt(fromStr, 10, 64)
@@ -116,22 +221,119 @@ func (e eventHandler) GetMetrics(ctx *fiber.Ctx) error {
 		req.GroupBy = &groupBy
 	}
 
+	// Parse tag_depth
+	if depthStr := ctx.Query("tag_depth"); depthStr != "" {
+		depth, err := strconv.Atoi(depthStr)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricResponse{
+				Success: false,
+				Message: "Invalid 'tag_depth' parameter: " + err.Error(),
+				Metrics: nil,
+			})
+		}
+		req.TagDepth = &depth
+	}
+
+	// Parse top_k
+	if topKStr := ctx.Query("top_k"); topKStr != "" {
+		topK, err := strconv.Atoi(topKStr)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricResponse{
+				Success: false,
+				Message: "Invalid 'top_k' parameter: " + err.Error(),
+				Metrics: nil,
+			})
+		}
+		req.TopK = &topK
+	}
+
+	// Parse enrich
+	if enrich := ctx.Query("enrich"); enrich != "" {
+		req.Enrich = &enrich
+	}
+
+	// Parse max_abuse_score
+	if maxScoreStr := ctx.Query("max_abuse_score"); maxScoreStr != "" {
+		maxScore, err := strconv.Atoi(maxScoreStr)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricResponse{
+				Success: false,
+				Message: "Invalid 'max_abuse_score' parameter: " + err.Error(),
+				Metrics: nil,
+			})
+		}
+		req.MaxAbuseScore = &maxScore
+	}
+
+	// Parse as_of
+	if asOfStr := ctx.Query("as_of"); asOfStr != "" {
+		asOf, err := strconv.ParseInt(asOfStr, 10, 64)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricResponse{
+				Success: false,
+				Message: "Invalid 'as_of' parameter: " + err.Error(),
+				Metrics: nil,
+			})
+		}
+		req.AsOf = &asOf
+	}
+	req.Pin = ctx.QueryBool("pin")
+	req.AnnotateAnomalies = ctx.QueryBool("annotate_anomalies")
+
+	// Parse as_ingested_before, ingestion times have second granularity so it is the watermark one second earlier
+	if beforeStr := ctx.Query("as_ingested_before"); beforeStr != "" {
+		before, err := strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricResponse{
+				Success: false,
+				Message: "Invalid 'as_ingested_before' parameter: " + err.Error(),
+				Metrics: nil,
+			})
+		}
+		if req.AsOf != nil || req.Pin {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricResponse{
+				Success: false,
+				Message: "Validation failed: as_ingested_before cannot be combined with as_of or pin",
+				Metrics: nil,
+			})
+		}
+		asOf := before - 1
+		req.AsOf = &asOf
+	}
+
+	req.Tenant = tenantID(ctx)
+	req.Role = apiKeyRole(ctx)
+
 	// Validate request
 	if err := validations.ValidateMetricRequest(&req); err != nil {
-		return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricResponse{
+		status, code := validationFailure(err)
+		return ctx.Status(status).JSON(domain.MetricResponse{
 			Success: false,
+			Code:    code,
 			Metrics: nil,
 			Message: "Validation failed: " + err.Error(),
 		})
 	}
-	resp, err := e.eventService.GetMetrics(ctx.Context(), &req)
+
+	// Buckets of users are streamed when asked for, there can be millions of them
+	if format == MetricFormatJSON && req.GroupBy != nil && *req.GroupBy == "user_id" && req.TopK == nil && ctx.Accepts(fiber.MIMEApplicationJSON, ndjsonMIME) == ndjsonMIME {
+		return e.streamMetrics(ctx, &req)
+	}
+
+	resp, err := e.eventService.GetMetrics(ctx.UserContext(), &req)
 	if err != nil {
+		if errors.Is(err, services.ErrUnknownDimension) || errors.Is(err, services.ErrRangeTooLong) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(resp)
+		}
 		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.MetricResponse{
 			Success: false,
 			Message: "Internal server error: " + err.Error(),
 			Metrics: resp.Metrics,
 		})
 	}
+	if format != MetricFormatJSON {
+		return sendMetricFile(ctx, format, resp)
+	}
 	return ctx.Status(fiber.StatusOK).JSON(resp)
 
 }
@@ -142,9 +344,18 @@ func (e eventHandler) GetMetrics(ctx *fiber.Ctx) error {
 // @Tags Events
 // @Accept json
 // @Produce json
+// @Param X-Tenant-ID header string false "Tenant of the events"
+// @Param X-Client-Time header int false "Time of the client's clock when sending (Unix milliseconds), its skew from the X-Server-Time of the response is measured and corrected with CLOCK_SKEW_CORRECTION"
+// @Param Content-Encoding header string false "Compression of the body, gzip or zstd"
 // @Param events body domain.BulkEventRequest true "Array of event data"
 // @Success 200 {object} domain.BulkEventResponse "Bulk events posted successfully"
+// @Success 202 {object} domain.BulkEventResponse "Bulk events enqueued (async bulk mode or async_bulk flag)"
 // @Failure 400 {object} domain.BulkEventResponse "Invalid request"
+// @Failure 413 {object} domain.BulkEventResponse "Body too large once decompressed"
+// @Failure 415 {object} domain.BulkEventResponse "Unsupported Content-Encoding"
+// @Failure 429 {object} domain.BulkEventResponse "Monthly quota of the tenant exceeded"
+// @Failure 503 {object} domain.BulkEventResponse "Service unavailable (buffer full, async bulk mode or user ordering only)"
+// @Failure 504 {object} domain.BulkEventResponse "Events were not flushed in time (user ordering only)"
 // @Failure 500 {object} domain.BulkEventResponse "Internal server error"
 // @Router /events/bulk [post]
 func (e eventHandler) PostEventsBulk(ctx *fiber.Ctx) error {
@@ -160,19 +371,54 @@ func (e eventHandler) PostEventsBulk(ctx *fiber.Ctx) error {
 		})
 	}
 
+	attributeRawBytes(req.Events, len(ctx.Body()))
+	if e.rawPayloads {
+		attachRawBulkEvents(req.Events, ctx.Body())
+	}
+	producer, tenant, agent, browser, ip := producerID(ctx), tenantID(ctx), userAgent(ctx), clientToken(ctx) != nil, clientIP(ctx)
+	skew, corrected := clockSkew(ctx)
+	for i := range req.Events {
+		req.Events[i].Ingest.Producer = producer
+		req.Events[i].Ingest.Tenant = tenant
+		req.Events[i].Ingest.Source = domain.SourceBulk
+		req.Events[i].Ingest.UserAgent, req.Events[i].Ingest.Browser = agent, browser
+		req.Events[i].Ingest.ClientIP = ip
+		if corrected {
+			req.Events[i].ShiftTime(skew)
+		}
+	}
+	requestBodyBytes.WithLabelValues("/events/bulk").Observe(float64(len(ctx.Body())))
+
 	// Validate request
-	if err := validations.ValidateBulkEventRequest(&req); err != nil {
-		return ctx.Status(fiber.StatusBadRequest).JSON(domain.BulkEventResponse{
+	maxEvents, _ := validations.MaxBulkEvents(producer, tenant)
+	if err := validations.ValidateBulkEventRequest(&req, maxEvents); err != nil {
+		resp := domain.BulkEventResponse{
 			Success:      false,
 			Message:      "Validation failed: " + err.Error(),
 			TotalCount:   len(req.Events),
 			SuccessCount: 0,
 			FailureCount: len(req.Events),
-		})
+		}
+		var violations *validations.BulkValidationError
+		if errors.As(err, &violations) {
+			resp.Errors = violations.Violations
+		}
+		return ctx.Status(fiber.StatusBadRequest).JSON(resp)
 	}
 
-	resp, err := e.eventService.PostEventsBulk(ctx.Context(), &req)
+	resp, err := e.eventService.PostEventsBulk(ctx.UserContext(), &req)
 	if err != nil {
+		// In async bulk mode the events are enqueued and the buffer may fill up
+		if errors.Is(err, services.ErrBufferFull) {
+			return ctx.Status(fiber.StatusServiceUnavailable).JSON(resp)
+		}
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			return ctx.Status(fiber.StatusTooManyRequests).JSON(resp)
+		}
+		// With per-user ordering the events go through the batcher, which may not flush them in time
+		if errors.Is(err, services.ErrFlushTimeout) {
+			return ctx.Status(fiber.StatusGatewayTimeout).JSON(resp)
+		}
 		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.BulkEventResponse{
 			Success:      false,
 			Message:      "Internal server error: " + err.Error(),
@@ -181,9 +427,130 @@ func (e eventHandler) PostEventsBulk(ctx *fiber.Ctx) error {
 			FailureCount: resp.FailureCount,
 		})
 	}
+	if resp.Enqueued {
+		return ctx.Status(fiber.StatusAccepted).JSON(resp)
+	}
 	return ctx.Status(fiber.StatusOK).JSON(resp)
 }
 
-func NewEventHandler(eventService domain.EventService) EventHandler {
-	return &eventHandler{eventService: eventService}
+func NewEventHandler(eventService domain.EventService, rawPayloads bool) EventHandler {
+	return &eventHandler{eventService: eventService, rawPayloads: rawPayloads}
+}
+
+type AdminHandler interface {
+	GetColumnCompression(ctx *fiber.Ctx) error
+}
+
+type BackfillHandler interface {
+	PostBackfill(ctx *fiber.Ctx) error
+	StartBackfill(ctx *fiber.Ctx) error
+	GetBackfill(ctx *fiber.Ctx) error
+}
+
+type MetricJobHandler interface {
+	SubmitMetricJob(ctx *fiber.Ctx) error
+	GetMetricJob(ctx *fiber.Ctx) error
+	CancelMetricJob(ctx *fiber.Ctx) error
+}
+
+type UsageHandler interface {
+	GetUsage(ctx *fiber.Ctx) error
+	GetQuota(ctx *fiber.Ctx) error
+}
+
+type WebhookHandler interface {
+	RegisterWebhook(ctx *fiber.Ctx) error
+	GetWebhook(ctx *fiber.Ctx) error
+	DeleteWebhook(ctx *fiber.Ctx) error
+}
+
+type ClientTokenHandler interface {
+	IssueToken(ctx *fiber.Ctx) error
+}
+
+type HealthHandler interface {
+	HealthCheck(ctx *fiber.Ctx) error
+}
+
+type VersionHandler interface {
+	GetVersion(ctx *fiber.Ctx) error
+}
+
+type PreStopHandler interface {
+	PreStop(ctx *fiber.Ctx) error
+}
+
+type SchemaHandler interface {
+	GetMigrations(ctx *fiber.Ctx) error
+	ApplyMigrations(ctx *fiber.Ctx) error
+}
+
+type FeatureFlagHandler interface {
+	ListFlags(ctx *fiber.Ctx) error
+	SetFlag(ctx *fiber.Ctx) error
+	ResetFlag(ctx *fiber.Ctx) error
+}
+
+type AggregateHandler interface {
+	GetAggregatePublisher(ctx *fiber.Ctx) error
+	PauseAggregates(ctx *fiber.Ctx) error
+	ResumeAggregates(ctx *fiber.Ctx) error
+}
+
+type RewriteHandler interface {
+	ListRewriteRules(ctx *fiber.Ctx) error
+	SetRewriteRule(ctx *fiber.Ctx) error
+	DeleteRewriteRule(ctx *fiber.Ctx) error
+}
+
+type ExpressionHandler interface {
+	TestExpression(ctx *fiber.Ctx) error
+}
+
+type CatalogHandler interface {
+	ListCatalog(ctx *fiber.Ctx) error
+	GetCatalogEntry(ctx *fiber.Ctx) error
+	SetCatalogEntry(ctx *fiber.Ctx) error
+	DeleteCatalogEntry(ctx *fiber.Ctx) error
+	GetCatalogCoverage(ctx *fiber.Ctx) error
+}
+
+type EventSchemaHandler interface {
+	ListEventSchemas(ctx *fiber.Ctx) error
+	GetEventSchema(ctx *fiber.Ctx) error
+	CreateEventSchema(ctx *fiber.Ctx) error
+	UpdateEventSchema(ctx *fiber.Ctx) error
+	DeleteEventSchema(ctx *fiber.Ctx) error
+}
+
+type QuarantineHandler interface {
+	ListQuarantine(ctx *fiber.Ctx) error
+	ReprocessQuarantine(ctx *fiber.Ctx) error
+}
+
+type ReprocessHandler interface {
+	Reprocess(ctx *fiber.Ctx) error
+}
+
+type APIKeyHandler interface {
+	ListAPIKeys(ctx *fiber.Ctx) error
+	CreateAPIKey(ctx *fiber.Ctx) error
+	DeleteAPIKey(ctx *fiber.Ctx) error
+}
+
+type DeadLetterHandler interface {
+	ListDeadLetters(ctx *fiber.Ctx) error
+}
+
+type DimensionHandler interface {
+	UploadDimension(ctx *fiber.Ctx) error
+	SetDimensionURL(ctx *fiber.Ctx) error
+	ListDimensions(ctx *fiber.Ctx) error
+	GetDimension(ctx *fiber.Ctx) error
+	ReloadDimension(ctx *fiber.Ctx) error
+	DeleteDimension(ctx *fiber.Ctx) error
+}
+
+type SLOHandler interface {
+	GetSLO(ctx *fiber.Ctx) error
 }
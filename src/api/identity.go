@@ -0,0 +1,72 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// APIKeyHeader identifies the producer of a request
+	APIKeyHeader = "X-API-Key"
+	// TenantHeader identifies the tenant a request belongs to
+	TenantHeader = "X-Tenant-ID"
+	// ClientTokenHeader carries a short-lived client token standing for an API key and tenant
+	ClientTokenHeader = "X-Client-Token"
+	// RequestTimestampHeader, RequestNonceHeader and RequestSignatureHeader prove that a client token request is not replayed
+	RequestTimestampHeader = "X-Request-Timestamp"
+	RequestNonceHeader     = "X-Request-Nonce"
+	RequestSignatureHeader = "X-Request-Signature"
+)
+
+// producerID returns the producer identity of the request's client token or API key, empty if the request has none
+func producerID(ctx *fiber.Ctx) string {
+	if claims := clientToken(ctx); claims != nil {
+		return claims.Producer
+	}
+	return domain.ProducerID(ctx.Get(APIKeyHeader))
+}
+
+// apiKeyTenantKey holds the tenant the request's API key is bound to, set by the API key middleware
+const apiKeyTenantKey = "api_key_tenant"
+
+// apiKeyRoleKey holds the role of the request's API key, set by the API key middleware
+const apiKeyRoleKey = "api_key_role"
+
+// apiKeyRole returns the role of the request's API key, empty if the request was not authenticated
+func apiKeyRole(ctx *fiber.Ctx) string {
+	role, _ := ctx.Locals(apiKeyRoleKey).(string)
+	return role
+}
+
+// validationFailure returns the status and code of a rejected query, 403 if the role of its API key cannot group or
+// filter by one of its columns, 401 if authentication is enabled and it has no API key
+func validationFailure(err error) (int, string) {
+	if errors.Is(err, validations.ErrMissingAPIKeyRole) {
+		return fiber.StatusUnauthorized, ""
+	}
+	var denied *validations.ColumnAccessError
+	if errors.As(err, &denied) {
+		return fiber.StatusForbidden, domain.MetricCodeColumnDenied
+	}
+	return fiber.StatusBadRequest, ""
+}
+
+// tenantID returns the tenant of the request, empty for the default tenant.
+// The tenant of a client token, or of an API key bound to one, cannot be overridden by the client.
+func tenantID(ctx *fiber.Ctx) string {
+	if claims := clientToken(ctx); claims != nil {
+		return claims.Tenant
+	}
+	if tenant, ok := ctx.Locals(apiKeyTenantKey).(string); ok {
+		return tenant
+	}
+	return ctx.Get(TenantHeader)
+}
+
+// userAgent returns the User-Agent header of the request, used to score browser events for abuse
+func userAgent(ctx *fiber.Ctx) string {
+	return string(ctx.Request().Header.UserAgent())
+}
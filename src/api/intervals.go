@@ -0,0 +1,65 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetIntervals retrieves the distribution of the time between consecutive events of the same user
+// @Summary GET inter-event intervals
+// @Description Histogram and quantiles of the time between consecutive events of each user, computed with window functions over the events ordered by time. With from_event and to_event, only the intervals where to_event directly follows from_event are measured, ignoring events of other names.
+// @Tags Metrics
+// @Produce json
+// @Param from_event query string false "Event the intervals start at, requires to_event"
+// @Param to_event query string false "Event the intervals end at, requires from_event"
+// @Param from query int false "Start timestamp (Unix seconds), defaults to 7 days before to"
+// @Param to query int false "End timestamp (Unix seconds)"
+// @Param X-Tenant-ID header string false "Tenant whose events are queried"
+// @Success 200 {object} domain.IntervalResponse "Intervals retrieved successfully"
+// @Failure 400 {object} domain.IntervalResponse "Invalid request"
+// @Failure 403 {object} domain.IntervalResponse "The role of the API key cannot group or filter by a column (code column_denied)"
+// @Failure 500 {object} domain.IntervalResponse "Internal server error"
+// @Router /metrics/intervals [get]
+func (e eventHandler) GetIntervals(ctx *fiber.Ctx) error {
+	req := domain.IntervalRequest{Tenant: tenantID(ctx), Role: apiKeyRole(ctx)}
+
+	if fromEvent := ctx.Query("from_event"); fromEvent != "" {
+		req.FromEvent = &fromEvent
+	}
+	if toEvent := ctx.Query("to_event"); toEvent != "" {
+		req.ToEvent = &toEvent
+	}
+	for name, value := range map[string]**int64{"from": &req.From, "to": &req.To} {
+		if str := ctx.Query(name); str != "" {
+			timestamp, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(domain.IntervalResponse{
+					Success: false,
+					Message: "Invalid '" + name + "' parameter: " + err.Error(),
+				})
+			}
+			*value = &timestamp
+		}
+	}
+
+	if err := validations.ValidateIntervalRequest(&req); err != nil {
+		status, code := validationFailure(err)
+		return ctx.Status(status).JSON(domain.IntervalResponse{
+			Success: false,
+			Code:    code,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := e.eventService.GetIntervals(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.IntervalResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
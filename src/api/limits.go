@@ -0,0 +1,40 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetBulkLimits reports the maximum number of events per bulk request of the caller
+// @Summary Bulk request limits
+// @Description Report the maximum number of events per /events/bulk request and /events/stream chunk for the API key and tenant of the request, so SDKs can adapt their batch sizes
+// @Tags Events
+// @Produce json
+// @Param X-API-Key header string false "API key of the producer"
+// @Param X-Tenant-ID header string false "Tenant of the events"
+// @Success 200 {object} domain.BulkLimitsResponse "Bulk limits retrieved successfully"
+// @Failure 400 {object} domain.BulkLimitsResponse "Invalid tenant"
+// @Router /events/bulk/limits [get]
+func (e eventHandler) GetBulkLimits(ctx *fiber.Ctx) error {
+	producer, tenant := producerID(ctx), tenantID(ctx)
+	if err := validations.ValidateTenantID(tenant); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.BulkLimitsResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+			Tenant:  tenant,
+		})
+	}
+
+	maxEvents, source := validations.MaxBulkEvents(producer, tenant)
+	return ctx.Status(fiber.StatusOK).JSON(domain.BulkLimitsResponse{
+		Success:             true,
+		Message:             "Bulk limits retrieved successfully",
+		Tenant:              tenant,
+		Producer:            producer,
+		MaxEvents:           maxEvents,
+		Source:              source,
+		MaxValidationErrors: validations.MaxBulkViolations(),
+	})
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Formats of the responses of GET /metrics
+const (
+	MetricFormatJSON  = "json"  // a domain.MetricResponse, the default
+	MetricFormatCSV   = "csv"   // a header line and a line per bucket, for spreadsheets
+	MetricFormatJSONL = "jsonl" // a domain.MetricResult per line, for downstream pipelines
+)
+
+// csvMIME is the content type of CSV responses
+const csvMIME = "text/csv; charset=utf-8"
+
+// validMetricFormat tells whether a format of GET /metrics is known
+func validMetricFormat(format string) bool {
+	return format == MetricFormatJSON || format == MetricFormatCSV || format == MetricFormatJSONL
+}
+
+// sendMetricFile answers the buckets of a metrics response as a CSV or JSONL attachment. The fields of the response
+// besides the buckets, e.g. as_of, are left out.
+func sendMetricFile(ctx *fiber.Ctx, format string, resp *domain.MetricResponse) error {
+	var body []byte
+	var err error
+	contentType := ndjsonMIME
+	if format == MetricFormatCSV {
+		body, err = metricsCSV(resp.Metrics)
+		contentType = csvMIME
+	} else {
+		body, err = metricsJSONL(resp.Metrics)
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.MetricResponse{
+			Success: false,
+			Message: "Failed to encode metrics: " + err.Error(),
+		})
+	}
+	ctx.Attachment(fmt.Sprintf("metrics-%d.%s", time.Now().Unix(), format))
+	ctx.Set(fiber.HeaderContentType, contentType)
+	return ctx.Status(fiber.StatusOK).Send(body)
+}
+
+// metricsCSV writes a line per bucket with its counts and the attributes of an enriched grouping, in name order.
+// new_users is empty for the buckets without it.
+func metricsCSV(metrics []domain.MetricResult) ([]byte, error) {
+	var attributes []string
+	for _, metric := range metrics {
+		for name := range metric.Attributes {
+			if !slices.Contains(attributes, name) {
+				attributes = append(attributes, name)
+			}
+		}
+	}
+	slices.Sort(attributes)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	header := append([]string{"bucket", "total_events", "unique_users", "new_users"}, attributes...)
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	record := make([]string, len(header))
+	for _, metric := range metrics {
+		record[0] = csvText(metric.Bucket)
+		record[1] = strconv.FormatUint(metric.TotalEvents, 10)
+		record[2] = strconv.FormatUint(metric.UniqueUsers, 10)
+		record[3] = ""
+		if metric.NewUsers != nil {
+			record[3] = strconv.FormatUint(*metric.NewUsers, 10)
+		}
+		for i, name := range attributes {
+			record[4+i] = csvText(metric.Attributes[name])
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// csvText escapes a value a spreadsheet would evaluate as a formula with a leading quote, buckets and attributes
+// come from the events (CSV injection)
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// metricsJSONL writes a bucket per line, as the NDJSON stream of user buckets does
+func metricsJSONL(metrics []domain.MetricResult) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, metric := range metrics {
+		if err := encoder.Encode(metric); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
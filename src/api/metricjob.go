@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ MetricJobHandler = &metricJobHandler{nil}
+
+type metricJobHandler struct {
+	metricJobService domain.MetricJobService
+}
+
+// SubmitMetricJob queues an expensive metric query in the background
+// @Summary Submit an async metric query
+// @Description Queue a metric query with the parameters of GET /metrics and return its job id right away, for month-long scans that would exceed HTTP timeouts.
+// @Description Poll GET /metrics/async/{id} for the result. Jobs are kept in memory by the instance they were submitted to, for an hour once finished.
+// @Tags Metrics
+// @Accept json
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant whose events are queried"
+// @Param query body domain.MetricRequest true "Metric query"
+// @Success 202 {object} domain.MetricJobResponse "Metric job submitted"
+// @Failure 400 {object} domain.MetricJobResponse "Invalid request"
+// @Failure 403 {object} domain.MetricJobResponse "The role of the API key cannot group or filter by a column (code column_denied)"
+// @Failure 503 {object} domain.MetricJobResponse "Too many metric jobs"
+// @Failure 500 {object} domain.MetricJobResponse "Internal server error"
+// @Router /metrics/async [post]
+func (m metricJobHandler) SubmitMetricJob(ctx *fiber.Ctx) error {
+	var req domain.MetricRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.MetricJobResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	req.Tenant = tenantID(ctx)
+	req.Role = apiKeyRole(ctx)
+	if err := validations.ValidateMetricRequest(&req); err != nil {
+		status, code := validationFailure(err)
+		return ctx.Status(status).JSON(domain.MetricJobResponse{
+			Success: false,
+			Code:    code,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := m.metricJobService.SubmitMetricJob(ctx.UserContext(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyMetricJobs) {
+			return ctx.Status(fiber.StatusServiceUnavailable).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusAccepted).JSON(resp)
+}
+
+// GetMetricJob returns the state of a metric job
+// @Summary Get an async metric query
+// @Description Return the status of a metric job (queued, running, succeeded, failed or canceled), with the metrics once it succeeded.
+// @Tags Metrics
+// @Produce json
+// @Param id path string true "Job id"
+// @Param X-Tenant-ID header string false "Tenant that submitted the job"
+// @Success 200 {object} domain.MetricJobResponse "Metric job retrieved successfully"
+// @Failure 404 {object} domain.MetricJobResponse "Metric job not found"
+// @Router /metrics/async/{id} [get]
+func (m metricJobHandler) GetMetricJob(ctx *fiber.Ctx) error {
+	resp, err := m.metricJobService.GetMetricJob(ctx.UserContext(), tenantID(ctx), ctx.Params("id"))
+	return metricJobStatus(ctx, resp, err)
+}
+
+// CancelMetricJob cancels a queued or running metric job
+// @Summary Cancel an async metric query
+// @Description Cancel a queued or running metric job, aborting its ClickHouse query. Finished jobs are left as they are.
+// @Tags Metrics
+// @Produce json
+// @Param id path string true "Job id"
+// @Param X-Tenant-ID header string false "Tenant that submitted the job"
+// @Success 200 {object} domain.MetricJobResponse "Metric job canceled"
+// @Failure 404 {object} domain.MetricJobResponse "Metric job not found"
+// @Router /metrics/async/{id} [delete]
+func (m metricJobHandler) CancelMetricJob(ctx *fiber.Ctx) error {
+	resp, err := m.metricJobService.CancelMetricJob(ctx.UserContext(), tenantID(ctx), ctx.Params("id"))
+	return metricJobStatus(ctx, resp, err)
+}
+
+func metricJobStatus(ctx *fiber.Ctx, resp *domain.MetricJobResponse, err error) error {
+	if err != nil {
+		if errors.Is(err, services.ErrMetricJobNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func NewMetricJobHandler(metricJobService domain.MetricJobService) MetricJobHandler {
+	return &metricJobHandler{metricJobService: metricJobService}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ndjsonMIME is the content type of newline delimited JSON responses
+const ndjsonMIME = "application/x-ndjson"
+
+// metricStreamFlushRows is the number of buckets written per chunk of a streamed metrics response
+const metricStreamFlushRows = 1000
+
+// streamMetrics writes the buckets of a metrics query as NDJSON, one bucket per line, as ClickHouse returns them.
+// An error after the first chunk is sent is reported as a last line holding a failed domain.MetricResponse.
+func (e eventHandler) streamMetrics(ctx *fiber.Ctx, req *domain.MetricRequest) error {
+	// The body is written after the handler returns, when the request's context is no longer valid
+	stream, err := e.eventService.StreamMetrics(context.Background(), req)
+	if err != nil {
+		resp := domain.MetricResponse{
+			Success: false,
+			Message: "Failed to retrieve metrics: " + err.Error(),
+		}
+		switch {
+		case errors.Is(err, services.ErrRangeTooLong):
+			resp.Code = domain.MetricCodeRangeTooLong
+			return ctx.Status(fiber.StatusBadRequest).JSON(resp)
+		case errors.Is(err, services.ErrUnknownDimension):
+			return ctx.Status(fiber.StatusBadRequest).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+
+	ctx.Set(fiber.HeaderContentType, ndjsonMIME)
+	ctx.Status(fiber.StatusOK).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// Closing reads and discards the rest of the result if the client went away
+		defer stream.Close()
+
+		encoder := json.NewEncoder(w)
+		for rows := 1; stream.Next(); rows++ {
+			if err := encoder.Encode(stream.Result()); err != nil {
+				return
+			}
+			if rows%metricStreamFlushRows == 0 {
+				if err := w.Flush(); err != nil {
+					// The client went away
+					return
+				}
+			}
+		}
+		if err := stream.Err(); err != nil {
+			_ = encoder.Encode(domain.MetricResponse{
+				Success: false,
+				Message: "Failed to retrieve metrics: " + err.Error(),
+			})
+		}
+	})
+	return nil
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ SchemaHandler = &schemaHandler{nil}
+
+type schemaHandler struct {
+	schemaService domain.SchemaService
+}
+
+// GetMigrations lists the schema migrations of the events table
+// @Summary Schema migrations
+// @Description List the columns added to the events table by each schema migration, whether they were applied, when and by which instance
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} domain.SchemaMigrationsResponse "Schema migrations retrieved successfully"
+// @Failure 500 {object} domain.SchemaMigrationsResponse "Internal server error"
+// @Router /admin/migrations [get]
+func (s schemaHandler) GetMigrations(ctx *fiber.Ctx) error {
+	resp, err := s.schemaService.GetMigrations(ctx.UserContext())
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// ApplyMigrations applies the pending schema migrations
+// @Summary Apply schema migrations
+// @Description Add the columns of the pending schema migrations to the events table, in version order. Columns are added with defaults, which only changes the table metadata,
+// @Description so instances of the previous version keep ingesting meanwhile. Migrations rewriting the events table, of the connection's and the tenant databases, pause the inserts
+// @Description of every instance until they are applied, the events wait in the batchers. Only one instance applies migrations at a time.
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} domain.SchemaMigrationsResponse "Schema migrations applied"
+// @Failure 409 {object} domain.SchemaMigrationsResponse "Another instance is applying the migrations, or the next one changes the layout of the events table and the table_engines flag is disabled"
+// @Failure 500 {object} domain.SchemaMigrationsResponse "Internal server error"
+// @Router /admin/migrations [post]
+func (s schemaHandler) ApplyMigrations(ctx *fiber.Ctx) error {
+	resp, err := s.schemaService.ApplyMigrations(ctx.UserContext())
+	if errors.Is(err, services.ErrMigrationInProgress) || errors.Is(err, database.ErrTableEnginesDisabled) {
+		return ctx.Status(fiber.StatusConflict).JSON(resp)
+	}
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func NewSchemaHandler(schemaService domain.SchemaService) SchemaHandler {
+	return &schemaHandler{schemaService: schemaService}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetPacing reports the pacing of the budgets of campaigns
+// @Summary GET budget pacing of campaigns
+// @Description Report the spend of each campaign with events in a budget period against its budget, read from a dimension keyed by campaign_id.
+// @Description The spend is the sum of a numeric metadata field of the events from the start of the period until now. Pacing is the fraction of the budget
+// @Description spent divided by the elapsed fraction of the period: above 1.1 a campaign is overpacing, below 0.9 underpacing, and campaigns without a
+// @Description numeric budget are reported as no_budget. The elapsed part of the period is bounded like the range of GET /metrics.
+// @Tags Metrics
+// @Produce json
+// @Param dimension query string true "Dimension keyed by campaign_id holding the budgets"
+// @Param budget query string false "Attribute of the dimension holding the budget of the period (default: budget)"
+// @Param spend_field query string false "Numeric metadata field of the spend of an event (default: metadata.spend)"
+// @Param from query int true "Start of the budget period (Unix seconds)"
+// @Param to query int true "End of the budget period (Unix seconds), may be in the future"
+// @Param event_name query string false "Event name filter, e.g. the event carrying the spend"
+// @Param tag query string false "Only events with this tag, and the tags under it if it ends with /"
+// @Param max_abuse_score query int false "Leave out the events whose abuse score (0-100) is above it"
+// @Param X-Tenant-ID header string false "Tenant whose events are queried"
+// @Success 200 {object} domain.PacingResponse "Pacing computed successfully"
+// @Failure 400 {object} domain.PacingResponse "Invalid request, unknown dimension or budget attribute, or period too long (code range_too_long)"
+// @Failure 403 {object} domain.PacingResponse "The role of the API key cannot group or filter by a column (code column_denied)"
+// @Failure 500 {object} domain.PacingResponse "Internal server error"
+// @Router /metrics/pacing [get]
+func (e eventHandler) GetPacing(ctx *fiber.Ctx) error {
+	req := domain.PacingRequest{
+		Dimension:  ctx.Query("dimension"),
+		Budget:     ctx.Query("budget", "budget"),
+		SpendField: ctx.Query("spend_field", "metadata.spend"),
+		Filter:     domain.MetricRequest{Tenant: tenantID(ctx), Role: apiKeyRole(ctx)},
+	}
+	if eventName := ctx.Query("event_name"); eventName != "" {
+		req.Filter.EventName = &eventName
+	}
+	if tag := ctx.Query("tag"); tag != "" {
+		req.Filter.Tag = &tag
+	}
+	for name, value := range map[string]*int64{"from": &req.From, "to": &req.To} {
+		if str := ctx.Query(name); str != "" {
+			n, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(domain.PacingResponse{
+					Success: false,
+					Message: "Invalid '" + name + "' parameter: " + err.Error(),
+				})
+			}
+			*value = n
+		}
+	}
+	if str := ctx.Query("max_abuse_score"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.PacingResponse{
+				Success: false,
+				Message: "Invalid 'max_abuse_score' parameter: " + err.Error(),
+			})
+		}
+		req.Filter.MaxAbuseScore = &n
+	}
+
+	if err := validations.ValidatePacingRequest(&req); err != nil {
+		status, code := validationFailure(err)
+		return ctx.Status(status).JSON(domain.PacingResponse{
+			Success: false,
+			Code:    code,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := e.eventService.GetPacing(ctx.UserContext(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownDimension) || errors.Is(err, services.ErrRangeTooLong) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.PacingResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/services"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ PreStopHandler = &preStopHandler{}
+
+type preStopHandler struct {
+	drainer *services.Drainer
+}
+
+// PreStop switches the instance to drain mode before Kubernetes stops it
+// @Summary Drain the instance before it is stopped
+// @Description Meant as the preStop hook of the container, it answers once the instance can be stopped without losing events.
+// @Description /health reports draining from then on and the instance leaves service discovery, so that no new traffic is routed to it.
+// @Description Requests still arriving are served for PRESTOP_DELAY_SECONDS, then the buffered events are flushed without waiting for full batches,
+// @Description for at most PRESTOP_TIMEOUT_SECONDS. Kubernetes preStop hooks send GET requests, POST is accepted as well.
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} domain.PreStopResponse "Buffered events flushed"
+// @Failure 503 {object} domain.PreStopResponse "Buffered events could not be flushed in time"
+// @Router /admin/prestop [get]
+// @Router /admin/prestop [post]
+func (p preStopHandler) PreStop(ctx *fiber.Ctx) error {
+	resp := p.drainer.Drain(ctx.UserContext())
+	if !resp.Success {
+		return ctx.Status(fiber.StatusServiceUnavailable).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func NewPreStopHandler(drainer *services.Drainer) PreStopHandler {
+	return &preStopHandler{drainer: drainer}
+}
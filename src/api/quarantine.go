@@ -0,0 +1,91 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ QuarantineHandler = &quarantineHandler{nil}
+
+type quarantineHandler struct {
+	quarantineService domain.QuarantineService
+}
+
+// ListQuarantine lists the quarantined events
+// @Summary List quarantined events
+// @Description List the events of a tenant that violated data quality rules, newest first
+// @Tags Admin
+// @Produce json
+// @Param event_name query string false "Event name filter"
+// @Param status query string false "Status filter (quarantined, reprocessed)"
+// @Param limit query int false "Maximum number of events, 100 by default and at most 1000"
+// @Param X-Tenant-ID header string false "Tenant whose events are listed"
+// @Success 200 {object} domain.QuarantineResponse "Quarantined events retrieved successfully"
+// @Failure 400 {object} domain.QuarantineResponse "Invalid request"
+// @Failure 500 {object} domain.QuarantineResponse "Internal server error"
+// @Router /admin/quarantine [get]
+func (q quarantineHandler) ListQuarantine(ctx *fiber.Ctx) error {
+	req := domain.QuarantineRequest{
+		EventName: ctx.Query("event_name"),
+		Status:    ctx.Query("status"),
+		Limit:     ctx.QueryInt("limit"),
+		Tenant:    tenantID(ctx),
+	}
+	if err := validations.ValidateQuarantineRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.QuarantineResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := q.quarantineService.ListQuarantine(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.QuarantineResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// ReprocessQuarantine reprocesses quarantined events
+// @Summary Reprocess quarantined events
+// @Description Check quarantined events against the current data quality rules again, after an optional transform. Events passing them are ingested and marked reprocessed, the others stay quarantined with their updated violations.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant whose events are reprocessed"
+// @Param request body domain.ReprocessRequest true "Ids of the quarantined events and optional transform, source is ignored"
+// @Success 200 {object} domain.ReprocessResponse "Quarantined events reprocessed"
+// @Failure 400 {object} domain.ReprocessResponse "Invalid request"
+// @Failure 500 {object} domain.ReprocessResponse "Internal server error"
+// @Router /admin/quarantine/reprocess [post]
+func (q quarantineHandler) ReprocessQuarantine(ctx *fiber.Ctx) error {
+	var req domain.ReprocessRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.ReprocessResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	req.Tenant = tenantID(ctx)
+	req.Source = domain.ReprocessSourceQuarantine
+	if err := validations.ValidateReprocessRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.ReprocessResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := q.quarantineService.ReprocessQuarantine(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func NewQuarantineHandler(quarantineService domain.QuarantineService) QuarantineHandler {
+	return &quarantineHandler{quarantineService: quarantineService}
+}
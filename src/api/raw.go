@@ -0,0 +1,20 @@
+package api
+
+import (
+	"encoding/json"
+	"kucukaslan/clickhouse/domain"
+)
+
+// attachRawBulkEvents keeps the JSON of each event of a bulk request body as received, for events_raw.
+// The body was already parsed successfully, the events are left without raw payloads if it cannot be split.
+func attachRawBulkEvents(events []domain.EventRequest, body []byte) {
+	var raw struct {
+		Events []json.RawMessage `json:"events"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil || len(raw.Events) != len(events) {
+		return
+	}
+	for i := range events {
+		events[i].Ingest.Raw = raw.Events[i]
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetRealtimeMetrics retrieves the per-minute event counts of the last minutes
+// @Summary GET realtime metrics
+// @Description Per-minute event counts by event name and channel for the last minutes, served from this instance's memory without querying ClickHouse. Events are counted when accepted, before they are flushed. Requires EVENT_REALTIME_AGGREGATION_ENABLED.
+// @Tags Metrics
+// @Produce json
+// @Param event_name query string false "Event name filter"
+// @Param channel query string false "Channel filter"
+// @Param minutes query int false "Number of last minutes to return, defaults to the whole window"
+// @Param X-Tenant-ID header string false "Tenant whose events are queried"
+// @Success 200 {object} domain.RealtimeMetricResponse "Realtime metrics retrieved successfully"
+// @Failure 400 {object} domain.RealtimeMetricResponse "Invalid request"
+// @Failure 403 {object} domain.RealtimeMetricResponse "The role of the API key cannot group or filter by a column (code column_denied)"
+// @Failure 404 {object} domain.RealtimeMetricResponse "Realtime aggregation is disabled"
+// @Router /metrics/realtime [get]
+func (e eventHandler) GetRealtimeMetrics(ctx *fiber.Ctx) error {
+	var req domain.RealtimeMetricRequest
+
+	if eventName := ctx.Query("event_name"); eventName != "" {
+		req.EventName = &eventName
+	}
+	if channel := ctx.Query("channel"); channel != "" {
+		req.Channel = &channel
+	}
+	if minutesStr := ctx.Query("minutes"); minutesStr != "" {
+		minutes, err := strconv.Atoi(minutesStr)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.RealtimeMetricResponse{
+				Success: false,
+				Message: "Invalid 'minutes' parameter: " + err.Error(),
+			})
+		}
+		req.Minutes = minutes
+	}
+	req.Tenant = tenantID(ctx)
+	req.Role = apiKeyRole(ctx)
+
+	if err := validations.ValidateRealtimeMetricRequest(&req); err != nil {
+		status, code := validationFailure(err)
+		return ctx.Status(status).JSON(domain.RealtimeMetricResponse{
+			Success: false,
+			Code:    code,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := e.eventService.GetRealtimeMetrics(ctx.UserContext(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrRealtimeDisabled) {
+			return ctx.Status(fiber.StatusNotFound).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.RealtimeMetricResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
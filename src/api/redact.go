@@ -0,0 +1,251 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// redactLog logs the messages of the redaction middleware
+var redactLog = logging.Named("Redaction")
+
+// Redaction actions of the fields of RESPONSE_REDACT_FIELDS
+const (
+	RedactHash = "hash" // values are replaced by a keyed hash, distinct values stay distinct so that groupings still add up
+	RedactMask = "mask" // values are replaced by [REDACTED]
+)
+
+// redactor redacts the values of the configured fields in JSON responses
+type redactor struct {
+	actions map[string]string // by field
+	secret  []byte
+}
+
+// NewRedactionMiddleware redacts the configured fields in the query responses of consumer API keys, e.g. the user ids of
+// /metrics grouped by user_id, so that dashboards can be shared with less trusted audiences. A field is redacted
+// wherever it is a key of the response, and in the buckets of the metrics grouped by it. It returns nil if no field
+// is configured, and must be mounted after the API key middleware.
+func NewRedactionMiddleware(cfg *config.RedactionConfig) (fiber.Handler, error) {
+	if len(cfg.Fields) == 0 {
+		return nil, nil
+	}
+	r := &redactor{actions: make(map[string]string, len(cfg.Fields)), secret: []byte(cfg.Secret)}
+	for field, action := range cfg.Fields {
+		if action != RedactHash && action != RedactMask {
+			return nil, fmt.Errorf("unknown redaction %q of field %s, expected %s or %s", action, field, RedactHash, RedactMask)
+		}
+		r.actions[field] = action
+	}
+	if len(r.secret) == 0 {
+		// Hashes differ between instances and restarts, buckets of the same user cannot be followed across requests
+		r.secret = make([]byte, 32)
+		if _, err := rand.Read(r.secret); err != nil {
+			return nil, err
+		}
+		redactLog.Warnf("RESPONSE_REDACT_SECRET is not set, redacted values are hashed with a key of this instance")
+	}
+
+	return func(c *fiber.Ctx) error {
+		if apiKeyRole(c) != domain.APIKeyRoleConsumer {
+			return c.Next()
+		}
+		groupings, err := r.groupings(c)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(domain.EventResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		// Streamed buckets are written after the middleware returns, they are answered as a single JSON response
+		if len(groupings) > 0 && c.Method() == fiber.MethodGet {
+			c.Request().Header.Set(fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+		}
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+		if c.Response().IsBodyStream() {
+			return nil
+		}
+		// Metrics are also answered as CSV and JSONL files
+		var body []byte
+		switch contentType := string(c.Response().Header.ContentType()); {
+		case strings.HasPrefix(contentType, fiber.MIMEApplicationJSON):
+			body, err = r.redact(c.Response().Body(), groupings)
+		case strings.HasPrefix(contentType, ndjsonMIME):
+			body, err = r.redactLines(c.Response().Body(), groupings[-1])
+		case strings.HasPrefix(contentType, csvMIME):
+			body, err = r.redactCSV(c.Response().Body(), groupings[-1])
+		default:
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to redact response: %w", err)
+		}
+		c.Response().SetBodyRaw(body)
+		return nil
+	}, nil
+}
+
+// groupings returns the actions of the redacted fields the metrics of the request are grouped by, by index of the
+// query of a batch, -1 for a single query. Asynchronous metric jobs cannot be grouped by a redacted field, their
+// results are retrieved by another request that does not know their grouping.
+func (r *redactor) groupings(c *fiber.Ctx) (map[int]string, error) {
+	groupings := make(map[int]string)
+	switch {
+	case c.Method() == fiber.MethodGet && (c.Path() == "/metrics" || c.Path() == "/metrics/diff"):
+		if action, ok := r.actions[c.Query("group_by")]; ok {
+			groupings[-1] = action
+		}
+	case c.Method() == fiber.MethodPost && c.Path() == "/metrics/batch":
+		var batch domain.MetricBatchRequest
+		if json.Unmarshal(c.Body(), &batch) != nil {
+			return nil, nil // rejected by the handler
+		}
+		for i, query := range batch.Queries {
+			if query.GroupBy == nil {
+				continue
+			}
+			if action, ok := r.actions[*query.GroupBy]; ok {
+				groupings[i] = action
+			}
+		}
+	case c.Method() == fiber.MethodPost && c.Path() == "/metrics/async":
+		var query domain.MetricRequest
+		if json.Unmarshal(c.Body(), &query) == nil && query.GroupBy != nil {
+			if _, ok := r.actions[*query.GroupBy]; ok {
+				return nil, fmt.Errorf("metric jobs of consumer API keys cannot be grouped by %s, query GET /metrics instead", *query.GroupBy)
+			}
+		}
+	}
+	return groupings, nil
+}
+
+// redact redacts the fields of a JSON response and the buckets of its grouped metrics
+func (r *redactor) redact(body []byte, groupings map[int]string) ([]byte, error) {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if response, ok := value.(map[string]any); ok && len(groupings) > 0 {
+		if action, ok := groupings[-1]; ok {
+			r.redactBuckets(response, action)
+		}
+		results, _ := response["results"].([]any)
+		for i, result := range results {
+			if result, ok := result.(map[string]any); ok {
+				if action, ok := groupings[i]; ok {
+					r.redactBuckets(result, action)
+				}
+			}
+		}
+	}
+	return json.Marshal(r.redactFields(value))
+}
+
+// redactLines redacts the buckets of a JSONL response, a bucket per line, with the action of their grouping if any
+func (r *redactor) redactLines(body []byte, action string) ([]byte, error) {
+	var buf bytes.Buffer
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	encoder := json.NewEncoder(&buf)
+	for decoder.More() {
+		var metric map[string]any
+		if err := decoder.Decode(&metric); err != nil {
+			return nil, err
+		}
+		if bucket, ok := metric["bucket"].(string); ok && action != "" {
+			metric["bucket"] = r.apply(action, bucket)
+		}
+		if err := encoder.Encode(r.redactFields(metric)); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// redactCSV redacts the bucket column of a CSV response with the action of its grouping if any, and the columns
+// named after a redacted field, e.g. attributes of an enriched grouping
+func (r *redactor) redactCSV(body []byte, action string) ([]byte, error) {
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	actions := make([]string, len(records[0]))
+	for i, column := range records[0] {
+		actions[i] = r.actions[column]
+		if column == "bucket" && action != "" {
+			actions[i] = action
+		}
+	}
+	for _, record := range records[1:] {
+		for i, value := range record {
+			if actions[i] != "" {
+				record[i] = r.apply(actions[i], value)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// redactBuckets redacts the buckets of the metrics of a response
+func (r *redactor) redactBuckets(response map[string]any, action string) {
+	metrics, _ := response["metrics"].([]any)
+	for _, metric := range metrics {
+		if metric, ok := metric.(map[string]any); ok {
+			if bucket, ok := metric["bucket"].(string); ok {
+				metric["bucket"] = r.apply(action, bucket)
+			}
+		}
+	}
+}
+
+// redactFields redacts the values of the fields at any depth
+func (r *redactor) redactFields(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if action, ok := r.actions[key]; ok && field != nil {
+				v[key] = r.apply(action, fmt.Sprint(field))
+			} else {
+				v[key] = r.redactFields(field)
+			}
+		}
+	case []any:
+		for i := range v {
+			v[i] = r.redactFields(v[i])
+		}
+	}
+	return value
+}
+
+// apply redacts a value, empty values are kept
+func (r *redactor) apply(action, value string) string {
+	if value == "" {
+		return value
+	}
+	if action == RedactMask {
+		return redactedValue
+	}
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte(value))
+	return "h_" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ ReprocessHandler = &reprocessHandler{nil}
+
+type reprocessHandler struct {
+	reprocessService domain.ReprocessService
+}
+
+// Reprocess re-ingests quarantined, dead-lettered or raw events
+// @Summary Reprocess events
+// @Description Read events from the quarantine or the dead-letter queue, apply an optional transform (rename, set and remove fields) and re-ingest them through validation, the data quality rules and the batcher. Events still failing stay where they are.
+// @Description With source raw the ids are event ids of events_raw, whose payloads are parsed again and replace the stored events instead of being deduplicated.
+// @Description With source dead_letter and a sink, the events the sink failed to write are written to it again as they are and removed from its dead-letter queue.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant whose events are reprocessed"
+// @Param request body domain.ReprocessRequest true "Source, ids and transform"
+// @Success 200 {object} domain.ReprocessResponse "Events reprocessed"
+// @Failure 400 {object} domain.ReprocessResponse "Invalid request"
+// @Failure 501 {object} domain.ReprocessResponse "Source not available"
+// @Failure 500 {object} domain.ReprocessResponse "Internal server error"
+// @Router /admin/reprocess [post]
+func (r reprocessHandler) Reprocess(ctx *fiber.Ctx) error {
+	var req domain.ReprocessRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.ReprocessResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	req.Tenant = tenantID(ctx)
+	if err := validations.ValidateReprocessRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.ReprocessResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := r.reprocessService.Reprocess(ctx.UserContext(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownReprocessSource) {
+			return ctx.Status(fiber.StatusNotImplemented).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func NewReprocessHandler(reprocessService domain.ReprocessService) ReprocessHandler {
+	return &reprocessHandler{reprocessService: reprocessService}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"kucukaslan/clickhouse/logging"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequestIDHeader carries the correlation id of a request, sent by the client or generated, and echoed in the response
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the ids accepted from clients, which end up in every log of the request
+const maxRequestIDLength = 128
+
+// RequestID assigns each request a correlation id: the one of the X-Request-ID header if it is a printable ASCII
+// string of up to 128 characters, so that a request can be followed across services, a random one otherwise.
+// The id is a Local of the request and a value of its UserContext, so that services given ctx.UserContext() log it
+// through logging.RequestID.
+func RequestID(c *fiber.Ctx) error {
+	// Copied, the header is only valid during the request while the id is kept by the events it ingests
+	id := strings.Clone(c.Get(RequestIDHeader))
+	if !validRequestID(id) {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		id = hex.EncodeToString(buf)
+	}
+	c.Locals(logging.RequestIDKey, id)
+	c.SetUserContext(logging.WithRequestID(c.UserContext(), id))
+	c.Set(RequestIDHeader, id)
+	return c.Next()
+}
+
+// validRequestID reports whether an id sent by a client can be logged as is
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
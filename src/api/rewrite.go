@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ RewriteHandler = &rewriteHandler{nil}
+
+type rewriteHandler struct {
+	rewriteService domain.RewriteService
+}
+
+// ListRewriteRules lists the rewrite rules of the tenant
+// @Summary List rewrite rules
+// @Description List the rules renaming the event names and channels of the tenant's events at ingestion, with the status of their backfills
+// @Tags Admin
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant whose rules are listed"
+// @Success 200 {object} domain.RewriteRulesResponse "Rewrite rules retrieved successfully"
+// @Failure 400 {object} domain.RewriteRulesResponse "Invalid tenant"
+// @Failure 500 {object} domain.RewriteRulesResponse "Internal server error"
+// @Router /admin/rewrites [get]
+func (r rewriteHandler) ListRewriteRules(ctx *fiber.Ctx) error {
+	tenant := tenantID(ctx)
+	if err := validations.ValidateTenantID(tenant); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.RewriteRulesResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := r.rewriteService.ListRewriteRules(ctx.UserContext(), tenant)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// SetRewriteRule creates or replaces a rewrite rule of the tenant
+// @Summary Set a rewrite rule
+// @Description Rename a value of event_name or channel of the tenant's events at ingestion, e.g. after a taxonomy change. All instances apply the rule within FEATURE_FLAGS_REFRESH_INTERVAL_SECONDS.
+// @Description With retroactive true, the events stored with the old value are rewritten in the background once every instance applies the rule; its progress is reported in the rule's backfill status.
+// @Description Rules cannot be chained: a rule cannot rewrite to a value another rule rewrites, or rewrite the value another rule rewrites to.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant whose events are rewritten"
+// @Param rule body domain.RewriteRuleRequest true "Rewrite rule"
+// @Success 200 {object} domain.RewriteRulesResponse "Rewrite rule set successfully"
+// @Failure 400 {object} domain.RewriteRulesResponse "Invalid rule"
+// @Failure 409 {object} domain.RewriteRulesResponse "Rule would be chained with another rule"
+// @Failure 500 {object} domain.RewriteRulesResponse "Internal server error"
+// @Router /admin/rewrites [put]
+func (r rewriteHandler) SetRewriteRule(ctx *fiber.Ctx) error {
+	var req domain.RewriteRuleRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.RewriteRulesResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	req.Tenant = tenantID(ctx)
+	if err := validations.ValidateRewriteRuleRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.RewriteRulesResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := r.rewriteService.SetRewriteRule(ctx.UserContext(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrRewriteChain) {
+			return ctx.Status(fiber.StatusConflict).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteRewriteRule removes a rewrite rule of the tenant
+// @Summary Delete a rewrite rule
+// @Description Stop rewriting a value of event_name or channel of the tenant's events. Events keep the value they were stored with.
+// @Tags Admin
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant whose rule is deleted"
+// @Param field query string true "Field of the rule (event_name, channel)"
+// @Param from query string true "Value rewritten by the rule"
+// @Success 200 {object} domain.RewriteRulesResponse "Rewrite rule deleted successfully"
+// @Failure 400 {object} domain.RewriteRulesResponse "Invalid request"
+// @Failure 404 {object} domain.RewriteRulesResponse "Rewrite rule not found"
+// @Failure 500 {object} domain.RewriteRulesResponse "Internal server error"
+// @Router /admin/rewrites [delete]
+func (r rewriteHandler) DeleteRewriteRule(ctx *fiber.Ctx) error {
+	tenant, field, from := tenantID(ctx), ctx.Query("field"), ctx.Query("from")
+	if err := validations.ValidateRewriteRuleDeletion(tenant, field, from); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.RewriteRulesResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := r.rewriteService.DeleteRewriteRule(ctx.UserContext(), tenant, field, from)
+	if err != nil {
+		if errors.Is(err, services.ErrRewriteRuleNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func NewRewriteHandler(rewriteService domain.RewriteService) RewriteHandler {
+	return &rewriteHandler{rewriteService: rewriteService}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetSchemaDrift reports the metadata keys that appeared, disappeared or changed frequency per event name
+// @Summary Schema drift of metadata keys
+// @Description Compare the metadata keys carried by the events of each name ingested in the last days with the baseline days before them,
+// @Description from a summary table maintained by a materialized view. Keys only carried by recent events are new, keys only carried by baseline events disappeared,
+// @Description and keys whose share of the events changed by at least min_change changed. Event names without events in both periods are left out.
+// @Tags Admin
+// @Produce json
+// @Param event_name query string false "Only the keys of this event name"
+// @Param days query int false "Recent days including today (default 7)"
+// @Param baseline_days query int false "Days before the recent ones compared against (default 28)"
+// @Param min_change query number false "Change of a key's share of the events reported as drift, 0-1 (default 0.2)"
+// @Param X-Tenant-ID header string false "Tenant whose events are queried"
+// @Success 200 {object} domain.SchemaDriftResponse "Schema drift retrieved successfully"
+// @Failure 400 {object} domain.SchemaDriftResponse "Invalid request"
+// @Failure 500 {object} domain.SchemaDriftResponse "Internal server error"
+// @Router /admin/schema-drift [get]
+func (e eventHandler) GetSchemaDrift(ctx *fiber.Ctx) error {
+	req := domain.SchemaDriftRequest{Tenant: tenantID(ctx)}
+
+	if eventName := ctx.Query("event_name"); eventName != "" {
+		req.EventName = &eventName
+	}
+	for name, value := range map[string]*int{"days": &req.Days, "baseline_days": &req.BaselineDays} {
+		if str := ctx.Query(name); str != "" {
+			days, err := strconv.Atoi(str)
+			if err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(domain.SchemaDriftResponse{
+					Success: false,
+					Message: "Invalid '" + name + "' parameter: " + err.Error(),
+				})
+			}
+			*value = days
+		}
+	}
+	if str := ctx.Query("min_change"); str != "" {
+		minChange, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(domain.SchemaDriftResponse{
+				Success: false,
+				Message: "Invalid 'min_change' parameter: " + err.Error(),
+			})
+		}
+		req.MinChange = minChange
+	}
+
+	if err := validations.ValidateSchemaDriftRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.SchemaDriftResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := e.eventService.GetSchemaDrift(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.SchemaDriftResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ SLOHandler = &sloHandler{nil}
+
+type sloHandler struct {
+	sloService domain.SLOService
+}
+
+// NewSLOMiddleware counts the requests of the routes with an objective, by route pattern. It is mounted right after
+// RequestTelemetry, so that the latency of a request includes the middlewares answering it.
+func NewSLOMiddleware(sloService domain.SLOService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+		sloService.RecordRequest(c.Route().Path, responseStatus(c, err), time.Since(start))
+		return err
+	}
+}
+
+// GetSLO reports the compliance of the routes with their service level objectives
+// @Summary Service level objectives
+// @Description Report, for each route with an objective, the share of its requests answered without a server error within the latency threshold over the period,
+// @Description the error budget left and the burn rates over windows from 5 minutes to 3 days. A route is at risk when the budget burns fast over both a long and a short window.
+// @Description Requests are counted by this instance since it started.
+// @Tags Admin
+// @Produce json
+// @Success 200 {object} domain.SLOResponse "SLO retrieved successfully"
+// @Failure 500 {object} domain.SLOResponse "Internal server error"
+// @Router /admin/slo [get]
+func (s sloHandler) GetSLO(ctx *fiber.Ctx) error {
+	resp, err := s.sloService.GetSLO(ctx.UserContext())
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.SLOResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func NewSLOHandler(sloService domain.SLOService) SLOHandler {
+	return &sloHandler{sloService: sloService}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetColumnStats reports the estimated cardinality and most frequent values of the columns metrics are grouped by
+// @Summary Column statistics
+// @Description Estimate the distinct values (HyperLogLog) and the most frequent values of event_name, channel, campaign_id, user_id and tags
+// @Description over the events of the last days in a single scan, to choose sensible group_bys and check the LowCardinality types of the events table.
+// @Description Columns whose type does not suit their cardinality carry a recommendation. Duplicates not merged yet are counted.
+// @Tags Admin
+// @Produce json
+// @Param days query int false "Last days of events (default 7)"
+// @Param top query int false "Most frequent values per column (default 10)"
+// @Param X-Tenant-ID header string false "Tenant whose events are queried"
+// @Success 200 {object} domain.ColumnStatsResponse "Column statistics retrieved successfully"
+// @Failure 400 {object} domain.ColumnStatsResponse "Invalid request"
+// @Failure 500 {object} domain.ColumnStatsResponse "Internal server error"
+// @Router /admin/stats [get]
+func (e eventHandler) GetColumnStats(ctx *fiber.Ctx) error {
+	req := domain.ColumnStatsRequest{Tenant: tenantID(ctx)}
+
+	for name, value := range map[string]*int{"days": &req.Days, "top": &req.Top} {
+		if str := ctx.Query(name); str != "" {
+			n, err := strconv.Atoi(str)
+			if err != nil {
+				return ctx.Status(fiber.StatusBadRequest).JSON(domain.ColumnStatsResponse{
+					Success: false,
+					Message: "Invalid '" + name + "' parameter: " + err.Error(),
+				})
+			}
+			*value = n
+		}
+	}
+
+	if err := validations.ValidateColumnStatsRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.ColumnStatsResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := e.eventService.GetColumnStats(ctx.UserContext(), &req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.ColumnStatsResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
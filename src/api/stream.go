@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxStreamLineBytes is the maximum size of a single NDJSON line
+const maxStreamLineBytes = 1 << 20
+
+// PostEventStream handles a chunk of a client event stream
+// @Summary Post a stream chunk
+// @Description Submit a chunk of a client event stream as newline delimited JSON, one event per line.
+// @Description The response is sent once all events of the chunk are flushed to ClickHouse, then the checkpoint token is acknowledged.
+// @Description After a disconnect clients resume from the last acknowledged checkpoint; resent events are deduplicated.
+// @Tags Events
+// @Accept plain
+// @Produce json
+// @Param X-Stream-ID header string true "Client stream id"
+// @Param X-Checkpoint-Token header string true "Opaque checkpoint token of the chunk, e.g. the producer offset"
+// @Param X-Tenant-ID header string false "Tenant of the events"
+// @Param X-Client-Time header int false "Time of the client's clock when sending (Unix milliseconds), its skew from the X-Server-Time of the response is measured and corrected with CLOCK_SKEW_CORRECTION"
+// @Param Content-Encoding header string false "Compression of the body, gzip or zstd"
+// @Param events body string true "Newline delimited JSON events"
+// @Success 200 {object} domain.StreamEventResponse "Chunk flushed and checkpoint acknowledged"
+// @Failure 400 {object} domain.StreamEventResponse "Invalid request"
+// @Failure 413 {object} domain.StreamEventResponse "Body too large once decompressed"
+// @Failure 415 {object} domain.StreamEventResponse "Unsupported Content-Encoding"
+// @Failure 429 {object} domain.StreamEventResponse "Monthly quota of the tenant exceeded"
+// @Failure 503 {object} domain.StreamEventResponse "Service unavailable (buffer full)"
+// @Failure 504 {object} domain.StreamEventResponse "Chunk was not flushed in time, checkpoint not acknowledged"
+// @Failure 500 {object} domain.StreamEventResponse "Internal server error"
+// @Router /events/stream [post]
+func (e eventHandler) PostEventStream(ctx *fiber.Ctx) error {
+	req := domain.StreamEventRequest{
+		StreamID:        ctx.Get("X-Stream-ID"),
+		CheckpointToken: ctx.Get("X-Checkpoint-Token"),
+	}
+	requestBodyBytes.WithLabelValues("/events/stream").Observe(float64(len(ctx.Body())))
+
+	origin := newStreamOrigin(ctx)
+	events, err := parseNDJSON(ctx.Body(), e.rawPayloads, origin.maxEvents)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.StreamEventResponse{
+			Success:  false,
+			Message:  "Invalid request body: " + err.Error(),
+			StreamID: req.StreamID,
+		})
+	}
+	req.Events = events
+
+	status, resp := e.postStreamChunk(ctx.UserContext(), &req, origin)
+	return ctx.Status(status).JSON(resp)
+}
+
+// streamOrigin is where the chunks of a stream come from, read from the request once so that it outlives it on WebSocket connections
+type streamOrigin struct {
+	producer, tenant, agent, ip string
+	browser                     bool
+	skew                        time.Duration
+	corrected                   bool
+	maxEvents                   int
+}
+
+func newStreamOrigin(ctx *fiber.Ctx) streamOrigin {
+	origin := streamOrigin{
+		producer: producerID(ctx),
+		tenant:   tenantID(ctx),
+		agent:    userAgent(ctx),
+		ip:       clientIP(ctx),
+		browser:  clientToken(ctx) != nil,
+	}
+	origin.skew, origin.corrected = clockSkew(ctx)
+	origin.maxEvents, _ = validations.MaxBulkEvents(origin.producer, origin.tenant)
+	return origin
+}
+
+// postStreamChunk validates a chunk of a stream and waits for its flush and the acknowledgment of its checkpoint,
+// returning the status of the response
+func (e eventHandler) postStreamChunk(ctx context.Context, req *domain.StreamEventRequest, origin streamOrigin) (int, *domain.StreamEventResponse) {
+	for i := range req.Events {
+		req.Events[i].Ingest.Producer = origin.producer
+		req.Events[i].Ingest.Tenant = origin.tenant
+		req.Events[i].Ingest.Source = domain.SourceStream
+		req.Events[i].Ingest.UserAgent, req.Events[i].Ingest.Browser = origin.agent, origin.browser
+		req.Events[i].Ingest.ClientIP = origin.ip
+		if origin.corrected {
+			req.Events[i].ShiftTime(origin.skew)
+		}
+	}
+
+	if err := validations.ValidateStreamEventRequest(req, origin.maxEvents); err != nil {
+		resp := &domain.StreamEventResponse{
+			Success:  false,
+			Message:  "Validation failed: " + err.Error(),
+			StreamID: req.StreamID,
+			Count:    len(req.Events),
+		}
+		var violations *validations.BulkValidationError
+		if errors.As(err, &violations) {
+			resp.Errors = violations.Violations
+		}
+		return fiber.StatusBadRequest, resp
+	}
+
+	resp, err := e.eventService.PostEventStream(ctx, req)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		switch {
+		case errors.Is(err, services.ErrBufferFull):
+			status = fiber.StatusServiceUnavailable
+		case errors.Is(err, services.ErrFlushTimeout):
+			status = fiber.StatusGatewayTimeout
+		case errors.Is(err, services.ErrQuotaExceeded):
+			status = fiber.StatusTooManyRequests
+		}
+		return status, resp
+	}
+	return fiber.StatusOK, resp
+}
+
+// GetStreamCheckpoint returns the last acknowledged checkpoint of a stream
+// @Summary Get the last acknowledged checkpoint
+// @Description Retrieve the last acknowledged checkpoint token of a client stream, to resume the stream after a disconnect
+// @Tags Events
+// @Produce json
+// @Param stream_id query string true "Client stream id"
+// @Success 200 {object} domain.StreamEventResponse "Checkpoint retrieved successfully"
+// @Failure 400 {object} domain.StreamEventResponse "Invalid request"
+// @Failure 500 {object} domain.StreamEventResponse "Internal server error"
+// @Router /events/stream/checkpoint [get]
+func (e eventHandler) GetStreamCheckpoint(ctx *fiber.Ctx) error {
+	streamID := ctx.Query("stream_id")
+	if strings.TrimSpace(streamID) == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.StreamEventResponse{
+			Success: false,
+			Message: "stream_id is required",
+		})
+	}
+
+	resp, err := e.eventService.GetStreamCheckpoint(ctx.UserContext(), streamID)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// parseNDJSON parses one event per line, at most maxEvents, blank lines are skipped. With keepRaw the lines are kept as raw payloads.
+func parseNDJSON(body []byte, keepRaw bool, maxEvents int) ([]domain.EventRequest, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineBytes)
+
+	var events []domain.EventRequest
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(events) == maxEvents {
+			return nil, fmt.Errorf("stream chunk exceeds maximum allowed size of %d", maxEvents)
+		}
+		event, err := decodeStreamEvent(data, keepRaw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		// Blank lines are skipped, so the line is kept to report violations where they are in the body
+		event.Ingest.Line = line
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// decodeStreamEvent decodes an event of a stream chunk. With keepRaw its JSON is kept as its raw payload.
+func decodeStreamEvent(data []byte, keepRaw bool) (domain.EventRequest, error) {
+	var event domain.EventRequest
+	if err := json.Unmarshal(data, &event); err != nil {
+		return event, err
+	}
+	event.Ingest.RawBytes = len(data)
+	if keepRaw {
+		event.Ingest.Raw = bytes.Clone(data)
+	}
+	return event, nil
+}
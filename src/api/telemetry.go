@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/telemetry"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	requestBodyBytes = telemetry.NewHistogramVec("http_ingest_request_bytes",
+		"Size of ingestion request bodies in bytes", telemetry.SizeBuckets, "endpoint")
+	httpRequestsTotal = telemetry.NewCounterVec("http_requests_total",
+		"Number of HTTP requests by method, route and status", "method", "route", "status")
+	httpRequestDuration = telemetry.NewHistogramVec("http_request_duration_seconds",
+		"Duration of HTTP requests by method and route", telemetry.DefaultBuckets, "method", "route")
+	httpRequestErrorsTotal = telemetry.NewCounterVec("http_request_errors_total",
+		"Number of HTTP requests answered with an error by method, route and class (client for 4xx, server for 5xx)", "method", "route", "class")
+	httpRequestSize = telemetry.NewHistogramVec("http_request_size_bytes",
+		"Size of HTTP request bodies in bytes by method and route", telemetry.SizeBuckets, "method", "route")
+	httpResponseSize = telemetry.NewHistogramVec("http_response_size_bytes",
+		"Size of HTTP response bodies in bytes by method and route, streamed responses excluded", telemetry.SizeBuckets, "method", "route")
+	httpRequestsInFlight = telemetry.NewGaugeVec("http_requests_in_flight",
+		"Number of HTTP requests being handled")
+)
+
+// RequestTelemetry counts the requests, their errors, duration and body sizes per route (the RED metrics of the HTTP
+// layer, the ingestion has metrics of its own). Routes are labeled by their pattern, e.g. /metrics/async/:id,
+// requests answered by a middleware mounted on a prefix by the prefix, and other requests not reaching a route (unknown paths,
+// CORS preflights) as "unmatched", so that the number of series stays bounded. It must be the first middleware to count
+// the requests of recovered panics.
+func RequestTelemetry(c *fiber.Ctx) error {
+	start := time.Now()
+	inFlight := httpRequestsInFlight.WithLabelValues()
+	inFlight.Add(1)
+	err := c.Next()
+	inFlight.Add(-1)
+
+	status := responseStatus(c, err)
+	// Without a route of their own, requests are left at the last global middleware they passed
+	route := c.Route().Path
+	if route == "/" && c.Path() != "/" {
+		route = "unmatched"
+	}
+	httpRequestsTotal.WithLabelValues(c.Method(), route, strconv.Itoa(status)).Inc()
+	httpRequestDuration.WithLabelValues(c.Method(), route).Observe(time.Since(start).Seconds())
+	switch {
+	case status >= 500:
+		httpRequestErrorsTotal.WithLabelValues(c.Method(), route, "server").Inc()
+	case status >= 400:
+		httpRequestErrorsTotal.WithLabelValues(c.Method(), route, "client").Inc()
+	}
+
+	// The request body was read by the handler, the announced length covers the requests it rejected unread
+	requestSize := len(c.Request().Body())
+	if length := c.Request().Header.ContentLength(); length > requestSize {
+		requestSize = length
+	}
+	httpRequestSize.WithLabelValues(c.Method(), route).Observe(float64(requestSize))
+	// Streamed responses are written after the middleware returns, reading their body would consume the stream.
+	// Error responses are written by the error handler, their small bodies are not counted.
+	if !c.Response().IsBodyStream() && err == nil {
+		httpResponseSize.WithLabelValues(c.Method(), route).Observe(float64(len(c.Response().Body())))
+	}
+	return err
+}
+
+// responseStatus returns the status of the response to a request handled with err. Errors are turned into responses
+// by the error handler after the middlewares return, their status is the one the error handler answers.
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// RegisterIngestionGauges exposes the state of the event batcher, read at scrape time
+func RegisterIngestionGauges(eventService domain.EventService) {
+	telemetry.NewGaugeFunc("batcher_buffered_events", "Number of events waiting in the buffer channel", func() float64 {
+		return float64(eventService.GetIngestionStats().BufferedEvents)
+	})
+	telemetry.NewGaugeFunc("batcher_buffer_capacity", "Capacity of the buffer channel", func() float64 {
+		return float64(eventService.GetIngestionStats().BufferCapacity)
+	})
+	telemetry.NewGaugeFunc("batcher_batch_events", "Number of events in the batch being collected", func() float64 {
+		return float64(eventService.GetIngestionStats().BatchEvents)
+	})
+	telemetry.NewGaugeFunc("batcher_overflow_events", "Number of events spilled to disk and not yet drained into the buffer", func() float64 {
+		return float64(eventService.GetIngestionStats().OverflowEvents)
+	})
+	telemetry.NewGaugeFunc("batcher_overflow_bytes", "Size of the overflow segments on disk", func() float64 {
+		return float64(eventService.GetIngestionStats().OverflowBytes)
+	})
+	telemetry.NewGaugeFunc("batcher_last_flush_age_seconds", "Seconds since the last successful flush", func() float64 {
+		return time.Since(eventService.GetIngestionStats().LastFlushTime).Seconds()
+	})
+	telemetry.NewGaugeFunc("batcher_last_flush_failed", "Whether the last flush failed (1) or not (0)", func() float64 {
+		if eventService.GetIngestionStats().LastFlushFailed {
+			return 1
+		}
+		return 0
+	})
+}
+
+// PrometheusMetrics exposes the internal service telemetry
+// @Summary Internal service telemetry
+// @Description Expose internal service telemetry (ingestion, batching, storage) in the Prometheus text format
+// @Tags Health
+// @Produce plain
+// @Success 200 {string} string "Metrics in the Prometheus text exposition format"
+// @Router /internal/metrics [get]
+func PrometheusMetrics(c *fiber.Ctx) error {
+	var buf bytes.Buffer
+	if err := telemetry.DefaultRegistry.WriteText(&buf); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	return c.Status(fiber.StatusOK).Send(buf.Bytes())
+}
+
+// attributeRawBytes splits the size of a bulk request body among its events,
+// proportionally to their estimated size
+func attributeRawBytes(events []domain.EventRequest, bodySize int) {
+	total := 0
+	for i := range events {
+		total += events[i].EstimatedSize()
+	}
+	if total == 0 {
+		return
+	}
+	for i := range events {
+		events[i].Ingest.RawBytes = bodySize * events[i].EstimatedSize() / total
+	}
+}
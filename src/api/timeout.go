@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/telemetry"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var requestTimeoutsTotal = telemetry.NewCounterVec("http_request_timeouts_total",
+	"Number of HTTP requests answered 503 because they exceeded their timeout, by method and route", "method", "route")
+
+// routeTimeout is the timeout of the requests under a path prefix
+type routeTimeout struct {
+	prefix  string
+	timeout time.Duration
+}
+
+// NewTimeoutMiddleware bounds the handling of each request by the timeout of the longest route prefix matching its path,
+// e.g. /events/stream before /events, or REQUEST_TIMEOUT_SECONDS. The context handlers give the services (UserContext)
+// is cancelled once the timeout is exceeded, so that queries and waits stop, and a request failing after its timeout
+// is answered 503. Handlers not waiting on the context still complete, the timeout does not interrupt them.
+func NewTimeoutMiddleware(cfg *config.ServerConfig) (fiber.Handler, error) {
+	if cfg.RequestTimeoutSeconds < 0 {
+		return nil, fmt.Errorf("request timeout cannot be negative")
+	}
+	routes := make([]routeTimeout, 0, len(cfg.RouteTimeouts))
+	for prefix, value := range cfg.RouteTimeouts {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("route %q of the request timeouts must start with /", prefix)
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("invalid request timeout %q of route %s, expected a duration such as 2s", value, prefix)
+		}
+		routes = append(routes, routeTimeout{prefix: strings.TrimSuffix(prefix, "/"), timeout: timeout})
+	}
+	// The longest prefix is matched first
+	slices.SortFunc(routes, func(a, b routeTimeout) int { return len(b.prefix) - len(a.prefix) })
+	global := time.Duration(cfg.RequestTimeoutSeconds) * time.Second
+
+	return func(c *fiber.Ctx) error {
+		timeout := global
+		path := c.Path()
+		for _, route := range routes {
+			if path == route.prefix || strings.HasPrefix(path, route.prefix+"/") || route.prefix == "" {
+				timeout = route.timeout
+				break
+			}
+		}
+		if timeout == 0 {
+			return c.Next()
+		}
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+		err := c.Next()
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) || (err == nil && c.Response().StatusCode() < fiber.StatusInternalServerError) {
+			return err
+		}
+		requestTimeoutsTotal.WithLabelValues(c.Method(), c.Route().Path).Inc()
+		c.Set(fiber.HeaderRetryAfter, "1")
+		return c.Status(fiber.StatusServiceUnavailable).JSON(domain.EventResponse{
+			Success: false,
+			Message: fmt.Sprintf("Request timed out after %v", timeout),
+		})
+	}, nil
+}
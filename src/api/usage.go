@@ -0,0 +1,69 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ UsageHandler = &usageHandler{nil}
+
+type usageHandler struct {
+	usageService domain.UsageService
+}
+
+// GetUsage reports the payload and storage usage per channel and event name
+// @Summary Usage per channel and event name
+// @Description Report the number of events and bytes ingested and stored per channel and event name since this instance started, to identify which producers drive storage costs
+// @Description The events received today (UTC) by all instances per API key and channel are reported under duplicates with the share of duplicates among them, to identify producers whose retries resend stored events
+// @Tags Usage
+// @Produce json
+// @Success 200 {object} domain.UsageResponse "Usage retrieved successfully"
+// @Failure 500 {object} domain.UsageResponse "Internal server error"
+// @Router /usage [get]
+func (u usageHandler) GetUsage(ctx *fiber.Ctx) error {
+	resp, err := u.usageService.GetUsage(ctx.UserContext())
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.UsageResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// GetQuota reports the monthly quota of a tenant
+// @Summary Monthly quota of a tenant
+// @Description Report the events and bytes stored for a tenant in the current month (UTC) against its monthly caps
+// @Tags Usage
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant whose quota is reported"
+// @Success 200 {object} domain.QuotaResponse "Quota retrieved successfully"
+// @Failure 400 {object} domain.QuotaResponse "Invalid tenant"
+// @Failure 500 {object} domain.QuotaResponse "Internal server error"
+// @Router /usage/quota [get]
+func (u usageHandler) GetQuota(ctx *fiber.Ctx) error {
+	tenant := tenantID(ctx)
+	if err := validations.ValidateTenantID(tenant); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.QuotaResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+			Tenant:  tenant,
+		})
+	}
+
+	resp, err := u.usageService.GetQuota(ctx.UserContext(), tenant)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.QuotaResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+			Tenant:  tenant,
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func NewUsageHandler(usageService domain.UsageService) UsageHandler {
+	return &usageHandler{usageService: usageService}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/services"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetUserSummary retrieves the first and last event of a user
+// @Summary GET user summary
+// @Description First and last event time and event count of a user, maintained by a materialized view on the events table. Events still in the buffer are not included.
+// @Tags Users
+// @Produce json
+// @Param id path string true "User id"
+// @Param X-Tenant-ID header string false "Tenant whose events are queried"
+// @Success 200 {object} domain.UserSummaryResponse "User summary retrieved successfully"
+// @Failure 400 {object} domain.UserSummaryResponse "Invalid request"
+// @Failure 401 {object} domain.UserSummaryResponse "Missing API key, when authentication is enabled"
+// @Failure 403 {object} domain.UserSummaryResponse "The role of the API key cannot group or filter by a column (code column_denied)"
+// @Failure 404 {object} domain.UserSummaryResponse "User not found"
+// @Failure 500 {object} domain.UserSummaryResponse "Internal server error"
+// @Router /users/{id}/summary [get]
+func (e eventHandler) GetUserSummary(ctx *fiber.Ctx) error {
+	req := domain.UserSummaryRequest{
+		UserID: ctx.Params("id"),
+		Tenant: tenantID(ctx),
+		Role:   apiKeyRole(ctx),
+	}
+
+	if err := validations.ValidateUserSummaryRequest(&req); err != nil {
+		status, code := validationFailure(err)
+		return ctx.Status(status).JSON(domain.UserSummaryResponse{
+			Success: false,
+			Code:    code,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := e.eventService.GetUserSummary(ctx.UserContext(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(resp)
+		}
+		return ctx.Status(fiber.StatusInternalServerError).JSON(domain.UserSummaryResponse{
+			Success: false,
+			Message: "Internal server error: " + err.Error(),
+		})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/buildinfo"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ VersionHandler = &versionHandler{}
+
+type versionHandler struct {
+	features map[string]bool
+}
+
+// GetVersion returns the build information and the enabled optional features
+// @Summary Build information and features
+// @Description Return the build information and which optional features are enabled on this instance, so clients can detect capabilities
+// @Tags Health
+// @Produce json
+// @Success 200 {object} domain.VersionResponse "Build information and features"
+// @Router /version [get]
+func (v versionHandler) GetVersion(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(domain.VersionResponse{
+		BuildInfo: buildinfo.GetInfo(),
+		Features:  v.features,
+	})
+}
+
+func NewVersionHandler(cfg *config.Config) VersionHandler {
+	return &versionHandler{features: cfg.Features()}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetWatermark reports how fresh the stored events of the tenant are
+// @Summary Ingestion watermark
+// @Description Report the latest ingested_at of the tenant's stored events (flushed_until) and the earliest event time of its events accepted by this instance
+// @Description and not yet flushed (earliest_pending), so ETL jobs know how fresh the data is before extracting. Pending events are per instance,
+// @Description ask every instance or drain them first for a cluster-wide bound.
+// @Tags Metrics
+// @Produce json
+// @Param X-Tenant-ID header string false "Tenant whose events are reported"
+// @Success 200 {object} domain.WatermarkResponse "Ingestion watermark retrieved successfully"
+// @Failure 400 {object} domain.WatermarkResponse "Invalid tenant"
+// @Failure 500 {object} domain.WatermarkResponse "Internal server error"
+// @Router /metrics/watermark [get]
+func (e eventHandler) GetWatermark(ctx *fiber.Ctx) error {
+	tenant := tenantID(ctx)
+	if err := validations.ValidateTenantID(tenant); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.WatermarkResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+			Tenant:  tenant,
+		})
+	}
+
+	resp, err := e.eventService.GetIngestionWatermark(ctx.UserContext(), tenant)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
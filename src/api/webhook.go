@@ -0,0 +1,113 @@
+package api
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var _ WebhookHandler = &webhookHandler{nil}
+
+type webhookHandler struct {
+	webhookService domain.WebhookService
+}
+
+// RegisterWebhook registers the lifecycle webhook of the caller's API key
+// @Summary Register a lifecycle webhook
+// @Description Register a callback notified when batches containing events sent with this API key are flushed or dead-lettered.
+// @Description Replaces any existing webhook of the key. Notifications are signed with the returned secret in the X-Webhook-Signature header (sha256=HMAC-SHA256 of the body).
+// @Description The URL must resolve to public addresses only, private, loopback and link-local ones are rejected.
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Param X-API-Key header string true "API key of the producer"
+// @Param webhook body domain.WebhookRequest true "Webhook"
+// @Success 200 {object} domain.WebhookResponse "Webhook registered successfully"
+// @Failure 400 {object} domain.WebhookResponse "Invalid request"
+// @Failure 401 {object} domain.WebhookResponse "Missing API key"
+// @Failure 500 {object} domain.WebhookResponse "Internal server error"
+// @Router /webhooks [put]
+func (w webhookHandler) RegisterWebhook(ctx *fiber.Ctx) error {
+	producer := producerID(ctx)
+	if producer == "" {
+		return missingAPIKey(ctx)
+	}
+
+	var req domain.WebhookRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.WebhookResponse{
+			Success: false,
+			Message: "Invalid request body: " + err.Error(),
+		})
+	}
+	if err := validations.ValidateWebhookRequest(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.WebhookResponse{
+			Success: false,
+			Message: "Validation failed: " + err.Error(),
+		})
+	}
+
+	resp, err := w.webhookService.RegisterWebhook(ctx.UserContext(), producer, &req)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// GetWebhook returns the lifecycle webhook of the caller's API key
+// @Summary Get the lifecycle webhook
+// @Description Retrieve the webhook registered for this API key, without its secret
+// @Tags Webhooks
+// @Produce json
+// @Param X-API-Key header string true "API key of the producer"
+// @Success 200 {object} domain.WebhookResponse "Webhook retrieved successfully"
+// @Failure 401 {object} domain.WebhookResponse "Missing API key"
+// @Failure 500 {object} domain.WebhookResponse "Internal server error"
+// @Router /webhooks [get]
+func (w webhookHandler) GetWebhook(ctx *fiber.Ctx) error {
+	producer := producerID(ctx)
+	if producer == "" {
+		return missingAPIKey(ctx)
+	}
+
+	resp, err := w.webhookService.GetWebhook(ctx.UserContext(), producer)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteWebhook removes the lifecycle webhook of the caller's API key
+// @Summary Delete the lifecycle webhook
+// @Description Stop notifying the webhook registered for this API key
+// @Tags Webhooks
+// @Produce json
+// @Param X-API-Key header string true "API key of the producer"
+// @Success 200 {object} domain.WebhookResponse "Webhook deleted successfully"
+// @Failure 401 {object} domain.WebhookResponse "Missing API key"
+// @Failure 500 {object} domain.WebhookResponse "Internal server error"
+// @Router /webhooks [delete]
+func (w webhookHandler) DeleteWebhook(ctx *fiber.Ctx) error {
+	producer := producerID(ctx)
+	if producer == "" {
+		return missingAPIKey(ctx)
+	}
+
+	resp, err := w.webhookService.DeleteWebhook(ctx.UserContext(), producer)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).JSON(resp)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+func missingAPIKey(ctx *fiber.Ctx) error {
+	return ctx.Status(fiber.StatusUnauthorized).JSON(domain.WebhookResponse{
+		Success: false,
+		Message: APIKeyHeader + " header is required",
+	})
+}
+
+func NewWebhookHandler(webhookService domain.WebhookService) WebhookHandler {
+	return &webhookHandler{webhookService: webhookService}
+}
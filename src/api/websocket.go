@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"strings"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// websocketLog logs the messages of the WebSocket streams
+var websocketLog = logging.Named("WebSocket")
+
+// webSocketChunk is a message of a WebSocket stream, a chunk of events and its checkpoint token
+type webSocketChunk struct {
+	CheckpointToken string            `json:"checkpoint_token"`
+	Events          []json.RawMessage `json:"events"`
+}
+
+// EventWebSocket handles a client event stream over a WebSocket connection
+// @Summary Stream events over a WebSocket
+// @Description Upgrade to a WebSocket carrying a client event stream. Every text message is a chunk, {"checkpoint_token": "...", "events": [...]},
+// @Description answered with a message once all of its events are flushed to ClickHouse and the checkpoint token is acknowledged, in the order the chunks were sent.
+// @Description Chunks are validated and acknowledged like the ones of POST /events/stream, a rejected chunk does not close the connection.
+// @Tags Events
+// @Produce json
+// @Param stream_id query string false "Client stream id, instead of the X-Stream-ID header that browsers cannot set"
+// @Param X-Stream-ID header string false "Client stream id"
+// @Param X-Tenant-ID header string false "Tenant of the events"
+// @Success 101 {object} domain.StreamEventResponse "Switching protocols, every chunk is answered with a StreamEventResponse"
+// @Failure 400 {object} domain.StreamEventResponse "Missing stream id"
+// @Failure 426 {object} domain.StreamEventResponse "Not a WebSocket upgrade"
+// @Router /events/ws [get]
+func (e eventHandler) EventWebSocket(ctx *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(ctx) {
+		return ctx.Status(fiber.StatusUpgradeRequired).JSON(domain.StreamEventResponse{
+			Success: false,
+			Message: "WebSocket upgrade required",
+		})
+	}
+	streamID := ctx.Query("stream_id", ctx.Get("X-Stream-ID"))
+	if strings.TrimSpace(streamID) == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(domain.StreamEventResponse{
+			Success: false,
+			Message: "stream_id is required",
+		})
+	}
+
+	// The connection outlives the request, its identity is read before the upgrade
+	origin := newStreamOrigin(ctx)
+	return websocket.New(func(conn *websocket.Conn) {
+		conn.SetReadLimit(fiber.DefaultBodyLimit)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					websocketLog.Warnf("Stream %s closed: %v", streamID, err)
+				}
+				return
+			}
+			requestBodyBytes.WithLabelValues("/events/ws").Observe(float64(len(message)))
+
+			resp := e.postWebSocketChunk(streamID, message, origin)
+			if err := conn.WriteJSON(resp); err != nil {
+				websocketLog.Warnf("Failed to acknowledge a chunk of stream %s: %v", streamID, err)
+				return
+			}
+		}
+	})(ctx)
+}
+
+// postWebSocketChunk decodes a message of a WebSocket stream and posts its events like a chunk of POST /events/stream
+func (e eventHandler) postWebSocketChunk(streamID string, message []byte, origin streamOrigin) *domain.StreamEventResponse {
+	var chunk webSocketChunk
+	if err := json.Unmarshal(message, &chunk); err != nil {
+		return &domain.StreamEventResponse{Success: false, Message: "Invalid message: " + err.Error(), StreamID: streamID}
+	}
+	if len(chunk.Events) > origin.maxEvents {
+		return &domain.StreamEventResponse{
+			Success:  false,
+			Message:  fmt.Sprintf("Invalid message: stream chunk exceeds maximum allowed size of %d", origin.maxEvents),
+			StreamID: streamID,
+		}
+	}
+
+	req := domain.StreamEventRequest{
+		StreamID:        streamID,
+		CheckpointToken: chunk.CheckpointToken,
+		Events:          make([]domain.EventRequest, len(chunk.Events)),
+	}
+	for i, data := range chunk.Events {
+		event, err := decodeStreamEvent(data, e.rawPayloads)
+		if err != nil {
+			return &domain.StreamEventResponse{
+				Success:  false,
+				Message:  fmt.Sprintf("Invalid message: event %d: %v", i+1, err),
+				StreamID: streamID,
+			}
+		}
+		req.Events[i] = event
+	}
+
+	// Chunks of a connection are flushed one after the other, in the order of their checkpoints
+	_, resp := e.postStreamChunk(context.Background(), &req, origin)
+	return resp
+}
@@ -24,6 +24,46 @@ type Info struct {
 	GoVersion string        `json:"goVersion" example:"go1.25.4"`
 	Hostname  string        `json:"hostname" example:"app-server-01"`
 	Uptime    time.Duration `json:"uptime" swaggertype:"integer" example:"3600000000000"`
+	Pod       *Pod          `json:"pod,omitempty"`
+}
+
+// Pod is the Kubernetes pod the instance runs in, exposed to the container through the downward API:
+//
+//	env:
+//	  - name: POD_NAME
+//	    valueFrom: {fieldRef: {fieldPath: metadata.name}}
+//	  - name: POD_NAMESPACE
+//	    valueFrom: {fieldRef: {fieldPath: metadata.namespace}}
+//	  - name: NODE_NAME
+//	    valueFrom: {fieldRef: {fieldPath: spec.nodeName}}
+//	  - name: POD_IP
+//	    valueFrom: {fieldRef: {fieldPath: status.podIP}}
+type Pod struct {
+	Name      string `json:"name" example:"clickhouse-events-7d9c5b8f6-x2k4p"`
+	Namespace string `json:"namespace,omitempty" example:"analytics"`
+	Node      string `json:"node,omitempty" example:"ip-10-0-1-23.ec2.internal"`
+	IP        string `json:"ip,omitempty" example:"10.0.1.57"`
+}
+
+// pod is read once, the downward API environment does not change while the container runs
+var pod = loadPod()
+
+func loadPod() *Pod {
+	p := Pod{
+		Name:      os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+		Node:      os.Getenv("NODE_NAME"),
+		IP:        os.Getenv("POD_IP"),
+	}
+	if p.Name == "" {
+		return nil
+	}
+	return &p
+}
+
+// GetPod returns the pod the instance runs in, nil outside Kubernetes or without the downward API variables
+func GetPod() *Pod {
+	return pod
 }
 
 // GetInfo returns complete build and runtime information
@@ -40,6 +80,7 @@ func GetInfo() Info {
 		GoVersion: runtime.Version(),
 		Hostname:  hostname,
 		Uptime:    time.Since(startTime),
+		Pod:       pod,
 	}
 }
 
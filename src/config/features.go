@@ -0,0 +1,67 @@
+package config
+
+// Optional features reported by GET /version
+const (
+	FeatureAsyncInsert      = "async_insert"
+	FeatureParallelFlush    = "parallel_flush"
+	FeatureRateLimit        = "rate_limit"
+	FeatureUserSequence     = "user_sequence"
+	FeatureSchemaValidation = "schema_validation"
+	FeatureLowCardinality   = "campaign_id_low_cardinality"
+	FeatureTenantIsolation  = "tenant_isolation"
+	FeatureStreamIngest     = "stream_ingest"
+	FeatureWebhooks         = "webhooks"
+	FeatureTenantQuotas     = "tenant_quotas"
+	FeatureRealtimeMetrics  = "realtime_metrics"
+	FeatureTagsIndex        = "tags_index"
+	FeatureQualityRules     = "quality_rules"
+	FeatureFlushAggregates  = "flush_aggregates"
+	FeatureCORS             = "cors"
+	FeatureClientTokens     = "client_tokens"
+	FeatureAbuseScoring     = "abuse_scoring"
+	FeatureProxyProtocol    = "proxy_protocol"
+	FeatureDiscovery        = "service_discovery"
+	FeatureRawPayloads      = "raw_payloads"
+	FeatureUserOrdering     = "user_ordering"
+	FeatureClockSkew        = "clock_skew_correction"
+	FeatureAllowedValues    = "allowed_values"
+	FeatureKafka            = "kafka"
+	FeatureWAL              = "wal"
+	FeatureAuth             = "auth"
+	FeatureMultiTenant      = "multi_tenant"
+)
+
+// Features resolves which optional features are enabled by the configuration,
+// so that clients and operators can detect the capabilities of an instance
+func (c *Config) Features() map[string]bool {
+	return map[string]bool{
+		FeatureAsyncInsert:      c.ClickHouse.AsyncInsertEnabled,
+		FeatureParallelFlush:    c.ClickHouse.FlushConcurrency > 1,
+		FeatureRateLimit:        c.ClickHouse.MaxInsertsPerSecond > 0 || c.ClickHouse.MaxRowsPerSecond > 0,
+		FeatureUserSequence:     c.ClickHouse.UserSequenceEnabled,
+		FeatureSchemaValidation: c.Validation.SchemaFile != "" || c.Validation.SchemaSource == "clickhouse" || c.Validation.SchemaSource == "metadata",
+		FeatureQualityRules:     c.Validation.QualityRulesFile != "",
+		FeatureLowCardinality:   c.ClickHouse.CampaignIDLowCardinality,
+		FeatureTenantIsolation:  c.ClickHouse.TenantIsolation,
+		FeatureRealtimeMetrics:  c.ClickHouse.RealtimeAggregation,
+		FeatureFlushAggregates:  c.ClickHouse.AggregatesPublish != "",
+		FeatureCORS:             c.CORS.AllowedOrigins != "",
+		FeatureClientTokens:     c.Tokens.Secret != "",
+		FeatureAbuseScoring:     c.Abuse.Enabled,
+		FeatureProxyProtocol:    c.Server.ProxyProtocol,
+		FeatureDiscovery:        c.Discovery.Backend != "",
+		FeatureRawPayloads:      c.ClickHouse.RawPayloadTTLHours > 0,
+		FeatureUserOrdering:     c.ClickHouse.Ordering == "user",
+		FeatureClockSkew:        c.Validation.ClockSkewCorrection,
+		FeatureTagsIndex:        c.ClickHouse.TagsIndex,
+		FeatureTenantQuotas:     c.Quota.MonthlyEvents > 0 || c.Quota.MonthlyBytes > 0 || len(c.Quota.TenantEvents) > 0,
+		FeatureAllowedValues:    c.Validation.AllowedValuesFile != "" || c.Validation.AllowedChannels != "" || c.Validation.AllowedEventNames != "" || c.Validation.EventNamePattern != "",
+		FeatureKafka:            c.Sinks.KafkaBrokers != "",
+		FeatureWAL:              c.ClickHouse.OverflowDir != "",
+		FeatureAuth:             c.Auth.Enabled,
+		FeatureMultiTenant:      c.ClickHouse.TenantIsolation || len(c.Auth.KeyTenants) > 0,
+		// Always available
+		FeatureStreamIngest: true,
+		FeatureWebhooks:     true,
+	}
+}
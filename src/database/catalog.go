@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// CatalogEntry documents an event name of a tenant. Updates are inserted as new versions of the row and collapsed
+// by ReplacingMergeTree on updated_at, deleted entries are kept as a version marked deleted.
+type CatalogEntry struct {
+	ch.CHModel       `ch:"table:event_catalog"`
+	Tenant           string    `ch:"tenant,lc"`
+	EventName        string    `ch:"event_name"`
+	Owner            string    `ch:"owner"`
+	Description      string    `ch:"description,type:String"`
+	ExpectedMetadata []string  `ch:"expected_metadata,array"`
+	Deleted          uint8     `ch:"deleted"`
+	UpdatedAt        time.Time `ch:"updated_at,type:DateTime64(3)"`
+}
+
+// InitCatalogTable creates the event_catalog table if it doesn't exist.
+// Entries of all tenants share the table of the connection's database.
+func InitCatalogTable(ctx context.Context, db *ch.DB) error {
+	_, err := db.NewCreateTable().
+		Model((*CatalogEntry)(nil)).
+		Engine("ReplacingMergeTree(updated_at)").
+		Order("tenant, event_name").
+		IfNotExists().
+		Exec(ctx)
+	return err
+}
+
+// SaveCatalogEntry inserts a new version of a catalog entry
+func (c ClickHouseDB) SaveCatalogEntry(ctx context.Context, entry CatalogEntry) error {
+	if c.DB == nil {
+		return fmt.Errorf("database connection is nil")
+	}
+	if _, err := c.DB.NewInsert().Model(&entry).Exec(ctx); err != nil {
+		return fmt.Errorf("failed to insert catalog entry: %w", err)
+	}
+	return nil
+}
+
+// GetCatalogEntries returns the latest version of the catalog entries of a tenant that are not deleted, by event name.
+// If eventName is not empty only its entry is returned.
+func (c ClickHouseDB) GetCatalogEntries(ctx context.Context, tenant, eventName string) ([]CatalogEntry, error) {
+	query := c.NewSelect().
+		Model((*CatalogEntry)(nil)).
+		Final().
+		Where("tenant = ?", tenant).
+		Where("deleted = 0")
+	if eventName != "" {
+		query = query.Where("event_name = ?", eventName)
+	}
+
+	var entries []CatalogEntry
+	if err := query.OrderExpr("event_name ASC").Scan(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
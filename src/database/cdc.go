@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"kucukaslan/clickhouse/logging"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// cdcLog logs the messages of the CDC
+var cdcLog = logging.Named("CDC")
+
+// OutboxRow is a row of an outbox table, its payload is an event in the format of POST /events
+type OutboxRow struct {
+	ID      int64
+	Payload []byte
+}
+
+// SlotChange is a message of a logical replication slot decoded by wal2json (format version 2):
+// a begin or commit of a transaction, or a row change
+type SlotChange struct {
+	LSN  string
+	Data []byte
+}
+
+// PostgresCDC reads the row changes of a Postgres database, from an outbox table or a logical replication slot
+type PostgresCDC struct {
+	db *sql.DB
+}
+
+// OpenPostgresCDC connects to the Postgres database whose changes are captured
+func OpenPostgresCDC(dsn string) (*PostgresCDC, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("CDC_DSN is required to capture changes of a Postgres database")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CDC database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to CDC database: %w", err)
+	}
+	cdcLog.Infof("Database connection established successfully")
+	return &PostgresCDC{db: db}, nil
+}
+
+// Close closes the connection to the database
+func (p *PostgresCDC) Close() error {
+	return p.db.Close()
+}
+
+// quoteTable quotes a table name, optionally qualified with its schema
+func quoteTable(table string) string {
+	return pgx.Identifier(strings.Split(table, ".")).Sanitize()
+}
+
+// ConsumeOutbox locks up to limit rows of the outbox table in id order and hands them to handle, the rows are deleted
+// if it succeeds. Rows locked by another instance are skipped, so that instances consume the table in parallel;
+// the lock is held until handle returns, a failing or crashing consumer leaves the rows for the next poll.
+func (p *PostgresCDC) ConsumeOutbox(ctx context.Context, table string, limit int, handle func([]OutboxRow) error) (int, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	quoted := quoteTable(table)
+	rows, err := tx.QueryContext(ctx, "SELECT id, payload::text FROM "+quoted+" ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED", limit)
+	if err != nil {
+		return 0, err
+	}
+	var outbox []OutboxRow
+	ids := make([]int64, 0, limit)
+	for rows.Next() {
+		var row OutboxRow
+		if err := rows.Scan(&row.ID, &row.Payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		outbox = append(outbox, row)
+		ids = append(ids, row.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(outbox) == 0 {
+		return 0, nil
+	}
+
+	if err := handle(outbox); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+quoted+" WHERE id = ANY($1)", ids); err != nil {
+		return 0, err
+	}
+	return len(outbox), tx.Commit()
+}
+
+// EnsureSlot creates the logical replication slot with the wal2json output plugin if it does not exist yet
+func (p *PostgresCDC) EnsureSlot(ctx context.Context, slot string) error {
+	var exists bool
+	if err := p.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)", slot).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if _, err := p.db.ExecContext(ctx, "SELECT pg_create_logical_replication_slot($1, 'wal2json')", slot); err != nil {
+		return fmt.Errorf("failed to create replication slot %s: %w", slot, err)
+	}
+	cdcLog.Infof("Created replication slot %s", slot)
+	return nil
+}
+
+// PeekChanges reads the changes of the tables from the slot without consuming them, whole transactions of
+// at least limit changes if there are as many. Tables are schema qualified, e.g. public.orders.
+func (p *PostgresCDC) PeekChanges(ctx context.Context, slot string, tables []string, limit int) ([]SlotChange, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT lsn::text, data FROM pg_logical_slot_peek_changes($1, NULL, $2,
+		'format-version', '2', 'include-timestamp', '1', 'add-tables', $3)`, slot, limit, strings.Join(tables, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []SlotChange
+	for rows.Next() {
+		var change SlotChange
+		if err := rows.Scan(&change.LSN, &change.Data); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// AdvanceSlot consumes the changes of the slot up to lsn, so that the database can remove their WAL
+func (p *PostgresCDC) AdvanceSlot(ctx context.Context, slot, lsn string) error {
+	_, err := p.db.ExecContext(ctx, "SELECT pg_replication_slot_advance($1, $2::pg_lsn)", slot, lsn)
+	return err
+}
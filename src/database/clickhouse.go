@@ -2,11 +2,17 @@ package database
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"kucukaslan/clickhouse/buildinfo"
 	"kucukaslan/clickhouse/domain"
-	"log"
+	"kucukaslan/clickhouse/logging"
+	"os"
+	"strings"
 	"time"
 
 	"kucukaslan/clickhouse/config"
@@ -14,17 +20,78 @@ import (
 	"github.com/uptrace/go-clickhouse/ch"
 )
 
+// clickhouseLog logs the messages of the ClickHouse
+var clickhouseLog = logging.Named("ClickHouse")
+
 var clickHouseDB *ch.DB
 
-// InitClickHouse initializes the ClickHouse database connection
-func InitClickHouse(cfg *config.ClickHouseConfig) error {
+// clickHouseTLSConfig returns the TLS configuration of the native protocol, nil without TLS
+func clickHouseTLSConfig(cfg *config.ClickHouseConfig) (*tls.Config, error) {
+	if !cfg.TLS {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.TLSServerName,
+		InsecureSkipVerify: cfg.TLSSkipVerify,
+	}
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ClickHouse CA certificates: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificate found in %s", cfg.TLSCAFile)
+		}
+	}
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ClickHouse client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	if cfg.TLSSkipVerify {
+		clickhouseLog.Warnf("TLS certificate verification is disabled, the connection is not protected against interception")
+	}
+	return tlsConfig, nil
+}
+
+// InitClickHouse initializes the ClickHouse database connection, starting the local server first in the local mode
+func InitClickHouse(cfg *config.ClickHouseConfig) (err error) {
 	dsn := cfg.GetClickHouseDSN()
+	tlsConfig, err := clickHouseTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	switch cfg.Mode {
+	case ClickHouseModeServer:
+	case ClickHouseModeLocal:
+		local, localDSN, err := startLocalClickHouse(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to start local ClickHouse: %w", err)
+		}
+		localServer = local
+		dsn = localDSN
+		// The local server listens on the loopback interface only
+		tlsConfig = nil
+		defer func() {
+			if err != nil {
+				localServer.stop()
+				localServer = nil
+			}
+		}()
+	default:
+		return fmt.Errorf("unknown ClickHouse mode %q", cfg.Mode)
+	}
 
-	// Connect without TLS since ClickHouse native protocol doesn't use TLS by default
-	db := ch.Connect(
-		ch.WithDSN(dsn),
-		ch.WithInsecure(true), // Disable TLS for native protocol
-	)
+	// The TLS settings replace the sslmode of the DSN, which skips verification unless it is disabled
+	options := []ch.Option{ch.WithDSN(dsn), ch.WithInsecure(true)}
+	if tlsConfig != nil {
+		options = append(options, ch.WithTLSConfig(tlsConfig))
+	}
+	db := ch.Connect(options...)
 
 	// Test the connection
 	ctx := context.Background()
@@ -34,33 +101,134 @@ func InitClickHouse(cfg *config.ClickHouseConfig) error {
 		return fmt.Errorf("failed to initialize events table: %w", err)
 	}
 
+	if err := initUserFirstSeen(ctx, db, ""); err != nil {
+		return fmt.Errorf("failed to initialize user_first_seen table: %w", err)
+	}
+
+	if err := initMetadataKeys(ctx, db, ""); err != nil {
+		return fmt.Errorf("failed to initialize metadata_keys table: %w", err)
+	}
+
+	if err := InitQuarantineTable(ctx, db); err != nil {
+		return fmt.Errorf("failed to initialize quarantine table: %w", err)
+	}
+
+	if err := InitCatalogTable(ctx, db); err != nil {
+		return fmt.Errorf("failed to initialize event catalog table: %w", err)
+	}
+
+	if err := InitEventSchemasTable(ctx, db); err != nil {
+		return fmt.Errorf("failed to initialize event schemas table: %w", err)
+	}
+
+	if cfg.RawPayloadTTLHours > 0 {
+		if err := InitRawEventsTable(ctx, db, cfg.RawPayloadTTLHours); err != nil {
+			return fmt.Errorf("failed to initialize events_raw table: %w", err)
+		}
+	}
+
+	// Apply codecs and LowCardinality settings
+	if err := ApplyColumnSettings(ctx, db, cfg, ""); err != nil {
+		return fmt.Errorf("failed to apply events column settings: %w", err)
+	}
+
+	if err := ApplyTagsIndex(ctx, db, cfg, ""); err != nil {
+		return fmt.Errorf("failed to apply events tags index: %w", err)
+	}
+
+	if err := ApplyDeduplicationSettings(ctx, db, cfg, ""); err != nil {
+		return fmt.Errorf("failed to apply events deduplication settings: %w", err)
+	}
+
 	clickHouseDB = db
-	log.Println("ClickHouse connection established successfully")
+	clickhouseLog.Infof("Connection established successfully")
 
 	return nil
 }
 
+// ConnectSecondaryClickHouse connects to another ClickHouse server or cluster with the TLS settings of the primary one,
+// and creates and migrates the events table of the database of its DSN, e.g. for the secondary cluster sink.
+// DDL statements run without ON CLUSTER, the cluster of the primary one is not that of the secondary one.
+// Migrations changing the layout of the table are applied if tableEngines is set, rewrites never.
+func ConnectSecondaryClickHouse(cfg *config.ClickHouseConfig, dsn string, tableEngines bool) (ClickHouseDB, error) {
+	tlsConfig, err := clickHouseTLSConfig(cfg)
+	if err != nil {
+		return ClickHouseDB{}, err
+	}
+	options := []ch.Option{ch.WithDSN(dsn), ch.WithInsecure(true)}
+	if tlsConfig != nil {
+		options = append(options, ch.WithTLSConfig(tlsConfig))
+	}
+	db := ClickHouseDB{ch.Connect(options...)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := InitEventsTable(ctx, db.DB); err != nil {
+		db.Close()
+		return ClickHouseDB{}, fmt.Errorf("failed to initialize events table: %w", err)
+	}
+	// The secondary cluster is not paused by the schema coordinator, a rewrite of its events table is left to its operators
+	_, err = db.MigrateSchema(ctx, "", "", buildinfo.GetInfo().Hostname, MigrationOptions{TableEngines: tableEngines})
+	if errors.Is(err, ErrRewritePending) || errors.Is(err, ErrTableEnginesDisabled) {
+		clickhouseLog.Warnf("Events table of the secondary ClickHouse is not migrated further: %v", err)
+	} else if err != nil {
+		db.Close()
+		return ClickHouseDB{}, fmt.Errorf("failed to migrate events table: %w", err)
+	}
+	if err := ApplyColumnSettings(ctx, db.DB, cfg, ""); err != nil {
+		db.Close()
+		return ClickHouseDB{}, fmt.Errorf("failed to apply events column settings: %w", err)
+	}
+	if err := ApplyDeduplicationSettings(ctx, db.DB, cfg, ""); err != nil {
+		db.Close()
+		return ClickHouseDB{}, fmt.Errorf("failed to apply events deduplication settings: %w", err)
+	}
+	return db, nil
+}
+
 // CloseClickHouse closes the ClickHouse database connection
 func CloseClickHouse() error {
 	if clickHouseDB != nil {
 		if err := clickHouseDB.Close(); err != nil {
 			return fmt.Errorf("failed to close ClickHouse connection: %w", err)
 		}
-		log.Println("ClickHouse connection closed")
+		clickhouseLog.Infof("Connection closed")
+	}
+	if localServer != nil {
+		localServer.stop()
+		localServer = nil
 	}
 	return nil
 }
 
 // InitEventsTable creates the events table if it doesn't exist
 func InitEventsTable(ctx context.Context, db *ch.DB) error {
+	return initEventsTable(ctx, db, "")
+}
+
+// eventsTable returns the events table of a database, of the connection's database if empty.
+// Database names must be validated with DatabaseNamePattern.
+func eventsTable(database string) ch.Safe {
+	if database == "" {
+		return "events"
+	}
+	return ch.Safe(database + ".events")
+}
+
+func initEventsTable(ctx context.Context, db *ch.DB, database string) error {
+	table := eventsTable(database)
 	_, err := db.NewCreateTable().
 		Model((*Event)(nil)).
+		ModelTableExpr("?", table).
 		Engine("ReplacingMergeTree(ingested_at)").
-		Order("timestamp, event_name, channel, user_id").
+		Order("timestamp, event_name, channel, user_id, tenant_id").
 		IfNotExists().
 		Exec(ctx)
-
-	return err
+	if err != nil {
+		return err
+	}
+	// Columns added since tables of earlier versions were created are added by the schema migrations
+	return initSchemaMigrations(ctx, db, database)
 }
 
 // ClickHouseHealthCheck verifies that the ClickHouse connection is alive
@@ -83,9 +251,19 @@ type Event struct {
 	Channel    string    `ch:"channel,lc"`
 	CampaignID string    `ch:"campaign_id"`
 	UserID     string    `ch:"user_id"`
-	Timestamp  time.Time `ch:"timestamp"`
+	Timestamp  time.Time `ch:"timestamp,type:DateTime64(3)"`
 	Tags       []string  `ch:"tags,array"`
 	Metadata   string    `ch:"metadata,type:String"`
+	UserSeq    uint64    `ch:"user_seq"`
+	AbuseScore uint8     `ch:"abuse_score"`
+	// TenantID scopes the rows of tenants sharing the events table, empty for the default tenant
+	TenantID string `ch:"tenant_id,lc"`
+
+	// Lineage of the row: the API key and source it was received from, the instance and insert that wrote it
+	APIKeyID string `ch:"api_key_id,lc"`
+	Source   string `ch:"source,lc"`
+	Instance string `ch:"instance,lc"`
+	BatchID  string `ch:"batch_id"`
 
 	IngestedAt time.Time `ch:"ingested_at,default:now()"`
 }
@@ -97,11 +275,21 @@ type EventColumnar struct {
 	Channel    []string    `ch:"channel,lc"`
 	CampaignID []string    `ch:"campaign_id"`
 	UserID     []string    `ch:"user_id"`
-	Timestamp  []time.Time `ch:"timestamp"`
+	Timestamp  []time.Time `ch:"timestamp,type:DateTime64(3)"`
 	Tags       [][]string  `ch:"tags,array"`
 	Metadata   []string    `ch:"metadata,type:String"`
+	UserSeq    []uint64    `ch:"user_seq"`
+	AbuseScore []uint8     `ch:"abuse_score"`
+	TenantID   []string    `ch:"tenant_id,lc"`
+
+	APIKeyID []string `ch:"api_key_id,lc"`
+	Source   []string `ch:"source,lc"`
+	Instance []string `ch:"instance,lc"`
+	BatchID  []string `ch:"batch_id"`
 
 	IngestedAt []time.Time `ch:"ingested_at,default:now()"`
+
+	batchID string // batch the rows are stamped with
 }
 
 // SaveEvent saves an event to ClickHouse using async insert for high throughput
@@ -126,70 +314,152 @@ func (c ClickHouseDB) SaveEvent(ctx context.Context, request domain.EventRequest
 	return nil
 }
 
+// NewEventColumnar creates an empty columnar model with room for capacity events
+func NewEventColumnar(capacity int) *EventColumnar {
+	return &EventColumnar{
+		EventName:  make([]string, 0, capacity),
+		Channel:    make([]string, 0, capacity),
+		CampaignID: make([]string, 0, capacity),
+		UserID:     make([]string, 0, capacity),
+		Timestamp:  make([]time.Time, 0, capacity),
+		Tags:       make([][]string, 0, capacity),
+		Metadata:   make([]string, 0, capacity),
+		UserSeq:    make([]uint64, 0, capacity),
+		AbuseScore: make([]uint8, 0, capacity),
+		TenantID:   make([]string, 0, capacity),
+		APIKeyID:   make([]string, 0, capacity),
+		Source:     make([]string, 0, capacity),
+		Instance:   make([]string, 0, capacity),
+		BatchID:    make([]string, 0, capacity),
+		IngestedAt: make([]time.Time, 0, capacity),
+	}
+}
+
+// Append converts an event request and appends it to the columns.
+// This lets callers build the columnar model incrementally instead of converting a whole batch at insert time.
+func (c *EventColumnar) Append(request domain.EventRequest) error {
+	metadataJSON, err := serializeMetadata(request.Metadata)
+	if err != nil {
+		return err
+	}
+
+	c.EventName = append(c.EventName, request.EventName)
+	c.Channel = append(c.Channel, request.Channel)
+	c.CampaignID = append(c.CampaignID, request.CampaignID)
+	c.UserID = append(c.UserID, request.UserID)
+	c.Timestamp = append(c.Timestamp, request.EventTime())
+	c.Tags = append(c.Tags, request.Tags)
+	c.Metadata = append(c.Metadata, metadataJSON)
+	c.UserSeq = append(c.UserSeq, request.Ingest.Sequence)
+	c.AbuseScore = append(c.AbuseScore, request.Ingest.AbuseScore)
+	c.TenantID = append(c.TenantID, request.Ingest.Tenant)
+	c.APIKeyID = append(c.APIKeyID, request.Ingest.Producer)
+	c.Source = append(c.Source, request.Ingest.Source)
+	// instance, batch_id and ingested_at are stamped right before the insert
+	c.Instance = append(c.Instance, "")
+	c.BatchID = append(c.BatchID, "")
+	c.IngestedAt = append(c.IngestedAt, time.Time{})
+	return nil
+}
+
+// Len returns the number of rows in the columnar model
+func (c *EventColumnar) Len() int {
+	return len(c.EventName)
+}
+
+// RowSize estimates the uncompressed size of a row in bytes as stored by ClickHouse
+func (c *EventColumnar) RowSize(i int) int {
+	// strings are stored with a length prefix, DateTime64 takes 8 bytes and DateTime 4 bytes
+	size := len(c.EventName[i]) + len(c.Channel[i]) + len(c.CampaignID[i]) + len(c.UserID[i]) + len(c.Metadata[i]) + 5
+	// tenant and lineage columns, low cardinality ones are stored as an index into their dictionary
+	size += 4 + 4 + len(c.BatchID[i]) + 1
+	size += 8 + 4
+	// user_seq and abuse_score
+	size += 8 + 1
+	// arrays are stored as an offset plus their elements
+	size += 8
+	for _, tag := range c.Tags[i] {
+		size += len(tag) + 1
+	}
+	return size
+}
+
+// Size estimates the uncompressed size of all rows in bytes as stored by ClickHouse
+func (c *EventColumnar) Size() int {
+	size := 0
+	for i := 0; i < c.Len(); i++ {
+		size += c.RowSize(i)
+	}
+	return size
+}
+
+// Filter returns a new columnar model that holds only the rows where keep is true
+func (c *EventColumnar) Filter(keep []bool) *EventColumnar {
+	filtered := NewEventColumnar(len(keep))
+	for i, ok := range keep {
+		if !ok {
+			continue
+		}
+		filtered.EventName = append(filtered.EventName, c.EventName[i])
+		filtered.Channel = append(filtered.Channel, c.Channel[i])
+		filtered.CampaignID = append(filtered.CampaignID, c.CampaignID[i])
+		filtered.UserID = append(filtered.UserID, c.UserID[i])
+		filtered.Timestamp = append(filtered.Timestamp, c.Timestamp[i])
+		filtered.Tags = append(filtered.Tags, c.Tags[i])
+		filtered.Metadata = append(filtered.Metadata, c.Metadata[i])
+		filtered.UserSeq = append(filtered.UserSeq, c.UserSeq[i])
+		filtered.AbuseScore = append(filtered.AbuseScore, c.AbuseScore[i])
+		filtered.TenantID = append(filtered.TenantID, c.TenantID[i])
+		filtered.APIKeyID = append(filtered.APIKeyID, c.APIKeyID[i])
+		filtered.Source = append(filtered.Source, c.Source[i])
+		filtered.Instance = append(filtered.Instance, c.Instance[i])
+		filtered.BatchID = append(filtered.BatchID, c.BatchID[i])
+		filtered.IngestedAt = append(filtered.IngestedAt, c.IngestedAt[i])
+	}
+	filtered.batchID = c.batchID
+	return filtered
+}
+
 // SaveEvents saves multiple events to ClickHouse using native columnar insert format
 // This method uses ClickHouse's columnar insert which is significantly faster than row-based inserts
 // Data is sent column-by-column as arrays, optimizing for ClickHouse's columnar storage engine
 func (c ClickHouseDB) SaveEvents(ctx context.Context, requests []domain.EventRequest) error {
+	columnarModel := NewEventColumnar(len(requests))
+	for _, request := range requests {
+		if err := columnarModel.Append(request); err != nil {
+			return err
+		}
+	}
+	return c.SaveColumnar(ctx, columnarModel)
+}
+
+// SaveColumnar inserts an already built columnar model into ClickHouse
+func (c ClickHouseDB) SaveColumnar(ctx context.Context, columnarModel *EventColumnar) error {
+	return c.SaveColumnarTo(ctx, "", columnarModel)
+}
+
+// SaveColumnarTo inserts an already built columnar model into the events table of a database,
+// of the connection's database if empty
+func (c ClickHouseDB) SaveColumnarTo(ctx context.Context, database string, columnarModel *EventColumnar) error {
 	if c.DB == nil {
 		return fmt.Errorf("database connection is nil")
 	}
 
-	if len(requests) == 0 {
+	if columnarModel.Len() == 0 {
 		return fmt.Errorf("no events to insert")
 	}
 
-	batchSize := len(requests)
 	now := time.Now()
-
-	eventNames := make([]string, 0, batchSize)
-	channels := make([]string, 0, batchSize)
-	campaignIDs := make([]string, 0, batchSize)
-	userIDs := make([]string, 0, batchSize)
-	timestamps := make([]time.Time, 0, batchSize)
-	tags := make([][]string, 0, batchSize)
-	metadata := make([]string, 0, batchSize)
-	ingestedAt := make([]time.Time, 0, batchSize)
-
-	// Extract columns from requests
-	for _, request := range requests {
-		// Serialize metadata to JSON string
-		metadataJSON := ""
-		if request.Metadata != nil && len(request.Metadata) > 0 {
-			metadataBytes, err := json.Marshal(request.Metadata)
-			if err != nil {
-				return fmt.Errorf("failed to serialize metadata: %w", err)
-			}
-			metadataJSON = string(metadataBytes)
-		}
-
-		// Convert Unix timestamp to DateTime
-		eventTime := time.Unix(request.Timestamp, 0)
-
-		// Append to columnar arrays
-		eventNames = append(eventNames, request.EventName)
-		channels = append(channels, request.Channel)
-		campaignIDs = append(campaignIDs, request.CampaignID)
-		userIDs = append(userIDs, request.UserID)
-		timestamps = append(timestamps, eventTime)
-		tags = append(tags, request.Tags)
-		metadata = append(metadata, metadataJSON)
-		ingestedAt = append(ingestedAt, now)
+	for i := range columnarModel.IngestedAt {
+		columnarModel.IngestedAt[i] = now
 	}
-
-	// Create columnar model
-	columnarModel := &EventColumnar{
-		EventName:  eventNames,
-		Channel:    channels,
-		CampaignID: campaignIDs,
-		UserID:     userIDs,
-		Timestamp:  timestamps,
-		Tags:       tags,
-		Metadata:   metadata,
-		IngestedAt: ingestedAt,
+	if columnarModel.Batch() == "" {
+		columnarModel.Stamp(NewBatchID())
 	}
 
 	_, err := c.DB.NewInsert().
 		Model(columnarModel).
+		ModelTableExpr("?", eventsTable(database)).
 		Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to columnar insert events: %w", err)
@@ -197,19 +467,15 @@ func (c ClickHouseDB) SaveEvents(ctx context.Context, requests []domain.EventReq
 
 	return nil
 }
+
 func mapEventRequestToEvent(request domain.EventRequest) (*Event, error) {
-	// Serialize metadata to JSON string
-	metadataJSON := ""
-	if request.Metadata != nil && len(request.Metadata) > 0 {
-		metadataBytes, err := json.Marshal(request.Metadata)
-		if err != nil {
-			return nil, fmt.Errorf("failed to serialize metadata: %w", err)
-		}
-		metadataJSON = string(metadataBytes)
+	metadataJSON, err := serializeMetadata(request.Metadata)
+	if err != nil {
+		return nil, err
 	}
 
-	// Convert Unix timestamp to DateTime
-	eventTime := time.Unix(request.Timestamp, 0)
+	// Convert Unix timestamp to DateTime64
+	eventTime := request.EventTime()
 
 	event := &Event{
 		EventName:  request.EventName,
@@ -219,36 +485,130 @@ func mapEventRequestToEvent(request domain.EventRequest) (*Event, error) {
 		Timestamp:  eventTime,
 		Tags:       request.Tags,
 		Metadata:   metadataJSON,
+		UserSeq:    request.Ingest.Sequence,
+		AbuseScore: request.Ingest.AbuseScore,
+		TenantID:   request.Ingest.Tenant,
+		APIKeyID:   request.Ingest.Producer,
+		Source:     request.Ingest.Source,
+		Instance:   InstanceName,
+		BatchID:    NewBatchID(),
 	}
 	return event, nil
 }
 
+// serializeMetadata serializes event metadata to a JSON string, empty metadata is stored as an empty string
+func serializeMetadata(metadata map[string]any) (string, error) {
+	if len(metadata) == 0 {
+		return "", nil
+	}
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize metadata: %w", err)
+	}
+	return string(metadataBytes), nil
+}
+
+// timeBucketExprs are the bucket expressions of the time groupings of /metrics
+var timeBucketExprs = map[string]string{
+	"hour":  "toString(toStartOfHour(timestamp))",
+	"day":   "toString(toStartOfDay(timestamp))",
+	"week":  "toString(toStartOfWeek(timestamp))",
+	"month": "toString(toStartOfMonth(timestamp))",
+	"year":  "toString(toStartOfYear(timestamp))",
+}
+
 type MetricResult struct {
 	// The "Bucket" holds the group name (e.g., "2024-08-25 10:00:00" or "mobile")
 	Bucket      string `ch:"bucket"`
 	TotalEvents uint64 `ch:"total_events"`
 	UniqueUsers uint64 `ch:"unique_users"`
+	// CountError is the largest overestimate of TotalEvents of a top_k bucket
+	CountError uint64 `ch:"count_error"`
+	// AttributeValues are the dimension attributes of the bucket, in the order of the request's EnrichAttributes
+	AttributeValues []string `ch:"attribute_values"`
 }
 
 // GetMetrics retrieves aggregated metrics from events table
 func (c ClickHouseDB) GetMetrics(ctx context.Context, request domain.MetricRequest) ([]MetricResult, error) {
+	return c.GetMetricsFrom(ctx, "", request)
+}
+
+// GetMetricsFrom aggregates the events table of a database, of the connection's database if empty
+func (c ClickHouseDB) GetMetricsFrom(ctx context.Context, database string, request domain.MetricRequest) ([]MetricResult, error) {
 	var results []MetricResult
+	if err := c.metricsQuery(database, request).Scan(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// MetricRows iterates over the buckets of a metrics query as ClickHouse sends them, one block at a time
+type MetricRows struct {
+	rows     *ch.Rows
+	enriched bool
+	result   MetricResult
+	err      error
+}
+
+// QueryMetricsFrom runs the metrics query of GetMetricsFrom without reading its result,
+// so that buckets of high cardinality groupings are not held in memory all at once. The rows must be closed.
+func (c ClickHouseDB) QueryMetricsFrom(ctx context.Context, database string, request domain.MetricRequest) (*MetricRows, error) {
+	query := c.metricsQuery(database, request)
+	rows, err := c.QueryContext(ctx, query.String())
+	if err != nil {
+		return nil, err
+	}
+	return &MetricRows{
+		rows:     rows,
+		enriched: request.GroupBy != nil && request.Enrich != nil && len(request.EnrichAttributes) > 0,
+	}, nil
+}
 
+// Next reads the next bucket, false at the end of the result or on an error
+func (r *MetricRows) Next() bool {
+	if r.err != nil || !r.rows.Next() {
+		return false
+	}
+	r.result = MetricResult{}
+	dest := []any{&r.result.Bucket, &r.result.TotalEvents, &r.result.UniqueUsers}
+	if r.enriched {
+		dest = append(dest, &r.result.AttributeValues)
+	}
+	if err := r.rows.Scan(dest...); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+// Result returns the bucket read by Next
+func (r *MetricRows) Result() MetricResult {
+	return r.result
+}
+
+// Err returns the error that stopped Next, nil at the end of the result
+func (r *MetricRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.rows.Err()
+}
+
+// Close reads the rest of the result and releases the connection
+func (r *MetricRows) Close() error {
+	return r.rows.Close()
+}
+
+// metricsQuery builds the query of the total events and unique users per bucket of a metrics request
+func (c ClickHouseDB) metricsQuery(database string, request domain.MetricRequest) *ch.SelectQuery {
 	// 1. Determine the Grouping Logic safely
 	// Prevents SQL injection by validating the input against an allowlist.
 	var groupExpr string
+	var groupArgs []any
 	if request.GroupBy != nil {
 		switch *request.GroupBy {
-		case "hour":
-			groupExpr = "toString(toStartOfHour(timestamp))"
-		case "day":
-			groupExpr = "toString(toStartOfDay(timestamp))"
-		case "week":
-			groupExpr = "toString(toStartOfWeek(timestamp))"
-		case "month":
-			groupExpr = "toString(toStartOfMonth(timestamp))"
-		case "year":
-			groupExpr = "toString(toStartOfYear(timestamp))"
+		case "hour", "day", "week", "month", "year":
+			groupExpr = timeBucketExprs[*request.GroupBy]
 		case "channel":
 			groupExpr = "channel"
 		case "campaign_id":
@@ -257,47 +617,165 @@ func (c ClickHouseDB) GetMetrics(ctx context.Context, request domain.MetricReque
 			groupExpr = "user_id"
 		case "event_name":
 			groupExpr = "event_name"
+		case "tags":
+			var tags string
+			tags, groupArgs = tagsExpr(request)
+			groupExpr = "arrayJoin(" + tags + ")"
 		default:
 			// Default fallback (e.g., if they didn't provide a valid group)
 		}
 	}
+	if request.TopK != nil && groupExpr != "" {
+		return c.topKQuery(database, request, groupExpr)
+	}
 
 	query := c.NewSelect().
 		// Explicitly use TableExpr to add 'FINAL'.
 		// This forces ClickHouse to deduplicate rows before counting.
-		TableExpr("events FINAL")
+		TableExpr("? FINAL", eventsTable(database))
 
 	if groupExpr != "" {
-		query = query.ColumnExpr("? AS bucket", ch.Safe(groupExpr))
+		query = query.ColumnExpr(groupExpr+" AS bucket", groupArgs...)
 	} else {
 		query = query.ColumnExpr("'total' AS bucket")
 	}
+	uniqueExpr := "uniqExact(user_id) AS unique_users"
+	if request.ApproxUnique {
+		// HyperLogLog based, far less memory on high cardinality at the cost of ~1% error
+		uniqueExpr = "uniq(user_id) AS unique_users"
+	}
 	query = query.
 		ColumnExpr("count() AS total_events").
-		ColumnExpr("uniqExact(user_id) AS unique_users")
+		ColumnExpr(uniqueExpr)
+
+	if groupExpr != "" && request.Enrich != nil && len(request.EnrichAttributes) > 0 {
+		// Names come from validated dimension uploads
+		values := make([]string, len(request.EnrichAttributes))
+		for i, attribute := range request.EnrichAttributes {
+			values[i] = fmt.Sprintf("dictGetOrDefault('%s', '%s', tuple(toString(bucket)), '')",
+				dimensionDictionary(*request.Enrich), attribute)
+		}
+		query = query.ColumnExpr("[?] AS attribute_values", ch.Safe(strings.Join(values, ", ")))
+	}
+
+	query = eventFilters(query, database, request)
+	if groupExpr != "" {
+		// The alias, the tags grouping is not a column but the array join of the tags
+		query = query.GroupExpr("bucket")
+		query = query.OrderExpr("bucket ASC")
+	}
+	return query
+}
 
+// topKQuery builds the query of the approximate top buckets of a metrics request in a single pass over the events,
+// without the hash table of every bucket an exact GROUP BY keeps. approx_top_count (Filtered Space-Saving) overestimates
+// total_events by up to count_error and may miss buckets close to the cutoff. Unique users are not counted, and the table
+// is read without FINAL, so duplicates not merged yet are counted.
+func (c ClickHouseDB) topKQuery(database string, request domain.MetricRequest, groupExpr string) *ch.SelectQuery {
+	topExpr := fmt.Sprintf("approx_top_count(%d)(%s)", *request.TopK, groupExpr)
+	var args []any
+	if *request.GroupBy == "tags" {
+		// Tags are counted by element of the distinct tags of an event
+		var tags string
+		tags, args = tagsExpr(request)
+		topExpr = fmt.Sprintf("approx_top_countArray(%d)(%s)", *request.TopK, tags)
+	}
+	tops := c.NewSelect().
+		ColumnExpr(topExpr+" AS tops", args...).
+		TableExpr("?", eventsTable(database))
+	tops = eventFilters(tops, database, request)
+
+	return c.NewSelect().
+		ColumnExpr("toString(top.1) AS bucket").
+		ColumnExpr("toUInt64(top.2) AS total_events").
+		ColumnExpr("toUInt64(top.3) AS count_error").
+		TableExpr("(?)", tops).
+		Join("ARRAY JOIN tops AS top").
+		OrderExpr("total_events DESC, bucket ASC")
+}
+
+// tagsExpr returns the array of the tags grouping, whose elements are the buckets of an event. An event is counted
+// once in the bucket of each of its tags, cut to tag_depth levels, and not at all without tags. Filtered by a tag prefix,
+// only the tags under it are buckets.
+func tagsExpr(request domain.MetricRequest) (string, []any) {
+	tags := "tags"
+	var args []any
+	if request.Tag != nil {
+		if parent, ok := domain.TagPrefix(*request.Tag); ok {
+			tags = "arrayFilter(t -> t = ? OR startsWith(t, ?), tags)"
+			args = append(args, parent, *request.Tag)
+		}
+	}
+	if request.TagDepth != nil {
+		// The depth is validated, at least 1
+		tags = fmt.Sprintf("arrayMap(t -> arrayStringConcat(arraySlice(splitByChar('%s', t), 1, %d), '%s'), %s)",
+			domain.TagSeparator, *request.TagDepth, domain.TagSeparator, tags)
+	}
+	return "arrayDistinct(" + tags + ")", args
+}
+
+// eventFilters restricts a query of the events table to the events of a metrics request
+func eventFilters(query *ch.SelectQuery, database string, request domain.MetricRequest) *ch.SelectQuery {
+	// Tenants without a database of their own share the connection's events table
+	if database == "" {
+		query = query.Where("tenant_id = ?", request.Tenant)
+	}
 	if request.EventName != nil && *request.EventName != "" {
 		query = query.Where("event_name = ?", *request.EventName)
 	}
+	if request.Tag != nil {
+		if parent, ok := domain.TagPrefix(*request.Tag); ok {
+			// Prefixes cannot be looked up in the tags_bloom index, the granules are all read
+			query = query.Where("has(tags, ?) OR arrayExists(t -> startsWith(t, ?), tags)", parent, *request.Tag)
+		} else {
+			// Served by the tags_bloom index if enabled
+			query = query.Where("has(tags, ?)", *request.Tag)
+		}
+	}
+	if request.MaxAbuseScore != nil {
+		query = query.Where("abuse_score <= ?", *request.MaxAbuseScore)
+	}
+	if request.AsOf != nil {
+		query = query.Where("ingested_at <= ?", time.Unix(*request.AsOf, 0))
+	}
 	if request.From != nil {
 		fromTime := time.Unix(*request.From, 0)
 		query = query.Where("timestamp >= ?", fromTime)
 	}
 	if request.To != nil {
-		toTime := time.Unix(*request.To, 0)
-		query = query.Where("timestamp <= ?", toTime)
-	}
-	if groupExpr != "" {
-		query = query.GroupExpr(groupExpr)
-		query = query.OrderExpr("bucket ASC")
+		// to is inclusive, timestamps have millisecond precision so the whole last second is included
+		toTime := time.Unix(*request.To+1, 0)
+		query = query.Where("timestamp < ?", toTime)
 	}
+	return query
+}
 
-	err := query.Scan(ctx, &results)
+// watermarkLookbackDays bounds the partitions read for the ingestion watermark, events are stored shortly after they happen
+const watermarkLookbackDays = 2
+
+// GetIngestionWatermark returns the latest ingested_at of the events table of a database, of the connection's database if empty.
+// Only the partitions of the last days are read, the whole table only if they are empty; the zero time if there are no events.
+// A late event with an older timestamp may have been stored after the returned watermark.
+func (c ClickHouseDB) GetIngestionWatermark(ctx context.Context, database string) (time.Time, error) {
+	var watermark time.Time
+	err := c.NewSelect().
+		ColumnExpr("max(ingested_at)").
+		TableExpr("?", eventsTable(database)).
+		Where("timestamp >= now() - INTERVAL ? DAY", watermarkLookbackDays).
+		Scan(ctx, &watermark)
+	if err == nil && watermark.Unix() <= 0 {
+		err = c.NewSelect().
+			ColumnExpr("max(ingested_at)").
+			TableExpr("?", eventsTable(database)).
+			Scan(ctx, &watermark)
+	}
 	if err != nil {
-		return nil, err
+		return time.Time{}, err
 	}
-
-	return results, err
+	if watermark.Unix() <= 0 {
+		return time.Time{}, nil
+	}
+	return watermark, nil
 }
 
 type ClickHouseDB struct {
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"os"
+	"testing"
+	"time"
+)
+
+// benchmarkBatchSize is the size of the batches conversion shows up in the profiles from
+const benchmarkBatchSize = 5000
+
+func benchmarkEvents(n int) []domain.EventRequest {
+	now := time.Now().Unix()
+	events := make([]domain.EventRequest, n)
+	for i := range events {
+		events[i] = domain.EventRequest{
+			EventName:  "product_view",
+			Channel:    "web",
+			CampaignID: fmt.Sprintf("campaign_%d", i%50),
+			UserID:     fmt.Sprintf("user_%d", i),
+			Timestamp:  now - int64(i%3600),
+			Tags:       []string{"electronics", "homepage"},
+			Metadata:   map[string]any{"product_id": fmt.Sprintf("prod-%d", i), "price": 129.99, "currency": "TRY"},
+		}
+	}
+	return events
+}
+
+// BenchmarkEventColumnarAppend measures building the columns of a batch event by event, as the batcher does at enqueue time
+func BenchmarkEventColumnarAppend(b *testing.B) {
+	events := benchmarkEvents(benchmarkBatchSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		columns := NewEventColumnar(benchmarkBatchSize)
+		for _, event := range events {
+			if err := columns.Append(event); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkSaveColumnar measures a flush of a batch, converting the events at insert time (events) and inserting columns
+// built beforehand (columnar). It needs a ClickHouse server configured like the service and is skipped unless CLICKHOUSE_BENCH=1.
+func BenchmarkSaveColumnar(b *testing.B) {
+	if os.Getenv("CLICKHOUSE_BENCH") != "1" {
+		b.Skip("set CLICKHOUSE_BENCH=1 to insert into the configured ClickHouse")
+	}
+	cfg := config.Load()
+	if err := InitClickHouse(&cfg.ClickHouse); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { CloseClickHouse() })
+	db := GetClickHouseDB()
+	ctx := context.Background()
+	events := benchmarkEvents(benchmarkBatchSize)
+
+	b.Run("events", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := db.SaveEvents(ctx, events); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("columnar", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			columns := NewEventColumnar(benchmarkBatchSize)
+			for _, event := range events {
+				if err := columns.Append(event); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StartTimer()
+			if err := db.SaveColumnar(ctx, columns); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"kucukaslan/clickhouse/config"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// codecPattern restricts codec expressions to codec names, parameters and separators, e.g. "Delta, ZSTD(1)"
+var codecPattern = regexp.MustCompile(`^[A-Za-z0-9_(), ]+$`)
+
+// ColumnCompression holds the on-disk compression statistics of a column
+type ColumnCompression struct {
+	Name              string `ch:"name"`
+	Type              string `ch:"type"`
+	CompressionCodec  string `ch:"compression_codec"`
+	CompressedBytes   uint64 `ch:"data_compressed_bytes"`
+	UncompressedBytes uint64 `ch:"data_uncompressed_bytes"`
+}
+
+// ApplyColumnSettings applies the configured codecs and LowCardinality settings to the events table of a database,
+// of the connection's database if empty.
+// Codec changes only affect newly written parts, existing parts are recompressed as they get merged.
+func ApplyColumnSettings(ctx context.Context, db *ch.DB, cfg *config.ClickHouseConfig, database string) error {
+	table := eventsTable(database)
+	columns, err := getColumnCompression(ctx, db, database, "events")
+	if err != nil {
+		return fmt.Errorf("failed to read events columns: %w", err)
+	}
+	types := make(map[string]string, len(columns))
+	for _, column := range columns {
+		types[column.Name] = column.Type
+	}
+
+	// Converting to LowCardinality rewrites the column, so it is only done once and never reverted automatically
+	if cfg.CampaignIDLowCardinality && types["campaign_id"] == "String" {
+		clickhouseLog.Infof("Converting %s.campaign_id to LowCardinality(String)", table)
+		if _, err := db.ExecContext(ctx, "ALTER TABLE ? MODIFY COLUMN campaign_id LowCardinality(String)", table); err != nil {
+			return fmt.Errorf("failed to convert campaign_id to LowCardinality: %w", err)
+		}
+	}
+
+	// Apply codecs in a stable order
+	names := make([]string, 0, len(cfg.ColumnCodecs))
+	for name := range cfg.ColumnCodecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		codec := cfg.ColumnCodecs[name]
+		if _, ok := types[name]; !ok {
+			return fmt.Errorf("cannot set codec on unknown column %q", name)
+		}
+		if !codecPattern.MatchString(codec) {
+			return fmt.Errorf("invalid codec %q for column %q", codec, name)
+		}
+		if _, err := db.ExecContext(ctx, "ALTER TABLE ? MODIFY COLUMN ? CODEC(?)", table, ch.Ident(name), ch.Safe(codec)); err != nil {
+			return fmt.Errorf("failed to set codec on column %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// GetColumnCompression returns the compression statistics of the events table columns
+func (c ClickHouseDB) GetColumnCompression(ctx context.Context) ([]ColumnCompression, error) {
+	return getColumnCompression(ctx, c.DB, "", "events")
+}
+
+// getColumnCompression reads the columns of a table in a database, in the connection's database if empty
+func getColumnCompression(ctx context.Context, db *ch.DB, database, table string) ([]ColumnCompression, error) {
+	query := db.NewSelect().
+		TableExpr("system.columns").
+		Column("name", "type", "compression_codec", "data_compressed_bytes", "data_uncompressed_bytes")
+	if database == "" {
+		query = query.Where("database = currentDatabase()")
+	} else {
+		query = query.Where("database = ?", database)
+	}
+
+	var columns []ColumnCompression
+	err := query.
+		Where("table = ?", table).
+		OrderExpr("position ASC").
+		Scan(ctx, &columns)
+	if err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
@@ -0,0 +1,250 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/telemetry"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dedupLog logs the messages of the Deduplicator
+var dedupLog = logging.Named("Dedup")
+
+// Stores of the processed events, see Deduplicator
+const (
+	DedupStoreRedis  = "redis"  // keys in Redis shared by all instances, expiring after CLICKHOUSE_REDIS_CACHE_DURATION_MS
+	DedupStoreMemory = "memory" // an LRU cache local to the instance, see MemoryDeduplicator
+)
+
+var (
+	dedupStoreErrorsTotal = telemetry.NewCounterVec("dedup_store_errors_total",
+		"Number of failed lookups and writes of the processed events by store, the next store of the chain answers lookups", "store")
+	dedupMemoryKeys = telemetry.NewGaugeVec("dedup_memory_keys",
+		"Number of processed events kept by the in-memory deduplication store")
+)
+
+// Deduplicator remembers the events that were stored, so that retried and replayed requests are not stored twice.
+// Events are identified by their unique key, see domain.EventRequest.GetUniqueKey.
+type Deduplicator interface {
+	IsEventProcessed(ctx context.Context, request domain.EventRequest) (bool, error)
+	// AreEventsProcessed maps the unique keys of the events to whether they were stored
+	AreEventsProcessed(ctx context.Context, requests []domain.EventRequest) (map[string]bool, error)
+	SetMultipleEventsProcessed(ctx context.Context, requests []domain.EventRequest) error
+}
+
+var (
+	_ Deduplicator = ClickHouseRedis{}
+	_ Deduplicator = &MemoryDeduplicator{}
+	_ Deduplicator = ChainDeduplicator{}
+)
+
+// NewDeduplicator returns the chain of the configured stores, the Redis client itself by default
+func NewDeduplicator(cfg *config.DedupConfig, redisClient ClickHouseRedis) (Deduplicator, error) {
+	var chain ChainDeduplicator
+	for _, name := range strings.Split(cfg.Stores, ",") {
+		name = strings.TrimSpace(name)
+		var store Deduplicator
+		switch name {
+		case DedupStoreRedis:
+			if redisClient.Client == nil {
+				return nil, fmt.Errorf("Redis client cannot be nil")
+			}
+			store = redisClient
+		case DedupStoreMemory:
+			if cfg.MemoryMaxKeys <= 0 {
+				return nil, fmt.Errorf("in-memory deduplication keys must be positive, got %d", cfg.MemoryMaxKeys)
+			}
+			store = NewMemoryDeduplicator(cfg.MemoryMaxKeys, redisClient.getExpirationDuration())
+		default:
+			return nil, fmt.Errorf("unknown deduplication store %q", name)
+		}
+		for _, link := range chain {
+			if link.name == name {
+				return nil, fmt.Errorf("deduplication store %q is chained twice", name)
+			}
+		}
+		chain = append(chain, chainLink{name: name, store: store})
+	}
+	if len(chain) == 1 {
+		return chain[0].store, nil
+	}
+	return chain, nil
+}
+
+// MemoryDeduplicator keeps the unique keys of the processed events in memory, the least recently used ones are
+// evicted beyond maxKeys. It is local to the instance: alone it suits single-instance deployments and tests, in front
+// of Redis it answers the lookups of recent events without a round trip and while Redis is unavailable.
+type MemoryDeduplicator struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // of *memoryDedupEntry, most recently used first
+	maxKeys int
+	ttl     time.Duration // 0 keeps keys until they are evicted
+}
+
+type memoryDedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+// NewMemoryDeduplicator creates an in-memory store of at most maxKeys events, each kept for ttl
+func NewMemoryDeduplicator(maxKeys int, ttl time.Duration) *MemoryDeduplicator {
+	return &MemoryDeduplicator{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		maxKeys: maxKeys,
+		ttl:     ttl,
+	}
+}
+
+// IsEventProcessed reports whether the event is kept and not expired
+func (m *MemoryDeduplicator) IsEventProcessed(_ context.Context, request domain.EventRequest) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lookup(request.GetUniqueKey(), time.Now()), nil
+}
+
+// AreEventsProcessed reports which events are kept and not expired
+func (m *MemoryDeduplicator) AreEventsProcessed(_ context.Context, requests []domain.EventRequest) (map[string]bool, error) {
+	now := time.Now()
+	processed := make(map[string]bool, len(requests))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, request := range requests {
+		key := request.GetUniqueKey()
+		processed[key] = m.lookup(key, now)
+	}
+	return processed, nil
+}
+
+// SetMultipleEventsProcessed keeps the events, evicting the least recently used ones beyond the maximum
+func (m *MemoryDeduplicator) SetMultipleEventsProcessed(_ context.Context, requests []domain.EventRequest) error {
+	var expires time.Time
+	if m.ttl > 0 {
+		expires = time.Now().Add(m.ttl)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, request := range requests {
+		key := request.GetUniqueKey()
+		if element, ok := m.entries[key]; ok {
+			element.Value.(*memoryDedupEntry).expires = expires
+			m.order.MoveToFront(element)
+			continue
+		}
+		m.entries[key] = m.order.PushFront(&memoryDedupEntry{key: key, expires: expires})
+		for m.order.Len() > m.maxKeys {
+			m.remove(m.order.Back())
+		}
+	}
+	dedupMemoryKeys.WithLabelValues().Set(float64(m.order.Len()))
+	return nil
+}
+
+// lookup reports whether a key is kept, removing it if it expired, the lock must be held
+func (m *MemoryDeduplicator) lookup(key string, now time.Time) bool {
+	element, ok := m.entries[key]
+	if !ok {
+		return false
+	}
+	if entry := element.Value.(*memoryDedupEntry); !entry.expires.IsZero() && now.After(entry.expires) {
+		m.remove(element)
+		return false
+	}
+	m.order.MoveToFront(element)
+	return true
+}
+
+func (m *MemoryDeduplicator) remove(element *list.Element) {
+	m.order.Remove(element)
+	delete(m.entries, element.Value.(*memoryDedupEntry).key)
+}
+
+// ChainDeduplicator looks the events up in its stores in order, e.g. memory then Redis: an event is processed if any
+// store kept it, and the stores before it that missed it keep it from then on. A failing store is skipped, the chain
+// only fails when all of its stores do. Processed events are kept by every store.
+type ChainDeduplicator []chainLink
+
+type chainLink struct {
+	name  string
+	store Deduplicator
+}
+
+// IsEventProcessed looks the event up in the stores until one kept it
+func (c ChainDeduplicator) IsEventProcessed(ctx context.Context, request domain.EventRequest) (bool, error) {
+	processed, err := c.AreEventsProcessed(ctx, []domain.EventRequest{request})
+	if err != nil {
+		return false, err
+	}
+	return processed[request.GetUniqueKey()], nil
+}
+
+// AreEventsProcessed looks the events up in the stores, each store is asked for the events the previous ones missed
+func (c ChainDeduplicator) AreEventsProcessed(ctx context.Context, requests []domain.EventRequest) (map[string]bool, error) {
+	processed := make(map[string]bool, len(requests))
+	pending := requests
+	var missed []chainLink // stores that answered without keeping the pending events
+	var errs []error
+	for _, link := range c {
+		if len(pending) == 0 {
+			break
+		}
+		found, err := link.store.AreEventsProcessed(ctx, pending)
+		if err != nil {
+			dedupStoreErrorsTotal.WithLabelValues(link.name).Inc()
+			errs = append(errs, fmt.Errorf("%s: %w", link.name, err))
+			continue
+		}
+		var hits, misses []domain.EventRequest
+		for _, request := range pending {
+			if key := request.GetUniqueKey(); found[key] {
+				processed[key] = true
+				hits = append(hits, request)
+			} else {
+				misses = append(misses, request)
+			}
+		}
+		// The stores before this one keep the events it found, their next lookups are answered earlier
+		for _, previous := range missed {
+			if len(hits) > 0 {
+				if err := previous.store.SetMultipleEventsProcessed(ctx, hits); err != nil {
+					dedupStoreErrorsTotal.WithLabelValues(previous.name).Inc()
+				}
+			}
+		}
+		missed = append(missed, link)
+		pending = misses
+	}
+	if len(missed) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	for _, request := range pending {
+		processed[request.GetUniqueKey()] = false
+	}
+	return processed, nil
+}
+
+// SetMultipleEventsProcessed keeps the events in every store, it fails if none kept them
+func (c ChainDeduplicator) SetMultipleEventsProcessed(ctx context.Context, requests []domain.EventRequest) error {
+	var errs []error
+	for _, link := range c {
+		if err := link.store.SetMultipleEventsProcessed(ctx, requests); err != nil {
+			dedupStoreErrorsTotal.WithLabelValues(link.name).Inc()
+			errs = append(errs, fmt.Errorf("%s: %w", link.name, err))
+		}
+	}
+	if len(errs) == len(c) {
+		return errors.Join(errs...)
+	}
+	if len(errs) > 0 {
+		dedupLog.Warnf("Processed events are not kept by every deduplication store: %v", errors.Join(errs...))
+	}
+	return nil
+}
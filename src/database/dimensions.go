@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"kucukaslan/clickhouse/config"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// dimensionInsertBatch is the number of rows inserted per statement when a dimension is uploaded
+const dimensionInsertBatch = 1000
+
+// dimensionDictionary returns the dictionary serving the attributes of a dimension
+func dimensionDictionary(name string) ch.Safe {
+	return ch.Safe("dim_" + name)
+}
+
+// dimensionTable returns the table holding the uploaded rows of a dimension
+func dimensionTable(name string) ch.Safe {
+	return ch.Safe("dim_" + name + "_data")
+}
+
+// SaveDimension replaces the rows of a dimension table and the dictionary loading it in the connection's database.
+// The first column is the key, the others are attributes, all stored as strings.
+// Dimension and column names must be validated with validations.ValidateDimensionName.
+func (c ClickHouseDB) SaveDimension(ctx context.Context, cfg *config.ClickHouseConfig, name string, columns []string, rows [][]string) error {
+	table := dimensionTable(name)
+	definitions := make([]string, len(columns))
+	for i, column := range columns {
+		definitions[i] = column + " String"
+	}
+	if _, err := c.DB.ExecContext(ctx, "CREATE OR REPLACE TABLE ? (?) ENGINE = MergeTree ORDER BY ?",
+		table, ch.Safe(strings.Join(definitions, ", ")), ch.Ident(columns[0])); err != nil {
+		return fmt.Errorf("failed to create dimension table: %w", err)
+	}
+
+	for start := 0; start < len(rows); start += dimensionInsertBatch {
+		batch := rows[start:min(start+dimensionInsertBatch, len(rows))]
+		placeholders := make([]string, len(batch))
+		args := make([]any, 0, len(batch)+1)
+		args = append(args, table)
+		for i, row := range batch {
+			placeholders[i] = "(?)"
+			args = append(args, ch.In(row))
+		}
+		if _, err := c.DB.ExecContext(ctx, "INSERT INTO ? VALUES "+strings.Join(placeholders, ", "), args...); err != nil {
+			return fmt.Errorf("failed to insert dimension rows: %w", err)
+		}
+	}
+
+	// Attributes of unknown keys default to empty strings
+	for i := 1; i < len(definitions); i++ {
+		definitions[i] += " DEFAULT ''"
+	}
+	_, err := c.DB.ExecContext(ctx, `CREATE OR REPLACE DICTIONARY ? (?) PRIMARY KEY ?
+		SOURCE(CLICKHOUSE(TABLE ? DB ? USER ? PASSWORD ?))
+		LAYOUT(COMPLEX_KEY_HASHED()) LIFETIME(0)`,
+		dimensionDictionary(name), ch.Safe(strings.Join(definitions, ", ")), ch.Ident(columns[0]),
+		string(table), cfg.Database, cfg.User, cfg.Password)
+	if err != nil {
+		return fmt.Errorf("failed to create dimension dictionary: %w", err)
+	}
+	// Load the new rows now instead of on the first dictGet
+	if _, err := c.DB.ExecContext(ctx, "SYSTEM RELOAD DICTIONARY ?", dimensionDictionary(name)); err != nil {
+		return fmt.Errorf("failed to load dimension dictionary: %w", err)
+	}
+	return nil
+}
+
+// GetDimensionAttributes returns the attribute names of a dimension's dictionary, nil if there is no such dimension
+func (c ClickHouseDB) GetDimensionAttributes(ctx context.Context, name string) ([]string, error) {
+	var dictionaries []struct {
+		Attributes []string `ch:"attributes"`
+	}
+	err := c.NewSelect().
+		ColumnExpr("attribute.names AS attributes").
+		TableExpr("system.dictionaries").
+		Where("database = currentDatabase()").
+		Where("name = ?", string(dimensionDictionary(name))).
+		Scan(ctx, &dictionaries)
+	if err != nil {
+		return nil, err
+	}
+	if len(dictionaries) == 0 {
+		return nil, nil
+	}
+	return dictionaries[0].Attributes, nil
+}
+
+// SaveURLDimension creates or replaces the dictionary of a dimension loading its rows from a URL,
+// reloaded by ClickHouse every lifetime seconds (0 = only on demand). The first column is the key.
+// Dimension and column names must be validated with validations.ValidateDimensionName.
+func (c ClickHouseDB) SaveURLDimension(ctx context.Context, name, url, format string, columns []string, lifetime int) error {
+	definitions := make([]string, len(columns))
+	for i, column := range columns {
+		definitions[i] = column + " String"
+		if i > 0 {
+			definitions[i] += " DEFAULT ''"
+		}
+	}
+	_, err := c.DB.ExecContext(ctx, `CREATE OR REPLACE DICTIONARY ? (?) PRIMARY KEY ?
+		SOURCE(HTTP(URL ? FORMAT ?))
+		LAYOUT(COMPLEX_KEY_HASHED()) LIFETIME(?)`,
+		dimensionDictionary(name), ch.Safe(strings.Join(definitions, ", ")), ch.Ident(columns[0]),
+		url, format, lifetime)
+	if err != nil {
+		return fmt.Errorf("failed to create dimension dictionary: %w", err)
+	}
+	// Rows of an earlier upload are no longer read
+	if _, err := c.DB.ExecContext(ctx, "DROP TABLE IF EXISTS ?", dimensionTable(name)); err != nil {
+		return fmt.Errorf("failed to drop dimension table: %w", err)
+	}
+	return nil
+}
+
+// ReloadDimension reloads the dictionary of a dimension from its source
+func (c ClickHouseDB) ReloadDimension(ctx context.Context, name string) error {
+	if _, err := c.DB.ExecContext(ctx, "SYSTEM RELOAD DICTIONARY ?", dimensionDictionary(name)); err != nil {
+		return fmt.Errorf("failed to reload dimension dictionary: %w", err)
+	}
+	return nil
+}
+
+// DeleteDimension drops the dictionary of a dimension and its uploaded rows
+func (c ClickHouseDB) DeleteDimension(ctx context.Context, name string) error {
+	if _, err := c.DB.ExecContext(ctx, "DROP DICTIONARY IF EXISTS ?", dimensionDictionary(name)); err != nil {
+		return fmt.Errorf("failed to drop dimension dictionary: %w", err)
+	}
+	if _, err := c.DB.ExecContext(ctx, "DROP TABLE IF EXISTS ?", dimensionTable(name)); err != nil {
+		return fmt.Errorf("failed to drop dimension table: %w", err)
+	}
+	return nil
+}
+
+// DimensionStatus is the state of a dimension's dictionary as reported by system.dictionaries
+type DimensionStatus struct {
+	Name           string    `ch:"dimension"`
+	Status         string    `ch:"state"`
+	Source         string    `ch:"source"`
+	Keys           []string  `ch:"keys"`
+	Attributes     []string  `ch:"attributes"`
+	ElementCount   uint64    `ch:"element_count"`
+	BytesAllocated uint64    `ch:"bytes_allocated"`
+	LifetimeMax    uint64    `ch:"lifetime_max"`
+	LastUpdate     time.Time `ch:"last_successful_update_time"`
+	LastException  string    `ch:"last_exception"`
+}
+
+// GetDimensionStatuses returns the dictionaries of the dimensions in the connection's database,
+// of the given dimension only if name is not empty
+func (c ClickHouseDB) GetDimensionStatuses(ctx context.Context, name string) ([]DimensionStatus, error) {
+	query := c.NewSelect().
+		// Aliases must not shadow the columns filtered on
+		ColumnExpr("substring(name, 5) AS dimension").
+		ColumnExpr("toString(status) AS state").
+		ColumnExpr("source").
+		ColumnExpr("key.names AS keys").
+		ColumnExpr("attribute.names AS attributes").
+		ColumnExpr("element_count").
+		ColumnExpr("bytes_allocated").
+		ColumnExpr("lifetime_max").
+		ColumnExpr("last_successful_update_time").
+		ColumnExpr("last_exception").
+		TableExpr("system.dictionaries").
+		Where("database = currentDatabase()")
+	if name != "" {
+		query = query.Where("name = ?", string(dimensionDictionary(name)))
+	} else {
+		query = query.Where("startsWith(name, 'dim_')")
+	}
+
+	var statuses []DimensionStatus
+	if err := query.OrderExpr("dimension ASC").Scan(ctx, &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
@@ -0,0 +1,656 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// embeddedSweepInterval is how often expired keys of the embedded store are removed, keys are also expired when read
+const embeddedSweepInterval = time.Minute
+
+// embeddedRedis serves the Redis commands of the service from memory, so that a single instance can run without a Redis server.
+// The Redis client connects to it through in-memory connections and speaks the Redis protocol as with a server,
+// so deduplication keys, counters, locks and the state of the admin endpoints keep working unchanged.
+// Only the commands the service sends are supported. The state is local to the instance and lost when it stops.
+type embeddedRedis struct {
+	mu      sync.Mutex
+	entries map[string]*embeddedEntry
+	done    chan struct{}
+}
+
+// embeddedEntry is the value of a key, a string, a hash or the length of a stream
+type embeddedEntry struct {
+	str       string
+	hash      map[string]string
+	stream    int64
+	isHash    bool
+	isStream  bool
+	expiresAt time.Time
+}
+
+// errEmbeddedWrongType is returned by commands run on a key holding another type of value
+var errEmbeddedWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// embeddedScripts are the Lua scripts of the service implemented natively, by SHA1, as the embedded store cannot run Lua
+var embeddedScripts = map[string]func(e *embeddedRedis, keys, args []string) any{
+	releaseLockScript.Hash(): func(e *embeddedRedis, keys, args []string) any {
+		if entry := e.get(keys[0]); entry != nil && !entry.isHash && !entry.isStream && entry.str == args[0] {
+			delete(e.entries, keys[0])
+			return int64(1)
+		}
+		return int64(0)
+	},
+	extendLockScript.Hash(): func(e *embeddedRedis, keys, args []string) any {
+		if entry := e.get(keys[0]); entry != nil && !entry.isHash && !entry.isStream && entry.str == args[0] {
+			ms, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return errors.New("ERR value is not an integer or out of range")
+			}
+			entry.expiresAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			return int64(1)
+		}
+		return int64(0)
+	},
+	replaceRewriteRuleScript.Hash(): func(e *embeddedRedis, keys, args []string) any {
+		entry := e.get(keys[0])
+		if entry == nil || !entry.isHash || entry.hash[args[0]] != args[1] {
+			return int64(-1)
+		}
+		entry.hash[args[0]] = args[2]
+		return int64(0)
+	},
+}
+
+func newEmbeddedRedis() *embeddedRedis {
+	e := &embeddedRedis{
+		entries: make(map[string]*embeddedEntry),
+		done:    make(chan struct{}),
+	}
+	go e.sweep()
+	return e
+}
+
+// dial returns a connection to the embedded store, served until the client closes it
+func (e *embeddedRedis) dial(_ context.Context, _, _ string) (net.Conn, error) {
+	client, server := net.Pipe()
+	go e.serve(server)
+	return client, nil
+}
+
+// close stops removing expired keys
+func (e *embeddedRedis) close() {
+	close(e.done)
+}
+
+// sweep removes expired keys periodically, so that deduplication keys that are never read again do not pile up
+func (e *embeddedRedis) sweep() {
+	ticker := time.NewTicker(embeddedSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case now := <-ticker.C:
+			e.mu.Lock()
+			for key, entry := range e.entries {
+				if !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt) {
+					delete(e.entries, key)
+				}
+			}
+			e.mu.Unlock()
+		}
+	}
+}
+
+// serve answers the commands of a connection. Replies are written by a separate goroutine, so that a client
+// writing a long pipeline is never blocked by replies it has not started to read yet.
+func (e *embeddedRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	replies := newEmbeddedReplyQueue(conn)
+	defer replies.close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readEmbeddedCommand(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+				replies.push(appendEmbeddedReply(nil, fmt.Errorf("ERR %v", err)))
+			}
+			return
+		}
+		replies.push(appendEmbeddedReply(nil, e.execute(args)))
+	}
+}
+
+// execute runs a command and returns its reply: nil, a string, []byte for a bulk string, an int64, an error, or a []any
+func (e *embeddedRedis) execute(args []string) any {
+	if len(args) == 0 {
+		return errors.New("ERR empty command")
+	}
+	name := strings.ToLower(args[0])
+	args = args[1:]
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch name {
+	case "ping":
+		return "PONG"
+	case "get":
+		if len(args) != 1 {
+			return errEmbeddedArgs(name)
+		}
+		entry, err := e.getString(args[0])
+		if entry == nil || err != nil {
+			return err
+		}
+		return []byte(entry.str)
+	case "set":
+		return e.set(args)
+	case "setex":
+		if len(args) != 3 {
+			return errEmbeddedArgs(name)
+		}
+		return e.set([]string{args[0], args[2], "ex", args[1]})
+	case "setnx":
+		if len(args) != 2 {
+			return errEmbeddedArgs(name)
+		}
+		if e.get(args[0]) != nil {
+			return int64(0)
+		}
+		e.entries[args[0]] = &embeddedEntry{str: args[1]}
+		return int64(1)
+	case "mget":
+		values := make([]any, len(args))
+		for i, key := range args {
+			if entry := e.get(key); entry != nil && !entry.isHash && !entry.isStream {
+				values[i] = []byte(entry.str)
+			}
+		}
+		return values
+	case "del":
+		var deleted int64
+		for _, key := range args {
+			if e.get(key) != nil {
+				delete(e.entries, key)
+				deleted++
+			}
+		}
+		return deleted
+	case "incr":
+		if len(args) != 1 {
+			return errEmbeddedArgs(name)
+		}
+		entry, err := e.getString(args[0])
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			entry = &embeddedEntry{str: "0"}
+			e.entries[args[0]] = entry
+		}
+		n, err := strconv.ParseInt(entry.str, 10, 64)
+		if err != nil {
+			return errors.New("ERR value is not an integer or out of range")
+		}
+		entry.str = strconv.FormatInt(n+1, 10)
+		return n + 1
+	case "expire":
+		if len(args) != 2 {
+			return errEmbeddedArgs(name)
+		}
+		seconds, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errors.New("ERR value is not an integer or out of range")
+		}
+		entry := e.get(args[0])
+		if entry == nil {
+			return int64(0)
+		}
+		entry.expiresAt = time.Now().Add(time.Duration(seconds) * time.Second)
+		return int64(1)
+	case "hget":
+		if len(args) != 2 {
+			return errEmbeddedArgs(name)
+		}
+		entry, err := e.getHash(args[0], false)
+		if entry == nil || err != nil {
+			return err
+		}
+		if value, ok := entry.hash[args[1]]; ok {
+			return []byte(value)
+		}
+		return nil
+	case "hset":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return errEmbeddedArgs(name)
+		}
+		entry, err := e.getHash(args[0], true)
+		if err != nil {
+			return err
+		}
+		var added int64
+		for i := 1; i < len(args); i += 2 {
+			if _, ok := entry.hash[args[i]]; !ok {
+				added++
+			}
+			entry.hash[args[i]] = args[i+1]
+		}
+		return added
+	case "hdel":
+		if len(args) < 2 {
+			return errEmbeddedArgs(name)
+		}
+		entry, err := e.getHash(args[0], false)
+		if entry == nil || err != nil {
+			return int64(0)
+		}
+		var deleted int64
+		for _, field := range args[1:] {
+			if _, ok := entry.hash[field]; ok {
+				delete(entry.hash, field)
+				deleted++
+			}
+		}
+		if len(entry.hash) == 0 {
+			delete(e.entries, args[0])
+		}
+		return deleted
+	case "hgetall", "hvals":
+		if len(args) != 1 {
+			return errEmbeddedArgs(name)
+		}
+		entry, err := e.getHash(args[0], false)
+		if err != nil {
+			return err
+		}
+		values := []any{}
+		if entry != nil {
+			for field, value := range entry.hash {
+				if name == "hgetall" {
+					values = append(values, []byte(field))
+				}
+				values = append(values, []byte(value))
+			}
+		}
+		return values
+	case "hlen":
+		if len(args) != 1 {
+			return errEmbeddedArgs(name)
+		}
+		entry, err := e.getHash(args[0], false)
+		if err != nil || entry == nil {
+			return int64(0)
+		}
+		return int64(len(entry.hash))
+	case "scan":
+		return e.scan(args)
+	case "hmget":
+		if len(args) < 2 {
+			return errEmbeddedArgs(name)
+		}
+		entry, err := e.getHash(args[0], false)
+		if err != nil {
+			return err
+		}
+		values := make([]any, len(args)-1)
+		if entry != nil {
+			for i, field := range args[1:] {
+				if value, ok := entry.hash[field]; ok {
+					values[i] = []byte(value)
+				}
+			}
+		}
+		return values
+	case "hincrby":
+		if len(args) != 3 {
+			return errEmbeddedArgs(name)
+		}
+		increment, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errors.New("ERR value is not an integer or out of range")
+		}
+		entry, err := e.getHash(args[0], true)
+		if err != nil {
+			return err
+		}
+		n, _ := strconv.ParseInt(entry.hash[args[1]], 10, 64)
+		entry.hash[args[1]] = strconv.FormatInt(n+increment, 10)
+		return n + increment
+	case "publish":
+		// Nobody can subscribe to the embedded store, published messages are lost
+		return int64(0)
+	case "pubsub":
+		if len(args) < 1 || strings.ToLower(args[0]) != "numsub" {
+			return errors.New("ERR unsupported PUBSUB subcommand")
+		}
+		values := make([]any, 0, 2*(len(args)-1))
+		for _, channel := range args[1:] {
+			values = append(values, []byte(channel), int64(0))
+		}
+		return values
+	case "xadd":
+		return e.xadd(args)
+	case "xlen":
+		if len(args) != 1 {
+			return errEmbeddedArgs(name)
+		}
+		if entry := e.get(args[0]); entry != nil && entry.isStream {
+			return entry.stream
+		}
+		return int64(0)
+	case "xinfo":
+		if len(args) != 2 || strings.ToLower(args[0]) != "groups" {
+			return errors.New("ERR unsupported XINFO subcommand")
+		}
+		if entry := e.get(args[1]); entry == nil || !entry.isStream {
+			return errors.New("ERR no such key")
+		}
+		// Consumer groups cannot be created on the embedded store
+		return []any{}
+	case "evalsha", "eval":
+		if len(args) < 2 {
+			return errEmbeddedArgs(name)
+		}
+		sha := args[0]
+		if name == "eval" {
+			sha = scriptHash(args[0])
+		}
+		script, ok := embeddedScripts[sha]
+		if !ok {
+			return errors.New("NOSCRIPT No matching script")
+		}
+		numKeys, err := strconv.Atoi(args[1])
+		if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+			return errors.New("ERR Number of keys can't be greater than number of args")
+		}
+		return script(e, args[2:2+numKeys], args[2+numKeys:])
+	default:
+		// HELLO and CLIENT are answered with an error, the client then keeps the RESP2 protocol
+		return fmt.Errorf("ERR unknown command '%s'", name)
+	}
+}
+
+// set runs SET key value [EX seconds | PX milliseconds] [NX]
+func (e *embeddedRedis) set(args []string) any {
+	if len(args) < 2 {
+		return errEmbeddedArgs("set")
+	}
+	entry := &embeddedEntry{str: args[1]}
+	nx := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			nx = true
+		case "ex", "px":
+			if i+1 >= len(args) {
+				return errors.New("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return errors.New("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if strings.ToLower(args[i]) == "px" {
+				unit = time.Millisecond
+			}
+			entry.expiresAt = time.Now().Add(time.Duration(n) * unit)
+			i++
+		default:
+			return errors.New("ERR syntax error")
+		}
+	}
+	if nx && e.get(args[0]) != nil {
+		return nil
+	}
+	e.entries[args[0]] = entry
+	return "OK"
+}
+
+// scan runs SCAN cursor [MATCH pattern] [COUNT n], returning all matching keys at once with the cursor 0
+func (e *embeddedRedis) scan(args []string) any {
+	if len(args) < 1 {
+		return errEmbeddedArgs("scan")
+	}
+	pattern := "*"
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errors.New("ERR syntax error")
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+		default:
+			return errors.New("ERR syntax error")
+		}
+	}
+	keys := []any{}
+	for key := range e.entries {
+		if matched, _ := path.Match(pattern, key); matched && e.get(key) != nil {
+			keys = append(keys, []byte(key))
+		}
+	}
+	return []any{[]byte("0"), keys}
+}
+
+// xadd runs XADD key [MAXLEN [~] n] * field value..., only the length of the stream is kept as nobody can read it
+func (e *embeddedRedis) xadd(args []string) any {
+	if len(args) < 2 {
+		return errEmbeddedArgs("xadd")
+	}
+	key := args[0]
+	var maxLen int64
+	rest := args[1:]
+	if len(rest) > 0 && strings.ToLower(rest[0]) == "maxlen" {
+		rest = rest[1:]
+		if len(rest) > 0 && (rest[0] == "~" || rest[0] == "=") {
+			rest = rest[1:]
+		}
+		if len(rest) == 0 {
+			return errors.New("ERR syntax error")
+		}
+		n, err := strconv.ParseInt(rest[0], 10, 64)
+		if err != nil || n < 0 {
+			return errors.New("ERR syntax error")
+		}
+		maxLen = n
+		rest = rest[1:]
+	}
+	if len(rest) < 3 || rest[0] != "*" || len(rest)%2 != 1 {
+		return errors.New("ERR syntax error")
+	}
+
+	entry := e.get(key)
+	if entry == nil {
+		entry = &embeddedEntry{isStream: true}
+		e.entries[key] = entry
+	}
+	if !entry.isStream {
+		return errEmbeddedWrongType
+	}
+	entry.stream++
+	if maxLen > 0 && entry.stream > maxLen {
+		entry.stream = maxLen
+	}
+	return []byte(strconv.FormatInt(time.Now().UnixMilli(), 10) + "-" + strconv.FormatInt(entry.stream, 10))
+}
+
+// get returns the entry of a key, nil if it does not exist or expired. The lock must be held.
+func (e *embeddedRedis) get(key string) *embeddedEntry {
+	entry, ok := e.entries[key]
+	if !ok {
+		return nil
+	}
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		delete(e.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// getString returns the string of a key, nil if it does not exist
+func (e *embeddedRedis) getString(key string) (*embeddedEntry, error) {
+	entry := e.get(key)
+	if entry != nil && (entry.isHash || entry.isStream) {
+		return nil, errEmbeddedWrongType
+	}
+	return entry, nil
+}
+
+// getHash returns the hash of a key, created if it does not exist and create is set
+func (e *embeddedRedis) getHash(key string, create bool) (*embeddedEntry, error) {
+	entry := e.get(key)
+	if entry == nil {
+		if !create {
+			return nil, nil
+		}
+		entry = &embeddedEntry{isHash: true, hash: make(map[string]string)}
+		e.entries[key] = entry
+	}
+	if !entry.isHash {
+		return nil, errEmbeddedWrongType
+	}
+	return entry, nil
+}
+
+// scriptHash returns the SHA1 a script is run by with EVALSHA
+func scriptHash(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+func errEmbeddedArgs(name string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", name)
+}
+
+// readEmbeddedCommand reads a command sent as an array of bulk strings, the only form the Redis client sends
+func readEmbeddedCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := readEmbeddedLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, fmt.Errorf("expected an array, got %q", line)
+	}
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid array length %q", line)
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		line, err := readEmbeddedLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected a bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk string length %q", line)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+// readEmbeddedLine reads a line of the protocol without its CRLF
+func readEmbeddedLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r")), nil
+}
+
+// appendEmbeddedReply encodes a reply of execute in the RESP2 protocol
+func appendEmbeddedReply(buf []byte, reply any) []byte {
+	switch value := reply.(type) {
+	case nil:
+		return append(buf, "$-1\r\n"...)
+	case string:
+		return append(append(append(buf, '+'), value...), "\r\n"...)
+	case error:
+		return append(append(append(buf, '-'), value.Error()...), "\r\n"...)
+	case int64:
+		return append(strconv.AppendInt(append(buf, ':'), value, 10), "\r\n"...)
+	case []byte:
+		buf = strconv.AppendInt(append(buf, '$'), int64(len(value)), 10)
+		return append(append(append(buf, "\r\n"...), value...), "\r\n"...)
+	case []any:
+		buf = append(strconv.AppendInt(append(buf, '*'), int64(len(value)), 10), "\r\n"...)
+		for _, item := range value {
+			buf = appendEmbeddedReply(buf, item)
+		}
+		return buf
+	default:
+		return appendEmbeddedReply(buf, fmt.Errorf("ERR unexpected reply %T", reply))
+	}
+}
+
+// embeddedReplyQueue writes the replies of a connection in order without blocking the command loop
+type embeddedReplyQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []byte
+	closed  bool
+}
+
+func newEmbeddedReplyQueue(conn net.Conn) *embeddedReplyQueue {
+	q := &embeddedReplyQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	go q.write(conn)
+	return q
+}
+
+func (q *embeddedReplyQueue) push(reply []byte) {
+	q.mu.Lock()
+	q.pending = append(q.pending, reply...)
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+func (q *embeddedReplyQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+func (q *embeddedReplyQueue) write(conn net.Conn) {
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.pending) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		data := q.pending
+		q.pending = nil
+		q.mu.Unlock()
+
+		if _, err := conn.Write(data); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"kucukaslan/clickhouse/domain"
+	"time"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// ExportedEvent is a row of an export, the columns of an event analysts query, the tenant and the lineage of its insert left out
+type ExportedEvent struct {
+	EventName  string
+	Channel    string
+	CampaignID string
+	UserID     string
+	Timestamp  time.Time
+	Tags       []string
+	Metadata   string
+	AbuseScore uint8
+	Source     string
+	IngestedAt time.Time
+}
+
+// EventRows iterates over the events of an export as ClickHouse sends them, one block at a time
+type EventRows struct {
+	rows  *ch.Rows
+	event ExportedEvent
+	err   error
+}
+
+// QueryEventsFrom reads the events of the events table of a database matching the filters of a metrics request, in time
+// order, of the connection's database if empty. Grouping and enrichment are ignored. The rows must be closed.
+func (c ClickHouseDB) QueryEventsFrom(ctx context.Context, database string, request domain.MetricRequest) (*EventRows, error) {
+	query := c.NewSelect().
+		TableExpr("? FINAL", eventsTable(database))
+	for _, column := range domain.ExportedColumns {
+		query = query.Column(column)
+	}
+	query = eventFilters(query, database, request).OrderExpr("timestamp ASC")
+
+	rows, err := c.QueryContext(ctx, query.String())
+	if err != nil {
+		return nil, err
+	}
+	return &EventRows{rows: rows}, nil
+}
+
+// Next reads the next event, false at the end of the result or on an error
+func (r *EventRows) Next() bool {
+	if r.err != nil || !r.rows.Next() {
+		return false
+	}
+	r.event = ExportedEvent{}
+	e := &r.event
+	if err := r.rows.Scan(&e.EventName, &e.Channel, &e.CampaignID, &e.UserID, &e.Timestamp, &e.Tags, &e.Metadata,
+		&e.AbuseScore, &e.Source, &e.IngestedAt); err != nil {
+		r.err = err
+		return false
+	}
+	return true
+}
+
+// Event returns the event read by Next
+func (r *EventRows) Event() ExportedEvent {
+	return r.event
+}
+
+// Err returns the error that stopped Next, nil at the end of the result
+func (r *EventRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.rows.Err()
+}
+
+// Close reads the rest of the result and releases the connection
+func (r *EventRows) Close() error {
+	return r.rows.Close()
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"kucukaslan/clickhouse/config"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// tagsIndexName is the data skipping index on the tags column
+const tagsIndexName = "tags_bloom"
+
+// ApplyTagsIndex adds a bloom filter index on the tags column of the events table of a database,
+// of the connection's database if empty, so that has(tags, x) filters skip the granules without the tag.
+// ClickHouse maintains the index on insert. When it is added to an existing table, existing parts are
+// indexed by a background mutation. Disabling the setting does not drop the index.
+func ApplyTagsIndex(ctx context.Context, db *ch.DB, cfg *config.ClickHouseConfig, database string) error {
+	if !cfg.TagsIndex {
+		return nil
+	}
+	table := eventsTable(database)
+
+	query := db.NewSelect().
+		TableExpr("system.data_skipping_indices").
+		ColumnExpr("count()")
+	if database == "" {
+		query = query.Where("database = currentDatabase()")
+	} else {
+		query = query.Where("database = ?", database)
+	}
+	var existing uint64
+	if err := query.Where("table = 'events'").Where("name = ?", tagsIndexName).Scan(ctx, &existing); err != nil {
+		return fmt.Errorf("failed to read events indexes: %w", err)
+	}
+	if existing > 0 {
+		return nil
+	}
+
+	clickhouseLog.Infof("Adding %s index on %s.tags", tagsIndexName, table)
+	_, err := db.ExecContext(ctx, "ALTER TABLE ? ADD INDEX IF NOT EXISTS ? tags TYPE bloom_filter(?) GRANULARITY ?",
+		table, ch.Ident(tagsIndexName), cfg.TagsIndexFalsePositiveRate, cfg.TagsIndexGranularity)
+	if err != nil {
+		return fmt.Errorf("failed to add tags index: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, "ALTER TABLE ? MATERIALIZE INDEX ?", table, ch.Ident(tagsIndexName)); err != nil {
+		return fmt.Errorf("failed to materialize tags index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// IntervalBounds are the upper bounds in seconds of the inter-event interval histogram buckets,
+// a last bucket holds the intervals above the last bound
+var IntervalBounds = []float64{1, 10, 60, 600, 3600, 6 * 3600, 86400, 7 * 86400}
+
+// IntervalFilter selects the consecutive events of each user whose intervals are measured
+type IntervalFilter struct {
+	// FromEvent and ToEvent restrict the intervals to an event directly followed by another, ignoring other events,
+	// both empty for any consecutive events
+	FromEvent string
+	ToEvent   string
+	From      time.Time
+	To        time.Time // exclusive
+	// Tenant restricts the events to those of a tenant, in the connection's database shared by the tenants without their own
+	Tenant string
+}
+
+// IntervalHistogram is the distribution of the time between consecutive events of the same user
+type IntervalHistogram struct {
+	Total     uint64    `ch:"total"`
+	Quantiles []float64 `ch:"quantiles"` // p50, p90 and p99 in seconds
+	Counts    []uint64  `ch:"counts"`    // per bucket of IntervalBounds
+}
+
+// GetIntervalHistogramFrom computes the intervals between consecutive events of each user in the events table of a database,
+// of the connection's database if empty, with the lagInFrame window function over the events of each user ordered by time
+func (c ClickHouseDB) GetIntervalHistogramFrom(ctx context.Context, database string, filter IntervalFilter) (*IntervalHistogram, error) {
+	const window = "OVER (PARTITION BY user_id ORDER BY timestamp ROWS BETWEEN 1 PRECEDING AND CURRENT ROW)"
+
+	events := c.NewSelect().
+		ColumnExpr("event_name").
+		ColumnExpr("lagInFrame(event_name) "+window+" AS previous_event").
+		ColumnExpr("dateDiff('millisecond', lagInFrame(timestamp) "+window+", timestamp) / 1000 AS interval").
+		ColumnExpr("row_number() OVER (PARTITION BY user_id ORDER BY timestamp) AS position").
+		TableExpr("? FINAL", eventsTable(database)).
+		Where("timestamp >= ?", filter.From).
+		Where("timestamp < ?", filter.To)
+	// Tenants without a database of their own share the connection's events table
+	if database == "" {
+		events = events.Where("tenant_id = ?", filter.Tenant)
+	}
+	if filter.FromEvent != "" {
+		events = events.Where("event_name IN (?)", ch.In([]string{filter.FromEvent, filter.ToEvent}))
+	}
+
+	counts := make([]string, 0, len(IntervalBounds)+1)
+	lower := "0"
+	for _, bound := range IntervalBounds {
+		counts = append(counts, fmt.Sprintf("countIf(interval >= %s AND interval < %v)", lower, bound))
+		lower = fmt.Sprint(bound)
+	}
+	counts = append(counts, fmt.Sprintf("countIf(interval >= %s)", lower))
+
+	query := c.NewSelect().
+		ColumnExpr("count() AS total").
+		ColumnExpr("quantiles(0.5, 0.9, 0.99)(interval) AS quantiles").
+		ColumnExpr("[?] AS counts", ch.Safe(strings.Join(counts, ", "))).
+		TableExpr("(?)", events).
+		// The first event of a user has no predecessor
+		Where("position > 1")
+	if filter.FromEvent != "" {
+		query = query.Where("previous_event = ?", filter.FromEvent).Where("event_name = ?", filter.ToEvent)
+	}
+
+	var histogram IntervalHistogram
+	if err := query.Scan(ctx, &histogram); err != nil {
+		return nil, err
+	}
+	return &histogram, nil
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"kucukaslan/clickhouse/buildinfo"
+)
+
+// InstanceName identifies this instance in the instance column of the events it writes,
+// the pod name on Kubernetes and the hostname otherwise
+var InstanceName = instanceName()
+
+func instanceName() string {
+	info := buildinfo.GetInfo()
+	if info.Pod != nil {
+		return info.Pod.Name
+	}
+	return info.Hostname
+}
+
+// NewBatchID returns a random identifier of an insert, stored in the batch_id column of its events
+func NewBatchID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Stamp sets the batch and instance of all rows, so that inserting the model again after a failure keeps its batch_id
+func (c *EventColumnar) Stamp(batchID string) {
+	c.batchID = batchID
+	for i := range c.BatchID {
+		c.BatchID[i] = batchID
+		c.Instance[i] = InstanceName
+	}
+}
+
+// Batch returns the batch_id the rows are stamped with, empty if they are not stamped yet
+func (c *EventColumnar) Batch() string {
+	return c.batchID
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// Modes of the ClickHouse connection
+const (
+	ClickHouseModeServer = "server" // a ClickHouse server at CLICKHOUSE_HOST
+	ClickHouseModeLocal  = "local"  // a server started from the clickhouse binary for development, see localClickHouse
+)
+
+const (
+	// localStartTimeout bounds how long the local server takes to accept connections
+	localStartTimeout = 30 * time.Second
+	// localStopTimeout bounds how long the local server takes to stop before it is killed
+	localStopTimeout = 10 * time.Second
+)
+
+// localServer is the ClickHouse server started in the local mode, nil otherwise
+var localServer *localClickHouse
+
+// localClickHouse is a ClickHouse server run as a child process of the service, so that contributors can run
+// the service and its integration flows without containers or external services, only the clickhouse binary.
+// The same single binary provides clickhouse-local; it is started as a server because the service speaks
+// the native protocol. The server listens on 127.0.0.1 only and its default user has no password.
+type localClickHouse struct {
+	cmd    *exec.Cmd
+	dir    string
+	remove bool // whether dir is a temporary directory removed when the server stops
+	exited chan struct{}
+	err    error // why the process exited, set before exited is closed
+}
+
+// localConfig is the server configuration of the local mode, with the data directory and TCP port filled in
+const localConfig = `<clickhouse>
+    <logger>
+        <level>warning</level>
+        <console>1</console>
+    </logger>
+    <listen_host>127.0.0.1</listen_host>
+    <tcp_port>%s</tcp_port>
+    <path>%s/data/</path>
+    <tmp_path>%s/tmp/</tmp_path>
+    <user_files_path>%s/user_files/</user_files_path>
+    <users_config>users.xml</users_config>
+    <default_profile>default</default_profile>
+    <default_database>default</default_database>
+</clickhouse>
+`
+
+// localUsers lets the default user connect from the local host without a password
+const localUsers = `<clickhouse>
+    <profiles>
+        <default></default>
+    </profiles>
+    <users>
+        <default>
+            <password></password>
+            <networks>
+                <ip>::1</ip>
+                <ip>127.0.0.1</ip>
+            </networks>
+            <profile>default</profile>
+            <quota>default</quota>
+            <access_management>1</access_management>
+        </default>
+    </users>
+    <quotas>
+        <default></default>
+    </quotas>
+</clickhouse>
+`
+
+// startLocalClickHouse starts the local server, waits until it accepts connections, creates the configured database
+// and returns the DSN to connect to it
+func startLocalClickHouse(cfg *config.ClickHouseConfig) (*localClickHouse, string, error) {
+	binary, err := exec.LookPath(cfg.LocalBinary)
+	if err != nil {
+		return nil, "", fmt.Errorf("clickhouse binary not found, install it or set CLICKHOUSE_LOCAL_BINARY: %w", err)
+	}
+
+	local := &localClickHouse{dir: cfg.LocalPath, exited: make(chan struct{})}
+	if local.dir == "" {
+		if local.dir, err = os.MkdirTemp("", "clickhouse-local-"); err != nil {
+			return nil, "", err
+		}
+		local.remove = true
+	} else if local.dir, err = filepath.Abs(local.dir); err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(local.dir, 0o700); err != nil {
+		return nil, "", err
+	}
+	configPath := filepath.Join(local.dir, "config.xml")
+	if err := os.WriteFile(configPath, fmt.Appendf(nil, localConfig, cfg.Port, local.dir, local.dir, local.dir), 0o600); err != nil {
+		return nil, "", err
+	}
+	if err := os.WriteFile(filepath.Join(local.dir, "users.xml"), []byte(localUsers), 0o600); err != nil {
+		return nil, "", err
+	}
+
+	local.cmd = exec.Command(binary, "server", "--config-file="+configPath)
+	local.cmd.Dir = local.dir
+	local.cmd.Stdout = os.Stdout
+	local.cmd.Stderr = os.Stderr
+	if err := local.cmd.Start(); err != nil {
+		local.cleanup()
+		return nil, "", fmt.Errorf("failed to start %s: %w", binary, err)
+	}
+	go func() {
+		local.err = local.cmd.Wait()
+		close(local.exited)
+	}()
+	clickhouseLog.Infof("Started a local server (pid %d) on 127.0.0.1:%s with data in %s", local.cmd.Process.Pid, cfg.Port, local.dir)
+
+	if err := local.waitReady(cfg); err != nil {
+		local.stop()
+		return nil, "", err
+	}
+	return local, "clickhouse://default@127.0.0.1:" + cfg.Port + "/" + cfg.Database, nil
+}
+
+// waitReady waits until the server accepts connections and creates the configured database on it
+func (l *localClickHouse) waitReady(cfg *config.ClickHouseConfig) error {
+	db := ch.Connect(
+		ch.WithDSN("clickhouse://default@127.0.0.1:"+cfg.Port+"/default"),
+		ch.WithInsecure(true),
+	)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), localStartTimeout)
+	defer cancel()
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		err := db.Ping(ctx)
+		if err == nil {
+			break
+		}
+		select {
+		case <-l.exited:
+			return fmt.Errorf("local ClickHouse server exited: %v", l.err)
+		case <-ctx.Done():
+			return fmt.Errorf("local ClickHouse server did not accept connections within %s: %w", localStartTimeout, err)
+		case <-ticker.C:
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS ?", ch.Ident(cfg.Database)); err != nil {
+		return fmt.Errorf("failed to create database %s: %w", cfg.Database, err)
+	}
+	return nil
+}
+
+// stop terminates the server, killing it if it does not stop in time, and removes its temporary directory
+func (l *localClickHouse) stop() {
+	if err := l.cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		clickhouseLog.Errorf("Failed to stop the local server: %v", err)
+	}
+	select {
+	case <-l.exited:
+	case <-time.After(localStopTimeout):
+		clickhouseLog.Warnf("Local server did not stop within %s, killing it", localStopTimeout)
+		_ = l.cmd.Process.Kill()
+		<-l.exited
+	}
+	l.cleanup()
+	clickhouseLog.Infof("Local server stopped")
+}
+
+func (l *localClickHouse) cleanup() {
+	if l.remove {
+		if err := os.RemoveAll(l.dir); err != nil {
+			clickhouseLog.Errorf("Failed to remove %s: %v", l.dir, err)
+		}
+	}
+}
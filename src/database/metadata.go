@@ -0,0 +1,374 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" database/sql driver of the postgres metadata store
+)
+
+// metadataLog logs the messages of the MetadataStore
+var metadataLog = logging.Named("MetadataStore")
+
+// Stores of the admin entities, see MetadataStore
+const (
+	MetadataStoreRedis    = "redis"    // hashes and keys in Redis, next to the caches and counters
+	MetadataStorePostgres = "postgres" // tables in a PostgreSQL database
+	MetadataStoreSQLite   = "sqlite"   // tables in a SQLite file, with the pure-Go driver of modernc.org/sqlite linked into main
+)
+
+// MetadataStore keeps the entities managed through the admin endpoints: API keys created at runtime, webhooks,
+// feature flag overrides, rewrite rules and event schemas. They are small, rarely written and must not be lost, unlike the caches
+// and counters kept in Redis, so they can be stored in a transactional database instead.
+type MetadataStore interface {
+	GetAPIKeys(ctx context.Context) ([]domain.APIKey, error)
+	SetAPIKey(ctx context.Context, key domain.APIKey) error
+	DeleteAPIKey(ctx context.Context, producer string) (bool, error)
+
+	SetWebhook(ctx context.Context, producer string, webhook domain.Webhook) error
+	GetWebhook(ctx context.Context, producer string) (*domain.Webhook, error)
+	DeleteWebhook(ctx context.Context, producer string) (bool, error)
+
+	GetFeatureFlagOverrides(ctx context.Context) (map[string]bool, error)
+	SetFeatureFlagOverride(ctx context.Context, name string, enabled bool) error
+	DeleteFeatureFlagOverride(ctx context.Context, name string) error
+
+	GetRewriteRules(ctx context.Context) ([]domain.RewriteRule, error)
+	SetRewriteRule(ctx context.Context, rule domain.RewriteRule) error
+	ReplaceRewriteRule(ctx context.Context, old, updated domain.RewriteRule) (bool, error)
+	DeleteRewriteRule(ctx context.Context, tenant, field, from string) (bool, error)
+
+	GetEventSchemas(ctx context.Context, eventName string) ([]domain.EventSchema, error)
+	SetEventSchema(ctx context.Context, schema domain.EventSchema) error
+	DeleteEventSchema(ctx context.Context, eventName string) (bool, error)
+}
+
+var (
+	_ MetadataStore = ClickHouseRedis{}
+	_ MetadataStore = &SQLMetadataStore{}
+)
+
+// sqlMetadataDB is the connection of the SQL metadata store, closed on shutdown
+var sqlMetadataDB *sql.DB
+
+// NewMetadataStore returns the metadata store of the configuration, the Redis client itself by default
+func NewMetadataStore(cfg *config.MetadataConfig, redisClient ClickHouseRedis) (MetadataStore, error) {
+	switch cfg.Store {
+	case MetadataStoreRedis:
+		if redisClient.Client == nil {
+			return nil, fmt.Errorf("Redis client cannot be nil")
+		}
+		return redisClient, nil
+	case MetadataStorePostgres:
+		return openSQLMetadataStore(cfg.Store, "pgx", cfg.DSN, true)
+	case MetadataStoreSQLite:
+		return openSQLMetadataStore(cfg.Store, "sqlite", cfg.DSN, false)
+	default:
+		return nil, fmt.Errorf("unknown metadata store %q", cfg.Store)
+	}
+}
+
+// CloseMetadataStore closes the connection of the SQL metadata store, if any
+func CloseMetadataStore() error {
+	if sqlMetadataDB != nil {
+		if err := sqlMetadataDB.Close(); err != nil {
+			return fmt.Errorf("failed to close metadata store: %w", err)
+		}
+		metadataLog.Infof("Connection closed")
+	}
+	return nil
+}
+
+// metadataTables are created on startup, entities are stored as the JSON the Redis store keeps as well
+var metadataTables = []string{
+	`CREATE TABLE IF NOT EXISTS metadata_api_keys (producer TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS metadata_webhooks (producer TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS metadata_feature_flags (name TEXT PRIMARY KEY, enabled INTEGER NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS metadata_event_schemas (event_name TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS metadata_rewrite_rules (tenant TEXT NOT NULL, field TEXT NOT NULL, value TEXT NOT NULL, data TEXT NOT NULL, PRIMARY KEY (tenant, field, value))`,
+}
+
+// SQLMetadataStore keeps the admin entities in tables of PostgreSQL or SQLite. Every change is a single statement,
+// so it is atomic, and a rule is only replaced if it is unchanged, like the Lua script of the Redis store.
+type SQLMetadataStore struct {
+	db *sql.DB
+	// numbered placeholders ($1) of PostgreSQL instead of the ? of SQLite
+	numbered bool
+}
+
+func openSQLMetadataStore(store, driver, dsn string, numbered bool) (*SQLMetadataStore, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("METADATA_DSN is required for the %s metadata store", store)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata store: %w", err)
+	}
+	if !numbered {
+		// SQLite allows a single writer, queuing the writes in the pool avoids "database is locked" errors
+		db.SetMaxOpenConns(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to metadata store: %w", err)
+	}
+	for _, table := range metadataTables {
+		if _, err := db.ExecContext(ctx, table); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create metadata tables: %w", err)
+		}
+	}
+
+	sqlMetadataDB = db
+	metadataLog.Infof("Connection established successfully (%s)", store)
+	return &SQLMetadataStore{db: db, numbered: numbered}, nil
+}
+
+// query rewrites the ? placeholders of a statement to the ones of the database
+func (s *SQLMetadataStore) query(statement string) string {
+	if !s.numbered {
+		return statement
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range statement {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// exec runs a statement and returns whether it changed a row
+func (s *SQLMetadataStore) exec(ctx context.Context, statement string, args ...any) (bool, error) {
+	result, err := s.db.ExecContext(ctx, s.query(statement), args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// GetAPIKeys returns the API keys created at runtime
+func (s *SQLMetadataStore) GetAPIKeys(ctx context.Context) ([]domain.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT producer, data FROM metadata_api_keys")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []domain.APIKey
+	for rows.Next() {
+		var producer, data string
+		if err := rows.Scan(&producer, &data); err != nil {
+			return nil, err
+		}
+		var key domain.APIKey
+		if err := json.Unmarshal([]byte(data), &key); err != nil {
+			metadataLog.Warnf("Skipping invalid API key %q: %v", producer, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// SetAPIKey creates or replaces an API key
+func (s *SQLMetadataStore) SetAPIKey(ctx context.Context, key domain.APIKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, "INSERT INTO metadata_api_keys (producer, data) VALUES (?, ?) ON CONFLICT (producer) DO UPDATE SET data = excluded.data",
+		key.Producer, string(data))
+	return err
+}
+
+// DeleteAPIKey removes an API key, returns false if there was none
+func (s *SQLMetadataStore) DeleteAPIKey(ctx context.Context, producer string) (bool, error) {
+	return s.exec(ctx, "DELETE FROM metadata_api_keys WHERE producer = ?", producer)
+}
+
+// SetWebhook registers the webhook of a producer, replacing any existing one
+func (s *SQLMetadataStore) SetWebhook(ctx context.Context, producer string, webhook domain.Webhook) error {
+	data, err := json.Marshal(webhook)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, "INSERT INTO metadata_webhooks (producer, data) VALUES (?, ?) ON CONFLICT (producer) DO UPDATE SET data = excluded.data",
+		producer, string(data))
+	return err
+}
+
+// GetWebhook returns the webhook of a producer, nil if there is none
+func (s *SQLMetadataStore) GetWebhook(ctx context.Context, producer string) (*domain.Webhook, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, s.query("SELECT data FROM metadata_webhooks WHERE producer = ?"), producer).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var webhook domain.Webhook
+	if err := json.Unmarshal([]byte(data), &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// DeleteWebhook removes the webhook of a producer, returns false if there was none
+func (s *SQLMetadataStore) DeleteWebhook(ctx context.Context, producer string) (bool, error) {
+	return s.exec(ctx, "DELETE FROM metadata_webhooks WHERE producer = ?", producer)
+}
+
+// GetFeatureFlagOverrides returns the runtime overrides of the feature flags
+func (s *SQLMetadataStore) GetFeatureFlagOverrides(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name, enabled FROM metadata_feature_flags")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		var enabled int
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return nil, err
+		}
+		overrides[name] = enabled == 1
+	}
+	return overrides, rows.Err()
+}
+
+// SetFeatureFlagOverride overrides a feature flag at runtime
+func (s *SQLMetadataStore) SetFeatureFlagOverride(ctx context.Context, name string, enabled bool) error {
+	value := 0
+	if enabled {
+		value = 1
+	}
+	_, err := s.exec(ctx, "INSERT INTO metadata_feature_flags (name, enabled) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET enabled = excluded.enabled",
+		name, value)
+	return err
+}
+
+// DeleteFeatureFlagOverride removes the runtime override of a feature flag
+func (s *SQLMetadataStore) DeleteFeatureFlagOverride(ctx context.Context, name string) error {
+	_, err := s.exec(ctx, "DELETE FROM metadata_feature_flags WHERE name = ?", name)
+	return err
+}
+
+// GetRewriteRules returns the rewrite rules of all tenants
+func (s *SQLMetadataStore) GetRewriteRules(ctx context.Context) ([]domain.RewriteRule, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT tenant, field, value, data FROM metadata_rewrite_rules")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []domain.RewriteRule
+	for rows.Next() {
+		var tenant, field, from, data string
+		if err := rows.Scan(&tenant, &field, &from, &data); err != nil {
+			return nil, err
+		}
+		var rule domain.RewriteRule
+		if err := json.Unmarshal([]byte(data), &rule); err != nil {
+			metadataLog.Warnf("Skipping invalid rewrite rule %q: %v", rewriteRuleField(tenant, field, from), err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// SetRewriteRule creates or replaces the rewrite rule of a tenant, field and value
+func (s *SQLMetadataStore) SetRewriteRule(ctx context.Context, rule domain.RewriteRule) error {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, "INSERT INTO metadata_rewrite_rules (tenant, field, value, data) VALUES (?, ?, ?, ?) "+
+		"ON CONFLICT (tenant, field, value) DO UPDATE SET data = excluded.data",
+		rule.Tenant, rule.Field, rule.From, string(data))
+	return err
+}
+
+// ReplaceRewriteRule replaces a rewrite rule with an updated version of it, returns false if the stored rule is not the old one anymore
+func (s *SQLMetadataStore) ReplaceRewriteRule(ctx context.Context, old, updated domain.RewriteRule) (bool, error) {
+	oldData, err := json.Marshal(old)
+	if err != nil {
+		return false, err
+	}
+	updatedData, err := json.Marshal(updated)
+	if err != nil {
+		return false, err
+	}
+	return s.exec(ctx, "UPDATE metadata_rewrite_rules SET data = ? WHERE tenant = ? AND field = ? AND value = ? AND data = ?",
+		string(updatedData), old.Tenant, old.Field, old.From, string(oldData))
+}
+
+// DeleteRewriteRule removes the rewrite rule of a tenant, field and value, returns false if there was none
+func (s *SQLMetadataStore) DeleteRewriteRule(ctx context.Context, tenant, field, from string) (bool, error) {
+	return s.exec(ctx, "DELETE FROM metadata_rewrite_rules WHERE tenant = ? AND field = ? AND value = ?", tenant, field, from)
+}
+
+// GetEventSchemas returns the event schemas, only the one of eventName if it is not empty
+func (s *SQLMetadataStore) GetEventSchemas(ctx context.Context, eventName string) ([]domain.EventSchema, error) {
+	statement, args := "SELECT event_name, data FROM metadata_event_schemas ORDER BY event_name", []any(nil)
+	if eventName != "" {
+		statement, args = "SELECT event_name, data FROM metadata_event_schemas WHERE event_name = ?", []any{eventName}
+	}
+	rows, err := s.db.QueryContext(ctx, s.query(statement), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schemas []domain.EventSchema
+	for rows.Next() {
+		var name, data string
+		if err := rows.Scan(&name, &data); err != nil {
+			return nil, err
+		}
+		var schema domain.EventSchema
+		if err := json.Unmarshal([]byte(data), &schema); err != nil {
+			metadataLog.Warnf("Skipping invalid event schema %q: %v", name, err)
+			continue
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, rows.Err()
+}
+
+// SetEventSchema creates or replaces the schema of an event name
+func (s *SQLMetadataStore) SetEventSchema(ctx context.Context, schema domain.EventSchema) error {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec(ctx, "INSERT INTO metadata_event_schemas (event_name, data) VALUES (?, ?) ON CONFLICT (event_name) DO UPDATE SET data = excluded.data",
+		schema.EventName, string(data))
+	return err
+}
+
+// DeleteEventSchema removes the schema of an event name, returns false if there was none
+func (s *SQLMetadataStore) DeleteEventSchema(ctx context.Context, eventName string) (bool, error) {
+	return s.exec(ctx, "DELETE FROM metadata_event_schemas WHERE event_name = ?", eventName)
+}
@@ -0,0 +1,416 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// SchemaMigration adds columns to the events table, with defaults so that earlier inserts and existing parts stay valid.
+// Adding a column only changes the table metadata, it neither rewrites parts nor blocks inserts and queries.
+// Changes that ALTER cannot make in place are made by a Rewrite of the table instead.
+type SchemaMigration struct {
+	Version int
+	Name    string
+	Columns []string // column definitions, e.g. "abuse_score UInt8 DEFAULT 0"
+	// OrderBy is the sorting key of the events table once the columns are appended to it, empty to leave it unchanged
+	OrderBy string
+	// Rewrite changes the events table of a database, of the connection's database if empty, nil if the migration only adds columns
+	Rewrite func(ctx context.Context, c ClickHouseDB, database string) error
+	// NeedsRewrite reports whether the events table still has to be rewritten, tables created by the running code do not
+	NeedsRewrite func(ctx context.Context, c ClickHouseDB, database string) (bool, error)
+}
+
+// ErrRewritePending is returned when the next migration rewrites the events table, which is only applied on request
+// while inserts are paused: rows inserted during the rewrite would be lost
+var ErrRewritePending = errors.New("a schema migration rewriting the events table is pending")
+
+// ErrTableEnginesDisabled is returned when the next migration changes the sorting key or rewrites the events table
+// while the migrations changing the layout of the table are not enabled
+var ErrTableEnginesDisabled = errors.New("a schema migration changing the layout of the events table is pending and table engine migrations are disabled")
+
+// MigrationOptions select the migrations MigrateSchema applies besides those only adding columns
+type MigrationOptions struct {
+	// TableEngines applies the migrations extending the sorting key or rewriting the table
+	TableEngines bool
+	// Rewrite applies the migrations rewriting the table, which must run while inserts are paused
+	Rewrite bool
+}
+
+// SchemaMigrations are the changes to the events table since it was first released, in version order.
+// Versions are never reused or reordered, new migrations are appended.
+var SchemaMigrations = []SchemaMigration{
+	{Version: 1, Name: "user_seq", Columns: []string{"user_seq UInt64 DEFAULT 0"}},
+	{Version: 2, Name: "abuse_score", Columns: []string{"abuse_score UInt8 DEFAULT 0"}},
+	{Version: 3, Name: "lineage", Columns: []string{
+		"api_key_id LowCardinality(String) DEFAULT ''",
+		"source LowCardinality(String) DEFAULT ''",
+		"instance LowCardinality(String) DEFAULT ''",
+		"batch_id String DEFAULT ''",
+	}},
+	// Rows of different tenants are never collapsed by the ReplacingMergeTree. Columns appended to the sorting key
+	// cannot have a default expression, the empty string of the type is the default tenant.
+	{Version: 4, Name: "tenant_id", Columns: []string{"tenant_id LowCardinality(String)"}, OrderBy: "timestamp, event_name, channel, user_id, tenant_id"},
+	{Version: 5, Name: "timestamp_ms", Rewrite: migrateTimestampMS, NeedsRewrite: timestampNeedsRewrite},
+}
+
+// LatestSchemaVersion is the schema version the running code writes
+func LatestSchemaVersion() int {
+	return SchemaMigrations[len(SchemaMigrations)-1].Version
+}
+
+// AppliedMigration is a migration recorded in the schema_migrations table
+type AppliedMigration struct {
+	Version   uint32    `ch:"version"`
+	Name      string    `ch:"name"`
+	AppliedAt time.Time `ch:"applied_at"`
+	AppliedBy string    `ch:"applied_by"`
+}
+
+// schemaMigrationsTable returns the schema_migrations table of a database, of the connection's database if empty.
+// Database names must be validated with DatabaseNamePattern.
+func schemaMigrationsTable(database string) ch.Safe {
+	if database == "" {
+		return "schema_migrations"
+	}
+	return ch.Safe(database + ".schema_migrations")
+}
+
+// initSchemaMigrations creates the table recording the migrations applied to the events table of a database
+func initSchemaMigrations(ctx context.Context, db *ch.DB, database string) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS ? (
+		version UInt32,
+		name String,
+		applied_at DateTime DEFAULT now(),
+		applied_by String
+	) ENGINE = ReplacingMergeTree ORDER BY version`, schemaMigrationsTable(database))
+	return err
+}
+
+// onCluster returns the ON CLUSTER clause of DDL statements, empty without a cluster.
+// Cluster names must be validated with DatabaseNamePattern.
+func onCluster(cluster string) ch.Safe {
+	if cluster == "" {
+		return ""
+	}
+	return ch.Safe("ON CLUSTER " + cluster)
+}
+
+// GetAppliedMigrations returns the migrations applied to the events table of a database,
+// of the connection's database if empty, in version order
+func (c ClickHouseDB) GetAppliedMigrations(ctx context.Context, database string) ([]AppliedMigration, error) {
+	var applied []AppliedMigration
+	err := c.NewSelect().
+		ColumnExpr("version, name, applied_at, applied_by").
+		TableExpr("? FINAL", schemaMigrationsTable(database)).
+		OrderExpr("version ASC").
+		Scan(ctx, &applied)
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// ApplySchemaMigration adds the columns of a migration to the events table of a database, of the connection's database if empty,
+// rewrites the table if the migration does, and records it as applied. With a cluster the columns are added on all its servers,
+// otherwise only replicas of a Replicated table receive the change through Keeper; rewrites only change the table of the
+// connected server. Adding existing columns is a no-op and rewrites check the table first, so a failed migration can be retried.
+func (c ClickHouseDB) ApplySchemaMigration(ctx context.Context, database, cluster string, migration SchemaMigration, appliedBy string) error {
+	table := eventsTable(database)
+	if migration.OrderBy != "" {
+		if err := c.addSortingKeyColumns(ctx, database, cluster, migration); err != nil {
+			return err
+		}
+	}
+	for _, column := range migration.Columns {
+		if _, err := c.DB.ExecContext(ctx, "ALTER TABLE ? ? ADD COLUMN IF NOT EXISTS ?", table, onCluster(cluster), ch.Safe(column)); err != nil {
+			return fmt.Errorf("failed to add column %q: %w", column, err)
+		}
+	}
+	if migration.Rewrite != nil {
+		if err := migration.Rewrite(ctx, c, database); err != nil {
+			return fmt.Errorf("failed to rewrite %s: %w", table, err)
+		}
+	}
+	_, err := c.DB.ExecContext(ctx, "INSERT INTO ? (version, name, applied_by) VALUES (?, ?, ?)",
+		schemaMigrationsTable(database), migration.Version, migration.Name, appliedBy)
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+	}
+	return nil
+}
+
+// addSortingKeyColumns adds the columns of a migration and appends them to the sorting key of the events table,
+// both in a single ALTER: ClickHouse only extends the sorting key of an existing table by columns added by the same statement.
+// Tables created with the sorting key are left as they are. Tables that already have one of the columns, e.g. added by hand,
+// keep their sorting key, which can no longer be extended without rewriting the table.
+func (c ClickHouseDB) addSortingKeyColumns(ctx context.Context, database, cluster string, migration SchemaMigration) error {
+	table := eventsTable(database)
+	sortingKey, err := c.sortingKey(ctx, database)
+	if err != nil {
+		return err
+	}
+	if sortingKey == migration.OrderBy {
+		return nil
+	}
+
+	names := make([]string, len(migration.Columns))
+	for i, column := range migration.Columns {
+		names[i] = strings.Fields(column)[0]
+	}
+	existing, err := getColumnCompression(ctx, c.DB, database, "events")
+	if err != nil {
+		return fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	for _, column := range existing {
+		if slices.Contains(names, column.Name) {
+			clickhouseLog.Warnf("%s already has column %s, its sorting key (%s) is not extended to (%s)", table, column.Name, sortingKey, migration.OrderBy)
+			return nil
+		}
+	}
+
+	alter := make([]string, 0, len(migration.Columns)+1)
+	for _, column := range migration.Columns {
+		alter = append(alter, "ADD COLUMN "+column)
+	}
+	alter = append(alter, "MODIFY ORDER BY ("+migration.OrderBy+")")
+	if _, err := c.DB.ExecContext(ctx, "ALTER TABLE ? ? ?", table, onCluster(cluster), ch.Safe(strings.Join(alter, ", "))); err != nil {
+		return fmt.Errorf("failed to extend sorting key to (%s): %w", migration.OrderBy, err)
+	}
+	return nil
+}
+
+// sortingKey reads the sorting key of the events table of a database, of the connection's database if empty
+func (c ClickHouseDB) sortingKey(ctx context.Context, database string) (string, error) {
+	query := c.NewSelect().
+		TableExpr("system.tables").
+		Column("sorting_key")
+	if database == "" {
+		query = query.Where("database = currentDatabase()")
+	} else {
+		query = query.Where("database = ?", database)
+	}
+	var sortingKey string
+	if err := query.Where("name = 'events'").Scan(ctx, &sortingKey); err != nil {
+		return "", fmt.Errorf("failed to read sorting key of %s: %w", eventsTable(database), err)
+	}
+	return sortingKey, nil
+}
+
+// changesLayout reports whether a migration would extend the sorting key or rewrite the events table of a database,
+// tables created by the running code already have the sorting key and column types of the latest migration
+func (c ClickHouseDB) changesLayout(ctx context.Context, database string, migration SchemaMigration) (bool, error) {
+	if migration.OrderBy != "" {
+		sortingKey, err := c.sortingKey(ctx, database)
+		if err != nil {
+			return false, err
+		}
+		if sortingKey != migration.OrderBy {
+			return true, nil
+		}
+	}
+	if migration.Rewrite != nil {
+		return migration.NeedsRewrite(ctx, c, database)
+	}
+	return false, nil
+}
+
+// MigrateSchema applies the migrations missing from the events table of a database, of the connection's database if empty,
+// in version order, and returns the ones it applied. It stops with ErrTableEnginesDisabled before a migration that would
+// change the layout of the table unless the options enable them, and with ErrRewritePending before one that would rewrite it
+// unless rewrites are enabled too. Migrations whose table already has the layout are recorded either way.
+func (c ClickHouseDB) MigrateSchema(ctx context.Context, database, cluster, appliedBy string, options MigrationOptions) ([]SchemaMigration, error) {
+	applied, err := c.GetAppliedMigrations(ctx, database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	done := make(map[int]bool, len(applied))
+	for _, migration := range applied {
+		done[int(migration.Version)] = true
+	}
+
+	var migrated []SchemaMigration
+	for _, migration := range SchemaMigrations {
+		if done[migration.Version] {
+			continue
+		}
+		if (migration.OrderBy != "" || migration.Rewrite != nil) && !options.TableEngines {
+			changes, err := c.changesLayout(ctx, database, migration)
+			if err != nil {
+				return migrated, fmt.Errorf("failed to check migration %d: %w", migration.Version, err)
+			}
+			if changes {
+				return migrated, fmt.Errorf("%w: %d (%s)", ErrTableEnginesDisabled, migration.Version, migration.Name)
+			}
+		}
+		if migration.Rewrite != nil && !options.Rewrite {
+			needed, err := migration.NeedsRewrite(ctx, c, database)
+			if err != nil {
+				return migrated, fmt.Errorf("failed to check migration %d: %w", migration.Version, err)
+			}
+			if needed {
+				return migrated, fmt.Errorf("%w: %d (%s)", ErrRewritePending, migration.Version, migration.Name)
+			}
+		}
+		if err := c.ApplySchemaMigration(ctx, database, cluster, migration, appliedBy); err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, migration)
+	}
+	return migrated, nil
+}
+
+// secondTimestampPattern matches the definition of the timestamp column in the CREATE statement of tables created before
+// timestamps had milliseconds, with or without a time zone
+var secondTimestampPattern = regexp.MustCompile("`timestamp` DateTime(\\('[^']*'\\))?([ ,])")
+
+// timestampColumn reads the type of the timestamp column, the engine and the CREATE statement of the events table of a database
+func timestampColumn(ctx context.Context, c ClickHouseDB, database string) (columnType, engine, create string, err error) {
+	query := c.NewSelect().
+		TableExpr("system.tables AS t").
+		Join("JOIN system.columns AS c ON c.database = t.database AND c.table = t.name").
+		ColumnExpr("c.type, t.engine, t.create_table_query")
+	if database == "" {
+		query = query.Where("t.database = currentDatabase()")
+	} else {
+		query = query.Where("t.database = ?", database)
+	}
+	if err := query.Where("t.name = 'events' AND c.name = 'timestamp'").Scan(ctx, &columnType, &engine, &create); err != nil {
+		return "", "", "", fmt.Errorf("failed to read timestamp column type: %w", err)
+	}
+	return columnType, engine, create, nil
+}
+
+// timestampNeedsRewrite reports whether the timestamp column of the events table of a database is still a DateTime
+func timestampNeedsRewrite(ctx context.Context, c ClickHouseDB, database string) (bool, error) {
+	columnType, _, _, err := timestampColumn(ctx, c, database)
+	return err == nil && !strings.HasPrefix(columnType, "DateTime64"), err
+}
+
+// rewriteCopyPasses bounds how often partitions that received inserts while they were copied are copied again
+const rewriteCopyPasses = 3
+
+// migrateTimestampMS converts the timestamp column of the events table of a database to DateTime64(3).
+// The column is in the partition key, toYYYYMMDD(timestamp), and in the sorting key, whose types ALTER cannot change,
+// so the table is rewritten: a copy with the new type is created from its CREATE statement, keeping its engine, settings,
+// codecs and indexes, filled one partition at a time and exchanged with the table, then the old table is dropped.
+// It must run while inserts are paused. Partitions that still received inserts while they were copied, seen by their
+// highest block number, are copied again, and the migration fails rather than exchanging the tables if they keep receiving them.
+// Replicated tables are refused, a copy created on one replica would not be shared with the others.
+func migrateTimestampMS(ctx context.Context, c ClickHouseDB, database string) error {
+	table := eventsTable(database)
+	copied := ch.Safe(string(table) + "_migrating")
+
+	columnType, engine, create, err := timestampColumn(ctx, c, database)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(columnType, "DateTime64") {
+		// Tables created with DateTime64(3), or rewritten by an earlier attempt that failed to drop the old table
+		_, err := c.DB.ExecContext(ctx, "DROP TABLE IF EXISTS ?", copied)
+		return err
+	}
+	if strings.HasPrefix(engine, "Replicated") {
+		return fmt.Errorf("timestamp of %s %s table is %s, convert it to DateTime64(3) on every replica", table, engine, columnType)
+	}
+
+	// The table name is everything before the column list
+	columns := strings.Index(create, " (")
+	if columns < 0 || !secondTimestampPattern.MatchString(create) {
+		return fmt.Errorf("unexpected definition of %s: %s", table, create)
+	}
+	create = "CREATE TABLE " + string(copied) + secondTimestampPattern.ReplaceAllString(create[columns:], "`timestamp` DateTime64(3)${2}")
+
+	// A copy left by an earlier attempt may be incomplete
+	if _, err := c.DB.ExecContext(ctx, "DROP TABLE IF EXISTS ?", copied); err != nil {
+		return fmt.Errorf("failed to drop %s: %w", copied, err)
+	}
+	if _, err := c.DB.ExecContext(ctx, create); err != nil {
+		return fmt.Errorf("failed to create %s: %w", copied, err)
+	}
+
+	// Highest block number of each copied partition, inserts allocate higher ones while merges keep them
+	copiedBlocks := make(map[string]int64)
+	for pass := 0; ; pass++ {
+		blocks, err := c.partitionBlocks(ctx, database)
+		if err != nil {
+			return fmt.Errorf("failed to read partitions to copy: %w", err)
+		}
+		var changed []string
+		for partition, block := range blocks {
+			if previous, ok := copiedBlocks[partition]; !ok || previous != block {
+				changed = append(changed, partition)
+			}
+		}
+		// Partitions dropped meanwhile, e.g. by a TTL, are dropped from the copy too
+		for partition := range copiedBlocks {
+			if _, ok := blocks[partition]; !ok {
+				changed = append(changed, partition)
+			}
+		}
+		if len(changed) == 0 {
+			break
+		}
+		if pass == rewriteCopyPasses {
+			return fmt.Errorf("partitions %v of %s still receive inserts, pause the inserts before rewriting it", changed, table)
+		}
+		slices.Sort(changed)
+		for _, partition := range changed {
+			if _, ok := copiedBlocks[partition]; ok {
+				if _, err := c.DB.ExecContext(ctx, "ALTER TABLE ? DROP PARTITION ID ?", copied, partition); err != nil {
+					return fmt.Errorf("failed to drop partition %s of %s: %w", partition, copied, err)
+				}
+			}
+			block, ok := blocks[partition]
+			if !ok {
+				delete(copiedBlocks, partition)
+				continue
+			}
+			if _, err := c.DB.ExecContext(ctx, "INSERT INTO ? SELECT * FROM ? WHERE _partition_id = ?", copied, table, partition); err != nil {
+				return fmt.Errorf("failed to copy partition %s: %w", partition, err)
+			}
+			copiedBlocks[partition] = block
+		}
+	}
+
+	if _, err := c.DB.ExecContext(ctx, "EXCHANGE TABLES ? AND ?", table, copied); err != nil {
+		return fmt.Errorf("failed to exchange %s with %s: %w", table, copied, err)
+	}
+	if _, err := c.DB.ExecContext(ctx, "DROP TABLE ?", copied); err != nil {
+		return fmt.Errorf("failed to drop the previous %s: %w", table, err)
+	}
+	return nil
+}
+
+// partitionBlock is the highest block number of the active parts of a partition
+type partitionBlock struct {
+	PartitionID string `ch:"partition_id"`
+	Block       int64  `ch:"block"`
+}
+
+// partitionBlocks returns the highest block number of each partition of the events table of a database
+func (c ClickHouseDB) partitionBlocks(ctx context.Context, database string) (map[string]int64, error) {
+	query := c.NewSelect().
+		TableExpr("system.parts").
+		ColumnExpr("partition_id, max(max_block_number) AS block")
+	if database == "" {
+		query = query.Where("database = currentDatabase()")
+	} else {
+		query = query.Where("database = ?", database)
+	}
+	var rows []partitionBlock
+	if err := query.Where("table = 'events' AND active").GroupExpr("partition_id").Scan(ctx, &rows); err != nil {
+		return nil, err
+	}
+	blocks := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		blocks[row.PartitionID] = row.Block
+	}
+	return blocks, nil
+}
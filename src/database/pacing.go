@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"kucukaslan/clickhouse/domain"
+)
+
+// CampaignSpend is the spend and the number of events of a campaign, with its budget
+type CampaignSpend struct {
+	CampaignID  string  `ch:"campaign_id"`
+	TotalEvents uint64  `ch:"total_events"`
+	Spend       float64 `ch:"spend"`
+	// Budget is the budget attribute of the campaign in the dimension, empty for campaigns missing from it
+	Budget string `ch:"budget"`
+}
+
+// GetCampaignSpendFrom sums the numeric metadata key of the spend and counts the events of each campaign in the events
+// table of a database, of the connection's database if empty, matching the filters of a metrics request. Budgets are
+// read from the dictionary of a dimension keyed by campaign_id. Names must be validated with validations.ValidateDimensionName.
+func (c ClickHouseDB) GetCampaignSpendFrom(ctx context.Context, database string, request domain.MetricRequest, spendKey, dimension, budget string) ([]CampaignSpend, error) {
+	query := c.NewSelect().
+		ColumnExpr("campaign_id").
+		ColumnExpr("count() AS total_events").
+		ColumnExpr("sum(JSONExtractFloat(metadata, ?)) AS spend", spendKey).
+		ColumnExpr("dictGetOrDefault(?, ?, tuple(campaign_id), '') AS budget", string(dimensionDictionary(dimension)), budget).
+		TableExpr("? FINAL", eventsTable(database))
+	query = eventFilters(query, database, request).
+		GroupExpr("campaign_id").
+		OrderExpr("campaign_id ASC")
+
+	var spends []CampaignSpend
+	if err := query.Scan(ctx, &spends); err != nil {
+		return nil, err
+	}
+	return spends, nil
+}
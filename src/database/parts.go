@@ -0,0 +1,33 @@
+package database
+
+import (
+	"context"
+)
+
+// PartitionParts is the number of active parts of a partition of an events table
+type PartitionParts struct {
+	Database  string `ch:"database"`
+	Partition string `ch:"partition"`
+	Parts     uint64 `ch:"parts"`
+}
+
+// GetMaxActiveParts returns the partition with the most active parts among the events tables of every database,
+// the ones of isolated tenants included. ClickHouse delays and then rejects inserts into a partition once its parts
+// exceed parts_to_delay_insert and parts_to_throw_insert. It returns a zero PartitionParts if the tables have no parts.
+func (c ClickHouseDB) GetMaxActiveParts(ctx context.Context) (PartitionParts, error) {
+	var parts []PartitionParts
+	err := c.NewSelect().
+		TableExpr("system.parts").
+		Column("database", "partition").
+		ColumnExpr("count() AS parts").
+		Where("table = 'events'").
+		Where("active").
+		GroupExpr("database, partition").
+		OrderExpr("parts DESC").
+		Limit(1).
+		Scan(ctx, &parts)
+	if err != nil || len(parts) == 0 {
+		return PartitionParts{}, err
+	}
+	return parts[0], nil
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// Statuses of quarantined events
+const (
+	QuarantineStatusQuarantined = "quarantined"
+	QuarantineStatusReprocessed = "reprocessed"
+)
+
+// QuarantinedEvent is an event that violated a data quality rule, kept for review instead of being stored.
+// Updates are inserted as new versions of the row and collapsed by ReplacingMergeTree on updated_at.
+type QuarantinedEvent struct {
+	ch.CHModel    `ch:"table:events_quarantine,partition:toYYYYMM(quarantined_at)"`
+	ID            string    `ch:"id"`
+	Tenant        string    `ch:"tenant,lc"`
+	Producer      string    `ch:"producer"`
+	EventName     string    `ch:"event_name,lc"`
+	Event         string    `ch:"event,type:String"` // the event request as JSON
+	Violations    []string  `ch:"violations,array"`
+	Status        string    `ch:"status,lc"`
+	QuarantinedAt time.Time `ch:"quarantined_at,type:DateTime64(3)"`
+	UpdatedAt     time.Time `ch:"updated_at,type:DateTime64(3)"`
+}
+
+// QuarantineFilter selects quarantined events, empty fields match everything
+type QuarantineFilter struct {
+	Tenant    string
+	EventName string
+	Status    string
+	Limit     int
+}
+
+// InitQuarantineTable creates the events_quarantine table if it doesn't exist.
+// Quarantined events of all tenants share the table of the connection's database.
+func InitQuarantineTable(ctx context.Context, db *ch.DB) error {
+	_, err := db.NewCreateTable().
+		Model((*QuarantinedEvent)(nil)).
+		Engine("ReplacingMergeTree(updated_at)").
+		Order("id").
+		IfNotExists().
+		Exec(ctx)
+	return err
+}
+
+// SaveQuarantined inserts quarantined events, or new versions of them
+func (c ClickHouseDB) SaveQuarantined(ctx context.Context, events []QuarantinedEvent) error {
+	if c.DB == nil {
+		return fmt.Errorf("database connection is nil")
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	if _, err := c.DB.NewInsert().Model(&events).Exec(ctx); err != nil {
+		return fmt.Errorf("failed to insert quarantined events: %w", err)
+	}
+	return nil
+}
+
+// GetQuarantined returns the latest version of the quarantined events matching the filter, newest first
+func (c ClickHouseDB) GetQuarantined(ctx context.Context, filter QuarantineFilter) ([]QuarantinedEvent, error) {
+	query := c.NewSelect().
+		Model((*QuarantinedEvent)(nil)).
+		Final().
+		Where("tenant = ?", filter.Tenant)
+	if filter.EventName != "" {
+		query = query.Where("event_name = ?", filter.EventName)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	var events []QuarantinedEvent
+	err := query.
+		OrderExpr("quarantined_at DESC").
+		Limit(filter.Limit).
+		Scan(ctx, &events)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetQuarantinedByIDs returns the latest version of the quarantined events of a tenant with the given ids
+func (c ClickHouseDB) GetQuarantinedByIDs(ctx context.Context, tenant string, ids []string) ([]QuarantinedEvent, error) {
+	var events []QuarantinedEvent
+	err := c.NewSelect().
+		Model((*QuarantinedEvent)(nil)).
+		Final().
+		Where("tenant = ?", tenant).
+		Where("id IN (?)", ch.In(ids)).
+		Scan(ctx, &events)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// RawEvent is the JSON of an event as it was received, kept for a few hours to replay it after fixing a mapping bug.
+// Events sent again replace their earlier payload, collapsed by ReplacingMergeTree on received_at.
+type RawEvent struct {
+	ch.CHModel `ch:"table:events_raw"`
+	EventID    string    `ch:"event_id"`
+	Tenant     string    `ch:"tenant,lc"`
+	Producer   string    `ch:"producer"`
+	ReceivedAt time.Time `ch:"received_at,type:DateTime64(3)"`
+	Payload    string    `ch:"payload,type:String"`
+}
+
+// InitRawEventsTable creates the events_raw table if it doesn't exist and sets the TTL of its rows.
+// Raw payloads of all tenants share the table of the connection's database. Partitions are hourly,
+// so that expired rows are dropped with whole parts instead of being rewritten.
+func InitRawEventsTable(ctx context.Context, db *ch.DB, ttlHours int) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS events_raw (
+		event_id String,
+		tenant LowCardinality(String),
+		producer String,
+		received_at DateTime64(3),
+		payload String CODEC(ZSTD(3))
+	) ENGINE = ReplacingMergeTree(received_at)
+	PARTITION BY toStartOfHour(received_at)
+	ORDER BY (tenant, event_id)
+	TTL toDateTime(received_at) + INTERVAL ? HOUR
+	SETTINGS ttl_only_drop_parts = 1`, ttlHours)
+	if err != nil {
+		return err
+	}
+
+	// The TTL of an existing table is only changed if it differs, modifying it rewrites the expired rows
+	var engine string
+	err = db.NewSelect().
+		ColumnExpr("engine_full").
+		TableExpr("system.tables").
+		Where("database = currentDatabase()").
+		Where("name = 'events_raw'").
+		Scan(ctx, &engine)
+	if err != nil {
+		return fmt.Errorf("failed to read events_raw engine: %w", err)
+	}
+	if !strings.Contains(engine, fmt.Sprintf("toIntervalHour(%d)", ttlHours)) {
+		if _, err := db.ExecContext(ctx, "ALTER TABLE events_raw MODIFY TTL toDateTime(received_at) + INTERVAL ? HOUR", ttlHours); err != nil {
+			return fmt.Errorf("failed to change events_raw TTL: %w", err)
+		}
+	}
+	return nil
+}
+
+// SaveRawEvents inserts the raw payloads of events
+func (c ClickHouseDB) SaveRawEvents(ctx context.Context, events []RawEvent) error {
+	if c.DB == nil {
+		return fmt.Errorf("database connection is nil")
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	if _, err := c.DB.NewInsert().Model(&events).Exec(ctx); err != nil {
+		return fmt.Errorf("failed to insert raw events: %w", err)
+	}
+	return nil
+}
+
+// GetRawEvents returns the latest raw payloads of a tenant's events with the given ids
+func (c ClickHouseDB) GetRawEvents(ctx context.Context, tenant string, ids []string) ([]RawEvent, error) {
+	var events []RawEvent
+	err := c.NewSelect().
+		Model((*RawEvent)(nil)).
+		Final().
+		Where("tenant = ?", tenant).
+		Where("event_id IN (?)", ch.In(ids)).
+		Scan(ctx, &events)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
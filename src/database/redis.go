@@ -2,18 +2,34 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"kucukaslan/clickhouse/config"
 	"kucukaslan/clickhouse/domain"
-	"log"
+	"kucukaslan/clickhouse/logging"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// redisLog logs the messages of the Redis
+var redisLog = logging.Named("Redis")
+
 var redisClient *redis.Client
 
+// embeddedStore serves redisClient in the embedded mode, nil with a Redis server
+var embeddedStore *embeddedRedis
+
+// Modes of the Redis connection
+const (
+	RedisModeServer   = "server"   // a Redis server shared by all instances
+	RedisModeEmbedded = "embedded" // an in-process store local to the instance, see embeddedRedis
+)
+
 type ClickHouseRedis struct {
 	*redis.Client
 	expirationMilliseconds int64
@@ -21,6 +37,9 @@ type ClickHouseRedis struct {
 
 const RedisKeyPrefix = "clickhouse_event:"
 
+// UserSequenceKeyPrefix prefixes the per-user sequence counters, they never expire
+const UserSequenceKeyPrefix = "clickhouse_user_seq:"
+
 func (r ClickHouseRedis) getExpirationDuration() (durationMilliseconds time.Duration) {
 	if r.expirationMilliseconds <= 0 {
 		return 0
@@ -77,7 +96,585 @@ func (r ClickHouseRedis) AreEventsProcessed(ctx context.Context, requests []doma
 	return processedMap, nil
 }
 
-// InitRedis initializes the Redis client connection
+// CheckpointKeyPrefix prefixes the last acknowledged checkpoint token of each client stream
+const CheckpointKeyPrefix = "clickhouse_checkpoint:"
+
+// checkpointExpiration is how long a stream checkpoint is kept after its last update
+const checkpointExpiration = 7 * 24 * time.Hour
+
+// SetStreamCheckpoint stores the last acknowledged checkpoint token of a stream
+func (r ClickHouseRedis) SetStreamCheckpoint(ctx context.Context, streamID, token string) error {
+	return r.SetEx(ctx, CheckpointKeyPrefix+streamID, token, checkpointExpiration).Err()
+}
+
+// GetStreamCheckpoint returns the last acknowledged checkpoint token of a stream, empty if there is none
+func (r ClickHouseRedis) GetStreamCheckpoint(ctx context.Context, streamID string) (string, error) {
+	token, err := r.Get(ctx, CheckpointKeyPrefix+streamID).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return token, err
+}
+
+// WebhookKeyPrefix prefixes the webhook registered for each producer, they never expire
+const WebhookKeyPrefix = "clickhouse_webhook:"
+
+// SetWebhook registers the webhook of a producer, replacing any existing one
+func (r ClickHouseRedis) SetWebhook(ctx context.Context, producer string, webhook domain.Webhook) error {
+	data, err := json.Marshal(webhook)
+	if err != nil {
+		return err
+	}
+	return r.Set(ctx, WebhookKeyPrefix+producer, data, 0).Err()
+}
+
+// GetWebhook returns the webhook of a producer, nil if there is none
+func (r ClickHouseRedis) GetWebhook(ctx context.Context, producer string) (*domain.Webhook, error) {
+	data, err := r.Get(ctx, WebhookKeyPrefix+producer).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var webhook domain.Webhook
+	if err := json.Unmarshal(data, &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// DeleteWebhook removes the webhook of a producer, returns false if there was none
+func (r ClickHouseRedis) DeleteWebhook(ctx context.Context, producer string) (bool, error) {
+	deleted, err := r.Del(ctx, WebhookKeyPrefix+producer).Result()
+	return deleted > 0, err
+}
+
+// FeatureFlagsKey is the hash holding the runtime overrides of the feature flags, shared by all instances
+const FeatureFlagsKey = "clickhouse_feature_flags"
+
+// GetFeatureFlagOverrides returns the runtime overrides of the feature flags
+func (r ClickHouseRedis) GetFeatureFlagOverrides(ctx context.Context) (map[string]bool, error) {
+	values, err := r.HGetAll(ctx, FeatureFlagsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]bool, len(values))
+	for name, value := range values {
+		overrides[name] = value == "1"
+	}
+	return overrides, nil
+}
+
+// SetFeatureFlagOverride overrides a feature flag at runtime
+func (r ClickHouseRedis) SetFeatureFlagOverride(ctx context.Context, name string, enabled bool) error {
+	value := "0"
+	if enabled {
+		value = "1"
+	}
+	return r.HSet(ctx, FeatureFlagsKey, name, value).Err()
+}
+
+// DeleteFeatureFlagOverride removes the runtime override of a feature flag
+func (r ClickHouseRedis) DeleteFeatureFlagOverride(ctx context.Context, name string) error {
+	return r.HDel(ctx, FeatureFlagsKey, name).Err()
+}
+
+// EventSchemasVersionKey is incremented whenever an event schema changes, so that the instances reload their cached schemas
+const EventSchemasVersionKey = "clickhouse_event_schemas_version"
+
+// IncrementEventSchemasVersion invalidates the cached event schemas of all instances
+func (r ClickHouseRedis) IncrementEventSchemasVersion(ctx context.Context) (int64, error) {
+	return r.Incr(ctx, EventSchemasVersionKey).Result()
+}
+
+// GetEventSchemasVersion returns the version of the event schemas, 0 if they never changed
+func (r ClickHouseRedis) GetEventSchemasVersion(ctx context.Context) (int64, error) {
+	version, err := r.Get(ctx, EventSchemasVersionKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return version, err
+}
+
+// EventSchemasKey is the hash holding the event schemas of the metadata store, by event name
+const EventSchemasKey = "clickhouse_event_schemas"
+
+// GetEventSchemas returns the event schemas, only the one of eventName if it is not empty
+func (r ClickHouseRedis) GetEventSchemas(ctx context.Context, eventName string) ([]domain.EventSchema, error) {
+	values := map[string]string{}
+	if eventName == "" {
+		var err error
+		if values, err = r.HGetAll(ctx, EventSchemasKey).Result(); err != nil {
+			return nil, err
+		}
+	} else {
+		value, err := r.HGet(ctx, EventSchemasKey, eventName).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[eventName] = value
+	}
+
+	schemas := make([]domain.EventSchema, 0, len(values))
+	for name, value := range values {
+		var schema domain.EventSchema
+		if err := json.Unmarshal([]byte(value), &schema); err != nil {
+			redisLog.Warnf("Skipping invalid event schema %q: %v", name, err)
+			continue
+		}
+		schemas = append(schemas, schema)
+	}
+	slices.SortFunc(schemas, func(a, b domain.EventSchema) int { return strings.Compare(a.EventName, b.EventName) })
+	return schemas, nil
+}
+
+// SetEventSchema creates or replaces the schema of an event name
+func (r ClickHouseRedis) SetEventSchema(ctx context.Context, schema domain.EventSchema) error {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return err
+	}
+	return r.HSet(ctx, EventSchemasKey, schema.EventName, data).Err()
+}
+
+// DeleteEventSchema removes the schema of an event name, returns false if there was none
+func (r ClickHouseRedis) DeleteEventSchema(ctx context.Context, eventName string) (bool, error) {
+	deleted, err := r.HDel(ctx, EventSchemasKey, eventName).Result()
+	return deleted > 0, err
+}
+
+// RewriteRulesKey is the hash holding the rewrite rules of all tenants, by tenant, field and value
+const RewriteRulesKey = "clickhouse_rewrite_rules"
+
+// rewriteRuleField is the hash field of a rule, tenant ids and fields cannot contain the separator
+func rewriteRuleField(tenant, field, from string) string {
+	return tenant + "|" + field + "|" + from
+}
+
+// GetRewriteRules returns the rewrite rules of all tenants
+func (r ClickHouseRedis) GetRewriteRules(ctx context.Context) ([]domain.RewriteRule, error) {
+	values, err := r.HGetAll(ctx, RewriteRulesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]domain.RewriteRule, 0, len(values))
+	for key, value := range values {
+		var rule domain.RewriteRule
+		if err := json.Unmarshal([]byte(value), &rule); err != nil {
+			redisLog.Warnf("Skipping invalid rewrite rule %q: %v", key, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// SetRewriteRule creates or replaces the rewrite rule of a tenant, field and value
+func (r ClickHouseRedis) SetRewriteRule(ctx context.Context, rule domain.RewriteRule) error {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	return r.HSet(ctx, RewriteRulesKey, rewriteRuleField(rule.Tenant, rule.Field, rule.From), data).Err()
+}
+
+// replaceRewriteRuleScript replaces a rule only if it is unchanged, it may have been deleted or replaced meanwhile
+var replaceRewriteRuleScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	return redis.call("HSET", KEYS[1], ARGV[1], ARGV[3])
+end
+return -1`)
+
+// ReplaceRewriteRule replaces a rewrite rule with an updated version of it, returns false if the stored rule is not the old one anymore
+func (r ClickHouseRedis) ReplaceRewriteRule(ctx context.Context, old, updated domain.RewriteRule) (bool, error) {
+	oldData, err := json.Marshal(old)
+	if err != nil {
+		return false, err
+	}
+	updatedData, err := json.Marshal(updated)
+	if err != nil {
+		return false, err
+	}
+	result, err := replaceRewriteRuleScript.Run(ctx, r.Client, []string{RewriteRulesKey},
+		rewriteRuleField(old.Tenant, old.Field, old.From), oldData, updatedData).Int()
+	return result >= 0, err
+}
+
+// DeleteRewriteRule removes the rewrite rule of a tenant, field and value, returns false if there was none
+func (r ClickHouseRedis) DeleteRewriteRule(ctx context.Context, tenant, field, from string) (bool, error) {
+	deleted, err := r.HDel(ctx, RewriteRulesKey, rewriteRuleField(tenant, field, from)).Result()
+	return deleted > 0, err
+}
+
+// DeadLetterKeyPrefix prefixes the hash of the dead-lettered events of each tenant, by event id
+const DeadLetterKeyPrefix = "clickhouse_dead_letter:"
+
+// SinkDeadLetterKeyPrefix prefixes the hashes of the events an optional sink failed to write, <sink>:<tenant>
+const SinkDeadLetterKeyPrefix = "clickhouse_sink_dead_letter:"
+
+// deadLetterKey returns the hash of the dead-lettered events of a tenant, of ClickHouse if the sink is empty
+func deadLetterKey(sink, tenant string) string {
+	if sink == "" {
+		return DeadLetterKeyPrefix + tenant
+	}
+	return SinkDeadLetterKeyPrefix + sink + ":" + tenant
+}
+
+// SaveDeadLetters stores the dead-lettered events of a sink, empty for ClickHouse, replacing earlier versions of the same events
+func (r ClickHouseRedis) SaveDeadLetters(ctx context.Context, sink string, letters []domain.DeadLetter) error {
+	pipe := r.Pipeline()
+	for _, letter := range letters {
+		data, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+		pipe.HSet(ctx, deadLetterKey(sink, letter.Tenant), letter.ID, data)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetDeadLetters returns the dead-lettered events of a sink and tenant with the given ids, all of them if ids is empty
+func (r ClickHouseRedis) GetDeadLetters(ctx context.Context, sink, tenant string, ids []string) ([]domain.DeadLetter, error) {
+	var values []string
+	if len(ids) == 0 {
+		all, err := r.HVals(ctx, deadLetterKey(sink, tenant)).Result()
+		if err != nil {
+			return nil, err
+		}
+		values = all
+	} else {
+		found, err := r.HMGet(ctx, deadLetterKey(sink, tenant), ids...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range found {
+			if str, ok := value.(string); ok {
+				values = append(values, str)
+			}
+		}
+	}
+
+	letters := make([]domain.DeadLetter, 0, len(values))
+	for _, value := range values {
+		var letter domain.DeadLetter
+		if err := json.Unmarshal([]byte(value), &letter); err != nil {
+			redisLog.Warnf("Skipping invalid dead-lettered event of tenant %q: %v", tenant, err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// CountDeadLetters returns the number of dead-lettered events of a sink, empty for ClickHouse, across all tenants
+func (r ClickHouseRedis) CountDeadLetters(ctx context.Context, sink string) (int64, error) {
+	var keys []string
+	iter := r.Scan(ctx, 0, deadLetterKey(sink, "")+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return 0, err
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	pipe := r.Pipeline()
+	lengths := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		lengths[i] = pipe.HLen(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, length := range lengths {
+		count += length.Val()
+	}
+	return count, nil
+}
+
+// DeleteDeadLetters removes dead-lettered events of a sink and tenant
+func (r ClickHouseRedis) DeleteDeadLetters(ctx context.Context, sink, tenant string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.HDel(ctx, deadLetterKey(sink, tenant), ids...).Err()
+}
+
+// TenantUsageKeyPrefix prefixes the stored event and byte counters of each tenant and month
+const TenantUsageKeyPrefix = "clickhouse_tenant_usage:"
+
+// tenantUsageExpiration keeps monthly counters a little longer than a month
+const tenantUsageExpiration = 40 * 24 * time.Hour
+
+// IncrementTenantUsage adds stored events and bytes to the monthly counters of a tenant
+func (r ClickHouseRedis) IncrementTenantUsage(ctx context.Context, tenant, month string, events, bytes int64) error {
+	key := TenantUsageKeyPrefix + tenant + ":" + month
+	pipe := r.Pipeline()
+	pipe.HIncrBy(ctx, key, "events", events)
+	pipe.HIncrBy(ctx, key, "bytes", bytes)
+	pipe.Expire(ctx, key, tenantUsageExpiration)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetTenantUsage returns the stored events and bytes of a tenant in a month
+func (r ClickHouseRedis) GetTenantUsage(ctx context.Context, tenant, month string) (events, bytes int64, err error) {
+	values, err := r.HMGet(ctx, TenantUsageKeyPrefix+tenant+":"+month, "events", "bytes").Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	parse := func(value any) int64 {
+		str, _ := value.(string)
+		n, _ := strconv.ParseInt(str, 10, 64)
+		return n
+	}
+	return parse(values[0]), parse(values[1]), nil
+}
+
+// APIKeysKey is the hash holding the API keys created at runtime, by producer id
+const APIKeysKey = "clickhouse_api_keys"
+
+// GetAPIKeys returns the API keys created at runtime
+func (r ClickHouseRedis) GetAPIKeys(ctx context.Context) ([]domain.APIKey, error) {
+	values, err := r.HGetAll(ctx, APIKeysKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]domain.APIKey, 0, len(values))
+	for producer, value := range values {
+		var key domain.APIKey
+		if err := json.Unmarshal([]byte(value), &key); err != nil {
+			redisLog.Warnf("Skipping invalid API key %q: %v", producer, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SetAPIKey creates or replaces an API key
+func (r ClickHouseRedis) SetAPIKey(ctx context.Context, key domain.APIKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return r.HSet(ctx, APIKeysKey, key.Producer, data).Err()
+}
+
+// DeleteAPIKey removes an API key, returns false if there was none
+func (r ClickHouseRedis) DeleteAPIKey(ctx context.Context, producer string) (bool, error) {
+	deleted, err := r.HDel(ctx, APIKeysKey, producer).Result()
+	return deleted > 0, err
+}
+
+// APIKeyUsageKeyPrefix prefixes the hash of the stored events of each API key in a UTC day, by producer id
+const APIKeyUsageKeyPrefix = "clickhouse_api_key_usage:"
+
+// apiKeyUsageExpiration keeps daily counters until the next day is over
+const apiKeyUsageExpiration = 48 * time.Hour
+
+// IncrementAPIKeyUsage adds stored events to the daily counters of API keys, by producer id
+func (r ClickHouseRedis) IncrementAPIKeyUsage(ctx context.Context, day string, events map[string]int64) error {
+	key := APIKeyUsageKeyPrefix + day
+	pipe := r.Pipeline()
+	for producer, count := range events {
+		pipe.HIncrBy(ctx, key, producer, count)
+	}
+	pipe.Expire(ctx, key, apiKeyUsageExpiration)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetAPIKeyUsage returns the stored events of all API keys in a UTC day, by producer id
+func (r ClickHouseRedis) GetAPIKeyUsage(ctx context.Context, day string) (map[string]int64, error) {
+	values, err := r.HGetAll(ctx, APIKeyUsageKeyPrefix+day).Result()
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[string]int64, len(values))
+	for producer, value := range values {
+		usage[producer], _ = strconv.ParseInt(value, 10, 64)
+	}
+	return usage, nil
+}
+
+// DuplicatesKeyPrefix prefixes the hashes of the events received and of the duplicates among them in a UTC day,
+// by producer id and channel, see DuplicateField
+const DuplicatesKeyPrefix = "clickhouse_duplicates:"
+
+// DuplicateField is the field of a producer and channel in the duplicate counters, producer ids never hold the separator
+func DuplicateField(producer, channel string) string {
+	return producer + "|" + channel
+}
+
+// IncrementDuplicates adds received events and the duplicates among them to the daily counters, by DuplicateField
+func (r ClickHouseRedis) IncrementDuplicates(ctx context.Context, day string, received, duplicates map[string]int64) error {
+	receivedKey, duplicatesKey := DuplicatesKeyPrefix+day+":received", DuplicatesKeyPrefix+day+":duplicates"
+	pipe := r.Pipeline()
+	for field, count := range received {
+		pipe.HIncrBy(ctx, receivedKey, field, count)
+	}
+	for field, count := range duplicates {
+		pipe.HIncrBy(ctx, duplicatesKey, field, count)
+	}
+	pipe.Expire(ctx, receivedKey, apiKeyUsageExpiration)
+	pipe.Expire(ctx, duplicatesKey, apiKeyUsageExpiration)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetDuplicates returns the events received and the duplicates among them in a UTC day, by DuplicateField
+func (r ClickHouseRedis) GetDuplicates(ctx context.Context, day string) (received, duplicates map[string]int64, err error) {
+	pipe := r.Pipeline()
+	receivedCmd := pipe.HGetAll(ctx, DuplicatesKeyPrefix+day+":received")
+	duplicatesCmd := pipe.HGetAll(ctx, DuplicatesKeyPrefix+day+":duplicates")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, nil, err
+	}
+	parse := func(values map[string]string) map[string]int64 {
+		counts := make(map[string]int64, len(values))
+		for field, value := range values {
+			counts[field], _ = strconv.ParseInt(value, 10, 64)
+		}
+		return counts
+	}
+	return parse(receivedCmd.Val()), parse(duplicatesCmd.Val()), nil
+}
+
+// PublishFlushAggregate publishes an encoded flush aggregate on a pub/sub channel, it is lost if nobody is subscribed
+func (r ClickHouseRedis) PublishFlushAggregate(ctx context.Context, channel string, aggregate []byte) error {
+	return r.Publish(ctx, channel, aggregate).Err()
+}
+
+// AddFlushAggregate appends an encoded flush aggregate to a stream trimmed to about maxLen entries (0 = untrimmed)
+func (r ClickHouseRedis) AddFlushAggregate(ctx context.Context, stream string, maxLen int64, aggregate []byte) error {
+	return r.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: maxLen,
+		Approx: maxLen > 0,
+		Values: map[string]any{"aggregate": aggregate},
+	}).Err()
+}
+
+// GetFlushAggregateStream returns the length of a flush aggregate stream and its consumer groups, none if the stream does not exist
+func (r ClickHouseRedis) GetFlushAggregateStream(ctx context.Context, stream string) (int64, []redis.XInfoGroup, error) {
+	length, err := r.XLen(ctx, stream).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+	groups, err := r.XInfoGroups(ctx, stream).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return length, nil, nil
+		}
+		return 0, nil, err
+	}
+	return length, groups, nil
+}
+
+// GetFlushAggregateSubscribers returns the number of subscribers of a flush aggregate pub/sub channel
+func (r ClickHouseRedis) GetFlushAggregateSubscribers(ctx context.Context, channel string) (int64, error) {
+	counts, err := r.PubSubNumSub(ctx, channel).Result()
+	if err != nil {
+		return 0, err
+	}
+	return counts[channel], nil
+}
+
+// ClientNonceKeyPrefix prefixes the nonces already used by the client requests of each producer
+const ClientNonceKeyPrefix = "clickhouse_client_nonce:"
+
+// UseClientNonce records the nonce of a client request for ttl, returns false if it was already used
+func (r ClickHouseRedis) UseClientNonce(ctx context.Context, producer, nonce string, ttl time.Duration) (bool, error) {
+	return r.SetNX(ctx, ClientNonceKeyPrefix+producer+":"+nonce, 1, ttl).Result()
+}
+
+// NextUserSequences assigns the next per-user sequence number to each event using INCR,
+// events of the same user get increasing numbers in the order they are given
+func (r ClickHouseRedis) NextUserSequences(ctx context.Context, requests []domain.EventRequest) ([]uint64, error) {
+	pipe := r.Pipeline()
+	cmds := make([]*redis.IntCmd, len(requests))
+	for i, request := range requests {
+		key := UserSequenceKeyPrefix + request.UserID
+		if request.Ingest.Tenant != "" {
+			key = UserSequenceKeyPrefix + request.Ingest.Tenant + ":" + request.UserID
+		}
+		cmds[i] = pipe.Incr(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	sequences := make([]uint64, len(requests))
+	for i, cmd := range cmds {
+		sequences[i] = uint64(cmd.Val())
+	}
+	return sequences, nil
+}
+
+// SchemaLockKey is held by the instance applying schema migrations, so that instances starting together do not race
+const SchemaLockKey = "clickhouse_schema_lock"
+
+// SchemaRewriteKey is held while a schema migration rewrites the events table, inserts of every instance wait until it is released
+const SchemaRewriteKey = "clickhouse_schema_rewrite"
+
+// CDCLockKeyPrefix followed by the replication slot is held by the instance reading the slot, so that its changes are read once
+const CDCLockKeyPrefix = "clickhouse_cdc_lock:"
+
+// KinesisLockKeyPrefix followed by the stream and shard is held by the instance reading the shard
+const KinesisLockKeyPrefix = "clickhouse_kinesis_lock:"
+
+// ImportLockKeyPrefix followed by the name of a file of the import directory is held by the instance importing the file
+const ImportLockKeyPrefix = "clickhouse_import_lock:"
+
+// releaseLockScript deletes a lock only if it is still held by the owner, it may have expired and been taken over
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// extendLockScript resets the expiry of a lock only if it is still held by the owner
+var extendLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// AcquireLock takes a lock for the owner until it is released or ttl passes, returns false if it is held by someone else
+func (r ClickHouseRedis) AcquireLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	return r.SetNX(ctx, key, owner, ttl).Result()
+}
+
+// ReleaseLock releases a lock taken by the owner
+func (r ClickHouseRedis) ReleaseLock(ctx context.Context, key, owner string) error {
+	return releaseLockScript.Run(ctx, r.Client, []string{key}, owner).Err()
+}
+
+// ExtendLock holds a lock taken by the owner for ttl more, returns false if it expired or is held by someone else
+func (r ClickHouseRedis) ExtendLock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	extended, err := extendLockScript.Run(ctx, r.Client, []string{key}, owner, ttl.Milliseconds()).Int64()
+	return extended == 1, err
+}
+
+// IsLocked reports whether a lock is held by anyone
+func (r ClickHouseRedis) IsLocked(ctx context.Context, key string) (bool, error) {
+	err := r.Get(ctx, key).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// InitRedis initializes the Redis client connection, to the in-process store in the embedded mode
 func InitRedis(cfg *config.RedisConfig) error {
 	addr := cfg.GetRedisAddr()
 
@@ -86,6 +683,18 @@ func InitRedis(cfg *config.RedisConfig) error {
 		Password: cfg.Password,
 		DB:       0, // default DB
 	}
+	switch cfg.Mode {
+	case RedisModeServer:
+	case RedisModeEmbedded:
+		embeddedStore = newEmbeddedRedis()
+		opts.Addr = "embedded"
+		opts.Password = ""
+		opts.Protocol = 2
+		opts.DisableIdentity = true
+		opts.Dialer = embeddedStore.dial
+	default:
+		return fmt.Errorf("unknown Redis mode %q", cfg.Mode)
+	}
 
 	client := redis.NewClient(opts)
 
@@ -96,7 +705,11 @@ func InitRedis(cfg *config.RedisConfig) error {
 	}
 
 	redisClient = client
-	log.Println("Redis connection established successfully")
+	if embeddedStore != nil {
+		redisLog.Errorf("Using the embedded in-process store, its state is local to this instance and lost when it stops")
+		return nil
+	}
+	redisLog.Infof("Connection established successfully")
 	return nil
 }
 
@@ -106,7 +719,10 @@ func CloseRedis() error {
 		if err := redisClient.Close(); err != nil {
 			return fmt.Errorf("failed to close Redis connection: %w", err)
 		}
-		log.Println("Redis connection closed")
+		redisLog.Infof("Connection closed")
+	}
+	if embeddedStore != nil {
+		embeddedStore.close()
 	}
 	return nil
 }
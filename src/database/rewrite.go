@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// rewritableColumns are the columns of the events table whose values can be rewritten
+var rewritableColumns = map[string]bool{
+	"event_name": true,
+	"channel":    true,
+}
+
+// RewriteEvents changes a value of a column of the events of a tenant in a database, of the connection's database if empty,
+// one daily partition at a time, and returns the number of rewritten events. The column is part of the sorting key and
+// cannot be updated in place, so the matching rows of a partition are inserted again with the new value and then removed
+// with a lightweight delete. Queries with FINAL collapse the rows inserted twice by a retry after a failure.
+// The materialized views on the events table count the inserted rows again.
+func (c ClickHouseDB) RewriteEvents(ctx context.Context, database, tenant, column, from, to string) (uint64, error) {
+	if !rewritableColumns[column] {
+		return 0, fmt.Errorf("column %q cannot be rewritten", column)
+	}
+	table := eventsTable(database)
+	match := ch.SafeQuery("? = ?", ch.Safe(column), from)
+	if database == "" {
+		// Tenants without a database of their own share the events table, only the rows of the tenant are rewritten
+		match = ch.SafeQuery("? = ? AND tenant_id = ?", ch.Safe(column), from, tenant)
+	}
+
+	var partitions []string
+	err := c.NewSelect().
+		ColumnExpr("DISTINCT _partition_id").
+		TableExpr("?", table).
+		Where("?", match).
+		OrderExpr("_partition_id").
+		Scan(ctx, &partitions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read partitions to rewrite: %w", err)
+	}
+
+	var rewritten uint64
+	for _, partition := range partitions {
+		var count uint64
+		err := c.NewSelect().
+			ColumnExpr("count()").
+			TableExpr("? FINAL", table).
+			Where("_partition_id = ?", partition).
+			Where("?", match).
+			Scan(ctx, &count)
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to count events of partition %s: %w", partition, err)
+		}
+		if count > 0 {
+			_, err = c.DB.ExecContext(ctx, "INSERT INTO ? SELECT * REPLACE (? AS ?) FROM ? FINAL WHERE _partition_id = ? AND ?",
+				table, to, ch.Safe(column), table, partition, match)
+			if err != nil {
+				return rewritten, fmt.Errorf("failed to insert rewritten events of partition %s: %w", partition, err)
+			}
+		}
+		_, err = c.DB.ExecContext(ctx, "DELETE FROM ? WHERE _partition_id = ? AND ?", table, partition, match)
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to delete rewritten events of partition %s: %w", partition, err)
+		}
+		rewritten += count
+	}
+	return rewritten, nil
+}
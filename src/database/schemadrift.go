@@ -0,0 +1,93 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// metadataKeysTable returns the metadata_keys table of a database, of the connection's database if empty.
+// Database names must be validated with DatabaseNamePattern.
+func metadataKeysTable(database string) ch.Safe {
+	if database == "" {
+		return "metadata_keys"
+	}
+	return ch.Safe(database + ".metadata_keys")
+}
+
+// initMetadataKeys creates the metadata_keys table and the materialized view feeding it from the events table.
+// The table counts the events carrying each metadata key per event name and ingestion day, summed in the background
+// by SummingMergeTree. The empty key counts all events of the name, so that key frequencies can be derived.
+// Events stored before the view existed are backfilled once.
+func initMetadataKeys(ctx context.Context, db *ch.DB, database string) error {
+	table := metadataKeysTable(database)
+
+	exists, err := viewExists(ctx, db, database, "metadata_keys_mv")
+	if err != nil {
+		return fmt.Errorf("failed to look up metadata_keys view: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS ? (
+		day Date,
+		event_name LowCardinality(String),
+		key String,
+		total_events UInt64
+	) ENGINE = SummingMergeTree(total_events) ORDER BY (event_name, key, day)`, table)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata_keys table: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	const keys = "SELECT toDate(ingested_at) AS day, event_name, arrayJoin(arrayPushFront(JSONExtractKeys(metadata), '')) AS key, count() AS total_events FROM ?"
+	// Rows inserted from now on are counted by the view, only earlier ones are backfilled
+	created := time.Now()
+	_, err = db.ExecContext(ctx, "CREATE MATERIALIZED VIEW IF NOT EXISTS ? TO ? AS "+keys+" GROUP BY day, event_name, key",
+		table+"_mv", table, eventsTable(database))
+	if err != nil {
+		return fmt.Errorf("failed to create metadata_keys view: %w", err)
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO ? "+keys+" FINAL WHERE ingested_at < ? GROUP BY day, event_name, key",
+		table, eventsTable(database), created)
+	if err != nil {
+		return fmt.Errorf("failed to backfill metadata_keys: %w", err)
+	}
+	return nil
+}
+
+// MetadataKeyCount is the number of events of a name carrying a metadata key in a recent period and in the baseline before it
+type MetadataKeyCount struct {
+	EventName      string    `ch:"event_name"`
+	Key            string    `ch:"key"` // empty for all events of the name
+	RecentEvents   uint64    `ch:"recent_events"`
+	BaselineEvents uint64    `ch:"baseline_events"`
+	FirstSeen      time.Time `ch:"first_day"`
+	LastSeen       time.Time `ch:"last_day"`
+}
+
+// GetMetadataKeyCounts counts the events carrying each metadata key per event name from the metadata_keys table
+// of a database, of the connection's database if empty, ingested since recent and from baseline to recent.
+// If eventName is not empty only its keys are returned.
+func (c ClickHouseDB) GetMetadataKeyCounts(ctx context.Context, database, eventName string, baseline, recent time.Time) ([]MetadataKeyCount, error) {
+	query := c.NewSelect().
+		ColumnExpr("event_name").
+		ColumnExpr("key").
+		ColumnExpr("sumIf(total_events, day >= toDate(?)) AS recent_events", recent).
+		ColumnExpr("sumIf(total_events, day < toDate(?)) AS baseline_events", recent).
+		ColumnExpr("min(day) AS first_day").
+		ColumnExpr("max(day) AS last_day").
+		TableExpr("?", metadataKeysTable(database)).
+		Where("day >= toDate(?)", baseline)
+	if eventName != "" {
+		query = query.Where("event_name = ?", eventName)
+	}
+
+	var counts []MetadataKeyCount
+	if err := query.GroupExpr("event_name, key").OrderExpr("event_name ASC, key ASC").Scan(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// EventSchemaRow is the JSON Schema of the metadata of an event name. Updates are inserted as new versions of the row
+// and collapsed by ReplacingMergeTree on updated_at, deleted schemas are kept as a version marked deleted.
+type EventSchemaRow struct {
+	ch.CHModel `ch:"table:event_schemas"`
+	EventName  string    `ch:"event_name"`
+	Schema     string    `ch:"schema,type:String"`
+	Deleted    uint8     `ch:"deleted"`
+	UpdatedAt  time.Time `ch:"updated_at,type:DateTime64(3)"`
+}
+
+// InitEventSchemasTable creates the event_schemas table if it doesn't exist.
+// Schemas apply to the events of all tenants and share the table of the connection's database.
+func InitEventSchemasTable(ctx context.Context, db *ch.DB) error {
+	_, err := db.NewCreateTable().
+		Model((*EventSchemaRow)(nil)).
+		Engine("ReplacingMergeTree(updated_at)").
+		Order("event_name").
+		IfNotExists().
+		Exec(ctx)
+	return err
+}
+
+// SaveEventSchema inserts a new version of an event schema
+func (c ClickHouseDB) SaveEventSchema(ctx context.Context, row EventSchemaRow) error {
+	if c.DB == nil {
+		return fmt.Errorf("database connection is nil")
+	}
+	if _, err := c.DB.NewInsert().Model(&row).Exec(ctx); err != nil {
+		return fmt.Errorf("failed to insert event schema: %w", err)
+	}
+	return nil
+}
+
+// GetEventSchemas returns the latest version of the event schemas that are not deleted, by event name.
+// If eventName is not empty only its schema is returned.
+func (c ClickHouseDB) GetEventSchemas(ctx context.Context, eventName string) ([]EventSchemaRow, error) {
+	query := c.NewSelect().
+		Model((*EventSchemaRow)(nil)).
+		Final().
+		Where("deleted = 0")
+	if eventName != "" {
+		query = query.Where("event_name = ?", eventName)
+	}
+
+	var rows []EventSchemaRow
+	if err := query.OrderExpr("event_name ASC").Scan(ctx, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"kucukaslan/clickhouse/config"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// ApplyDeduplicationSettings applies the configured insert deduplication window to the events table of a database,
+// of the connection's database if empty. Replicated tables keep the hashes of their last inserted blocks in Keeper,
+// controlled by replicated_deduplication_window(_seconds); plain MergeTree tables only deduplicate inserts if
+// non_replicated_deduplication_window is set. Negative values leave the table's current setting untouched.
+func ApplyDeduplicationSettings(ctx context.Context, db *ch.DB, cfg *config.ClickHouseConfig, database string) error {
+	if cfg.DeduplicationWindow < 0 && cfg.DeduplicationWindowSeconds < 0 {
+		return nil
+	}
+	table := eventsTable(database)
+
+	query := db.NewSelect().
+		TableExpr("system.tables").
+		Column("engine")
+	if database == "" {
+		query = query.Where("database = currentDatabase()")
+	} else {
+		query = query.Where("database = ?", database)
+	}
+	var engine string
+	if err := query.Where("name = 'events'").Scan(ctx, &engine); err != nil {
+		return fmt.Errorf("failed to read events table engine: %w", err)
+	}
+	replicated := strings.HasPrefix(engine, "Replicated")
+
+	var settings []string
+	if cfg.DeduplicationWindow >= 0 {
+		name := "non_replicated_deduplication_window"
+		if replicated {
+			name = "replicated_deduplication_window"
+		}
+		settings = append(settings, fmt.Sprintf("%s = %d", name, cfg.DeduplicationWindow))
+	}
+	if cfg.DeduplicationWindowSeconds >= 0 {
+		if replicated {
+			settings = append(settings, fmt.Sprintf("replicated_deduplication_window_seconds = %d", cfg.DeduplicationWindowSeconds))
+		} else {
+			clickhouseLog.Warnf("Ignoring deduplication window seconds, %s is not replicated (%s)", table, engine)
+		}
+	}
+	if len(settings) == 0 {
+		return nil
+	}
+
+	if _, err := db.ExecContext(ctx, "ALTER TABLE ? MODIFY SETTING ?", table, ch.Safe(strings.Join(settings, ", "))); err != nil {
+		return fmt.Errorf("failed to set deduplication window: %w", err)
+	}
+	return nil
+}
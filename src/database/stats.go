@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// StatsColumns are the events table columns whose value distributions are reported, the ones /metrics groups by
+var StatsColumns = []string{"event_name", "channel", "campaign_id", "user_id", "tags"}
+
+// ColumnStats are the estimated distinct values and most frequent values of the StatsColumns, in their order
+type ColumnStats struct {
+	TotalEvents   uint64     `ch:"total_events"`
+	Cardinalities []uint64   `ch:"cardinalities"`
+	TopValues     [][]string `ch:"top_values"`
+	TopCounts     [][]uint64 `ch:"top_counts"`
+	// Types of the StatsColumns in the events table
+	Types []string `ch:"-"`
+}
+
+// GetColumnStatsFrom estimates the value distributions of the StatsColumns in the events table of a database,
+// of the connection's database if empty, over the events since the given time in a single scan.
+// Cardinalities are HyperLogLog estimates and top values come from the approximate approx_top_count,
+// the table is read without FINAL, so duplicates not merged yet are counted.
+func (c ClickHouseDB) GetColumnStatsFrom(ctx context.Context, database string, since time.Time, top int) (*ColumnStats, error) {
+	cardinalities := make([]string, len(StatsColumns))
+	values := make([]string, len(StatsColumns))
+	counts := make([]string, len(StatsColumns))
+	for i, column := range StatsColumns {
+		// Arrays are counted by element
+		combinator := ""
+		if column == "tags" {
+			combinator = "Array"
+		}
+		topCount := fmt.Sprintf("approx_top_count%s(%d)(%s)", combinator, top, column)
+		cardinalities[i] = fmt.Sprintf("uniq%s(%s)", combinator, column)
+		values[i] = fmt.Sprintf("arrayMap(t -> toString(t.1), %s)", topCount)
+		counts[i] = fmt.Sprintf("arrayMap(t -> toUInt64(t.2), %s)", topCount)
+	}
+
+	var stats ColumnStats
+	err := c.NewSelect().
+		ColumnExpr("count() AS total_events").
+		ColumnExpr("[?] AS cardinalities", ch.Safe(strings.Join(cardinalities, ", "))).
+		ColumnExpr("[?] AS top_values", ch.Safe(strings.Join(values, ", "))).
+		ColumnExpr("[?] AS top_counts", ch.Safe(strings.Join(counts, ", "))).
+		TableExpr("?", eventsTable(database)).
+		Where("timestamp >= ?", since).
+		Scan(ctx, &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := getColumnCompression(ctx, c.DB, database, "events")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events columns: %w", err)
+	}
+	types := make(map[string]string, len(columns))
+	for _, column := range columns {
+		types[column.Name] = column.Type
+	}
+	stats.Types = make([]string, len(StatsColumns))
+	for i, column := range StatsColumns {
+		stats.Types[i] = types[column]
+	}
+	return &stats, nil
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"kucukaslan/clickhouse/buildinfo"
+	"kucukaslan/clickhouse/config"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// DatabaseNamePattern restricts tenant database names to identifier characters,
+// so they can be used unquoted in table expressions
+var DatabaseNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,127}$`)
+
+// CreateTenantDatabase creates a tenant database with the standard events and user_first_seen tables, schema migrations, column settings, indexes and deduplication settings if it doesn't exist.
+// Migrations changing the layout of the events table are applied if tableEngines is set, rewrites never.
+func (c ClickHouseDB) CreateTenantDatabase(ctx context.Context, database string, cfg *config.ClickHouseConfig, tableEngines bool) error {
+	if !DatabaseNamePattern.MatchString(database) {
+		return fmt.Errorf("invalid tenant database name %q", database)
+	}
+	if _, err := c.DB.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS ?", ch.Safe(database)); err != nil {
+		return fmt.Errorf("failed to create database %q: %w", database, err)
+	}
+	if err := initEventsTable(ctx, c.DB, database); err != nil {
+		return fmt.Errorf("failed to initialize events table in %q: %w", database, err)
+	}
+	// Tenant databases are migrated when an instance first uses them, as they are not known upfront.
+	// Rewrites are left to POST /admin/migrations, which pauses the inserts of every instance first.
+	_, err := c.MigrateSchema(ctx, database, cfg.Cluster, buildinfo.GetInfo().Hostname, MigrationOptions{TableEngines: tableEngines})
+	if errors.Is(err, ErrRewritePending) || errors.Is(err, ErrTableEnginesDisabled) {
+		clickhouseLog.Warnf("Events table in %q is not migrated further, apply the migrations with POST /admin/migrations: %v", database, err)
+	} else if err != nil {
+		return fmt.Errorf("failed to migrate events table in %q: %w", database, err)
+	}
+	if err := initUserFirstSeen(ctx, c.DB, database); err != nil {
+		return fmt.Errorf("failed to initialize user_first_seen table in %q: %w", database, err)
+	}
+	if err := initMetadataKeys(ctx, c.DB, database); err != nil {
+		return fmt.Errorf("failed to initialize metadata_keys table in %q: %w", database, err)
+	}
+	if err := ApplyColumnSettings(ctx, c.DB, cfg, database); err != nil {
+		return fmt.Errorf("failed to apply column settings in %q: %w", database, err)
+	}
+	if err := ApplyTagsIndex(ctx, c.DB, cfg, database); err != nil {
+		return fmt.Errorf("failed to apply tags index in %q: %w", database, err)
+	}
+	if err := ApplyDeduplicationSettings(ctx, c.DB, cfg, database); err != nil {
+		return fmt.Errorf("failed to apply deduplication settings in %q: %w", database, err)
+	}
+	return nil
+}
+
+// GetTenantDatabases returns the databases besides the connection's that hold both an events and a schema_migrations table,
+// as created by CreateTenantDatabase
+func (c ClickHouseDB) GetTenantDatabases(ctx context.Context) ([]string, error) {
+	var databases []string
+	err := c.NewSelect().
+		TableExpr("system.tables").
+		Column("database").
+		Where("name IN ('events', 'schema_migrations') AND database != currentDatabase()").
+		GroupExpr("database").
+		Having("count() = 2").
+		OrderExpr("database").
+		Scan(ctx, &databases)
+	return databases, err
+}
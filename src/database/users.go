@@ -0,0 +1,184 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"slices"
+	"time"
+
+	"github.com/uptrace/go-clickhouse/ch"
+)
+
+// userFirstSeenTable returns the user_first_seen table of a database, of the connection's database if empty.
+// Database names must be validated with DatabaseNamePattern.
+func userFirstSeenTable(database string) ch.Safe {
+	if database == "" {
+		return "user_first_seen"
+	}
+	return ch.Safe(database + ".user_first_seen")
+}
+
+func userFirstSeenView(database string) ch.Safe {
+	return userFirstSeenTable(database) + "_mv"
+}
+
+// initUserFirstSeen creates the user_first_seen table and the materialized view feeding it from the events table.
+// The table keeps the first and last event time and the event count of each user of each tenant as aggregate states,
+// merged in the background by AggregatingMergeTree. Events stored before the view existed are backfilled once.
+func initUserFirstSeen(ctx context.Context, db *ch.DB, database string) error {
+	table := userFirstSeenTable(database)
+	view := userFirstSeenView(database)
+
+	exists, err := viewExists(ctx, db, database, "user_first_seen_mv")
+	if err != nil {
+		return fmt.Errorf("failed to look up user_first_seen view: %w", err)
+	}
+	if exists {
+		// Tables of earlier versions merged the users of all tenants, they are rebuilt from the events
+		columns, err := getColumnCompression(ctx, db, database, "user_first_seen")
+		if err != nil {
+			return fmt.Errorf("failed to read columns of user_first_seen: %w", err)
+		}
+		if !slices.ContainsFunc(columns, func(column ColumnCompression) bool { return column.Name == "tenant_id" }) {
+			clickhouseLog.Infof("Rebuilding %s per tenant", table)
+			if _, err := db.ExecContext(ctx, "DROP VIEW IF EXISTS ?", view); err != nil {
+				return fmt.Errorf("failed to drop user_first_seen view: %w", err)
+			}
+			if _, err := db.ExecContext(ctx, "DROP TABLE IF EXISTS ?", table); err != nil {
+				return fmt.Errorf("failed to drop user_first_seen table: %w", err)
+			}
+			exists = false
+		}
+	}
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS ? (
+		tenant_id LowCardinality(String),
+		user_id String,
+		first_seen AggregateFunction(min, DateTime64(3)),
+		last_seen AggregateFunction(max, DateTime64(3)),
+		total_events AggregateFunction(count)
+	) ENGINE = AggregatingMergeTree ORDER BY (tenant_id, user_id)`, table)
+	if err != nil {
+		return fmt.Errorf("failed to create user_first_seen table: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	// Rows inserted from now on are counted by the view, only earlier ones are backfilled
+	created := time.Now()
+	_, err = db.ExecContext(ctx, `CREATE MATERIALIZED VIEW IF NOT EXISTS ? TO ? AS
+		SELECT tenant_id, user_id, minState(timestamp) AS first_seen, maxState(timestamp) AS last_seen, countState() AS total_events
+		FROM ? GROUP BY tenant_id, user_id`, view, table, eventsTable(database))
+	if err != nil {
+		return fmt.Errorf("failed to create user_first_seen view: %w", err)
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO ?
+		SELECT tenant_id, user_id, minState(timestamp), maxState(timestamp), countState()
+		FROM ? FINAL WHERE ingested_at < ? GROUP BY tenant_id, user_id`, table, eventsTable(database), created)
+	if err != nil {
+		return fmt.Errorf("failed to backfill user_first_seen: %w", err)
+	}
+	return nil
+}
+
+// viewExists tells whether a materialized view exists in a database, in the connection's database if empty.
+// Views backfill their table when they are created, so they are only created once.
+func viewExists(ctx context.Context, db *ch.DB, database, name string) (bool, error) {
+	query := db.NewSelect().
+		TableExpr("system.tables").
+		ColumnExpr("count()")
+	if database == "" {
+		query = query.Where("database = currentDatabase()")
+	} else {
+		query = query.Where("database = ?", database)
+	}
+	var views uint64
+	if err := query.Where("name = ?", name).Scan(ctx, &views); err != nil {
+		return false, err
+	}
+	return views > 0, nil
+}
+
+// UserSummary is the first and last event time and the event count of a user
+type UserSummary struct {
+	UserID      string    `ch:"user_id"`
+	FirstSeen   time.Time `ch:"first_seen"`
+	LastSeen    time.Time `ch:"last_seen"`
+	TotalEvents uint64    `ch:"total_events"`
+}
+
+// GetUserSummary returns the summary of a user of a tenant from the user_first_seen table of a database,
+// of the connection's database if empty. It returns nil if the user has no events.
+func (c ClickHouseDB) GetUserSummary(ctx context.Context, database, tenant, userID string) (*UserSummary, error) {
+	query := c.NewSelect().
+		ColumnExpr("user_id").
+		ColumnExpr("minMerge(first_seen) AS first_seen").
+		ColumnExpr("maxMerge(last_seen) AS last_seen").
+		ColumnExpr("countMerge(total_events) AS total_events").
+		TableExpr("?", userFirstSeenTable(database)).
+		Where("user_id = ?", userID)
+	// Tenants without a database of their own share the connection's table
+	if database == "" {
+		query = query.Where("tenant_id = ?", tenant)
+	}
+	var summaries []UserSummary
+	err := query.
+		GroupExpr("user_id").
+		Scan(ctx, &summaries)
+	if err != nil {
+		return nil, err
+	}
+	if len(summaries) == 0 {
+		return nil, nil
+	}
+	return &summaries[0], nil
+}
+
+// NewUsersResult is the number of users seen for the first time in a bucket
+type NewUsersResult struct {
+	Bucket   string `ch:"bucket"`
+	NewUsers uint64 `ch:"new_users"`
+}
+
+// GetNewUsersFrom counts the users whose first event falls in the request's range, by the time bucket of group_by.
+// It returns nil for groupings other than time buckets, whose new users are not defined.
+func (c ClickHouseDB) GetNewUsersFrom(ctx context.Context, database string, request domain.MetricRequest) ([]NewUsersResult, error) {
+	bucketExpr := "'total'"
+	if request.GroupBy != nil {
+		expr, ok := timeBucketExprs[*request.GroupBy]
+		if !ok {
+			return nil, nil
+		}
+		bucketExpr = expr
+	}
+
+	firstSeen := c.NewSelect().
+		ColumnExpr("minMerge(first_seen) AS timestamp").
+		TableExpr("?", userFirstSeenTable(database)).
+		GroupExpr("user_id")
+	// Tenants without a database of their own share the connection's table
+	if database == "" {
+		firstSeen = firstSeen.Where("tenant_id = ?", request.Tenant)
+	}
+	if request.From != nil {
+		firstSeen = firstSeen.Having("timestamp >= ?", time.Unix(*request.From, 0))
+	}
+	if request.To != nil {
+		firstSeen = firstSeen.Having("timestamp < ?", time.Unix(*request.To+1, 0))
+	}
+
+	var results []NewUsersResult
+	err := c.NewSelect().
+		ColumnExpr("? AS bucket", ch.Safe(bucketExpr)).
+		ColumnExpr("count() AS new_users").
+		TableExpr("(?)", firstSeen).
+		GroupExpr("bucket").
+		OrderExpr("bucket ASC").
+		Scan(ctx, &results)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/city"
+)
+
+// BatchChecksum summarizes the rows of an insert, to compare the rows stored with its batch_id with the ones sent.
+// Rows are read with FINAL, as the ReplacingMergeTree keeps them once merged: one row per sorting key, the one with
+// the latest ingested_at, so rows of the batch sharing a key, or inserted again by a retry, count once whether they
+// were merged yet or not.
+type BatchChecksum struct {
+	Rows     uint64 // rows left once rows sharing a sorting key are replaced
+	Checksum uint64 // groupBitXor of the cityHash64 of the inserted columns of the rows
+	From, To time.Time
+}
+
+// rowSeparator separates the columns of a row in the string they are hashed as
+const rowSeparator = '\x1f'
+
+// rowHashColumns are the inserted columns of the events table, as strings in the order they are hashed in.
+// A row is hashed as a single string, its columns separated by rowSeparator and its tags by '\x1e': cityHash64 of
+// several arguments combines hashes that depend on their types, the hash of a string is the same in Go and in ClickHouse.
+var rowHashColumns = []string{
+	"event_name", "channel", "campaign_id", "user_id", "toString(toUnixTimestamp64Milli(timestamp))",
+	"arrayStringConcat(tags, '\\x1e')", "metadata", "toString(user_seq)", "toString(abuse_score)",
+	"tenant_id", "api_key_id", "source", "instance", "batch_id", "toString(toUnixTimestamp(ingested_at))",
+}
+
+// rowHashExpr computes the hash of a row in ClickHouse, of the string appendRow builds in Go
+var rowHashExpr = "cityHash64(concat(" + strings.Join(rowHashColumns, ", '\\x1f', ") + "))"
+
+// rowKey is the sorting key of a row of the events table
+type rowKey struct {
+	timestamp                        int64
+	eventName, channel, user, tenant string
+}
+
+// NewBatchChecksum computes the checksum of the rows of a columnar model, the way GetBatchChecksum computes it in ClickHouse.
+// Of the rows sharing a sorting key, the last one is kept, as they share the ingested_at of the insert.
+func NewBatchChecksum(columns *EventColumnar) BatchChecksum {
+	var sum BatchChecksum
+	hashes := make(map[rowKey]uint64, columns.Len())
+	var buf []byte
+	for i := 0; i < columns.Len(); i++ {
+		timestamp := columns.Timestamp[i]
+		key := rowKey{timestamp.UnixMilli(), columns.EventName[i], columns.Channel[i], columns.UserID[i], columns.TenantID[i]}
+		buf = columns.appendRow(buf[:0], i)
+		hashes[key] = city.CH64(buf)
+		if sum.From.IsZero() || timestamp.Before(sum.From) {
+			sum.From = timestamp
+		}
+		if timestamp.After(sum.To) {
+			sum.To = timestamp
+		}
+	}
+	for _, hash := range hashes {
+		sum.Rows++
+		sum.Checksum ^= hash
+	}
+	return sum
+}
+
+// appendRow appends the columns of a row as rowHashColumns formats them in ClickHouse
+func (c *EventColumnar) appendRow(buf []byte, i int) []byte {
+	buf = append(buf, c.EventName[i]...)
+	buf = append(buf, rowSeparator)
+	buf = append(buf, c.Channel[i]...)
+	buf = append(buf, rowSeparator)
+	buf = append(buf, c.CampaignID[i]...)
+	buf = append(buf, rowSeparator)
+	buf = append(buf, c.UserID[i]...)
+	buf = append(buf, rowSeparator)
+	buf = strconv.AppendInt(buf, c.Timestamp[i].UnixMilli(), 10)
+	buf = append(buf, rowSeparator)
+	for j, tag := range c.Tags[i] {
+		if j > 0 {
+			buf = append(buf, '\x1e')
+		}
+		buf = append(buf, tag...)
+	}
+	buf = append(buf, rowSeparator)
+	buf = append(buf, c.Metadata[i]...)
+	buf = append(buf, rowSeparator)
+	buf = strconv.AppendUint(buf, c.UserSeq[i], 10)
+	buf = append(buf, rowSeparator)
+	buf = strconv.AppendUint(buf, uint64(c.AbuseScore[i]), 10)
+	buf = append(buf, rowSeparator)
+	buf = append(buf, c.TenantID[i]...)
+	buf = append(buf, rowSeparator)
+	buf = append(buf, c.APIKeyID[i]...)
+	buf = append(buf, rowSeparator)
+	buf = append(buf, c.Source[i]...)
+	buf = append(buf, rowSeparator)
+	buf = append(buf, c.Instance[i]...)
+	buf = append(buf, rowSeparator)
+	buf = append(buf, c.BatchID[i]...)
+	buf = append(buf, rowSeparator)
+	// ingested_at is a DateTime, stored in seconds
+	return strconv.AppendInt(buf, c.IngestedAt[i].Unix(), 10)
+}
+
+// GetBatchChecksum computes the checksum of the rows stored with a batch_id in the events table of a database,
+// of the connection's database if empty. Only the partitions of the range of timestamps of the batch are read.
+func (c ClickHouseDB) GetBatchChecksum(ctx context.Context, database, batchID string, from, to time.Time) (BatchChecksum, error) {
+	stored := BatchChecksum{From: from, To: to}
+	// Times are sent with second precision, to is extended to include its whole second
+	err := c.NewSelect().
+		ColumnExpr("count()").
+		ColumnExpr("groupBitXor("+rowHashExpr+")").
+		TableExpr("? FINAL", eventsTable(database)).
+		Where("batch_id = ?", batchID).
+		Where("timestamp >= ? AND timestamp < ?", from.Truncate(time.Second), to.Truncate(time.Second).Add(time.Second)).
+		Scan(ctx, &stored.Rows, &stored.Checksum)
+	return stored, err
+}
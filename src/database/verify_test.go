@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"kucukaslan/clickhouse/config"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestBatchChecksumRetry inserts a batch twice with the same batch_id, as a retry after a failed insert that was stored anyway
+// does, and expects the rows read back to match the ones of the last attempt. It needs a ClickHouse server configured like
+// the service and is skipped unless CLICKHOUSE_BENCH=1.
+func TestBatchChecksumRetry(t *testing.T) {
+	if os.Getenv("CLICKHOUSE_BENCH") != "1" {
+		t.Skip("set CLICKHOUSE_BENCH=1 to insert into the configured ClickHouse")
+	}
+	cfg := config.Load()
+	if err := InitClickHouse(&cfg.ClickHouse); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseClickHouse() })
+	db := GetClickHouseDB()
+	ctx := context.Background()
+
+	columns := NewEventColumnar(100)
+	for _, event := range benchmarkEvents(100) {
+		if err := columns.Append(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 {
+			// ingested_at is stored in seconds, the attempts are told apart by it
+			time.Sleep(time.Second)
+		}
+		if err := db.SaveColumnar(ctx, columns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := NewBatchChecksum(columns)
+	stored, err := db.GetBatchChecksum(ctx, "", columns.Batch(), expected.From, expected.To)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Rows != expected.Rows || stored.Checksum != expected.Checksum {
+		t.Fatalf("%d rows with checksum %d stored, %d rows with checksum %d inserted", stored.Rows, stored.Checksum, expected.Rows, expected.Checksum)
+	}
+}
+
+// TestNewBatchChecksumSortingKey expects rows sharing a sorting key to count once, as the last of them
+func TestNewBatchChecksumSortingKey(t *testing.T) {
+	events := benchmarkEvents(2)
+	events[1] = events[0]
+	events[1].CampaignID = "replacing"
+	columns := NewEventColumnar(2)
+	for _, event := range events {
+		if err := columns.Append(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	columns.Stamp(NewBatchID())
+
+	last := NewEventColumnar(1)
+	if err := last.Append(events[1]); err != nil {
+		t.Fatal(err)
+	}
+	last.Stamp(columns.Batch())
+
+	got, want := NewBatchChecksum(columns), NewBatchChecksum(last)
+	if got.Rows != 1 || got.Checksum != want.Checksum {
+		t.Fatalf("%d rows with checksum %d, expected 1 row with checksum %d", got.Rows, got.Checksum, want.Checksum)
+	}
+}
@@ -15,55 +15,129 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/events": {
-            "post": {
-                "description": "Submit event data for tracking and analytics",
-                "consumes": [
+        "/admin/aggregates": {
+            "get": {
+                "description": "Report the flush aggregates published, failed and dropped by this instance, the lag between a flush and its publication, whether publishing is paused, and the downstream consumers: the length and consumer group lag of the stream in stream mode, the subscribers in pubsub mode",
+                "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Flush aggregate publisher",
+                "responses": {
+                    "200": {
+                        "description": "Aggregate publisher retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.AggregatePublisherResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Flush aggregates are not published",
+                        "schema": {
+                            "$ref": "#/definitions/domain.AggregatePublisherResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.AggregatePublisherResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/aggregates/pause": {
+            "post": {
+                "description": "Hold the flush aggregates instead of publishing them, on all instances, to relieve downstream consumers. Other instances pick the pause up within FEATURE_FLAGS_REFRESH_INTERVAL_SECONDS",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Events"
+                    "Admin"
                 ],
-                "summary": "Post event data",
-                "parameters": [
-                    {
-                        "description": "Event data",
-                        "name": "event",
-                        "in": "body",
-                        "required": true,
+                "summary": "Pause publishing flush aggregates",
+                "responses": {
+                    "200": {
+                        "description": "Aggregate publishing paused",
                         "schema": {
-                            "$ref": "#/definitions/domain.EventRequest"
+                            "$ref": "#/definitions/domain.AggregatePublisherResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Flush aggregates are not published",
+                        "schema": {
+                            "$ref": "#/definitions/domain.AggregatePublisherResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.AggregatePublisherResponse"
                         }
                     }
+                }
+            }
+        },
+        "/admin/aggregates/resume": {
+            "post": {
+                "description": "Resume publishing the flush aggregates on all instances, the aggregates held while paused are published first, in order",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
                 ],
+                "summary": "Resume publishing flush aggregates",
                 "responses": {
                     "200": {
-                        "description": "Event posted successfully",
+                        "description": "Aggregate publishing resumed",
                         "schema": {
-                            "$ref": "#/definitions/domain.EventResponse"
+                            "$ref": "#/definitions/domain.AggregatePublisherResponse"
                         }
                     },
-                    "400": {
-                        "description": "Invalid request",
+                    "404": {
+                        "description": "Flush aggregates are not published",
                         "schema": {
-                            "$ref": "#/definitions/domain.EventResponse"
+                            "$ref": "#/definitions/domain.AggregatePublisherResponse"
                         }
                     },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
-                            "$ref": "#/definitions/domain.EventResponse"
+                            "$ref": "#/definitions/domain.AggregatePublisherResponse"
                         }
                     }
                 }
             }
         },
-        "/events/bulk": {
+        "/admin/api-keys": {
+            "get": {
+                "description": "List the API keys allowed to call /events and /metrics by producer id, with their effective limits and the events they stored today (UTC)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List API keys",
+                "responses": {
+                    "200": {
+                        "description": "API keys retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.APIKeysResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.APIKeysResponse"
+                        }
+                    }
+                }
+            },
             "post": {
-                "description": "Submit multiple events in a single request for high-throughput ingestion. Uses columnar batch inserts for optimal performance.",
+                "description": "Generate an API key, returned only once in the response, or allow an existing key by its producer id. Creating a key that exists replaces its limits.\nOther instances accept the key within AUTH_REFRESH_INTERVAL_SECONDS.",
                 "consumes": [
                     "application/json"
                 ],
@@ -71,319 +145,6568 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Events"
+                    "Admin"
                 ],
-                "summary": "Post bulk event data",
+                "summary": "Create an API key",
                 "parameters": [
                     {
-                        "description": "Array of event data",
-                        "name": "events",
+                        "description": "API key",
+                        "name": "key",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/domain.BulkEventRequest"
+                            "$ref": "#/definitions/domain.APIKeyRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Bulk events posted successfully",
+                        "description": "API key created successfully",
                         "schema": {
-                            "$ref": "#/definitions/domain.BulkEventResponse"
+                            "$ref": "#/definitions/domain.APIKeysResponse"
                         }
                     },
                     "400": {
                         "description": "Invalid request",
                         "schema": {
-                            "$ref": "#/definitions/domain.BulkEventResponse"
+                            "$ref": "#/definitions/domain.APIKeysResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "API key is configured in AUTH_API_KEYS",
+                        "schema": {
+                            "$ref": "#/definitions/domain.APIKeysResponse"
                         }
                     },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
-                            "$ref": "#/definitions/domain.BulkEventResponse"
+                            "$ref": "#/definitions/domain.APIKeysResponse"
                         }
                     }
                 }
             }
         },
-        "/health": {
+        "/admin/api-keys/{producer}": {
+            "delete": {
+                "description": "Revoke an API key created at runtime, other instances reject it within AUTH_REFRESH_INTERVAL_SECONDS. Keys of AUTH_API_KEYS cannot be revoked at runtime.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Revoke an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Producer id of the API key",
+                        "name": "producer",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "API key revoked successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.APIKeysResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid producer id",
+                        "schema": {
+                            "$ref": "#/definitions/domain.APIKeysResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "API key not found",
+                        "schema": {
+                            "$ref": "#/definitions/domain.APIKeysResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "API key is configured in AUTH_API_KEYS",
+                        "schema": {
+                            "$ref": "#/definitions/domain.APIKeysResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.APIKeysResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/compression": {
             "get": {
-                "description": "Check the health status of the service and its dependencies",
+                "description": "Report the codec, type and compressed/uncompressed size of each column of the events table",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Health"
+                    "Admin"
                 ],
-                "summary": "Health check endpoint",
+                "summary": "Column compression statistics",
                 "responses": {
                     "200": {
-                        "description": "Service is healthy",
+                        "description": "Column compression retrieved successfully",
                         "schema": {
-                            "$ref": "#/definitions/domain.HealthResponse"
+                            "$ref": "#/definitions/domain.ColumnCompressionResponse"
                         }
                     },
-                    "503": {
-                        "description": "Service is unhealthy",
+                    "500": {
+                        "description": "Internal server error",
                         "schema": {
-                            "$ref": "#/definitions/domain.HealthResponse"
+                            "$ref": "#/definitions/domain.ColumnCompressionResponse"
                         }
                     }
                 }
             }
         },
-        "/metrics": {
+        "/admin/dead-letter": {
             "get": {
-                "description": "Query aggregated event metrics with filtering and grouping",
+                "description": "List the events of the tenant whose flush still failed after EVENT_FLUSH_MAX_RETRIES retries, oldest first, with the error of the last attempt.\nReprocess them with POST /admin/reprocess and source dead_letter once ClickHouse is back.\nWith sink, the events an optional sink (clickhouse_secondary, kafka or s3) failed to write are listed instead, reprocessed with the same sink.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Metrics"
+                    "Admin"
                 ],
-                "summary": "GET aggregated metrics",
+                "summary": "List dead-lettered events",
                 "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Event name filter",
-                        "name": "event_name",
-                        "in": "query"
-                    },
                     {
                         "type": "integer",
-                        "description": "Start timestamp (Unix seconds)",
-                        "name": "from",
+                        "description": "Maximum number of events (default 100)",
+                        "name": "limit",
                         "in": "query"
                     },
                     {
-                        "type": "integer",
-                        "description": "End timestamp (Unix seconds)",
-                        "name": "to",
+                        "type": "string",
+                        "description": "Optional sink whose dead-lettered events are listed, e.g. kafka",
+                        "name": "sink",
                         "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Group by field (hour, day, week, month, year, channel, campaign_id, user_id, event_name)",
-                        "name": "group_by",
-                        "in": "query"
+                        "description": "Tenant whose events are listed",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Metrics retrieved successfully",
+                        "description": "Dead-lettered events retrieved successfully",
                         "schema": {
-                            "$ref": "#/definitions/domain.MetricResponse"
+                            "$ref": "#/definitions/domain.DeadLetterResponse"
                         }
                     },
                     "400": {
                         "description": "Invalid request",
                         "schema": {
-                            "$ref": "#/definitions/domain.MetricResponse"
+                            "$ref": "#/definitions/domain.DeadLetterResponse"
                         }
                     },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
-                            "$ref": "#/definitions/domain.MetricResponse"
+                            "$ref": "#/definitions/domain.DeadLetterResponse"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "buildinfo.Info": {
-            "type": "object",
-            "properties": {
-                "buildDate": {
-                    "type": "string",
-                    "example": "2025-11-22T10:00:00Z"
-                },
-                "commit": {
-                    "type": "string",
-                    "example": "abc123def456"
-                },
-                "goVersion": {
-                    "type": "string",
-                    "example": "go1.25.4"
-                },
-                "hostname": {
-                    "type": "string",
-                    "example": "app-server-01"
-                },
-                "uptime": {
-                    "type": "integer",
-                    "example": 3600000000000
-                },
-                "version": {
-                    "type": "string",
-                    "example": "v1.0.0"
-                }
-            }
         },
-        "domain.BulkEventRequest": {
-            "type": "object",
-            "properties": {
-                "events": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/domain.EventRequest"
+        "/admin/dimensions": {
+            "get": {
+                "description": "List the dimensions with the state of their dictionaries: rows loaded, memory, source, refresh interval, last load and last error",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List dimensions",
+                "responses": {
+                    "200": {
+                        "description": "Dimensions retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionListResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionListResponse"
+                        }
                     }
                 }
             }
         },
-        "domain.BulkEventResponse": {
-            "type": "object",
-            "properties": {
-                "failure_count": {
-                    "type": "integer",
-                    "example": 0
-                },
-                "message": {
-                    "type": "string",
-                    "example": "Bulk events posted successfully"
-                },
-                "success": {
-                    "type": "boolean",
-                    "example": true
-                },
-                "success_count": {
-                    "type": "integer",
-                    "example": 100
-                },
-                "total_count": {
-                    "type": "integer",
-                    "example": 100
+        "/admin/dimensions/{name}": {
+            "get": {
+                "description": "Get a dimension with the state of its dictionary",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Get a dimension",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Dimension name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Dimension retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid dimension name",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Dimension not found",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    }
                 }
-            }
-        },
+            },
+            "put": {
+                "description": "Replace the rows of a dimension from a CSV file with a header row. The first column is the key matched against the buckets of /metrics?enrich={name}, the other columns are attributes. Rows are stored in a table and served by a ClickHouse dictionary through dictGet.",
+                "consumes": [
+                    "text/csv"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Upload a dimension",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Dimension name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "CSV rows with a header row, e.g. campaign_id,owner,budget",
+                        "name": "rows",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Dimension uploaded successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Drop the dictionary of a dimension and its uploaded rows",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Delete a dimension",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Dimension name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Dimension deleted successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid dimension name",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Dimension not found",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/dimensions/{name}/reload": {
+            "post": {
+                "description": "Reload the dictionary of a dimension from its uploaded rows or URL now, instead of waiting for its refresh interval",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Reload a dimension",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Dimension name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Dimension reloaded successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid dimension name",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Dimension not found",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error, e.g. the source could not be loaded",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/dimensions/{name}/url": {
+            "put": {
+                "description": "Create or replace the dictionary of a dimension loading its rows from a file ClickHouse downloads from the URL and refreshes every lifetime_seconds. The file must start with a header row naming the columns, the first column is the key.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Load a dimension from a URL",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Dimension name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "URL, format and columns of the dimension",
+                        "name": "source",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionURLRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Dimension created successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error, e.g. the file could not be loaded",
+                        "schema": {
+                            "$ref": "#/definitions/domain.DimensionResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/expressions/test": {
+            "post": {
+                "description": "Check that an expression of data quality rules, the drop transformation plugin or the sink filters compiles, and evaluate it on up to 100 sample events.\nExpressions are CEL over event_name, channel, campaign_id, user_id, timestamp, tags, metadata, tenant, source and producer; the sample events have the tenant of the request.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Test an expression",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant of the sample events",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Expression and sample events",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.ExpressionTestRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Expression tested, valid reports whether it compiles",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ExpressionTestResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ExpressionTestResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/flags": {
+            "get": {
+                "description": "List the feature flags with their defaults from the environment, runtime overrides and effective values",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List feature flags",
+                "responses": {
+                    "200": {
+                        "description": "Feature flags retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.FeatureFlagsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.FeatureFlagsResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/flags/{name}": {
+            "put": {
+                "description": "Override a feature flag on all instances, other instances pick the change up within FEATURE_FLAGS_REFRESH_INTERVAL_SECONDS",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Override a feature flag",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Flag name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Flag value",
+                        "name": "flag",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.FeatureFlagRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Feature flag set successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.FeatureFlagsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.FeatureFlagsResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown feature flag",
+                        "schema": {
+                            "$ref": "#/definitions/domain.FeatureFlagsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.FeatureFlagsResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Remove the runtime override of a feature flag, reverting it to its default from the environment",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Reset a feature flag",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Flag name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Feature flag reset successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.FeatureFlagsResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown feature flag",
+                        "schema": {
+                            "$ref": "#/definitions/domain.FeatureFlagsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.FeatureFlagsResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/migrations": {
+            "get": {
+                "description": "List the columns added to the events table by each schema migration, whether they were applied, when and by which instance",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Schema migrations",
+                "responses": {
+                    "200": {
+                        "description": "Schema migrations retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.SchemaMigrationsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.SchemaMigrationsResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Add the columns of the pending schema migrations to the events table, in version order. Columns are added with defaults, which only changes the table metadata,\nso instances of the previous version keep ingesting meanwhile. Migrations rewriting the events table, of the connection's and the tenant databases, pause the inserts\nof every instance until they are applied, the events wait in the batchers. Only one instance applies migrations at a time.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Apply schema migrations",
+                "responses": {
+                    "200": {
+                        "description": "Schema migrations applied",
+                        "schema": {
+                            "$ref": "#/definitions/domain.SchemaMigrationsResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Another instance is applying the migrations, or the next one changes the layout of the events table and the table_engines flag is disabled",
+                        "schema": {
+                            "$ref": "#/definitions/domain.SchemaMigrationsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.SchemaMigrationsResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/prestop": {
+            "get": {
+                "description": "Meant as the preStop hook of the container, it answers once the instance can be stopped without losing events.\n/health reports draining from then on and the instance leaves service discovery, so that no new traffic is routed to it.\nRequests still arriving are served for PRESTOP_DELAY_SECONDS, then the buffered events are flushed without waiting for full batches,\nfor at most PRESTOP_TIMEOUT_SECONDS. Kubernetes preStop hooks send GET requests, POST is accepted as well.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Drain the instance before it is stopped",
+                "responses": {
+                    "200": {
+                        "description": "Buffered events flushed",
+                        "schema": {
+                            "$ref": "#/definitions/domain.PreStopResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Buffered events could not be flushed in time",
+                        "schema": {
+                            "$ref": "#/definitions/domain.PreStopResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Meant as the preStop hook of the container, it answers once the instance can be stopped without losing events.\n/health reports draining from then on and the instance leaves service discovery, so that no new traffic is routed to it.\nRequests still arriving are served for PRESTOP_DELAY_SECONDS, then the buffered events are flushed without waiting for full batches,\nfor at most PRESTOP_TIMEOUT_SECONDS. Kubernetes preStop hooks send GET requests, POST is accepted as well.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Drain the instance before it is stopped",
+                "responses": {
+                    "200": {
+                        "description": "Buffered events flushed",
+                        "schema": {
+                            "$ref": "#/definitions/domain.PreStopResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Buffered events could not be flushed in time",
+                        "schema": {
+                            "$ref": "#/definitions/domain.PreStopResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/quarantine": {
+            "get": {
+                "description": "List the events of a tenant that violated data quality rules, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List quarantined events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name filter",
+                        "name": "event_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Status filter (quarantined, reprocessed)",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of events, 100 by default and at most 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are listed",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Quarantined events retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.QuarantineResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.QuarantineResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.QuarantineResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/quarantine/reprocess": {
+            "post": {
+                "description": "Check quarantined events against the current data quality rules again, after an optional transform. Events passing them are ingested and marked reprocessed, the others stay quarantined with their updated violations.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Reprocess quarantined events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are reprocessed",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Ids of the quarantined events and optional transform, source is ignored",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.ReprocessRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Quarantined events reprocessed",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ReprocessResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ReprocessResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ReprocessResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/reprocess": {
+            "post": {
+                "description": "Read events from the quarantine or the dead-letter queue, apply an optional transform (rename, set and remove fields) and re-ingest them through validation, the data quality rules and the batcher. Events still failing stay where they are.\nWith source raw the ids are event ids of events_raw, whose payloads are parsed again and replace the stored events instead of being deduplicated.\nWith source dead_letter and a sink, the events the sink failed to write are written to it again as they are and removed from its dead-letter queue.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Reprocess events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are reprocessed",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Source, ids and transform",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.ReprocessRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Events reprocessed",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ReprocessResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ReprocessResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ReprocessResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "Source not available",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ReprocessResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/rewrites": {
+            "get": {
+                "description": "List the rules renaming the event names and channels of the tenant's events at ingestion, with the status of their backfills",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List rewrite rules",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose rules are listed",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Rewrite rules retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRulesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid tenant",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRulesResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRulesResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Rename a value of event_name or channel of the tenant's events at ingestion, e.g. after a taxonomy change. All instances apply the rule within FEATURE_FLAGS_REFRESH_INTERVAL_SECONDS.\nWith retroactive true, the events stored with the old value are rewritten in the background once every instance applies the rule; its progress is reported in the rule's backfill status.\nRules cannot be chained: a rule cannot rewrite to a value another rule rewrites, or rewrite the value another rule rewrites to.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Set a rewrite rule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are rewritten",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Rewrite rule",
+                        "name": "rule",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRuleRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Rewrite rule set successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRulesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid rule",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRulesResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Rule would be chained with another rule",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRulesResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRulesResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Stop rewriting a value of event_name or channel of the tenant's events. Events keep the value they were stored with.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Delete a rewrite rule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose rule is deleted",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Field of the rule (event_name, channel)",
+                        "name": "field",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Value rewritten by the rule",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Rewrite rule deleted successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRulesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRulesResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Rewrite rule not found",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRulesResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RewriteRulesResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/schema-drift": {
+            "get": {
+                "description": "Compare the metadata keys carried by the events of each name ingested in the last days with the baseline days before them,\nfrom a summary table maintained by a materialized view. Keys only carried by recent events are new, keys only carried by baseline events disappeared,\nand keys whose share of the events changed by at least min_change changed. Event names without events in both periods are left out.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Schema drift of metadata keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only the keys of this event name",
+                        "name": "event_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Recent days including today (default 7)",
+                        "name": "days",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Days before the recent ones compared against (default 28)",
+                        "name": "baseline_days",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Change of a key's share of the events reported as drift, 0-1 (default 0.2)",
+                        "name": "min_change",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are queried",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Schema drift retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.SchemaDriftResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.SchemaDriftResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.SchemaDriftResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/slo": {
+            "get": {
+                "description": "Report, for each route with an objective, the share of its requests answered without a server error within the latency threshold over the period,\nthe error budget left and the burn rates over windows from 5 minutes to 3 days. A route is at risk when the budget burns fast over both a long and a short window.\nRequests are counted by this instance since it started.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Service level objectives",
+                "responses": {
+                    "200": {
+                        "description": "SLO retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.SLOResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.SLOResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/stats": {
+            "get": {
+                "description": "Estimate the distinct values (HyperLogLog) and the most frequent values of event_name, channel, campaign_id, user_id and tags\nover the events of the last days in a single scan, to choose sensible group_bys and check the LowCardinality types of the events table.\nColumns whose type does not suit their cardinality carry a recommendation. Duplicates not merged yet are counted.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Column statistics",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Last days of events (default 7)",
+                        "name": "days",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Most frequent values per column (default 10)",
+                        "name": "top",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are queried",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Column statistics retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ColumnStatsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ColumnStatsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ColumnStatsResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/beacon": {
+            "post": {
+                "description": "Track a single event sent as a form-encoded body (as sent by navigator.sendBeacon with URLSearchParams or FormData strings) or as the query string. The parameters and defaults are the ones of /pixel.gif, body parameters take precedence over the query string.",
+                "consumes": [
+                    "application/x-www-form-urlencoded",
+                    "text/plain"
+                ],
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Post a beacon event",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name",
+                        "name": "event_name",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User id",
+                        "name": "user_id",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Channel, web by default",
+                        "name": "channel",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Campaign id, none by default",
+                        "name": "campaign_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unix seconds, or milliseconds (auto-detected), or an RFC 3339 date-time",
+                        "name": "timestamp",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated tags",
+                        "name": "tags",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant of the event, X-Tenant-ID takes precedence",
+                        "name": "tenant",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant of the event",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client token, as beacons cannot send the X-Client-Token header",
+                        "name": "token",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Event accepted"
+                    },
+                    "400": {
+                        "description": "Invalid event",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "Beacon body too large",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Monthly quota of the tenant exceeded",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Service unavailable (buffer full)",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/catalog": {
+            "get": {
+                "description": "List the documented event names of the tenant with their owner, description and expected metadata keys,\ncross-referenced with the events ingested in the last days: their number, the last day they were seen,\nthe expected metadata keys no event carried and the keys carried but not documented.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Catalog"
+                ],
+                "summary": "List the event catalog",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Ingestion days including today the documentation is compared with (default 30)",
+                        "name": "days",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose catalog is listed",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Event catalog retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/catalog/coverage": {
+            "get": {
+                "description": "Report the event names ingested in the last days without documentation, most frequent first, the share of the events\nwhose name is documented, and the documented event names without events in the last days.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Catalog"
+                ],
+                "summary": "Event catalog coverage",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Ingestion days including today (default 30)",
+                        "name": "days",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose catalog is checked",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Catalog coverage retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogCoverageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogCoverageResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogCoverageResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/catalog/{event_name}": {
+            "get": {
+                "description": "Get the documentation of an event name, cross-referenced with its events ingested in the last days",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Catalog"
+                ],
+                "summary": "Get an event catalog entry",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name",
+                        "name": "event_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Ingestion days including today the documentation is compared with (default 30)",
+                        "name": "days",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose catalog is read",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Event catalog retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Event name is not documented",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Document an event name with its owner, description and expected metadata keys, replacing its earlier documentation",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Catalog"
+                ],
+                "summary": "Document an event name",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name",
+                        "name": "event_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose event name is documented",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Documentation of the event name",
+                        "name": "entry",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogEntryRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Event catalog retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Remove the documentation of an event name, its events are not affected",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Catalog"
+                ],
+                "summary": "Delete an event catalog entry",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name",
+                        "name": "event_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose event name is deleted",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Event documentation deleted successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Event name is not documented",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.CatalogResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/events": {
+            "post": {
+                "description": "Submit event data for tracking and analytics",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Post event data",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant of the event",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Time of the client's clock when sending (Unix milliseconds), its skew from the X-Server-Time of the response is measured and corrected with CLOCK_SKEW_CORRECTION",
+                        "name": "X-Client-Time",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Compression of the body, gzip or zstd",
+                        "name": "Content-Encoding",
+                        "in": "header"
+                    },
+                    {
+                        "enum": [
+                            "none",
+                            "buffered",
+                            "flushed"
+                        ],
+                        "type": "string",
+                        "description": "Acknowledgment level: none answers 204 right away and ingests the event in the background, buffered (default) once it is buffered, flushed once it is stored",
+                        "name": "ack",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Event data",
+                        "name": "event",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Event posted successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventResponse"
+                        }
+                    },
+                    "204": {
+                        "description": "Event accepted with ack=none, failures are not reported"
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Body too large once decompressed",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Content-Encoding",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Monthly quota of the tenant exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service unavailable (buffer full)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventResponse"
+                        }
+                    },
+                    "504": {
+                        "description": "Event not flushed in time with ack=flushed",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/events/backfill/{id}": {
+            "get": {
+                "description": "Get the state of a backfill job and the progress of its day partitions: their events, the events written or skipped as already stored so far",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Get a backfill job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Backfill job id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant of the job",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Backfill job retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Backfill job not found",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "Backfills are not enabled",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Upload a chunk of historical events as newline delimited JSON, one event per line, to the backfill job of the id, created by its first chunk.\nThe events are spooled to disk by day; nothing is written to ClickHouse until the job is started with POST /events/backfill/{id}/start.",
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Upload a backfill chunk",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Backfill job id, 1 to 64 letters, digits, '-' or '_'",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant of the events",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Compression of the body, gzip or zstd",
+                        "name": "Content-Encoding",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Newline delimited JSON events",
+                        "name": "events",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Backfill chunk received",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Backfill job was started",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Body too large once decompressed",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Content-Encoding",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "Backfills are not enabled",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/events/backfill/{id}/start": {
+            "post": {
+                "description": "Stop receiving events and write the events of the job to ClickHouse in the background, day partition by day partition,\nBACKFILL_WORKERS days in parallel. Starting a failed job resumes it from the checkpoints of its days; a started job is left as is.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Start a backfill job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Backfill job id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant of the job",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Backfill job started",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Backfill job not found",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "Backfills are not enabled",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BackfillResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/events/bulk": {
+            "post": {
+                "description": "Submit multiple events in a single request for high-throughput ingestion. Uses columnar batch inserts for optimal performance.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Post bulk event data",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant of the events",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Time of the client's clock when sending (Unix milliseconds), its skew from the X-Server-Time of the response is measured and corrected with CLOCK_SKEW_CORRECTION",
+                        "name": "X-Client-Time",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Compression of the body, gzip or zstd",
+                        "name": "Content-Encoding",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Array of event data",
+                        "name": "events",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkEventRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Bulk events posted successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkEventResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Bulk events enqueued (async bulk mode or async_bulk flag)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkEventResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkEventResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Body too large once decompressed",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkEventResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Content-Encoding",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkEventResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Monthly quota of the tenant exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkEventResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkEventResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service unavailable (buffer full, async bulk mode or user ordering only)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkEventResponse"
+                        }
+                    },
+                    "504": {
+                        "description": "Events were not flushed in time (user ordering only)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkEventResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/events/bulk/limits": {
+            "get": {
+                "description": "Report the maximum number of events per /events/bulk request and /events/stream chunk for the API key and tenant of the request, so SDKs can adapt their batch sizes",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Bulk request limits",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key of the producer",
+                        "name": "X-API-Key",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant of the events",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Bulk limits retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkLimitsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid tenant",
+                        "schema": {
+                            "$ref": "#/definitions/domain.BulkLimitsResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/events/export": {
+            "get": {
+                "description": "Stream the events matching the filters of GET /metrics, in time order, as a Parquet file generated by the service,\nso that analysts can load them into Spark or pandas without access to ClickHouse. The range is bounded like the one of /metrics.\nEvents are sent as they are read, a failure after the response started leaves the file without its footer, which readers reject.\nClient tokens cannot export, and neither can API keys whose role is denied one of the exported columns.",
+                "produces": [
+                    "application/vnd.apache.parquet",
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Export raw events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "File format, parquet (default)",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Event name filter",
+                        "name": "event_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only events with this tag",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Start timestamp (Unix seconds), the default range (7 days) before to if omitted",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "End timestamp (Unix seconds), now if omitted",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Leave out the events whose abuse score (0-100) is above it",
+                        "name": "max_abuse_score",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Ingestion watermark (Unix timestamp), only events stored at or before it are exported",
+                        "name": "as_of",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Pin the export to the current ingestion watermark",
+                        "name": "pin",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are exported",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Parquet file of the events",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request or range too long (code range_too_long)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ExportResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Client token, or an API key whose role is denied an exported column (code column_denied)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ExportResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ExportResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/events/stream": {
+            "post": {
+                "description": "Submit a chunk of a client event stream as newline delimited JSON, one event per line.\nThe response is sent once all events of the chunk are flushed to ClickHouse, then the checkpoint token is acknowledged.\nAfter a disconnect clients resume from the last acknowledged checkpoint; resent events are deduplicated.",
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Post a stream chunk",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Client stream id",
+                        "name": "X-Stream-ID",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Opaque checkpoint token of the chunk, e.g. the producer offset",
+                        "name": "X-Checkpoint-Token",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant of the events",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Time of the client's clock when sending (Unix milliseconds), its skew from the X-Server-Time of the response is measured and corrected with CLOCK_SKEW_CORRECTION",
+                        "name": "X-Client-Time",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Compression of the body, gzip or zstd",
+                        "name": "Content-Encoding",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Newline delimited JSON events",
+                        "name": "events",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Chunk flushed and checkpoint acknowledged",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Body too large once decompressed",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Content-Encoding",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Monthly quota of the tenant exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service unavailable (buffer full)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    },
+                    "504": {
+                        "description": "Chunk was not flushed in time, checkpoint not acknowledged",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/events/stream/checkpoint": {
+            "get": {
+                "description": "Retrieve the last acknowledged checkpoint token of a client stream, to resume the stream after a disconnect",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Get the last acknowledged checkpoint",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Client stream id",
+                        "name": "stream_id",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Checkpoint retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/events/ws": {
+            "get": {
+                "description": "Upgrade to a WebSocket carrying a client event stream. Every text message is a chunk, {\"checkpoint_token\": \"...\", \"events\": [...]},\nanswered with a message once all of its events are flushed to ClickHouse and the checkpoint token is acknowledged, in the order the chunks were sent.\nChunks are validated and acknowledged like the ones of POST /events/stream, a rejected chunk does not close the connection.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Stream events over a WebSocket",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Client stream id, instead of the X-Stream-ID header that browsers cannot set",
+                        "name": "stream_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client stream id",
+                        "name": "X-Stream-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant of the events",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "101": {
+                        "description": "Switching protocols, every chunk is answered with a StreamEventResponse",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Missing stream id",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    },
+                    "426": {
+                        "description": "Not a WebSocket upgrade",
+                        "schema": {
+                            "$ref": "#/definitions/domain.StreamEventResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/health": {
+            "get": {
+                "description": "Check the health status of the service, its dependencies and the ingestion pipeline.\nThe service is degraded when the event buffer is nearly full or pending events have not been flushed for too long.\nIt is also degraded while schema migrations are pending, so that instances of a new version only receive traffic once the columns they write exist.\nIt is draining once /admin/prestop was called, so that readiness probes take the instance out of rotation.\nIt is degraded as well once more events than HEALTH_MAX_DEAD_LETTER_EVENTS wait in the dead-letter queue, reported in ingestion.dead_letter_events.\nservices.parts reports whether inserts are slowed or paused because the events tables have too many active parts, without degrading the service.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Health"
+                ],
+                "summary": "Health check endpoint",
+                "responses": {
+                    "200": {
+                        "description": "Service is healthy",
+                        "schema": {
+                            "$ref": "#/definitions/domain.HealthResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service is unhealthy or degraded",
+                        "schema": {
+                            "$ref": "#/definitions/domain.HealthResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/internal/metrics": {
+            "get": {
+                "description": "Expose internal service telemetry (ingestion, batching, storage) in the Prometheus text format",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "Health"
+                ],
+                "summary": "Internal service telemetry",
+                "responses": {
+                    "200": {
+                        "description": "Metrics in the Prometheus text exposition format",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics": {
+            "get": {
+                "description": "Query aggregated event metrics with filtering and grouping.\nWith group_by=user_id and Accept: application/x-ndjson the buckets are streamed as newline delimited JSON, one MetricResult per line,\nwithout realtime counts and new users. A failure after the response started is reported as a last line with success false.\nQueries pinned with pin or as_of count the events stored up to the watermark, without realtime counts and new users,\nso that follow-up queries passing the returned as_of agree with each other while events keep arriving.\nannotate_anomalies scores each bucket of an hour or day grouping against the same bucket of the previous seasons, see anomaly_score of the buckets.\ntop_k estimates the top buckets with approx_top_count instead of counting every bucket, its accuracy is described by top_k of the response.\nformat=csv or format=jsonl returns the buckets alone as an attachment, a CSV line or a MetricResult per bucket; errors are still answered as JSON.",
+                "produces": [
+                    "application/json",
+                    "application/x-ndjson",
+                    "text/csv"
+                ],
+                "tags": [
+                    "Metrics"
+                ],
+                "summary": "GET aggregated metrics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name filter",
+                        "name": "event_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only events with this tag, and the tags under it if it ends with / (e.g. category/electronics/)",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Start timestamp (Unix seconds), the default range (7 days) before to if omitted",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "End timestamp (Unix seconds), now if omitted",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are queried",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Group by field (hour, day, week, month, year, channel, campaign_id, user_id, event_name, tags)",
+                        "name": "group_by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "With group_by=tags, roll the tags up to their first levels (e.g. 2 counts category/electronics/phones as category/electronics)",
+                        "name": "tag_depth",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only the approximate top buckets by events (1-1000), estimated in a single pass for long ranges; requires group_by channel, campaign_id, user_id, event_name or tags",
+                        "name": "top_k",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Score the events of each bucket against the same bucket of the previous 4 days (hour) or weeks (day), requires group_by hour or day",
+                        "name": "annotate_anomalies",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Dimension whose attributes are added to the buckets, requires group_by channel, campaign_id, user_id or event_name",
+                        "name": "enrich",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Leave out the events whose abuse score (0-100) is above it",
+                        "name": "max_abuse_score",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Ingestion watermark (Unix timestamp) returned by a pinned query, only events stored at or before it are counted",
+                        "name": "as_of",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Pin the query to the current ingestion watermark, returned as as_of",
+                        "name": "pin",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Only count the events stored before this time (Unix seconds), to reproduce what a dashboard showed then; replaces as_of",
+                        "name": "as_ingested_before",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "csv",
+                            "jsonl"
+                        ],
+                        "type": "string",
+                        "description": "Response format, json (default), csv or jsonl",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Metrics retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request, unknown dimension or range too long (code range_too_long)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "The role of the API key cannot group or filter by a column (code column_denied)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics/async": {
+            "post": {
+                "description": "Queue a metric query with the parameters of GET /metrics and return its job id right away, for month-long scans that would exceed HTTP timeouts.\nPoll GET /metrics/async/{id} for the result. Jobs are kept in memory by the instance they were submitted to, for an hour once finished.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Metrics"
+                ],
+                "summary": "Submit an async metric query",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are queried",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Metric query",
+                        "name": "query",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Metric job submitted",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricJobResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricJobResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "The role of the API key cannot group or filter by a column (code column_denied)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricJobResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricJobResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Too many metric jobs",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricJobResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics/async/{id}": {
+            "get": {
+                "description": "Return the status of a metric job (queued, running, succeeded, failed or canceled), with the metrics once it succeeded.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Metrics"
+                ],
+                "summary": "Get an async metric query",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant that submitted the job",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Metric job retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricJobResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Metric job not found",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricJobResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Cancel a queued or running metric job, aborting its ClickHouse query. Finished jobs are left as they are.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Metrics"
+                ],
+                "summary": "Cancel an async metric query",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant that submitted the job",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Metric job canceled",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricJobResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Metric job not found",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricJobResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics/batch": {
+            "post": {
+                "description": "Run up to 50 metric queries, each with the parameters of GET /metrics, concurrently with bounded parallelism.\nResults are returned in the order of the queries with their index. A failed query does not fail the others:\nthe batch is answered with 200 and success false, the failed results carry their error message.\nWith pin true the batch is pinned to the current ingestion watermark, returned as as_of, so that all queries count\nthe same snapshot; as_of pins it to an earlier watermark. Queries with their own as_of keep it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Metrics"
+                ],
+                "summary": "Batch of metric queries",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are queried",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Metric queries",
+                        "name": "queries",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricBatchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Results of the queries",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricBatchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricBatchResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "The role of the API key cannot group or filter by a column (code column_denied)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricBatchResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to read the ingestion watermark of a pinned batch",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricBatchResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics/diff": {
+            "get": {
+                "description": "Compare the metrics of two windows for the same filters and grouping, e.g. the days before and after a release.\nEach bucket of either window is reported with its counts in both, their deltas and its status: common, new (only in the compared window)\nor disappeared (only in the base window). Windows are bounded like the range of GET /metrics and counted without realtime counts and new users.\nTime buckets differ between the windows, diffs are grouped by a field or not at all.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Metrics"
+                ],
+                "summary": "GET metrics diff between two windows",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Start of the base window (Unix seconds)",
+                        "name": "base_from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "End of the base window (Unix seconds)",
+                        "name": "base_to",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Start of the compared window (Unix seconds)",
+                        "name": "compare_from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "End of the compared window (Unix seconds)",
+                        "name": "compare_to",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Event name filter",
+                        "name": "event_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only events with this tag, and the tags under it if it ends with /",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Group by field (channel, campaign_id, user_id, event_name, tags)",
+                        "name": "group_by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "With group_by=tags, roll the tags up to their first levels",
+                        "name": "tag_depth",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Leave out the events whose abuse score (0-100) is above it",
+                        "name": "max_abuse_score",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are queried",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Metrics compared successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricDiffResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request or window too long (code range_too_long)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricDiffResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "The role of the API key cannot group or filter by a column (code column_denied)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricDiffResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.MetricDiffResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics/forecast": {
+            "get": {
+                "description": "Fits an additive Holt-Winters model on the event counts of the last complete buckets and forecasts the next ones, for capacity planning and alert baselines. Hourly counts have a daily season and daily counts a weekly one.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Metrics"
+                ],
+                "summary": "GET event count forecast",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name filter",
+                        "name": "event_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only events with this tag",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bucket size, hour or day (default: day)",
+                        "name": "group_by",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Past buckets the model is fitted on, at least two seasons (default: eight seasons)",
+                        "name": "history",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Future buckets to forecast (default: one season)",
+                        "name": "horizon",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are queried",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Forecast computed successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ForecastResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ForecastResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "The role of the API key cannot group or filter by a column (code column_denied)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ForecastResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ForecastResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics/intervals": {
+            "get": {
+                "description": "Histogram and quantiles of the time between consecutive events of each user, computed with window functions over the events ordered by time. With from_event and to_event, only the intervals where to_event directly follows from_event are measured, ignoring events of other names.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Metrics"
+                ],
+                "summary": "GET inter-event intervals",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event the intervals start at, requires to_event",
+                        "name": "from_event",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Event the intervals end at, requires from_event",
+                        "name": "to_event",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Start timestamp (Unix seconds), defaults to 7 days before to",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "End timestamp (Unix seconds)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are queried",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Intervals retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.IntervalResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.IntervalResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "The role of the API key cannot group or filter by a column (code column_denied)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.IntervalResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.IntervalResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics/pacing": {
+            "get": {
+                "description": "Report the spend of each campaign with events in a budget period against its budget, read from a dimension keyed by campaign_id.\nThe spend is the sum of a numeric metadata field of the events from the start of the period until now. Pacing is the fraction of the budget\nspent divided by the elapsed fraction of the period: above 1.1 a campaign is overpacing, below 0.9 underpacing, and campaigns without a\nnumeric budget are reported as no_budget. The elapsed part of the period is bounded like the range of GET /metrics.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Metrics"
+                ],
+                "summary": "GET budget pacing of campaigns",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Dimension keyed by campaign_id holding the budgets",
+                        "name": "dimension",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Attribute of the dimension holding the budget of the period (default: budget)",
+                        "name": "budget",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Numeric metadata field of the spend of an event (default: metadata.spend)",
+                        "name": "spend_field",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Start of the budget period (Unix seconds)",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "End of the budget period (Unix seconds), may be in the future",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Event name filter, e.g. the event carrying the spend",
+                        "name": "event_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only events with this tag, and the tags under it if it ends with /",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Leave out the events whose abuse score (0-100) is above it",
+                        "name": "max_abuse_score",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are queried",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Pacing computed successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.PacingResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request, unknown dimension or budget attribute, or period too long (code range_too_long)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.PacingResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "The role of the API key cannot group or filter by a column (code column_denied)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.PacingResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.PacingResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics/realtime": {
+            "get": {
+                "description": "Per-minute event counts by event name and channel for the last minutes, served from this instance's memory without querying ClickHouse. Events are counted when accepted, before they are flushed. Requires EVENT_REALTIME_AGGREGATION_ENABLED.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Metrics"
+                ],
+                "summary": "GET realtime metrics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name filter",
+                        "name": "event_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Channel filter",
+                        "name": "channel",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of last minutes to return, defaults to the whole window",
+                        "name": "minutes",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are queried",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Realtime metrics retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RealtimeMetricResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RealtimeMetricResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "The role of the API key cannot group or filter by a column (code column_denied)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RealtimeMetricResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Realtime aggregation is disabled",
+                        "schema": {
+                            "$ref": "#/definitions/domain.RealtimeMetricResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/metrics/watermark": {
+            "get": {
+                "description": "Report the latest ingested_at of the tenant's stored events (flushed_until) and the earliest event time of its events accepted by this instance\nand not yet flushed (earliest_pending), so ETL jobs know how fresh the data is before extracting. Pending events are per instance,\nask every instance or drain them first for a cluster-wide bound.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Metrics"
+                ],
+                "summary": "Ingestion watermark",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are reported",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Ingestion watermark retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WatermarkResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid tenant",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WatermarkResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WatermarkResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/pixel.gif": {
+            "get": {
+                "description": "Track an event from the query string of an image request, e.g. an \u003cimg\u003e tag in an email or web page. channel defaults to web, campaign_id to none and a missing or future timestamp to the time the event is received. Metadata values are strings. The 1x1 transparent GIF is returned in any case, the status code tells whether the event was accepted.",
+                "produces": [
+                    "image/gif"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Tracking pixel",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name",
+                        "name": "event_name",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User id",
+                        "name": "user_id",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Channel, web by default",
+                        "name": "channel",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Campaign id, none by default",
+                        "name": "campaign_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unix seconds, or milliseconds (auto-detected), or an RFC 3339 date-time",
+                        "name": "timestamp",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma separated tags",
+                        "name": "tags",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Metadata value of key, any parameter starting with meta.",
+                        "name": "meta.key",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant of the event, as images cannot send the X-Tenant-ID header",
+                        "name": "tenant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client token, as images cannot send the X-Client-Token header",
+                        "name": "token",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Event accepted",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid event",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "429": {
+                        "description": "Monthly quota of the tenant exceeded",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "503": {
+                        "description": "Service unavailable (buffer full)",
+                        "schema": {
+                            "type": "file"
+                        }
+                    }
+                }
+            }
+        },
+        "/schemas": {
+            "get": {
+                "description": "List the JSON Schemas the metadata of events is validated against, by event name.\nThe schemas are served from the cache of the instance, which reloads them within a second of a change on any instance.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Schemas"
+                ],
+                "summary": "List event schemas",
+                "responses": {
+                    "200": {
+                        "description": "Event schemas retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Schemas are read from EVENT_SCHEMA_FILE",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Register the JSON Schema the metadata of the events of a name is validated against.\nThe schema must compile; it applies to the events ingested by every instance within a second.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Schemas"
+                ],
+                "summary": "Create an event schema",
+                "parameters": [
+                    {
+                        "description": "Event name and its JSON Schema",
+                        "name": "schema",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Event schema created successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Event name already has a schema or schemas are read from EVENT_SCHEMA_FILE",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/schemas/{event_name}": {
+            "get": {
+                "description": "Get the JSON Schema the metadata of the events of a name is validated against",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Schemas"
+                ],
+                "summary": "Get an event schema",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name",
+                        "name": "event_name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Event schemas retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Event name has no schema",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Schemas are read from EVENT_SCHEMA_FILE",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Replace the JSON Schema the metadata of the events of a name is validated against.\nThe schema must compile; it applies to the events ingested by every instance within a second.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Schemas"
+                ],
+                "summary": "Update an event schema",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name",
+                        "name": "event_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "JSON Schema of the event name, whose event_name is taken from the path",
+                        "name": "schema",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Event schema updated successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Event name has no schema",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Schemas are read from EVENT_SCHEMA_FILE",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Remove the JSON Schema of an event name, whose events are no longer validated",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Schemas"
+                ],
+                "summary": "Delete an event schema",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event name",
+                        "name": "event_name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Event schema deleted successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Event name has no schema",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Schemas are read from EVENT_SCHEMA_FILE",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.EventSchemaResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tokens": {
+            "post": {
+                "description": "Issue a short-lived signed token for a web or mobile client, to be requested by the client's backend so that the API key never reaches browsers.\nClients send it in the X-Client-Token header, or the token query parameter for /pixel.gif and /beacon, and their events are attributed to this API key and tenant.\nWith replay protection, every request with the token carries X-Request-Timestamp (Unix seconds), a single-use X-Request-Nonce and X-Request-Signature,\nthe hex encoded HMAC-SHA256 of \"\u003ctimestamp\u003e.\u003cnonce\u003e\" keyed with the returned signing_key (or the ts, nonce and sig query parameters).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Events"
+                ],
+                "summary": "Issue a client token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key of the producer",
+                        "name": "X-API-Key",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant of the client's events, with AUTH_ENABLED=1 only the one the API key is bound to or any for admin keys",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Token lifetime",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ClientTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Token issued successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ClientTokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ClientTokenResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing API key",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ClientTokenResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "Client tokens are not enabled",
+                        "schema": {
+                            "$ref": "#/definitions/domain.ClientTokenResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/usage": {
+            "get": {
+                "description": "Report the number of events and bytes ingested and stored per channel and event name since this instance started, to identify which producers drive storage costs\nThe events received today (UTC) by all instances per API key and channel are reported under duplicates with the share of duplicates among them, to identify producers whose retries resend stored events",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Usage"
+                ],
+                "summary": "Usage per channel and event name",
+                "responses": {
+                    "200": {
+                        "description": "Usage retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UsageResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UsageResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/usage/quota": {
+            "get": {
+                "description": "Report the events and bytes stored for a tenant in the current month (UTC) against its monthly caps",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Usage"
+                ],
+                "summary": "Monthly quota of a tenant",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant whose quota is reported",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Quota retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.QuotaResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid tenant",
+                        "schema": {
+                            "$ref": "#/definitions/domain.QuotaResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.QuotaResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/summary": {
+            "get": {
+                "description": "First and last event time and event count of a user, maintained by a materialized view on the events table. Events still in the buffer are not included.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "GET user summary",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant whose events are queried",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "User summary retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UserSummaryResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UserSummaryResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing API key, when authentication is enabled",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UserSummaryResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "The role of the API key cannot group or filter by a column (code column_denied)",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UserSummaryResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UserSummaryResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.UserSummaryResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/version": {
+            "get": {
+                "description": "Return the build information and which optional features are enabled on this instance, so clients can detect capabilities",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Health"
+                ],
+                "summary": "Build information and features",
+                "responses": {
+                    "200": {
+                        "description": "Build information and features",
+                        "schema": {
+                            "$ref": "#/definitions/domain.VersionResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks": {
+            "get": {
+                "description": "Retrieve the webhook registered for this API key, without its secret",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhooks"
+                ],
+                "summary": "Get the lifecycle webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key of the producer",
+                        "name": "X-API-Key",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Webhook retrieved successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WebhookResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing API key",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WebhookResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WebhookResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Register a callback notified when batches containing events sent with this API key are flushed or dead-lettered.\nReplaces any existing webhook of the key. Notifications are signed with the returned secret in the X-Webhook-Signature header (sha256=HMAC-SHA256 of the body).\nThe URL must resolve to public addresses only, private, loopback and link-local ones are rejected.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhooks"
+                ],
+                "summary": "Register a lifecycle webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key of the producer",
+                        "name": "X-API-Key",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Webhook",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.WebhookRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Webhook registered successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WebhookResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WebhookResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing API key",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WebhookResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WebhookResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Stop notifying the webhook registered for this API key",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhooks"
+                ],
+                "summary": "Delete the lifecycle webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key of the producer",
+                        "name": "X-API-Key",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Webhook deleted successfully",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WebhookResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing API key",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WebhookResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/domain.WebhookResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "buildinfo.Info": {
+            "type": "object",
+            "properties": {
+                "buildDate": {
+                    "type": "string",
+                    "example": "2025-11-22T10:00:00Z"
+                },
+                "commit": {
+                    "type": "string",
+                    "example": "abc123def456"
+                },
+                "goVersion": {
+                    "type": "string",
+                    "example": "go1.25.4"
+                },
+                "hostname": {
+                    "type": "string",
+                    "example": "app-server-01"
+                },
+                "pod": {
+                    "$ref": "#/definitions/buildinfo.Pod"
+                },
+                "uptime": {
+                    "type": "integer",
+                    "example": 3600000000000
+                },
+                "version": {
+                    "type": "string",
+                    "example": "v1.0.0"
+                }
+            }
+        },
+        "buildinfo.Pod": {
+            "type": "object",
+            "properties": {
+                "ip": {
+                    "type": "string",
+                    "example": "10.0.1.57"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "clickhouse-events-7d9c5b8f6-x2k4p"
+                },
+                "namespace": {
+                    "type": "string",
+                    "example": "analytics"
+                },
+                "node": {
+                    "type": "string",
+                    "example": "ip-10-0-1-23.ec2.internal"
+                }
+            }
+        },
+        "domain.APIKey": {
+            "type": "object",
+            "properties": {
+                "configured": {
+                    "description": "Configured keys are set in AUTH_API_KEYS and cannot be deleted at runtime",
+                    "type": "boolean",
+                    "example": false
+                },
+                "created_at": {
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "daily_events": {
+                    "type": "integer",
+                    "example": 1000000
+                },
+                "events_today": {
+                    "description": "EventsToday is the number of events of the key stored in the current UTC day, only set in listings",
+                    "type": "integer",
+                    "example": 42000
+                },
+                "name": {
+                    "type": "string",
+                    "example": "checkout-service"
+                },
+                "producer": {
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015"
+                },
+                "rate_limit": {
+                    "description": "RateLimit and DailyEvents override the configured limits of the key, 0 keeps them",
+                    "type": "number",
+                    "example": 50
+                },
+                "role": {
+                    "description": "Role is producer, consumer or admin, empty for producer",
+                    "type": "string",
+                    "example": "producer"
+                },
+                "tenant": {
+                    "description": "Tenant binds the requests of the key to a tenant, X-Tenant-ID is ignored for them. Empty lets requests choose their tenant.",
+                    "type": "string",
+                    "example": "acme"
+                }
+            }
+        },
+        "domain.APIKeyRequest": {
+            "type": "object",
+            "properties": {
+                "daily_events": {
+                    "description": "events per UTC day, the default if 0",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 1000000
+                },
+                "name": {
+                    "type": "string",
+                    "example": "checkout-service"
+                },
+                "producer": {
+                    "description": "Producer is the producer id of an existing key to allow, a new key is generated if empty",
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015"
+                },
+                "rate_limit": {
+                    "description": "requests per second on each instance, the default if 0",
+                    "type": "number",
+                    "minimum": 0,
+                    "example": 50
+                },
+                "role": {
+                    "description": "Role is producer, consumer or admin, the one of AUTH_KEY_ROLES if empty",
+                    "type": "string",
+                    "example": "consumer"
+                },
+                "tenant": {
+                    "description": "Tenant binds the requests of the key to a tenant, the one of AUTH_KEY_TENANTS if empty",
+                    "type": "string",
+                    "example": "acme"
+                }
+            }
+        },
+        "domain.APIKeysResponse": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "description": "Key is the generated API key, only returned once when it is created",
+                    "type": "string",
+                    "example": "ak_3f1c9a0e5b7d42c8a6e1f0b9d3c7a5e2"
+                },
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.APIKey"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "API keys retrieved successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.AggregateConsumerGroup": {
+            "type": "object",
+            "properties": {
+                "consumers": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "lag": {
+                    "description": "not delivered yet, -1 if Redis cannot tell",
+                    "type": "integer",
+                    "example": 120
+                },
+                "name": {
+                    "type": "string",
+                    "example": "billing"
+                },
+                "pending": {
+                    "description": "delivered but not acknowledged",
+                    "type": "integer",
+                    "example": 5
+                }
+            }
+        },
+        "domain.AggregatePublisherResponse": {
+            "type": "object",
+            "properties": {
+                "consumer_groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.AggregateConsumerGroup"
+                    }
+                },
+                "dropped": {
+                    "description": "too many in flight or held",
+                    "type": "integer",
+                    "example": 0
+                },
+                "failed": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "held": {
+                    "description": "aggregates held while paused, published once resumed",
+                    "type": "integer",
+                    "example": 0
+                },
+                "in_flight": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "key": {
+                    "type": "string",
+                    "example": "clickhouse_flush_aggregates"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Aggregate publisher retrieved successfully"
+                },
+                "mode": {
+                    "type": "string",
+                    "example": "stream"
+                },
+                "paused": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "publish_lag_seconds": {
+                    "description": "between the flush and the publication of the last aggregate",
+                    "type": "number",
+                    "example": 0.004
+                },
+                "published": {
+                    "type": "integer",
+                    "example": 1200
+                },
+                "stream_length": {
+                    "description": "Downstream consumers, the stream and its consumer groups in stream mode, the subscribers in pubsub mode",
+                    "type": "integer",
+                    "example": 10000
+                },
+                "subscribers": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.BackfillPartition": {
+            "type": "object",
+            "properties": {
+                "day": {
+                    "description": "partition of the events table, toYYYYMMDD(timestamp)",
+                    "type": "string",
+                    "example": "20240115"
+                },
+                "done": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "events": {
+                    "type": "integer",
+                    "example": 250000
+                },
+                "skipped": {
+                    "description": "events skipped as already stored",
+                    "type": "integer",
+                    "example": 12
+                },
+                "written": {
+                    "description": "events written or skipped as duplicates, the checkpoint the partition resumes from",
+                    "type": "integer",
+                    "example": 120000
+                }
+            }
+        },
+        "domain.BackfillResponse": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "description": "events of the chunk received",
+                    "type": "integer",
+                    "example": 500
+                },
+                "created_at": {
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "error": {
+                    "description": "why the job failed",
+                    "type": "string"
+                },
+                "errors": {
+                    "description": "Errors are the invalid events of a rejected chunk by line, up to BULK_MAX_VALIDATION_ERRORS",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.EventViolation"
+                    }
+                },
+                "events": {
+                    "type": "integer",
+                    "example": 1000000
+                },
+                "finished_at": {
+                    "type": "integer",
+                    "example": 1732233720
+                },
+                "job_id": {
+                    "type": "string",
+                    "example": "import-2024"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Backfill chunk received"
+                },
+                "partitions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.BackfillPartition"
+                    }
+                },
+                "skipped": {
+                    "type": "integer",
+                    "example": 12
+                },
+                "started_at": {
+                    "type": "integer",
+                    "example": 1732233601
+                },
+                "status": {
+                    "description": "receiving, running, succeeded or failed",
+                    "type": "string",
+                    "example": "running"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "written": {
+                    "type": "integer",
+                    "example": 250000
+                }
+            }
+        },
+        "domain.BulkEventRequest": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.EventRequest"
+                    }
+                }
+            }
+        },
+        "domain.BulkEventResponse": {
+            "type": "object",
+            "properties": {
+                "enqueued": {
+                    "description": "Enqueued is set when the events were handed to the batcher and are flushed in the background, answered with 202",
+                    "type": "boolean",
+                    "example": false
+                },
+                "errors": {
+                    "description": "Errors are the invalid events of a rejected request, up to BULK_MAX_VALIDATION_ERRORS",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.EventViolation"
+                    }
+                },
+                "failure_count": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Bulk events posted successfully"
+                },
+                "quarantined_count": {
+                    "description": "accepted but quarantined by data quality rules, included in success_count",
+                    "type": "integer",
+                    "example": 0
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "success_count": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "total_count": {
+                    "type": "integer",
+                    "example": 100
+                }
+            }
+        },
+        "domain.BulkLimitsResponse": {
+            "type": "object",
+            "properties": {
+                "max_events": {
+                    "description": "per /events/bulk request and /events/stream chunk",
+                    "type": "integer",
+                    "example": 10000
+                },
+                "max_validation_errors": {
+                    "description": "invalid events reported per rejected request",
+                    "type": "integer",
+                    "example": 10
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Bulk limits retrieved successfully"
+                },
+                "producer": {
+                    "description": "identifies the API key in PRODUCER_BULK_MAX_EVENTS",
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015"
+                },
+                "source": {
+                    "description": "default, tenant or producer",
+                    "type": "string",
+                    "example": "default"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "tenant": {
+                    "type": "string",
+                    "example": "acme"
+                }
+            }
+        },
+        "domain.CampaignPacing": {
+            "type": "object",
+            "properties": {
+                "budget": {
+                    "type": "number",
+                    "example": 5000
+                },
+                "campaign_id": {
+                    "type": "string",
+                    "example": "summer_sale_2025"
+                },
+                "pacing": {
+                    "description": "Pacing is Spent divided by the elapsed fraction of the period, 1 when the budget is spent evenly",
+                    "type": "number",
+                    "example": 1.2
+                },
+                "projected_spend": {
+                    "description": "ProjectedSpend is the spend at the end of the period at the current rate",
+                    "type": "number",
+                    "example": 6000
+                },
+                "spend": {
+                    "type": "number",
+                    "example": 3000
+                },
+                "spend_per_event": {
+                    "type": "number",
+                    "example": 0.25
+                },
+                "spent": {
+                    "description": "Spent is the fraction of the budget spent",
+                    "type": "number",
+                    "example": 0.6
+                },
+                "status": {
+                    "description": "on_track, overpacing, underpacing or no_budget",
+                    "type": "string",
+                    "example": "overpacing"
+                },
+                "total_events": {
+                    "type": "integer",
+                    "example": 12000
+                }
+            }
+        },
+        "domain.CatalogCoverageEvent": {
+            "type": "object",
+            "properties": {
+                "event_name": {
+                    "type": "string",
+                    "example": "add_to_wishlist"
+                },
+                "events": {
+                    "type": "integer",
+                    "example": 340
+                },
+                "last_seen": {
+                    "type": "integer",
+                    "example": 1732147200
+                }
+            }
+        },
+        "domain.CatalogCoverageResponse": {
+            "type": "object",
+            "properties": {
+                "days": {
+                    "type": "integer",
+                    "example": 30
+                },
+                "documented_event_names": {
+                    "type": "integer",
+                    "example": 38
+                },
+                "event_coverage": {
+                    "description": "share of the observed events whose name is documented",
+                    "type": "number",
+                    "example": 0.97
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Catalog coverage retrieved successfully"
+                },
+                "observed_event_names": {
+                    "description": "ObservedEventNames are the event names with events in the last days, DocumentedEventNames the ones of them documented",
+                    "type": "integer",
+                    "example": 42
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "undocumented": {
+                    "description": "Undocumented are the observed event names without documentation, most frequent first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.CatalogCoverageEvent"
+                    }
+                },
+                "unobserved": {
+                    "description": "Unobserved are the documented event names without events in the last days",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "legacy_signup"
+                    ]
+                }
+            }
+        },
+        "domain.CatalogEntry": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string",
+                    "example": "A completed checkout"
+                },
+                "event_name": {
+                    "type": "string",
+                    "example": "purchase"
+                },
+                "expected_metadata": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "order_id",
+                        "amount"
+                    ]
+                },
+                "observed": {
+                    "$ref": "#/definitions/domain.CatalogObservation"
+                },
+                "owner": {
+                    "type": "string",
+                    "example": "checkout-team"
+                },
+                "updated_at": {
+                    "type": "integer",
+                    "example": 1732233600
+                }
+            }
+        },
+        "domain.CatalogEntryRequest": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string",
+                    "example": "A completed checkout"
+                },
+                "expected_metadata": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "order_id",
+                        "amount"
+                    ]
+                },
+                "owner": {
+                    "type": "string",
+                    "example": "checkout-team"
+                }
+            }
+        },
+        "domain.CatalogObservation": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "type": "integer",
+                    "example": 1200
+                },
+                "last_seen": {
+                    "description": "start of the last ingestion day with events",
+                    "type": "integer",
+                    "example": 1732147200
+                },
+                "missing_metadata": {
+                    "description": "MissingMetadata are the expected keys no event carried, UndocumentedMetadata the keys carried but not expected",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "coupon"
+                    ]
+                },
+                "undocumented_metadata": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "currency"
+                    ]
+                }
+            }
+        },
+        "domain.CatalogResponse": {
+            "type": "object",
+            "properties": {
+                "days": {
+                    "type": "integer",
+                    "example": 30
+                },
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.CatalogEntry"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Event catalog retrieved successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.ClientTokenRequest": {
+            "type": "object",
+            "properties": {
+                "ttl_seconds": {
+                    "description": "lifetime of the token, the configured default if 0",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 900
+                }
+            }
+        },
+        "domain.ClientTokenResponse": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "description": "Unix seconds",
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "signing_key": {
+                    "description": "SigningKey signs the timestamp and nonce of the token's requests, only when replay protection is enabled",
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "domain.ColumnCompression": {
+            "type": "object",
+            "properties": {
+                "compressed_bytes": {
+                    "type": "integer",
+                    "example": 1048576
+                },
+                "compression_codec": {
+                    "type": "string",
+                    "example": "CODEC(Delta(4), ZSTD(1))"
+                },
+                "compression_ratio": {
+                    "type": "number",
+                    "example": 8
+                },
+                "name": {
+                    "type": "string",
+                    "example": "timestamp"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "DateTime"
+                },
+                "uncompressed_bytes": {
+                    "type": "integer",
+                    "example": 8388608
+                }
+            }
+        },
+        "domain.ColumnCompressionResponse": {
+            "type": "object",
+            "properties": {
+                "columns": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.ColumnCompression"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Column compression retrieved successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.ColumnStats": {
+            "type": "object",
+            "properties": {
+                "cardinality": {
+                    "description": "estimated distinct values",
+                    "type": "integer",
+                    "example": 12
+                },
+                "low_cardinality": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "name": {
+                    "type": "string",
+                    "example": "channel"
+                },
+                "recommendation": {
+                    "type": "string",
+                    "example": "Fewer than 10000 distinct values, LowCardinality(String) would compress better"
+                },
+                "top_values": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.ValueCount"
+                    }
+                },
+                "type": {
+                    "type": "string",
+                    "example": "LowCardinality(String)"
+                }
+            }
+        },
+        "domain.ColumnStatsResponse": {
+            "type": "object",
+            "properties": {
+                "columns": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.ColumnStats"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Column statistics retrieved successfully"
+                },
+                "since": {
+                    "description": "Unix seconds, start of the events",
+                    "type": "integer",
+                    "example": 1732147200
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "total_events": {
+                    "type": "integer",
+                    "example": 1500000
+                }
+            }
+        },
+        "domain.DeadLetter": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer",
+                    "example": 4
+                },
+                "dead_lettered_at": {
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "error": {
+                    "type": "string",
+                    "example": "failed to columnar insert events: dial tcp: connection refused"
+                },
+                "event": {
+                    "$ref": "#/definitions/domain.EventRequest"
+                },
+                "id": {
+                    "description": "event id, as in events_raw",
+                    "type": "string",
+                    "example": "3f2a9c0d5b7e4a1c8d6f0e2b4a9c7d51"
+                },
+                "producer": {
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015"
+                },
+                "source": {
+                    "type": "string",
+                    "example": "bulk"
+                },
+                "tenant": {
+                    "type": "string",
+                    "example": "acme"
+                }
+            }
+        },
+        "domain.DeadLetterResponse": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.DeadLetter"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Dead-lettered events retrieved successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "total": {
+                    "description": "dead-lettered events of the tenant, including the ones beyond the limit",
+                    "type": "integer",
+                    "example": 1200
+                }
+            }
+        },
+        "domain.Dimension": {
+            "type": "object",
+            "properties": {
+                "attributes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "owner",
+                        "budget"
+                    ]
+                },
+                "bytes_allocated": {
+                    "type": "integer",
+                    "example": 16384
+                },
+                "key": {
+                    "type": "string",
+                    "example": "campaign_id"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "last_update": {
+                    "description": "Unix time of the last successful load",
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "lifetime_seconds": {
+                    "description": "refresh interval, 0 = only on reload",
+                    "type": "integer",
+                    "example": 3600
+                },
+                "name": {
+                    "type": "string",
+                    "example": "campaigns"
+                },
+                "rows": {
+                    "type": "integer",
+                    "example": 120
+                },
+                "source": {
+                    "type": "string",
+                    "example": "HTTP: https://example.com/campaigns.csv"
+                },
+                "status": {
+                    "description": "Status of the dictionary as reported by ClickHouse, e.g. LOADED, NOT_LOADED, LOADING or FAILED",
+                    "type": "string",
+                    "example": "LOADED"
+                }
+            }
+        },
+        "domain.DimensionListResponse": {
+            "type": "object",
+            "properties": {
+                "dimensions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.Dimension"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Dimensions retrieved successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.DimensionResponse": {
+            "type": "object",
+            "properties": {
+                "dimension": {
+                    "$ref": "#/definitions/domain.Dimension"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Dimension uploaded successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.DimensionURLRequest": {
+            "type": "object",
+            "properties": {
+                "columns": {
+                    "description": "key column first, then the attributes",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "campaign_id",
+                        "owner",
+                        "budget"
+                    ]
+                },
+                "format": {
+                    "description": "CSVWithNames (default), TSVWithNames or JSONEachRow",
+                    "type": "string",
+                    "example": "CSVWithNames"
+                },
+                "lifetime_seconds": {
+                    "description": "refresh interval, 0 = only on reload (default: 3600)",
+                    "type": "integer",
+                    "example": 3600
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://example.com/campaigns.csv"
+                }
+            }
+        },
+        "domain.DuplicateEntry": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "type": "string",
+                    "example": "mobile"
+                },
+                "duplicate_ratio": {
+                    "type": "number",
+                    "example": 0.25
+                },
+                "duplicates": {
+                    "type": "integer",
+                    "example": 250
+                },
+                "producer": {
+                    "description": "hash of the API key, empty without one",
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015"
+                },
+                "received_events": {
+                    "type": "integer",
+                    "example": 1000
+                }
+            }
+        },
         "domain.EventRequest": {
             "type": "object",
             "properties": {
-                "campaign_id": {
+                "campaign_id": {
+                    "type": "string",
+                    "example": "summer_sale_2025"
+                },
+                "channel": {
+                    "type": "string",
+                    "example": "web"
+                },
+                "event_name": {
+                    "type": "string",
+                    "example": "purchase"
+                },
+                "metadata": {
+                    "type": "object"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "mobile",
+                        "premium"
+                    ]
+                },
+                "timestamp": {
+                    "description": "Unix seconds, or milliseconds (auto-detected), or an RFC 3339 string",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 1732233600
+                },
+                "timestamp_ms": {
+                    "description": "Unix milliseconds, takes precedence over timestamp",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 1732233600123
+                },
+                "user_id": {
+                    "type": "string",
+                    "example": "user123"
+                }
+            }
+        },
+        "domain.EventResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "Event posted successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.EventSchema": {
+            "type": "object",
+            "properties": {
+                "event_name": {
+                    "type": "string",
+                    "example": "purchase"
+                },
+                "schema": {
+                    "type": "object"
+                },
+                "updated_at": {
+                    "type": "string",
+                    "example": "2025-01-01T00:00:00Z"
+                }
+            }
+        },
+        "domain.EventSchemaRequest": {
+            "type": "object",
+            "properties": {
+                "event_name": {
+                    "type": "string",
+                    "example": "purchase"
+                },
+                "schema": {
+                    "type": "object"
+                }
+            }
+        },
+        "domain.EventSchemaResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "Event schemas retrieved successfully"
+                },
+                "schemas": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.EventSchema"
+                    }
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.EventTransform": {
+            "type": "object",
+            "properties": {
+                "remove": {
+                    "description": "metadata fields to drop",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "metadata.debug"
+                    ]
+                },
+                "rename": {
+                    "description": "source field -\u003e target field",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    },
+                    "example": {
+                        "metadata.amount": "metadata.price"
+                    }
+                },
+                "set": {
+                    "description": "field -\u003e value",
+                    "type": "object"
+                }
+            }
+        },
+        "domain.EventViolation": {
+            "type": "object",
+            "properties": {
+                "index": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "message": {
+                    "type": "string",
+                    "example": "user_id is required"
+                }
+            }
+        },
+        "domain.ExportResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "why the export was rejected",
+                    "type": "string",
+                    "example": "range_too_long"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Validation failed: format must be parquet"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": false
+                }
+            }
+        },
+        "domain.ExpressionResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "e.g. a missing metadata key, the event does not match",
+                    "type": "string",
+                    "example": ""
+                },
+                "matched": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.ExpressionTestRequest": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "description": "evaluated with the tenant of the request, at most 100",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.EventRequest"
+                    }
+                },
+                "expression": {
+                    "type": "string",
+                    "example": "event_name == 'purchase' \u0026\u0026 metadata.price \u003e 100.0"
+                }
+            }
+        },
+        "domain.ExpressionTestResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "why the expression does not compile",
+                    "type": "string",
+                    "example": ""
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Expression is valid"
+                },
+                "results": {
+                    "description": "in the order of the sample events",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.ExpressionResult"
+                    }
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "valid": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.FeatureFlag": {
+            "type": "object",
+            "properties": {
+                "default": {
+                    "description": "value from the environment",
+                    "type": "boolean",
+                    "example": false
+                },
+                "description": {
+                    "type": "string",
+                    "example": "Count unique users approximately with uniq instead of uniqExact"
+                },
+                "enabled": {
+                    "description": "effective value",
+                    "type": "boolean",
+                    "example": true
+                },
+                "name": {
+                    "type": "string",
+                    "example": "approx_unique"
+                },
+                "overridden": {
+                    "description": "whether a runtime override is set",
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.FeatureFlagRequest": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.FeatureFlagsResponse": {
+            "type": "object",
+            "properties": {
+                "flags": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.FeatureFlag"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Feature flags retrieved successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.ForecastPoint": {
+            "type": "object",
+            "properties": {
+                "bucket": {
+                    "description": "start of the bucket in UTC",
+                    "type": "string",
+                    "example": "2025-11-22 10:00:00"
+                },
+                "lower": {
+                    "description": "lower bound of the 95% prediction interval",
+                    "type": "number",
+                    "example": 1210
+                },
+                "total_events": {
+                    "type": "number",
+                    "example": 1234.5
+                },
+                "upper": {
+                    "description": "upper bound of the 95% prediction interval",
+                    "type": "number",
+                    "example": 1259
+                }
+            }
+        },
+        "domain.ForecastResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "why the query was rejected",
+                    "type": "string",
+                    "example": "column_denied"
+                },
+                "forecast": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.ForecastPoint"
+                    }
+                },
+                "group_by": {
+                    "type": "string",
+                    "example": "hour"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Forecast computed successfully"
+                },
+                "rmse": {
+                    "description": "root mean squared error of the one-step predictions on the history",
+                    "type": "number",
+                    "example": 12.5
+                },
+                "season": {
+                    "description": "buckets per season",
+                    "type": "integer",
+                    "example": 24
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.HealthResponse": {
+            "type": "object",
+            "properties": {
+                "buildInfo": {
+                    "$ref": "#/definitions/buildinfo.Info"
+                },
+                "ingestion": {
+                    "$ref": "#/definitions/domain.IngestionHealth"
+                },
+                "services": {
+                    "$ref": "#/definitions/domain.ServiceHealthStatus"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "healthy"
+                },
+                "timestamp": {
+                    "type": "string",
+                    "example": "2025-11-22T10:00:00Z"
+                }
+            }
+        },
+        "domain.IngestionHealth": {
+            "type": "object",
+            "properties": {
+                "dead_letter_events": {
+                    "description": "events waiting in the dead-letter queue of ClickHouse, -1 if it could not be read",
+                    "type": "integer",
+                    "example": 0
+                },
+                "buffer_utilization_percent": {
+                    "type": "number",
+                    "example": 12.5
+                },
+                "message": {
+                    "type": "string",
+                    "example": ""
+                },
+                "pending_events": {
+                    "description": "events in the buffer, the overflow queue and the batch being built",
+                    "type": "integer",
+                    "example": 6250
+                },
+                "seconds_since_last_flush": {
+                    "type": "number",
+                    "example": 0.8
+                },
+                "status": {
+                    "type": "string",
+                    "example": "healthy"
+                }
+            }
+        },
+        "domain.IntervalBucket": {
+            "type": "object",
+            "properties": {
+                "bucket": {
+                    "type": "string",
+                    "example": "1m-10m"
+                },
+                "count": {
+                    "type": "integer",
+                    "example": 1200
+                },
+                "max_seconds": {
+                    "description": "exclusive, omitted for the last bucket",
+                    "type": "number",
+                    "example": 600
+                },
+                "min_seconds": {
+                    "type": "number",
+                    "example": 60
+                }
+            }
+        },
+        "domain.IntervalResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "why the query was rejected",
+                    "type": "string",
+                    "example": "column_denied"
+                },
+                "histogram": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.IntervalBucket"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Intervals retrieved successfully"
+                },
+                "p50": {
+                    "description": "median interval in seconds",
+                    "type": "number",
+                    "example": 42.5
+                },
+                "p90": {
+                    "type": "number",
+                    "example": 3600
+                },
+                "p99": {
+                    "type": "number",
+                    "example": 86400
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "total": {
+                    "description": "number of intervals",
+                    "type": "integer",
+                    "example": 5000
+                }
+            }
+        },
+        "domain.MetricBatchRequest": {
+            "type": "object",
+            "properties": {
+                "as_of": {
+                    "description": "AsOf and Pin apply to the queries without their own as_of, so that all of them count the same snapshot",
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "pin": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "queries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.MetricRequest"
+                    }
+                }
+            }
+        },
+        "domain.MetricBatchResponse": {
+            "type": "object",
+            "properties": {
+                "as_of": {
+                    "description": "AsOf is the ingestion watermark the batch was pinned to",
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "code": {
+                    "description": "why the query was rejected",
+                    "type": "string",
+                    "example": "column_denied"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Metrics retrieved successfully"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.MetricBatchResult"
+                    }
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.MetricBatchResult": {
+            "type": "object",
+            "properties": {
+                "as_of": {
+                    "description": "AsOf is the ingestion watermark the query was pinned to, pass it to the next queries to count the same snapshot",
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "code": {
+                    "description": "why the query was rejected",
+                    "type": "string",
+                    "example": "range_too_long"
+                },
+                "from": {
+                    "description": "From is the Unix time the query started from when from was omitted, the default range before to",
+                    "type": "integer",
+                    "example": 1731628800
+                },
+                "index": {
+                    "description": "position of the query in the batch",
+                    "type": "integer",
+                    "example": 0
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Metrics retrieved successfully"
+                },
+                "metrics": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.MetricResult"
+                    }
+                },
+                "realtime_from": {
+                    "description": "RealtimeFrom is the Unix time from which total_events were counted in memory instead of ClickHouse,\nunique_users do not include those events. Only set with the hybrid_metrics flag.",
+                    "type": "integer",
+                    "example": 1732233300
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.MetricDiff": {
+            "type": "object",
+            "properties": {
+                "base_events": {
+                    "type": "integer",
+                    "example": 1200
+                },
+                "base_users": {
+                    "type": "integer",
+                    "example": 300
+                },
+                "bucket": {
+                    "type": "string",
+                    "example": "mobile"
+                },
+                "compare_events": {
+                    "type": "integer",
+                    "example": 1500
+                },
+                "compare_users": {
+                    "type": "integer",
+                    "example": 280
+                },
+                "events_change": {
+                    "description": "EventsChange is the relative change of the events in percent, omitted for new buckets",
+                    "type": "number",
+                    "example": 25
+                },
+                "events_delta": {
+                    "type": "integer",
+                    "example": 300
+                },
+                "status": {
+                    "description": "common, new or disappeared",
+                    "type": "string",
+                    "example": "common"
+                },
+                "users_delta": {
+                    "type": "integer",
+                    "example": -20
+                }
+            }
+        },
+        "domain.MetricDiffResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "why the query was rejected",
+                    "type": "string",
+                    "example": "range_too_long"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Metrics compared successfully"
+                },
+                "metrics": {
+                    "description": "in bucket order",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.MetricDiff"
+                    }
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.MetricJobResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "why the query was rejected",
+                    "type": "string",
+                    "example": "column_denied"
+                },
+                "created_at": {
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "error": {
+                    "description": "why the job failed",
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "integer",
+                    "example": 1732233720
+                },
+                "job_id": {
+                    "type": "string",
+                    "example": "3f1c9a7be04d5e6f8a9b0c1d2e3f4a5b"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Metric job submitted"
+                },
+                "result": {
+                    "$ref": "#/definitions/domain.MetricResponse"
+                },
+                "started_at": {
+                    "type": "integer",
+                    "example": 1732233601
+                },
+                "status": {
+                    "description": "queued, running, succeeded, failed or canceled",
+                    "type": "string",
+                    "example": "running"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.MetricRequest": {
+            "type": "object",
+            "properties": {
+                "annotate_anomalies": {
+                    "description": "AnnotateAnomalies scores the events of each bucket of an hour or day grouping against the history of the series",
+                    "type": "boolean",
+                    "example": false
+                },
+                "as_of": {
+                    "description": "AsOf pins the query to an ingestion watermark (Unix seconds), only events stored at or before it are counted",
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "enrich": {
+                    "description": "dimension whose attributes are added to the buckets",
+                    "type": "string",
+                    "example": "campaigns"
+                },
+                "event_name": {
+                    "type": "string",
+                    "example": "purchase"
+                },
+                "from": {
+                    "type": "integer",
+                    "example": 1732147200
+                },
+                "group_by": {
+                    "description": "e.g., \"channel\" or \"timestamp\"",
+                    "type": "string",
+                    "example": "channel"
+                },
+                "max_abuse_score": {
+                    "description": "MaxAbuseScore leaves out the events whose abuse score is above it",
+                    "type": "integer",
+                    "example": 49
+                },
+                "pin": {
+                    "description": "Pin pins the query to the current ingestion watermark when as_of is omitted, returned as as_of",
+                    "type": "boolean",
+                    "example": false
+                },
+                "tag": {
+                    "description": "only events with this tag, and the tags under it if it ends with TagSeparator",
+                    "type": "string",
+                    "example": "premium"
+                },
+                "tag_depth": {
+                    "description": "TagDepth rolls the buckets of group_by=tags up to the first levels of the hierarchical tags",
+                    "type": "integer",
+                    "example": 2
+                },
+                "to": {
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "top_k": {
+                    "description": "TopK only returns the approximate top buckets by events, estimated in a single pass for long ranges",
+                    "type": "integer",
+                    "example": 10
+                }
+            }
+        },
+        "domain.MetricResponse": {
+            "type": "object",
+            "properties": {
+                "as_of": {
+                    "description": "AsOf is the ingestion watermark the query was pinned to, pass it to the next queries to count the same snapshot",
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "code": {
+                    "description": "why the query was rejected",
+                    "type": "string",
+                    "example": "range_too_long"
+                },
+                "from": {
+                    "description": "From is the Unix time the query started from when from was omitted, the default range before to",
+                    "type": "integer",
+                    "example": 1731628800
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Metrics retrieved successfully"
+                },
+                "metrics": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.MetricResult"
+                    }
+                },
+                "realtime_from": {
+                    "description": "RealtimeFrom is the Unix time from which total_events were counted in memory instead of ClickHouse,\nunique_users do not include those events. Only set with the hybrid_metrics flag.",
+                    "type": "integer",
+                    "example": 1732233300
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "top_k": {
+                    "description": "TopK describes the accuracy of the estimated buckets of a top_k query",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/domain.TopKAccuracy"
+                        }
+                    ]
+                }
+            }
+        },
+        "domain.MetricResult": {
+            "type": "object",
+            "properties": {
+                "anomalous": {
+                    "description": "Anomalous flags the buckets whose anomaly score is beyond 3.5 either way",
+                    "type": "boolean",
+                    "example": true
+                },
+                "anomaly_score": {
+                    "description": "AnomalyScore is the modified z-score of TotalEvents against the same bucket of the previous seasons, only set with annotate_anomalies",
+                    "type": "number",
+                    "example": 4.2
+                },
+                "attributes": {
+                    "description": "Attributes of the bucket from the dimension given by enrich, empty for keys unknown to the dimension",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "bucket": {
+                    "description": "The \"Bucket\" holds the group name (e.g., \"2024-08-25 10:00:00\" or \"mobile\")",
+                    "type": "string"
+                },
+                "count_error": {
+                    "description": "CountError is the largest overestimate of TotalEvents, only set for top_k queries",
+                    "type": "integer"
+                },
+                "expected_events": {
+                    "description": "ExpectedEvents is the median of TotalEvents in the same bucket of the previous seasons, only set with annotate_anomalies",
+                    "type": "number",
+                    "example": 1180
+                },
+                "new_users": {
+                    "description": "NewUsers counts the users whose first event falls in the bucket, only set for time groupings without filters",
+                    "type": "integer"
+                },
+                "total_events": {
+                    "type": "integer"
+                },
+                "unique_users": {
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.PacingResponse": {
+            "type": "object",
+            "properties": {
+                "campaigns": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.CampaignPacing"
+                    }
+                },
+                "code": {
+                    "description": "why the query was rejected",
+                    "type": "string",
+                    "example": "range_too_long"
+                },
+                "elapsed": {
+                    "description": "Elapsed is the fraction of the budget period elapsed, from 0 to 1",
+                    "type": "number",
+                    "example": 0.5
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Pacing computed successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.PreStopResponse": {
+            "type": "object",
+            "properties": {
+                "elapsed_seconds": {
+                    "type": "number",
+                    "example": 5.3
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Buffered events flushed"
+                },
+                "pending_events": {
+                    "description": "events not flushed when the drain ended",
+                    "type": "integer",
+                    "example": 0
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.QuarantineResponse": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.QuarantinedEvent"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Quarantined events retrieved successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.QuarantinedEvent": {
+            "type": "object",
+            "properties": {
+                "event": {
+                    "$ref": "#/definitions/domain.EventRequest"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "3f2a9c0d5b7e4a1c8d6f0e2b4a9c7d51"
+                },
+                "quarantined_at": {
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "status": {
+                    "type": "string",
+                    "example": "quarantined"
+                },
+                "updated_at": {
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "violations": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "metadata.price must be \u003e= 0"
+                    ]
+                }
+            }
+        },
+        "domain.QuotaResponse": {
+            "type": "object",
+            "properties": {
+                "byte_limit": {
+                    "description": "0 = unlimited",
+                    "type": "integer",
+                    "example": 0
+                },
+                "event_limit": {
+                    "description": "0 = unlimited",
+                    "type": "integer",
+                    "example": 1000000
+                },
+                "exceeded": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Quota retrieved successfully"
+                },
+                "mode": {
+                    "description": "what happens to events beyond the cap, reject or sample",
+                    "type": "string",
+                    "example": "reject"
+                },
+                "month": {
+                    "type": "string",
+                    "example": "2025-11"
+                },
+                "stored_bytes": {
+                    "type": "integer",
+                    "example": 150000000
+                },
+                "stored_events": {
+                    "type": "integer",
+                    "example": 750000
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "tenant": {
+                    "type": "string",
+                    "example": "acme"
+                }
+            }
+        },
+        "domain.RealtimeMetric": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "type": "string",
+                    "example": "web"
+                },
+                "event_name": {
+                    "type": "string",
+                    "example": "purchase"
+                },
+                "minute": {
+                    "description": "start of the minute in UTC",
+                    "type": "string",
+                    "example": "2025-11-22 10:05:00"
+                },
+                "total_events": {
+                    "type": "integer",
+                    "example": 42
+                }
+            }
+        },
+        "domain.RealtimeMetricResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "why the query was rejected",
+                    "type": "string",
+                    "example": "column_denied"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Realtime metrics retrieved successfully"
+                },
+                "metrics": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.RealtimeMetric"
+                    }
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "window_minutes": {
+                    "type": "integer",
+                    "example": 5
+                }
+            }
+        },
+        "domain.ReprocessRequest": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "3f2a9c0d5b7e4a1c8d6f0e2b4a9c7d51"
+                    ]
+                },
+                "source": {
+                    "description": "quarantine, dead_letter or raw, quarantine if empty",
+                    "type": "string",
+                    "example": "quarantine"
+                },
+                "sink": {
+                    "description": "Sink whose dead-lettered events are written to it again as they are, without transform, validation or ingestion,\ne.g. kafka; with source dead_letter only, the events ClickHouse failed to store if empty",
+                    "type": "string",
+                    "example": "kafka"
+                },
+                "transform": {
+                    "description": "optional rewrite applied before validation",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/domain.EventTransform"
+                        }
+                    ]
+                }
+            }
+        },
+        "domain.ReprocessResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "Quarantined events reprocessed"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.ReprocessResult"
+                    }
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.ReprocessResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "3f2a9c0d5b7e4a1c8d6f0e2b4a9c7d51"
+                },
+                "status": {
+                    "description": "reprocessed, quarantined, not_found or failed",
+                    "type": "string",
+                    "example": "reprocessed"
+                },
+                "violations": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "domain.RewriteRule": {
+            "type": "object",
+            "properties": {
+                "backfill": {
+                    "description": "Backfill is the status of the rewrite of the stored events, empty if they are not rewritten",
+                    "type": "string",
+                    "example": "done"
+                },
+                "backfill_error": {
+                    "type": "string",
+                    "example": ""
+                },
+                "backfill_rows": {
+                    "description": "stored events rewritten",
+                    "type": "integer",
+                    "example": 12000
+                },
+                "backfilled_at": {
+                    "type": "integer",
+                    "example": 1732233900
+                },
+                "created_at": {
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "field": {
+                    "type": "string",
+                    "example": "event_name"
+                },
+                "from": {
+                    "type": "string",
+                    "example": "checkout_complete"
+                },
+                "tenant": {
+                    "type": "string",
+                    "example": "acme"
+                },
+                "to": {
+                    "type": "string",
+                    "example": "purchase"
+                }
+            }
+        },
+        "domain.RewriteRuleRequest": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "description": "event_name or channel",
+                    "type": "string",
+                    "example": "event_name"
+                },
+                "from": {
+                    "type": "string",
+                    "example": "checkout_complete"
+                },
+                "retroactive": {
+                    "description": "Retroactive rewrites the events stored before the rule in the background as well",
+                    "type": "boolean",
+                    "example": true
+                },
+                "to": {
+                    "type": "string",
+                    "example": "purchase"
+                }
+            }
+        },
+        "domain.RewriteRulesResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "Rewrite rules retrieved successfully"
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.RewriteRule"
+                    }
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.SLOBurnRate": {
+            "type": "object",
+            "properties": {
+                "burn_rate": {
+                    "type": "number",
+                    "example": 0.5
+                },
+                "good_requests": {
+                    "type": "integer",
+                    "example": 3998
+                },
+                "requests": {
+                    "type": "integer",
+                    "example": 4000
+                },
+                "sli": {
+                    "type": "number",
+                    "example": 99.95
+                },
+                "window": {
+                    "type": "string",
+                    "example": "1h"
+                }
+            }
+        },
+        "domain.SLOResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "SLO retrieved successfully"
+                },
+                "objectives": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.SLOStatus"
+                    }
+                },
+                "since": {
+                    "description": "start of the instance, requests before it are not counted",
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.SLOStatus": {
+            "type": "object",
+            "properties": {
+                "budget_remaining": {
+                    "description": "BudgetRemaining is the share of the error budget of the period left, negative once overspent",
+                    "type": "number",
+                    "example": 0.5
+                },
+                "burn_rates": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.SLOBurnRate"
+                    }
+                },
+                "good_requests": {
+                    "type": "integer",
+                    "example": 999500
+                },
+                "latency_ms": {
+                    "type": "integer",
+                    "example": 50
+                },
+                "period_hours": {
+                    "description": "PeriodHours is the length of the rolling window the budget is spent over",
+                    "type": "integer",
+                    "example": 720
+                },
+                "requests": {
+                    "type": "integer",
+                    "example": 1000000
+                },
+                "risk": {
+                    "type": "string",
+                    "example": "none"
+                },
+                "route": {
+                    "type": "string",
+                    "example": "/events"
+                },
+                "sli": {
+                    "description": "percentage of good requests over the period, 100 without requests",
+                    "type": "number",
+                    "example": 99.95
+                },
+                "target": {
+                    "description": "percentage of good requests",
+                    "type": "number",
+                    "example": 99.9
+                }
+            }
+        },
+        "domain.SchemaDriftChange": {
+            "type": "object",
+            "properties": {
+                "baseline_events": {
+                    "description": "baseline events carrying the key",
+                    "type": "integer",
+                    "example": 0
+                },
+                "baseline_frequency": {
+                    "type": "number",
+                    "example": 0
+                },
+                "event_name": {
+                    "type": "string",
+                    "example": "purchase"
+                },
+                "first_seen": {
+                    "description": "first ingestion day of the key since the baseline start",
+                    "type": "string",
+                    "example": "2025-11-10"
+                },
+                "key": {
+                    "type": "string",
+                    "example": "coupon_code"
+                },
+                "last_seen": {
+                    "type": "string",
+                    "example": "2025-11-15"
+                },
+                "recent_events": {
+                    "description": "recent events carrying the key",
+                    "type": "integer",
+                    "example": 1200
+                },
+                "recent_frequency": {
+                    "description": "share of the recent events of the name carrying the key",
+                    "type": "number",
+                    "example": 0.35
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "new",
+                        "disappeared",
+                        "changed"
+                    ],
+                    "example": "new"
+                }
+            }
+        },
+        "domain.SchemaDriftResponse": {
+            "type": "object",
+            "properties": {
+                "baseline_since": {
                     "type": "string",
-                    "example": "summer_sale_2025"
+                    "example": "2025-10-12"
                 },
-                "channel": {
+                "changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.SchemaDriftChange"
+                    }
+                },
+                "message": {
                     "type": "string",
-                    "example": "web"
+                    "example": "Schema drift retrieved successfully"
                 },
-                "event_name": {
+                "recent_since": {
                     "type": "string",
-                    "example": "purchase"
+                    "example": "2025-11-09"
                 },
-                "metadata": {
-                    "type": "object"
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "domain.SchemaMigrationStatus": {
+            "type": "object",
+            "properties": {
+                "applied": {
+                    "type": "boolean",
+                    "example": true
                 },
-                "tags": {
+                "applied_at": {
+                    "type": "integer",
+                    "example": 1732233600
+                },
+                "applied_by": {
+                    "type": "string",
+                    "example": "clickhouse-events-7d9c5b8f6-x2k4p"
+                },
+                "columns": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     },
                     "example": [
-                        "mobile",
-                        "premium"
+                        "abuse_score UInt8 DEFAULT 0"
                     ]
                 },
-                "timestamp": {
-                    "type": "integer",
-                    "minimum": 0,
-                    "example": 1732233600
-                },
-                "user_id": {
+                "name": {
                     "type": "string",
-                    "example": "user123"
+                    "example": "abuse_score"
+                },
+                "version": {
+                    "type": "integer",
+                    "example": 2
                 }
             }
         },
-        "domain.EventResponse": {
+        "domain.SchemaMigrationsResponse": {
             "type": "object",
             "properties": {
+                "latest_version": {
+                    "description": "version the running code writes",
+                    "type": "integer",
+                    "example": 2
+                },
                 "message": {
                     "type": "string",
-                    "example": "Event posted successfully"
+                    "example": "Schema migrations retrieved successfully"
+                },
+                "migrations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.SchemaMigrationStatus"
+                    }
+                },
+                "mode": {
+                    "type": "string",
+                    "example": "auto"
                 },
                 "success": {
                     "type": "boolean",
                     "example": true
+                },
+                "version": {
+                    "description": "highest applied migration",
+                    "type": "integer",
+                    "example": 2
                 }
             }
         },
-        "domain.HealthResponse": {
+        "domain.ServiceHealthStatus": {
             "type": "object",
             "properties": {
-                "buildInfo": {
-                    "$ref": "#/definitions/buildinfo.Info"
+                "clickhouse": {
+                    "$ref": "#/definitions/domain.ServiceStatus"
                 },
-                "services": {
-                    "$ref": "#/definitions/domain.ServiceHealthStatus"
+                "parts": {
+                    "description": "Parts is \"slowed\" or \"paused\" while the parts guard holds inserts back, absent if it is disabled",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/domain.ServiceStatus"
+                        }
+                    ]
+                },
+                "redis": {
+                    "$ref": "#/definitions/domain.ServiceStatus"
+                },
+                "schema": {
+                    "description": "\"pending\" while schema migrations wait to be applied",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/domain.ServiceStatus"
+                        }
+                    ]
+                }
+            }
+        },
+        "domain.ServiceStatus": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": ""
                 },
                 "status": {
                     "type": "string",
                     "example": "healthy"
+                }
+            }
+        },
+        "domain.StreamEventResponse": {
+            "type": "object",
+            "properties": {
+                "acknowledged": {
+                    "type": "boolean",
+                    "example": true
                 },
-                "timestamp": {
+                "checkpoint": {
                     "type": "string",
-                    "example": "2025-11-22T10:00:00Z"
+                    "example": "offset-1024"
+                },
+                "count": {
+                    "type": "integer",
+                    "example": 500
+                },
+                "errors": {
+                    "description": "Errors are the invalid events of a rejected chunk by line, up to BULK_MAX_VALIDATION_ERRORS",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.EventViolation"
+                    }
+                },
+                "message": {
+                    "type": "string",
+                    "example": "Stream chunk flushed and checkpoint acknowledged"
+                },
+                "quarantined_count": {
+                    "description": "QuarantinedCount events of the chunk were quarantined by data quality rules, they count as acknowledged",
+                    "type": "integer",
+                    "example": 0
+                },
+                "stream_id": {
+                    "type": "string",
+                    "example": "device-42"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
                 }
             }
         },
-        "domain.MetricResponse": {
+        "domain.TopKAccuracy": {
             "type": "object",
             "properties": {
-                "message": {
+                "k": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "max_count_error": {
+                    "description": "largest count_error of the buckets, 0 if their counts are exact",
+                    "type": "integer",
+                    "example": 120
+                },
+                "note": {
                     "type": "string",
-                    "example": "Metrics retrieved successfully"
+                    "example": "Buckets are estimated in a single pass"
+                }
+            }
+        },
+        "domain.UsageEntry": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "type": "string",
+                    "example": "web"
                 },
-                "metrics": {
+                "event_name": {
+                    "type": "string",
+                    "example": "purchase"
+                },
+                "ingested_events": {
+                    "type": "integer",
+                    "example": 1000
+                },
+                "ingested_raw_bytes": {
+                    "type": "integer",
+                    "example": 250000
+                },
+                "stored_bytes": {
+                    "type": "integer",
+                    "example": 120000
+                },
+                "stored_events": {
+                    "type": "integer",
+                    "example": 990
+                }
+            }
+        },
+        "domain.UsageResponse": {
+            "type": "object",
+            "properties": {
+                "duplicates": {
+                    "description": "Duplicates are the events received today (UTC) by all instances per API key and channel, the highest duplicate ratios first",
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/domain.MetricResult"
+                        "$ref": "#/definitions/domain.DuplicateEntry"
                     }
                 },
+                "message": {
+                    "type": "string",
+                    "example": "Usage retrieved successfully"
+                },
                 "success": {
                     "type": "boolean",
                     "example": true
+                },
+                "usage": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/domain.UsageEntry"
+                    }
                 }
             }
         },
-        "domain.MetricResult": {
+        "domain.UserSummary": {
             "type": "object",
             "properties": {
-                "bucket": {
-                    "description": "The \"Bucket\" holds the group name (e.g., \"2024-08-25 10:00:00\" or \"mobile\")",
-                    "type": "string"
+                "first_seen": {
+                    "description": "Unix seconds of the user's first event",
+                    "type": "integer",
+                    "example": 1732147200
+                },
+                "last_seen": {
+                    "description": "Unix seconds of the user's last event",
+                    "type": "integer",
+                    "example": 1732233600
                 },
                 "total_events": {
-                    "type": "integer"
+                    "type": "integer",
+                    "example": 42
                 },
-                "unique_users": {
-                    "type": "integer"
+                "user_id": {
+                    "type": "string",
+                    "example": "user123"
                 }
             }
         },
-        "domain.ServiceHealthStatus": {
+        "domain.UserSummaryResponse": {
             "type": "object",
             "properties": {
-                "clickhouse": {
-                    "$ref": "#/definitions/domain.ServiceStatus"
+                "code": {
+                    "description": "why the query was rejected",
+                    "type": "string",
+                    "example": "column_denied"
                 },
-                "redis": {
-                    "$ref": "#/definitions/domain.ServiceStatus"
+                "message": {
+                    "type": "string",
+                    "example": "User summary retrieved successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "user": {
+                    "$ref": "#/definitions/domain.UserSummary"
                 }
             }
         },
-        "domain.ServiceStatus": {
+        "domain.ValueCount": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "description": "approximate",
+                    "type": "integer",
+                    "example": 900000
+                },
+                "share": {
+                    "description": "of the events, carrying the tag for tags",
+                    "type": "number",
+                    "example": 0.6
+                },
+                "value": {
+                    "type": "string",
+                    "example": "web"
+                }
+            }
+        },
+        "domain.VersionResponse": {
+            "type": "object",
+            "properties": {
+                "buildInfo": {
+                    "$ref": "#/definitions/buildinfo.Info"
+                },
+                "features": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "boolean"
+                    },
+                    "example": {
+                        "user_sequence": true
+                    }
+                }
+            }
+        },
+        "domain.WatermarkResponse": {
             "type": "object",
             "properties": {
+                "earliest_pending": {
+                    "description": "EarliestPending is the earliest event time (Unix seconds) of the events accepted by the instance and not yet flushed, omitted if none are pending",
+                    "type": "integer",
+                    "example": 1732233590
+                },
+                "flushed_until": {
+                    "description": "FlushedUntil is the latest ingested_at of the stored events (Unix seconds), omitted if none are stored",
+                    "type": "integer",
+                    "example": 1732233600
+                },
                 "message": {
                     "type": "string",
-                    "example": ""
+                    "example": "Ingestion watermark retrieved successfully"
                 },
-                "status": {
+                "pending_events": {
+                    "type": "integer",
+                    "example": 120
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "tenant": {
                     "type": "string",
-                    "example": "healthy"
+                    "example": "acme"
+                }
+            }
+        },
+        "domain.Webhook": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "batch.flushed",
+                        "batch.dead_lettered"
+                    ]
+                },
+                "secret": {
+                    "description": "HMAC-SHA256 key of the X-Webhook-Signature header, only returned on registration",
+                    "type": "string",
+                    "example": "4f9c..."
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://producer.example.com/hooks/clickhouse"
+                }
+            }
+        },
+        "domain.WebhookRequest": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "description": "subscribed event types, all if empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "batch.flushed",
+                        "batch.dead_lettered"
+                    ]
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://producer.example.com/hooks/clickhouse"
+                }
+            }
+        },
+        "domain.WebhookResponse": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "Webhook registered successfully"
+                },
+                "success": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "webhook": {
+                    "$ref": "#/definitions/domain.Webhook"
                 }
             }
         }
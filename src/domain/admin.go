@@ -0,0 +1,7 @@
+package domain
+
+import "context"
+
+type AdminService interface {
+	GetColumnCompression(ctx context.Context) (*ColumnCompressionResponse, error)
+}
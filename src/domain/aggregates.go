@@ -0,0 +1,9 @@
+package domain
+
+import "context"
+
+type AggregateService interface {
+	GetAggregatePublisher(ctx context.Context) (*AggregatePublisherResponse, error)
+	PauseAggregates(ctx context.Context) (*AggregatePublisherResponse, error)
+	ResumeAggregates(ctx context.Context) (*AggregatePublisherResponse, error)
+}
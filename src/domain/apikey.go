@@ -0,0 +1,37 @@
+package domain
+
+import "context"
+
+// Roles of API keys
+const (
+	APIKeyRoleProducer = "producer" // ingests events and queries metrics, the default
+	APIKeyRoleConsumer = "consumer" // read-only, its query responses have the fields of RESPONSE_REDACT_FIELDS redacted, e.g. for shared dashboards
+	APIKeyRoleAdmin    = "admin"    // a producer also allowed to call the /admin endpoints
+)
+
+type APIKeyService interface {
+	// Authenticate checks that a producer's API key is known and within its request rate, and for ingestion requests
+	// also within its daily event quota and allowed to ingest. It returns the tenant the key is bound to, if any, and its role.
+	Authenticate(ctx context.Context, producer string, ingestion bool) (tenant, role string, err error)
+	ListAPIKeys(ctx context.Context) (*APIKeysResponse, error)
+	CreateAPIKey(ctx context.Context, request *APIKeyRequest) (*APIKeysResponse, error)
+	DeleteAPIKey(ctx context.Context, producer string) (*APIKeysResponse, error)
+}
+
+// APIKey is an API key allowed to call /events and /metrics, identified by its producer id
+type APIKey struct {
+	Producer string `json:"producer" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	Name     string `json:"name,omitempty" example:"checkout-service"`
+	// RateLimit and DailyEvents override the configured limits of the key, 0 keeps them
+	RateLimit   float64 `json:"rate_limit,omitempty" example:"50"`
+	DailyEvents int64   `json:"daily_events,omitempty" example:"1000000"`
+	// Tenant binds the requests of the key to a tenant, X-Tenant-ID is ignored for them. Empty lets requests choose their tenant.
+	Tenant string `json:"tenant,omitempty" example:"acme"`
+	// Role is producer, consumer or admin, empty for producer
+	Role      string `json:"role,omitempty" example:"producer"`
+	CreatedAt int64  `json:"created_at,omitempty" example:"1732233600"`
+	// Configured keys are set in AUTH_API_KEYS and cannot be deleted at runtime
+	Configured bool `json:"configured,omitempty" example:"false"`
+	// EventsToday is the number of events of the key stored in the current UTC day, only set in listings
+	EventsToday int64 `json:"events_today,omitempty" example:"42000"`
+}
@@ -0,0 +1,26 @@
+package domain
+
+import "context"
+
+// Backfill job statuses
+const (
+	BackfillReceiving = "receiving" // chunks of events are uploaded, nothing is written yet
+	BackfillRunning   = "running"   // the day partitions are being written
+	BackfillSucceeded = "succeeded"
+	BackfillFailed    = "failed" // a partition could not be written, starting the job again resumes it from its checkpoints
+)
+
+type BackfillService interface {
+	AppendBackfill(ctx context.Context, request *BackfillRequest) (*BackfillResponse, error)
+	StartBackfill(ctx context.Context, tenant, id string) (*BackfillResponse, error)
+	GetBackfill(ctx context.Context, tenant, id string) (*BackfillResponse, error)
+}
+
+// BackfillPartition is the progress of a day partition of a backfill job
+type BackfillPartition struct {
+	Day     string `json:"day" example:"20240115"` // partition of the events table, toYYYYMMDD(timestamp)
+	Events  int    `json:"events" example:"250000"`
+	Written int    `json:"written" example:"120000"` // events written or skipped as duplicates, the checkpoint the partition resumes from
+	Skipped int    `json:"skipped" example:"12"`     // events skipped as already stored
+	Done    bool   `json:"done" example:"false"`
+}
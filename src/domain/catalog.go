@@ -0,0 +1,36 @@
+package domain
+
+import "context"
+
+type CatalogService interface {
+	ListCatalog(ctx context.Context, request *CatalogRequest) (*CatalogResponse, error)
+	SetCatalogEntry(ctx context.Context, request *CatalogEntryRequest) (*CatalogResponse, error)
+	DeleteCatalogEntry(ctx context.Context, tenant, eventName string) (*CatalogResponse, error)
+	GetCatalogCoverage(ctx context.Context, request *CatalogRequest) (*CatalogCoverageResponse, error)
+}
+
+// CatalogEntry documents an event name, cross-referenced with the events observed in the last days
+type CatalogEntry struct {
+	EventName        string              `json:"event_name" example:"purchase"`
+	Owner            string              `json:"owner" example:"checkout-team"`
+	Description      string              `json:"description" example:"A completed checkout"`
+	ExpectedMetadata []string            `json:"expected_metadata" example:"order_id,amount"`
+	UpdatedAt        int64               `json:"updated_at" example:"1732233600"`
+	Observed         *CatalogObservation `json:"observed"`
+}
+
+// CatalogObservation compares the documentation of an event name with its events of the last days
+type CatalogObservation struct {
+	Events   uint64 `json:"events" example:"1200"`
+	LastSeen int64  `json:"last_seen,omitempty" example:"1732147200"` // start of the last ingestion day with events
+	// MissingMetadata are the expected keys no event carried, UndocumentedMetadata the keys carried but not expected
+	MissingMetadata      []string `json:"missing_metadata" example:"coupon"`
+	UndocumentedMetadata []string `json:"undocumented_metadata" example:"currency"`
+}
+
+// CatalogCoverageEvent is an observed event name that is not documented
+type CatalogCoverageEvent struct {
+	EventName string `json:"event_name" example:"add_to_wishlist"`
+	Events    uint64 `json:"events" example:"340"`
+	LastSeen  int64  `json:"last_seen" example:"1732147200"`
+}
@@ -0,0 +1,43 @@
+package domain
+
+import "context"
+
+type ClientTokenService interface {
+	IssueToken(ctx context.Context, request *ClientTokenRequest) (*ClientTokenResponse, error)
+	VerifyToken(token string) (*ClientTokenClaims, error)
+	CheckReplay(ctx context.Context, token string, claims *ClientTokenClaims, proof ClientRequestProof) error
+}
+
+// ClientTokenRequest asks for a token letting a web or mobile client send events on behalf of the caller's API key
+type ClientTokenRequest struct {
+	TTLSeconds int `json:"ttl_seconds,omitempty" example:"900" minimum:"0"` // lifetime of the token, the configured default if 0
+
+	// Producer and Tenant are taken from the X-API-Key and X-Tenant-ID headers
+	Producer string `json:"-" swaggerignore:"true"`
+	Tenant   string `json:"-" swaggerignore:"true"`
+}
+
+type ClientTokenResponse struct {
+	Success   bool   `json:"success"`
+	Message   string `json:"message"`
+	Token     string `json:"token,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"` // Unix seconds
+	// SigningKey signs the timestamp and nonce of the token's requests, only when replay protection is enabled
+	SigningKey string `json:"signing_key,omitempty"`
+}
+
+// ClientTokenClaims are the identity a client token grants, signed by the service
+type ClientTokenClaims struct {
+	Producer  string `json:"p"`           // ProducerID of the API key the token was issued for
+	Tenant    string `json:"t,omitempty"` // tenant of the events, empty for the default tenant
+	IssuedAt  int64  `json:"iat"`         // Unix seconds
+	ExpiresAt int64  `json:"exp"`         // Unix seconds
+}
+
+// ClientRequestProof shows that a request with a client token was sent once by the token's holder:
+// Signature is the hex encoded HMAC-SHA256 of "<timestamp>.<nonce>" keyed with the token's signing key
+type ClientRequestProof struct {
+	Timestamp int64 // Unix seconds
+	Nonce     string
+	Signature string
+}
@@ -0,0 +1,37 @@
+package domain
+
+import "context"
+
+// Sinks the events of a flush are written to, see flush.Sink. The optional ones dead-letter the events they fail to
+// write to a queue of their own.
+const (
+	SinkClickHouse          = "clickhouse"           // the events table, whose failures are dead-lettered by the batcher
+	SinkClickHouseSecondary = "clickhouse_secondary" // a copy in a secondary cluster, see services.ClickHouseSink
+	SinkKafka               = "kafka"                // a Kafka topic as outbox of the stored events, see services.KafkaSink
+	SinkS3                  = "s3"                   // an archive of gzipped JSON lines in S3, see services.S3ArchiveSink
+)
+
+type DeadLetterService interface {
+	ListDeadLetters(ctx context.Context, request *DeadLetterRequest) (*DeadLetterResponse, error)
+}
+
+// DeadLetter is an event of a batch whose flush still failed after all retries, kept until it is reprocessed
+type DeadLetter struct {
+	ID             string       `json:"id" example:"3f2a9c0d5b7e4a1c8d6f0e2b4a9c7d51"` // event id, as in events_raw
+	Tenant         string       `json:"tenant,omitempty" example:"acme"`
+	Producer       string       `json:"producer,omitempty" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	Source         string       `json:"source,omitempty" example:"bulk"`
+	Event          EventRequest `json:"event"`
+	Error          string       `json:"error" example:"failed to columnar insert events: dial tcp: connection refused"`
+	Attempts       int          `json:"attempts" example:"4"`
+	DeadLetteredAt int64        `json:"dead_lettered_at" example:"1732233600"`
+}
+
+// Restore returns the event with the attributes assigned at its ingestion
+func (d DeadLetter) Restore() EventRequest {
+	event := d.Event
+	event.Ingest.Tenant = d.Tenant
+	event.Ingest.Producer = d.Producer
+	event.Ingest.Source = d.Source
+	return event
+}
@@ -0,0 +1,15 @@
+package domain
+
+import "context"
+
+// Formats of the files a dimension can be loaded from by URL, all starting with a header row
+var DimensionURLFormats = []string{"CSVWithNames", "TSVWithNames", "JSONEachRow"}
+
+type DimensionService interface {
+	UploadDimension(ctx context.Context, request *DimensionUploadRequest) (*DimensionResponse, error)
+	SetDimensionURL(ctx context.Context, request *DimensionURLRequest) (*DimensionResponse, error)
+	ListDimensions(ctx context.Context) (*DimensionListResponse, error)
+	GetDimension(ctx context.Context, name string) (*DimensionResponse, error)
+	ReloadDimension(ctx context.Context, name string) (*DimensionResponse, error)
+	DeleteDimension(ctx context.Context, name string) (*DimensionResponse, error)
+}
@@ -6,6 +6,37 @@ type EventService interface {
 	PostEvents(ctx context.Context, eventData *EventRequest) (*EventResponse, error)
 	PostEventsBulk(ctx context.Context, bulkData *BulkEventRequest) (*BulkEventResponse, error)
 	GetMetrics(ctx context.Context, metricRequest *MetricRequest) (*MetricResponse, error)
+	StreamMetrics(ctx context.Context, metricRequest *MetricRequest) (MetricStream, error)
+	ExportEvents(ctx context.Context, request *ExportRequest) (EventStream, error)
+	GetMetricsBatch(ctx context.Context, request *MetricBatchRequest) (*MetricBatchResponse, error)
+	GetRealtimeMetrics(ctx context.Context, metricRequest *RealtimeMetricRequest) (*RealtimeMetricResponse, error)
+	GetMetricsDiff(ctx context.Context, request *MetricDiffRequest) (*MetricDiffResponse, error)
+	GetForecast(ctx context.Context, request *ForecastRequest) (*ForecastResponse, error)
+	GetPacing(ctx context.Context, request *PacingRequest) (*PacingResponse, error)
+	GetIntervals(ctx context.Context, request *IntervalRequest) (*IntervalResponse, error)
+	GetUserSummary(ctx context.Context, request *UserSummaryRequest) (*UserSummaryResponse, error)
+	GetSchemaDrift(ctx context.Context, request *SchemaDriftRequest) (*SchemaDriftResponse, error)
+	GetColumnStats(ctx context.Context, request *ColumnStatsRequest) (*ColumnStatsResponse, error)
+	PostEventStream(ctx context.Context, streamData *StreamEventRequest) (*StreamEventResponse, error)
+	GetStreamCheckpoint(ctx context.Context, streamID string) (*StreamEventResponse, error)
+	GetIngestionStats() IngestionStats
+	GetIngestionWatermark(ctx context.Context, tenant string) (*WatermarkResponse, error)
+}
+
+// MetricStream iterates over the buckets of a metrics query as they are read from ClickHouse, it must be closed
+type MetricStream interface {
+	Next() bool
+	Result() MetricResult
+	Err() error
+	Close() error
+}
+
+// EventStream iterates over the events of an export as they are read from ClickHouse, it must be closed
+type EventStream interface {
+	Next() bool
+	Event() ExportedEvent
+	Err() error
+	Close() error
 }
 
 // TODO Health Service
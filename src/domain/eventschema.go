@@ -0,0 +1,21 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type EventSchemaService interface {
+	ListEventSchemas(ctx context.Context, eventName string) (*EventSchemaResponse, error)
+	CreateEventSchema(ctx context.Context, request *EventSchemaRequest) (*EventSchemaResponse, error)
+	UpdateEventSchema(ctx context.Context, request *EventSchemaRequest) (*EventSchemaResponse, error)
+	DeleteEventSchema(ctx context.Context, eventName string) (*EventSchemaResponse, error)
+}
+
+// EventSchema is the JSON Schema the metadata of the events of a name is validated against
+type EventSchema struct {
+	EventName string          `json:"event_name" example:"purchase"`
+	Schema    json.RawMessage `json:"schema" swaggertype:"object"`
+	UpdatedAt time.Time       `json:"updated_at" example:"2025-01-01T00:00:00Z"`
+}
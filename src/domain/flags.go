@@ -0,0 +1,9 @@
+package domain
+
+import "context"
+
+type FeatureFlagService interface {
+	ListFlags(ctx context.Context) (*FeatureFlagsResponse, error)
+	SetFlag(ctx context.Context, name string, enabled bool) (*FeatureFlagsResponse, error)
+	ResetFlag(ctx context.Context, name string) (*FeatureFlagsResponse, error)
+}
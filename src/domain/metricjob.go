@@ -0,0 +1,18 @@
+package domain
+
+import "context"
+
+// Metric job statuses
+const (
+	MetricJobQueued    = "queued"
+	MetricJobRunning   = "running"
+	MetricJobSucceeded = "succeeded"
+	MetricJobFailed    = "failed"
+	MetricJobCanceled  = "canceled"
+)
+
+type MetricJobService interface {
+	SubmitMetricJob(ctx context.Context, metricRequest *MetricRequest) (*MetricJobResponse, error)
+	GetMetricJob(ctx context.Context, tenant, id string) (*MetricJobResponse, error)
+	CancelMetricJob(ctx context.Context, tenant, id string) (*MetricJobResponse, error)
+}
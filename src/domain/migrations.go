@@ -0,0 +1,14 @@
+package domain
+
+import "context"
+
+// Schema migration modes
+const (
+	SchemaMigrationsAuto   = "auto"
+	SchemaMigrationsManual = "manual"
+)
+
+type SchemaService interface {
+	GetMigrations(ctx context.Context) (*SchemaMigrationsResponse, error)
+	ApplyMigrations(ctx context.Context) (*SchemaMigrationsResponse, error)
+}
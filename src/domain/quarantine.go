@@ -0,0 +1,27 @@
+package domain
+
+import "context"
+
+// Results of reprocessing an event
+const (
+	ReprocessReprocessed = "reprocessed" // passed the rules and was ingested
+	ReprocessQuarantined = "quarantined" // still violates the rules, violations were updated
+	ReprocessNotFound    = "not_found"   // no quarantined event with the id, or it was already reprocessed
+	ReprocessFailed      = "failed"      // could not be ingested, e.g. the buffer is full, and stays quarantined
+)
+
+// Sources of events that can be reprocessed
+const (
+	ReprocessSourceQuarantine = "quarantine"
+	ReprocessSourceDeadLetter = "dead_letter"
+	ReprocessSourceRaw        = "raw" // raw payloads in events_raw, by event id
+)
+
+type QuarantineService interface {
+	ListQuarantine(ctx context.Context, request *QuarantineRequest) (*QuarantineResponse, error)
+	ReprocessQuarantine(ctx context.Context, request *ReprocessRequest) (*ReprocessResponse, error)
+}
+
+type ReprocessService interface {
+	Reprocess(ctx context.Context, request *ReprocessRequest) (*ReprocessResponse, error)
+}
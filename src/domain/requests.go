@@ -1,32 +1,533 @@
 package domain
 
-import "strconv"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MillisecondThreshold is the smallest Timestamp interpreted as milliseconds.
+// As seconds it would be a date in the year 5138, as milliseconds it is March 1973.
+const MillisecondThreshold = 100_000_000_000
 
 // EventRequest represents an event to be tracked
 type EventRequest struct {
-	EventName  string         `json:"event_name" example:"purchase"`
-	Channel    string         `json:"channel" example:"web"`
-	CampaignID string         `json:"campaign_id" example:"summer_sale_2025"`
-	UserID     string         `json:"user_id" example:"user123"`
-	Timestamp  int64          `json:"timestamp" example:"1732233600" minimum:"0"`
-	Tags       []string       `json:"tags" example:"mobile,premium"`
-	Metadata   map[string]any `json:"metadata" swaggertype:"object"`
+	EventName   string         `json:"event_name" example:"purchase"`
+	Channel     string         `json:"channel" example:"web"`
+	CampaignID  string         `json:"campaign_id" example:"summer_sale_2025"`
+	UserID      string         `json:"user_id" example:"user123"`
+	Timestamp   int64          `json:"timestamp" example:"1732233600" minimum:"0"`                 // Unix seconds, or milliseconds (auto-detected), or an RFC 3339 string
+	TimestampMS int64          `json:"timestamp_ms,omitempty" example:"1732233600123" minimum:"0"` // Unix milliseconds, takes precedence over timestamp
+	Tags        []string       `json:"tags" example:"mobile,premium"`
+	Metadata    map[string]any `json:"metadata" swaggertype:"object"`
+
+	// Ingest is assigned by the service during ingestion, clients cannot set it
+	Ingest IngestInfo `json:"-" swaggerignore:"true"`
 }
 
-// `event_name, user_id, timestamp, channel` pair as a unique identifier
+// IngestInfo carries attributes of an event assigned by the service at ingestion time
+type IngestInfo struct {
+	RawBytes int    `json:"raw_bytes,omitempty"` // size of the event in the request payload
+	Sequence uint64 `json:"sequence,omitempty"`  // per-user sequence number, 0 if not assigned
+	Producer string `json:"producer,omitempty"`  // ProducerID of the API key that sent the event, empty if none
+	Tenant   string `json:"tenant,omitempty"`    // tenant the event belongs to, empty for the default tenant
+	// Source is the endpoint or adapter the event was received through, one of the Source constants
+	Source string `json:"source,omitempty"`
+
+	// UserAgent is the User-Agent header of the request, Browser whether it came from a pixel, beacon or client token
+	UserAgent string `json:"user_agent,omitempty"`
+	Browser   bool   `json:"browser,omitempty"`
+	// ClientIP is the address of the client of the request, the one forwarded by trusted proxies, empty for the ingestion adapters
+	ClientIP string `json:"client_ip,omitempty"`
+	// AbuseScore from 0 to 100 rates how likely the event is fake or scripted, 0 if abuse scoring is disabled
+	AbuseScore uint8 `json:"abuse_score,omitempty"`
+	// ClampedFromMS is the time the client sent, in Unix milliseconds, of an event whose time was clamped into the accepted window.
+	// The deduplication key is computed from it, so that retries clamped at another time of receipt are still duplicates.
+	ClampedFromMS int64 `json:"clamped_from_ms,omitempty"`
+
+	// Raw is the JSON of the event as received, kept in events_raw if raw payloads are retained
+	Raw []byte `json:"-"`
+	// RequestID is the correlation id of the request that sent the event, logged by the batcher with its flush
+	RequestID string `json:"-"`
+	// Replay marks events re-parsed from their raw payload, they replace the stored version instead of being deduplicated
+	Replay bool `json:"-"`
+	// Ack is the acknowledgment level the producer asked for, one of the Ack constants, empty for AckBuffered
+	Ack string `json:"-"`
+	// Line is the line of the event in the NDJSON body of a stream or backfill chunk, numbered from 1, 0 for other requests
+	Line int `json:"-"`
+}
+
+// Acknowledgment levels of POST /events, chosen per request with ?ack=, trading latency for durability
+const (
+	AckNone     = "none"     // answered 204 right away, the event is ingested in the background and failures are not reported
+	AckBuffered = "buffered" // answered once the event is in the buffer (default)
+	AckFlushed  = "flushed"  // answered once the event is stored in ClickHouse
+)
+
+// Sources of ingested events, stored with them in the source column
+const (
+	SourceEvents    = "events"    // POST /events
+	SourceBulk      = "bulk"      // POST /events/bulk
+	SourceStream    = "stream"    // POST /events/stream
+	SourcePixel     = "pixel"     // GET /pixel.gif
+	SourceBeacon    = "beacon"    // POST /beacon
+	SourceReprocess = "reprocess" // quarantined, dead-lettered or raw events ingested again
+	SourceBackfill  = "backfill"  // POST /events/backfill/{id}, historical events written per day partition
+	SourceCDC       = "cdc"       // row changes of a Postgres database, see services.CDCConsumer
+	SourceKinesis   = "kinesis"   // records of a Kinesis stream, see services.KinesisConsumer
+	SourceSQS       = "sqs"       // messages of an SQS queue, see services.SQSConsumer
+	SourcePubSub    = "pubsub"    // messages of a Google Cloud Pub/Sub subscription, see services.PubSubConsumer
+	SourceKafka     = "kafka"     // messages of a Kafka topic, see services.KafkaConsumer
+	SourceImport    = "import"    // lines of NDJSON files dropped into a directory, see services.FileImporter
+)
+
+// ProducerID derives a stable identifier from an API key, so that keys are never stored or logged
+func ProducerID(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:16])
+}
+
+// EstimatedSize approximates the size of the event when encoded as JSON.
+// It is used to attribute the payload size of bulk requests to the individual events.
+func (e EventRequest) EstimatedSize() int {
+	// field names, quotes and separators of a JSON encoded event
+	const overhead = 110
+	size := overhead + len(e.EventName) + len(e.Channel) + len(e.CampaignID) + len(e.UserID) + 10
+	for _, tag := range e.Tags {
+		size += len(tag) + 3
+	}
+	for key, value := range e.Metadata {
+		size += len(key) + 4
+		if str, ok := value.(string); ok {
+			size += len(str) + 2
+		} else {
+			size += 8
+		}
+	}
+	return size
+}
+
+// `event_name, user_id, timestamp, channel` pair as a unique identifier, scoped to the tenant if any
 func (e EventRequest) GetUniqueKey() string {
-	return e.EventName + "|" + e.UserID + "|" + strconv.FormatInt(e.Timestamp, 10) + "|" + e.Channel
+	eventTime := e.EventTime()
+	if e.Ingest.ClampedFromMS != 0 {
+		eventTime = time.UnixMilli(e.Ingest.ClampedFromMS)
+	}
+	key := e.EventName + "|" + e.UserID + "|" + formatUnixTime(eventTime) + "|" + e.Channel
+	if e.Ingest.Tenant != "" {
+		// Keys of the default tenant stay unprefixed so existing deduplication keys remain valid
+		return e.Ingest.Tenant + "|" + key
+	}
+	return key
+}
+
+// EventID identifies an event by a hash of its unique key, it keys the raw payload of the event in events_raw
+func (e EventRequest) EventID() string {
+	sum := sha256.Sum256([]byte(e.GetUniqueKey()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// EventTime resolves the time of the event with millisecond precision.
+// timestamp_ms is used if present, otherwise timestamp is interpreted as seconds or, if it is too large to be seconds, as milliseconds.
+func (e EventRequest) EventTime() time.Time {
+	if e.TimestampMS > 0 {
+		return time.UnixMilli(e.TimestampMS)
+	}
+	if e.Timestamp >= MillisecondThreshold {
+		return time.UnixMilli(e.Timestamp)
+	}
+	return time.Unix(e.Timestamp, 0)
+}
+
+// timestampLayouts are the layouts of string timestamps, the ones without an offset are in UTC
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	time.DateOnly,
+}
+
+// ParseTimestamp parses a timestamp sent as a string or a fractional number: Unix seconds or milliseconds,
+// told apart like integer timestamps, or an RFC 3339 date-time, in UTC if it has no offset
+func ParseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		if math.IsNaN(number) || math.IsInf(number, 0) {
+			return time.Time{}, fmt.Errorf("timestamp must be a finite number")
+		}
+		if math.Abs(number) >= MillisecondThreshold {
+			return time.UnixMilli(int64(number)), nil
+		}
+		seconds, fraction := math.Modf(number)
+		return time.Unix(int64(seconds), int64(math.Round(fraction*1000))*int64(time.Millisecond)), nil
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("timestamp must be Unix seconds or milliseconds, or an RFC 3339 date-time such as 2024-11-22T00:00:00.123Z")
+}
+
+// SetTime sets the time of the event with millisecond precision, in timestamp_ms too if it is not whole seconds
+// and timestamp_ms is not set, so that integer timestamps keep resolving as before
+func (e *EventRequest) SetTime(t time.Time) {
+	e.Timestamp = t.Unix()
+	if ms := t.UnixMilli(); ms%1000 != 0 && e.TimestampMS == 0 {
+		e.TimestampMS = ms
+	}
+}
+
+// UnmarshalJSON decodes an event, accepting timestamps sent as strings or fractional numbers besides integers
+func (e *EventRequest) UnmarshalJSON(data []byte) error {
+	type event EventRequest
+	decoded := struct {
+		*event
+		Timestamp json.RawMessage `json:"timestamp"`
+	}{event: (*event)(e)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	raw := decoded.Timestamp
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	// Integers are kept as sent, milliseconds included, so that the validation and deduplication keys see them unchanged
+	if timestamp, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+		e.Timestamp = timestamp
+		return nil
+	}
+	value := string(raw)
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+	}
+	t, err := ParseTimestamp(value)
+	if err != nil {
+		return err
+	}
+	e.SetTime(t)
+	return nil
+}
+
+// ShiftTime moves the time of the event by d, in timestamp and timestamp_ms alike
+func (e *EventRequest) ShiftTime(d time.Duration) {
+	if e.TimestampMS > 0 {
+		e.TimestampMS += d.Milliseconds()
+	}
+	if e.Timestamp >= MillisecondThreshold {
+		e.Timestamp += d.Milliseconds()
+	} else if e.Timestamp > 0 {
+		e.Timestamp += int64(d / time.Second)
+	}
+}
+
+// formatUnixTime formats whole seconds as before millisecond support, so that existing deduplication keys stay valid
+func formatUnixTime(t time.Time) string {
+	ms := t.UnixMilli()
+	if ms%1000 == 0 {
+		return strconv.FormatInt(ms/1000, 10)
+	}
+	return strconv.FormatInt(ms/1000, 10) + "." + fmt.Sprintf("%03d", ms%1000)
 }
 
 // MetricRequest represents a query for aggregated metrics
 type MetricRequest struct {
 	EventName *string `json:"event_name" example:"purchase"`
+	Tag       *string `json:"tag" example:"premium"` // only events with this tag, and the tags under it if it ends with TagSeparator
 	From      *int64  `json:"from" example:"1732147200"`
 	To        *int64  `json:"to" example:"1732233600"`
 	GroupBy   *string `json:"group_by" example:"channel"` // e.g., "channel" or "timestamp"
+	Enrich    *string `json:"enrich" example:"campaigns"` // dimension whose attributes are added to the buckets
+	// TagDepth rolls the buckets of group_by=tags up to the first levels of the hierarchical tags
+	TagDepth *int `json:"tag_depth" example:"2"`
+	// TopK only returns the approximate top buckets by events, estimated in a single pass for long ranges
+	TopK *int `json:"top_k" example:"10"`
+	// AnnotateAnomalies scores the events of each bucket of an hour or day grouping against the history of the series
+	AnnotateAnomalies bool `json:"annotate_anomalies" example:"false"`
+	// MaxAbuseScore leaves out the events whose abuse score is above it
+	MaxAbuseScore *int `json:"max_abuse_score" example:"49"`
+	// AsOf pins the query to an ingestion watermark (Unix seconds), only events stored at or before it are counted
+	AsOf *int64 `json:"as_of" example:"1732233600"`
+	// Pin pins the query to the current ingestion watermark when as_of is omitted, returned as as_of
+	Pin bool `json:"pin" example:"false"`
+
+	// EnrichAttributes are the attributes of the Enrich dimension, set by the service
+	EnrichAttributes []string `json:"-" swaggerignore:"true"`
+	// ApproxUnique counts unique users with uniq instead of uniqExact, set by the service from the approx_unique flag
+	ApproxUnique bool `json:"-" swaggerignore:"true"`
+	// Tenant whose events are queried, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+	// Role of the API key of the request, the columns denied to it cannot be grouped or filtered by
+	Role string `json:"-" swaggerignore:"true"`
+}
+
+// TagSeparator separates the levels of hierarchical tags, e.g. category/electronics/phones
+const TagSeparator = "/"
+
+// TagPrefix returns the tag of a tag filter ending with TagSeparator, which matches the events with the tag or a tag
+// under it, e.g. category/electronics/ matches category/electronics and category/electronics/phones
+func TagPrefix(filter string) (string, bool) {
+	if !strings.HasSuffix(filter, TagSeparator) {
+		return "", false
+	}
+	return strings.TrimSuffix(filter, TagSeparator), true
+}
+
+// MetricBatchRequest holds the metric queries of a dashboard, run concurrently
+type MetricBatchRequest struct {
+	Queries []MetricRequest `json:"queries"`
+	// AsOf and Pin apply to the queries without their own as_of, so that all of them count the same snapshot
+	AsOf *int64 `json:"as_of" example:"1732233600"`
+	Pin  bool   `json:"pin" example:"true"`
+}
+
+// Formats of the event exports
+const (
+	ExportFormatParquet = "parquet"
+)
+
+// ExportRequest selects the events streamed by GET /events/export
+type ExportRequest struct {
+	Format string `json:"format" example:"parquet"`
+	// Filter holds the filters of the events, the ones of GET /metrics; grouping and enrichment do not apply
+	Filter MetricRequest `json:"-" swaggerignore:"true"`
+}
+
+// MetricDiffRequest compares the metrics of two windows of GET /metrics/diff, e.g. the days before and after a release
+type MetricDiffRequest struct {
+	BaseFrom    int64 `json:"base_from" example:"1732060800"`
+	BaseTo      int64 `json:"base_to" example:"1732147199"`
+	CompareFrom int64 `json:"compare_from" example:"1732147200"`
+	CompareTo   int64 `json:"compare_to" example:"1732233599"`
+	// Filter holds the filters and the grouping of both windows, the ones of GET /metrics; its range is set per window
+	Filter MetricRequest `json:"-" swaggerignore:"true"`
+}
+
+// PacingRequest selects the campaigns and the budget period of GET /metrics/pacing
+type PacingRequest struct {
+	Dimension  string `json:"dimension" example:"campaigns"`        // dimension keyed by campaign_id holding the budgets
+	Budget     string `json:"budget" example:"budget"`              // attribute of the dimension holding the budget of the period
+	SpendField string `json:"spend_field" example:"metadata.spend"` // numeric metadata field of the spend of an event
+	From       int64  `json:"from" example:"1730419200"`            // start of the budget period (Unix seconds)
+	To         int64  `json:"to" example:"1733011199"`              // end of the budget period (Unix seconds), may be in the future
+	// Filter holds the filters of the events, the ones of GET /metrics; its range is set from the budget period
+	Filter MetricRequest `json:"-" swaggerignore:"true"`
+}
+
+// RealtimeMetricRequest filters the per-minute event counts kept in memory
+type RealtimeMetricRequest struct {
+	EventName *string `json:"event_name" example:"purchase"`
+	Channel   *string `json:"channel" example:"web"`
+	Minutes   int     `json:"minutes" example:"5"` // last minutes to return, 0 = the whole window
+
+	// Tenant whose events are queried, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+	// Role of the API key of the request, the columns denied to it cannot be grouped or filtered by
+	Role string `json:"-" swaggerignore:"true"`
+}
+
+// ForecastRequest selects the event counts a forecast is fitted on
+type ForecastRequest struct {
+	EventName *string `json:"event_name" example:"purchase"`
+	Tag       *string `json:"tag" example:"premium"`   // only events with this tag
+	GroupBy   string  `json:"group_by" example:"hour"` // hour (daily season) or day (weekly season)
+	History   int     `json:"history" example:"336"`   // past buckets the model is fitted on, 0 = eight seasons
+	Horizon   int     `json:"horizon" example:"24"`    // future buckets to forecast, 0 = one season
+
+	// Tenant whose events are queried, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+	// Role of the API key of the request, the columns denied to it cannot be grouped or filtered by
+	Role string `json:"-" swaggerignore:"true"`
+}
+
+// IntervalRequest selects the consecutive events whose time between is measured
+type IntervalRequest struct {
+	FromEvent *string `json:"from_event" example:"add_to_cart"` // with ToEvent, only intervals where ToEvent directly follows this event
+	ToEvent   *string `json:"to_event" example:"purchase"`
+	From      *int64  `json:"from" example:"1732147200"` // defaults to 7 days before to
+	To        *int64  `json:"to" example:"1732233600"`
+
+	// Tenant whose events are queried, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+	// Role of the API key of the request, the columns denied to it cannot be grouped or filtered by
+	Role string `json:"-" swaggerignore:"true"`
+}
+
+// SchemaDriftRequest compares the metadata keys of the last days with the ones of a baseline period before them
+type SchemaDriftRequest struct {
+	EventName    *string `json:"event_name" example:"purchase"`
+	Days         int     `json:"days" example:"7"`           // recent days including today, 7 if 0
+	BaselineDays int     `json:"baseline_days" example:"28"` // days before the recent ones compared against, 28 if 0
+	MinChange    float64 `json:"min_change" example:"0.2"`   // change of a key's frequency reported as drift, 0.2 if 0
+
+	// Tenant whose events are queried, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+}
+
+// ColumnStatsRequest selects the events whose column value distributions are estimated
+type ColumnStatsRequest struct {
+	Days int `json:"days" example:"7"` // last days of events, 7 if 0
+	Top  int `json:"top" example:"10"` // most frequent values reported per column, 10 if 0
+
+	// Tenant whose events are queried, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+}
+
+// DimensionUploadRequest holds the rows of a dimension parsed from an uploaded CSV file.
+// The first column is the key matched against metric buckets, the others are attributes.
+type DimensionUploadRequest struct {
+	Name    string     `json:"name" example:"campaigns"`
+	Columns []string   `json:"columns" example:"campaign_id,owner,budget"`
+	Rows    [][]string `json:"rows"`
+}
+
+// DimensionURLRequest loads a dimension from a file ClickHouse downloads and refreshes periodically
+type DimensionURLRequest struct {
+	URL             string   `json:"url" example:"https://example.com/campaigns.csv"`
+	Format          string   `json:"format" example:"CSVWithNames"`              // CSVWithNames (default), TSVWithNames or JSONEachRow
+	Columns         []string `json:"columns" example:"campaign_id,owner,budget"` // key column first, then the attributes
+	LifetimeSeconds *int     `json:"lifetime_seconds" example:"3600"`            // refresh interval, 0 = only on reload (default: 3600)
+
+	// Name of the dimension, from the path
+	Name string `json:"-" swaggerignore:"true"`
+}
+
+// UserSummaryRequest selects the user whose first and last event are returned
+type UserSummaryRequest struct {
+	UserID string `json:"user_id" example:"user123"`
+
+	// Tenant whose events are queried, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+	// Role of the API key of the request, the columns denied to it cannot be grouped or filtered by
+	Role string `json:"-" swaggerignore:"true"`
 }
 
 // BulkEventRequest represents a batch of events to be tracked
 type BulkEventRequest struct {
 	Events []EventRequest `json:"events"`
 }
+
+// StreamEventRequest represents a chunk of a client's event stream, sent as NDJSON.
+// The checkpoint token is acknowledged only once all events of the chunk are flushed.
+type StreamEventRequest struct {
+	StreamID        string
+	CheckpointToken string
+	Events          []EventRequest
+}
+
+// BackfillRequest is a chunk of historical events uploaded to a backfill job, created by its first chunk
+type BackfillRequest struct {
+	ID     string
+	Tenant string
+	Events []EventRequest
+}
+
+// WebhookRequest registers a callback notified when batches containing the producer's events are flushed or dead-lettered
+type WebhookRequest struct {
+	URL    string   `json:"url" example:"https://producer.example.com/hooks/clickhouse"`
+	Events []string `json:"events" example:"batch.flushed,batch.dead_lettered"` // subscribed event types, all if empty
+}
+
+// RewriteRuleRequest creates or replaces the rewrite rule of a field and value of the tenant's events
+type RewriteRuleRequest struct {
+	Tenant string `json:"-"`
+	Field  string `json:"field" example:"event_name"` // event_name or channel
+	From   string `json:"from" example:"checkout_complete"`
+	To     string `json:"to" example:"purchase"`
+	// Retroactive rewrites the events stored before the rule in the background as well
+	Retroactive bool `json:"retroactive" example:"true"`
+}
+
+// CatalogRequest selects the days of events the catalog is cross-referenced with
+type CatalogRequest struct {
+	EventName string `json:"event_name" example:"purchase"` // only this entry, all if empty
+	Days      int    `json:"days" example:"30"`             // ingestion days including today, 30 if 0
+
+	// Tenant whose catalog is read, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+}
+
+// CatalogEntryRequest documents an event name, replacing its earlier documentation
+type CatalogEntryRequest struct {
+	Owner            string   `json:"owner" example:"checkout-team"`
+	Description      string   `json:"description" example:"A completed checkout"`
+	ExpectedMetadata []string `json:"expected_metadata" example:"order_id,amount"`
+
+	EventName string `json:"-" swaggerignore:"true"`
+	Tenant    string `json:"-" swaggerignore:"true"`
+}
+
+// EventSchemaRequest creates or replaces the JSON Schema of the metadata of an event name
+type EventSchemaRequest struct {
+	EventName string          `json:"event_name" example:"purchase"` // taken from the path when updating
+	Schema    json.RawMessage `json:"schema" swaggertype:"object"`
+}
+
+// QuarantineRequest filters the quarantined events to review
+type QuarantineRequest struct {
+	EventName string `json:"event_name" example:"purchase"`
+	Status    string `json:"status" example:"quarantined"` // quarantined or reprocessed, all if empty
+	Limit     int    `json:"limit" example:"100"`
+
+	// Tenant whose events are listed, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+}
+
+// ReprocessRequest selects events to transform, check against the current validation and rules again and ingest if they pass
+type ReprocessRequest struct {
+	Source    string          `json:"source" example:"quarantine"` // quarantine, dead_letter or raw, quarantine if empty
+	IDs       []string        `json:"ids" example:"3f2a9c0d5b7e4a1c8d6f0e2b4a9c7d51"`
+	Transform *EventTransform `json:"transform"` // optional rewrite applied before validation
+	// Sink whose dead-lettered events are written to it again as they are, without transform, validation or ingestion,
+	// e.g. kafka; with source dead_letter only, the events ClickHouse failed to store if empty
+	Sink string `json:"sink,omitempty" example:"kafka"`
+
+	// Tenant whose events are reprocessed, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+}
+
+// DeadLetterRequest selects the dead-lettered events to review
+type DeadLetterRequest struct {
+	Limit int    `json:"limit" example:"100"`
+	Sink  string `json:"sink" example:"kafka"` // optional sink whose dead-lettered events are listed, those of ClickHouse if empty
+
+	// Tenant whose events are listed, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+}
+
+// APIKeyRequest creates an API key, or allows an existing one by its producer id
+type APIKeyRequest struct {
+	Name string `json:"name" example:"checkout-service"`
+	// Producer is the producer id of an existing key to allow, a new key is generated if empty
+	Producer    string  `json:"producer,omitempty" example:"9f86d081884c7d659a2feaa0c55ad015"`
+	RateLimit   float64 `json:"rate_limit,omitempty" example:"50" minimum:"0"`        // requests per second on each instance, the default if 0
+	DailyEvents int64   `json:"daily_events,omitempty" example:"1000000" minimum:"0"` // events per UTC day, the default if 0
+	// Tenant binds the requests of the key to a tenant, the one of AUTH_KEY_TENANTS if empty
+	Tenant string `json:"tenant,omitempty" example:"acme"`
+	// Role is producer, consumer or admin, the one of AUTH_KEY_ROLES if empty
+	Role string `json:"role,omitempty" example:"consumer"`
+}
+
+// FeatureFlagRequest overrides a feature flag at runtime
+type FeatureFlagRequest struct {
+	Enabled *bool `json:"enabled" example:"true"`
+}
+
+// ExpressionTestRequest compiles an expression and evaluates it on sample events
+type ExpressionTestRequest struct {
+	Expression string         `json:"expression" example:"event_name == 'purchase' && metadata.price > 100.0"`
+	Events     []EventRequest `json:"events"` // evaluated with the tenant of the request, at most 100
+	// Tenant the sample events belong to, empty for the default tenant
+	Tenant string `json:"-" swaggerignore:"true"`
+}
@@ -11,12 +11,52 @@ type HealthResponse struct {
 	Timestamp time.Time           `json:"timestamp" example:"2025-11-22T10:00:00Z"`
 	BuildInfo buildinfo.Info      `json:"buildInfo"`
 	Services  ServiceHealthStatus `json:"services"`
+	Ingestion IngestionHealth     `json:"ingestion"`
+}
+
+// VersionResponse represents the build information and the optional features enabled on this instance
+type VersionResponse struct {
+	BuildInfo buildinfo.Info  `json:"buildInfo"`
+	Features  map[string]bool `json:"features" example:"user_sequence:true"`
+}
+
+// PreStopResponse reports the events left to flush once the instance drained
+type PreStopResponse struct {
+	Success        bool    `json:"success" example:"true"`
+	Message        string  `json:"message" example:"Buffered events flushed"`
+	PendingEvents  int     `json:"pending_events" example:"0"` // events not flushed when the drain ended
+	ElapsedSeconds float64 `json:"elapsed_seconds" example:"5.3"`
 }
 
 // ServiceHealthStatus represents the health status of dependent services
 type ServiceHealthStatus struct {
 	ClickHouse ServiceStatus `json:"clickhouse"`
 	Redis      ServiceStatus `json:"redis"`
+	Schema     ServiceStatus `json:"schema"` // "pending" while schema migrations wait to be applied
+	// Parts is "slowed" or "paused" while the parts guard holds inserts back, absent if it is disabled
+	Parts *ServiceStatus `json:"parts,omitempty"`
+}
+
+// IngestionHealth represents the state of the event batcher.
+// Status is "degraded" when the buffer is nearly full or pending events have not been flushed for too long.
+type IngestionHealth struct {
+	Status                   string  `json:"status" example:"healthy"`
+	Message                  string  `json:"message,omitempty" example:""`
+	BufferUtilizationPercent float64 `json:"buffer_utilization_percent" example:"12.5"`
+	PendingEvents            int     `json:"pending_events" example:"6250"` // events in the buffer, the overflow queue and the batch being built
+	SecondsSinceLastFlush    float64 `json:"seconds_since_last_flush" example:"0.8"`
+	DeadLetterEvents         int64   `json:"dead_letter_events" example:"0"` // events waiting in the dead-letter queue of ClickHouse, -1 if it could not be read
+}
+
+// IngestionStats is a snapshot of the event batcher state
+type IngestionStats struct {
+	BufferedEvents  int
+	BufferCapacity  int
+	BatchEvents     int
+	LastFlushTime   time.Time // time of the last successful flush, or of the start if none
+	LastFlushFailed bool
+	OverflowEvents  int   // events spilled to disk and not yet drained into the buffer
+	OverflowBytes   int64 // size of the overflow segments on disk
 }
 
 // ServiceStatus represents the status of a single service
@@ -32,10 +72,53 @@ type EventResponse struct {
 }
 
 // MetricResponse represents aggregated metrics data
+// Error codes of metrics responses
+const (
+	MetricCodeRangeTooLong = "range_too_long" // from and to are further apart than the maximum range
+	MetricCodeColumnDenied = "column_denied"  // the role of the API key cannot group or filter by a column of the query
+)
+
 type MetricResponse struct {
 	Success bool           `json:"success" example:"true"`
 	Message string         `json:"message" example:"Metrics retrieved successfully"`
+	Code    string         `json:"code,omitempty" example:"range_too_long"` // why the query was rejected
 	Metrics []MetricResult `json:"metrics"`
+	// From is the Unix time the query started from when from was omitted, the default range before to
+	From int64 `json:"from,omitempty" example:"1731628800"`
+	// RealtimeFrom is the Unix time from which total_events were counted in memory instead of ClickHouse,
+	// unique_users do not include those events. Only set with the hybrid_metrics flag.
+	RealtimeFrom int64 `json:"realtime_from,omitempty" example:"1732233300"`
+	// AsOf is the ingestion watermark the query was pinned to, pass it to the next queries to count the same snapshot
+	AsOf int64 `json:"as_of,omitempty" example:"1732233600"`
+	// TopK describes the accuracy of the estimated buckets of a top_k query
+	TopK *TopKAccuracy `json:"top_k,omitempty"`
+}
+
+// TopKNote explains the accuracy of the buckets of top_k queries
+const TopKNote = "Buckets are estimated in a single pass: total_events may exceed the exact count by up to count_error, " +
+	"buckets close to the cutoff may be missing, unique_users are not counted and duplicates not merged yet are counted"
+
+// TopKAccuracy describes the accuracy of the estimated buckets of a top_k query
+type TopKAccuracy struct {
+	K             int    `json:"k" example:"10"`
+	MaxCountError uint64 `json:"max_count_error" example:"120"` // largest count_error of the buckets, 0 if their counts are exact
+	Note          string `json:"note" example:"Buckets are estimated in a single pass"`
+}
+
+// MetricBatchResponse holds the results of a metric batch in the order of its queries.
+// Success is false if any query failed, the other results are still returned.
+type MetricBatchResponse struct {
+	Success bool                `json:"success" example:"true"`
+	Message string              `json:"message" example:"Metrics retrieved successfully"`
+	Code    string              `json:"code,omitempty" example:"column_denied"` // why the query was rejected
+	Results []MetricBatchResult `json:"results"`
+	// AsOf is the ingestion watermark the batch was pinned to
+	AsOf int64 `json:"as_of,omitempty" example:"1732233600"`
+}
+
+type MetricBatchResult struct {
+	Index int `json:"index" example:"0"` // position of the query in the batch
+	MetricResponse
 }
 
 type MetricResult struct {
@@ -43,13 +126,617 @@ type MetricResult struct {
 	Bucket      string `json:"bucket"`
 	TotalEvents uint64 `json:"total_events"`
 	UniqueUsers uint64 `json:"unique_users"`
+	// NewUsers counts the users whose first event falls in the bucket, only set for time groupings without filters
+	NewUsers *uint64 `json:"new_users,omitempty"`
+	// CountError is the largest overestimate of TotalEvents, only set for top_k queries
+	CountError *uint64 `json:"count_error,omitempty"`
+	// ExpectedEvents is the median of TotalEvents in the same bucket of the previous seasons, only set with annotate_anomalies
+	ExpectedEvents *float64 `json:"expected_events,omitempty" example:"1180"`
+	// AnomalyScore is the modified z-score of TotalEvents against the same bucket of the previous seasons, only set with annotate_anomalies
+	AnomalyScore *float64 `json:"anomaly_score,omitempty" example:"4.2"`
+	// Anomalous flags the buckets whose anomaly score is beyond 3.5 either way
+	Anomalous bool `json:"anomalous,omitempty" example:"true"`
+	// Attributes of the bucket from the dimension given by enrich, empty for keys unknown to the dimension
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Statuses of the buckets of a metrics diff
+const (
+	MetricDiffCommon      = "common"      // the bucket is in both windows
+	MetricDiffNew         = "new"         // the bucket is only in the compared window
+	MetricDiffDisappeared = "disappeared" // the bucket is only in the base window
+)
+
+// MetricDiffResponse represents the change of the metrics of each bucket from the base window to the compared one
+type MetricDiffResponse struct {
+	Success bool         `json:"success" example:"true"`
+	Message string       `json:"message" example:"Metrics compared successfully"`
+	Code    string       `json:"code,omitempty" example:"range_too_long"` // why the query was rejected
+	Metrics []MetricDiff `json:"metrics"`                                 // in bucket order
+}
+
+// MetricDiff is the change of the metrics of a bucket, the counts of a window without the bucket are zero
+type MetricDiff struct {
+	Bucket        string `json:"bucket" example:"mobile"`
+	Status        string `json:"status" example:"common"` // common, new or disappeared
+	BaseEvents    uint64 `json:"base_events" example:"1200"`
+	CompareEvents uint64 `json:"compare_events" example:"1500"`
+	EventsDelta   int64  `json:"events_delta" example:"300"`
+	// EventsChange is the relative change of the events in percent, omitted for new buckets
+	EventsChange *float64 `json:"events_change,omitempty" example:"25"`
+	BaseUsers    uint64   `json:"base_users" example:"300"`
+	CompareUsers uint64   `json:"compare_users" example:"280"`
+	UsersDelta   int64    `json:"users_delta" example:"-20"`
+}
+
+// Pacing statuses of a campaign
+const (
+	PacingOnTrack  = "on_track"    // the budget is spent at the rate of the elapsed period, within PacingTolerance
+	PacingOver     = "overpacing"  // the budget runs out before the end of the period
+	PacingUnder    = "underpacing" // part of the budget is left at the end of the period
+	PacingNoBudget = "no_budget"   // the campaign has no numeric budget in the dimension
+)
+
+// PacingTolerance is the relative deviation of a campaign's spend from the elapsed period still on track
+const PacingTolerance = 0.1
+
+// PacingResponse represents the spend of each campaign relative to its budget and the elapsed budget period
+type PacingResponse struct {
+	Success bool   `json:"success" example:"true"`
+	Message string `json:"message" example:"Pacing computed successfully"`
+	Code    string `json:"code,omitempty" example:"range_too_long"` // why the query was rejected
+	// Elapsed is the fraction of the budget period elapsed, from 0 to 1
+	Elapsed   float64          `json:"elapsed" example:"0.5"`
+	Campaigns []CampaignPacing `json:"campaigns"`
+}
+
+// CampaignPacing is the spend of a campaign in the elapsed budget period. The ratios are only set for campaigns with a budget.
+type CampaignPacing struct {
+	CampaignID    string   `json:"campaign_id" example:"summer_sale_2025"`
+	TotalEvents   uint64   `json:"total_events" example:"12000"`
+	Spend         float64  `json:"spend" example:"3000"`
+	SpendPerEvent float64  `json:"spend_per_event" example:"0.25"`
+	Budget        *float64 `json:"budget,omitempty" example:"5000"`
+	// Spent is the fraction of the budget spent
+	Spent *float64 `json:"spent,omitempty" example:"0.6"`
+	// Pacing is Spent divided by the elapsed fraction of the period, 1 when the budget is spent evenly
+	Pacing *float64 `json:"pacing,omitempty" example:"1.2"`
+	// ProjectedSpend is the spend at the end of the period at the current rate
+	ProjectedSpend *float64 `json:"projected_spend,omitempty" example:"6000"`
+	Status         string   `json:"status" example:"overpacing"` // on_track, overpacing, underpacing or no_budget
+}
+
+// ExportResponse reports why an export was rejected, exported events are sent in the format of the request instead
+type ExportResponse struct {
+	Success bool   `json:"success" example:"false"`
+	Message string `json:"message" example:"Validation failed: format must be parquet"`
+	Code    string `json:"code,omitempty" example:"range_too_long"` // why the export was rejected
+}
+
+// ExportedColumns are the columns of the events table in an export, in the order of ExportedEvent
+var ExportedColumns = []string{
+	"event_name", "channel", "campaign_id", "user_id", "timestamp", "tags", "metadata", "abuse_score", "source", "ingested_at",
+}
+
+// ExportedEvent is an event of an export
+type ExportedEvent struct {
+	EventName  string
+	Channel    string
+	CampaignID string
+	UserID     string
+	Timestamp  time.Time
+	Tags       []string
+	Metadata   string // JSON object
+	AbuseScore uint8
+	Source     string // endpoint the event was received from, e.g. events or bulk
+	IngestedAt time.Time
+}
+
+// ForecastResponse represents the forecasted event counts of the next buckets
+type ForecastResponse struct {
+	Success  bool            `json:"success" example:"true"`
+	Message  string          `json:"message" example:"Forecast computed successfully"`
+	Code     string          `json:"code,omitempty" example:"column_denied"` // why the query was rejected
+	GroupBy  string          `json:"group_by,omitempty" example:"hour"`
+	Season   int             `json:"season,omitempty" example:"24"` // buckets per season
+	RMSE     float64         `json:"rmse" example:"12.5"`           // root mean squared error of the one-step predictions on the history
+	Forecast []ForecastPoint `json:"forecast"`
+}
+
+type ForecastPoint struct {
+	Bucket      string  `json:"bucket" example:"2025-11-22 10:00:00"` // start of the bucket in UTC
+	TotalEvents float64 `json:"total_events" example:"1234.5"`
+	Lower       float64 `json:"lower" example:"1210"` // lower bound of the 95% prediction interval
+	Upper       float64 `json:"upper" example:"1259"` // upper bound of the 95% prediction interval
+}
+
+// IntervalResponse represents the distribution of the time between consecutive events of the same user
+type IntervalResponse struct {
+	Success   bool             `json:"success" example:"true"`
+	Message   string           `json:"message" example:"Intervals retrieved successfully"`
+	Code      string           `json:"code,omitempty" example:"column_denied"` // why the query was rejected
+	Total     uint64           `json:"total" example:"5000"`                   // number of intervals
+	P50       float64          `json:"p50" example:"42.5"`                     // median interval in seconds
+	P90       float64          `json:"p90" example:"3600"`
+	P99       float64          `json:"p99" example:"86400"`
+	Histogram []IntervalBucket `json:"histogram"`
+}
+
+type IntervalBucket struct {
+	Bucket     string  `json:"bucket" example:"1m-10m"`
+	MinSeconds float64 `json:"min_seconds" example:"60"`
+	MaxSeconds float64 `json:"max_seconds,omitempty" example:"600"` // exclusive, omitted for the last bucket
+	Count      uint64  `json:"count" example:"1200"`
+}
+
+// DimensionResponse represents a dimension available to enrich metrics
+type DimensionResponse struct {
+	Success   bool       `json:"success" example:"true"`
+	Message   string     `json:"message" example:"Dimension uploaded successfully"`
+	Dimension *Dimension `json:"dimension,omitempty"`
+}
+
+// DimensionListResponse represents the dimensions available to enrich metrics
+type DimensionListResponse struct {
+	Success    bool        `json:"success" example:"true"`
+	Message    string      `json:"message" example:"Dimensions retrieved successfully"`
+	Dimensions []Dimension `json:"dimensions"`
+}
+
+type Dimension struct {
+	Name       string   `json:"name" example:"campaigns"`
+	Key        string   `json:"key" example:"campaign_id"`
+	Attributes []string `json:"attributes" example:"owner,budget"`
+	Rows       uint64   `json:"rows" example:"120"`
+	// Status of the dictionary as reported by ClickHouse, e.g. LOADED, NOT_LOADED, LOADING or FAILED
+	Status          string `json:"status,omitempty" example:"LOADED"`
+	Source          string `json:"source,omitempty" example:"HTTP: https://example.com/campaigns.csv"`
+	BytesAllocated  uint64 `json:"bytes_allocated,omitempty" example:"16384"`
+	LifetimeSeconds uint64 `json:"lifetime_seconds" example:"3600"`            // refresh interval, 0 = only on reload
+	LastUpdate      int64  `json:"last_update,omitempty" example:"1732233600"` // Unix time of the last successful load
+	LastError       string `json:"last_error,omitempty"`
+}
+
+// UserSummaryResponse represents the first and last event of a user
+type UserSummaryResponse struct {
+	Success bool         `json:"success" example:"true"`
+	Message string       `json:"message" example:"User summary retrieved successfully"`
+	Code    string       `json:"code,omitempty" example:"column_denied"` // why the query was rejected
+	User    *UserSummary `json:"user,omitempty"`
+}
+
+type UserSummary struct {
+	UserID      string `json:"user_id" example:"user123"`
+	FirstSeen   int64  `json:"first_seen" example:"1732147200"` // Unix seconds of the user's first event
+	LastSeen    int64  `json:"last_seen" example:"1732233600"`  // Unix seconds of the user's last event
+	TotalEvents uint64 `json:"total_events" example:"42"`
+}
+
+// RealtimeMetricResponse represents the per-minute event counts of the last minutes, served from memory
+type RealtimeMetricResponse struct {
+	Success       bool             `json:"success" example:"true"`
+	Message       string           `json:"message" example:"Realtime metrics retrieved successfully"`
+	Code          string           `json:"code,omitempty" example:"column_denied"` // why the query was rejected
+	WindowMinutes int              `json:"window_minutes" example:"5"`
+	Metrics       []RealtimeMetric `json:"metrics"`
+}
+
+type RealtimeMetric struct {
+	Minute      string `json:"minute" example:"2025-11-22 10:05:00"` // start of the minute in UTC
+	EventName   string `json:"event_name" example:"purchase"`
+	Channel     string `json:"channel" example:"web"`
+	TotalEvents uint64 `json:"total_events" example:"42"`
+}
+
+// WatermarkResponse tells how fresh the stored events of a tenant are
+type WatermarkResponse struct {
+	Success bool   `json:"success" example:"true"`
+	Message string `json:"message" example:"Ingestion watermark retrieved successfully"`
+	Tenant  string `json:"tenant,omitempty" example:"acme"`
+	// FlushedUntil is the latest ingested_at of the stored events (Unix seconds), omitted if none are stored
+	FlushedUntil int64 `json:"flushed_until,omitempty" example:"1732233600"`
+	// EarliestPending is the earliest event time (Unix seconds) of the events accepted by the instance and not yet flushed, omitted if none are pending
+	EarliestPending int64 `json:"earliest_pending,omitempty" example:"1732233590"`
+	PendingEvents   int   `json:"pending_events" example:"120"`
 }
 
 // BulkEventResponse represents the response after posting bulk events
 type BulkEventResponse struct {
-	Success     bool   `json:"success" example:"true"`
-	Message     string `json:"message" example:"Bulk events posted successfully"`
-	TotalCount  int    `json:"total_count" example:"100"`
-	SuccessCount int   `json:"success_count" example:"100"`
-	FailureCount int   `json:"failure_count" example:"0"`
+	Success          bool   `json:"success" example:"true"`
+	Message          string `json:"message" example:"Bulk events posted successfully"`
+	TotalCount       int    `json:"total_count" example:"100"`
+	SuccessCount     int    `json:"success_count" example:"100"`
+	FailureCount     int    `json:"failure_count" example:"0"`
+	QuarantinedCount int    `json:"quarantined_count,omitempty" example:"0"` // accepted but quarantined by data quality rules, included in success_count
+	// Enqueued is set when the events were handed to the batcher and are flushed in the background, answered with 202
+	Enqueued bool `json:"enqueued,omitempty" example:"false"`
+	// Errors are the invalid events of a rejected request, up to BULK_MAX_VALIDATION_ERRORS
+	Errors []EventViolation `json:"errors,omitempty"`
+}
+
+// EventViolation is the reason an event of a bulk request was rejected
+type EventViolation struct {
+	Index   int    `json:"index" example:"3"`
+	Message string `json:"message" example:"user_id is required"`
+}
+
+// ColumnCompressionResponse represents the compression statistics of the events table
+type ColumnCompressionResponse struct {
+	Success bool                `json:"success" example:"true"`
+	Message string              `json:"message" example:"Column compression retrieved successfully"`
+	Columns []ColumnCompression `json:"columns"`
+}
+
+// ColumnCompression represents the on-disk compression statistics of a single column
+type ColumnCompression struct {
+	Name              string  `json:"name" example:"timestamp"`
+	Type              string  `json:"type" example:"DateTime"`
+	CompressionCodec  string  `json:"compression_codec" example:"CODEC(Delta(4), ZSTD(1))"`
+	CompressedBytes   uint64  `json:"compressed_bytes" example:"1048576"`
+	UncompressedBytes uint64  `json:"uncompressed_bytes" example:"8388608"`
+	CompressionRatio  float64 `json:"compression_ratio" example:"8"`
+}
+
+// UsageResponse represents the payload and storage usage per channel and event name
+type UsageResponse struct {
+	Success bool         `json:"success" example:"true"`
+	Message string       `json:"message" example:"Usage retrieved successfully"`
+	Usage   []UsageEntry `json:"usage"`
+	// Duplicates are the events received today (UTC) by all instances per API key and channel, the highest duplicate ratios first
+	Duplicates []DuplicateEntry `json:"duplicates"`
+}
+
+// UsageEntry represents the usage of a single channel and event name pair since the service started
+type UsageEntry struct {
+	Channel          string `json:"channel" example:"web"`
+	EventName        string `json:"event_name" example:"purchase"`
+	IngestedEvents   uint64 `json:"ingested_events" example:"1000"`
+	IngestedRawBytes uint64 `json:"ingested_raw_bytes" example:"250000"`
+	StoredEvents     uint64 `json:"stored_events" example:"990"`
+	StoredBytes      uint64 `json:"stored_bytes" example:"120000"`
+}
+
+// DuplicateEntry represents the events of an API key and channel received in a day, and the duplicates among them:
+// events whose unique key was already stored
+type DuplicateEntry struct {
+	Producer       string  `json:"producer" example:"9f86d081884c7d659a2feaa0c55ad015"` // hash of the API key, empty without one
+	Channel        string  `json:"channel" example:"mobile"`
+	ReceivedEvents uint64  `json:"received_events" example:"1000"`
+	Duplicates     uint64  `json:"duplicates" example:"250"`
+	DuplicateRatio float64 `json:"duplicate_ratio" example:"0.25"`
+}
+
+// StreamEventResponse represents the acknowledgment of a stream chunk, or the last acknowledged checkpoint of a stream
+type StreamEventResponse struct {
+	Success      bool   `json:"success" example:"true"`
+	Message      string `json:"message" example:"Stream chunk flushed and checkpoint acknowledged"`
+	StreamID     string `json:"stream_id" example:"device-42"`
+	Checkpoint   string `json:"checkpoint" example:"offset-1024"`
+	Acknowledged bool   `json:"acknowledged" example:"true"`
+	Count        int    `json:"count" example:"500"`
+	// QuarantinedCount events of the chunk were quarantined by data quality rules, they count as acknowledged
+	QuarantinedCount int `json:"quarantined_count,omitempty" example:"0"`
+	// Errors are the invalid events of a rejected chunk by line, up to BULK_MAX_VALIDATION_ERRORS
+	Errors []EventViolation `json:"errors,omitempty"`
+}
+
+// CatalogResponse lists documented event names
+type CatalogResponse struct {
+	Success bool           `json:"success" example:"true"`
+	Message string         `json:"message" example:"Event catalog retrieved successfully"`
+	Days    int            `json:"days,omitempty" example:"30"`
+	Entries []CatalogEntry `json:"entries"`
+}
+
+// EventSchemaResponse lists event schemas
+type EventSchemaResponse struct {
+	Success bool          `json:"success" example:"true"`
+	Message string        `json:"message" example:"Event schemas retrieved successfully"`
+	Schemas []EventSchema `json:"schemas"`
+}
+
+// CatalogCoverageResponse reports how much of the observed events the catalog documents
+type CatalogCoverageResponse struct {
+	Success bool   `json:"success" example:"true"`
+	Message string `json:"message" example:"Catalog coverage retrieved successfully"`
+	Days    int    `json:"days" example:"30"`
+	// ObservedEventNames are the event names with events in the last days, DocumentedEventNames the ones of them documented
+	ObservedEventNames   int     `json:"observed_event_names" example:"42"`
+	DocumentedEventNames int     `json:"documented_event_names" example:"38"`
+	EventCoverage        float64 `json:"event_coverage" example:"0.97"` // share of the observed events whose name is documented
+	// Undocumented are the observed event names without documentation, most frequent first
+	Undocumented []CatalogCoverageEvent `json:"undocumented"`
+	// Unobserved are the documented event names without events in the last days
+	Unobserved []string `json:"unobserved" example:"legacy_signup"`
+}
+
+// SLOResponse reports the compliance of the routes with their objectives
+type SLOResponse struct {
+	Success    bool        `json:"success" example:"true"`
+	Message    string      `json:"message" example:"SLO retrieved successfully"`
+	Since      int64       `json:"since" example:"1732233600"` // start of the instance, requests before it are not counted
+	Objectives []SLOStatus `json:"objectives"`
+}
+
+// RewriteRulesResponse lists the rewrite rules of a tenant
+type RewriteRulesResponse struct {
+	Success bool          `json:"success" example:"true"`
+	Message string        `json:"message" example:"Rewrite rules retrieved successfully"`
+	Rules   []RewriteRule `json:"rules"`
+}
+
+// APIKeysResponse lists the API keys allowed to call /events and /metrics
+type APIKeysResponse struct {
+	Success bool     `json:"success" example:"true"`
+	Message string   `json:"message" example:"API keys retrieved successfully"`
+	Keys    []APIKey `json:"keys"`
+	// Key is the generated API key, only returned once when it is created
+	Key string `json:"key,omitempty" example:"ak_3f1c9a0e5b7d42c8a6e1f0b9d3c7a5e2"`
+}
+
+// WebhookResponse represents the webhook registered for an API key
+type WebhookResponse struct {
+	Success bool     `json:"success" example:"true"`
+	Message string   `json:"message" example:"Webhook registered successfully"`
+	Webhook *Webhook `json:"webhook,omitempty"`
+}
+
+// Webhook is a registered lifecycle callback
+type Webhook struct {
+	URL    string   `json:"url" example:"https://producer.example.com/hooks/clickhouse"`
+	Events []string `json:"events" example:"batch.flushed,batch.dead_lettered"`
+	Secret string   `json:"secret,omitempty" example:"4f9c..."` // HMAC-SHA256 key of the X-Webhook-Signature header, only returned on registration
+}
+
+// WebhookNotification is the body posted to a webhook
+type WebhookNotification struct {
+	Type       string         `json:"type" example:"batch.flushed"`
+	BatchToken string         `json:"batch_token" example:"5d41402abc4b2a76b9719d911017c592"`
+	Count      int            `json:"count" example:"120"` // number of the producer's events in the batch
+	BatchSize  int            `json:"batch_size" example:"1000"`
+	Error      string         `json:"error,omitempty"`
+	Timestamp  int64          `json:"timestamp" example:"1732233600"`
+	Pod        *buildinfo.Pod `json:"pod,omitempty"` // pod that flushed the batch, when running on Kubernetes
+}
+
+// FlushAggregate is published to Redis after each flush with the counts of the events stored by it
+type FlushAggregate struct {
+	Tenant    string                `json:"tenant,omitempty" example:"acme"`
+	Events    int                   `json:"events" example:"1000"`
+	Counts    []FlushAggregateCount `json:"counts"`
+	Timestamp int64                 `json:"timestamp" example:"1732233600"`
+	Pod       *buildinfo.Pod        `json:"pod,omitempty"` // pod that flushed the events, when running on Kubernetes
+}
+
+// FlushAggregateCount is the number of events with the same event name and channel in a flush
+type FlushAggregateCount struct {
+	EventName string `json:"event_name" example:"purchase"`
+	Channel   string `json:"channel" example:"web"`
+	Count     int    `json:"count" example:"120"`
+}
+
+// AggregatePublisherResponse reports the flush aggregate publisher of this instance and the lag of its downstream consumers
+type AggregatePublisherResponse struct {
+	Success           bool    `json:"success" example:"true"`
+	Message           string  `json:"message" example:"Aggregate publisher retrieved successfully"`
+	Mode              string  `json:"mode" example:"stream"`
+	Key               string  `json:"key" example:"clickhouse_flush_aggregates"`
+	Paused            bool    `json:"paused" example:"false"`
+	Held              int     `json:"held" example:"0"` // aggregates held while paused, published once resumed
+	InFlight          int     `json:"in_flight" example:"0"`
+	Published         int64   `json:"published" example:"1200"`
+	Failed            int64   `json:"failed" example:"3"`
+	Dropped           int64   `json:"dropped" example:"0"`                 // too many in flight or held
+	PublishLagSeconds float64 `json:"publish_lag_seconds" example:"0.004"` // between the flush and the publication of the last aggregate
+	// Downstream consumers, the stream and its consumer groups in stream mode, the subscribers in pubsub mode
+	StreamLength   int64                    `json:"stream_length,omitempty" example:"10000"`
+	ConsumerGroups []AggregateConsumerGroup `json:"consumer_groups,omitempty"`
+	Subscribers    *int64                   `json:"subscribers,omitempty" example:"2"`
+}
+
+// AggregateConsumerGroup is a consumer group reading the flush aggregate stream
+type AggregateConsumerGroup struct {
+	Name      string `json:"name" example:"billing"`
+	Consumers int64  `json:"consumers" example:"2"`
+	Pending   int64  `json:"pending" example:"5"` // delivered but not acknowledged
+	Lag       int64  `json:"lag" example:"120"`   // not delivered yet, -1 if Redis cannot tell
+}
+
+// FeatureFlagsResponse represents the feature flags and their effective values
+type FeatureFlagsResponse struct {
+	Success bool          `json:"success" example:"true"`
+	Message string        `json:"message" example:"Feature flags retrieved successfully"`
+	Flags   []FeatureFlag `json:"flags"`
+}
+
+// FeatureFlag is the state of a single feature flag
+type FeatureFlag struct {
+	Name        string `json:"name" example:"approx_unique"`
+	Description string `json:"description" example:"Count unique users approximately with uniq instead of uniqExact"`
+	Enabled     bool   `json:"enabled" example:"true"`    // effective value
+	Default     bool   `json:"default" example:"false"`   // value from the environment
+	Overridden  bool   `json:"overridden" example:"true"` // whether a runtime override is set
+}
+
+// QuotaResponse represents the stored usage of a tenant in the current month against its caps
+type QuotaResponse struct {
+	Success      bool   `json:"success" example:"true"`
+	Message      string `json:"message" example:"Quota retrieved successfully"`
+	Tenant       string `json:"tenant" example:"acme"`
+	Month        string `json:"month" example:"2025-11"`
+	StoredEvents int64  `json:"stored_events" example:"750000"`
+	StoredBytes  int64  `json:"stored_bytes" example:"150000000"`
+	EventLimit   int64  `json:"event_limit" example:"1000000"` // 0 = unlimited
+	ByteLimit    int64  `json:"byte_limit" example:"0"`        // 0 = unlimited
+	Exceeded     bool   `json:"exceeded" example:"false"`
+	Mode         string `json:"mode" example:"reject"` // what happens to events beyond the cap, reject or sample
+}
+
+// BulkLimitsResponse reports the request size limits of a producer and tenant, so SDKs can size their batches
+type BulkLimitsResponse struct {
+	Success             bool   `json:"success" example:"true"`
+	Message             string `json:"message" example:"Bulk limits retrieved successfully"`
+	Tenant              string `json:"tenant" example:"acme"`
+	Producer            string `json:"producer,omitempty" example:"9f86d081884c7d659a2feaa0c55ad015"` // identifies the API key in PRODUCER_BULK_MAX_EVENTS
+	MaxEvents           int    `json:"max_events" example:"10000"`                                    // per /events/bulk request and /events/stream chunk
+	Source              string `json:"source" example:"default"`                                      // default, tenant or producer
+	MaxValidationErrors int    `json:"max_validation_errors" example:"10"`                            // invalid events reported per rejected request
+}
+
+// QuarantineResponse lists quarantined events
+type QuarantineResponse struct {
+	Success bool               `json:"success" example:"true"`
+	Message string             `json:"message" example:"Quarantined events retrieved successfully"`
+	Events  []QuarantinedEvent `json:"events"`
+}
+
+// DeadLetterResponse lists dead-lettered events, oldest first
+type DeadLetterResponse struct {
+	Success bool         `json:"success" example:"true"`
+	Message string       `json:"message" example:"Dead-lettered events retrieved successfully"`
+	Total   int          `json:"total" example:"1200"` // dead-lettered events of the tenant, including the ones beyond the limit
+	Events  []DeadLetter `json:"events"`
+}
+
+// QuarantinedEvent is an event that violated data quality rules
+type QuarantinedEvent struct {
+	ID            string       `json:"id" example:"3f2a9c0d5b7e4a1c8d6f0e2b4a9c7d51"`
+	Event         EventRequest `json:"event"`
+	Violations    []string     `json:"violations" example:"metadata.price must be >= 0"`
+	Status        string       `json:"status" example:"quarantined"`
+	QuarantinedAt int64        `json:"quarantined_at" example:"1732233600"`
+	UpdatedAt     int64        `json:"updated_at" example:"1732233600"`
+}
+
+// ReprocessResponse reports the result of reprocessing quarantined events
+type ReprocessResponse struct {
+	Success bool              `json:"success" example:"true"`
+	Message string            `json:"message" example:"Quarantined events reprocessed"`
+	Results []ReprocessResult `json:"results"`
+}
+
+type ReprocessResult struct {
+	ID         string   `json:"id" example:"3f2a9c0d5b7e4a1c8d6f0e2b4a9c7d51"`
+	Status     string   `json:"status" example:"reprocessed"` // reprocessed, quarantined, not_found or failed
+	Violations []string `json:"violations,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// ExpressionTestResponse reports whether an expression compiles and whether it matches each sample event
+type ExpressionTestResponse struct {
+	Success bool               `json:"success" example:"true"`
+	Message string             `json:"message" example:"Expression is valid"`
+	Valid   bool               `json:"valid" example:"true"`
+	Error   string             `json:"error,omitempty" example:""` // why the expression does not compile
+	Results []ExpressionResult `json:"results,omitempty"`          // in the order of the sample events
+}
+
+type ExpressionResult struct {
+	Matched bool   `json:"matched" example:"true"`
+	Error   string `json:"error,omitempty" example:""` // e.g. a missing metadata key, the event does not match
+}
+
+// Schema drift statuses of a metadata key
+const (
+	SchemaDriftNew         = "new"         // carried by recent events only
+	SchemaDriftDisappeared = "disappeared" // carried by baseline events only
+	SchemaDriftChanged     = "changed"     // carried by a different share of the events
+)
+
+// SchemaDriftResponse lists the metadata keys whose presence changed between the baseline and the recent days
+type SchemaDriftResponse struct {
+	Success       bool                `json:"success" example:"true"`
+	Message       string              `json:"message" example:"Schema drift retrieved successfully"`
+	BaselineSince string              `json:"baseline_since" example:"2025-10-12"`
+	RecentSince   string              `json:"recent_since" example:"2025-11-09"`
+	Changes       []SchemaDriftChange `json:"changes"`
+}
+
+type SchemaDriftChange struct {
+	EventName         string  `json:"event_name" example:"purchase"`
+	Key               string  `json:"key" example:"coupon_code"`
+	Status            string  `json:"status" example:"new" enums:"new,disappeared,changed"`
+	RecentEvents      uint64  `json:"recent_events" example:"1200"`    // recent events carrying the key
+	BaselineEvents    uint64  `json:"baseline_events" example:"0"`     // baseline events carrying the key
+	RecentFrequency   float64 `json:"recent_frequency" example:"0.35"` // share of the recent events of the name carrying the key
+	BaselineFrequency float64 `json:"baseline_frequency" example:"0"`
+	FirstSeen         string  `json:"first_seen" example:"2025-11-10"` // first ingestion day of the key since the baseline start
+	LastSeen          string  `json:"last_seen" example:"2025-11-15"`
+}
+
+// ColumnStatsResponse represents the estimated value distributions of the columns metrics are grouped by
+type ColumnStatsResponse struct {
+	Success     bool          `json:"success" example:"true"`
+	Message     string        `json:"message" example:"Column statistics retrieved successfully"`
+	Since       int64         `json:"since" example:"1732147200"` // Unix seconds, start of the events
+	TotalEvents uint64        `json:"total_events" example:"1500000"`
+	Columns     []ColumnStats `json:"columns"`
+}
+
+type ColumnStats struct {
+	Name           string       `json:"name" example:"channel"`
+	Type           string       `json:"type" example:"LowCardinality(String)"`
+	Cardinality    uint64       `json:"cardinality" example:"12"` // estimated distinct values
+	LowCardinality bool         `json:"low_cardinality" example:"true"`
+	Recommendation string       `json:"recommendation,omitempty" example:"Fewer than 10000 distinct values, LowCardinality(String) would compress better"`
+	TopValues      []ValueCount `json:"top_values"`
+}
+
+type ValueCount struct {
+	Value string  `json:"value" example:"web"`
+	Count uint64  `json:"count" example:"900000"` // approximate
+	Share float64 `json:"share" example:"0.6"`    // of the events, carrying the tag for tags
+}
+
+// MetricJobResponse represents the state of a metric query running in the background, with its result once it succeeded
+type MetricJobResponse struct {
+	Success    bool            `json:"success" example:"true"`
+	Message    string          `json:"message" example:"Metric job submitted"`
+	Code       string          `json:"code,omitempty" example:"column_denied"` // why the query was rejected
+	JobID      string          `json:"job_id,omitempty" example:"3f1c9a7be04d5e6f8a9b0c1d2e3f4a5b"`
+	Status     string          `json:"status,omitempty" example:"running"` // queued, running, succeeded, failed or canceled
+	Error      string          `json:"error,omitempty"`                    // why the job failed
+	CreatedAt  int64           `json:"created_at,omitempty" example:"1732233600"`
+	StartedAt  int64           `json:"started_at,omitempty" example:"1732233601"`
+	FinishedAt int64           `json:"finished_at,omitempty" example:"1732233720"`
+	Result     *MetricResponse `json:"result,omitempty"`
+}
+
+// BackfillResponse represents the state of a backfill job and the progress of its day partitions
+type BackfillResponse struct {
+	Success    bool                `json:"success" example:"true"`
+	Message    string              `json:"message" example:"Backfill chunk received"`
+	JobID      string              `json:"job_id,omitempty" example:"import-2024"`
+	Status     string              `json:"status,omitempty" example:"running"` // receiving, running, succeeded or failed
+	Error      string              `json:"error,omitempty"`                    // why the job failed
+	Count      int                 `json:"count,omitempty" example:"500"`      // events of the chunk received
+	Events     int                 `json:"events" example:"1000000"`
+	Written    int                 `json:"written" example:"250000"`
+	Skipped    int                 `json:"skipped" example:"12"`
+	CreatedAt  int64               `json:"created_at,omitempty" example:"1732233600"`
+	StartedAt  int64               `json:"started_at,omitempty" example:"1732233601"`
+	FinishedAt int64               `json:"finished_at,omitempty" example:"1732233720"`
+	Partitions []BackfillPartition `json:"partitions,omitempty"`
+	// Errors are the invalid events of a rejected chunk by line, up to BULK_MAX_VALIDATION_ERRORS
+	Errors []EventViolation `json:"errors,omitempty"`
+}
+
+// SchemaMigrationsResponse lists the schema migrations of the events table and whether they were applied
+type SchemaMigrationsResponse struct {
+	Success       bool                    `json:"success" example:"true"`
+	Message       string                  `json:"message" example:"Schema migrations retrieved successfully"`
+	Mode          string                  `json:"mode" example:"auto"`
+	Version       int                     `json:"version" example:"2"`        // highest applied migration
+	LatestVersion int                     `json:"latest_version" example:"2"` // version the running code writes
+	Migrations    []SchemaMigrationStatus `json:"migrations"`
+}
+
+// SchemaMigrationStatus is a schema migration and when it was applied
+type SchemaMigrationStatus struct {
+	Version   int      `json:"version" example:"2"`
+	Name      string   `json:"name" example:"abuse_score"`
+	Columns   []string `json:"columns" example:"abuse_score UInt8 DEFAULT 0"`
+	Applied   bool     `json:"applied" example:"true"`
+	AppliedAt int64    `json:"applied_at,omitempty" example:"1732233600"`
+	AppliedBy string   `json:"applied_by,omitempty" example:"clickhouse-events-7d9c5b8f6-x2k4p"`
 }
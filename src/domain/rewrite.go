@@ -0,0 +1,37 @@
+package domain
+
+import "context"
+
+// Fields of events rewritten by rewrite rules
+const (
+	RewriteFieldEventName = "event_name"
+	RewriteFieldChannel   = "channel"
+)
+
+// Statuses of the backfill rewriting the stored events of a rule
+const (
+	RewriteBackfillPending = "pending"
+	RewriteBackfillRunning = "running"
+	RewriteBackfillDone    = "done"
+	RewriteBackfillFailed  = "failed"
+)
+
+type RewriteService interface {
+	ListRewriteRules(ctx context.Context, tenant string) (*RewriteRulesResponse, error)
+	SetRewriteRule(ctx context.Context, request *RewriteRuleRequest) (*RewriteRulesResponse, error)
+	DeleteRewriteRule(ctx context.Context, tenant, field, from string) (*RewriteRulesResponse, error)
+}
+
+// RewriteRule renames a value of a field of a tenant's events when they are ingested, e.g. an event_name after a taxonomy change
+type RewriteRule struct {
+	Tenant    string `json:"tenant,omitempty" example:"acme"`
+	Field     string `json:"field" example:"event_name"`
+	From      string `json:"from" example:"checkout_complete"`
+	To        string `json:"to" example:"purchase"`
+	CreatedAt int64  `json:"created_at" example:"1732233600"`
+	// Backfill is the status of the rewrite of the stored events, empty if they are not rewritten
+	Backfill      string `json:"backfill,omitempty" example:"done"`
+	BackfillError string `json:"backfill_error,omitempty" example:""`
+	BackfillRows  uint64 `json:"backfill_rows,omitempty" example:"12000"` // stored events rewritten
+	BackfilledAt  int64  `json:"backfilled_at,omitempty" example:"1732233900"`
+}
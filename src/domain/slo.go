@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// Risks of an objective, from the burn rates of its error budget, see SLOStatus
+const (
+	SLORiskNone      = "none"      // the budget lasts the period
+	SLORiskSlowBurn  = "slow_burn" // the budget runs out before the end of the period at the current rate, worth a ticket
+	SLORiskFastBurn  = "fast_burn" // the budget runs out within days, worth a page
+	SLORiskExhausted = "exhausted" // the budget of the period is spent
+)
+
+type SLOService interface {
+	// RecordRequest counts a request of a route pattern, e.g. /events, against the objective of the route
+	RecordRequest(route string, status int, duration time.Duration)
+	GetSLO(ctx context.Context) (*SLOResponse, error)
+}
+
+// SLOStatus is the compliance of a route with its objective: the share of its requests answered without a server error
+// within the latency threshold. The requests are the ones seen by this instance since it started.
+type SLOStatus struct {
+	Route     string  `json:"route" example:"/events"`
+	Target    float64 `json:"target" example:"99.9"` // percentage of good requests
+	LatencyMS int64   `json:"latency_ms" example:"50"`
+	// PeriodHours is the length of the rolling window the budget is spent over
+	PeriodHours  int     `json:"period_hours" example:"720"`
+	Requests     uint64  `json:"requests" example:"1000000"`
+	GoodRequests uint64  `json:"good_requests" example:"999500"`
+	SLI          float64 `json:"sli" example:"99.95"` // percentage of good requests over the period, 100 without requests
+	// BudgetRemaining is the share of the error budget of the period left, negative once overspent
+	BudgetRemaining float64       `json:"budget_remaining" example:"0.5"`
+	Risk            string        `json:"risk" example:"none"`
+	BurnRates       []SLOBurnRate `json:"burn_rates"`
+}
+
+// SLOBurnRate is how fast the error budget is spent over a window, 1 spends it exactly by the end of the period
+type SLOBurnRate struct {
+	Window       string  `json:"window" example:"1h"`
+	Requests     uint64  `json:"requests" example:"4000"`
+	GoodRequests uint64  `json:"good_requests" example:"3998"`
+	SLI          float64 `json:"sli" example:"99.95"`
+	BurnRate     float64 `json:"burn_rate" example:"0.5"`
+}
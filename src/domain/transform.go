@@ -0,0 +1,144 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MetadataFieldPrefix prefixes metadata keys in field names, e.g. metadata.price
+const MetadataFieldPrefix = "metadata."
+
+// Field returns the value of a field of the event: event_name, channel, campaign_id, user_id or metadata.<key>.
+// Empty strings and missing metadata keys are reported as missing.
+func (e *EventRequest) Field(name string) (any, bool) {
+	var value any
+	switch name {
+	case "event_name":
+		value = e.EventName
+	case "channel":
+		value = e.Channel
+	case "campaign_id":
+		value = e.CampaignID
+	case "user_id":
+		value = e.UserID
+	default:
+		key, ok := strings.CutPrefix(name, MetadataFieldPrefix)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = e.Metadata[key]; !ok || value == nil {
+			return nil, false
+		}
+	}
+	if str, ok := value.(string); ok && str == "" {
+		return nil, false
+	}
+	return value, true
+}
+
+// SetField sets a field of the event, top level fields only accept strings
+func (e *EventRequest) SetField(name string, value any) error {
+	if key, ok := strings.CutPrefix(name, MetadataFieldPrefix); ok {
+		if e.Metadata == nil {
+			e.Metadata = make(map[string]any)
+		}
+		e.Metadata[key] = value
+		return nil
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s must be a string", name)
+	}
+	switch name {
+	case "event_name":
+		e.EventName = str
+	case "channel":
+		e.Channel = str
+	case "campaign_id":
+		e.CampaignID = str
+	case "user_id":
+		e.UserID = str
+	default:
+		return fmt.Errorf("unknown field %q", name)
+	}
+	return nil
+}
+
+// IsEventField reports whether a name refers to a field accessible with Field and SetField
+func IsEventField(name string) bool {
+	switch name {
+	case "event_name", "channel", "campaign_id", "user_id":
+		return true
+	}
+	key, ok := strings.CutPrefix(name, MetadataFieldPrefix)
+	return ok && key != ""
+}
+
+// EventTransform rewrites fields of an event, e.g. to fix events quarantined because of a producer bug.
+// Fields are renamed first, then set, then removed.
+type EventTransform struct {
+	Rename map[string]string `json:"rename" example:"metadata.amount:metadata.price"` // source field -> target field
+	Set    map[string]any    `json:"set" swaggertype:"object"`                        // field -> value
+	Remove []string          `json:"remove" example:"metadata.debug"`                 // metadata fields to drop
+}
+
+// Apply transforms an event in place
+func (t *EventTransform) Apply(event *EventRequest) error {
+	for source, target := range t.Rename {
+		value, ok := event.Field(source)
+		if !ok {
+			continue
+		}
+		if err := event.SetField(target, value); err != nil {
+			return err
+		}
+		if err := event.removeField(source); err != nil {
+			return err
+		}
+	}
+	for name, value := range t.Set {
+		if err := event.SetField(name, value); err != nil {
+			return err
+		}
+	}
+	for _, name := range t.Remove {
+		if err := event.removeField(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks that the transform only refers to known fields
+func (t *EventTransform) Validate() error {
+	for source, target := range t.Rename {
+		if !IsEventField(source) || !IsEventField(target) {
+			return fmt.Errorf("cannot rename %q to %q, fields must be event_name, channel, campaign_id, user_id or metadata.<key>", source, target)
+		}
+		if !strings.HasPrefix(source, MetadataFieldPrefix) {
+			return fmt.Errorf("cannot rename %q, only metadata fields can be renamed", source)
+		}
+	}
+	for name := range t.Set {
+		if !IsEventField(name) {
+			return fmt.Errorf("cannot set %q, fields must be event_name, channel, campaign_id, user_id or metadata.<key>", name)
+		}
+	}
+	for _, name := range t.Remove {
+		if !strings.HasPrefix(name, MetadataFieldPrefix) || !IsEventField(name) {
+			return fmt.Errorf("cannot remove %q, only metadata fields can be removed", name)
+		}
+	}
+	return nil
+}
+
+// removeField deletes a metadata key, top level fields are required and cannot be removed
+func (e *EventRequest) removeField(name string) error {
+	key, ok := strings.CutPrefix(name, MetadataFieldPrefix)
+	if !ok {
+		return fmt.Errorf("cannot remove %q, only metadata fields can be removed", name)
+	}
+	delete(e.Metadata, key)
+	return nil
+}
@@ -0,0 +1,8 @@
+package domain
+
+import "context"
+
+type UsageService interface {
+	GetUsage(ctx context.Context) (*UsageResponse, error)
+	GetQuota(ctx context.Context, tenant string) (*QuotaResponse, error)
+}
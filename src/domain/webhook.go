@@ -0,0 +1,15 @@
+package domain
+
+import "context"
+
+// Webhook event types
+const (
+	WebhookBatchFlushed      = "batch.flushed"
+	WebhookBatchDeadLettered = "batch.dead_lettered"
+)
+
+type WebhookService interface {
+	RegisterWebhook(ctx context.Context, producer string, request *WebhookRequest) (*WebhookResponse, error)
+	GetWebhook(ctx context.Context, producer string) (*WebhookResponse, error)
+	DeleteWebhook(ctx context.Context, producer string) (*WebhookResponse, error)
+}
@@ -0,0 +1,134 @@
+// Package expression compiles the CEL expressions (https://cel.dev) shared by the features filtering or matching events:
+// data quality rules, the drop transformation plugin and the routing of the optional flush sinks. CEL is not Turing
+// complete and has no side effects, and the evaluation of each expression is bounded by a cost limit, so expressions
+// set by operators cannot stall the ingestion.
+//
+// An expression evaluates to a bool over the fields of an event:
+//
+//	event_name, channel, campaign_id, user_id  string
+//	timestamp                                  timestamp, e.g. timestamp > timestamp("2025-01-01T00:00:00Z")
+//	tags                                       list(string), e.g. "premium" in tags
+//	metadata                                   map(string, dyn), e.g. has(metadata.price) && metadata.price > 100.0
+//	tenant, source, producer                   string, assigned at ingestion
+package expression
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+)
+
+// costLimit bounds the evaluation of an expression, a comparison costs 1 and a string or list scan its length
+const costLimit = 10000
+
+// ErrNotBool is returned for expressions that do not evaluate to a bool
+var ErrNotBool = errors.New("expression must evaluate to a bool")
+
+// environment declares the variables of the expressions, created once
+var environment = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("event_name", cel.StringType),
+		cel.Variable("channel", cel.StringType),
+		cel.Variable("campaign_id", cel.StringType),
+		cel.Variable("user_id", cel.StringType),
+		cel.Variable("timestamp", cel.TimestampType),
+		cel.Variable("tags", cel.ListType(cel.StringType)),
+		cel.Variable("metadata", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("tenant", cel.StringType),
+		cel.Variable("source", cel.StringType),
+		cel.Variable("producer", cel.StringType),
+	)
+})
+
+// Expression is a compiled expression, safe for concurrent use
+type Expression struct {
+	source  string
+	program cel.Program
+}
+
+// Compile parses and type checks an expression
+func Compile(source string) (*Expression, error) {
+	env, err := environment()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(source)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	// Expressions reading metadata are dyn, their type is only known once evaluated
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, ErrNotBool
+	}
+	program, err := env.Program(ast, cel.CostLimit(costLimit))
+	if err != nil {
+		return nil, err
+	}
+	return &Expression{source: source, program: program}, nil
+}
+
+// Match evaluates the expression on an event. Reading a missing metadata key is an error, has(metadata.key) checks it.
+func (e *Expression) Match(event *domain.EventRequest) (bool, error) {
+	tags := event.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	metadata := metadataValues(event.Metadata)
+	value, _, err := e.program.Eval(map[string]any{
+		"event_name":  event.EventName,
+		"channel":     event.Channel,
+		"campaign_id": event.CampaignID,
+		"user_id":     event.UserID,
+		"timestamp":   event.EventTime(),
+		"tags":        tags,
+		"metadata":    metadata,
+		"tenant":      event.Ingest.Tenant,
+		"source":      event.Ingest.Source,
+		"producer":    event.Ingest.Producer,
+	})
+	if err != nil {
+		return false, err
+	}
+	matched, ok := value.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("%w, got %s", ErrNotBool, value.Type().TypeName())
+	}
+	return matched, nil
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// metadataValues returns the metadata of an event as CEL values, numbers decoded as json.Number (e.g. by the change
+// data capture) are converted to doubles like the numbers of the JSON payloads
+func metadataValues(metadata map[string]any) map[string]any {
+	if metadata == nil {
+		return map[string]any{}
+	}
+	var converted map[string]any
+	for key, value := range metadata {
+		number, ok := value.(json.Number)
+		if !ok {
+			continue
+		}
+		if converted == nil {
+			converted = make(map[string]any, len(metadata))
+			for k, v := range metadata {
+				converted[k] = v
+			}
+		}
+		if f, err := number.Float64(); err == nil {
+			converted[key] = f
+		}
+	}
+	if converted == nil {
+		return metadata
+	}
+	return converted
+}
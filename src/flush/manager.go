@@ -0,0 +1,263 @@
+package flush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/expression"
+	"kucukaslan/clickhouse/telemetry"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	sinkEventsTotal = telemetry.NewCounterVec("flush_sink_events_total",
+		"Number of flushed events handed over to the optional sinks by sink and result (written, dead_lettered, lost, filtered)", "sink", "result")
+	sinkWritesTotal = telemetry.NewCounterVec("flush_sink_writes_total",
+		"Number of batch writes of the optional sinks by sink and result (ok, error), retries included", "sink", "result")
+	sinkWriteDuration = telemetry.NewHistogramVec("flush_sink_write_duration_seconds",
+		"Duration of the batch writes of the optional sinks", telemetry.DefaultBuckets, "sink")
+	sinkQueueLength = telemetry.NewGaugeVec("flush_sink_queue_length",
+		"Number of flushed batches waiting for an optional sink", "sink")
+)
+
+// errQueueFull dead-letters the batches of a sink that falls behind the flushes
+var errQueueFull = errors.New("sink queue is full")
+
+// optionalSinks are the sinks events can be routed to with filters
+var optionalSinks = []string{domain.SinkClickHouseSecondary, domain.SinkKafka, domain.SinkS3}
+
+// Manager writes the flushed batches to the sinks. The primary sink is written synchronously by the flush, which
+// retries and dead-letters its failures. Once a batch is stored, each optional sink receives it through a queue of
+// its own, so that a slow or failing sink neither delays the flushes nor the other sinks, and retries it with backoff
+// before dead-lettering its events to its own dead-letter queue.
+type Manager struct {
+	primary  Sink
+	replicas []*replica
+	filters  map[string]*expression.Expression // by sink name
+	cfg      *config.SinksConfig
+	ctx      context.Context // cancelled on Stop, failed writes are no longer retried
+	cancel   context.CancelFunc
+	mu       sync.RWMutex
+	stopped  bool
+	stopOnce sync.Once
+}
+
+// replica is an optional sink with its queue of batches and dead-letter queue
+type replica struct {
+	sink        Sink
+	deadLetters DeadLetters
+	filter      *expression.Expression // events not matching it are not written to the sink, nil for all events
+	queue       chan Batch
+	done        chan struct{}
+}
+
+// NewManager creates a manager writing to the primary sink
+func NewManager(primary Sink, cfg *config.SinksConfig) (*Manager, error) {
+	if cfg.QueueSize <= 0 {
+		return nil, fmt.Errorf("sink queue size must be positive, got %d", cfg.QueueSize)
+	}
+	if cfg.MaxRetries < 0 {
+		return nil, fmt.Errorf("sink retries cannot be negative")
+	}
+	if cfg.RetryBackoffMS <= 0 || cfg.TimeoutSeconds <= 0 {
+		return nil, fmt.Errorf("sink retry backoff and timeout must be positive")
+	}
+	filters := make(map[string]*expression.Expression, len(cfg.Filters))
+	for name, source := range cfg.Filters {
+		if !slices.Contains(optionalSinks, name) {
+			return nil, fmt.Errorf("unknown sink %q in the sink filters", name)
+		}
+		filter, err := expression.Compile(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter of sink %s: %w", name, err)
+		}
+		filters[name] = filter
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{primary: primary, filters: filters, cfg: cfg, ctx: ctx, cancel: cancel}, nil
+}
+
+// Register adds an optional sink with the dead-letter queue of its failed batches,
+// nil sinks (disabled by their configuration) are skipped
+func (m *Manager) Register(sink Sink, deadLetters DeadLetters) {
+	if sink == nil {
+		return
+	}
+	if v := reflect.ValueOf(sink); v.Kind() == reflect.Pointer && v.IsNil() {
+		return
+	}
+	m.replicas = append(m.replicas, &replica{
+		sink:        sink,
+		deadLetters: deadLetters,
+		filter:      m.filters[sink.Name()],
+		queue:       make(chan Batch, m.cfg.QueueSize),
+		done:        make(chan struct{}),
+	})
+}
+
+// Start starts writing the batches handed over by Replicate to the optional sinks
+func (m *Manager) Start() {
+	for _, r := range m.replicas {
+		flushLog.Infof("Starting sink %s", r.sink.Name())
+		go m.run(r)
+	}
+}
+
+// Write writes a batch to the primary sink
+func (m *Manager) Write(ctx context.Context, batch Batch) error {
+	return m.primary.Write(ctx, batch)
+}
+
+// Replicate hands over a batch stored by the primary sink to the optional sinks, each receiving the events matching
+// its filter. A sink whose queue is full, or that is stopped, dead-letters the batch right away. The events of the batch must not be modified afterwards.
+func (m *Manager) Replicate(batch Batch) {
+	if len(m.replicas) == 0 {
+		return
+	}
+	batch.StoredAt = time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, r := range m.replicas {
+		routed := r.route(batch)
+		if len(routed.Events) == 0 {
+			continue
+		}
+		if m.stopped {
+			m.deadLetter(r, routed, errQueueFull, 0)
+			continue
+		}
+		select {
+		case r.queue <- routed:
+			sinkQueueLength.WithLabelValues(r.sink.Name()).Set(float64(len(r.queue)))
+		default:
+			m.deadLetter(r, routed, errQueueFull, 0)
+		}
+	}
+}
+
+// Rewrite writes a batch to a single optional sink, e.g. the events reprocessed from its dead-letter queue
+func (m *Manager) Rewrite(ctx context.Context, name string, batch Batch) error {
+	for _, r := range m.replicas {
+		if r.sink.Name() == name {
+			batch.StoredAt = time.Now()
+			return m.attempt(ctx, r, batch)
+		}
+	}
+	return ErrUnknownSink
+}
+
+// Stop waits for the optional sinks to write the batches in their queues, each attempted once more without retries,
+// and closes the sinks. The flushes must be stopped before.
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() {
+		m.cancel()
+		m.mu.Lock()
+		m.stopped = true
+		for _, r := range m.replicas {
+			close(r.queue)
+		}
+		m.mu.Unlock()
+
+		for _, r := range m.replicas {
+			<-r.done
+			if err := r.sink.Close(); err != nil {
+				flushLog.Errorf("Failed to close sink %s: %v", r.sink.Name(), err)
+			}
+		}
+		if err := m.primary.Close(); err != nil {
+			flushLog.Errorf("Failed to close sink %s: %v", m.primary.Name(), err)
+		}
+		flushLog.Infof("Stopped")
+	})
+}
+
+// run writes the batches of the queue of an optional sink until it is closed
+func (m *Manager) run(r *replica) {
+	defer close(r.done)
+	for batch := range r.queue {
+		sinkQueueLength.WithLabelValues(r.sink.Name()).Set(float64(len(r.queue)))
+		m.write(r, batch)
+	}
+}
+
+// route returns the events of a batch matching the filter of the sink, as a batch with the same id.
+// Events the filter cannot be evaluated on, e.g. reading a missing metadata key, do not match.
+func (r *replica) route(batch Batch) Batch {
+	if r.filter == nil {
+		return batch
+	}
+	keep := make([]bool, len(batch.Events))
+	events := make([]domain.EventRequest, 0, len(batch.Events))
+	for i := range batch.Events {
+		matched, err := r.filter.Match(&batch.Events[i])
+		if err != nil {
+			flushLog.Debugf("Filter of sink %s failed on %s event of user %q: %v", r.sink.Name(), batch.Events[i].EventName, batch.Events[i].UserID, err)
+		}
+		if keep[i] = matched; matched {
+			events = append(events, batch.Events[i])
+		}
+	}
+	if filtered := len(batch.Events) - len(events); filtered > 0 {
+		sinkEventsTotal.WithLabelValues(r.sink.Name(), "filtered").Add(float64(filtered))
+		batch.Events, batch.Columns = events, batch.Columns.Filter(keep)
+	}
+	return batch
+}
+
+// write writes a batch to an optional sink, retrying with backoff if the write fails.
+// Once the retries are exhausted, or right away during shutdown, the events are dead-lettered.
+func (m *Manager) write(r *replica, batch Batch) {
+	log := flushLog.With("sink", r.sink.Name(), "batch_id", batch.ID(), "tenant", batch.Tenant)
+	backoff := time.Duration(m.cfg.RetryBackoffMS) * time.Millisecond
+	attempts := 1
+	err := m.attempt(context.Background(), r, batch)
+	for err != nil && attempts <= m.cfg.MaxRetries && m.ctx.Err() == nil {
+		log.Warnf("Retrying write of %d events in %v (retry %d of %d): %v", len(batch.Events), backoff, attempts, m.cfg.MaxRetries, err)
+		select {
+		case <-time.After(backoff):
+		case <-m.ctx.Done():
+		}
+		if m.ctx.Err() != nil {
+			break
+		}
+		backoff *= 2
+		attempts++
+		err = m.attempt(context.Background(), r, batch)
+	}
+	if err != nil {
+		log.Errorf("Failed to write %d events after %d attempt(s): %v", len(batch.Events), attempts, err)
+		m.deadLetter(r, batch, err, attempts)
+		return
+	}
+	sinkEventsTotal.WithLabelValues(r.sink.Name(), "written").Add(float64(len(batch.Events)))
+}
+
+// attempt writes a batch to an optional sink once, bounded by the write timeout
+func (m *Manager) attempt(ctx context.Context, r *replica, batch Batch) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(m.cfg.TimeoutSeconds)*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := r.sink.Write(ctx, batch)
+	sinkWriteDuration.WithLabelValues(r.sink.Name()).Observe(time.Since(start).Seconds())
+	if err != nil {
+		sinkWritesTotal.WithLabelValues(r.sink.Name(), "error").Inc()
+		return err
+	}
+	sinkWritesTotal.WithLabelValues(r.sink.Name(), "ok").Inc()
+	return nil
+}
+
+// deadLetter keeps the events of a batch an optional sink failed to write in its dead-letter queue
+func (m *Manager) deadLetter(r *replica, batch Batch, err error, attempts int) {
+	if dlErr := r.deadLetters.Add(batch.Events, err, attempts); dlErr != nil {
+		flushLog.Errorf("Failed to dead-letter %d events of batch %s for sink %s, they are lost: %v", len(batch.Events), batch.ID(), r.sink.Name(), dlErr)
+		sinkEventsTotal.WithLabelValues(r.sink.Name(), "lost").Add(float64(len(batch.Events)))
+		return
+	}
+	sinkEventsTotal.WithLabelValues(r.sink.Name(), "dead_lettered").Add(float64(len(batch.Events)))
+}
@@ -0,0 +1,63 @@
+// Package flush writes the events of each flush to its sinks: ClickHouse, the primary sink whose failures are retried
+// and dead-lettered by the batcher, and optional sinks such as a Kafka outbox, an S3 archive or a secondary cluster,
+// which receive the batches once they are stored and retry and dead-letter them on their own.
+package flush
+
+import (
+	"context"
+	"errors"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"time"
+)
+
+// flushLog logs the messages of the sinks
+var flushLog = logging.Named("Sinks")
+
+// ErrUnknownSink is returned when a batch is written to a sink that is not registered
+var ErrUnknownSink = errors.New("unknown sink")
+
+// Sink is a destination of the flushed events. Writes of the same batch may be repeated after a failure,
+// the batch id lets the sink or its consumers drop the duplicates.
+type Sink interface {
+	// Name labels the metrics, logs and dead-letter queue of the sink, e.g. kafka
+	Name() string
+	// Write stores the events of a batch
+	Write(ctx context.Context, batch Batch) error
+	// Close releases the connections of the sink once no more batches are written
+	Close() error
+}
+
+// Batch is the events of a single tenant written by a flush
+type Batch struct {
+	Tenant string
+	Events []domain.EventRequest
+	// Columns are the events as inserted into ClickHouse, stamped with the batch id. They are written by the
+	// ClickHouse sinks only, other sinks read the events.
+	Columns *database.EventColumnar
+	// StoredAt is when the batch was handed over to the optional sinks, set by Replicate and Rewrite
+	StoredAt time.Time
+}
+
+// NewBatch builds a batch of events of a tenant with a new batch id, e.g. for events read back from a dead-letter queue
+func NewBatch(tenant string, events []domain.EventRequest) (Batch, error) {
+	columns := database.NewEventColumnar(len(events))
+	for _, event := range events {
+		if err := columns.Append(event); err != nil {
+			return Batch{}, err
+		}
+	}
+	columns.Stamp(database.NewBatchID())
+	return Batch{Tenant: tenant, Events: events, Columns: columns}, nil
+}
+
+// ID returns the batch id the events are stored with
+func (b Batch) ID() string {
+	return b.Columns.Batch()
+}
+
+// DeadLetters keeps the events of the batches a sink failed to write, see services.DeadLetterQueue
+type DeadLetters interface {
+	Add(events []domain.EventRequest, err error, attempts int) error
+}
@@ -0,0 +1,100 @@
+package ingest
+
+import (
+	"context"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/telemetry"
+	"kucukaslan/clickhouse/validations"
+	"reflect"
+	"time"
+)
+
+var (
+	sourceRecordsTotal = telemetry.NewCounterVec("ingest_source_records_total",
+		"Number of records read by the ingestion adapters by source and result (delivered, invalid)", "source", "result")
+	sourceDeliveriesTotal = telemetry.NewCounterVec("ingest_source_deliveries_total",
+		"Number of chunks delivered by the ingestion adapters by source and result (ok, error)", "source", "result")
+	sourceDeliveryDuration = telemetry.NewHistogramVec("ingest_source_delivery_duration_seconds",
+		"Duration of the deliveries of the ingestion adapters until their events are flushed", telemetry.DefaultBuckets, "source")
+	sourceRunning = telemetry.NewGaugeVec("ingest_source_running",
+		"Whether an ingestion adapter is running (1) or not (0)", "source")
+)
+
+// Manager runs the ingestion adapters: it starts them with a sink of their own, which validates and counts their
+// records before handing them over to the shared sink, and stops them in the reverse order on shutdown.
+type Manager struct {
+	sink    Sink
+	sources []Source
+}
+
+// NewManager creates a manager delivering the chunks of its sources to the sink
+func NewManager(sink Sink) *Manager {
+	return &Manager{sink: sink}
+}
+
+// Register adds a source, nil sources (disabled by their configuration) are skipped
+func (m *Manager) Register(source Source) {
+	if source == nil {
+		return
+	}
+	if v := reflect.ValueOf(source); v.Kind() == reflect.Pointer && v.IsNil() {
+		return
+	}
+	m.sources = append(m.sources, source)
+}
+
+// Start starts the registered sources
+func (m *Manager) Start() {
+	for _, source := range m.sources {
+		ingestLog.Infof("Starting source %s", source.Name())
+		source.Start(&sourceSink{name: source.Name(), sink: m.sink})
+		sourceRunning.WithLabelValues(source.Name()).Set(1)
+	}
+}
+
+// Stop stops the sources in the reverse order of their registration, each waiting for its delivery in progress
+func (m *Manager) Stop() {
+	for i := len(m.sources) - 1; i >= 0; i-- {
+		m.sources[i].Stop()
+		sourceRunning.WithLabelValues(m.sources[i].Name()).Set(0)
+	}
+}
+
+// sourceSink is the sink of a single source. Invalid events are logged and skipped, so that a single bad record
+// does not hold up the ones after it.
+type sourceSink struct {
+	name string
+	sink Sink
+}
+
+func (s *sourceSink) Deliver(ctx context.Context, chunk Chunk) error {
+	valid := make([]domain.EventRequest, 0, len(chunk.Events))
+	for i := range chunk.Events {
+		if err := validations.ValidateEventRequest(&chunk.Events[i]); err != nil {
+			ingestLog.With("source", s.name, "stream_id", chunk.StreamID).Warnf("Skipping invalid %s event of user %q: %v",
+				chunk.Events[i].EventName, chunk.Events[i].UserID, err)
+			continue
+		}
+		valid = append(valid, chunk.Events[i])
+	}
+	invalid := chunk.Invalid + len(chunk.Events) - len(valid)
+	chunk.Events = valid
+
+	start := time.Now()
+	err := s.sink.Deliver(ctx, chunk)
+	sourceDeliveryDuration.WithLabelValues(s.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		sourceDeliveriesTotal.WithLabelValues(s.name, "error").Inc()
+		return err
+	}
+	sourceDeliveriesTotal.WithLabelValues(s.name, "ok").Inc()
+	sourceRecordsTotal.WithLabelValues(s.name, "delivered").Add(float64(len(valid)))
+	if invalid > 0 {
+		sourceRecordsTotal.WithLabelValues(s.name, "invalid").Add(float64(invalid))
+	}
+	return nil
+}
+
+func (s *sourceSink) Checkpoint(ctx context.Context, streamID string) (string, error) {
+	return s.sink.Checkpoint(ctx, streamID)
+}
@@ -0,0 +1,83 @@
+// Package ingest runs the ingestion adapters, which read events from systems such as Kinesis, SQS, Pub/Sub, Kafka,
+// a directory of files or a Postgres database and hand them over in chunks to a sink, acknowledging them to their system once it accepted them.
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+)
+
+// ingestLog logs the messages of the ingestion adapters
+var ingestLog = logging.Named("Ingest")
+
+// Source is an ingestion adapter. It reads records from its system in the background from Start until Stop returns,
+// delivers them to the sink as chunks of a stream and only then acknowledges them to its system (deletes, acks or
+// checkpoints them), so that records of a failed or interrupted delivery are read again and deduplicated.
+type Source interface {
+	// Name labels the metrics and logs of the source, e.g. kinesis
+	Name() string
+	// Start reads records in the background and delivers them to the sink
+	Start(sink Sink)
+	// Stop stops reading and waits for the delivery in progress, whose records are read again if it did not complete
+	Stop()
+}
+
+// Chunk is a batch of records of a source, delivered as a chunk of the stream ingestion
+type Chunk struct {
+	StreamID   string                // stream of the source the checkpoint is stored under, e.g. kinesis:<stream>:<shard>
+	Checkpoint string                // position of the last record of the chunk in its source
+	Events     []domain.EventRequest // events of the records
+	Invalid    int                   // records that could not be parsed into events, skipped by the source
+}
+
+// Sink ingests the chunks of the sources
+type Sink interface {
+	// Deliver ingests the events of a chunk, returning once they are flushed.
+	// The source acknowledges the records of the chunk only if it succeeds.
+	Deliver(ctx context.Context, chunk Chunk) error
+	// Checkpoint returns the checkpoint of the last chunk of a stream delivered, empty if there is none
+	Checkpoint(ctx context.Context, streamID string) (string, error)
+}
+
+// ParseEvent parses a record in the format of POST /events into an event of the tenant and source
+func ParseEvent(payload []byte, tenant, source string) (domain.EventRequest, error) {
+	var event domain.EventRequest
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return event, err
+	}
+	event.Ingest = domain.IngestInfo{RawBytes: len(payload), Tenant: tenant, Source: source}
+	return event, nil
+}
+
+// StreamSink delivers chunks through the stream ingestion of the event service, with the checkpoints kept in Redis
+type StreamSink struct {
+	events    domain.EventService
+	redisRepo database.ClickHouseRedis
+}
+
+// NewStreamSink creates a sink ingesting through the stream ingestion of the event service
+func NewStreamSink(events domain.EventService, redisRepo database.ClickHouseRedis) *StreamSink {
+	return &StreamSink{events: events, redisRepo: redisRepo}
+}
+
+// Deliver ingests the events of the chunk as a chunk of its stream and waits until they are flushed,
+// without events only the checkpoint is stored
+func (s *StreamSink) Deliver(ctx context.Context, chunk Chunk) error {
+	if len(chunk.Events) == 0 {
+		return s.redisRepo.SetStreamCheckpoint(ctx, chunk.StreamID, chunk.Checkpoint)
+	}
+	_, err := s.events.PostEventStream(ctx, &domain.StreamEventRequest{
+		StreamID:        chunk.StreamID,
+		CheckpointToken: chunk.Checkpoint,
+		Events:          chunk.Events,
+	})
+	return err
+}
+
+// Checkpoint returns the last acknowledged checkpoint of a stream
+func (s *StreamSink) Checkpoint(ctx context.Context, streamID string) (string, error) {
+	return s.redisRepo.GetStreamCheckpoint(ctx, streamID)
+}
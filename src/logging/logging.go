@@ -0,0 +1,149 @@
+// Package logging provides leveled, structured logs configured through LOG_LEVEL and LOG_FORMAT,
+// with the correlation id of the request that caused them.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Formats of the log output
+const (
+	FormatJSON    = "json"    // one JSON object per line, for log collectors
+	FormatConsole = "console" // tab separated, for humans
+)
+
+// contextKey keys the values of the package in contexts
+type contextKey int
+
+// RequestIDKey keys the correlation id of a request in its context, and in the Locals of Fiber, which back the context of fasthttp
+const RequestIDKey contextKey = 0
+
+// logger is the configured logger, a development logger until Init is called
+var logger atomic.Pointer[zap.Logger]
+
+func init() {
+	development, _ := zap.NewDevelopment()
+	logger.Store(development)
+}
+
+// Init configures the logger with the level (debug, info, warn or error) and format (json or console) and
+// redirects the standard library logger to it, so that the logs of dependencies are structured as well
+func Init(level, format string) error {
+	var atomicLevel zap.AtomicLevel
+	if err := atomicLevel.UnmarshalText([]byte(strings.ToLower(level))); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	var cfg zap.Config
+	switch format {
+	case FormatJSON:
+		cfg = zap.NewProductionConfig()
+	case FormatConsole:
+		cfg = zap.NewDevelopmentConfig()
+		cfg.Development = false
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", format, FormatJSON, FormatConsole)
+	}
+	cfg.Level = atomicLevel
+	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	// Logs are not sampled, dropping repeated flush errors would hide how often they happen
+	cfg.Sampling = nil
+	// Errors are logged with their cause, a stack trace of the logging call adds nothing
+	cfg.DisableStacktrace = true
+
+	configured, err := cfg.Build()
+	if err != nil {
+		return err
+	}
+	logger.Store(configured)
+	zap.RedirectStdLog(configured)
+	log.SetFlags(0)
+	return nil
+}
+
+// Sync flushes buffered logs, it is called before the process exits
+func Sync() {
+	_ = logger.Load().Sync()
+}
+
+// L returns the logger, for logs with structured fields
+func L() *zap.Logger {
+	return logger.Load()
+}
+
+// WithRequestID returns a context carrying the correlation id of a request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, RequestIDKey, id)
+}
+
+// RequestID returns the correlation id of the request of the context, empty if there is none
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(RequestIDKey).(string)
+	return id
+}
+
+// Logger logs the messages of a component, named after it. It resolves the configured logger on each call,
+// so that package level loggers can be created before Init.
+type Logger struct {
+	name string
+}
+
+// Named creates the logger of a component
+func Named(name string) Logger {
+	return Logger{name: name}
+}
+
+func (l Logger) sugar() *zap.SugaredLogger {
+	return logger.Load().Named(l.name).WithOptions(zap.AddCallerSkip(1)).Sugar()
+}
+
+// Ctx returns the logger with the correlation id of the request of the context, if any
+func (l Logger) Ctx(ctx context.Context) *zap.SugaredLogger {
+	sugar := logger.Load().Named(l.name).Sugar()
+	if id := RequestID(ctx); id != "" {
+		return sugar.With("request_id", id)
+	}
+	return sugar
+}
+
+// With returns the logger with structured fields, e.g. the id of a batch
+func (l Logger) With(args ...any) *zap.SugaredLogger {
+	return logger.Load().Named(l.name).Sugar().With(args...)
+}
+
+// Debugf logs a message at debug level
+func (l Logger) Debugf(format string, args ...any) { l.sugar().Debugf(format, args...) }
+
+// Infof logs a message at info level
+func (l Logger) Infof(format string, args ...any) { l.sugar().Infof(format, args...) }
+
+// Warnf logs a message at warn level
+func (l Logger) Warnf(format string, args ...any) { l.sugar().Warnf(format, args...) }
+
+// Errorf logs a message at error level
+func (l Logger) Errorf(format string, args ...any) { l.sugar().Errorf(format, args...) }
+
+// Infof logs a message of the application at info level
+func Infof(format string, args ...any) {
+	logger.Load().WithOptions(zap.AddCallerSkip(1)).Sugar().Infof(format, args...)
+}
+
+// Errorf logs a message of the application at error level
+func Errorf(format string, args ...any) {
+	logger.Load().WithOptions(zap.AddCallerSkip(1)).Sugar().Errorf(format, args...)
+}
+
+// Fatalf logs a message of the application at fatal level and exits
+func Fatalf(format string, args ...any) {
+	logger.Load().WithOptions(zap.AddCallerSkip(1)).Sugar().Fatalf(format, args...)
+}
@@ -1,10 +1,11 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"kucukaslan/clickhouse/api"
 	"kucukaslan/clickhouse/services"
-	"log"
+	"kucukaslan/clickhouse/validations"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
@@ -13,6 +14,12 @@ import (
 	"kucukaslan/clickhouse/buildinfo"
 	"kucukaslan/clickhouse/config"
 	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/flush"
+	"kucukaslan/clickhouse/ingest"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/proxy"
+	"kucukaslan/clickhouse/transform"
 
 	"github.com/gofiber/fiber/v2/middleware/recover"
 
@@ -20,6 +27,8 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/swagger"
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver of the sqlite metadata store
 )
 
 // @title ClickHouse Event Tracking API
@@ -34,86 +43,571 @@ func main() {
 	// Set application start time for accurate uptime tracking
 	buildinfo.SetStartTime(time.Now())
 
-	// Log build information
-	info := buildinfo.GetInfo()
-	log.Printf("Starting application\nVersion: %s, Commit: %s, BuildDate: %s, GoVersion: %s, Hostname: %s",
-		info.Version, info.Commit, info.BuildDate, info.GoVersion, info.Hostname)
 	// Load configuration
 	cfg := config.Load()
+	if err := logging.Init(cfg.Log.Level, cfg.Log.Format); err != nil {
+		logging.Fatalf("Failed to initialize logging: %v", err)
+	}
+	defer logging.Sync()
+
+	// Log build information
+	info := buildinfo.GetInfo()
+	fields := []zap.Field{zap.String("version", info.Version), zap.String("commit", info.Commit), zap.String("build_date", info.BuildDate),
+		zap.String("go_version", info.GoVersion), zap.String("hostname", info.Hostname)}
+	if info.Pod != nil {
+		fields = append(fields, zap.String("pod", info.Pod.Name), zap.String("namespace", info.Pod.Namespace),
+			zap.String("node", info.Pod.Node), zap.String("pod_ip", info.Pod.IP))
+	}
+	logging.L().Info("Starting application", fields...)
 
 	// Initialize ClickHouse connection
 	if err := database.InitClickHouse(&cfg.ClickHouse); err != nil {
-		log.Fatalf("Failed to initialize ClickHouse: %v", err)
+		logging.Fatalf("Failed to initialize ClickHouse: %v", err)
 	}
 
-	// Initialize Redis connection
+	// Initialize Redis connection, or the in-process store of REDIS_MODE=embedded for deployments without a Redis server
 	if err := database.InitRedis(&cfg.Redis); err != nil {
-		// TODO: we are (will be) using redis for idempotency/deduplication,
-		// so it is not necessary to fail the application if redis is not available.
-		// app can be slowed down by this, but it is not critical.
-		// obviously we should have a fallback mechanism for the places where we use redis.
-		// Possible in memory cache fallback.
-		log.Fatalf("Failed to initialize Redis: %v", err)
+		logging.Fatalf("Failed to initialize Redis: %v", err)
+	}
+
+	// Keeps the admin entities: API keys, webhooks, feature flag overrides and rewrite rules
+	metadata, err := database.NewMetadataStore(&cfg.Metadata, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS))
+	if err != nil {
+		logging.Fatalf("Failed to initialize metadata store: %v", err)
 	}
 
-	eventService, err := services.NewEventService(database.GetClickHouseDB(), &cfg.ClickHouse, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS))
+	// Read before the schema migrations, table_engines gates those changing the layout of the events table
+	flags, err := services.NewFeatureFlags(&cfg.Flags, &cfg.ClickHouse, metadata)
 	if err != nil {
-		log.Fatalf("Failed to initialize EventService: %v", err)
+		logging.Fatalf("Failed to initialize feature flags: %v", err)
 	}
 
-	httpHandler := api.NewEventHandler(eventService)
+	// Apply the schema migrations of the events table, or wait for the instance applying them
+	schemaCoordinator, err := services.NewSchemaCoordinator(database.GetClickHouseDB(), database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS), &cfg.ClickHouse, flags)
+	if err != nil {
+		logging.Fatalf("Failed to initialize schema migrations: %v", err)
+	}
+	if err := schemaCoordinator.Start(context.Background()); err != nil {
+		logging.Fatalf("Failed to apply schema migrations: %v", err)
+	}
+
+	validations.SetMaxBulkViolations(cfg.Validation.MaxBulkErrors)
+	bulkLimits, err := validations.NewBulkLimits(cfg.Validation.MaxBulkEvents, cfg.Validation.TenantBulkEvents, cfg.Validation.ProducerBulkEvents)
+	if err != nil {
+		logging.Fatalf("Failed to parse bulk event limits: %v", err)
+	}
+	validations.SetBulkLimits(bulkLimits)
+	timeWindow, err := validations.NewTimeWindow(cfg.Validation.MaxFutureSkewSeconds, cfg.Validation.MaxEventAgeDays, cfg.Validation.EventTimePolicy)
+	if err != nil {
+		logging.Fatalf("Invalid event time window: %v", err)
+	}
+	validations.SetTimeWindow(timeWindow)
+	columnACL, err := validations.NewColumnACL(cfg.Auth.DeniedColumns, cfg.Auth.Enabled)
+	if err != nil {
+		logging.Fatalf("Failed to parse the columns denied to API key roles: %v", err)
+	}
+	validations.SetColumnACL(columnACL)
+
+	// Load the JSON Schemas the metadata of events is validated against, from a file or the event_schemas table
+	schemaRegistry, eventSchemas, err := services.LoadEventSchemas(database.GetClickHouseDB(), database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS), metadata, &cfg.Validation)
+	if err != nil {
+		logging.Fatalf("Failed to load event schemas: %v", err)
+	}
+	validations.SetSchemaRegistry(schemaRegistry)
+	eventSchemas.Start()
+
+	// Load data quality rules, violating events are quarantined
+	if cfg.Validation.QualityRulesFile != "" {
+		rules, err := validations.LoadQualityRules(cfg.Validation.QualityRulesFile)
+		if err != nil {
+			logging.Fatalf("Failed to load data quality rules: %v", err)
+		}
+		validations.SetQualityRules(rules)
+	}
+
+	// Load the channels and event names events may carry
+	allowedValues, err := validations.LoadAllowedValues(&cfg.Validation)
+	if err != nil {
+		logging.Fatalf("Failed to load allowed values: %v", err)
+	}
+	validations.SetAllowedValues(allowedValues)
+
+	quotas, err := services.NewQuotaEnforcer(&cfg.Quota, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS))
+	if err != nil {
+		logging.Fatalf("Failed to initialize quota enforcer: %v", err)
+	}
+
+	duplicates, err := services.NewDuplicateTracker(database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS))
+	if err != nil {
+		logging.Fatalf("Failed to initialize duplicate tracker: %v", err)
+	}
+
+	// Authenticates the API keys of /events and /metrics when AUTH_ENABLED is set, keys can be managed either way
+	apiKeys, err := services.NewAPIKeys(&cfg.Auth, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS), metadata)
+	if err != nil {
+		logging.Fatalf("Failed to initialize API keys: %v", err)
+	}
+
+	abuse, err := services.NewAbuseScorer(&cfg.Abuse)
+	if err != nil {
+		logging.Fatalf("Failed to initialize abuse scoring: %v", err)
+	}
+
+	// Publishes the counts of each flush to Redis, nil when disabled
+	aggregates, err := services.NewAggregatePublisher(&cfg.ClickHouse, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS), flags)
+	if err != nil {
+		logging.Fatalf("Failed to initialize aggregate publisher: %v", err)
+	}
+
+	rewrites, err := services.NewRewriteRules(database.GetClickHouseDB(), metadata, &cfg.ClickHouse, &cfg.Flags, flags)
+	if err != nil {
+		logging.Fatalf("Failed to initialize rewrite rules: %v", err)
+	}
+
+	// Transformation plugins mutating, enriching or dropping the ingested events, nil when none is configured
+	transforms, err := transform.NewPipeline(&cfg.Transforms)
+	if err != nil {
+		logging.Fatalf("Failed to initialize transforms: %v", err)
+	}
+
+	// Keeps the events of flushes failing after all retries
+	deadLetters, err := services.NewDeadLetterQueue(&cfg.ClickHouse, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS))
+	if err != nil {
+		logging.Fatalf("Failed to initialize dead-letter queue: %v", err)
+	}
+	deadLetters.Start()
+
+	tenants, err := services.NewTenantRouter(database.GetClickHouseDB(), &cfg.ClickHouse, flags)
+	if err != nil {
+		logging.Fatalf("Failed to initialize tenant databases: %v", err)
+	}
+
+	// Reads the batches inserted into ClickHouse back by batch_id, nil when disabled
+	verifier, err := services.NewFlushVerifier(&cfg.ClickHouse)
+	if err != nil {
+		logging.Fatalf("Failed to initialize flush verifier: %v", err)
+	}
+	verifier.Start()
+
+	// Copy the events to a secondary cluster, a Kafka outbox and an S3 archive, nil when not configured
+	secondarySink, err := services.NewSecondaryClickHouseSink(&cfg.Sinks, &cfg.ClickHouse, verifier, flags)
+	if err != nil {
+		logging.Fatalf("Failed to initialize secondary ClickHouse sink: %v", err)
+	}
+	kafkaSink, err := services.NewKafkaSink(&cfg.Sinks)
+	if err != nil {
+		logging.Fatalf("Failed to initialize Kafka sink: %v", err)
+	}
+	s3Sink, err := services.NewS3ArchiveSink(&cfg.Sinks)
+	if err != nil {
+		logging.Fatalf("Failed to initialize S3 archive sink: %v", err)
+	}
+
+	// Flushes write to ClickHouse, the stored batches are then written to the optional sinks, each with its dead-letter queue
+	sinks, err := flush.NewManager(services.NewClickHouseSink(database.GetClickHouseDB(), tenants, verifier), &cfg.Sinks)
+	if err != nil {
+		logging.Fatalf("Failed to initialize sinks: %v", err)
+	}
+	sinks.Register(secondarySink, deadLetters.ForSink(domain.SinkClickHouseSecondary))
+	sinks.Register(kafkaSink, deadLetters.ForSink(domain.SinkKafka))
+	sinks.Register(s3Sink, deadLetters.ForSink(domain.SinkS3))
+	sinks.Start()
+
+	// Remembers the processed events in Redis, in memory or both
+	dedup, err := database.NewDeduplicator(&cfg.Dedup, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS))
+	if err != nil {
+		logging.Fatalf("Failed to initialize deduplication: %v", err)
+	}
+
+	// Slows and pauses inserts while the events tables have too many parts, nil when disabled
+	partsGuard, err := services.NewPartsGuard(database.GetClickHouseDB(), &cfg.ClickHouse)
+	if err != nil {
+		logging.Fatalf("Failed to initialize parts guard: %v", err)
+	}
+	partsGuard.Start()
+
+	eventService, err := services.NewEventService(database.GetClickHouseDB(), &cfg.ClickHouse, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS), dedup, metadata, flags, quotas, duplicates, apiKeys, abuse, aggregates, rewrites, deadLetters, tenants, sinks, transforms, partsGuard, schemaCoordinator)
+	if err != nil {
+		logging.Fatalf("Failed to initialize EventService: %v", err)
+	}
+
+	// Writes historical events per day partition, bypassing the batcher
+	backfills, err := services.NewBackfills(&cfg.ClickHouse, dedup, sinks, rewrites, transforms, partsGuard, schemaCoordinator)
+	if err != nil {
+		logging.Fatalf("Failed to initialize backfills: %v", err)
+	}
+	backfills.Start()
+
+	// Started once listening, so that discovered instances accept connections
+	registrar, err := services.NewServiceRegistrar(&cfg.Discovery, cfg.Port)
+	if err != nil {
+		logging.Fatalf("Failed to initialize service discovery: %v", err)
+	}
+	// Turns writes to a Postgres database into events, nil when disabled
+	cdc, err := services.NewCDCConsumer(&cfg.CDC, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS))
+	if err != nil {
+		logging.Fatalf("Failed to initialize CDC: %v", err)
+	}
+
+	// Consume a Kinesis stream, an SQS queue and a Pub/Sub subscription, nil when not configured
+	kinesisConsumer, err := services.NewKinesisConsumer(&cfg.AWS, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS))
+	if err != nil {
+		logging.Fatalf("Failed to initialize Kinesis consumer: %v", err)
+	}
+	sqsConsumer, err := services.NewSQSConsumer(&cfg.AWS)
+	if err != nil {
+		logging.Fatalf("Failed to initialize SQS consumer: %v", err)
+	}
+	pubSubConsumer, err := services.NewPubSubConsumer(&cfg.PubSub)
+	if err != nil {
+		logging.Fatalf("Failed to initialize Pub/Sub consumer: %v", err)
+	}
+	kafkaConsumer, err := services.NewKafkaConsumer(&cfg.Kafka, &cfg.Sinks)
+	if err != nil {
+		logging.Fatalf("Failed to initialize Kafka consumer: %v", err)
+	}
+	fileImporter, err := services.NewFileImporter(&cfg.Import, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS))
+	if err != nil {
+		logging.Fatalf("Failed to initialize file importer: %v", err)
+	}
+
+	// The ingestion adapters deliver their records through the stream ingestion, acknowledging them once flushed
+	sources := ingest.NewManager(ingest.NewStreamSink(eventService, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS)))
+	sources.Register(cdc)
+	sources.Register(kinesisConsumer)
+	sources.Register(sqsConsumer)
+	sources.Register(pubSubConsumer)
+	sources.Register(kafkaConsumer)
+	sources.Register(fileImporter)
+
+	drainer := services.NewDrainer(&cfg.Server, eventService, registrar)
+	api.RegisterIngestionGauges(eventService)
+
+	httpHandler := api.NewEventHandler(eventService, cfg.ClickHouse.RawPayloadTTLHours > 0)
+	healthHandler := api.NewHealthHandler(eventService, &cfg.Health, drainer, schemaCoordinator, partsGuard, deadLetters)
+	schemaHandler := api.NewSchemaHandler(schemaCoordinator)
+	preStopHandler := api.NewPreStopHandler(drainer)
+	versionHandler := api.NewVersionHandler(cfg)
+
+	metricJobService, err := services.NewMetricJobService(eventService)
+	if err != nil {
+		logging.Fatalf("Failed to initialize MetricJobService: %v", err)
+	}
+	metricJobHandler := api.NewMetricJobHandler(metricJobService)
+	backfillHandler := api.NewBackfillHandler(backfills)
+
+	adminService, err := services.NewAdminService(database.GetClickHouseDB())
+	if err != nil {
+		logging.Fatalf("Failed to initialize AdminService: %v", err)
+	}
+	adminHandler := api.NewAdminHandler(adminService)
+	usageHandler := api.NewUsageHandler(services.NewUsageService(quotas, duplicates))
+
+	sloService, err := services.NewSLOService(&cfg.SLO)
+	if err != nil {
+		logging.Fatalf("Invalid SLO_OBJECTIVES: %v", err)
+	}
+	sloHandler := api.NewSLOHandler(sloService)
+	flagHandler := api.NewFeatureFlagHandler(flags)
+	aggregateHandler := api.NewAggregateHandler(aggregates)
+	rewriteHandler := api.NewRewriteHandler(rewrites)
+	expressionHandler := api.NewExpressionHandler()
+	deadLetterHandler := api.NewDeadLetterHandler(deadLetters)
+	apiKeyHandler := api.NewAPIKeyHandler(apiKeys)
+
+	quarantineService, err := services.NewQuarantineService(database.GetClickHouseDB(), eventService)
+	if err != nil {
+		logging.Fatalf("Failed to initialize QuarantineService: %v", err)
+	}
+	quarantineHandler := api.NewQuarantineHandler(quarantineService)
+	reprocessService, err := services.NewReprocessService(quarantineService, database.GetClickHouseDB(), eventService, deadLetters, sinks, &cfg.ClickHouse)
+	if err != nil {
+		logging.Fatalf("Failed to initialize ReprocessService: %v", err)
+	}
+	reprocessHandler := api.NewReprocessHandler(reprocessService)
+
+	dimensionService, err := services.NewDimensionService(database.GetClickHouseDB(), &cfg.ClickHouse)
+	if err != nil {
+		logging.Fatalf("Failed to initialize DimensionService: %v", err)
+	}
+	dimensionHandler := api.NewDimensionHandler(dimensionService)
+
+	webhookService, err := services.NewWebhookService(metadata)
+	if err != nil {
+		logging.Fatalf("Failed to initialize WebhookService: %v", err)
+	}
+	webhookHandler := api.NewWebhookHandler(webhookService)
+
+	tokenService, err := services.NewClientTokenService(&cfg.Tokens, database.GetRedisClient(cfg.ClickHouse.RedisCacheDurationMS))
+	if err != nil {
+		logging.Fatalf("Failed to initialize ClientTokenService: %v", err)
+	}
+	tokenHandler := api.NewClientTokenHandler(tokenService)
+
+	catalogService, err := services.NewCatalogService(database.GetClickHouseDB(), &cfg.ClickHouse, flags)
+	if err != nil {
+		logging.Fatalf("Failed to initialize CatalogService: %v", err)
+	}
+	catalogHandler := api.NewCatalogHandler(catalogService)
+	eventSchemaHandler := api.NewEventSchemaHandler(eventSchemas)
+
+	trustedProxies, err := proxy.ParseNetworks(cfg.Server.TrustedProxies)
+	if err != nil {
+		logging.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 
 	app := fiber.New(fiber.Config{
 		IdleTimeout: idleTimeout,
 	})
 
+	app.Use(api.RequestTelemetry)
+	app.Use(api.NewSLOMiddleware(sloService))
+	app.Use(api.RequestID)
 	app.Use(recover.New())
 
+	// Bounds the handling of each request, the context given to the services is cancelled on timeout
+	timeoutHandler, err := api.NewTimeoutMiddleware(&cfg.Server)
+	if err != nil {
+		logging.Fatalf("Invalid request timeouts: %v", err)
+	}
+	app.Use(timeoutHandler)
+
+	// Behind load balancers the client address is the one they forward
+	if len(trustedProxies) > 0 && cfg.Server.ClientIPHeader != "" {
+		app.Use(api.NewClientIPMiddleware(cfg.Server.ClientIPHeader, trustedProxies))
+	}
+
+	// Logged after the client address is resolved, requests answered by the middlewares below included
+	accessLog, err := api.NewAccessLog(&cfg.AccessLog)
+	if err != nil {
+		logging.Fatalf("Failed to initialize access log: %v", err)
+	}
+	if accessLog != nil {
+		app.Use(accessLog.Handler)
+	}
+
+	// Preflight requests are answered before reaching the routes
+	if cfg.CORS.AllowedOrigins != "" {
+		corsHandler, err := api.NewCORS(&cfg.CORS)
+		if err != nil {
+			logging.Fatalf("Failed to initialize CORS: %v", err)
+		}
+		app.Use(corsHandler)
+	}
+
 	// redirect to swagger docs
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.Redirect("/swagger/", fiber.StatusMovedPermanently)
 	})
 
 	// Health check endpoint
-	app.Get("/health", api.HealthCheck)
+	app.Get("/health", healthHandler.HealthCheck)
+	app.Get("/version", versionHandler.GetVersion)
+
+	// Internal service telemetry in Prometheus format
+	app.Get("/internal/metrics", api.PrometheusMetrics)
 
 	// Swagger documentation
 	app.Get("/swagger/*", swagger.HandlerDefault)
 
+	// Client tokens stand for the API key and tenant of ingestion requests
+	if cfg.Tokens.Secret != "" {
+		app.Use([]string{"/events", "/pixel.gif", "/beacon"}, api.NewClientTokenMiddleware(tokenService, cfg.Tokens.Required))
+	}
+
+	// API keys are authenticated after client tokens, which stand for them
+	if cfg.Auth.Enabled {
+		app.Use("/events", api.NewAPIKeyMiddleware(apiKeys, true))
+		app.Use("/metrics", api.NewAPIKeyMiddleware(apiKeys, false))
+		app.Use("/tokens", api.NewAPIKeyMiddleware(apiKeys, false))
+		app.Use("/users", api.NewAPIKeyMiddleware(apiKeys, false))
+		app.Use("/usage", api.NewAPIKeyMiddleware(apiKeys, false))
+		app.Use("/schemas", api.NewAPIKeyMiddleware(apiKeys, false))
+		app.Use("/catalog", api.NewAPIKeyMiddleware(apiKeys, false))
+		app.Use("/webhooks", api.NewAPIKeyMiddleware(apiKeys, false))
+		// Admin endpoints are limited to keys with the admin role
+		app.Use("/admin", api.NewAPIKeyMiddleware(apiKeys, false), api.NewAdminMiddleware())
+	}
+
+	// Query responses of read-only consumer keys have their sensitive fields redacted
+	redactHandler, err := api.NewRedactionMiddleware(&cfg.Redaction)
+	if err != nil {
+		logging.Fatalf("Invalid RESPONSE_REDACT_FIELDS: %v", err)
+	}
+	if cfg.Auth.Enabled && redactHandler != nil {
+		app.Use("/metrics", redactHandler)
+	}
+
+	// Compressed ingestion bodies are decompressed once authenticated, so that unauthenticated requests cost no decompression
+	decompressHandler, err := api.NewDecompressMiddleware(&cfg.Server)
+	if err != nil {
+		logging.Fatalf("Invalid REQUEST_MAX_DECOMPRESSED_BYTES: %v", err)
+	}
+	app.Use("/events", decompressHandler)
+
+	// Ingestion responses carry the server time, client clocks are measured against it and optionally corrected
+	app.Use("/events", api.NewClockSkewMiddleware(services.NewClockSkewTracker(), cfg.Validation.ClockSkewCorrection))
+
 	// Event endpoints
 	app.Post("/events", httpHandler.PostEvent)
 	app.Post("/events/bulk", httpHandler.PostEventsBulk)
+	app.Get("/events/bulk/limits", httpHandler.GetBulkLimits)
+	app.Get("/pixel.gif", httpHandler.PixelEvent)
+	app.Post("/beacon", httpHandler.PostBeacon)
+	app.Post("/events/stream", httpHandler.PostEventStream)
+	app.Get("/events/ws", httpHandler.EventWebSocket)
+	app.Post("/events/backfill/:id", backfillHandler.PostBackfill)
+	app.Post("/events/backfill/:id/start", backfillHandler.StartBackfill)
+	app.Get("/events/backfill/:id", backfillHandler.GetBackfill)
+	app.Get("/events/stream/checkpoint", httpHandler.GetStreamCheckpoint)
+	app.Get("/events/export", httpHandler.ExportEvents)
 	app.Get("/metrics", httpHandler.GetMetrics)
+	app.Post("/metrics/batch", httpHandler.GetMetricsBatch)
+	app.Post("/metrics/async", metricJobHandler.SubmitMetricJob)
+	app.Get("/metrics/async/:id", metricJobHandler.GetMetricJob)
+	app.Delete("/metrics/async/:id", metricJobHandler.CancelMetricJob)
+	app.Get("/metrics/realtime", httpHandler.GetRealtimeMetrics)
+	app.Get("/metrics/watermark", httpHandler.GetWatermark)
+	app.Get("/metrics/diff", httpHandler.GetMetricsDiff)
+	app.Get("/metrics/forecast", httpHandler.GetForecast)
+	app.Get("/metrics/pacing", httpHandler.GetPacing)
+	app.Get("/metrics/intervals", httpHandler.GetIntervals)
+	app.Get("/users/:id/summary", httpHandler.GetUserSummary)
+
+	app.Post("/tokens", tokenHandler.IssueToken)
+
+	app.Get("/usage", usageHandler.GetUsage)
+	app.Get("/usage/quota", usageHandler.GetQuota)
+
+	// Documentation of the event names of the tenant
+	app.Get("/catalog", catalogHandler.ListCatalog)
+	app.Get("/catalog/coverage", catalogHandler.GetCatalogCoverage)
+	app.Get("/catalog/:event_name", catalogHandler.GetCatalogEntry)
+	app.Put("/catalog/:event_name", catalogHandler.SetCatalogEntry)
+	app.Delete("/catalog/:event_name", catalogHandler.DeleteCatalogEntry)
+
+	// JSON Schemas the metadata of events is validated against, with EVENT_SCHEMA_SOURCE=clickhouse
+	app.Get("/schemas", eventSchemaHandler.ListEventSchemas)
+	app.Post("/schemas", eventSchemaHandler.CreateEventSchema)
+	app.Get("/schemas/:event_name", eventSchemaHandler.GetEventSchema)
+	app.Put("/schemas/:event_name", eventSchemaHandler.UpdateEventSchema)
+	app.Delete("/schemas/:event_name", eventSchemaHandler.DeleteEventSchema)
+
+	// Lifecycle webhooks of the caller's API key
+	app.Put("/webhooks", webhookHandler.RegisterWebhook)
+	app.Get("/webhooks", webhookHandler.GetWebhook)
+	app.Delete("/webhooks", webhookHandler.DeleteWebhook)
+
+	// Admin endpoints
+	admin := app.Group("/admin")
+	admin.Get("/compression", adminHandler.GetColumnCompression)
+	admin.Get("/schema-drift", httpHandler.GetSchemaDrift)
+	admin.Get("/stats", httpHandler.GetColumnStats)
+	admin.Get("/slo", sloHandler.GetSLO)
+	admin.Get("/migrations", schemaHandler.GetMigrations)
+	admin.Post("/migrations", schemaHandler.ApplyMigrations)
+	admin.Get("/flags", flagHandler.ListFlags)
+	admin.Put("/flags/:name", flagHandler.SetFlag)
+	admin.Delete("/flags/:name", flagHandler.ResetFlag)
+	admin.Get("/aggregates", aggregateHandler.GetAggregatePublisher)
+	admin.Post("/aggregates/pause", aggregateHandler.PauseAggregates)
+	admin.Post("/aggregates/resume", aggregateHandler.ResumeAggregates)
+	admin.Get("/rewrites", rewriteHandler.ListRewriteRules)
+	admin.Put("/rewrites", rewriteHandler.SetRewriteRule)
+	admin.Delete("/rewrites", rewriteHandler.DeleteRewriteRule)
+	admin.Post("/expressions/test", expressionHandler.TestExpression)
+	admin.Get("/quarantine", quarantineHandler.ListQuarantine)
+	admin.Post("/quarantine/reprocess", quarantineHandler.ReprocessQuarantine)
+	admin.Post("/reprocess", reprocessHandler.Reprocess)
+	admin.Get("/dead-letter", deadLetterHandler.ListDeadLetters)
+	admin.Get("/api-keys", apiKeyHandler.ListAPIKeys)
+	admin.Post("/api-keys", apiKeyHandler.CreateAPIKey)
+	admin.Delete("/api-keys/:producer", apiKeyHandler.DeleteAPIKey)
+	admin.Get("/dimensions", dimensionHandler.ListDimensions)
+	admin.Get("/dimensions/:name", dimensionHandler.GetDimension)
+	admin.Put("/dimensions/:name", dimensionHandler.UploadDimension)
+	admin.Put("/dimensions/:name/url", dimensionHandler.SetDimensionURL)
+	admin.Post("/dimensions/:name/reload", dimensionHandler.ReloadDimension)
+	admin.Delete("/dimensions/:name", dimensionHandler.DeleteDimension)
+
+	// preStop hook of Kubernetes, which sends GET requests
+	admin.Get("/prestop", preStopHandler.PreStop)
+	admin.Post("/prestop", preStopHandler.PreStop)
+
+	address := cfg.Server.ListenAddress
+	if address == "" {
+		address = ":" + cfg.Port
+	}
+	listener, err := net.Listen(cfg.Server.Network, address)
+	if err != nil {
+		logging.Fatalf("Failed to listen on %s (%s): %v", address, cfg.Server.Network, err)
+	}
+	if cfg.Server.ProxyProtocol {
+		listener = proxy.NewProtocolListener(listener, trustedProxies, time.Duration(cfg.Server.ProxyProtocolTimeoutSeconds)*time.Second)
+	}
 
 	// Listen from a different goroutine
 	go func() {
-		if err := app.Listen(":" + cfg.Port); err != nil {
-			log.Panic(err)
+		if err := app.Listener(listener); err != nil {
+			logging.Fatalf("Failed to serve: %v", err)
 		}
 	}()
 
+	registrar.Start()
+	sources.Start()
+
 	c := make(chan os.Signal, 1)                    // Create channel to signify a signal being sent
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM) // When an interrupt or termination signal is sent, notify the channel
 
 	_ = <-c // This blocks the main thread until an interrupt is received
-	fmt.Println("Gracefully shutting down...")
+	logging.Infof("Gracefully shutting down...")
+
+	// Deregistered first, so that no new traffic is routed to the instance while it drains
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := registrar.Stop(ctx); err != nil {
+		logging.Errorf("Error leaving service discovery: %v", err)
+	}
+	cancel()
+
 	_ = app.Shutdown()
 
-	fmt.Println("Running cleanup tasks...")
+	logging.Infof("Running cleanup tasks...")
+
+	// Stopped before the batcher, which flushes the events of their last batches
+	sources.Stop()
+	eventSchemas.Stop()
 
 	// Shutdown event service batcher (flushes remaining events)
 	if err := services.ShutdownEventService(eventService); err != nil {
-		log.Printf("Error shutting down event service batcher: %v", err)
+		logging.Errorf("Error shutting down event service batcher: %v", err)
 	}
 
+	// Stopped after writing the block in progress, the jobs resume from their checkpoints after the next start
+	backfills.Stop()
+	partsGuard.Stop()
+	// Stopped before the sinks close the connection of the secondary cluster, the batches still queued are not verified
+	verifier.Stop()
+
+	// Stopped after the batcher, so that the optional sinks receive the last batches
+	sinks.Stop()
+	deadLetters.Stop()
+
 	// Close database connections
+	if err := accessLog.Close(); err != nil {
+		logging.Errorf("Error closing access log: %v", err)
+	}
+
 	if err := database.CloseClickHouse(); err != nil {
-		log.Printf("Error closing ClickHouse: %v", err)
+		logging.Errorf("Error closing ClickHouse: %v", err)
 	}
 
 	if err := database.CloseRedis(); err != nil {
-		log.Printf("Error closing Redis: %v", err)
+		logging.Errorf("Error closing Redis: %v", err)
+	}
+
+	if err := database.CloseMetadataStore(); err != nil {
+		logging.Errorf("Error closing metadata store: %v", err)
 	}
 
-	fmt.Println("Fiber was successful shutdown.")
+	logging.Infof("Fiber was successful shutdown.")
 }
@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidHeader is returned by the connections of a PROXY protocol listener whose header cannot be parsed
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// v2Signature starts the binary header of PROXY protocol version 2
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1HeaderBytes is the longest text header of PROXY protocol version 1, including CRLF
+const maxV1HeaderBytes = 107
+
+// protocolListener reads the PROXY protocol header load balancers send ahead of the proxied connection
+type protocolListener struct {
+	net.Listener
+	trusted Networks
+	timeout time.Duration
+}
+
+// NewProtocolListener wraps a listener whose connections from trusted peers, any peer if trusted is empty,
+// may start with a PROXY protocol header (version 1 or 2), as sent by HAProxy, AWS NLB or Envoy.
+// The remote address of such connections is the client address of the header.
+// The header is read on the first use of the connection, within the timeout, so that slow peers do not block Accept.
+// Connections without a header, e.g. load balancer health checks, keep the peer's address.
+func NewProtocolListener(listener net.Listener, trusted Networks, timeout time.Duration) net.Listener {
+	return &protocolListener{Listener: listener, trusted: trusted, timeout: timeout}
+}
+
+func (l *protocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if len(l.trusted) > 0 {
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || !l.trusted.Contains(addr.IP) {
+			return conn, nil
+		}
+	}
+	return &protocolConn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+type protocolConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *protocolConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *protocolConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readHeader reads the header if the connection starts with one
+func (c *protocolConn) readHeader() {
+	if c.timeout > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+	if prefix, err := c.reader.Peek(len(v2Signature)); err == nil && bytes.Equal(prefix, v2Signature) {
+		c.remote, c.err = readV2Header(c.reader)
+		return
+	}
+	if prefix, err := c.reader.Peek(6); err == nil && string(prefix) == "PROXY " {
+		c.remote, c.err = readV1Header(c.reader)
+	}
+}
+
+// readV1Header parses "PROXY TCP4 <src> <dst> <src port> <dst port>\r\n", nil for "PROXY UNKNOWN"
+func readV1Header(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxV1HeaderBytes {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: version 1 header not terminated by CRLF", ErrInvalidHeader)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("%w: invalid source address %s:%s", ErrInvalidHeader, fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2Header parses the binary header, nil for LOCAL commands and address families other than TCP over IPv4 or IPv6
+func readV2Header(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	versionCommand, family := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidHeader, versionCommand>>4)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	// LOCAL connections are opened by the proxy itself, e.g. for health checks
+	if versionCommand&0x0F == 0 {
+		return nil, nil
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4: source and destination addresses, source and destination ports
+		if length < 12 {
+			return nil, fmt.Errorf("%w: short IPv4 addresses", ErrInvalidHeader)
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if length < 36 {
+			return nil, fmt.Errorf("%w: short IPv6 addresses", ErrInvalidHeader)
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	return nil, nil
+}
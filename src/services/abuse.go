@@ -0,0 +1,168 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/telemetry"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions applied to events scored at or above the abuse threshold
+const (
+	AbuseActionFlag = "flag"
+	AbuseActionDrop = "drop"
+)
+
+// Score added by each heuristic, the total is capped at 100
+const (
+	abuseScoreOldTimestamp = 30 // event time older than the maximum event age
+	abuseScoreUserBurst    = 40 // more events of a user within a second than a person can produce
+	abuseScoreFlood        = 50 // the same payload sent over and over
+	abuseScoreNoUserAgent  = 30 // browser request without a User-Agent
+	abuseScoreBotUserAgent = 40 // browser request from a crawler or an HTTP library
+)
+
+// maxAbuseKeys bounds the payloads and user seconds counted per window, keys beyond it are not counted
+const maxAbuseKeys = 100_000
+
+// botUserAgents are lowercase fragments of the User-Agents of crawlers, headless browsers and HTTP libraries
+var botUserAgents = []string{
+	"bot", "crawler", "spider", "headless", "phantomjs", "curl/", "wget/",
+	"python-requests", "python-urllib", "go-http-client", "java/", "libwww-perl", "httpclient",
+}
+
+var abusiveEventsTotal = telemetry.NewCounterVec("events_abusive_total",
+	"Number of events scored at or above the abuse threshold", "action")
+
+// AbuseScorer rates ingested events with heuristics on their timestamps, payloads and User-Agents.
+// Payloads and user bursts are counted in memory per fixed window, so each instance only sees its own traffic.
+type AbuseScorer struct {
+	cfg *config.AbuseConfig
+
+	mu          sync.Mutex
+	windowStart time.Time
+	payloads    map[uint64]int // identical payloads in the current window
+	userSeconds map[string]int // events per tenant, user and second of their timestamp in the current window
+}
+
+// NewAbuseScorer creates a scorer for the configured heuristics, nil if abuse scoring is disabled
+func NewAbuseScorer(cfg *config.AbuseConfig) (*AbuseScorer, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.Action != AbuseActionFlag && cfg.Action != AbuseActionDrop {
+		return nil, fmt.Errorf("unknown abuse action %q", cfg.Action)
+	}
+	if cfg.Threshold < 1 || cfg.Threshold > 100 {
+		return nil, fmt.Errorf("abuse threshold must be between 1 and 100, got %d", cfg.Threshold)
+	}
+	if cfg.FloodWindowSeconds <= 0 || cfg.FloodThreshold <= 0 || cfg.MaxUserEventsPerSecond <= 0 || cfg.MaxEventAgeDays <= 0 {
+		return nil, fmt.Errorf("abuse flood window, flood threshold, user events per second and event age must be positive")
+	}
+	return &AbuseScorer{
+		cfg:         cfg,
+		payloads:    make(map[uint64]int),
+		userSeconds: make(map[string]int),
+	}, nil
+}
+
+// Score sets the abuse score of the events and returns the ones to ingest,
+// without the events at or above the threshold in drop mode. A nil scorer returns the events unchanged.
+func (a *AbuseScorer) Score(events []domain.EventRequest) []domain.EventRequest {
+	if a == nil || len(events) == 0 {
+		return events
+	}
+	now := time.Now()
+	minTime := now.AddDate(0, 0, -a.cfg.MaxEventAgeDays)
+
+	a.mu.Lock()
+	if now.Sub(a.windowStart) >= time.Duration(a.cfg.FloodWindowSeconds)*time.Second {
+		a.windowStart = now
+		clear(a.payloads)
+		clear(a.userSeconds)
+	}
+	for i := range events {
+		event := &events[i]
+		score := 0
+		if event.EventTime().Before(minTime) {
+			score += abuseScoreOldTimestamp
+		}
+		userSecond := event.Ingest.Tenant + "|" + event.UserID + "|" + strconv.FormatInt(event.EventTime().Unix(), 10)
+		if countKey(a.userSeconds, userSecond) > a.cfg.MaxUserEventsPerSecond {
+			score += abuseScoreUserBurst
+		}
+		if countKey(a.payloads, payloadHash(*event)) > a.cfg.FloodThreshold {
+			score += abuseScoreFlood
+		}
+		if event.Ingest.Browser {
+			score += userAgentScore(event.Ingest.UserAgent)
+		}
+		event.Ingest.AbuseScore = uint8(min(score, 100))
+	}
+	a.mu.Unlock()
+
+	var kept []domain.EventRequest
+	for i, event := range events {
+		if int(event.Ingest.AbuseScore) < a.cfg.Threshold {
+			if kept != nil {
+				kept = append(kept, event)
+			}
+			continue
+		}
+		abusiveEventsTotal.WithLabelValues(a.cfg.Action).Inc()
+		if a.cfg.Action == AbuseActionDrop && kept == nil {
+			// Only copy the events once the first one is dropped
+			kept = append(make([]domain.EventRequest, 0, len(events)), events[:i]...)
+		}
+	}
+	if kept == nil {
+		return events
+	}
+	return kept
+}
+
+// countKey increments and returns the count of a key in the current window, new keys are not counted beyond maxAbuseKeys
+func countKey[K comparable](counts map[K]int, key K) int {
+	if _, ok := counts[key]; !ok && len(counts) >= maxAbuseKeys {
+		return 0
+	}
+	counts[key]++
+	return counts[key]
+}
+
+// payloadHash identifies the content of an event regardless of its timestamp
+func payloadHash(event domain.EventRequest) uint64 {
+	h := fnv.New64a()
+	for _, field := range []string{event.Ingest.Tenant, event.EventName, event.Channel, event.CampaignID, event.UserID} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	for _, tag := range event.Tags {
+		h.Write([]byte(tag))
+		h.Write([]byte{0})
+	}
+	// Metadata keys are encoded in sorted order
+	metadata, _ := json.Marshal(event.Metadata)
+	h.Write(metadata)
+	return h.Sum64()
+}
+
+// userAgentScore rates the User-Agent of a browser request
+func userAgentScore(userAgent string) int {
+	if strings.TrimSpace(userAgent) == "" {
+		return abuseScoreNoUserAgent
+	}
+	userAgent = strings.ToLower(userAgent)
+	for _, fragment := range botUserAgents {
+		if strings.Contains(userAgent, fragment) {
+			return abuseScoreBotUserAgent
+		}
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// FlushAck tracks a group of enqueued events until all of them are flushed to ClickHouse.
+// The creator holds a reference until Wait is called, so the ack cannot complete
+// while events are still being enqueued.
+type FlushAck struct {
+	mu      sync.Mutex
+	pending int
+	err     error
+	done    chan struct{}
+}
+
+// NewFlushAck creates an ack with no events
+func NewFlushAck() *FlushAck {
+	return &FlushAck{
+		pending: 1, // the creator's reference, released by Wait
+		done:    make(chan struct{}),
+	}
+}
+
+// add registers an enqueued event
+func (a *FlushAck) add() {
+	a.mu.Lock()
+	a.pending++
+	a.mu.Unlock()
+}
+
+// complete marks an event as flushed, or failed if err is not nil
+func (a *FlushAck) complete(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	a.pending--
+	if a.pending == 0 {
+		close(a.done)
+	}
+}
+
+// Wait releases the creator's reference and blocks until all events enqueued with this ack are flushed.
+// It returns the first flush error, or the context error if the context is done first.
+// Wait must be called exactly once, after the last event was enqueued.
+func (a *FlushAck) Wait(ctx context.Context) error {
+	a.complete(nil)
+
+	select {
+	case <-a.done:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+)
+
+var _ domain.AdminService = &adminService{}
+
+type adminService struct {
+	clickhouseDB database.ClickHouseDB
+}
+
+func (a adminService) GetColumnCompression(ctx context.Context) (*domain.ColumnCompressionResponse, error) {
+	columns, err := a.clickhouseDB.GetColumnCompression(ctx)
+	if err != nil {
+		return &domain.ColumnCompressionResponse{
+			Success: false,
+			Message: "Failed to retrieve column compression: " + err.Error(),
+		}, err
+	}
+
+	results := make([]domain.ColumnCompression, len(columns))
+	for i, c := range columns {
+		results[i] = domain.ColumnCompression{
+			Name:              c.Name,
+			Type:              c.Type,
+			CompressionCodec:  c.CompressionCodec,
+			CompressedBytes:   c.CompressedBytes,
+			UncompressedBytes: c.UncompressedBytes,
+		}
+		if c.CompressedBytes > 0 {
+			results[i].CompressionRatio = float64(c.UncompressedBytes) / float64(c.CompressedBytes)
+		}
+	}
+
+	return &domain.ColumnCompressionResponse{
+		Success: true,
+		Message: "Column compression retrieved successfully",
+		Columns: results,
+	}, nil
+}
+
+// NewAdminService returns a domain.AdminService backed by the provided ClickHouse connection.
+func NewAdminService(db database.ClickHouseDB) (domain.AdminService, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("ClickHouse database connection cannot be nil")
+	}
+	return &adminService{clickhouseDB: db}, nil
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/buildinfo"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// aggregatesLog logs the messages of the AggregatePublisher
+var aggregatesLog = logging.Named("AggregatePublisher")
+
+// Destinations of the flush aggregates
+const (
+	AggregatesPubSub = "pubsub"
+	AggregatesStream = "stream"
+)
+
+const (
+	aggregatePublishTimeout = 5 * time.Second
+	// maxConcurrentAggregates bounds the publications in flight, aggregates beyond it are dropped
+	maxConcurrentAggregates = 16
+	// maxHeldAggregates bounds the aggregates held while publishing is paused, the oldest ones are dropped beyond it
+	maxHeldAggregates = 10_000
+)
+
+// ErrAggregatesDisabled is returned by the admin operations of the publisher when aggregates are not published
+var ErrAggregatesDisabled = errors.New("flush aggregates are not published")
+
+var _ domain.AggregateService = &AggregatePublisher{}
+
+// AggregatePublisher publishes the counts of the events stored by each flush to Redis,
+// so that other services can react to traffic changes without querying this API.
+// Publications are asynchronous and best effort, they never delay or fail a flush.
+// While the pause_aggregates flag is set, aggregates are held and published in order once it is cleared.
+type AggregatePublisher struct {
+	mode      string
+	key       string
+	maxLen    int64
+	redisRepo database.ClickHouseRedis
+	flags     *FeatureFlags
+	inFlight  chan struct{}
+
+	mu   sync.Mutex
+	held []pendingAggregate // aggregates of flushes while paused, oldest first
+
+	published atomic.Int64
+	failed    atomic.Int64
+	dropped   atomic.Int64
+	lag       atomic.Int64 // publish lag of the last published aggregate in microseconds
+}
+
+// pendingAggregate is an aggregate waiting to be published, with the time of its flush
+type pendingAggregate struct {
+	aggregate domain.FlushAggregate
+	flushedAt time.Time
+}
+
+// NewAggregatePublisher creates a publisher for the configured destination, nil when publishing is disabled
+func NewAggregatePublisher(cfg *config.ClickHouseConfig, redisClient database.ClickHouseRedis, flags *FeatureFlags) (*AggregatePublisher, error) {
+	switch cfg.AggregatesPublish {
+	case "":
+		return nil, nil
+	case AggregatesPubSub, AggregatesStream:
+	default:
+		return nil, fmt.Errorf("unknown aggregates destination %q", cfg.AggregatesPublish)
+	}
+	if cfg.AggregatesKey == "" {
+		return nil, fmt.Errorf("aggregates key cannot be empty")
+	}
+	return &AggregatePublisher{
+		mode:      cfg.AggregatesPublish,
+		key:       cfg.AggregatesKey,
+		maxLen:    max(cfg.AggregatesStreamMaxLen, 0),
+		redisRepo: redisClient,
+		flags:     flags,
+		inFlight:  make(chan struct{}, maxConcurrentAggregates),
+	}, nil
+}
+
+// Publish publishes the counts by event name and channel of the events of a tenant stored by a flush.
+// It is safe to call on a nil publisher.
+func (p *AggregatePublisher) Publish(tenant string, events []domain.EventRequest) {
+	if p == nil || len(events) == 0 {
+		return
+	}
+
+	pending := pendingAggregate{aggregate: newFlushAggregate(tenant, events), flushedAt: time.Now()}
+	if p.flags.Enabled(FlagPauseAggregates) {
+		p.hold(pending)
+		return
+	}
+	// Aggregates held while paused go first, the new one is published after them
+	if p.release(pending) {
+		return
+	}
+	select {
+	case p.inFlight <- struct{}{}:
+		go func() {
+			defer func() { <-p.inFlight }()
+			p.publish(pending)
+		}()
+	default:
+		p.dropped.Add(1)
+		aggregatesLog.Warnf("Too many aggregates in flight, dropping the aggregate of %d events", pending.aggregate.Events)
+	}
+}
+
+// hold keeps an aggregate until publishing is resumed, dropping the oldest one if too many are held
+func (p *AggregatePublisher) hold(pending pendingAggregate) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.held) >= maxHeldAggregates {
+		p.held = p.held[1:]
+		p.dropped.Add(1)
+	}
+	p.held = append(p.held, pending)
+}
+
+// release publishes the held aggregates in order, followed by the given ones, using a single publication slot.
+// They stay held if no slot is free, until the next flush or resume. Returns false if no aggregates were held.
+func (p *AggregatePublisher) release(next ...pendingAggregate) bool {
+	p.mu.Lock()
+	if len(p.held) == 0 {
+		p.mu.Unlock()
+		return false
+	}
+	p.held = append(p.held, next...)
+	if over := len(p.held) - maxHeldAggregates; over > 0 {
+		p.held = p.held[over:]
+		p.dropped.Add(int64(over))
+	}
+	select {
+	case p.inFlight <- struct{}{}:
+	default:
+		p.mu.Unlock()
+		return true
+	}
+	held := p.held
+	p.held = nil
+	p.mu.Unlock()
+
+	aggregatesLog.Infof("Publishing %d aggregates held while paused", len(held))
+	go func() {
+		defer func() { <-p.inFlight }()
+		for _, pending := range held {
+			p.publish(pending)
+		}
+	}()
+	return true
+}
+
+func (p *AggregatePublisher) publish(pending pendingAggregate) {
+	body, err := json.Marshal(pending.aggregate)
+	if err != nil {
+		p.failed.Add(1)
+		aggregatesLog.Errorf("Failed to encode aggregate: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), aggregatePublishTimeout)
+	defer cancel()
+	if p.mode == AggregatesStream {
+		err = p.redisRepo.AddFlushAggregate(ctx, p.key, p.maxLen, body)
+	} else {
+		err = p.redisRepo.PublishFlushAggregate(ctx, p.key, body)
+	}
+	if err != nil {
+		p.failed.Add(1)
+		aggregatesLog.Errorf("Failed to publish aggregate to %s: %v", p.key, err)
+		return
+	}
+	p.published.Add(1)
+	p.lag.Store(time.Since(pending.flushedAt).Microseconds())
+}
+
+// GetAggregatePublisher reports the publications of this instance and the lag of the downstream consumers
+func (p *AggregatePublisher) GetAggregatePublisher(ctx context.Context) (*domain.AggregatePublisherResponse, error) {
+	return p.status(ctx, "Aggregate publisher retrieved successfully")
+}
+
+// PauseAggregates pauses publishing on all instances through the pause_aggregates flag,
+// other instances pick it up on their next flag refresh
+func (p *AggregatePublisher) PauseAggregates(ctx context.Context) (*domain.AggregatePublisherResponse, error) {
+	return p.setPaused(ctx, true, "Aggregate publishing paused")
+}
+
+// ResumeAggregates resumes publishing on all instances, each one publishes its held aggregates with its next flush
+func (p *AggregatePublisher) ResumeAggregates(ctx context.Context) (*domain.AggregatePublisherResponse, error) {
+	resp, err := p.setPaused(ctx, false, "Aggregate publishing resumed")
+	if err == nil {
+		p.release()
+	}
+	return resp, err
+}
+
+func (p *AggregatePublisher) setPaused(ctx context.Context, paused bool, message string) (*domain.AggregatePublisherResponse, error) {
+	if p == nil {
+		return p.status(ctx, "")
+	}
+	if _, err := p.flags.SetFlag(ctx, FlagPauseAggregates, paused); err != nil {
+		return &domain.AggregatePublisherResponse{
+			Success: false,
+			Message: "Failed to set " + FlagPauseAggregates + ": " + err.Error(),
+			Mode:    p.mode,
+			Key:     p.key,
+		}, err
+	}
+	aggregatesLog.Infof("%s", message)
+	return p.status(ctx, message)
+}
+
+func (p *AggregatePublisher) status(ctx context.Context, message string) (*domain.AggregatePublisherResponse, error) {
+	if p == nil {
+		return &domain.AggregatePublisherResponse{
+			Success: false,
+			Message: "Flush aggregates are not published, set EVENT_AGGREGATES_PUBLISH to enable them",
+		}, ErrAggregatesDisabled
+	}
+
+	p.mu.Lock()
+	held := len(p.held)
+	p.mu.Unlock()
+	resp := &domain.AggregatePublisherResponse{
+		Success:           true,
+		Message:           message,
+		Mode:              p.mode,
+		Key:               p.key,
+		Paused:            p.flags.Enabled(FlagPauseAggregates),
+		Held:              held,
+		InFlight:          len(p.inFlight),
+		Published:         p.published.Load(),
+		Failed:            p.failed.Load(),
+		Dropped:           p.dropped.Load(),
+		PublishLagSeconds: float64(p.lag.Load()) / 1e6,
+	}
+
+	if p.mode == AggregatesPubSub {
+		subscribers, err := p.redisRepo.GetFlushAggregateSubscribers(ctx, p.key)
+		if err != nil {
+			resp.Success = false
+			resp.Message = "Failed to read the subscribers of " + p.key + ": " + err.Error()
+			return resp, err
+		}
+		resp.Subscribers = &subscribers
+		return resp, nil
+	}
+
+	length, groups, err := p.redisRepo.GetFlushAggregateStream(ctx, p.key)
+	if err != nil {
+		resp.Success = false
+		resp.Message = "Failed to read the consumer groups of " + p.key + ": " + err.Error()
+		return resp, err
+	}
+	resp.StreamLength = length
+	for _, group := range groups {
+		resp.ConsumerGroups = append(resp.ConsumerGroups, domain.AggregateConsumerGroup{
+			Name:      group.Name,
+			Consumers: group.Consumers,
+			Pending:   group.Pending,
+			Lag:       group.Lag,
+		})
+	}
+	return resp, nil
+}
+
+// newFlushAggregate counts events by event name and channel, sorted for stable output
+func newFlushAggregate(tenant string, events []domain.EventRequest) domain.FlushAggregate {
+	type key struct{ eventName, channel string }
+	counts := make(map[key]int)
+	for _, event := range events {
+		counts[key{event.EventName, event.Channel}]++
+	}
+
+	aggregate := domain.FlushAggregate{
+		Tenant:    tenant,
+		Events:    len(events),
+		Counts:    make([]domain.FlushAggregateCount, 0, len(counts)),
+		Timestamp: time.Now().Unix(),
+		Pod:       buildinfo.GetPod(),
+	}
+	for k, count := range counts {
+		aggregate.Counts = append(aggregate.Counts, domain.FlushAggregateCount{
+			EventName: k.eventName,
+			Channel:   k.channel,
+			Count:     count,
+		})
+	}
+	sort.Slice(aggregate.Counts, func(i, j int) bool {
+		if aggregate.Counts[i].EventName != aggregate.Counts[j].EventName {
+			return aggregate.Counts[i].EventName < aggregate.Counts[j].EventName
+		}
+		return aggregate.Counts[i].Channel < aggregate.Counts[j].Channel
+	})
+	return aggregate
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+	"math"
+	"slices"
+	"time"
+)
+
+// anomalySeasons is the number of past seasons a bucket is compared to, the same hour of the last days or the same
+// weekday of the last weeks
+const anomalySeasons = 4
+
+// AnomalyThreshold is the anomaly score beyond which, either way, a bucket is flagged anomalous. It is the usual cutoff
+// of modified z-scores, which are about normally distributed for a series without anomalies.
+const AnomalyThreshold = 3.5
+
+// annotateAnomalies scores the event count of each bucket of a time grouping against the same bucket of the previous
+// seasons of the series, with a modified z-score: the distance to their median in median absolute deviations, which a
+// single past anomaly does not distort. The history before the range is queried with the filters of the request.
+func (e eventService) annotateAnomalies(ctx context.Context, tenantDB string, metricRequest *domain.MetricRequest, metrics []domain.MetricResult) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	season := validations.ForecastSeasons[*metricRequest.GroupBy]
+	step := time.Hour
+	if *metricRequest.GroupBy == "day" {
+		step = 24 * time.Hour
+	}
+
+	counts := make(map[time.Time]float64)
+	first := time.Time{}
+	for _, m := range metrics {
+		// Buckets are formatted by ClickHouse in the server's time zone, assumed to be UTC
+		bucket, err := time.Parse(time.DateTime, m.Bucket)
+		if err != nil {
+			return fmt.Errorf("unexpected bucket %q: %w", m.Bucket, err)
+		}
+		counts[bucket] = float64(m.TotalEvents)
+		if first.IsZero() || bucket.Before(first) {
+			first = bucket
+		}
+	}
+
+	history := *metricRequest
+	from := first.Add(-time.Duration(anomalySeasons*season) * step).Unix()
+	to := first.Unix() - 1
+	history.From, history.To = &from, &to
+	history.ApproxUnique = true // unique users are not scored, keep them cheap
+	past, err := e.clickhouseDB.GetMetricsFrom(ctx, tenantDB, history)
+	if err != nil {
+		return err
+	}
+	for _, m := range past {
+		bucket, err := time.Parse(time.DateTime, m.Bucket)
+		if err != nil {
+			return fmt.Errorf("unexpected bucket %q: %w", m.Bucket, err)
+		}
+		counts[bucket] = float64(m.TotalEvents)
+	}
+
+	// Buckets without events are not returned, they count as zero
+	baseline := make([]float64, anomalySeasons)
+	for i, m := range metrics {
+		bucket, _ := time.Parse(time.DateTime, m.Bucket)
+		for k := range baseline {
+			baseline[k] = counts[bucket.Add(-time.Duration((k+1)*season)*step)]
+		}
+		score, expected := anomalyScore(float64(m.TotalEvents), baseline)
+		metrics[i].ExpectedEvents = &expected
+		metrics[i].AnomalyScore = &score
+		metrics[i].Anomalous = math.Abs(score) > AnomalyThreshold
+	}
+	return nil
+}
+
+// anomalyScore returns the modified z-score of a value against a baseline, and the median of the baseline
+func anomalyScore(value float64, baseline []float64) (float64, float64) {
+	expected := median(baseline)
+	deviations := make([]float64, len(baseline))
+	for i, v := range baseline {
+		deviations[i] = math.Abs(v - expected)
+	}
+	// The median absolute deviation of a steady series is 0, counts vary at least like a Poisson process
+	scale := max(1.4826*median(deviations), math.Sqrt(expected), 1)
+	return (value - expected) / scale, expected
+}
+
+// median returns the median of values, which are sorted in place
+func median(values []float64) float64 {
+	slices.Sort(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
@@ -0,0 +1,424 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/validations"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// apiKeyLog logs the messages of the APIKeys
+var apiKeyLog = logging.Named("APIKeys")
+
+var (
+	// ErrMissingAPIKey is returned when a request without an API key is authenticated
+	ErrMissingAPIKey = errors.New("API key is required")
+	// ErrUnknownAPIKey is returned when a request with an API key that is not allowed is authenticated
+	ErrUnknownAPIKey = errors.New("unknown API key")
+	// ErrAPIKeyRateLimited is returned when an API key sends more requests per second than allowed
+	ErrAPIKeyRateLimited = errors.New("request rate of the API key exceeded")
+	// ErrAPIKeyQuotaExceeded is returned when the events of an API key stored today reached its daily quota
+	ErrAPIKeyQuotaExceeded = errors.New("daily event quota of the API key exceeded")
+	// ErrAPIKeyReadOnly is returned when a consumer API key sends events
+	ErrAPIKeyReadOnly = errors.New("API key is read-only")
+	// ErrAPIKeyNotFound is returned when an API key that does not exist is deleted
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrAPIKeyConfigured is returned when an API key set in AUTH_API_KEYS is created or deleted at runtime
+	ErrAPIKeyConfigured = errors.New("API key is configured in AUTH_API_KEYS")
+)
+
+var _ domain.APIKeyService = &APIKeys{}
+
+// APIKeys authenticates the API keys of requests to /events and /metrics and enforces their request rates and daily event quotas.
+// Keys are configured in AUTH_API_KEYS or created at runtime and kept in the metadata store, they are cached like feature flag overrides.
+// Request rates are limited on each instance, daily quotas count the events stored by all instances, so events still
+// in the buffer can overshoot a quota slightly.
+type APIKeys struct {
+	cfg             *config.AuthConfig
+	redisRepo       database.ClickHouseRedis
+	metadata        database.MetadataStore
+	configured      map[string]domain.APIKey // keys of AUTH_API_KEYS by producer id
+	keyRates        map[string]float64
+	keyDailyEvents  map[string]int64
+	keyTenants      map[string]string
+	keyRoles        map[string]string
+	refreshInterval time.Duration
+
+	mu               sync.Mutex
+	keys             map[string]domain.APIKey // keys created at runtime by producer id
+	keysRefreshedAt  time.Time
+	refreshingKeys   bool
+	limiters         map[string]*tokenBucket
+	usage            map[string]int64 // events stored today by producer id
+	usageDay         string
+	usageRefreshedAt time.Time
+	refreshingUsage  bool
+}
+
+// NewAPIKeys creates the API keys of the configuration and loads the ones created at runtime
+func NewAPIKeys(cfg *config.AuthConfig, redisClient database.ClickHouseRedis, metadata database.MetadataStore) (*APIKeys, error) {
+	if redisClient.Client == nil {
+		return nil, fmt.Errorf("Redis client cannot be nil")
+	}
+	if metadata == nil {
+		return nil, fmt.Errorf("metadata store cannot be nil")
+	}
+	if cfg.RateLimit < 0 || cfg.DailyEvents < 0 {
+		return nil, fmt.Errorf("API key rate limit and daily events cannot be negative")
+	}
+
+	configured := make(map[string]domain.APIKey, len(cfg.Keys))
+	for producer, name := range cfg.Keys {
+		if err := validations.ValidateProducerID(producer); err != nil {
+			return nil, fmt.Errorf("invalid API key %q: %w", producer, err)
+		}
+		configured[producer] = domain.APIKey{Producer: producer, Name: name, Configured: true}
+	}
+	keyRates := make(map[string]float64, len(cfg.KeyRateLimits))
+	for producer, value := range cfg.KeyRateLimits {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid rate limit %q for API key %q", value, producer)
+		}
+		keyRates[producer] = rate
+	}
+	keyDailyEvents := make(map[string]int64, len(cfg.KeyDailyEvents))
+	for producer, value := range cfg.KeyDailyEvents {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid daily events %q for API key %q", value, producer)
+		}
+		keyDailyEvents[producer] = limit
+	}
+	for producer, tenant := range cfg.KeyTenants {
+		if err := validations.ValidateTenantID(tenant); err != nil {
+			return nil, fmt.Errorf("invalid tenant %q for API key %q: %w", tenant, producer, err)
+		}
+	}
+	for producer, role := range cfg.KeyRoles {
+		if err := validations.ValidateAPIKeyRole(role); err != nil {
+			return nil, fmt.Errorf("invalid role %q for API key %q: %w", role, producer, err)
+		}
+	}
+
+	k := &APIKeys{
+		cfg:             cfg,
+		redisRepo:       redisClient,
+		metadata:        metadata,
+		configured:      configured,
+		keyRates:        keyRates,
+		keyDailyEvents:  keyDailyEvents,
+		keyTenants:      cfg.KeyTenants,
+		keyRoles:        cfg.KeyRoles,
+		refreshInterval: time.Duration(cfg.RefreshIntervalSeconds) * time.Second,
+		keys:            make(map[string]domain.APIKey),
+		limiters:        make(map[string]*tokenBucket),
+		usage:           make(map[string]int64),
+	}
+	k.refreshKeys()
+	k.refreshUsage()
+	return k, nil
+}
+
+// Authenticate checks that a producer's API key is allowed and within its request rate, and for ingestion requests
+// also within its daily event quota and not read-only, and returns the tenant the key is bound to and its role.
+// It never waits for the stores, stale keys and usage are reloaded in the background.
+func (k *APIKeys) Authenticate(_ context.Context, producer string, ingestion bool) (string, string, error) {
+	tenant, role, err := k.authenticate(producer, ingestion)
+	switch {
+	case errors.Is(err, ErrMissingAPIKey):
+		apiKeyRejectionsTotal.WithLabelValues("missing").Inc()
+	case errors.Is(err, ErrUnknownAPIKey):
+		apiKeyRejectionsTotal.WithLabelValues("unknown").Inc()
+	case errors.Is(err, ErrAPIKeyRateLimited):
+		apiKeyRejectionsTotal.WithLabelValues("rate_limited").Inc()
+	case errors.Is(err, ErrAPIKeyQuotaExceeded):
+		apiKeyRejectionsTotal.WithLabelValues("quota_exceeded").Inc()
+	case errors.Is(err, ErrAPIKeyReadOnly):
+		apiKeyRejectionsTotal.WithLabelValues("read_only").Inc()
+	}
+	return tenant, role, err
+}
+
+func (k *APIKeys) authenticate(producer string, ingestion bool) (string, string, error) {
+	if producer == "" {
+		return "", "", ErrMissingAPIKey
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.refreshingKeys && time.Since(k.keysRefreshedAt) >= k.refreshInterval {
+		k.refreshingKeys = true
+		go k.refreshKeys()
+	}
+	if !k.refreshingUsage && time.Since(k.usageRefreshedAt) >= quotaRefreshInterval {
+		k.refreshingUsage = true
+		go k.refreshUsage()
+	}
+
+	key, ok := k.lookup(producer)
+	if !ok {
+		return "", "", ErrUnknownAPIKey
+	}
+	role := k.role(key)
+	if ingestion && role == domain.APIKeyRoleConsumer {
+		return "", "", ErrAPIKeyReadOnly
+	}
+	rate, dailyEvents := k.limits(key)
+	limiter := k.limiters[producer]
+	if limiter == nil || limiter.rate != rate {
+		limiter = newTokenBucket(rate)
+		k.limiters[producer] = limiter
+	}
+	if !limiter.take(1) {
+		return "", "", ErrAPIKeyRateLimited
+	}
+	if ingestion && dailyEvents > 0 && k.usageDay == currentDay() && k.usage[producer] >= dailyEvents {
+		return "", "", ErrAPIKeyQuotaExceeded
+	}
+	return k.tenant(key), role, nil
+}
+
+// lookup returns the allowed key of a producer, configured keys take precedence
+func (k *APIKeys) lookup(producer string) (domain.APIKey, bool) {
+	if key, ok := k.configured[producer]; ok {
+		return key, true
+	}
+	key, ok := k.keys[producer]
+	return key, ok
+}
+
+// limits returns the request rate and daily event quota of a key, 0 means unlimited
+func (k *APIKeys) limits(key domain.APIKey) (rate float64, dailyEvents int64) {
+	rate, dailyEvents = k.cfg.RateLimit, k.cfg.DailyEvents
+	if limit, ok := k.keyRates[key.Producer]; ok {
+		rate = limit
+	}
+	if limit, ok := k.keyDailyEvents[key.Producer]; ok {
+		dailyEvents = limit
+	}
+	if key.RateLimit > 0 {
+		rate = key.RateLimit
+	}
+	if key.DailyEvents > 0 {
+		dailyEvents = key.DailyEvents
+	}
+	return rate, dailyEvents
+}
+
+// tenant returns the tenant a key is bound to, empty if its requests choose their tenant
+func (k *APIKeys) tenant(key domain.APIKey) string {
+	if key.Tenant != "" {
+		return key.Tenant
+	}
+	return k.keyTenants[key.Producer]
+}
+
+// role returns the role of a key, producer unless it or AUTH_KEY_ROLES makes it a consumer
+func (k *APIKeys) role(key domain.APIKey) string {
+	if key.Role != "" {
+		return key.Role
+	}
+	if role := k.keyRoles[key.Producer]; role != "" {
+		return role
+	}
+	return domain.APIKeyRoleProducer
+}
+
+// RecordStored adds events written to ClickHouse to the daily usage of their API keys.
+// It is safe to call on nil, in which case nothing is recorded.
+func (k *APIKeys) RecordStored(ctx context.Context, events []domain.EventRequest) {
+	if k == nil || len(events) == 0 {
+		return
+	}
+	counts := make(map[string]int64)
+	for _, event := range events {
+		if event.Ingest.Producer != "" {
+			counts[event.Ingest.Producer]++
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+	day := currentDay()
+	if err := k.redisRepo.IncrementAPIKeyUsage(ctx, day, counts); err != nil {
+		apiKeyLog.Errorf("Failed to record usage of %d API key(s): %v", len(counts), err)
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.usageDay == day {
+		for producer, count := range counts {
+			k.usage[producer] += count
+		}
+	}
+}
+
+// refreshKeys reloads the keys created at runtime from the metadata store, keeping the cached ones if it is unavailable
+func (k *APIKeys) refreshKeys() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	stored, err := k.metadata.GetAPIKeys(ctx)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.refreshingKeys = false
+	k.keysRefreshedAt = time.Now()
+	if err != nil {
+		apiKeyLog.Warnf("Failed to reload API keys, keeping cached ones: %v", err)
+		return
+	}
+	keys := make(map[string]domain.APIKey, len(stored))
+	for _, key := range stored {
+		keys[key.Producer] = key
+	}
+	k.keys = keys
+}
+
+// refreshUsage reloads the events stored today per API key from Redis, keeping the cached usage if Redis is unavailable
+func (k *APIKeys) refreshUsage() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	day := currentDay()
+	usage, err := k.redisRepo.GetAPIKeyUsage(ctx, day)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.refreshingUsage = false
+	k.usageRefreshedAt = time.Now()
+	if err != nil {
+		apiKeyLog.Warnf("Failed to reload API key usage, keeping cached one: %v", err)
+		return
+	}
+	k.usage = usage
+	k.usageDay = day
+}
+
+// ListAPIKeys returns the allowed API keys with their effective limits and the events they stored today
+func (k *APIKeys) ListAPIKeys(ctx context.Context) (*domain.APIKeysResponse, error) {
+	stored, err := k.metadata.GetAPIKeys(ctx)
+	if err != nil {
+		return &domain.APIKeysResponse{
+			Success: false,
+			Message: "Failed to retrieve API keys: " + err.Error(),
+		}, err
+	}
+	usage, err := k.redisRepo.GetAPIKeyUsage(ctx, currentDay())
+	if err != nil {
+		return &domain.APIKeysResponse{
+			Success: false,
+			Message: "Failed to retrieve API key usage: " + err.Error(),
+		}, err
+	}
+
+	keys := make([]domain.APIKey, 0, len(k.configured)+len(stored))
+	for _, key := range k.configured {
+		keys = append(keys, key)
+	}
+	for _, key := range stored {
+		if _, ok := k.configured[key.Producer]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	for i := range keys {
+		keys[i].RateLimit, keys[i].DailyEvents = k.limits(keys[i])
+		keys[i].Tenant = k.tenant(keys[i])
+		keys[i].Role = k.role(keys[i])
+		keys[i].EventsToday = usage[keys[i].Producer]
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Name != keys[j].Name {
+			return keys[i].Name < keys[j].Name
+		}
+		return keys[i].Producer < keys[j].Producer
+	})
+	return &domain.APIKeysResponse{
+		Success: true,
+		Message: "API keys retrieved successfully",
+		Keys:    keys,
+	}, nil
+}
+
+// CreateAPIKey generates an API key, or allows an existing one by its producer id, replacing its earlier limits.
+// The generated key is only returned in the response, it is stored hashed.
+func (k *APIKeys) CreateAPIKey(ctx context.Context, request *domain.APIKeyRequest) (*domain.APIKeysResponse, error) {
+	key := domain.APIKey{
+		Producer:    request.Producer,
+		Name:        request.Name,
+		RateLimit:   request.RateLimit,
+		DailyEvents: request.DailyEvents,
+		Tenant:      request.Tenant,
+		Role:        request.Role,
+		CreatedAt:   time.Now().Unix(),
+	}
+	var secret string
+	if key.Producer == "" {
+		random, err := randomHex(16)
+		if err != nil {
+			return &domain.APIKeysResponse{
+				Success: false,
+				Message: "Failed to generate API key: " + err.Error(),
+			}, err
+		}
+		secret = "ak_" + random
+		key.Producer = domain.ProducerID(secret)
+	}
+	if _, ok := k.configured[key.Producer]; ok {
+		return &domain.APIKeysResponse{
+			Success: false,
+			Message: "Failed to create API key: " + ErrAPIKeyConfigured.Error(),
+		}, ErrAPIKeyConfigured
+	}
+	if err := k.metadata.SetAPIKey(ctx, key); err != nil {
+		return &domain.APIKeysResponse{
+			Success: false,
+			Message: "Failed to create API key: " + err.Error(),
+		}, err
+	}
+	k.refreshKeys()
+	apiKeyLog.Infof("API key %s (%q) allowed", key.Producer, key.Name)
+
+	resp, err := k.ListAPIKeys(ctx)
+	if err == nil {
+		resp.Message = "API key created successfully"
+		resp.Key = secret
+	}
+	return resp, err
+}
+
+// DeleteAPIKey revokes an API key created at runtime, other instances reject it within the refresh interval
+func (k *APIKeys) DeleteAPIKey(ctx context.Context, producer string) (*domain.APIKeysResponse, error) {
+	var err error
+	if _, ok := k.configured[producer]; ok {
+		err = ErrAPIKeyConfigured
+	} else {
+		var deleted bool
+		deleted, err = k.metadata.DeleteAPIKey(ctx, producer)
+		if err == nil && !deleted {
+			err = ErrAPIKeyNotFound
+		}
+	}
+	if err != nil {
+		return &domain.APIKeysResponse{
+			Success: false,
+			Message: "Failed to delete API key: " + err.Error(),
+		}, err
+	}
+	k.refreshKeys()
+	apiKeyLog.Infof("API key %s revoked", producer)
+	return k.ListAPIKeys(ctx)
+}
+
+// currentDay returns the period of the daily quotas, days are in UTC
+func currentDay() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/flush"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var _ flush.Sink = &S3ArchiveSink{}
+
+// S3ArchiveSink archives the stored events in S3, an object of gzipped JSON lines per batch, each line an event with
+// its id, batch, tenant and source. Objects are partitioned by tenant and by the hour the batch was stored,
+// <prefix>/tenant=<tenant>/date=<YYYY-MM-DD>/hour=<HH>/<batch id>.jsonl.gz, so that a retried batch replaces its object.
+type S3ArchiveSink struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewS3ArchiveSink creates a sink archiving to the configured bucket, nil if no bucket is configured.
+// Region and credentials come from the default chain of the AWS SDK, as for the Kinesis and SQS consumers.
+func NewS3ArchiveSink(cfg *config.SinksConfig) (*S3ArchiveSink, error) {
+	if cfg.S3Bucket == "" {
+		return nil, nil
+	}
+	awsCfg, err := loadAWSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &S3ArchiveSink{client: s3.NewFromConfig(awsCfg), bucket: cfg.S3Bucket, prefix: cfg.S3Prefix}, nil
+}
+
+// Name returns the sink the events are written to
+func (s *S3ArchiveSink) Name() string {
+	return domain.SinkS3
+}
+
+// Write uploads the events of the batch as a single object
+func (s *S3ArchiveSink) Write(ctx context.Context, batch flush.Batch) error {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(writer)
+	for _, event := range batch.Events {
+		if err := encoder.Encode(newSinkRecord(batch, event)); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(s.key(batch)),
+		Body:            bytes.NewReader(buf.Bytes()),
+		ContentType:     aws.String("application/x-ndjson"),
+		ContentEncoding: aws.String("gzip"),
+	})
+	return err
+}
+
+// key returns the object of a batch
+func (s *S3ArchiveSink) key(batch flush.Batch) string {
+	tenant := batch.Tenant
+	if tenant == "" {
+		tenant = "default"
+	}
+	stored := batch.StoredAt.UTC()
+	return path.Join(s.prefix, "tenant="+tenant, "date="+stored.Format("2006-01-02"), fmt.Sprintf("hour=%02d", stored.Hour()),
+		batch.ID()+".jsonl.gz")
+}
+
+// Close does nothing, the S3 client keeps no connections that need closing
+func (s *S3ArchiveSink) Close() error {
+	return nil
+}
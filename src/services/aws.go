@@ -0,0 +1,386 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/buildinfo"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/ingest"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/validations"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	kinesistypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Loggers of the consumers
+var (
+	kinesisLog = logging.Named("Kinesis")
+	sqsLog     = logging.Named("SQS")
+)
+
+// Start positions of Kinesis shards without a checkpoint
+const (
+	KinesisStartTrimHorizon = "TRIM_HORIZON" // the oldest record kept by the stream
+	KinesisStartLatest      = "LATEST"       // the records added since the consumer started
+)
+
+const (
+	// kinesisLockTTL bounds how long a crashed instance keeps a shard from being read, a poll lasts at most the flush ack timeout
+	kinesisLockTTL = 5 * time.Minute
+	// kinesisMaxBatchSize is the most records GetRecords returns
+	kinesisMaxBatchSize = 10000
+	// sqsMaxMessages is the most messages ReceiveMessage returns
+	sqsMaxMessages = 10
+	// sqsRetryInterval is the wait after a failed receive
+	sqsRetryInterval = time.Second
+)
+
+// loadAWSConfig resolves the region and credentials from the default chain of the AWS SDK:
+// AWS_REGION, AWS_ACCESS_KEY_ID, AWS_PROFILE, web identity tokens and instance roles
+func loadAWSConfig() (aws.Config, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// KinesisConsumer ingests the records of a Kinesis stream, each an event in the format of POST /events.
+// Every shard is a stream of the stream ingestion, kinesis:<stream>:<shard>, with the sequence number of its last
+// flushed record as the checkpoint, so that a restarted or another instance resumes the shard after it.
+// A shard is read by one instance at a time, holding a Redis lock, so that instances share the shards.
+type KinesisConsumer struct {
+	client    *kinesis.Client
+	sink      ingest.Sink
+	redisRepo database.ClickHouseRedis
+	cfg       *config.AWSIngestConfig
+	started   time.Time // where shards without checkpoint are read from with LATEST
+	owner     string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stopOnce  sync.Once
+	done      chan struct{}
+}
+
+// NewKinesisConsumer creates a consumer of the configured stream, nil if no stream is configured
+func NewKinesisConsumer(cfg *config.AWSIngestConfig, redisClient database.ClickHouseRedis) (*KinesisConsumer, error) {
+	if cfg.KinesisStream == "" {
+		return nil, nil
+	}
+	if redisClient.Client == nil {
+		return nil, fmt.Errorf("Redis client cannot be nil")
+	}
+	if cfg.KinesisBatchSize <= 0 || cfg.KinesisBatchSize > kinesisMaxBatchSize {
+		return nil, fmt.Errorf("Kinesis batch size must be between 1 and %d, got %d", kinesisMaxBatchSize, cfg.KinesisBatchSize)
+	}
+	if cfg.KinesisPollIntervalMS <= 0 {
+		return nil, fmt.Errorf("Kinesis poll interval must be positive")
+	}
+	if cfg.KinesisStartPosition != KinesisStartTrimHorizon && cfg.KinesisStartPosition != KinesisStartLatest {
+		return nil, fmt.Errorf("unknown Kinesis start position %q, expected %s or %s", cfg.KinesisStartPosition, KinesisStartTrimHorizon, KinesisStartLatest)
+	}
+	if err := validations.ValidateTenantID(cfg.Tenant); err != nil {
+		return nil, fmt.Errorf("invalid AWS ingestion tenant %q", cfg.Tenant)
+	}
+	awsCfg, err := loadAWSConfig()
+	if err != nil {
+		return nil, err
+	}
+	token, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &KinesisConsumer{
+		client:    kinesis.NewFromConfig(awsCfg),
+		redisRepo: redisClient,
+		cfg:       cfg,
+		started:   time.Now(),
+		owner:     buildinfo.GetInfo().Hostname + "-" + token,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// Name returns the source of the events of the consumer
+func (k *KinesisConsumer) Name() string {
+	return domain.SourceKinesis
+}
+
+// Start reads the shards in the background and delivers their records to the sink, polling again right away while
+// a shard returns whole batches. Failures are logged and retried at the next poll. It is safe to call on a nil consumer.
+func (k *KinesisConsumer) Start(sink ingest.Sink) {
+	if k == nil {
+		return
+	}
+	k.sink = sink
+	kinesisLog.Infof("Consuming stream %s", k.cfg.KinesisStream)
+	go func() {
+		defer close(k.done)
+		interval := time.Duration(k.cfg.KinesisPollIntervalMS) * time.Millisecond
+		for {
+			wait := interval
+			if k.poll(k.ctx) {
+				wait = 0
+			}
+			select {
+			case <-k.ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// Stop stops reading, records whose events are not flushed yet are read again later. It is safe to call on a nil consumer.
+func (k *KinesisConsumer) Stop() {
+	if k == nil {
+		return
+	}
+	k.stopOnce.Do(func() {
+		k.cancel()
+		<-k.done
+		kinesisLog.Infof("Stopped")
+	})
+}
+
+// poll reads a batch of each shard not read by another instance, returns whether a shard has more records
+func (k *KinesisConsumer) poll(ctx context.Context) bool {
+	shards, err := k.listShards(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			kinesisLog.Errorf("Failed to list the shards of %s: %v", k.cfg.KinesisStream, err)
+		}
+		return false
+	}
+	more := false
+	for _, shard := range shards {
+		read, err := k.pollShard(ctx, shard)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			kinesisLog.Errorf("Failed to consume shard %s of %s: %v", shard, k.cfg.KinesisStream, err)
+			continue
+		}
+		more = more || read >= k.cfg.KinesisBatchSize
+	}
+	return more
+}
+
+// listShards returns the ids of the shards of the stream, including closed ones still holding records
+func (k *KinesisConsumer) listShards(ctx context.Context) ([]string, error) {
+	var shards []string
+	input := &kinesis.ListShardsInput{StreamName: aws.String(k.cfg.KinesisStream)}
+	for {
+		output, err := k.client.ListShards(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, shard := range output.Shards {
+			shards = append(shards, aws.ToString(shard.ShardId))
+		}
+		if output.NextToken == nil {
+			return shards, nil
+		}
+		input = &kinesis.ListShardsInput{NextToken: output.NextToken}
+	}
+}
+
+// pollShard reads a batch of records of the shard after its checkpoint and ingests them, returns how many were read
+func (k *KinesisConsumer) pollShard(ctx context.Context, shard string) (int, error) {
+	lock := database.KinesisLockKeyPrefix + k.cfg.KinesisStream + ":" + shard
+	acquired, err := k.redisRepo.AcquireLock(ctx, lock, k.owner, kinesisLockTTL)
+	if err != nil || !acquired {
+		return 0, err
+	}
+	defer func() {
+		if err := k.redisRepo.ReleaseLock(context.Background(), lock, k.owner); err != nil {
+			kinesisLog.Errorf("Failed to release the lock of shard %s: %v", shard, err)
+		}
+	}()
+
+	streamID := "kinesis:" + k.cfg.KinesisStream + ":" + shard
+	checkpoint, err := k.sink.Checkpoint(ctx, streamID)
+	if err != nil {
+		return 0, err
+	}
+	input := &kinesis.GetShardIteratorInput{
+		StreamName: aws.String(k.cfg.KinesisStream),
+		ShardId:    aws.String(shard),
+	}
+	switch {
+	case checkpoint != "":
+		input.ShardIteratorType = kinesistypes.ShardIteratorTypeAfterSequenceNumber
+		input.StartingSequenceNumber = aws.String(checkpoint)
+	case k.cfg.KinesisStartPosition == KinesisStartLatest:
+		// A LATEST iterator of each poll would skip the records added between the polls
+		input.ShardIteratorType = kinesistypes.ShardIteratorTypeAtTimestamp
+		input.Timestamp = aws.Time(k.started)
+	default:
+		input.ShardIteratorType = kinesistypes.ShardIteratorTypeTrimHorizon
+	}
+	iterator, err := k.client.GetShardIterator(ctx, input)
+	if err != nil {
+		return 0, err
+	}
+	output, err := k.client.GetRecords(ctx, &kinesis.GetRecordsInput{
+		ShardIterator: iterator.ShardIterator,
+		Limit:         aws.Int32(int32(k.cfg.KinesisBatchSize)),
+	})
+	if err != nil || len(output.Records) == 0 {
+		return 0, err
+	}
+
+	chunk := ingest.Chunk{StreamID: streamID, Checkpoint: aws.ToString(output.Records[len(output.Records)-1].SequenceNumber)}
+	for _, record := range output.Records {
+		event, err := ingest.ParseEvent(record.Data, k.cfg.Tenant, domain.SourceKinesis)
+		if err != nil {
+			kinesisLog.Warnf("Skipping invalid record %s of shard %s: %v", aws.ToString(record.SequenceNumber), shard, err)
+			chunk.Invalid++
+			continue
+		}
+		chunk.Events = append(chunk.Events, event)
+	}
+	return len(output.Records), k.sink.Deliver(ctx, chunk)
+}
+
+// SQSConsumer ingests the messages of an SQS queue, each an event in the format of POST /events.
+// Messages are deleted once their events are flushed, otherwise they are received again after their
+// visibility timeout, so that the queue itself keeps the progress. Instances receive from the queue in parallel.
+type SQSConsumer struct {
+	client   *sqs.Client
+	sink     ingest.Sink
+	cfg      *config.AWSIngestConfig
+	streamID string // stream the id of the last flushed message is stored under, sqs:<queue name>
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewSQSConsumer creates a consumer of the configured queue, nil if no queue is configured
+func NewSQSConsumer(cfg *config.AWSIngestConfig) (*SQSConsumer, error) {
+	if cfg.SQSQueueURL == "" {
+		return nil, nil
+	}
+	if cfg.SQSWaitSeconds < 0 || cfg.SQSWaitSeconds > 20 {
+		return nil, fmt.Errorf("SQS wait time must be between 0 and 20 seconds, got %d", cfg.SQSWaitSeconds)
+	}
+	if cfg.SQSVisibilityTimeoutSeconds < 0 {
+		return nil, fmt.Errorf("SQS visibility timeout cannot be negative")
+	}
+	if err := validations.ValidateTenantID(cfg.Tenant); err != nil {
+		return nil, fmt.Errorf("invalid AWS ingestion tenant %q", cfg.Tenant)
+	}
+	awsCfg, err := loadAWSConfig()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SQSConsumer{
+		client:   sqs.NewFromConfig(awsCfg),
+		cfg:      cfg,
+		streamID: "sqs:" + path.Base(cfg.SQSQueueURL),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Name returns the source of the events of the consumer
+func (s *SQSConsumer) Name() string {
+	return domain.SourceSQS
+}
+
+// Start receives messages in the background and delivers them to the sink, failures are logged and retried.
+// It is safe to call on a nil consumer.
+func (s *SQSConsumer) Start(sink ingest.Sink) {
+	if s == nil {
+		return
+	}
+	s.sink = sink
+	sqsLog.Infof("Consuming queue %s", s.cfg.SQSQueueURL)
+	go func() {
+		defer close(s.done)
+		for s.ctx.Err() == nil {
+			if err := s.receive(s.ctx); err != nil && s.ctx.Err() == nil {
+				sqsLog.Errorf("Failed to consume %s: %v", s.cfg.SQSQueueURL, err)
+				select {
+				case <-s.ctx.Done():
+				case <-time.After(sqsRetryInterval):
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops receiving, messages whose events are not flushed yet are received again. It is safe to call on a nil consumer.
+func (s *SQSConsumer) Stop() {
+	if s == nil {
+		return
+	}
+	s.stopOnce.Do(func() {
+		s.cancel()
+		<-s.done
+		sqsLog.Infof("Stopped")
+	})
+}
+
+// receive ingests a batch of messages and deletes them once their events are flushed
+func (s *SQSConsumer) receive(ctx context.Context) error {
+	output, err := s.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(s.cfg.SQSQueueURL),
+		MaxNumberOfMessages: sqsMaxMessages,
+		WaitTimeSeconds:     int32(s.cfg.SQSWaitSeconds),
+		VisibilityTimeout:   int32(s.cfg.SQSVisibilityTimeoutSeconds),
+	})
+	if err != nil || len(output.Messages) == 0 {
+		return err
+	}
+
+	chunk := ingest.Chunk{StreamID: s.streamID, Checkpoint: aws.ToString(output.Messages[len(output.Messages)-1].MessageId)}
+	entries := make([]sqstypes.DeleteMessageBatchRequestEntry, 0, len(output.Messages))
+	for i, message := range output.Messages {
+		entries = append(entries, sqstypes.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(fmt.Sprint(i)),
+			ReceiptHandle: message.ReceiptHandle,
+		})
+		event, err := ingest.ParseEvent([]byte(aws.ToString(message.Body)), s.cfg.Tenant, domain.SourceSQS)
+		if err != nil {
+			sqsLog.Warnf("Skipping invalid message %s: %v", aws.ToString(message.MessageId), err)
+			chunk.Invalid++
+			continue
+		}
+		chunk.Events = append(chunk.Events, event)
+	}
+	if err := s.sink.Deliver(ctx, chunk); err != nil {
+		return err
+	}
+
+	deleted, err := s.client.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(s.cfg.SQSQueueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete flushed messages: %w", err)
+	}
+	if len(deleted.Failed) > 0 {
+		// They are received again and dropped by deduplication
+		sqsLog.Errorf("Failed to delete %d flushed messages of %s: %s", len(deleted.Failed), s.cfg.SQSQueueURL, aws.ToString(deleted.Failed[0].Message))
+	}
+	return nil
+}
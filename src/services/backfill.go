@@ -0,0 +1,851 @@
+package services
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/flush"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/transform"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backfillLog logs the messages of the Backfills
+var backfillLog = logging.Named("Backfills")
+
+var (
+	// ErrBackfillsDisabled is returned when backfill jobs are used without BACKFILL_DIR
+	ErrBackfillsDisabled = errors.New("backfills are not enabled")
+	// ErrBackfillNotFound is returned for unknown backfill jobs or the ones of other tenants
+	ErrBackfillNotFound = errors.New("backfill job not found")
+	// ErrBackfillNotReceiving is returned when events are uploaded to a job that was started, or whose id another tenant uses
+	ErrBackfillNotReceiving = errors.New("backfill job is not receiving events")
+)
+
+const (
+	// backfillManifestFile holds the state of a job and the checkpoints of its partitions, in the directory of the job
+	backfillManifestFile = "manifest.json"
+	// backfillDayLayout names the day partitions as toYYYYMMDD(timestamp) does
+	backfillDayLayout = "20060102"
+	// backfillInsertTimeout bounds the insert of a block of rows
+	backfillInsertTimeout = 5 * time.Minute
+	// backfillInsertAttempts is how often a block is inserted before its job fails
+	backfillInsertAttempts = 3
+)
+
+// backfillManifest is the state of a backfill job as stored on disk
+type backfillManifest struct {
+	ID         string                               `json:"id"`
+	Tenant     string                               `json:"tenant"`
+	Status     string                               `json:"status"`
+	Error      string                               `json:"error,omitempty"`
+	CreatedAt  int64                                `json:"created_at"`
+	StartedAt  int64                                `json:"started_at,omitempty"`
+	FinishedAt int64                                `json:"finished_at,omitempty"`
+	Partitions map[string]*domain.BackfillPartition `json:"partitions"`
+}
+
+type backfillJob struct {
+	dir string
+
+	mu       sync.Mutex
+	manifest backfillManifest
+	queued   map[string]bool // days queued or being written, so that restarting a failed job does not write a day twice
+}
+
+// backfillTask is a day partition of a job to write
+type backfillTask struct {
+	job *backfillJob
+	day string
+}
+
+var _ domain.BackfillService = &Backfills{}
+
+// Backfills writes historical events directly into their day partitions, bypassing the batcher. Flushes of the batcher
+// mix events of many days, and every insert creates a part in each partition it touches, so importing months of events
+// through it creates thousands of tiny parts that ClickHouse then has to merge. A backfill job instead spools the uploaded
+// events to a file per day, then workers write the days in parallel, each in blocks of rows sorted by the sorting key
+// of the events table, so that each insert creates a single part. The job records in its manifest how many events of each
+// day were written after each block, the checkpoint its days resume from after a failure or a restart.
+type Backfills struct {
+	dir        string
+	workers    int
+	blockRows  int
+	dedup      database.Deduplicator
+	sinks      *flush.Manager
+	rewrites   *RewriteRules
+	transforms *transform.Pipeline
+	parts      *PartsGuard
+	schema     *SchemaCoordinator
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+
+	mu     sync.Mutex
+	jobs   map[string]*backfillJob
+	queue  []backfillTask
+	notify chan struct{}
+}
+
+// NewBackfills opens the backfill jobs of the configured directory, resuming the ones that were running once started.
+// Backfill jobs are disabled if the directory is not set.
+func NewBackfills(cfg *config.ClickHouseConfig, dedup database.Deduplicator, sinks *flush.Manager, rewrites *RewriteRules, transforms *transform.Pipeline, parts *PartsGuard, schema *SchemaCoordinator) (*Backfills, error) {
+	if cfg.BackfillDir == "" {
+		return &Backfills{}, nil
+	}
+	if cfg.BackfillWorkers <= 0 || cfg.BackfillBlockRows <= 0 {
+		return nil, fmt.Errorf("backfill workers and block rows must be positive")
+	}
+	if err := os.MkdirAll(cfg.BackfillDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create backfill directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Backfills{
+		dir:        cfg.BackfillDir,
+		workers:    cfg.BackfillWorkers,
+		blockRows:  cfg.BackfillBlockRows,
+		dedup:      dedup,
+		sinks:      sinks,
+		rewrites:   rewrites,
+		transforms: transforms,
+		parts:      parts,
+		schema:     schema,
+		ctx:        ctx,
+		cancel:     cancel,
+		jobs:       make(map[string]*backfillJob),
+		notify:     make(chan struct{}, 1),
+	}
+	if err := b.recover(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to recover backfill jobs: %w", err)
+	}
+	return b, nil
+}
+
+// recover loads the jobs of the directory and queues the unwritten days of the running ones
+func (b *Backfills) recover() error {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(b.dir, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, backfillManifestFile))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		job := &backfillJob{dir: dir}
+		if err := json.Unmarshal(data, &job.manifest); err != nil {
+			return fmt.Errorf("invalid manifest of backfill job %s: %w", entry.Name(), err)
+		}
+		b.jobs[job.manifest.ID] = job
+		if job.manifest.Status == domain.BackfillRunning {
+			backfillLog.Infof("Resuming backfill job %s", job.manifest.ID)
+			b.enqueue(job)
+		}
+	}
+	return nil
+}
+
+// Start starts the workers writing the day partitions, it does nothing if backfill jobs are disabled
+func (b *Backfills) Start() {
+	if b.dir == "" {
+		return
+	}
+	for range b.workers {
+		b.wg.Add(1)
+		go b.work()
+	}
+	backfillLog.Infof("Started %d backfill worker(s) writing blocks of %d rows", b.workers, b.blockRows)
+}
+
+// Stop stops the workers once their block in progress is written, the jobs resume from their checkpoints after the next start
+func (b *Backfills) Stop() {
+	if b.dir == "" {
+		return
+	}
+	b.cancel()
+	b.wg.Wait()
+}
+
+// enqueue queues the days of a job that are not written yet, oldest first. The job's lock must not be held.
+func (b *Backfills) enqueue(job *backfillJob) {
+	job.mu.Lock()
+	if job.queued == nil {
+		job.queued = make(map[string]bool)
+	}
+	var days []string
+	for day, partition := range job.manifest.Partitions {
+		if !partition.Done && !job.queued[day] {
+			days = append(days, day)
+			job.queued[day] = true
+		}
+	}
+	job.mu.Unlock()
+	slices.Sort(days)
+
+	b.mu.Lock()
+	for _, day := range days {
+		b.queue = append(b.queue, backfillTask{job: job, day: day})
+	}
+	b.mu.Unlock()
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next queued day, false once stopped
+func (b *Backfills) next() (backfillTask, bool) {
+	for {
+		b.mu.Lock()
+		if len(b.queue) > 0 {
+			task := b.queue[0]
+			b.queue = b.queue[1:]
+			more := len(b.queue) > 0
+			b.mu.Unlock()
+			if more {
+				// Wake up another worker for the remaining days
+				select {
+				case b.notify <- struct{}{}:
+				default:
+				}
+			}
+			return task, true
+		}
+		b.mu.Unlock()
+		select {
+		case <-b.notify:
+		case <-b.ctx.Done():
+			return backfillTask{}, false
+		}
+	}
+}
+
+// work writes the queued days until stopped
+func (b *Backfills) work() {
+	defer b.wg.Done()
+	for {
+		task, ok := b.next()
+		if !ok {
+			return
+		}
+		err := b.writePartition(task)
+		task.job.mu.Lock()
+		delete(task.job.queued, task.day)
+		task.job.mu.Unlock()
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			backfillLog.Errorf("Backfill job %s failed writing day %s: %v", task.job.manifest.ID, task.day, err)
+			task.job.fail(fmt.Errorf("day %s: %w", task.day, err))
+		}
+	}
+}
+
+// writePartition writes the events of a day not written yet, sorted by the sorting key of the events table,
+// a block of rows per insert, checkpointing the job after each block. A day larger than a block is sorted on disk,
+// so that a worker holds about two blocks of events in memory however many events the day has.
+func (b *Backfills) writePartition(task backfillTask) error {
+	job := task.job
+	job.mu.Lock()
+	status, tenant, partition := job.manifest.Status, job.manifest.Tenant, *job.manifest.Partitions[task.day]
+	job.mu.Unlock()
+	if status != domain.BackfillRunning || partition.Done {
+		// The job failed writing another day
+		return nil
+	}
+
+	runsDir := job.runsPath(task.day)
+	// Runs left by a worker that was stopped are sorted again
+	if err := os.RemoveAll(runsDir); err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(runsDir); err != nil {
+			backfillLog.Warnf("Failed to remove sorted runs of day %s of backfill job %s: %v", task.day, job.manifest.ID, err)
+		}
+	}()
+	merge, err := sortBackfillPartition(job.partitionPath(task.day), runsDir, b.blockRows)
+	if err != nil {
+		return err
+	}
+	defer merge.close()
+
+	block := make([]domain.EventRequest, 0, min(b.blockRows, partition.Events))
+	for offset := 0; ; offset++ {
+		event, ok, err := merge.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			if offset < partition.Written {
+				continue
+			}
+			if block = append(block, event); len(block) < b.blockRows {
+				continue
+			}
+		}
+		if len(block) > 0 {
+			if b.ctx.Err() != nil {
+				return b.ctx.Err()
+			}
+			skipped, err := b.writeBlock(tenant, block)
+			if err != nil {
+				return err
+			}
+			if err := job.checkpoint(task.day, len(block), skipped); err != nil {
+				return err
+			}
+			block = block[:0]
+		}
+		if !ok {
+			break
+		}
+	}
+	return job.completePartition(task.day)
+}
+
+// writeBlock inserts the events of a block that were not stored before and returns the number of the others
+func (b *Backfills) writeBlock(tenant string, block []domain.EventRequest) (int, error) {
+	if waited, err := b.schema.WaitRewrite(b.ctx); err != nil {
+		return 0, err
+	} else if waited > 0 {
+		backfillLog.Warnf("Held back a block of %d backfill events for %v, a schema migration rewrote the events table", len(block), waited)
+	}
+	// Blocks are large and few, but they still add parts while the merges fall behind
+	if waited, err := b.parts.Wait(b.ctx); err != nil {
+		return 0, err
+	} else if waited > 0 {
+		backfillLog.Warnf("Held back a block of %d backfill events for %v, the events tables have too many parts", len(block), waited)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), backfillInsertTimeout)
+	defer cancel()
+
+	unprocessed := block
+	processed, err := b.dedup.AreEventsProcessed(ctx, block)
+	if err != nil {
+		// Written anyway, ClickHouse deduplicates a copy
+		backfillLog.Warnf("Failed to check whether %d backfill events were processed: %v", len(block), err)
+	} else {
+		unprocessed = make([]domain.EventRequest, 0, len(block))
+		for _, event := range block {
+			if !processed[event.GetUniqueKey()] {
+				unprocessed = append(unprocessed, event)
+			}
+		}
+	}
+	skipped := len(block) - len(unprocessed)
+	backfillEventsTotal.WithLabelValues("skipped").Add(float64(skipped))
+	if len(unprocessed) == 0 {
+		return skipped, nil
+	}
+
+	batch, err := flush.NewBatch(tenant, unprocessed)
+	if err != nil {
+		return 0, err
+	}
+	for attempt := 1; ; attempt++ {
+		if err = b.sinks.Write(ctx, batch); err == nil {
+			break
+		}
+		recordInsertError("events")
+		if attempt == backfillInsertAttempts {
+			return 0, fmt.Errorf("failed to insert batch %s of %d events: %w", batch.ID(), len(unprocessed), err)
+		}
+		backfillLog.Warnf("Retrying insert of batch %s of %d backfill events: %v", batch.ID(), len(unprocessed), err)
+		select {
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	backfillEventsTotal.WithLabelValues("written").Add(float64(len(unprocessed)))
+	recordStored(batch.Events, batch.Columns)
+	b.sinks.Replicate(batch)
+	if err := b.dedup.SetMultipleEventsProcessed(ctx, unprocessed); err != nil {
+		backfillLog.Errorf("Failed to mark %d backfill events as processed: %v", len(unprocessed), err)
+	}
+	return skipped, nil
+}
+
+// compareBackfillEvents orders events by the sorting key of the events table
+func compareBackfillEvents(a, b domain.EventRequest) int {
+	return cmp.Or(
+		a.EventTime().Compare(b.EventTime()),
+		strings.Compare(a.EventName, b.EventName),
+		strings.Compare(a.Channel, b.Channel),
+		strings.Compare(a.UserID, b.UserID),
+	)
+}
+
+// backfillRun is a sorted run of the events of a day, read one event at a time while the runs are merged
+type backfillRun struct {
+	index   int                   // position of the run in the file of the day, which breaks ties so that the merge is stable
+	events  []domain.EventRequest // events of a run kept in memory, when the day fits in a single run
+	file    *os.File
+	scanner *bufio.Scanner
+	head    domain.EventRequest // next event of the run
+}
+
+// spill writes the events of the run to a file of the directory and reads them back from its start
+func (r *backfillRun) spill(dir string, events []domain.EventRequest) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%d.jsonl", r.index)), os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, event := range events {
+		if err := encoder.Encode(newOverflowRecord(event)); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return err
+	}
+	r.scanner = bufio.NewScanner(file)
+	r.scanner.Buffer(make([]byte, 0, 64*1024), maxOverflowLineBytes)
+	return nil
+}
+
+// advance reads the next event of the run into its head, false once the run is exhausted
+func (r *backfillRun) advance() (bool, error) {
+	if r.scanner == nil {
+		if len(r.events) == 0 {
+			return false, nil
+		}
+		r.head, r.events = r.events[0], r.events[1:]
+		return true, nil
+	}
+	if !r.scanner.Scan() {
+		return false, r.scanner.Err()
+	}
+	var record overflowRecord
+	if err := json.Unmarshal(r.scanner.Bytes(), &record); err != nil {
+		return false, fmt.Errorf("invalid event in %s: %w", r.file.Name(), err)
+	}
+	r.head = record.event()
+	return true, nil
+}
+
+// backfillMerge merges the sorted runs of a day, a heap of the runs by their head
+type backfillMerge []*backfillRun
+
+func (m backfillMerge) Len() int { return len(m) }
+func (m backfillMerge) Less(i, j int) bool {
+	return cmp.Or(compareBackfillEvents(m[i].head, m[j].head), cmp.Compare(m[i].index, m[j].index)) < 0
+}
+func (m backfillMerge) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m *backfillMerge) Push(x any)   { *m = append(*m, x.(*backfillRun)) }
+func (m *backfillMerge) Pop() any {
+	old := *m
+	run := old[len(old)-1]
+	*m = old[:len(old)-1]
+	return run
+}
+
+// sortBackfillPartition reads the events spooled to the file of a day in runs of runEvents events, sorted stably in the
+// order they were received, so that the sort, and thereby the checkpoint, is the same across restarts. Runs are spilled
+// to files of runsDir unless the day fits in a single run.
+func sortBackfillPartition(path, runsDir string, runEvents int) (*backfillMerge, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	merge := &backfillMerge{}
+	var runs []*backfillRun // every run, to close the ones exhausted already on errors
+	fail := func(err error) (*backfillMerge, error) {
+		for _, run := range runs {
+			if run.file != nil {
+				run.file.Close()
+			}
+		}
+		return nil, err
+	}
+	var events []domain.EventRequest
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxOverflowLineBytes)
+	for {
+		more := scanner.Scan()
+		if more {
+			var record overflowRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				return fail(fmt.Errorf("invalid event in %s: %w", path, err))
+			}
+			if events = append(events, record.event()); len(events) < runEvents {
+				continue
+			}
+		} else if err := scanner.Err(); err != nil {
+			return fail(err)
+		}
+		if len(events) == 0 {
+			break
+		}
+
+		slices.SortStableFunc(events, compareBackfillEvents)
+		run := &backfillRun{index: len(runs)}
+		runs = append(runs, run)
+		if !more && len(runs) == 1 {
+			run.events = events
+		} else {
+			if err := run.spill(runsDir, events); err != nil {
+				return fail(err)
+			}
+			events = events[:0]
+		}
+		if ok, err := run.advance(); err != nil {
+			return fail(err)
+		} else if ok {
+			*merge = append(*merge, run)
+		}
+		if !more {
+			break
+		}
+	}
+	heap.Init(merge)
+	return merge, nil
+}
+
+// next returns the next event of the day in the order of the sorting key, false once every run is exhausted
+func (m *backfillMerge) next() (domain.EventRequest, bool, error) {
+	if len(*m) == 0 {
+		return domain.EventRequest{}, false, nil
+	}
+	run := (*m)[0]
+	event := run.head
+	ok, err := run.advance()
+	if err != nil {
+		return domain.EventRequest{}, false, err
+	}
+	if ok {
+		heap.Fix(m, 0)
+	} else {
+		heap.Pop(m)
+		if run.file != nil {
+			run.file.Close()
+		}
+	}
+	return event, true, nil
+}
+
+// close closes the files of the runs that are not exhausted
+func (m *backfillMerge) close() {
+	for _, run := range *m {
+		if run.file != nil {
+			run.file.Close()
+		}
+	}
+}
+
+// partitionPath returns the file the events of a day are spooled to
+func (j *backfillJob) partitionPath(day string) string {
+	return filepath.Join(j.dir, day+".jsonl")
+}
+
+// runsPath returns the directory the sorted runs of a day are spilled to while it is written
+func (j *backfillJob) runsPath(day string) string {
+	return filepath.Join(j.dir, day+".runs")
+}
+
+// save writes the manifest of the job, replacing the previous one at once. The job's lock must be held.
+func (j *backfillJob) save() error {
+	data, err := json.Marshal(j.manifest)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(j.dir, backfillManifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(j.dir, backfillManifestFile))
+}
+
+// checkpoint records that a block of events of a day was written
+func (j *backfillJob) checkpoint(day string, events, skipped int) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	partition := j.manifest.Partitions[day]
+	partition.Written += events
+	partition.Skipped += skipped
+	return j.save()
+}
+
+// completePartition marks a day as written and removes its file, completing the job with its last day
+func (j *backfillJob) completePartition(day string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.manifest.Partitions[day].Done = true
+	if err := os.Remove(j.partitionPath(day)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		backfillLog.Warnf("Failed to remove written day %s of backfill job %s: %v", day, j.manifest.ID, err)
+	}
+	if j.manifest.Status == domain.BackfillRunning && !slices.ContainsFunc(j.partitions(), func(p domain.BackfillPartition) bool { return !p.Done }) {
+		j.manifest.Status = domain.BackfillSucceeded
+		j.manifest.FinishedAt = time.Now().Unix()
+		backfillLog.Infof("Backfill job %s succeeded", j.manifest.ID)
+	}
+	return j.save()
+}
+
+// fail marks the job as failed, its remaining days are not written until it is started again
+func (j *backfillJob) fail(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.manifest.Status != domain.BackfillRunning {
+		return
+	}
+	j.manifest.Status = domain.BackfillFailed
+	j.manifest.Error = err.Error()
+	j.manifest.FinishedAt = time.Now().Unix()
+	if err := j.save(); err != nil {
+		backfillLog.Errorf("Failed to save the state of backfill job %s: %v", j.manifest.ID, err)
+	}
+}
+
+// partitions returns the progress of the days of the job, oldest first. The job's lock must be held.
+func (j *backfillJob) partitions() []domain.BackfillPartition {
+	partitions := make([]domain.BackfillPartition, 0, len(j.manifest.Partitions))
+	for day, partition := range j.manifest.Partitions {
+		p := *partition
+		p.Day = day
+		partitions = append(partitions, p)
+	}
+	slices.SortFunc(partitions, func(a, b domain.BackfillPartition) int { return strings.Compare(a.Day, b.Day) })
+	return partitions
+}
+
+// response returns the state of the job. The job's lock must be held.
+func (j *backfillJob) response(message string) *domain.BackfillResponse {
+	resp := &domain.BackfillResponse{
+		Success:    true,
+		Message:    message,
+		JobID:      j.manifest.ID,
+		Status:     j.manifest.Status,
+		Error:      j.manifest.Error,
+		CreatedAt:  j.manifest.CreatedAt,
+		StartedAt:  j.manifest.StartedAt,
+		FinishedAt: j.manifest.FinishedAt,
+		Partitions: j.partitions(),
+	}
+	for _, partition := range resp.Partitions {
+		resp.Events += partition.Events
+		resp.Written += partition.Written - partition.Skipped
+		resp.Skipped += partition.Skipped
+	}
+	return resp
+}
+
+// job returns the job of a tenant, creating it if create is set and no job has the id
+func (b *Backfills) job(tenant, id string, create bool) (*backfillJob, error) {
+	if b.dir == "" {
+		return nil, ErrBackfillsDisabled
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.jobs[id]
+	if ok {
+		if job.manifest.Tenant != tenant {
+			if create {
+				return nil, ErrBackfillNotReceiving
+			}
+			return nil, ErrBackfillNotFound
+		}
+		return job, nil
+	}
+	if !create {
+		return nil, ErrBackfillNotFound
+	}
+
+	job = &backfillJob{
+		dir: filepath.Join(b.dir, id),
+		manifest: backfillManifest{
+			ID:         id,
+			Tenant:     tenant,
+			Status:     domain.BackfillReceiving,
+			CreatedAt:  time.Now().Unix(),
+			Partitions: make(map[string]*domain.BackfillPartition),
+		},
+	}
+	if err := os.MkdirAll(job.dir, 0o700); err != nil {
+		return nil, err
+	}
+	b.jobs[id] = job
+	return job, nil
+}
+
+// AppendBackfill spools a chunk of events to their days, creating the job with its first chunk.
+// The events are rewritten and transformed as they are received, nothing is written until the job is started.
+func (b *Backfills) AppendBackfill(ctx context.Context, request *domain.BackfillRequest) (*domain.BackfillResponse, error) {
+	job, err := b.job(request.Tenant, request.ID, true)
+	if err != nil {
+		return &domain.BackfillResponse{
+			Success: false,
+			Message: "Failed to receive backfill chunk: " + err.Error(),
+			JobID:   request.ID,
+		}, err
+	}
+
+	stampRequestID(ctx, request.Events)
+	b.rewrites.Apply(request.Events)
+	events := b.transforms.Apply(request.Events)
+	days := make(map[string][]domain.EventRequest)
+	for _, event := range events {
+		day := event.EventTime().UTC().Format(backfillDayLayout)
+		days[day] = append(days[day], event)
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.manifest.Status != domain.BackfillReceiving {
+		return &domain.BackfillResponse{
+			Success: false,
+			Message: "Backfill job was started, upload the remaining events to a new job",
+			JobID:   request.ID,
+			Status:  job.manifest.Status,
+		}, ErrBackfillNotReceiving
+	}
+	for day, dayEvents := range days {
+		if err := job.spool(day, dayEvents); err != nil {
+			// Events of the chunk spooled before are counted and written, resending the chunk stores them once
+			if saveErr := job.save(); saveErr != nil {
+				err = errors.Join(err, saveErr)
+			}
+			return &domain.BackfillResponse{
+				Success: false,
+				Message: "Failed to spool backfill chunk: " + err.Error(),
+				JobID:   request.ID,
+			}, err
+		}
+	}
+	if err := job.save(); err != nil {
+		return &domain.BackfillResponse{
+			Success: false,
+			Message: "Failed to save backfill job: " + err.Error(),
+			JobID:   request.ID,
+		}, err
+	}
+	backfillEventsTotal.WithLabelValues("received").Add(float64(len(events)))
+	recordIngested(events)
+
+	resp := job.response("Backfill chunk received")
+	resp.Count = len(request.Events)
+	return resp, nil
+}
+
+// spool appends the events of a day to its file. The job's lock must be held.
+func (j *backfillJob) spool(day string, events []domain.EventRequest) error {
+	var data []byte
+	for _, event := range events {
+		line, err := json.Marshal(newOverflowRecord(event))
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	file, err := os.OpenFile(j.partitionPath(day), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	partition, ok := j.manifest.Partitions[day]
+	if !ok {
+		partition = &domain.BackfillPartition{}
+		j.manifest.Partitions[day] = partition
+	}
+	partition.Events += len(events)
+	return nil
+}
+
+// StartBackfill starts writing the days of a job that is receiving events, or resumes a failed job from its checkpoints
+func (b *Backfills) StartBackfill(ctx context.Context, tenant, id string) (*domain.BackfillResponse, error) {
+	job, err := b.job(tenant, id, false)
+	if err != nil {
+		return &domain.BackfillResponse{
+			Success: false,
+			Message: "Failed to start backfill job: " + err.Error(),
+			JobID:   id,
+		}, err
+	}
+
+	job.mu.Lock()
+	if job.manifest.Status == domain.BackfillRunning || job.manifest.Status == domain.BackfillSucceeded {
+		resp := job.response("Backfill job was already started")
+		job.mu.Unlock()
+		return resp, nil
+	}
+	job.manifest.Status = domain.BackfillRunning
+	job.manifest.Error = ""
+	job.manifest.StartedAt = time.Now().Unix()
+	job.manifest.FinishedAt = 0
+	if !slices.ContainsFunc(job.partitions(), func(p domain.BackfillPartition) bool { return !p.Done }) {
+		// Every event of the job was dropped by a transform
+		job.manifest.Status = domain.BackfillSucceeded
+		job.manifest.FinishedAt = job.manifest.StartedAt
+	}
+	if err := job.save(); err != nil {
+		job.mu.Unlock()
+		return &domain.BackfillResponse{
+			Success: false,
+			Message: "Failed to save backfill job: " + err.Error(),
+			JobID:   id,
+		}, err
+	}
+	resp := job.response("Backfill job started")
+	job.mu.Unlock()
+
+	backfillLog.Infof("Starting backfill job %s of %d events in %d day(s)", id, resp.Events, len(resp.Partitions))
+	b.enqueue(job)
+	return resp, nil
+}
+
+// GetBackfill returns the state of a job of the tenant and the progress of its days
+func (b *Backfills) GetBackfill(ctx context.Context, tenant, id string) (*domain.BackfillResponse, error) {
+	job, err := b.job(tenant, id, false)
+	if err != nil {
+		return &domain.BackfillResponse{
+			Success: false,
+			Message: "Failed to retrieve backfill job: " + err.Error(),
+			JobID:   id,
+		}, err
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.response("Backfill job retrieved successfully"), nil
+}
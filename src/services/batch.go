@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"sync"
+)
+
+// maxConcurrentBatchQueries bounds the queries of a metric batch running at once against ClickHouse
+const maxConcurrentBatchQueries = 4
+
+// GetMetricsBatch runs the queries of a batch concurrently, at most maxConcurrentBatchQueries at a time,
+// and returns their results in the order of the queries. A failed query does not fail the others,
+// the errors of the failed queries are joined and returned with all the results.
+// A pinned batch reads the watermark once and pins the queries without their own as_of to it, they all count the same snapshot.
+func (e eventService) GetMetricsBatch(ctx context.Context, request *domain.MetricBatchRequest) (*domain.MetricBatchResponse, error) {
+	asOf := request.AsOf
+	if asOf == nil && request.Pin && len(request.Queries) > 0 {
+		pinned := domain.MetricRequest{Tenant: request.Queries[0].Tenant, Pin: true}
+		tenantDB, err := e.tenants.Database(ctx, pinned.Tenant)
+		if err == nil {
+			err = e.pinMetricRequest(ctx, tenantDB, &pinned)
+		}
+		if err != nil {
+			return &domain.MetricBatchResponse{
+				Success: false,
+				Message: "Failed to retrieve metrics: " + err.Error(),
+			}, err
+		}
+		asOf = pinned.AsOf
+	}
+	if asOf != nil {
+		for i := range request.Queries {
+			if request.Queries[i].AsOf == nil {
+				request.Queries[i].AsOf = asOf
+			}
+		}
+	}
+
+	results := make([]domain.MetricBatchResult, len(request.Queries))
+	errs := make([]error, len(request.Queries))
+	inFlight := make(chan struct{}, maxConcurrentBatchQueries)
+	var wg sync.WaitGroup
+	for i := range request.Queries {
+		results[i].Index = i
+		inFlight <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-inFlight
+				wg.Done()
+			}()
+			resp, err := e.GetMetrics(ctx, &request.Queries[i])
+			results[i].MetricResponse = *resp
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	resp := &domain.MetricBatchResponse{
+		Success: true,
+		Message: "Metrics retrieved successfully",
+		Results: results,
+	}
+	if asOf != nil {
+		resp.AsOf = *asOf
+	}
+	if failed > 0 {
+		resp.Success = false
+		resp.Message = fmt.Sprintf("%d of %d metric queries failed", failed, len(results))
+		return resp, errors.Join(errs...)
+	}
+	return resp, nil
+}
@@ -3,32 +3,96 @@ package services
 import (
 	"context"
 	"errors"
+	"kucukaslan/clickhouse/config"
 	"kucukaslan/clickhouse/database"
 	"kucukaslan/clickhouse/domain"
-	"log"
+	"kucukaslan/clickhouse/flush"
+	"kucukaslan/clickhouse/logging"
+	"math/rand/v2"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.uber.org/zap"
 )
 
+// batcherLog logs the messages of the EventBatcher
+var batcherLog = logging.Named("EventBatcher")
+
 var (
 	// ErrBufferFull is returned when the event buffer channel is full
 	ErrBufferFull = errors.New("event buffer is full")
+	// ErrFlushTimeout is returned when enqueued events are not flushed in time
+	ErrFlushTimeout = errors.New("timed out waiting for events to be flushed")
 )
 
+// maxLoggedRequestIDs bounds the correlation ids logged with a batch, which may hold the events of thousands of requests
+const maxLoggedRequestIDs = 10
+
+// drainPollInterval is how often Drain checks whether everything was flushed
+const drainPollInterval = 100 * time.Millisecond
+
+// FlushRetry is how often and how long a failed insert of a flush is retried before its events are dead-lettered
+type FlushRetry struct {
+	MaxRetries int
+	Backoff    time.Duration // wait before the first retry, doubled for each further one
+	MaxBackoff time.Duration
+}
+
+// NewFlushRetry returns the configured retries of failed flushes
+func NewFlushRetry(cfg *config.ClickHouseConfig) FlushRetry {
+	return FlushRetry{
+		MaxRetries: max(cfg.FlushMaxRetries, 0),
+		Backoff:    time.Duration(cfg.FlushRetryBackoffMS) * time.Millisecond,
+		MaxBackoff: time.Duration(cfg.FlushRetryMaxBackoffMS) * time.Millisecond,
+	}
+}
+
+// wait returns the wait before a retry, counted from 1. The jitter keeps instances failing together from retrying together.
+func (r FlushRetry) wait(retry int) time.Duration {
+	backoff := r.MaxBackoff
+	if retry < 32 && r.Backoff<<(retry-1) < r.MaxBackoff {
+		backoff = r.Backoff << (retry - 1)
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
 // EventBatcher batches events and flushes them to ClickHouse
 type EventBatcher struct {
-	eventChan        chan domain.EventRequest
+	eventChan        chan queuedEvent
 	batchSize        int
 	flushInterval    time.Duration
-	clickhouseDB     database.ClickHouseDB
-	redisRepo        database.ClickHouseRedis
+	sinks            *flush.Manager
+	dedup            database.Deduplicator
+	rateLimiter      *InsertRateLimiter
+	notifier         *WebhookNotifier
+	quotas           *QuotaEnforcer
+	keys             *APIKeys
+	realtime         *RealtimeAggregator
+	aggregates       *AggregatePublisher
+	raw              *RawPayloadStore
+	retry            FlushRetry
+	deadLetters      *DeadLetterQueue
+	overflow         *OverflowQueue   // spills events to disk while the buffer channel is full, nil when disabled
+	flushQueue       chan *eventBatch // batches waiting for a flusher
+	flushConcurrency int              // number of flushers writing batches in parallel
 	ctx              context.Context
 	cancel           context.CancelFunc
 	wg               sync.WaitGroup
 	mu               sync.Mutex
 	isRunning        bool
-	currentBatch     []domain.EventRequest
-	lastFlushTime    time.Time
+	currentBatch     *eventBatch
+	lastFlushTime    time.Time // time of the last successful flush
+	lastFlushFailed  bool      // whether the last flush failed
+	flushing         int       // batches handed over to the flushers and not yet written
+	drain            chan struct{}
+	draining         atomic.Bool // flush as soon as the buffer is empty instead of waiting for a full batch
+	// event times of the events enqueued and not yet flushed
+	pending *pendingTimes
 }
 
 // NewEventBatcher creates a new EventBatcher instance
@@ -36,24 +100,54 @@ func NewEventBatcher(
 	capacity int,
 	batchSize int,
 	flushIntervalSeconds int,
-	clickhouseDB database.ClickHouseDB,
-	redisRepo database.ClickHouseRedis,
+	flushConcurrency int,
+	sinks *flush.Manager,
+	dedup database.Deduplicator,
+	rateLimiter *InsertRateLimiter,
+	notifier *WebhookNotifier,
+	quotas *QuotaEnforcer,
+	keys *APIKeys,
+	realtime *RealtimeAggregator,
+	aggregates *AggregatePublisher,
+	raw *RawPayloadStore,
+	retry FlushRetry,
+	deadLetters *DeadLetterQueue,
+	overflow *OverflowQueue,
 ) *EventBatcher {
+	if flushConcurrency < 1 {
+		flushConcurrency = 1
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	return &EventBatcher{
-		eventChan:     make(chan domain.EventRequest, capacity),
-		batchSize:     batchSize,
-		flushInterval: time.Duration(flushIntervalSeconds) * time.Second,
-		clickhouseDB:  clickhouseDB,
-		redisRepo:     redisRepo,
-		ctx:           ctx,
-		cancel:        cancel,
-		currentBatch:  make([]domain.EventRequest, 0, batchSize),
-		lastFlushTime: time.Now(),
+		eventChan:        make(chan queuedEvent, capacity),
+		batchSize:        batchSize,
+		flushInterval:    time.Duration(flushIntervalSeconds) * time.Second,
+		sinks:            sinks,
+		dedup:            dedup,
+		rateLimiter:      rateLimiter,
+		notifier:         notifier,
+		quotas:           quotas,
+		keys:             keys,
+		realtime:         realtime,
+		aggregates:       aggregates,
+		raw:              raw,
+		retry:            retry,
+		deadLetters:      deadLetters,
+		overflow:         overflow,
+		flushQueue:       make(chan *eventBatch, flushConcurrency),
+		flushConcurrency: flushConcurrency,
+		ctx:              ctx,
+		cancel:           cancel,
+		currentBatch:     newEventBatch(batchSize),
+		lastFlushTime:    time.Now(),
+		drain:            make(chan struct{}, 1),
+		pending:          newPendingTimes(),
 	}
 }
 
-// Start launches the background worker goroutine that processes events
+// Start launches the background worker goroutine that collects events
+// and the flusher goroutines that write batches to ClickHouse
 func (b *EventBatcher) Start() {
 	b.mu.Lock()
 	if b.isRunning {
@@ -65,23 +159,91 @@ func (b *EventBatcher) Start() {
 
 	b.wg.Add(1)
 	go b.worker()
-	log.Println("EventBatcher started")
+
+	for i := 0; i < b.flushConcurrency; i++ {
+		b.wg.Add(1)
+		go b.flusher()
+	}
+	if b.overflow != nil {
+		b.wg.Add(1)
+		go b.drainOverflow()
+	}
+	batcherLog.Infof("Started with %d flusher(s)", b.flushConcurrency)
+}
+
+// queuedEvent is an event waiting in the buffer channel, with the ack to complete once it is flushed
+type queuedEvent struct {
+	event domain.EventRequest
+	ack   *FlushAck
 }
 
 // Enqueue adds an event to the buffer channel (non-blocking)
 // Returns ErrBufferFull if the channel is full
 func (b *EventBatcher) Enqueue(event domain.EventRequest) error {
-	select {
-	case b.eventChan <- event:
-		return nil
-	default:
-		return ErrBufferFull
+	return b.EnqueueWithAck(event, nil)
+}
+
+// EnqueueWithAck adds an event to the buffer channel (non-blocking) and completes the ack once it is flushed.
+// Returns ErrBufferFull if the channel is full, in which case the ack is not affected.
+// With an overflow queue, events without an ack are spilled to disk instead while the channel is full or the queue holds events,
+// events with an ack are rejected meanwhile, since their producers wait for the flush anyway.
+func (b *EventBatcher) EnqueueWithAck(event domain.EventRequest, ack *FlushAck) error {
+	if ack != nil {
+		ack.add()
+	}
+	// Counted before it is queued, so that a quick flush cannot remove it first
+	b.pending.add(event)
+	pushed := false
+	push := func() bool {
+		select {
+		case b.eventChan <- queuedEvent{event: event, ack: ack}:
+			pushed = true
+		default:
+		}
+		return pushed
+	}
+
+	var err error
+	if b.overflow != nil {
+		err = b.overflow.Add(event, push, ack == nil)
+	} else if !push() {
+		err = ErrBufferFull
+	}
+	if !pushed {
+		// Spilled events are counted again once they are drained back into the channel
+		b.pending.remove(event)
+	}
+	if err != nil {
+		if ack != nil {
+			ack.complete(nil)
+		}
+		return err
 	}
+	b.realtime.Record(event)
+	return nil
+}
+
+// drainOverflow is the background goroutine that moves the spilled events back into the buffer channel as it has room
+func (b *EventBatcher) drainOverflow() {
+	defer b.wg.Done()
+	b.overflow.Drain(b.ctx, func(event domain.EventRequest) bool {
+		b.pending.add(event)
+		select {
+		case b.eventChan <- queuedEvent{event: event}:
+			return true
+		case <-b.ctx.Done():
+			b.pending.remove(event)
+			return false
+		}
+	})
 }
 
-// worker is the background goroutine that collects events and flushes them
+// worker is the background goroutine that collects events into batches
+// and hands them over to the flushers
 func (b *EventBatcher) worker() {
 	defer b.wg.Done()
+	// No more batches will be dispatched once the worker returns
+	defer close(b.flushQueue)
 
 	ticker := time.NewTicker(b.flushInterval)
 	defer ticker.Stop()
@@ -93,20 +255,18 @@ func (b *EventBatcher) worker() {
 			b.flushRemaining()
 			return
 
-		case event := <-b.eventChan:
-			b.mu.Lock()
-			b.currentBatch = append(b.currentBatch, event)
-			shouldFlush := len(b.currentBatch) >= b.batchSize
-			b.mu.Unlock()
-
-			if shouldFlush {
+		case queued := <-b.eventChan:
+			if b.addToBatch(queued) || (b.draining.Load() && len(b.eventChan) == 0) {
 				b.flushBatch()
 			}
 
+		case <-b.drain:
+			b.flushBatch()
+
 		case <-ticker.C:
 			// Time-based flush
 			b.mu.Lock()
-			hasEvents := len(b.currentBatch) > 0
+			hasEvents := b.currentBatch.len() > 0
 			b.mu.Unlock()
 
 			if hasEvents {
@@ -116,55 +276,174 @@ func (b *EventBatcher) worker() {
 	}
 }
 
-// flushBatch flushes the current batch to ClickHouse
+// flusher is a background goroutine that writes dispatched batches to ClickHouse.
+// Up to flushConcurrency flushers run in parallel.
+func (b *EventBatcher) flusher() {
+	defer b.wg.Done()
+
+	for batch := range b.flushQueue {
+		b.flush(batch)
+	}
+}
+
+// addToBatch appends an event to the current batch, converting it to the columnar format right away
+// so that the conversion cost is spread over time instead of paid at flush.
+// Returns true if the batch is full and should be flushed.
+func (b *EventBatcher) addToBatch(queued queuedEvent) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.currentBatch.add(queued.event, queued.ack); err != nil {
+		batcherLog.Warnf("Dropping event that cannot be converted to columnar format: %v", err)
+		b.pending.remove(queued.event)
+		if queued.ack != nil {
+			queued.ack.complete(err)
+		}
+		return false
+	}
+	return b.currentBatch.len() >= b.batchSize
+}
+
+// flushBatch cuts the current batch and queues it for the flushers.
+// It blocks while all flushers are busy and the flush queue is full,
+// which in turn fills the buffer channel and applies backpressure to the producers.
 func (b *EventBatcher) flushBatch() {
 	b.mu.Lock()
-	if len(b.currentBatch) == 0 {
+	if b.currentBatch.len() == 0 {
 		b.mu.Unlock()
 		return
 	}
 
-	// Copy batch and clear current batch
-	batch := make([]domain.EventRequest, len(b.currentBatch))
-	copy(batch, b.currentBatch)
-	b.currentBatch = b.currentBatch[:0]
+	// Hand over the current batch and start a new one
+	batch := b.currentBatch
+	b.currentBatch = newEventBatch(b.batchSize)
+	b.flushing++
+	b.mu.Unlock()
+
+	b.flushQueue <- batch
+}
+
+// flush writes a single batch to ClickHouse, completes the acks of its events and notifies their producers
+func (b *EventBatcher) flush(batch *eventBatch) {
+	start := time.Now()
+	err := b.write(batch)
+	recordFlush(start, err)
+	b.mu.Lock()
+	if err == nil {
+		b.lastFlushTime = time.Now()
+	}
+	b.lastFlushFailed = err != nil
+	b.flushing--
 	b.mu.Unlock()
+	b.pending.remove(batch.events...)
+	batch.complete(err)
+	b.notifier.NotifyBatch(batch.events, err)
+}
 
+// write filters already processed events out of a batch and inserts the rest into ClickHouse,
+// into the database of each tenant when tenants are isolated
+func (b *EventBatcher) write(batch *eventBatch) error {
 	// Filter processed events using Redis
-	unprocessedEvents := b.filterProcessedEvents(batch)
+	unprocessed := b.filterProcessedEvents(batch)
 
-	if len(unprocessedEvents) == 0 {
-		log.Printf("EventBatcher: All %d events in batch were already processed", len(batch))
-		return
+	if unprocessed.len() == 0 {
+		batcherLog.Debugf("All %d events in batch were already processed", batch.len())
+		return nil
 	}
 
-	// Save to ClickHouse
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	// Wait for the insert rate limiter so bursts are spread over time, and for the parts guard.
+	// The wait is cut short at shutdown so that the last batches are written or dead-lettered.
+	if waited, err := b.rateLimiter.Wait(b.ctx, unprocessed.len()); err != nil {
+		batcherLog.Warnf("Rate limiter wait failed: %v", err)
+	} else if waited > 0 {
+		batcherLog.Warnf("Throttled flush of %d events for %v", unprocessed.len(), waited)
+	}
 
-	if err := b.clickhouseDB.SaveEvents(ctx, unprocessedEvents); err != nil {
-		log.Printf("EventBatcher: Failed to flush batch of %d events: %v", len(unprocessedEvents), err)
-		return
+	var firstErr error
+	for tenant, group := range unprocessed.byTenant() {
+		if err := b.writeTenant(tenant, group); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	batcherLog.Debugf("Successfully flushed batch of %d events (filtered from %d)", unprocessed.len(), batch.len())
+	return nil
+}
+
+// writeTenant inserts the events of a single tenant into ClickHouse, retrying with backoff if the insert fails.
+// Once the retries are exhausted, or right away during shutdown, the events are dead-lettered.
+func (b *EventBatcher) writeTenant(tenant string, group *eventBatch) error {
+	// Retries keep the batch_id, they insert the same rows
+	group.columns.Stamp(database.NewBatchID())
+	// The logs of the batch name the requests that sent its events, so that a failure can be traced back to them
+	log := batcherLog.With("batch_id", group.columns.Batch(), "request_ids", group.requestIDs())
+	attempts := 1
+	err := b.insertTenant(tenant, group, log)
+	for err != nil && attempts <= b.retry.MaxRetries {
+		wait := b.retry.wait(attempts)
+		log.Warnf("Retrying flush of batch %s of %d events in %v (retry %d of %d)",
+			group.columns.Batch(), group.len(), wait, attempts, b.retry.MaxRetries)
+		select {
+		case <-time.After(wait):
+		case <-b.ctx.Done():
+		}
+		if b.ctx.Err() != nil {
+			log.Warnf("Shutting down, not retrying batch %s", group.columns.Batch())
+			break
+		}
+		flushRetriesTotal.WithLabelValues().Inc()
+		attempts++
+		err = b.insertTenant(tenant, group, log)
+	}
+	if err != nil {
+		if dlErr := b.deadLetters.Add(group.events, err, attempts); dlErr != nil {
+			log.Errorf("Failed to dead-letter %d events of batch %s, they are lost: %v", group.len(), group.columns.Batch(), dlErr)
+		} else {
+			log.Infof("Dead-lettered %d events of batch %s after %d attempt(s)", group.len(), group.columns.Batch(), attempts)
+		}
+		return err
 	}
 
-	log.Printf("EventBatcher: Successfully flushed batch of %d events (filtered from %d)", len(unprocessedEvents), len(batch))
+	recordStored(group.events, group.columns)
+	b.aggregates.Publish(tenant, group.events)
+	b.raw.Save(group.events)
+	b.sinks.Replicate(flush.Batch{Tenant: tenant, Events: group.events, Columns: group.columns})
 
-	// Mark events as processed in Redis (async)
+	// Mark events as processed and account the usage of the tenant and API keys in Redis (async)
 	go func() {
-		if err := b.redisRepo.SetMultipleEventsProcessed(context.Background(), unprocessedEvents); err != nil {
-			log.Printf("EventBatcher: Failed to mark events as processed in Redis: %v", err)
+		if err := b.dedup.SetMultipleEventsProcessed(context.Background(), group.events); err != nil {
+			log.Errorf("Failed to mark events as processed: %v", err)
 		}
+		b.quotas.RecordStored(context.Background(), tenant, group.len(), group.columns.Size())
+		b.keys.RecordStored(context.Background(), group.events)
 	}()
+	return nil
+}
+
+// insertTenant writes the events of a single tenant to the primary sink, the tenant's database
+func (b *EventBatcher) insertTenant(tenant string, group *eventBatch, log *zap.SugaredLogger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := b.sinks.Write(ctx, flush.Batch{Tenant: tenant, Events: group.events, Columns: group.columns}); err != nil {
+		recordInsertError("events")
+		log.Errorf("Failed to flush batch %s of %d events: %v", group.columns.Batch(), group.len(), err)
+		return err
+	}
+	return nil
 }
 
 // flushRemaining flushes any remaining events in the buffer during shutdown
 func (b *EventBatcher) flushRemaining() {
 	b.mu.Lock()
-	remaining := len(b.currentBatch)
+	remaining := b.currentBatch.len()
 	b.mu.Unlock()
 
 	if remaining > 0 {
-		log.Printf("EventBatcher: Flushing %d remaining events during shutdown", remaining)
+		batcherLog.Infof("Flushing %d remaining events during shutdown", remaining)
 		b.flushBatch()
 	}
 
@@ -172,14 +451,14 @@ func (b *EventBatcher) flushRemaining() {
 	drained := 0
 	for {
 		select {
-		case event := <-b.eventChan:
-			b.mu.Lock()
-			b.currentBatch = append(b.currentBatch, event)
-			b.mu.Unlock()
+		case queued := <-b.eventChan:
 			drained++
+			if b.addToBatch(queued) {
+				b.flushBatch()
+			}
 		default:
 			if drained > 0 {
-				log.Printf("EventBatcher: Drained %d events from channel during shutdown", drained)
+				batcherLog.Infof("Drained %d events from channel during shutdown", drained)
 				b.flushBatch()
 			}
 			return
@@ -188,21 +467,55 @@ func (b *EventBatcher) flushRemaining() {
 }
 
 // filterProcessedEvents filters out events that have already been processed
-func (b *EventBatcher) filterProcessedEvents(events []domain.EventRequest) []domain.EventRequest {
-	unprocessedEvents := make([]domain.EventRequest, 0, len(events))
-	maps, err := b.redisRepo.AreEventsProcessed(context.Background(), events)
+func (b *EventBatcher) filterProcessedEvents(batch *eventBatch) *eventBatch {
+	maps, err := b.dedup.AreEventsProcessed(context.Background(), batch.events)
+	recordProcessedLookups(batch.events, maps, err)
 	if err != nil {
-		// If Redis check fails, assume all events are unprocessed
-		log.Printf("EventBatcher: Redis check failed, assuming all events are unprocessed: %v", err)
-		return events
+		// If the check fails, assume all events are unprocessed
+		batcherLog.Warnf("Processed events check failed, assuming all events are unprocessed: %v", err)
+		return batch
+	}
+
+	keep := make([]bool, len(batch.events))
+	kept := 0
+	for i, event := range batch.events {
+		if processed, exists := maps[event.GetUniqueKey()]; !exists || !processed || event.Ingest.Replay {
+			keep[i] = true
+			kept++
+		}
+	}
+	if kept == len(batch.events) {
+		// Nothing to filter, reuse the columns built at enqueue time
+		return batch
+	}
+	return batch.filter(keep, kept)
+}
+
+// Drain flushes the pending batch right away and from then on flushes events as soon as the buffer is empty,
+// instead of waiting for a full batch or the flush interval. It blocks until nothing is left to flush or ctx is done.
+// Events keep being accepted, so that requests routed to the instance before it left the rotation are not lost.
+func (b *EventBatcher) Drain(ctx context.Context) error {
+	b.draining.Store(true)
+	select {
+	case b.drain <- struct{}{}:
+	default:
 	}
 
-	for _, event := range events {
-		if processed, exists := maps[event.GetUniqueKey()]; !exists || !processed {
-			unprocessedEvents = append(unprocessedEvents, event)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		b.mu.Lock()
+		idle := len(b.eventChan) == 0 && b.currentBatch.len() == 0 && b.flushing == 0 && b.overflow.Len() == 0
+		b.mu.Unlock()
+		if idle {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
 		}
 	}
-	return unprocessedEvents
 }
 
 // Shutdown gracefully shuts down the batcher, flushing remaining events
@@ -214,10 +527,17 @@ func (b *EventBatcher) Shutdown() error {
 	}
 	b.mu.Unlock()
 
-	log.Println("EventBatcher: Initiating graceful shutdown...")
+	batcherLog.Infof("Initiating graceful shutdown...")
 	b.cancel()
 	b.wg.Wait()
-	log.Println("EventBatcher: Shutdown complete")
+	// Spilled events not drained yet stay on disk for the next start
+	if err := b.overflow.Close(); err != nil {
+		batcherLog.Warnf("Failed to close overflow queue: %v", err)
+	}
+	if spilled := b.overflow.Len(); spilled > 0 {
+		batcherLog.Infof("Keeping %d spilled events on disk until the next start", spilled)
+	}
+	batcherLog.Infof("Shutdown complete")
 	return nil
 }
 
@@ -230,6 +550,139 @@ func (b *EventBatcher) GetBufferSize() int {
 func (b *EventBatcher) GetBatchSize() int {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	return len(b.currentBatch)
+	return b.currentBatch.len()
+}
+
+// Stats returns a snapshot of the buffer, the pending batch and the last successful flush
+func (b *EventBatcher) Stats() domain.IngestionStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return domain.IngestionStats{
+		BufferedEvents:  len(b.eventChan),
+		BufferCapacity:  cap(b.eventChan),
+		BatchEvents:     b.currentBatch.len(),
+		LastFlushTime:   b.lastFlushTime,
+		LastFlushFailed: b.lastFlushFailed,
+		OverflowEvents:  b.overflow.Len(),
+		OverflowBytes:   b.overflow.Bytes(),
+	}
+}
+
+// HasRoom reports whether the buffer has room for the events
+func (b *EventBatcher) HasRoom(events []domain.EventRequest) bool {
+	return b.hasRoom(len(events))
+}
+
+// hasRoom reports whether the buffer has room for the given number of events
+func (b *EventBatcher) hasRoom(events int) bool {
+	return cap(b.eventChan)-len(b.eventChan) >= events
+}
+
+// EarliestPending returns the earliest event time of a tenant's events enqueued and not yet flushed and their number,
+// the zero time if there are none
+func (b *EventBatcher) EarliestPending(tenant string) (time.Time, int) {
+	return b.pending.earliest(tenant)
+}
+
+// eventBatch holds the events of a batch along with their columnar representation,
+// which is built incrementally as events are added
+type eventBatch struct {
+	events  []domain.EventRequest
+	columns *database.EventColumnar
+	acks    []*FlushAck // acks to complete once the batch is flushed, one per event that has one
+}
+
+func newEventBatch(capacity int) *eventBatch {
+	return &eventBatch{
+		events:  make([]domain.EventRequest, 0, capacity),
+		columns: database.NewEventColumnar(capacity),
+	}
+}
+
+func (e *eventBatch) add(event domain.EventRequest, ack *FlushAck) error {
+	if err := e.columns.Append(event); err != nil {
+		return err
+	}
+	e.events = append(e.events, event)
+	if ack != nil {
+		e.acks = append(e.acks, ack)
+	}
+	return nil
+}
+
+// complete completes the acks of all events in the batch with the flush result.
+// Events filtered out as already processed share the result of the batch.
+func (e *eventBatch) complete(err error) {
+	for _, ack := range e.acks {
+		ack.complete(err)
+	}
+}
+
+func (e *eventBatch) len() int {
+	return len(e.events)
 }
 
+// requestIDs returns the distinct correlation ids of the requests that sent the events, at most maxLoggedRequestIDs
+func (e *eventBatch) requestIDs() []string {
+	var ids []string
+	for _, event := range e.events {
+		id := event.Ingest.RequestID
+		if id == "" || slices.Contains(ids, id) {
+			continue
+		}
+		if len(ids) == maxLoggedRequestIDs {
+			break
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// filter returns a new batch with only the events where keep is true
+func (e *eventBatch) filter(keep []bool, kept int) *eventBatch {
+	filtered := &eventBatch{
+		events:  make([]domain.EventRequest, 0, kept),
+		columns: e.columns.Filter(keep),
+	}
+	for i, event := range e.events {
+		if keep[i] {
+			filtered.events = append(filtered.events, event)
+		}
+	}
+	return filtered
+}
+
+// byTenant splits the batch by tenant, reusing it when all events belong to the same tenant
+func (e *eventBatch) byTenant() map[string]*eventBatch {
+	if e.len() == 0 {
+		return nil
+	}
+	first := e.events[0].Ingest.Tenant
+	mixed := false
+	for _, event := range e.events {
+		if event.Ingest.Tenant != first {
+			mixed = true
+			break
+		}
+	}
+	if !mixed {
+		return map[string]*eventBatch{first: e}
+	}
+
+	keeps := make(map[string][]bool)
+	counts := make(map[string]int)
+	for i, event := range e.events {
+		keep, ok := keeps[event.Ingest.Tenant]
+		if !ok {
+			keep = make([]bool, len(e.events))
+			keeps[event.Ingest.Tenant] = keep
+		}
+		keep[i] = true
+		counts[event.Ingest.Tenant]++
+	}
+	groups := make(map[string]*eventBatch, len(keeps))
+	for tenant, keep := range keeps {
+		groups[tenant] = e.filter(keep, counts[tenant])
+	}
+	return groups
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ErrCatalogEntryNotFound is returned when an event name that is not documented is deleted from the catalog
+var ErrCatalogEntryNotFound = errors.New("event name is not documented")
+
+// defaultCatalogDays is the number of ingestion days the catalog is cross-referenced with
+const defaultCatalogDays = 30
+
+var _ domain.CatalogService = &catalogService{}
+
+// catalogService documents the event names of each tenant in the event_catalog table and compares the documentation
+// with the metadata keys counted per event name and ingestion day in the metadata_keys table
+type catalogService struct {
+	clickhouseDB database.ClickHouseDB
+	tenants      *TenantRouter
+}
+
+// observedEvent is the events of a name observed in the last days and the metadata keys they carried
+type observedEvent struct {
+	events   uint64
+	lastSeen time.Time
+	keys     []string
+}
+
+// ListCatalog returns the documented event names of a tenant with the events observed for them
+func (c catalogService) ListCatalog(ctx context.Context, request *domain.CatalogRequest) (*domain.CatalogResponse, error) {
+	days := catalogDays(request.Days)
+	entries, err := c.clickhouseDB.GetCatalogEntries(ctx, request.Tenant, request.EventName)
+	if err != nil {
+		return &domain.CatalogResponse{
+			Success: false,
+			Message: "Failed to retrieve event catalog: " + err.Error(),
+		}, err
+	}
+	observed, err := c.observe(ctx, request.Tenant, request.EventName, days)
+	if err != nil {
+		return &domain.CatalogResponse{
+			Success: false,
+			Message: "Failed to retrieve observed events: " + err.Error(),
+		}, err
+	}
+
+	resp := &domain.CatalogResponse{
+		Success: true,
+		Message: "Event catalog retrieved successfully",
+		Days:    days,
+		Entries: make([]domain.CatalogEntry, len(entries)),
+	}
+	for i, entry := range entries {
+		resp.Entries[i] = catalogEntry(entry, observed[entry.EventName])
+	}
+	return resp, nil
+}
+
+// SetCatalogEntry documents an event name of a tenant, replacing its earlier documentation
+func (c catalogService) SetCatalogEntry(ctx context.Context, request *domain.CatalogEntryRequest) (*domain.CatalogResponse, error) {
+	expected := slices.Clone(request.ExpectedMetadata)
+	slices.Sort(expected)
+	entry := database.CatalogEntry{
+		Tenant:           request.Tenant,
+		EventName:        request.EventName,
+		Owner:            request.Owner,
+		Description:      request.Description,
+		ExpectedMetadata: slices.Compact(expected),
+		UpdatedAt:        time.Now(),
+	}
+	if err := c.clickhouseDB.SaveCatalogEntry(ctx, entry); err != nil {
+		return &domain.CatalogResponse{
+			Success: false,
+			Message: "Failed to document event: " + err.Error(),
+		}, err
+	}
+	return c.ListCatalog(ctx, &domain.CatalogRequest{Tenant: request.Tenant, EventName: request.EventName})
+}
+
+// DeleteCatalogEntry removes the documentation of an event name of a tenant
+func (c catalogService) DeleteCatalogEntry(ctx context.Context, tenant, eventName string) (*domain.CatalogResponse, error) {
+	entries, err := c.clickhouseDB.GetCatalogEntries(ctx, tenant, eventName)
+	if err == nil && len(entries) == 0 {
+		err = ErrCatalogEntryNotFound
+	}
+	if err == nil {
+		deleted := entries[0]
+		deleted.Deleted = 1
+		deleted.UpdatedAt = time.Now()
+		err = c.clickhouseDB.SaveCatalogEntry(ctx, deleted)
+	}
+	if err != nil {
+		return &domain.CatalogResponse{
+			Success: false,
+			Message: "Failed to delete event documentation: " + err.Error(),
+		}, err
+	}
+	return &domain.CatalogResponse{
+		Success: true,
+		Message: "Event documentation deleted successfully",
+		Entries: []domain.CatalogEntry{},
+	}, nil
+}
+
+// GetCatalogCoverage lists the event names observed in the last days that are not documented, and the documented ones not observed
+func (c catalogService) GetCatalogCoverage(ctx context.Context, request *domain.CatalogRequest) (*domain.CatalogCoverageResponse, error) {
+	days := catalogDays(request.Days)
+	entries, err := c.clickhouseDB.GetCatalogEntries(ctx, request.Tenant, "")
+	if err != nil {
+		return &domain.CatalogCoverageResponse{
+			Success: false,
+			Message: "Failed to retrieve event catalog: " + err.Error(),
+		}, err
+	}
+	observed, err := c.observe(ctx, request.Tenant, "", days)
+	if err != nil {
+		return &domain.CatalogCoverageResponse{
+			Success: false,
+			Message: "Failed to retrieve observed events: " + err.Error(),
+		}, err
+	}
+
+	resp := &domain.CatalogCoverageResponse{
+		Success:            true,
+		Message:            "Catalog coverage retrieved successfully",
+		Days:               days,
+		ObservedEventNames: len(observed),
+		Undocumented:       []domain.CatalogCoverageEvent{},
+		Unobserved:         []string{},
+	}
+	documented := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		documented[entry.EventName] = true
+		if _, ok := observed[entry.EventName]; !ok {
+			resp.Unobserved = append(resp.Unobserved, entry.EventName)
+		}
+	}
+
+	var total, covered uint64
+	for name, event := range observed {
+		total += event.events
+		if documented[name] {
+			covered += event.events
+			resp.DocumentedEventNames++
+			continue
+		}
+		resp.Undocumented = append(resp.Undocumented, domain.CatalogCoverageEvent{
+			EventName: name,
+			Events:    event.events,
+			LastSeen:  event.lastSeen.Unix(),
+		})
+	}
+	if total > 0 {
+		resp.EventCoverage = float64(covered) / float64(total)
+	}
+	sort.Slice(resp.Undocumented, func(i, j int) bool {
+		if resp.Undocumented[i].Events != resp.Undocumented[j].Events {
+			return resp.Undocumented[i].Events > resp.Undocumented[j].Events
+		}
+		return resp.Undocumented[i].EventName < resp.Undocumented[j].EventName
+	})
+	return resp, nil
+}
+
+// observe reads the events of each name ingested in the last days and the metadata keys they carried,
+// only those of eventName if it is not empty
+func (c catalogService) observe(ctx context.Context, tenant, eventName string, days int) (map[string]*observedEvent, error) {
+	tenantDB, err := c.tenants.Database(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	// Days are ingestion days of the ClickHouse server, assumed to be UTC
+	since := startOfDay(time.Now().UTC()).AddDate(0, 0, 1-days)
+	counts, err := c.clickhouseDB.GetMetadataKeyCounts(ctx, tenantDB, eventName, since, since)
+	if err != nil {
+		return nil, err
+	}
+
+	observed := make(map[string]*observedEvent)
+	for _, count := range counts {
+		if count.RecentEvents == 0 {
+			continue
+		}
+		event := observed[count.EventName]
+		if event == nil {
+			event = &observedEvent{}
+			observed[count.EventName] = event
+		}
+		// The empty key counts all events of the name
+		if count.Key == "" {
+			event.events = count.RecentEvents
+			event.lastSeen = count.LastSeen
+			continue
+		}
+		event.keys = append(event.keys, count.Key)
+	}
+	return observed, nil
+}
+
+// catalogEntry converts a catalog entry and compares its expected metadata with the observed metadata keys
+func catalogEntry(entry database.CatalogEntry, observed *observedEvent) domain.CatalogEntry {
+	result := domain.CatalogEntry{
+		EventName:        entry.EventName,
+		Owner:            entry.Owner,
+		Description:      entry.Description,
+		ExpectedMetadata: entry.ExpectedMetadata,
+		UpdatedAt:        entry.UpdatedAt.Unix(),
+		Observed: &domain.CatalogObservation{
+			MissingMetadata:      []string{},
+			UndocumentedMetadata: []string{},
+		},
+	}
+	if result.ExpectedMetadata == nil {
+		result.ExpectedMetadata = []string{}
+	}
+	if observed == nil {
+		result.Observed.MissingMetadata = append(result.Observed.MissingMetadata, entry.ExpectedMetadata...)
+		return result
+	}
+
+	result.Observed.Events = observed.events
+	if !observed.lastSeen.IsZero() {
+		result.Observed.LastSeen = observed.lastSeen.Unix()
+	}
+	for _, key := range entry.ExpectedMetadata {
+		if !slices.Contains(observed.keys, key) {
+			result.Observed.MissingMetadata = append(result.Observed.MissingMetadata, key)
+		}
+	}
+	for _, key := range observed.keys {
+		if !slices.Contains(entry.ExpectedMetadata, key) {
+			result.Observed.UndocumentedMetadata = append(result.Observed.UndocumentedMetadata, key)
+		}
+	}
+	return result
+}
+
+func catalogDays(days int) int {
+	if days == 0 {
+		return defaultCatalogDays
+	}
+	return days
+}
+
+// NewCatalogService returns a domain.CatalogService storing the catalog in the connection's database
+// and reading the observed events from the database of each tenant
+func NewCatalogService(db database.ClickHouseDB, cfg *config.ClickHouseConfig, flags *FeatureFlags) (domain.CatalogService, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("ClickHouse database connection cannot be nil")
+	}
+	tenants, err := NewTenantRouter(db, cfg, flags)
+	if err != nil {
+		return nil, err
+	}
+	return &catalogService{clickhouseDB: db, tenants: tenants}, nil
+}
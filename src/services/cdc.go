@@ -0,0 +1,360 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"kucukaslan/clickhouse/buildinfo"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/ingest"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/validations"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cdcLog logs the messages of the CDC
+var cdcLog = logging.Named("CDC")
+
+// Modes of the change data capture adapter, see CDCConsumer
+const (
+	CDCModeOutbox      = "outbox"      // polls an outbox table the application writes events to in its own transactions
+	CDCModeReplication = "replication" // reads the row changes of the configured tables from a logical replication slot
+)
+
+// cdcLockTTL bounds how long a crashed instance keeps the slot from being read, a poll lasts at most the flush ack timeout
+const cdcLockTTL = 5 * time.Minute
+
+// cdcActions maps the actions of CDC_TABLES to the ones of wal2json
+var cdcActions = map[string]string{"insert": "I", "update": "U", "delete": "D"}
+
+// postgresTimeLayouts are the text formats of commit timestamps and timestamptz values
+var postgresTimeLayouts = []string{"2006-01-02 15:04:05.999999-07", "2006-01-02 15:04:05.999999-07:00", time.RFC3339Nano}
+
+// walMessage is a message of wal2json format version 2, inserts and updates carry the new row in columns,
+// deletes the replica identity (the primary key by default) in identity
+type walMessage struct {
+	Action    string      `json:"action"`
+	Timestamp string      `json:"timestamp"`
+	Schema    string      `json:"schema"`
+	Table     string      `json:"table"`
+	Columns   []walColumn `json:"columns"`
+	Identity  []walColumn `json:"identity"`
+}
+
+type walColumn struct {
+	Name  string `json:"name"`
+	Value any    `json:"value"`
+}
+
+// CDCConsumer turns writes to a Postgres database into events, for teams whose events only exist as rows.
+// Changes go through the stream ingestion: they are consumed only once their events are flushed, with the
+// slot or outbox table as the stream, so that a crash resends them and deduplication drops the copies.
+type CDCConsumer struct {
+	source      *database.PostgresCDC
+	sink        ingest.Sink
+	redisRepo   database.ClickHouseRedis
+	cfg         *config.CDCConfig
+	streamID    string            // stream the checkpoints are stored under, see GET /events/stream/checkpoint
+	names       map[string]string // event name per schema qualified table and wal2json action, e.g. public.orders:I
+	tables      []string          // tables read from the slot
+	userColumns map[string]string // column of the user id per schema qualified table
+	owner       string
+	ctx         context.Context
+	cancel      context.CancelFunc
+	stopOnce    sync.Once
+	done        chan struct{}
+}
+
+// NewCDCConsumer connects to the Postgres database whose changes are captured, nil if CDC is disabled.
+// In the replication mode the slot is created if it does not exist yet.
+func NewCDCConsumer(cfg *config.CDCConfig, redisClient database.ClickHouseRedis) (*CDCConsumer, error) {
+	if cfg.Mode == "" {
+		return nil, nil
+	}
+	if redisClient.Client == nil {
+		return nil, fmt.Errorf("Redis client cannot be nil")
+	}
+	if cfg.BatchSize <= 0 || cfg.PollIntervalMS <= 0 {
+		return nil, fmt.Errorf("CDC batch size and poll interval must be positive")
+	}
+	if err := validations.ValidateTenantID(cfg.Tenant); err != nil {
+		return nil, fmt.Errorf("invalid CDC tenant %q", cfg.Tenant)
+	}
+	token, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	consumer := &CDCConsumer{
+		redisRepo:   redisClient,
+		cfg:         cfg,
+		userColumns: make(map[string]string, len(cfg.UserColumns)),
+		owner:       buildinfo.GetInfo().Hostname + "-" + token,
+		done:        make(chan struct{}),
+	}
+	switch cfg.Mode {
+	case CDCModeOutbox:
+		if cfg.OutboxTable == "" {
+			return nil, fmt.Errorf("CDC_OUTBOX_TABLE cannot be empty")
+		}
+		consumer.streamID = "cdc:" + cfg.OutboxTable
+	case CDCModeReplication:
+		if cfg.Slot == "" {
+			return nil, fmt.Errorf("CDC_SLOT cannot be empty")
+		}
+		if consumer.names, consumer.tables, err = parseCDCTables(cfg.Tables); err != nil {
+			return nil, err
+		}
+		for table, column := range cfg.UserColumns {
+			consumer.userColumns[qualifyTable(table)] = column
+		}
+		consumer.streamID = "cdc:" + cfg.Slot
+	default:
+		return nil, fmt.Errorf("unknown CDC mode %q", cfg.Mode)
+	}
+
+	if consumer.source, err = database.OpenPostgresCDC(cfg.DSN); err != nil {
+		return nil, err
+	}
+	if cfg.Mode == CDCModeReplication {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := consumer.source.EnsureSlot(ctx, cfg.Slot); err != nil {
+			consumer.source.Close()
+			return nil, err
+		}
+	}
+	consumer.ctx, consumer.cancel = context.WithCancel(context.Background())
+	return consumer, nil
+}
+
+// parseCDCTables parses the event name per table and optional action (insert if omitted) of CDC_TABLES,
+// e.g. public.orders=order_created and public.orders:update=order_updated
+func parseCDCTables(tables map[string]string) (map[string]string, []string, error) {
+	if len(tables) == 0 {
+		return nil, nil, fmt.Errorf("CDC_TABLES must map at least one table to an event name")
+	}
+	names := make(map[string]string, len(tables))
+	var list []string
+	for key, name := range tables {
+		table, action, _ := strings.Cut(key, ":")
+		code, ok := cdcActions[cmp.Or(action, "insert")]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown action %q of CDC table %s, expected insert, update or delete", action, table)
+		}
+		if name == "" {
+			return nil, nil, fmt.Errorf("event name of CDC table %s cannot be empty", key)
+		}
+		table = qualifyTable(table)
+		names[table+":"+code] = name
+		if !slices.Contains(list, table) {
+			list = append(list, table)
+		}
+	}
+	slices.Sort(list)
+	return names, list, nil
+}
+
+// qualifyTable adds the public schema to a table name without one
+func qualifyTable(table string) string {
+	if !strings.Contains(table, ".") {
+		return "public." + table
+	}
+	return table
+}
+
+// Name returns the source of the events of the consumer
+func (c *CDCConsumer) Name() string {
+	return domain.SourceCDC
+}
+
+// Start consumes the changes in the background and delivers them to the sink, polling again right away while whole
+// batches are read. Failures are logged and retried at the next poll. It is safe to call on a nil consumer.
+func (c *CDCConsumer) Start(sink ingest.Sink) {
+	if c == nil {
+		return
+	}
+	c.sink = sink
+	cdcLog.Infof("Consuming %s changes as stream %s", c.cfg.Mode, c.streamID)
+	go func() {
+		defer close(c.done)
+		interval := time.Duration(c.cfg.PollIntervalMS) * time.Millisecond
+		for {
+			consumed, err := c.poll(c.ctx)
+			if err != nil && c.ctx.Err() == nil {
+				cdcLog.Errorf("Failed to consume changes of %s: %v", c.streamID, err)
+			}
+			wait := interval
+			if err == nil && consumed >= c.cfg.BatchSize {
+				wait = 0
+			}
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// Stop stops consuming and closes the connection. A batch whose events are not flushed yet is not consumed,
+// it is read again after a restart. It is safe to call on a nil consumer.
+func (c *CDCConsumer) Stop() {
+	if c == nil {
+		return
+	}
+	c.stopOnce.Do(func() {
+		c.cancel()
+		<-c.done
+		if err := c.source.Close(); err != nil {
+			cdcLog.Errorf("Failed to close the connection: %v", err)
+		}
+		cdcLog.Infof("Stopped")
+	})
+}
+
+// poll consumes a batch of changes, returns how many were read
+func (c *CDCConsumer) poll(ctx context.Context) (int, error) {
+	if c.cfg.Mode == CDCModeOutbox {
+		return c.source.ConsumeOutbox(ctx, c.cfg.OutboxTable, c.cfg.BatchSize, func(rows []database.OutboxRow) error {
+			chunk := ingest.Chunk{StreamID: c.streamID, Checkpoint: strconv.FormatInt(rows[len(rows)-1].ID, 10)}
+			for _, row := range rows {
+				event, err := ingest.ParseEvent(row.Payload, c.cfg.Tenant, domain.SourceCDC)
+				if err != nil {
+					cdcLog.Warnf("Skipping invalid outbox row %d: %v", row.ID, err)
+					chunk.Invalid++
+					continue
+				}
+				chunk.Events = append(chunk.Events, event)
+			}
+			return c.sink.Deliver(ctx, chunk)
+		})
+	}
+
+	// A slot is read by one instance at a time, the others would read the same changes
+	lock := database.CDCLockKeyPrefix + c.cfg.Slot
+	acquired, err := c.redisRepo.AcquireLock(ctx, lock, c.owner, cdcLockTTL)
+	if err != nil || !acquired {
+		return 0, err
+	}
+	defer func() {
+		if err := c.redisRepo.ReleaseLock(context.Background(), lock, c.owner); err != nil {
+			cdcLog.Errorf("Failed to release the lock of slot %s: %v", c.cfg.Slot, err)
+		}
+	}()
+
+	changes, err := c.source.PeekChanges(ctx, c.cfg.Slot, c.tables, c.cfg.BatchSize)
+	if err != nil || len(changes) == 0 {
+		return 0, err
+	}
+	last := changes[len(changes)-1].LSN
+	chunk := ingest.Chunk{StreamID: c.streamID, Checkpoint: last}
+	var committed time.Time
+	for _, change := range changes {
+		var message walMessage
+		decoder := json.NewDecoder(bytes.NewReader(change.Data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&message); err != nil {
+			cdcLog.Warnf("Skipping invalid change at %s: %v", change.LSN, err)
+			chunk.Invalid++
+			continue
+		}
+		switch message.Action {
+		case "B":
+			// Changes are decoded once their transaction committed, its begin carries the commit time
+			committed, _ = parsePostgresTime(message.Timestamp)
+		case "I", "U", "D":
+			table := message.Schema + "." + message.Table
+			name, ok := c.names[table+":"+message.Action]
+			if !ok {
+				continue
+			}
+			at, ok := parsePostgresTime(message.Timestamp)
+			if !ok {
+				at = committed
+			}
+			columns := message.Columns
+			if message.Action == "D" {
+				columns = message.Identity
+			}
+			event := c.rowEvent(name, table, message.Action, columns, at)
+			event.Ingest.RawBytes = len(change.Data)
+			chunk.Events = append(chunk.Events, event)
+		}
+	}
+
+	if err := c.sink.Deliver(ctx, chunk); err != nil {
+		return 0, err
+	}
+	if err := c.source.AdvanceSlot(ctx, c.cfg.Slot, last); err != nil {
+		return 0, fmt.Errorf("failed to advance slot %s to %s: %w", c.cfg.Slot, last, err)
+	}
+	return len(changes), nil
+}
+
+// rowEvent maps a row change to an event: the user id and campaign come from their columns, the other columns
+// become the metadata, the table and action the tags. The time of the event is the commit time of the change.
+func (c *CDCConsumer) rowEvent(name, table, action string, columns []walColumn, at time.Time) domain.EventRequest {
+	if now := time.Now(); at.IsZero() || at.After(now) {
+		// The database clock may be slightly ahead, events from the future are invalid
+		at = now
+	}
+	event := domain.EventRequest{
+		EventName:   name,
+		Channel:     c.cfg.Channel,
+		CampaignID:  c.cfg.CampaignID,
+		TimestampMS: at.UnixMilli(),
+		Tags:        []string{table},
+		Metadata:    make(map[string]any, len(columns)),
+		Ingest:      domain.IngestInfo{Tenant: c.cfg.Tenant, Source: domain.SourceCDC},
+	}
+	for word, code := range cdcActions {
+		if code == action {
+			event.Tags = append(event.Tags, word)
+		}
+	}
+	userColumn := cmp.Or(c.userColumns[table], "user_id")
+	for _, column := range columns {
+		if column.Value == nil {
+			continue
+		}
+		switch column.Name {
+		case userColumn:
+			event.UserID = columnString(column.Value)
+		case "campaign_id":
+			event.CampaignID = cmp.Or(columnString(column.Value), event.CampaignID)
+		default:
+			event.Metadata[column.Name] = column.Value
+		}
+	}
+	return event
+}
+
+// columnString formats a column value as an event field
+func columnString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// parsePostgresTime parses a commit timestamp or a timestamptz value
+func parsePostgresTime(value string) (time.Time, bool) {
+	for _, layout := range postgresTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrClientTokensDisabled is returned when a token is requested but no signing secret is configured
+	ErrClientTokensDisabled = errors.New("client tokens are not enabled")
+	// ErrInvalidClientToken is returned for malformed tokens and tokens whose signature does not match
+	ErrInvalidClientToken = errors.New("invalid client token")
+	// ErrClientTokenExpired is returned for tokens used after their expiry
+	ErrClientTokenExpired = errors.New("client token expired")
+	// ErrInvalidRequestProof is returned for token requests without a timestamp, nonce and matching signature
+	ErrInvalidRequestProof = errors.New("request timestamp, nonce or signature missing or invalid")
+	// ErrStaleRequest is returned for token requests whose timestamp is outside the replay window
+	ErrStaleRequest = errors.New("request timestamp outside the accepted window")
+	// ErrReplayedRequest is returned for token requests whose nonce was already used
+	ErrReplayedRequest = errors.New("request nonce already used")
+)
+
+const (
+	// minClientTokenSecret is the minimum length in bytes of the signing secret, the size of an HMAC-SHA256 key
+	minClientTokenSecret = 32
+	// maxNonceLength is the maximum length of the nonce of a token request
+	maxNonceLength = 128
+)
+
+var _ domain.ClientTokenService = &clientTokenService{}
+
+// clientTokenService issues and verifies client tokens: the base64url encoded JSON claims
+// and their base64url encoded HMAC-SHA256 signature, separated by a dot
+type clientTokenService struct {
+	cfg       *config.ClientTokenConfig
+	redisRepo database.ClickHouseRedis
+}
+
+// IssueToken signs a token for the producer and tenant of the request, its lifetime capped at the configured maximum
+func (c clientTokenService) IssueToken(_ context.Context, request *domain.ClientTokenRequest) (*domain.ClientTokenResponse, error) {
+	if c.cfg.Secret == "" {
+		return &domain.ClientTokenResponse{
+			Success: false,
+			Message: "Client tokens are not enabled on this instance",
+		}, ErrClientTokensDisabled
+	}
+
+	ttl := request.TTLSeconds
+	if ttl == 0 {
+		ttl = c.cfg.TTLSeconds
+	}
+	ttl = min(ttl, c.cfg.MaxTTLSeconds)
+
+	now := time.Now()
+	claims := domain.ClientTokenClaims{
+		Producer:  request.Producer,
+		Tenant:    request.Tenant,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Duration(ttl) * time.Second).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return &domain.ClientTokenResponse{
+			Success: false,
+			Message: "Failed to issue token: " + err.Error(),
+		}, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	resp := &domain.ClientTokenResponse{
+		Success:   true,
+		Message:   "Token issued successfully",
+		Token:     encoded + "." + base64.RawURLEncoding.EncodeToString(c.sign(encoded)),
+		ExpiresAt: claims.ExpiresAt,
+	}
+	if c.cfg.ReplayProtection {
+		resp.SigningKey = c.signingKey(resp.Token)
+	}
+	return resp, nil
+}
+
+// VerifyToken checks the signature and expiry of a token and returns its claims
+func (c clientTokenService) VerifyToken(token string) (*domain.ClientTokenClaims, error) {
+	if c.cfg.Secret == "" {
+		return nil, ErrClientTokensDisabled
+	}
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidClientToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, c.sign(encoded)) {
+		return nil, ErrInvalidClientToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidClientToken
+	}
+	var claims domain.ClientTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidClientToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrClientTokenExpired
+	}
+	return &claims, nil
+}
+
+// CheckReplay rejects a request with a verified client token unless its timestamp is within the replay window,
+// its signature matches and its nonce was not used before. Nothing is checked without replay protection.
+func (c clientTokenService) CheckReplay(ctx context.Context, token string, claims *domain.ClientTokenClaims, proof domain.ClientRequestProof) error {
+	if !c.cfg.ReplayProtection {
+		return nil
+	}
+	if proof.Timestamp <= 0 || proof.Nonce == "" || len(proof.Nonce) > maxNonceLength {
+		return ErrInvalidRequestProof
+	}
+	window := time.Duration(c.cfg.ReplayWindowSeconds) * time.Second
+	if skew := time.Since(time.Unix(proof.Timestamp, 0)); skew > window || skew < -window {
+		return ErrStaleRequest
+	}
+
+	signature, err := hex.DecodeString(proof.Signature)
+	if err != nil {
+		return ErrInvalidRequestProof
+	}
+	mac := hmac.New(sha256.New, []byte(c.signingKey(token)))
+	mac.Write([]byte(strconv.FormatInt(proof.Timestamp, 10) + "." + proof.Nonce))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return ErrInvalidRequestProof
+	}
+
+	// Requests are accepted until their timestamp is a window old, which is at most two windows after their first use
+	fresh, err := c.redisRepo.UseClientNonce(ctx, claims.Producer, proof.Nonce, 2*window)
+	if err != nil {
+		return fmt.Errorf("failed to record request nonce: %w", err)
+	}
+	if !fresh {
+		return ErrReplayedRequest
+	}
+	return nil
+}
+
+func (c clientTokenService) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, []byte(c.cfg.Secret))
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// signingKey derives the key signing the requests of a token, known to the token's holder only
+func (c clientTokenService) signingKey(token string) string {
+	return base64.RawURLEncoding.EncodeToString(c.sign("request-signing." + token))
+}
+
+// NewClientTokenService returns a domain.ClientTokenService signing tokens with the configured secret.
+// Without a secret tokens are neither issued nor accepted.
+func NewClientTokenService(cfg *config.ClientTokenConfig, redisClient database.ClickHouseRedis) (domain.ClientTokenService, error) {
+	if cfg.Secret != "" && len(cfg.Secret) < minClientTokenSecret {
+		return nil, fmt.Errorf("client token secret must be at least %d bytes", minClientTokenSecret)
+	}
+	if cfg.TTLSeconds <= 0 || cfg.MaxTTLSeconds < cfg.TTLSeconds {
+		return nil, fmt.Errorf("client token TTL must be positive and at most the maximum TTL, got %d and %d",
+			cfg.TTLSeconds, cfg.MaxTTLSeconds)
+	}
+	if cfg.ReplayProtection {
+		if redisClient.Client == nil {
+			return nil, fmt.Errorf("Redis client cannot be nil")
+		}
+		if cfg.ReplayWindowSeconds <= 0 {
+			return nil, fmt.Errorf("client token replay window must be positive, got %d", cfg.ReplayWindowSeconds)
+		}
+	}
+	return &clientTokenService{cfg: cfg, redisRepo: redisClient}, nil
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// maxClockSkewKeys bounds the number of API keys whose clock skew is tracked, requests of other keys are
+// corrected by their own measurement only
+const maxClockSkewKeys = 10000
+
+// clockSkewSmoothing is the weight of a new measurement in the tracked skew of an API key
+const clockSkewSmoothing = 0.2
+
+// ClockSkewTracker tracks the skew of the clocks of the producers of each API key: how far the server's clock is ahead
+// of theirs, measured from the client time sent with their requests. Measurements are smoothed with an exponentially
+// weighted moving average, so that a single request delayed by the network barely moves the skew of its key.
+type ClockSkewTracker struct {
+	mu    sync.Mutex
+	skews map[string]time.Duration
+}
+
+// NewClockSkewTracker creates a tracker without measurements
+func NewClockSkewTracker() *ClockSkewTracker {
+	return &ClockSkewTracker{skews: make(map[string]time.Duration)}
+}
+
+// Observe records the skew measured for a request of a producer, the hash of its API key
+func (t *ClockSkewTracker) Observe(producer string, skew time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tracked, ok := t.skews[producer]
+	switch {
+	case ok:
+		t.skews[producer] = tracked + time.Duration(clockSkewSmoothing*float64(skew-tracked))
+	case len(t.skews) < maxClockSkewKeys:
+		t.skews[producer] = skew
+	}
+}
+
+// Skew returns the tracked skew of a producer, false if none of its requests was measured
+func (t *ClockSkewTracker) Skew(producer string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	skew, ok := t.skews[producer]
+	return skew, ok
+}
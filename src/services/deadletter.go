@@ -0,0 +1,408 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// deadLetterLog logs the messages of the DeadLetterQueue
+var deadLetterLog = logging.Named("DeadLetterQueue")
+
+// Destinations of the events of batches whose flush failed after all retries
+const (
+	DeadLetterRedis = "redis" // a hash per tenant in Redis, shared by all instances
+	DeadLetterFile  = "file"  // a JSON lines file per tenant in a local directory
+)
+
+const (
+	// defaultDeadLetterLimit is the number of dead-lettered events listed when no limit is given
+	defaultDeadLetterLimit = 100
+	// deadLetterSaveTimeout bounds how long a failed flush waits for its events to be dead-lettered
+	deadLetterSaveTimeout = 10 * time.Second
+	// maxDeadLetterLineBytes bounds a line of a dead-letter file, a single event
+	maxDeadLetterLineBytes = 16 << 20
+	// deadLetterCountInterval is how often the events waiting in the queue are counted, counting reads the whole destination
+	deadLetterCountInterval = 30 * time.Second
+)
+
+// deadLetterStore keeps dead-lettered events by tenant and event id
+type deadLetterStore interface {
+	save(ctx context.Context, letters []domain.DeadLetter) error
+	// get returns the dead-lettered events of a tenant with the given ids, all of them if ids is empty
+	get(ctx context.Context, tenant string, ids []string) ([]domain.DeadLetter, error)
+	remove(ctx context.Context, tenant string, ids []string) error
+	// count returns the number of dead-lettered events of all tenants
+	count(ctx context.Context) (int64, error)
+	// forSink returns the store of the events an optional sink failed to write
+	forSink(name string) deadLetterStore
+}
+
+var _ domain.DeadLetterService = &DeadLetterQueue{}
+
+// DeadLetterQueue keeps the events of batches whose flush still failed after all retries, so that they are not lost.
+// They are listed with GET /admin/dead-letter and ingested again with POST /admin/reprocess.
+// Each optional sink has a queue of its own, see ForSink.
+type DeadLetterQueue struct {
+	store deadLetterStore
+	depth atomic.Int64 // events waiting in the queue as last counted, -1 until counted or if counting failed
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewDeadLetterQueue creates the dead-letter queue of the configured destination
+func NewDeadLetterQueue(cfg *config.ClickHouseConfig, redisClient database.ClickHouseRedis) (*DeadLetterQueue, error) {
+	switch cfg.DeadLetter {
+	case DeadLetterRedis:
+		if redisClient.Client == nil {
+			return nil, fmt.Errorf("Redis client cannot be nil")
+		}
+		return newDeadLetterQueue(redisDeadLetterStore{redisRepo: redisClient}), nil
+	case DeadLetterFile:
+		if err := os.MkdirAll(cfg.DeadLetterDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create dead-letter directory: %w", err)
+		}
+		return newDeadLetterQueue(&fileDeadLetterStore{dir: cfg.DeadLetterDir}), nil
+	default:
+		return nil, fmt.Errorf("unknown dead-letter destination %q", cfg.DeadLetter)
+	}
+}
+
+func newDeadLetterQueue(store deadLetterStore) *DeadLetterQueue {
+	q := &DeadLetterQueue{store: store, stop: make(chan struct{}), done: make(chan struct{})}
+	q.depth.Store(-1)
+	return q
+}
+
+// ForSink returns the dead-letter queue of the events an optional sink failed to write, kept at the same destination.
+// They are listed with GET /admin/dead-letter?sink=<name> and written to the sink again with POST /admin/reprocess.
+func (q *DeadLetterQueue) ForSink(name string) *DeadLetterQueue {
+	return newDeadLetterQueue(q.store.forSink(name))
+}
+
+// Start counts the events waiting in the queue every deadLetterCountInterval, in the background
+func (q *DeadLetterQueue) Start() {
+	q.count()
+	go func() {
+		defer close(q.done)
+		ticker := time.NewTicker(deadLetterCountInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-q.stop:
+				return
+			case <-ticker.C:
+				q.count()
+			}
+		}
+	}()
+}
+
+// Stop stops counting the events, it must only be called after Start
+func (q *DeadLetterQueue) Stop() {
+	close(q.stop)
+	<-q.done
+}
+
+// count counts the events waiting in the queue, the depth is -1 if the destination cannot be read
+func (q *DeadLetterQueue) count() {
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterSaveTimeout)
+	defer cancel()
+	depth, err := q.store.count(ctx)
+	if err != nil {
+		deadLetterLog.Warnf("Failed to count dead-lettered events: %v", err)
+		depth = -1
+	}
+	q.depth.Store(depth)
+}
+
+// Add dead-letters the events of a flush that failed after the given number of attempts.
+// Events dead-lettered before with the same id are replaced.
+func (q *DeadLetterQueue) Add(events []domain.EventRequest, flushErr error, attempts int) error {
+	now := time.Now().Unix()
+	letters := make([]domain.DeadLetter, len(events))
+	for i, event := range events {
+		letters[i] = domain.DeadLetter{
+			ID:             event.EventID(),
+			Tenant:         event.Ingest.Tenant,
+			Producer:       event.Ingest.Producer,
+			Source:         event.Ingest.Source,
+			Event:          event,
+			Error:          flushErr.Error(),
+			Attempts:       attempts,
+			DeadLetteredAt: now,
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterSaveTimeout)
+	defer cancel()
+	if err := q.store.save(ctx, letters); err != nil {
+		deadLetteredEventsTotal.WithLabelValues("error").Add(float64(len(events)))
+		return err
+	}
+	deadLetteredEventsTotal.WithLabelValues("stored").Add(float64(len(events)))
+	return nil
+}
+
+// Get returns the dead-lettered events of a tenant with the given ids, by id
+func (q *DeadLetterQueue) Get(ctx context.Context, tenant string, ids []string) (map[string]domain.DeadLetter, error) {
+	letters, err := q.store.get(ctx, tenant, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]domain.DeadLetter, len(letters))
+	for _, letter := range letters {
+		byID[letter.ID] = letter
+	}
+	return byID, nil
+}
+
+// Remove removes dead-lettered events of a tenant, once they are ingested again
+func (q *DeadLetterQueue) Remove(ctx context.Context, tenant string, ids []string) error {
+	return q.store.remove(ctx, tenant, ids)
+}
+
+// Depth returns the number of events waiting in the queue, of all tenants, as last counted in the background,
+// -1 if they could not be counted. Counting reads every key or file of the destination, too slow for every probe.
+func (q *DeadLetterQueue) Depth() int64 {
+	return q.depth.Load()
+}
+
+// ListDeadLetters returns the dead-lettered events of a tenant, of an optional sink if requested, oldest first
+func (q *DeadLetterQueue) ListDeadLetters(ctx context.Context, request *domain.DeadLetterRequest) (*domain.DeadLetterResponse, error) {
+	store := q.store
+	if request.Sink != "" {
+		store = store.forSink(request.Sink)
+	}
+	letters, err := store.get(ctx, request.Tenant, nil)
+	if err != nil {
+		return &domain.DeadLetterResponse{
+			Success: false,
+			Message: "Failed to retrieve dead-lettered events: " + err.Error(),
+		}, err
+	}
+	sort.Slice(letters, func(i, j int) bool {
+		if letters[i].DeadLetteredAt != letters[j].DeadLetteredAt {
+			return letters[i].DeadLetteredAt < letters[j].DeadLetteredAt
+		}
+		return letters[i].ID < letters[j].ID
+	})
+
+	limit := request.Limit
+	if limit == 0 {
+		limit = defaultDeadLetterLimit
+	}
+	return &domain.DeadLetterResponse{
+		Success: true,
+		Message: "Dead-lettered events retrieved successfully",
+		Total:   len(letters),
+		Events:  letters[:min(limit, len(letters))],
+	}, nil
+}
+
+// redisDeadLetterStore keeps dead-lettered events in a Redis hash per sink and tenant
+type redisDeadLetterStore struct {
+	redisRepo database.ClickHouseRedis
+	sink      string // empty for ClickHouse
+}
+
+func (r redisDeadLetterStore) save(ctx context.Context, letters []domain.DeadLetter) error {
+	return r.redisRepo.SaveDeadLetters(ctx, r.sink, letters)
+}
+
+func (r redisDeadLetterStore) get(ctx context.Context, tenant string, ids []string) ([]domain.DeadLetter, error) {
+	return r.redisRepo.GetDeadLetters(ctx, r.sink, tenant, ids)
+}
+
+func (r redisDeadLetterStore) remove(ctx context.Context, tenant string, ids []string) error {
+	return r.redisRepo.DeleteDeadLetters(ctx, r.sink, tenant, ids)
+}
+
+func (r redisDeadLetterStore) count(ctx context.Context) (int64, error) {
+	return r.redisRepo.CountDeadLetters(ctx, r.sink)
+}
+
+func (r redisDeadLetterStore) forSink(name string) deadLetterStore {
+	return redisDeadLetterStore{redisRepo: r.redisRepo, sink: name}
+}
+
+// fileDeadLetterStore appends dead-lettered events to a JSON lines file per tenant. The files are local to the instance,
+// so they survive an outage of Redis and ClickHouse alike, but are only reprocessed through the instance that wrote them.
+// The files of an optional sink are kept in a subdirectory, sink_<name>.
+type fileDeadLetterStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// path returns the file of a tenant, tenant ids are identifiers and safe in file names
+func (f *fileDeadLetterStore) path(tenant string) string {
+	if tenant == "" {
+		return filepath.Join(f.dir, "default.jsonl")
+	}
+	return filepath.Join(f.dir, "tenant_"+tenant+".jsonl")
+}
+
+func (f *fileDeadLetterStore) save(_ context.Context, letters []domain.DeadLetter) error {
+	byTenant := make(map[string][]domain.DeadLetter)
+	for _, letter := range letters {
+		byTenant[letter.Tenant] = append(byTenant[letter.Tenant], letter)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// The directory of a sink is created with its first events
+	if err := os.MkdirAll(f.dir, 0o700); err != nil {
+		return err
+	}
+	for tenant, tenantLetters := range byTenant {
+		if err := appendLines(f.path(tenant), tenantLetters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fileDeadLetterStore) get(_ context.Context, tenant string, ids []string) ([]domain.DeadLetter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	letters, err := f.read(tenant)
+	if err != nil || len(ids) == 0 {
+		return letters, err
+	}
+	return slices.DeleteFunc(letters, func(letter domain.DeadLetter) bool {
+		return !slices.Contains(ids, letter.ID)
+	}), nil
+}
+
+func (f *fileDeadLetterStore) remove(_ context.Context, tenant string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	letters, err := f.read(tenant)
+	if err != nil {
+		return err
+	}
+	kept := slices.DeleteFunc(letters, func(letter domain.DeadLetter) bool {
+		return slices.Contains(ids, letter.ID)
+	})
+	if len(kept) == 0 {
+		if err := os.Remove(f.path(tenant)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	// The remaining events are written to a new file which replaces the old one, so that a crash never loses them
+	tmp := f.path(tenant) + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := appendLines(tmp, kept); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(tenant))
+}
+
+func (f *fileDeadLetterStore) forSink(name string) deadLetterStore {
+	return &fileDeadLetterStore{dir: filepath.Join(f.dir, "sink_"+name)}
+}
+
+// count counts the lines of the files of all tenants, without decoding them. Events dead-lettered again are counted
+// once per version until they are removed, which rewrites the file with their latest version only.
+func (f *fileDeadLetterStore) count(_ context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries, err := os.ReadDir(f.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".jsonl" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.dir, entry.Name()))
+		if err != nil {
+			return 0, err
+		}
+		count += int64(bytes.Count(data, []byte{'\n'}))
+	}
+	return count, nil
+}
+
+// appendLines writes events to the end of a file and syncs it, a later line replaces an earlier one with the same id
+func appendLines(path string, letters []domain.DeadLetter) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, letter := range letters {
+		if err := encoder.Encode(letter); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// read returns the events of the file of a tenant in the order they were first dead-lettered, with their latest version
+func (f *fileDeadLetterStore) read(tenant string) ([]domain.DeadLetter, error) {
+	file, err := os.Open(f.path(tenant))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var letters []domain.DeadLetter
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxDeadLetterLineBytes)
+	for scanner.Scan() {
+		var letter domain.DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			// A line cut short by a crash while appending
+			deadLetterLog.Warnf("Skipping invalid line of %s: %v", f.path(tenant), err)
+			continue
+		}
+		if i, ok := index[letter.ID]; ok {
+			letters[i] = letter
+			continue
+		}
+		index[letter.ID] = len(letters)
+		letters = append(letters, letter)
+	}
+	return letters, scanner.Err()
+}
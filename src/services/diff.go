@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// GetMetricsDiff compares the metrics of two windows for the same filters, the windows are queried concurrently.
+// Each window is bounded like the range of GET /metrics. The counts are read from ClickHouse alone, without realtime
+// counts and new users.
+func (e eventService) GetMetricsDiff(ctx context.Context, request *domain.MetricDiffRequest) (*domain.MetricDiffResponse, error) {
+	tenantDB, err := e.tenants.Database(ctx, request.Filter.Tenant)
+	if err != nil {
+		return &domain.MetricDiffResponse{
+			Success: false,
+			Message: "Failed to compare metrics: " + err.Error(),
+		}, err
+	}
+	base, compare := request.Filter, request.Filter
+	base.From, base.To = &request.BaseFrom, &request.BaseTo
+	compare.From, compare.To = &request.CompareFrom, &request.CompareTo
+	for _, window := range []*domain.MetricRequest{&base, &compare} {
+		if err := e.applyMetricRange(window); err != nil {
+			return &domain.MetricDiffResponse{
+				Success: false,
+				Message: "Failed to compare metrics: " + err.Error(),
+				Code:    domain.MetricCodeRangeTooLong,
+			}, err
+		}
+		window.ApproxUnique = e.flags.Enabled(FlagApproxUnique)
+	}
+
+	var baseMetrics []database.MetricResult
+	var baseErr error
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		baseMetrics, baseErr = e.clickhouseDB.GetMetricsFrom(ctx, tenantDB, base)
+	})
+	compareMetrics, compareErr := e.clickhouseDB.GetMetricsFrom(ctx, tenantDB, compare)
+	wg.Wait()
+	if err := errors.Join(baseErr, compareErr); err != nil {
+		return &domain.MetricDiffResponse{
+			Success: false,
+			Message: "Failed to compare metrics: " + err.Error(),
+		}, err
+	}
+
+	return &domain.MetricDiffResponse{
+		Success: true,
+		Message: "Metrics compared successfully",
+		Metrics: diffMetrics(baseMetrics, compareMetrics),
+	}, nil
+}
+
+// diffMetrics returns the change of each bucket of either window, in bucket order
+func diffMetrics(base, compare []database.MetricResult) []domain.MetricDiff {
+	diffs := make(map[string]*domain.MetricDiff, max(len(base), len(compare)))
+	for _, m := range base {
+		diffs[m.Bucket] = &domain.MetricDiff{
+			Bucket:     m.Bucket,
+			Status:     domain.MetricDiffDisappeared,
+			BaseEvents: m.TotalEvents,
+			BaseUsers:  m.UniqueUsers,
+		}
+	}
+	for _, m := range compare {
+		diff, ok := diffs[m.Bucket]
+		if !ok {
+			diff = &domain.MetricDiff{Bucket: m.Bucket, Status: domain.MetricDiffNew}
+			diffs[m.Bucket] = diff
+		} else {
+			diff.Status = domain.MetricDiffCommon
+		}
+		diff.CompareEvents = m.TotalEvents
+		diff.CompareUsers = m.UniqueUsers
+	}
+
+	result := make([]domain.MetricDiff, 0, len(diffs))
+	for _, diff := range diffs {
+		diff.EventsDelta = int64(diff.CompareEvents) - int64(diff.BaseEvents)
+		diff.UsersDelta = int64(diff.CompareUsers) - int64(diff.BaseUsers)
+		// Ungrouped windows without events still have a total bucket, its change is undefined
+		if diff.BaseEvents > 0 {
+			change := float64(diff.EventsDelta) / float64(diff.BaseEvents) * 100
+			diff.EventsChange = &change
+		}
+		result = append(result, *diff)
+	}
+	slices.SortFunc(result, func(a, b domain.MetricDiff) int {
+		return strings.Compare(a.Bucket, b.Bucket)
+	})
+	return result
+}
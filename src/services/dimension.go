@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+)
+
+var (
+	// ErrUnknownDimension is returned when metrics are enriched with a dimension that was not uploaded
+	ErrUnknownDimension = errors.New("unknown dimension")
+	// ErrDimensionNotFound is returned when a dimension managed through the admin API does not exist
+	ErrDimensionNotFound = errors.New("dimension not found")
+)
+
+// defaultDimensionLifetime is the refresh interval in seconds of dimensions loaded from a URL
+const defaultDimensionLifetime = 3600
+
+var _ domain.DimensionService = &dimensionService{}
+
+type dimensionService struct {
+	clickhouseDB  database.ClickHouseDB
+	clickhouseCfg *config.ClickHouseConfig
+}
+
+// UploadDimension replaces the rows of a dimension and reloads the dictionary serving its attributes
+func (d dimensionService) UploadDimension(ctx context.Context, request *domain.DimensionUploadRequest) (*domain.DimensionResponse, error) {
+	if err := d.clickhouseDB.SaveDimension(ctx, d.clickhouseCfg, request.Name, request.Columns, request.Rows); err != nil {
+		return &domain.DimensionResponse{
+			Success: false,
+			Message: "Failed to upload dimension: " + err.Error(),
+		}, err
+	}
+	return d.dimensionResponse(ctx, request.Name, "Dimension uploaded successfully")
+}
+
+// SetDimensionURL creates or replaces the dictionary of a dimension loading its rows from a URL.
+// The dictionary is loaded right away, so that a wrong URL or format is reported in the response.
+func (d dimensionService) SetDimensionURL(ctx context.Context, request *domain.DimensionURLRequest) (*domain.DimensionResponse, error) {
+	format := request.Format
+	if format == "" {
+		format = domain.DimensionURLFormats[0]
+	}
+	lifetime := defaultDimensionLifetime
+	if request.LifetimeSeconds != nil {
+		lifetime = *request.LifetimeSeconds
+	}
+
+	if err := d.clickhouseDB.SaveURLDimension(ctx, request.Name, request.URL, format, request.Columns, lifetime); err != nil {
+		return &domain.DimensionResponse{
+			Success: false,
+			Message: "Failed to create dimension: " + err.Error(),
+		}, err
+	}
+	if err := d.clickhouseDB.ReloadDimension(ctx, request.Name); err != nil {
+		// The dictionary exists and is retried on the next reload, report why it could not be loaded
+		resp, _ := d.dimensionResponse(ctx, request.Name, "Dimension created but failed to load: "+err.Error())
+		resp.Success = false
+		return resp, err
+	}
+	return d.dimensionResponse(ctx, request.Name, "Dimension created successfully")
+}
+
+// ListDimensions returns the dimensions with the state of their dictionaries
+func (d dimensionService) ListDimensions(ctx context.Context) (*domain.DimensionListResponse, error) {
+	statuses, err := d.clickhouseDB.GetDimensionStatuses(ctx, "")
+	if err != nil {
+		return &domain.DimensionListResponse{
+			Success: false,
+			Message: "Failed to retrieve dimensions: " + err.Error(),
+		}, err
+	}
+
+	dimensions := make([]domain.Dimension, len(statuses))
+	for i, status := range statuses {
+		dimensions[i] = toDimension(status)
+	}
+	return &domain.DimensionListResponse{
+		Success:    true,
+		Message:    "Dimensions retrieved successfully",
+		Dimensions: dimensions,
+	}, nil
+}
+
+// GetDimension returns a dimension with the state of its dictionary
+func (d dimensionService) GetDimension(ctx context.Context, name string) (*domain.DimensionResponse, error) {
+	return d.dimensionResponse(ctx, name, "Dimension retrieved successfully")
+}
+
+// ReloadDimension reloads the dictionary of a dimension from its source now
+func (d dimensionService) ReloadDimension(ctx context.Context, name string) (*domain.DimensionResponse, error) {
+	resp, err := d.dimensionResponse(ctx, name, "")
+	if err != nil {
+		return resp, err
+	}
+	if err := d.clickhouseDB.ReloadDimension(ctx, name); err != nil {
+		resp, _ = d.dimensionResponse(ctx, name, "Failed to reload dimension: "+err.Error())
+		resp.Success = false
+		return resp, err
+	}
+	return d.dimensionResponse(ctx, name, "Dimension reloaded successfully")
+}
+
+// DeleteDimension drops the dictionary of a dimension and its uploaded rows
+func (d dimensionService) DeleteDimension(ctx context.Context, name string) (*domain.DimensionResponse, error) {
+	resp, err := d.dimensionResponse(ctx, name, "")
+	if err != nil {
+		return resp, err
+	}
+	if err := d.clickhouseDB.DeleteDimension(ctx, name); err != nil {
+		return &domain.DimensionResponse{
+			Success: false,
+			Message: "Failed to delete dimension: " + err.Error(),
+		}, err
+	}
+	return &domain.DimensionResponse{
+		Success: true,
+		Message: "Dimension deleted successfully",
+	}, nil
+}
+
+// dimensionResponse returns the current state of a dimension with the given message, or ErrDimensionNotFound
+func (d dimensionService) dimensionResponse(ctx context.Context, name, message string) (*domain.DimensionResponse, error) {
+	statuses, err := d.clickhouseDB.GetDimensionStatuses(ctx, name)
+	if err != nil {
+		return &domain.DimensionResponse{
+			Success: false,
+			Message: "Failed to retrieve dimension: " + err.Error(),
+		}, err
+	}
+	if len(statuses) == 0 {
+		return &domain.DimensionResponse{
+			Success: false,
+			Message: "Dimension not found",
+		}, ErrDimensionNotFound
+	}
+
+	dimension := toDimension(statuses[0])
+	return &domain.DimensionResponse{
+		Success:   true,
+		Message:   message,
+		Dimension: &dimension,
+	}, nil
+}
+
+func toDimension(status database.DimensionStatus) domain.Dimension {
+	dimension := domain.Dimension{
+		Name:            status.Name,
+		Attributes:      status.Attributes,
+		Rows:            status.ElementCount,
+		Status:          status.Status,
+		Source:          status.Source,
+		BytesAllocated:  status.BytesAllocated,
+		LifetimeSeconds: status.LifetimeMax,
+		LastError:       status.LastException,
+	}
+	if len(status.Keys) > 0 {
+		dimension.Key = status.Keys[0]
+	}
+	if !status.LastUpdate.IsZero() && status.LastUpdate.Unix() > 0 {
+		dimension.LastUpdate = status.LastUpdate.Unix()
+	}
+	return dimension
+}
+
+// NewDimensionService returns a domain.DimensionService storing dimensions in the connection's database.
+func NewDimensionService(db database.ClickHouseDB, cfg *config.ClickHouseConfig) (domain.DimensionService, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("ClickHouse database connection cannot be nil")
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("ClickHouse config cannot be nil")
+	}
+	return &dimensionService{clickhouseDB: db, clickhouseCfg: cfg}, nil
+}
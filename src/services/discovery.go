@@ -0,0 +1,355 @@
+package services
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/logging"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoveryLog logs the messages of the ServiceRegistrar
+var discoveryLog = logging.Named("ServiceRegistrar")
+
+// Service discovery backends
+const (
+	DiscoveryConsul = "consul"
+	DiscoveryEtcd   = "etcd"
+)
+
+// discoveryTimeout bounds each request to the discovery backend
+const discoveryTimeout = 5 * time.Second
+
+// ServiceInstance is how the instance is registered, stored as the value of its etcd key
+type ServiceInstance struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Address     string   `json:"address"`
+	Port        int      `json:"port"`
+	Tags        []string `json:"tags,omitempty"`
+	HealthCheck string   `json:"health_check"`
+}
+
+// registryBackend registers an instance in a discovery backend, register is called again to keep the registration alive
+type registryBackend interface {
+	register(ctx context.Context) error
+	deregister(ctx context.Context) error
+}
+
+// ServiceRegistrar keeps the instance registered in Consul or etcd while it runs, so that ingestion endpoints can be discovered.
+// The registration is refreshed every third of the TTL, which also restores it after the backend lost it.
+type ServiceRegistrar struct {
+	backend  registryBackend
+	instance ServiceInstance
+	interval time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewServiceRegistrar creates a registrar of the instance listening on port, nil if no discovery backend is configured
+func NewServiceRegistrar(cfg *config.DiscoveryConfig, port string) (*ServiceRegistrar, error) {
+	if cfg.Backend == "" {
+		return nil, nil
+	}
+	if cfg.TTLSeconds < 3 {
+		return nil, fmt.Errorf("discovery TTL must be at least 3 seconds, got %d", cfg.TTLSeconds)
+	}
+	instance, err := serviceInstance(cfg, port)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: discoveryTimeout}
+	registrar := &ServiceRegistrar{
+		instance: instance,
+		interval: time.Duration(cfg.TTLSeconds) * time.Second / 3,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	switch cfg.Backend {
+	case DiscoveryConsul:
+		registrar.backend = &consulBackend{
+			client:   client,
+			address:  strings.TrimSuffix(cmp.Or(cfg.Address, "http://127.0.0.1:8500"), "/"),
+			token:    cfg.Token,
+			instance: instance,
+			ttl:      cfg.TTLSeconds,
+		}
+	case DiscoveryEtcd:
+		registrar.backend = &etcdBackend{
+			client:   client,
+			address:  strings.TrimSuffix(cmp.Or(cfg.Address, "http://127.0.0.1:2379"), "/"),
+			token:    cfg.Token,
+			key:      cfg.EtcdPrefix + instance.Name + "/" + instance.ID,
+			instance: instance,
+			ttl:      cfg.TTLSeconds,
+		}
+	default:
+		return nil, fmt.Errorf("unknown discovery backend %q", cfg.Backend)
+	}
+	return registrar, nil
+}
+
+// serviceInstance resolves the registration of the instance, defaulting to the hostname and the listening port
+func serviceInstance(cfg *config.DiscoveryConfig, port string) (ServiceInstance, error) {
+	advertise := cfg.AdvertiseAddress
+	if advertise == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return ServiceInstance{}, fmt.Errorf("failed to resolve hostname: %w", err)
+		}
+		advertise = net.JoinHostPort(hostname, port)
+	}
+	host, portStr, err := net.SplitHostPort(advertise)
+	if err != nil {
+		return ServiceInstance{}, fmt.Errorf("invalid advertise address %q: %w", advertise, err)
+	}
+	advertisePort, err := strconv.Atoi(portStr)
+	if err != nil {
+		return ServiceInstance{}, fmt.Errorf("invalid advertise port %q: %w", portStr, err)
+	}
+
+	instance := ServiceInstance{
+		ID:          cfg.ServiceID,
+		Name:        cfg.ServiceName,
+		Address:     host,
+		Port:        advertisePort,
+		HealthCheck: cfg.HealthCheckURL,
+	}
+	if instance.ID == "" {
+		instance.ID = fmt.Sprintf("%s-%s-%d", cfg.ServiceName, host, advertisePort)
+	}
+	if instance.HealthCheck == "" {
+		instance.HealthCheck = "http://" + advertise + "/health"
+	}
+	for _, tag := range strings.Split(cfg.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			instance.Tags = append(instance.Tags, tag)
+		}
+	}
+	return instance, nil
+}
+
+// Start registers the instance and keeps the registration alive in the background.
+// Failures are logged and retried at the next refresh. It is safe to call on a nil registrar.
+func (r *ServiceRegistrar) Start() {
+	if r == nil {
+		return
+	}
+	go func() {
+		defer close(r.done)
+		registered := false
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+			err := r.backend.register(ctx)
+			cancel()
+			switch {
+			case err != nil:
+				discoveryLog.Errorf("Failed to register %s: %v", r.instance.ID, err)
+				registered = false
+			case !registered:
+				discoveryLog.Infof("Registered %s at %s:%d", r.instance.ID, r.instance.Address, r.instance.Port)
+				registered = true
+			}
+
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing the registration and deregisters the instance, so that no new traffic is routed to it.
+// Only the first call deregisters, so that a preStop drain and the shutdown can both stop it.
+// It is safe to call on a nil registrar.
+func (r *ServiceRegistrar) Stop(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	first := false
+	r.stopOnce.Do(func() {
+		close(r.stop)
+		first = true
+	})
+	if !first {
+		return nil
+	}
+	<-r.done
+	if err := r.backend.deregister(ctx); err != nil {
+		return fmt.Errorf("failed to deregister %s: %w", r.instance.ID, err)
+	}
+	discoveryLog.Infof("Deregistered %s", r.instance.ID)
+	return nil
+}
+
+// consulBackend registers the instance with the local Consul agent, which checks its health over HTTP
+type consulBackend struct {
+	client   *http.Client
+	address  string
+	token    string
+	instance ServiceInstance
+	ttl      int
+}
+
+// register registers the service with the agent, registering it again replaces the registration
+func (c *consulBackend) register(ctx context.Context) error {
+	interval := max(c.ttl/3, 1)
+	registration := map[string]any{
+		"ID":      c.instance.ID,
+		"Name":    c.instance.Name,
+		"Address": c.instance.Address,
+		"Port":    c.instance.Port,
+		"Tags":    c.instance.Tags,
+		"Check": map[string]any{
+			"HTTP":     c.instance.HealthCheck,
+			"Interval": fmt.Sprintf("%ds", interval),
+			"Timeout":  fmt.Sprintf("%ds", min(interval, int(discoveryTimeout/time.Second))),
+			// Instances that died without deregistering are removed by the agent
+			"DeregisterCriticalServiceAfter": fmt.Sprintf("%ds", max(c.ttl, 60)),
+		},
+	}
+	return c.put(ctx, "/v1/agent/service/register", registration)
+}
+
+func (c *consulBackend) deregister(ctx context.Context) error {
+	return c.put(ctx, "/v1/agent/service/deregister/"+url.PathEscape(c.instance.ID), nil)
+}
+
+func (c *consulBackend) put(ctx context.Context, path string, body any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.address+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	return doDiscoveryRequest(c.client, req, nil)
+}
+
+// etcdBackend stores the instance under a key attached to a lease, through the JSON gateway of the etcd v3 API.
+// The key disappears with the lease if the instance stops refreshing it.
+type etcdBackend struct {
+	client   *http.Client
+	address  string
+	token    string
+	key      string
+	instance ServiceInstance
+	ttl      int
+
+	mu    sync.Mutex
+	lease string // id of the current lease, empty until granted
+}
+
+// register keeps the current lease alive, or grants a new one and stores the key if there is none or it expired
+func (e *etcdBackend) register(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lease != "" {
+		var keepAlive struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+		if err := e.post(ctx, "/v3/lease/keepalive", map[string]string{"ID": e.lease}, &keepAlive); err != nil {
+			return err
+		}
+		// An expired lease is reported without a TTL, its key is gone
+		if ttl, _ := strconv.Atoi(keepAlive.Result.TTL); ttl > 0 {
+			return nil
+		}
+		e.lease = ""
+	}
+
+	var grant struct {
+		ID string `json:"ID"`
+	}
+	if err := e.post(ctx, "/v3/lease/grant", map[string]any{"TTL": e.ttl}, &grant); err != nil {
+		return err
+	}
+	value, err := json.Marshal(e.instance)
+	if err != nil {
+		return err
+	}
+	put := map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(e.key)),
+		"value": base64.StdEncoding.EncodeToString(value),
+		"lease": grant.ID,
+	}
+	if err := e.post(ctx, "/v3/kv/put", put, nil); err != nil {
+		return err
+	}
+	e.lease = grant.ID
+	return nil
+}
+
+// deregister revokes the lease, deleting the key
+func (e *etcdBackend) deregister(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lease == "" {
+		return nil
+	}
+	if err := e.post(ctx, "/v3/lease/revoke", map[string]string{"ID": e.lease}, nil); err != nil {
+		return err
+	}
+	e.lease = ""
+	return nil
+}
+
+func (e *etcdBackend) post(ctx context.Context, path string, body, result any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.address+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", e.token)
+	}
+	return doDiscoveryRequest(e.client, req, result)
+}
+
+// doDiscoveryRequest sends a request to a discovery backend and decodes its JSON response into result, if not nil
+func doDiscoveryRequest(client *http.Client, req *http.Request, result any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"sync/atomic"
+	"time"
+)
+
+// drainLog logs the messages of the Drainer
+var drainLog = logging.Named("Drainer")
+
+// Drainer takes the instance out of rotation before it is stopped, so that rolling updates lose no events.
+// Once draining, /health fails so that readiness probes and load balancers stop routing to the instance,
+// which is also deregistered from service discovery. Requests still arriving are served during the delay,
+// then the buffered events are flushed without waiting for full batches.
+type Drainer struct {
+	eventService domain.EventService
+	registrar    *ServiceRegistrar
+	delay        time.Duration
+	timeout      time.Duration
+	draining     atomic.Bool
+}
+
+// NewDrainer creates a drainer of the event service's buffer and the registrar's registration, which may be nil
+func NewDrainer(cfg *config.ServerConfig, eventService domain.EventService, registrar *ServiceRegistrar) *Drainer {
+	return &Drainer{
+		eventService: eventService,
+		registrar:    registrar,
+		delay:        time.Duration(cfg.PreStopDelaySeconds) * time.Second,
+		timeout:      time.Duration(cfg.PreStopTimeoutSeconds) * time.Second,
+	}
+}
+
+// Draining reports whether the instance is draining. It is safe to call on a nil drainer.
+func (d *Drainer) Draining() bool {
+	return d != nil && d.draining.Load()
+}
+
+// Drain switches the instance to drain mode and blocks until the buffered events are flushed or the timeout expires.
+// Draining again only waits for the buffer to be flushed.
+func (d *Drainer) Drain(ctx context.Context) *domain.PreStopResponse {
+	start := time.Now()
+	if d.draining.CompareAndSwap(false, true) {
+		drainLog.Infof("Draining, keeping serving for %v", d.delay)
+		deregisterCtx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+		if err := d.registrar.Stop(deregisterCtx); err != nil {
+			drainLog.Errorf("Error leaving service discovery: %v", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(d.delay - time.Since(start)):
+		}
+	}
+
+	flushCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	err := DrainEventService(flushCtx, d.eventService)
+	stats := d.eventService.GetIngestionStats()
+	response := &domain.PreStopResponse{
+		Success:        err == nil,
+		Message:        "Buffered events flushed",
+		PendingEvents:  stats.BufferedEvents + stats.BatchEvents + stats.OverflowEvents,
+		ElapsedSeconds: time.Since(start).Seconds(),
+	}
+	if err != nil {
+		response.Message = "Failed to flush buffered events: " + err.Error()
+		drainLog.Infof("%s, %d events pending", response.Message, response.PendingEvents)
+	} else {
+		drainLog.Infof("Drained in %.1fs", response.ElapsedSeconds)
+	}
+	return response
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"sort"
+	"strings"
+)
+
+// duplicatesLog logs the messages of the DuplicateTracker
+var duplicatesLog = logging.Named("DuplicateTracker")
+
+// DuplicateTracker counts the events received per API key and channel, and the duplicates among them, in daily counters
+// in Redis shared by all instances. A producer whose retries resend events already stored, e.g. without waiting for the
+// response or on every timeout, stands out by its duplicate ratio.
+type DuplicateTracker struct {
+	redisRepo database.ClickHouseRedis
+}
+
+// NewDuplicateTracker creates a tracker counting in Redis
+func NewDuplicateTracker(redisClient database.ClickHouseRedis) (*DuplicateTracker, error) {
+	if redisClient.Client == nil {
+		return nil, fmt.Errorf("Redis client cannot be nil")
+	}
+	return &DuplicateTracker{redisRepo: redisClient}, nil
+}
+
+// Record counts the events of a request looked up in the processed events, processed holds the result of the lookup by
+// unique key. Replayed events are expected to be stored already and are not counted.
+// It is safe to call on nil, in which case nothing is recorded.
+func (d *DuplicateTracker) Record(ctx context.Context, events []domain.EventRequest, processed map[string]bool) {
+	if d == nil || len(events) == 0 {
+		return
+	}
+	received, duplicates := make(map[string]int64), make(map[string]int64)
+	for _, event := range events {
+		if event.Ingest.Replay {
+			continue
+		}
+		field := database.DuplicateField(event.Ingest.Producer, event.Channel)
+		received[field]++
+		if processed[event.GetUniqueKey()] {
+			duplicates[field]++
+		}
+	}
+	if len(received) == 0 {
+		return
+	}
+	if err := d.redisRepo.IncrementDuplicates(ctx, currentDay(), received, duplicates); err != nil {
+		duplicatesLog.Errorf("Failed to record duplicates of %d event(s): %v", len(events), err)
+	}
+}
+
+// Duplicates returns the events received today (UTC) and the duplicates among them per API key and channel,
+// the highest duplicate ratios first
+func (d *DuplicateTracker) Duplicates(ctx context.Context) ([]domain.DuplicateEntry, error) {
+	received, duplicates, err := d.redisRepo.GetDuplicates(ctx, currentDay())
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]domain.DuplicateEntry, 0, len(received))
+	for field, count := range received {
+		if count <= 0 {
+			continue
+		}
+		producer, channel, _ := strings.Cut(field, "|")
+		entries = append(entries, domain.DuplicateEntry{
+			Producer:       producer,
+			Channel:        channel,
+			ReceivedEvents: uint64(count),
+			Duplicates:     uint64(duplicates[field]),
+			DuplicateRatio: float64(duplicates[field]) / float64(count),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].DuplicateRatio != entries[j].DuplicateRatio {
+			return entries[i].DuplicateRatio > entries[j].DuplicateRatio
+		}
+		if entries[i].Producer != entries[j].Producer {
+			return entries[i].Producer < entries[j].Producer
+		}
+		return entries[i].Channel < entries[j].Channel
+	})
+	return entries, nil
+}
@@ -2,10 +2,32 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"kucukaslan/clickhouse/config"
 	"kucukaslan/clickhouse/database"
 	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/flush"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/transform"
+	"sort"
+	"time"
+)
+
+// eventLog logs the messages of the EventService
+var eventLog = logging.Named("EventService")
+
+var (
+	// ErrUserNotFound is returned when a user has no stored events
+	ErrUserNotFound = errors.New("user not found")
+	// ErrRangeTooLong is returned for metrics queries whose from and to are further apart than the maximum range
+	ErrRangeTooLong = errors.New("range too long")
+)
+
+// Modes of storing the events of /events/bulk
+const (
+	BulkModeSync  = "sync"  // events are inserted before the request is answered
+	BulkModeAsync = "async" // events are enqueued to the batcher and the request is answered with 202
 )
 
 var _ domain.EventService = &eventService{}
@@ -14,22 +36,100 @@ type eventService struct {
 	clickhouseDB  database.ClickHouseDB
 	clickhouseCfg *config.ClickHouseConfig
 	redisRepo     database.ClickHouseRedis
-	batcher       *EventBatcher
+	dedup         database.Deduplicator
+	batcher       eventBuffer
+	sinks         *flush.Manager
+	rateLimiter   *InsertRateLimiter
+	flags         *FeatureFlags
+	tenants       *TenantRouter
+	quotas        *QuotaEnforcer
+	duplicates    *DuplicateTracker
+	keys          *APIKeys
+	realtime      *RealtimeAggregator
+	aggregates    *AggregatePublisher
+	abuse         *AbuseScorer
+	raw           *RawPayloadStore
+	rewrites      *RewriteRules
+	transforms    *transform.Pipeline
 }
 
 func (e eventService) PostEvents(ctx context.Context, eventData *domain.EventRequest) (*domain.EventResponse, error) {
+	// Renamed values are rewritten first, so that the event is deduplicated and stored under its new name
+	rewritten := []domain.EventRequest{*eventData}
+	e.rewrites.Apply(rewritten)
+	if rewritten = e.transforms.Apply(rewritten); len(rewritten) == 0 {
+		return &domain.EventResponse{
+			Success: true,
+			Message: "Event dropped by a transform",
+		}, nil
+	}
+	*eventData = rewritten[0]
+	eventData.Ingest.RequestID = logging.RequestID(ctx)
 
-	// Check Redis cache for duplicate event
-	isProcessed, err := e.redisRepo.IsEventProcessed(ctx, *eventData)
-	if err != nil { /* do nothing or log? */
+	// Check the processed events for a duplicate, replays replace the stored event
+	isProcessed, err := e.dedup.IsEventProcessed(ctx, *eventData)
+	if err != nil {
+		// Stored anyway, ClickHouse deduplicates a copy
+		eventLog.Ctx(ctx).Warnf("Failed to check whether event %s was processed: %v", eventData.GetUniqueKey(), err)
 	}
-	if isProcessed {
+	recordProcessedLookups([]domain.EventRequest{*eventData}, map[string]bool{eventData.GetUniqueKey(): isProcessed}, err)
+	if err == nil {
+		e.duplicates.Record(ctx, []domain.EventRequest{*eventData}, map[string]bool{eventData.GetUniqueKey(): isProcessed})
+	}
+	if isProcessed && !eventData.Ingest.Replay {
 		return &domain.EventResponse{
 			Success: true,
 			Message: "Event already processed",
 		}, nil
 	}
 
+	scored := []domain.EventRequest{*eventData}
+	if kept := e.abuse.Score(scored); len(kept) == 0 {
+		return &domain.EventResponse{
+			Success: true,
+			Message: "Event dropped, its abuse score is above the threshold",
+		}, nil
+	}
+	eventData.Ingest.AbuseScore = scored[0].Ingest.AbuseScore
+
+	passed, _, err := quarantineEvents(ctx, e.clickhouseDB, []domain.EventRequest{*eventData})
+	if err != nil {
+		return &domain.EventResponse{
+			Success: false,
+			Message: "Failed to quarantine event: " + err.Error(),
+		}, err
+	}
+	if len(passed) == 0 {
+		return &domain.EventResponse{
+			Success: true,
+			Message: "Event quarantined, it violates data quality rules",
+		}, nil
+	}
+
+	admitted, err := e.quotas.Admit(ctx, eventData.Ingest.Tenant, []domain.EventRequest{*eventData})
+	if err != nil {
+		return &domain.EventResponse{
+			Success: false,
+			Message: "Monthly quota exceeded",
+		}, err
+	}
+	if len(admitted) == 0 {
+		return &domain.EventResponse{
+			Success: true,
+			Message: "Event sampled out, monthly quota exceeded",
+		}, nil
+	}
+
+	if e.clickhouseCfg.UserSequenceEnabled {
+		events := []domain.EventRequest{*eventData}
+		e.assignUserSequences(ctx, events)
+		eventData.Ingest.Sequence = events[0].Ingest.Sequence
+	}
+
+	if eventData.Ingest.Ack == domain.AckFlushed {
+		return e.enqueueAndWait(ctx, eventData)
+	}
+
 	// Enqueue event to batcher (non-blocking)
 	if err := e.batcher.Enqueue(*eventData); err != nil {
 		// If buffer is full, return error (will be handled as 503 in HTTP handler)
@@ -38,6 +138,7 @@ func (e eventService) PostEvents(ctx context.Context, eventData *domain.EventReq
 			Message: "Event buffer is full, please try again later",
 		}, err
 	}
+	recordIngested([]domain.EventRequest{*eventData})
 
 	return &domain.EventResponse{
 		Success: true,
@@ -45,13 +146,74 @@ func (e eventService) PostEvents(ctx context.Context, eventData *domain.EventReq
 	}, nil
 }
 
-// get unique keys, check redis with mget, filter processed events
+// enqueueAndWait hands an event over to the batcher and waits until it is flushed, for producers asking for ack=flushed.
+// A flush failing after all retries is reported, the event is dead-lettered then.
+func (e eventService) enqueueAndWait(ctx context.Context, eventData *domain.EventRequest) (*domain.EventResponse, error) {
+	ack := NewFlushAck()
+	if err := e.batcher.EnqueueWithAck(*eventData, ack); err != nil {
+		return &domain.EventResponse{
+			Success: false,
+			Message: "Event buffer is full, please try again later",
+		}, err
+	}
+	recordIngested([]domain.EventRequest{*eventData})
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(e.clickhouseCfg.FlushAckTimeoutSeconds)*time.Second)
+	defer cancel()
+	if err := ack.Wait(waitCtx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = ErrFlushTimeout
+		}
+		return &domain.EventResponse{
+			Success: false,
+			Message: "Failed to save event: " + err.Error(),
+		}, err
+	}
+
+	return &domain.EventResponse{
+		Success: true,
+		Message: "Event stored successfully",
+	}, nil
+}
+
+// stampRequestID records the correlation id of the request in its events, so that the batcher logs it with their flush
+func stampRequestID(ctx context.Context, events []domain.EventRequest) {
+	id := logging.RequestID(ctx)
+	if id == "" {
+		return
+	}
+	for i := range events {
+		events[i].Ingest.RequestID = id
+	}
+}
+
+// assignUserSequences assigns per-user sequence numbers to the events if enabled.
+// Numbers of events that end up rejected (e.g. buffer full) are not reused, so a gap means the event was not stored.
+// If Redis is unavailable the events are stored without a sequence number (0).
+func (e eventService) assignUserSequences(ctx context.Context, events []domain.EventRequest) {
+	if !e.clickhouseCfg.UserSequenceEnabled || len(events) == 0 {
+		return
+	}
+
+	sequences, err := e.redisRepo.NextUserSequences(ctx, events)
+	if err != nil {
+		eventLog.Ctx(ctx).Errorf("Failed to assign user sequence numbers: %v", err)
+		return
+	}
+	for i := range events {
+		events[i].Ingest.Sequence = sequences[i]
+	}
+}
+
+// get unique keys, look them up in the deduplicator, filter processed events
 func (e eventService) filterProcessedEvents(events []domain.EventRequest) []domain.EventRequest {
 	unprocessedEvents := make([]domain.EventRequest, 0, len(events))
-	maps, err := e.redisRepo.AreEventsProcessed(context.Background(), events)
+	maps, err := e.dedup.AreEventsProcessed(context.Background(), events)
+	recordProcessedLookups(events, maps, err)
 	if err != nil {
 		return events
 	}
+	e.duplicates.Record(context.Background(), events, maps)
 	for _, event := range events {
 		if processed, exists := maps[event.GetUniqueKey()]; !exists || !processed {
 			unprocessedEvents = append(unprocessedEvents, event)
@@ -62,9 +224,60 @@ func (e eventService) filterProcessedEvents(events []domain.EventRequest) []doma
 
 func (e eventService) PostEventsBulk(ctx context.Context, bulkData *domain.BulkEventRequest) (*domain.BulkEventResponse, error) {
 	totalCount := len(bulkData.Events)
-	filteredEvents := e.filterProcessedEvents(bulkData.Events)
+	stampRequestID(ctx, bulkData.Events)
+	e.rewrites.Apply(bulkData.Events)
+	filteredEvents := e.abuse.Score(e.filterProcessedEvents(e.transforms.Apply(bulkData.Events)))
+	// All events of a bulk request belong to the tenant of the request
+	tenant := ""
+	if len(filteredEvents) > 0 {
+		tenant = filteredEvents[0].Ingest.Tenant
+	}
+	filteredEvents, quarantinedCount, err := quarantineEvents(ctx, e.clickhouseDB, filteredEvents)
+	if err != nil {
+		return &domain.BulkEventResponse{
+			Success:      false,
+			Message:      "Failed to quarantine events: " + err.Error(),
+			TotalCount:   totalCount,
+			SuccessCount: 0,
+			FailureCount: totalCount,
+		}, err
+	}
+	filteredEvents, err = e.quotas.Admit(ctx, tenant, filteredEvents)
+	if err != nil {
+		return &domain.BulkEventResponse{
+			Success:      false,
+			Message:      "Monthly quota exceeded",
+			TotalCount:   totalCount,
+			SuccessCount: 0,
+			FailureCount: totalCount,
+		}, err
+	}
+	e.assignUserSequences(ctx, filteredEvents)
+
+	// EVENT_BULK_MODE is the default of the flag
+	if e.flags.Enabled(FlagAsyncBulk) {
+		return e.enqueueBulk(bulkData.Events, filteredEvents, quarantinedCount)
+	}
+	// Inserting directly would overtake the user's events still in the batcher
+	if e.clickhouseCfg.Ordering == OrderingUser && len(filteredEvents) > 0 {
+		return e.enqueueBulkAndWait(ctx, bulkData.Events, filteredEvents, quarantinedCount)
+	}
+
+	// Every event was a duplicate, quarantined or sampled out by the tenant's quota
+	if len(filteredEvents) == 0 {
+		recordIngested(bulkData.Events)
+		return &domain.BulkEventResponse{
+			Success:          true,
+			Message:          "Bulk events posted successfully",
+			TotalCount:       totalCount,
+			SuccessCount:     totalCount,
+			FailureCount:     0,
+			QuarantinedCount: quarantinedCount,
+		}, nil
+	}
 
-	if err := e.clickhouseDB.SaveEvents(ctx, filteredEvents); err != nil {
+	// Bulk inserts share the insert rate limiter with the batcher
+	if _, err := e.rateLimiter.Wait(ctx, len(filteredEvents)); err != nil {
 		return &domain.BulkEventResponse{
 			Success:      false,
 			Message:      "Failed to save bulk events: " + err.Error(),
@@ -74,24 +287,156 @@ func (e eventService) PostEventsBulk(ctx context.Context, bulkData *domain.BulkE
 		}, err
 	}
 
+	columns := database.NewEventColumnar(len(filteredEvents))
+	for _, event := range filteredEvents {
+		if err := columns.Append(event); err != nil {
+			return &domain.BulkEventResponse{
+				Success:      false,
+				Message:      "Failed to save bulk events: " + err.Error(),
+				TotalCount:   totalCount,
+				SuccessCount: 0,
+				FailureCount: totalCount,
+			}, err
+		}
+	}
+
+	batch := flush.Batch{Tenant: tenant, Events: filteredEvents, Columns: columns}
+	if err := e.sinks.Write(ctx, batch); err != nil {
+		recordInsertError("events")
+		return &domain.BulkEventResponse{
+			Success:      false,
+			Message:      "Failed to save bulk events: " + err.Error(),
+			TotalCount:   totalCount,
+			SuccessCount: 0,
+			FailureCount: totalCount,
+		}, err
+	}
+	recordIngested(bulkData.Events)
+	recordStored(filteredEvents, columns)
+	// Synchronous inserts bypass the batcher, count them here
+	e.realtime.Record(filteredEvents...)
+	e.aggregates.Publish(tenant, filteredEvents)
+	e.raw.Save(filteredEvents)
+	e.sinks.Replicate(batch)
+
+	log := eventLog.Ctx(ctx)
+	// The request may be answered, and its context cancelled, before the events are marked
+	markCtx := context.WithoutCancel(ctx)
 	go func() {
-		err := e.redisRepo.SetMultipleEventsProcessed(ctx, filteredEvents)
+		err := e.dedup.SetMultipleEventsProcessed(markCtx, filteredEvents)
 		if err != nil {
-			// log error
+			log.Errorf("Failed to mark %d bulk events as processed: %v", len(filteredEvents), err)
 		}
+		e.quotas.RecordStored(context.Background(), tenant, len(filteredEvents), columns.Size())
+		e.keys.RecordStored(context.Background(), filteredEvents)
 	}()
 
 	return &domain.BulkEventResponse{
-		Success:      true,
-		Message:      "Bulk events posted successfully",
-		TotalCount:   totalCount,
-		SuccessCount: totalCount,
-		FailureCount: 0,
+		Success:          true,
+		Message:          "Bulk events posted successfully",
+		TotalCount:       totalCount,
+		SuccessCount:     totalCount,
+		FailureCount:     0,
+		QuarantinedCount: quarantinedCount,
+	}, nil
+}
+
+// enqueueBulk hands the events of a bulk request over to the batcher instead of inserting them synchronously.
+// A request the buffer has no room for is rejected as a whole, so that clients back off and retry it.
+// If the buffer still fills up meanwhile, the events enqueued so far are kept and the rest are reported as failed.
+func (e eventService) enqueueBulk(events, filteredEvents []domain.EventRequest, quarantinedCount int) (*domain.BulkEventResponse, error) {
+	totalCount := len(events)
+	// With an overflow queue the events beyond the buffer's room are spilled to disk
+	if e.clickhouseCfg.OverflowDir == "" && !e.batcher.HasRoom(filteredEvents) {
+		return &domain.BulkEventResponse{
+			Success:          false,
+			Message:          "Event buffer is full, please try again later",
+			TotalCount:       totalCount,
+			SuccessCount:     0,
+			FailureCount:     totalCount,
+			QuarantinedCount: quarantinedCount,
+		}, ErrBufferFull
+	}
+	for i, event := range filteredEvents {
+		if err := e.batcher.Enqueue(event); err != nil {
+			recordIngested(filteredEvents[:i])
+			failed := len(filteredEvents) - i
+			return &domain.BulkEventResponse{
+				Success:          false,
+				Message:          "Event buffer is full, please try again later",
+				TotalCount:       totalCount,
+				SuccessCount:     totalCount - failed,
+				FailureCount:     failed,
+				QuarantinedCount: quarantinedCount,
+			}, err
+		}
+	}
+	recordIngested(events)
+
+	return &domain.BulkEventResponse{
+		Success:          true,
+		Message:          "Bulk events enqueued successfully",
+		TotalCount:       totalCount,
+		SuccessCount:     totalCount,
+		FailureCount:     0,
+		QuarantinedCount: quarantinedCount,
+		Enqueued:         true,
+	}, nil
+}
+
+// enqueueBulkAndWait hands the events of a bulk request over to the batcher and waits until they are flushed,
+// so that they are stored in order with the other events of their users while the request stays synchronous.
+// If the buffer fills up, the events enqueued so far are still stored and the rest are reported as failed.
+func (e eventService) enqueueBulkAndWait(ctx context.Context, events, filteredEvents []domain.EventRequest, quarantinedCount int) (*domain.BulkEventResponse, error) {
+	totalCount := len(events)
+	ack := NewFlushAck()
+	for i, event := range filteredEvents {
+		if err := e.batcher.EnqueueWithAck(event, ack); err != nil {
+			recordIngested(filteredEvents[:i])
+			failed := len(filteredEvents) - i
+			return &domain.BulkEventResponse{
+				Success:          false,
+				Message:          "Event buffer is full, please try again later",
+				TotalCount:       totalCount,
+				SuccessCount:     totalCount - failed,
+				FailureCount:     failed,
+				QuarantinedCount: quarantinedCount,
+			}, err
+		}
+	}
+	recordIngested(events)
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(e.clickhouseCfg.FlushAckTimeoutSeconds)*time.Second)
+	defer cancel()
+	if err := ack.Wait(waitCtx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = ErrFlushTimeout
+		}
+		return &domain.BulkEventResponse{
+			Success:      false,
+			Message:      "Failed to save bulk events: " + err.Error(),
+			TotalCount:   totalCount,
+			SuccessCount: 0,
+			FailureCount: totalCount,
+		}, err
+	}
+
+	return &domain.BulkEventResponse{
+		Success:          true,
+		Message:          "Bulk events posted successfully",
+		TotalCount:       totalCount,
+		SuccessCount:     totalCount,
+		FailureCount:     0,
+		QuarantinedCount: quarantinedCount,
 	}, nil
 }
 
 func (e eventService) GetMetrics(ctx context.Context, metricRequest *domain.MetricRequest) (*domain.MetricResponse, error) {
-	metrics, err := e.clickhouseDB.GetMetrics(ctx, *metricRequest)
+	defaultFrom := metricRequest.From == nil
+	tenantDB, err := e.tenants.Database(ctx, metricRequest.Tenant)
+	if err == nil {
+		err = e.pinMetricRequest(ctx, tenantDB, metricRequest)
+	}
 	if err != nil {
 		return &domain.MetricResponse{
 			Success: false,
@@ -99,26 +444,414 @@ func (e eventService) GetMetrics(ctx context.Context, metricRequest *domain.Metr
 			Metrics: nil,
 		}, err
 	}
+	if err := e.applyMetricRange(metricRequest); err != nil {
+		return &domain.MetricResponse{
+			Success: false,
+			Message: "Failed to retrieve metrics: " + err.Error(),
+			Code:    domain.MetricCodeRangeTooLong,
+		}, err
+	}
+	metricRequest.ApproxUnique = e.flags.Enabled(FlagApproxUnique)
+
+	if metricRequest.Enrich != nil {
+		attributes, err := e.clickhouseDB.GetDimensionAttributes(ctx, *metricRequest.Enrich)
+		if err == nil && attributes == nil {
+			err = fmt.Errorf("%w %q", ErrUnknownDimension, *metricRequest.Enrich)
+		}
+		if err != nil {
+			return &domain.MetricResponse{
+				Success: false,
+				Message: "Failed to retrieve metrics: " + err.Error(),
+				Metrics: nil,
+			}, err
+		}
+		metricRequest.EnrichAttributes = attributes
+	}
+
+	// With the hybrid_metrics flag the tail of the range is counted from memory, ClickHouse only serves the rest
+	historical := *metricRequest
+	tail, hybrid := e.hybridTail(metricRequest)
+	if hybrid {
+		to := tail.Unix() - 1
+		if historical.To == nil || to < *historical.To {
+			historical.To = &to
+		}
+	}
+
+	var metrics []database.MetricResult
+	if historical.From == nil || historical.To == nil || *historical.From <= *historical.To {
+		metrics, err = e.clickhouseDB.GetMetricsFrom(ctx, tenantDB, historical)
+		if err != nil {
+			return &domain.MetricResponse{
+				Success: false,
+				Message: "Failed to retrieve metrics: " + err.Error(),
+				Metrics: nil,
+			}, err
+		}
+	} else if metricRequest.GroupBy == nil {
+		// Ungrouped queries always return a total, even if the whole range is in the tail
+		metrics = []database.MetricResult{{Bucket: "total"}}
+	}
 
-	return &domain.MetricResponse{
+	resp := &domain.MetricResponse{
 		Success: true,
 		Message: "Metrics retrieved successfully",
-		Metrics: func() []domain.MetricResult {
-			results := make([]domain.MetricResult, len(metrics))
-			for i, m := range metrics {
-				results[i] = domain.MetricResult{
-					Bucket:      m.Bucket,
-					TotalEvents: m.TotalEvents,
-					UniqueUsers: m.UniqueUsers,
-				}
+	}
+	if defaultFrom && metricRequest.From != nil {
+		resp.From = *metricRequest.From
+	}
+	if metricRequest.AsOf != nil {
+		resp.AsOf = *metricRequest.AsOf
+	}
+	if metricRequest.TopK != nil {
+		resp.TopK = &domain.TopKAccuracy{K: *metricRequest.TopK, Note: domain.TopKNote}
+	}
+	if hybrid {
+		metrics = mergeRealtime(metrics,
+			e.realtime.counts(metricRequest.Tenant, metricRequest.EventName, tail),
+			realtimeBucketFunc(metricRequest.GroupBy))
+		resp.RealtimeFrom = tail.Unix()
+	}
+
+	// New users are defined by the first event of any name, filtered queries cannot tell them.
+	// The first events are kept regardless of when they were stored, pinned queries cannot tell them either.
+	// They are kept per events table, tenants sharing the table cannot tell theirs.
+	var newUsers map[string]uint64
+	sharedTenant := tenantDB == "" && metricRequest.Tenant != ""
+	if metricRequest.EventName == nil && metricRequest.Tag == nil && metricRequest.MaxAbuseScore == nil && metricRequest.AsOf == nil && !sharedTenant {
+		results, err := e.clickhouseDB.GetNewUsersFrom(ctx, tenantDB, *metricRequest)
+		if err != nil {
+			return &domain.MetricResponse{
+				Success: false,
+				Message: "Failed to retrieve new users: " + err.Error(),
+				Metrics: nil,
+			}, err
+		}
+		if results != nil {
+			newUsers = make(map[string]uint64, len(results))
+			for _, r := range results {
+				newUsers[r.Bucket] = r.NewUsers
 			}
-			return results
-		}(),
+		}
+	}
+
+	resp.Metrics = make([]domain.MetricResult, len(metrics))
+	for i, m := range metrics {
+		resp.Metrics[i] = domain.MetricResult{
+			Bucket:      m.Bucket,
+			TotalEvents: m.TotalEvents,
+			UniqueUsers: m.UniqueUsers,
+		}
+		if metricRequest.TopK != nil {
+			countError := m.CountError
+			resp.Metrics[i].CountError = &countError
+			resp.TopK.MaxCountError = max(resp.TopK.MaxCountError, countError)
+		}
+		if newUsers != nil {
+			count := newUsers[m.Bucket]
+			resp.Metrics[i].NewUsers = &count
+		}
+		if len(m.AttributeValues) == len(metricRequest.EnrichAttributes) && len(m.AttributeValues) > 0 {
+			resp.Metrics[i].Attributes = make(map[string]string, len(m.AttributeValues))
+			for j, value := range m.AttributeValues {
+				resp.Metrics[i].Attributes[metricRequest.EnrichAttributes[j]] = value
+			}
+		}
+	}
+
+	if metricRequest.AnnotateAnomalies {
+		if err := e.annotateAnomalies(ctx, tenantDB, metricRequest, resp.Metrics); err != nil {
+			return &domain.MetricResponse{
+				Success: false,
+				Message: "Failed to score anomalies: " + err.Error(),
+				Metrics: nil,
+			}, err
+		}
+	}
+	return resp, nil
+}
+
+// StreamMetrics runs a metrics query and returns its buckets as they are read, for groupings too large to hold in memory.
+// It serves ClickHouse alone: buckets carry no realtime counts and no new users.
+func (e eventService) StreamMetrics(ctx context.Context, metricRequest *domain.MetricRequest) (domain.MetricStream, error) {
+	tenantDB, err := e.tenants.Database(ctx, metricRequest.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.pinMetricRequest(ctx, tenantDB, metricRequest); err != nil {
+		return nil, err
+	}
+	if err := e.applyMetricRange(metricRequest); err != nil {
+		return nil, err
+	}
+	metricRequest.ApproxUnique = e.flags.Enabled(FlagApproxUnique)
+	if metricRequest.Enrich != nil {
+		attributes, err := e.clickhouseDB.GetDimensionAttributes(ctx, *metricRequest.Enrich)
+		if err == nil && attributes == nil {
+			err = fmt.Errorf("%w %q", ErrUnknownDimension, *metricRequest.Enrich)
+		}
+		if err != nil {
+			return nil, err
+		}
+		metricRequest.EnrichAttributes = attributes
+	}
+
+	rows, err := e.clickhouseDB.QueryMetricsFrom(ctx, tenantDB, *metricRequest)
+	if err != nil {
+		return nil, err
+	}
+	return &metricStream{MetricRows: rows, attributes: metricRequest.EnrichAttributes}, nil
+}
+
+// metricStream converts the rows of a metrics query to results
+type metricStream struct {
+	*database.MetricRows
+	attributes []string
+}
+
+func (s *metricStream) Result() domain.MetricResult {
+	m := s.MetricRows.Result()
+	result := domain.MetricResult{
+		Bucket:      m.Bucket,
+		TotalEvents: m.TotalEvents,
+		UniqueUsers: m.UniqueUsers,
+	}
+	if len(m.AttributeValues) == len(s.attributes) && len(m.AttributeValues) > 0 {
+		result.Attributes = make(map[string]string, len(m.AttributeValues))
+		for j, value := range m.AttributeValues {
+			result.Attributes[s.attributes[j]] = value
+		}
+	}
+	return result
+}
+
+// GetUserSummary returns the first and last event time and the event count of a user
+func (e eventService) GetUserSummary(ctx context.Context, request *domain.UserSummaryRequest) (*domain.UserSummaryResponse, error) {
+	tenantDB, err := e.tenants.Database(ctx, request.Tenant)
+	if err != nil {
+		return &domain.UserSummaryResponse{
+			Success: false,
+			Message: "Failed to retrieve user summary: " + err.Error(),
+		}, err
+	}
+
+	summary, err := e.clickhouseDB.GetUserSummary(ctx, tenantDB, request.Tenant, request.UserID)
+	if err != nil {
+		return &domain.UserSummaryResponse{
+			Success: false,
+			Message: "Failed to retrieve user summary: " + err.Error(),
+		}, err
+	}
+	if summary == nil {
+		return &domain.UserSummaryResponse{
+			Success: false,
+			Message: "User not found",
+		}, ErrUserNotFound
+	}
+
+	return &domain.UserSummaryResponse{
+		Success: true,
+		Message: "User summary retrieved successfully",
+		User: &domain.UserSummary{
+			UserID:      summary.UserID,
+			FirstSeen:   summary.FirstSeen.Unix(),
+			LastSeen:    summary.LastSeen.Unix(),
+			TotalEvents: summary.TotalEvents,
+		},
+	}, nil
+}
+
+// pinMetricRequest pins a metrics query asking for it to the current ingestion watermark of its tenant's database,
+// unless it is already pinned. Without events the query is pinned to the current second.
+func (e eventService) pinMetricRequest(ctx context.Context, tenantDB string, metricRequest *domain.MetricRequest) error {
+	if !metricRequest.Pin || metricRequest.AsOf != nil {
+		return nil
+	}
+	watermark, err := e.clickhouseDB.GetIngestionWatermark(ctx, tenantDB)
+	if err != nil {
+		return fmt.Errorf("failed to read ingestion watermark: %w", err)
+	}
+	if watermark.IsZero() {
+		watermark = time.Now()
+	}
+	asOf := watermark.Unix()
+	metricRequest.AsOf = &asOf
+	return nil
+}
+
+// applyMetricRange starts a metrics query without from the default range before its end,
+// and rejects queries whose from and to are further apart than the maximum range.
+// A pinned query without to ends at its watermark.
+func (e eventService) applyMetricRange(metricRequest *domain.MetricRequest) error {
+	end := time.Now()
+	if metricRequest.To != nil {
+		end = time.Unix(*metricRequest.To, 0)
+	} else if metricRequest.AsOf != nil {
+		end = time.Unix(*metricRequest.AsOf, 0)
+	}
+	if metricRequest.From == nil && e.clickhouseCfg.MetricsDefaultRangeDays > 0 {
+		from := end.AddDate(0, 0, -e.clickhouseCfg.MetricsDefaultRangeDays).Unix()
+		metricRequest.From = &from
+	}
+	maxDays := e.clickhouseCfg.MetricsMaxRangeDays
+	if maxDays > 0 && metricRequest.From != nil && end.Sub(time.Unix(*metricRequest.From, 0)) > time.Duration(maxDays)*24*time.Hour {
+		return fmt.Errorf("%w, from and to must be at most %d days apart", ErrRangeTooLong, maxDays)
+	}
+	return nil
+}
+
+// hybridTail returns the start of the tail of a metrics query that is counted from the realtime aggregation.
+// Only queries reaching the current minute are affected by the batching latency, others are served by ClickHouse alone.
+func (e eventService) hybridTail(metricRequest *domain.MetricRequest) (time.Time, bool) {
+	// Realtime counts know nothing about tags and abuse scores, and their buckets would lack the dimension attributes.
+	// Pinned queries count stored events only, the realtime counts would move with every new event.
+	// Top buckets are estimates, exact realtime counts would not make them more accurate.
+	if e.realtime == nil || !e.flags.Enabled(FlagHybridMetrics) || metricRequest.Tag != nil || metricRequest.Enrich != nil || metricRequest.TopK != nil ||
+		metricRequest.MaxAbuseScore != nil || metricRequest.AsOf != nil || realtimeBucketFunc(metricRequest.GroupBy) == nil {
+		return time.Time{}, false
+	}
+	now := time.Now()
+	if metricRequest.To != nil && time.Unix(*metricRequest.To, 0).Before(now.Truncate(time.Minute)) {
+		return time.Time{}, false
+	}
+
+	tail := e.realtime.Tail()
+	if metricRequest.From != nil {
+		// Realtime counts are per minute, a minute partly before from is left to ClickHouse
+		from := time.Unix(*metricRequest.From, 0)
+		if start := from.Truncate(time.Minute); start.Before(from) {
+			from = start.Add(time.Minute)
+		}
+		if from.After(tail) {
+			tail = from
+		}
+	}
+	return tail, true
+}
+
+// mergeRealtime adds realtime counts to the buckets of the ClickHouse results, keeping the buckets in ascending order.
+// Unique users cannot be merged from counts, so they only reflect the events served by ClickHouse.
+func mergeRealtime(metrics []database.MetricResult, counts []realtimeCount, bucketOf func(realtimeCount) string) []database.MetricResult {
+	index := make(map[string]int, len(metrics))
+	for i, m := range metrics {
+		index[m.Bucket] = i
+	}
+	for _, c := range counts {
+		bucket := bucketOf(c)
+		i, ok := index[bucket]
+		if !ok {
+			metrics = append(metrics, database.MetricResult{Bucket: bucket})
+			i = len(metrics) - 1
+			index[bucket] = i
+		}
+		metrics[i].TotalEvents += c.count
+	}
+	sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].Bucket < metrics[j].Bucket })
+	return metrics
+}
+
+// GetRealtimeMetrics returns the per-minute counts of the last minutes from memory, without querying ClickHouse
+func (e eventService) GetRealtimeMetrics(_ context.Context, metricRequest *domain.RealtimeMetricRequest) (*domain.RealtimeMetricResponse, error) {
+	if e.realtime == nil {
+		return &domain.RealtimeMetricResponse{
+			Success: false,
+			Message: "Realtime aggregation is disabled",
+		}, ErrRealtimeDisabled
+	}
+
+	return &domain.RealtimeMetricResponse{
+		Success:       true,
+		Message:       "Realtime metrics retrieved successfully",
+		WindowMinutes: e.realtime.WindowMinutes(),
+		Metrics:       e.realtime.Query(metricRequest.Tenant, metricRequest.EventName, metricRequest.Channel, metricRequest.Minutes),
 	}, nil
 }
 
-// NewEventService returns a domain.EventService backed by the provided database connections.
-func NewEventService(db database.ClickHouseDB, cfg *config.ClickHouseConfig, redisClient database.ClickHouseRedis) (domain.EventService, error) {
+// PostEventStream enqueues a chunk of a client stream and waits until all of its events are flushed,
+// only then the chunk's checkpoint token is stored as the stream's last acknowledged checkpoint.
+// Clients resume after a disconnect from the last acknowledged checkpoint, duplicates are filtered by deduplication.
+func (e eventService) PostEventStream(ctx context.Context, streamData *domain.StreamEventRequest) (*domain.StreamEventResponse, error) {
+	resp := &domain.StreamEventResponse{
+		StreamID:   streamData.StreamID,
+		Checkpoint: streamData.CheckpointToken,
+		Count:      len(streamData.Events),
+	}
+
+	stampRequestID(ctx, streamData.Events)
+	e.rewrites.Apply(streamData.Events)
+	events, quarantinedCount, err := quarantineEvents(ctx, e.clickhouseDB, e.abuse.Score(e.filterProcessedEvents(e.transforms.Apply(streamData.Events))))
+	if err != nil {
+		resp.Message = "Failed to quarantine events: " + err.Error()
+		return resp, err
+	}
+	resp.QuarantinedCount = quarantinedCount
+	if len(events) > 0 {
+		// All events of a stream chunk belong to the tenant of the request
+		if events, err = e.quotas.Admit(ctx, events[0].Ingest.Tenant, events); err != nil {
+			resp.Message = "Monthly quota exceeded"
+			return resp, err
+		}
+	}
+	e.assignUserSequences(ctx, events)
+
+	ack := NewFlushAck()
+	for _, event := range events {
+		if err := e.batcher.EnqueueWithAck(event, ack); err != nil {
+			resp.Message = "Event buffer is full, resend the chunk later"
+			return resp, err
+		}
+	}
+	recordIngested(events)
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(e.clickhouseCfg.FlushAckTimeoutSeconds)*time.Second)
+	defer cancel()
+	if err := ack.Wait(waitCtx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = ErrFlushTimeout
+		}
+		eventLog.Ctx(ctx).Warnf("Chunk %s of stream %s was not flushed: %v", streamData.CheckpointToken, streamData.StreamID, err)
+		resp.Message = "Failed to flush stream chunk: " + err.Error()
+		return resp, err
+	}
+
+	if err := e.redisRepo.SetStreamCheckpoint(ctx, streamData.StreamID, streamData.CheckpointToken); err != nil {
+		resp.Message = "Failed to store checkpoint: " + err.Error()
+		return resp, err
+	}
+
+	resp.Success = true
+	resp.Acknowledged = true
+	resp.Message = "Stream chunk flushed and checkpoint acknowledged"
+	return resp, nil
+}
+
+// GetStreamCheckpoint returns the last acknowledged checkpoint token of a stream
+func (e eventService) GetStreamCheckpoint(ctx context.Context, streamID string) (*domain.StreamEventResponse, error) {
+	token, err := e.redisRepo.GetStreamCheckpoint(ctx, streamID)
+	if err != nil {
+		return &domain.StreamEventResponse{
+			Success:  false,
+			Message:  "Failed to retrieve checkpoint: " + err.Error(),
+			StreamID: streamID,
+		}, err
+	}
+	return &domain.StreamEventResponse{
+		Success:      true,
+		Message:      "Checkpoint retrieved successfully",
+		StreamID:     streamID,
+		Checkpoint:   token,
+		Acknowledged: token != "",
+	}, nil
+}
+
+// GetIngestionStats returns a snapshot of the event batcher state
+func (e eventService) GetIngestionStats() domain.IngestionStats {
+	return e.batcher.Stats()
+}
+
+// NewEventService returns a domain.EventService backed by the provided database connections,
+// writing the flushed events to the sinks.
+func NewEventService(db database.ClickHouseDB, cfg *config.ClickHouseConfig, redisClient database.ClickHouseRedis, dedup database.Deduplicator, metadata database.MetadataStore, flags *FeatureFlags, quotas *QuotaEnforcer, duplicates *DuplicateTracker, keys *APIKeys, abuse *AbuseScorer, aggregates *AggregatePublisher, rewrites *RewriteRules, deadLetters *DeadLetterQueue, tenants *TenantRouter, sinks *flush.Manager, transforms *transform.Pipeline, parts *PartsGuard, schema *SchemaCoordinator) (domain.EventService, error) {
 	if db.DB == nil {
 		return nil, fmt.Errorf("ClickHouse database connection cannot be nil")
 	}
@@ -128,22 +861,110 @@ func NewEventService(db database.ClickHouseDB, cfg *config.ClickHouseConfig, red
 	if cfg == nil {
 		return nil, fmt.Errorf("ClickHouse config cannot be nil")
 	}
+	if dedup == nil {
+		return nil, fmt.Errorf("deduplicator cannot be nil")
+	}
+	if deadLetters == nil {
+		return nil, fmt.Errorf("dead-letter queue cannot be nil")
+	}
+	if sinks == nil {
+		return nil, fmt.Errorf("sinks cannot be nil")
+	}
+	if cfg.MetricsMaxRangeDays > 0 && (cfg.MetricsDefaultRangeDays == 0 || cfg.MetricsDefaultRangeDays > cfg.MetricsMaxRangeDays) {
+		return nil, fmt.Errorf("default metrics range of %d days must be between 1 and the maximum range of %d days",
+			cfg.MetricsDefaultRangeDays, cfg.MetricsMaxRangeDays)
+	}
+
+	if cfg.Ordering != OrderingNone && cfg.Ordering != OrderingUser {
+		return nil, fmt.Errorf("unknown event ordering %q", cfg.Ordering)
+	}
+	if cfg.BulkMode != BulkModeSync && cfg.BulkMode != BulkModeAsync {
+		return nil, fmt.Errorf("unknown bulk mode %q", cfg.BulkMode)
+	}
+	if cfg.Ordering == OrderingUser && cfg.OrderingShards < 1 {
+		return nil, fmt.Errorf("event ordering shards must be positive, got %d", cfg.OrderingShards)
+	}
+
+	// Counts the events of the last minutes in memory, nil when disabled
+	var realtime *RealtimeAggregator
+	if cfg.RealtimeAggregation {
+		realtime = NewRealtimeAggregator(cfg.RealtimeWindowMinutes)
+	}
+
+	// Keeps the payloads of stored events for replays, nil when disabled
+	raw := NewRawPayloadStore(db, cfg)
 
-	// Create and start event batcher
-	batcher := NewEventBatcher(
-		cfg.BufferChannelCapacity,
-		cfg.BatchSize,
-		cfg.FlushIntervalSeconds,
-		db,
-		redisClient,
-	)
+	// Shared by the batcher and the bulk endpoint to smooth the insert rate toward ClickHouse and hold inserts back while it has too many parts
+	// or its events table is rewritten
+	rateLimiter := NewInsertRateLimiter(cfg.MaxInsertsPerSecond, cfg.MaxRowsPerSecond, parts, schema)
+
+	// Create and start event batcher, one per shard when events are ordered per user
+	notifier := NewWebhookNotifier(metadata)
+	newBatcher := func(capacity, flushConcurrency int, shard string) (*EventBatcher, error) {
+		// Spills the events of a full buffer to disk, nil when disabled
+		overflow, err := NewOverflowQueue(cfg, shard)
+		if err != nil {
+			return nil, err
+		}
+		return NewEventBatcher(
+			capacity,
+			cfg.BatchSize,
+			cfg.FlushIntervalSeconds,
+			flushConcurrency,
+			sinks,
+			dedup,
+			rateLimiter,
+			notifier,
+			quotas,
+			keys,
+			realtime,
+			aggregates,
+			raw,
+			NewFlushRetry(cfg),
+			deadLetters,
+			overflow,
+		), nil
+	}
+	var batcher eventBuffer
+	if cfg.Ordering == OrderingUser {
+		// The shards share the buffer capacity, each flushes one batch at a time
+		shards := make([]*EventBatcher, cfg.OrderingShards)
+		for i := range shards {
+			shard, err := newBatcher(max(cfg.BufferChannelCapacity/cfg.OrderingShards, 1), 1, fmt.Sprintf("shard_%d", i))
+			if err != nil {
+				return nil, err
+			}
+			shards[i] = shard
+		}
+		batcher = NewShardedBatcher(shards)
+	} else {
+		single, err := newBatcher(cfg.BufferChannelCapacity, cfg.FlushConcurrency, "")
+		if err != nil {
+			return nil, err
+		}
+		batcher = single
+	}
 	batcher.Start()
 
 	srv := &eventService{
 		clickhouseDB:  db,
 		clickhouseCfg: cfg,
 		redisRepo:     redisClient,
+		dedup:         dedup,
 		batcher:       batcher,
+		sinks:         sinks,
+		rateLimiter:   rateLimiter,
+		flags:         flags,
+		tenants:       tenants,
+		quotas:        quotas,
+		duplicates:    duplicates,
+		keys:          keys,
+		realtime:      realtime,
+		aggregates:    aggregates,
+		abuse:         abuse,
+		raw:           raw,
+		rewrites:      rewrites,
+		transforms:    transforms,
 	}
 	return srv, nil
 }
@@ -156,6 +977,14 @@ func (e *eventService) Shutdown() error {
 	return nil
 }
 
+// Drain flushes the buffered events without waiting for full batches, see EventBatcher.Drain
+func (e *eventService) Drain(ctx context.Context) error {
+	if e.batcher != nil {
+		return e.batcher.Drain(ctx)
+	}
+	return nil
+}
+
 // ShutdownEventService gracefully shuts down an event service if it supports shutdown
 func ShutdownEventService(service domain.EventService) error {
 	if srv, ok := service.(interface{ Shutdown() error }); ok {
@@ -163,3 +992,11 @@ func ShutdownEventService(service domain.EventService) error {
 	}
 	return nil
 }
+
+// DrainEventService flushes the events buffered by an event service if it supports draining
+func DrainEventService(ctx context.Context, service domain.EventService) error {
+	if srv, ok := service.(interface{ Drain(context.Context) error }); ok {
+		return srv.Drain(ctx)
+	}
+	return nil
+}
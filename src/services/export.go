@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+)
+
+// ExportEvents reads the events matching the filters of an export as they are read from ClickHouse, in time order.
+// The range of the filters is bounded like the one of GET /metrics, exports of longer ranges are split by the caller.
+func (e eventService) ExportEvents(ctx context.Context, request *domain.ExportRequest) (domain.EventStream, error) {
+	filter := &request.Filter
+	tenantDB, err := e.tenants.Database(ctx, filter.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.pinMetricRequest(ctx, tenantDB, filter); err != nil {
+		return nil, err
+	}
+	if err := e.applyMetricRange(filter); err != nil {
+		return nil, err
+	}
+
+	rows, err := e.clickhouseDB.QueryEventsFrom(ctx, tenantDB, *filter)
+	if err != nil {
+		return nil, err
+	}
+	return &eventStream{EventRows: rows}, nil
+}
+
+// eventStream converts the rows of an export to events
+type eventStream struct {
+	*database.EventRows
+}
+
+func (s *eventStream) Event() domain.ExportedEvent {
+	event := s.EventRows.Event()
+	return domain.ExportedEvent{
+		EventName:  event.EventName,
+		Channel:    event.Channel,
+		CampaignID: event.CampaignID,
+		UserID:     event.UserID,
+		Timestamp:  event.Timestamp,
+		Tags:       event.Tags,
+		Metadata:   event.Metadata,
+		AbuseScore: event.AbuseScore,
+		Source:     event.Source,
+		IngestedAt: event.IngestedAt,
+	}
+}
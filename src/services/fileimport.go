@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"kucukaslan/clickhouse/buildinfo"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/ingest"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/validations"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// importLog logs the messages of the FileImporter
+var importLog = logging.Named("FileImport")
+
+const (
+	// importLockTTL bounds how long a crashed instance keeps a file from being imported, a chunk lasts at most the flush ack timeout
+	importLockTTL = 5 * time.Minute
+	// importDoneDir is the subdirectory of the import directory the imported files are moved to
+	importDoneDir = "done"
+)
+
+// FileImporter imports the NDJSON files dropped into a directory, a line per event in the format of POST /events.
+// Every file is a stream of the stream ingestion, import:<file name>, with the byte offset after its last flushed line
+// as the checkpoint, so that a restarted or another instance sharing the directory resumes the file after it. A file is
+// read by one instance at a time, holding a Redis lock, and moved to the done subdirectory once all of its lines are flushed.
+type FileImporter struct {
+	sink      ingest.Sink
+	redisRepo database.ClickHouseRedis
+	cfg       *config.FileImportConfig
+	owner     string
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stopOnce  sync.Once
+	done      chan struct{}
+}
+
+// NewFileImporter creates an importer of the configured directory, nil if no directory is configured
+func NewFileImporter(cfg *config.FileImportConfig, redisClient database.ClickHouseRedis) (*FileImporter, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	if redisClient.Client == nil {
+		return nil, fmt.Errorf("Redis client cannot be nil")
+	}
+	if cfg.BatchSize <= 0 {
+		return nil, fmt.Errorf("import batch size must be positive")
+	}
+	if cfg.PollIntervalMS <= 0 {
+		return nil, fmt.Errorf("import poll interval must be positive")
+	}
+	if err := validations.ValidateTenantID(cfg.Tenant); err != nil {
+		return nil, fmt.Errorf("invalid import tenant %q", cfg.Tenant)
+	}
+	if err := os.MkdirAll(filepath.Join(cfg.Dir, importDoneDir), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create import directory: %w", err)
+	}
+	token, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &FileImporter{
+		redisRepo: redisClient,
+		cfg:       cfg,
+		owner:     buildinfo.GetInfo().Hostname + "-" + token,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// Name returns the source of the events of the importer
+func (f *FileImporter) Name() string {
+	return domain.SourceImport
+}
+
+// Start imports the files of the directory in the background, a chunk of each file per scan, scanning again right away
+// while a file has more lines. Failures are logged and retried at the next scan. It is safe to call on a nil importer.
+func (f *FileImporter) Start(sink ingest.Sink) {
+	if f == nil {
+		return
+	}
+	f.sink = sink
+	importLog.Infof("Importing files of %s", f.cfg.Dir)
+	go func() {
+		defer close(f.done)
+		interval := time.Duration(f.cfg.PollIntervalMS) * time.Millisecond
+		for {
+			wait := interval
+			if f.poll(f.ctx) {
+				wait = 0
+			}
+			select {
+			case <-f.ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// Stop stops importing, lines whose events are not flushed yet are read again later. It is safe to call on a nil importer.
+func (f *FileImporter) Stop() {
+	if f == nil {
+		return
+	}
+	f.stopOnce.Do(func() {
+		f.cancel()
+		<-f.done
+		importLog.Infof("Stopped")
+	})
+}
+
+// poll imports a chunk of each file not imported by another instance, returns whether a file has more lines.
+// Only .jsonl and .ndjson files are imported, so files are written under another name and renamed once complete.
+func (f *FileImporter) poll(ctx context.Context) bool {
+	entries, err := os.ReadDir(f.cfg.Dir)
+	if err != nil {
+		importLog.Errorf("Failed to list the files of %s: %v", f.cfg.Dir, err)
+		return false
+	}
+	more := false
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || (!strings.HasSuffix(name, ".jsonl") && !strings.HasSuffix(name, ".ndjson")) {
+			continue
+		}
+		imported, err := f.importFile(ctx, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			importLog.Errorf("Failed to import %s: %v", name, err)
+			continue
+		}
+		more = more || !imported
+	}
+	return more
+}
+
+// importFile delivers the next chunk of lines of a file after its checkpoint and moves the file to the done subdirectory
+// once all of its lines are delivered, returns whether it was moved
+func (f *FileImporter) importFile(ctx context.Context, name string) (bool, error) {
+	lock := database.ImportLockKeyPrefix + name
+	acquired, err := f.redisRepo.AcquireLock(ctx, lock, f.owner, importLockTTL)
+	if err != nil || !acquired {
+		// Imported by another instance
+		return true, err
+	}
+	defer func() {
+		if err := f.redisRepo.ReleaseLock(context.Background(), lock, f.owner); err != nil {
+			importLog.Errorf("Failed to release the lock of %s: %v", name, err)
+		}
+	}()
+
+	streamID := "import:" + name
+	checkpoint, err := f.sink.Checkpoint(ctx, streamID)
+	if err != nil {
+		return false, err
+	}
+	var offset int64
+	if checkpoint != "" {
+		if offset, err = strconv.ParseInt(checkpoint, 10, 64); err != nil {
+			return false, fmt.Errorf("invalid checkpoint %q: %w", checkpoint, err)
+		}
+	}
+
+	path := filepath.Join(f.cfg.Dir, name)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// Moved by another instance since the directory was listed
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	chunk := ingest.Chunk{StreamID: streamID}
+	reader := bufio.NewReader(file)
+	eof := false
+	for lines := 0; lines < f.cfg.BatchSize; {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return false, err
+		}
+		offset += int64(len(line))
+		if data := bytes.TrimSpace(line); len(data) > 0 {
+			lines++
+			event, parseErr := ingest.ParseEvent(data, f.cfg.Tenant, domain.SourceImport)
+			if parseErr != nil {
+				importLog.Warnf("Skipping invalid line ending at byte %d of %s: %v", offset, name, parseErr)
+				chunk.Invalid++
+			} else {
+				chunk.Events = append(chunk.Events, event)
+			}
+		}
+		if err != nil {
+			eof = true
+			break
+		}
+	}
+	chunk.Checkpoint = strconv.FormatInt(offset, 10)
+	if chunk.Checkpoint != checkpoint {
+		if err := f.sink.Deliver(ctx, chunk); err != nil {
+			return false, err
+		}
+	}
+	if !eof {
+		return false, nil
+	}
+
+	// The checkpoint is rewound first, so that a file dropped again under the same name is imported from its start;
+	// a crash before the move imports the file again, and deduplication drops its events
+	if err := f.redisRepo.SetStreamCheckpoint(ctx, streamID, "0"); err != nil {
+		return false, err
+	}
+	if err := os.Rename(path, filepath.Join(f.cfg.Dir, importDoneDir, name)); err != nil {
+		return false, fmt.Errorf("failed to move imported file: %w", err)
+	}
+	importLog.Infof("Imported %s", name)
+	return true, nil
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// flagsLog logs the messages of the FeatureFlags
+var flagsLog = logging.Named("FeatureFlags")
+
+// Feature flags gating risky features
+const (
+	FlagAsyncBulk       = "async_bulk"
+	FlagApproxUnique    = "approx_unique"
+	FlagHybridMetrics   = "hybrid_metrics"
+	FlagPauseAggregates = "pause_aggregates"
+	FlagTableEngines    = "table_engines"
+)
+
+// knownFlags describes the flags that can be set, unknown names are rejected to catch typos
+var knownFlags = map[string]string{
+	FlagAsyncBulk:       "Enqueue /events/bulk events to the batcher instead of inserting them synchronously",
+	FlagApproxUnique:    "Count unique users approximately with uniq instead of uniqExact in /metrics",
+	FlagHybridMetrics:   "Serve the last minutes of /metrics from the realtime aggregation, merged with ClickHouse for older data",
+	FlagPauseAggregates: "Hold the flush aggregates instead of publishing them, to relieve their downstream consumers",
+	FlagTableEngines:    "Apply the schema migrations changing the layout of the events table: its sorting key (4) and its timestamp type (5)",
+}
+
+// ErrUnknownFlag is returned when a flag that is not known is set or reset
+var ErrUnknownFlag = errors.New("unknown feature flag")
+
+var _ domain.FeatureFlagService = &FeatureFlags{}
+
+// FeatureFlags resolves feature flags from environment defaults and runtime overrides kept in the metadata store.
+// Overrides are cached and reloaded in the background, so flag checks never wait for the store.
+type FeatureFlags struct {
+	defaults        map[string]bool
+	metadata        database.MetadataStore
+	refreshInterval time.Duration
+
+	mu          sync.RWMutex
+	overrides   map[string]bool
+	refreshedAt time.Time
+	refreshing  bool
+}
+
+// NewFeatureFlags creates the feature flags and loads the current overrides.
+// EVENT_BULK_MODE=async turns async_bulk on by default, FEATURE_FLAGS takes precedence.
+func NewFeatureFlags(cfg *config.FlagsConfig, clickhouseCfg *config.ClickHouseConfig, metadata database.MetadataStore) (*FeatureFlags, error) {
+	if metadata == nil {
+		return nil, fmt.Errorf("metadata store cannot be nil")
+	}
+
+	defaults := make(map[string]bool, len(knownFlags))
+	defaults[FlagAsyncBulk] = clickhouseCfg.BulkMode == BulkModeAsync
+	for name, value := range cfg.Defaults {
+		if _, ok := knownFlags[name]; !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownFlag, name)
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for feature flag %q", value, name)
+		}
+		defaults[name] = enabled
+	}
+
+	f := &FeatureFlags{
+		defaults:        defaults,
+		metadata:        metadata,
+		refreshInterval: time.Duration(cfg.RefreshIntervalSeconds) * time.Second,
+		overrides:       make(map[string]bool),
+	}
+	f.refresh()
+	return f, nil
+}
+
+// Enabled reports whether a flag is enabled. It is safe to call on nil, in which case all flags are disabled.
+func (f *FeatureFlags) Enabled(name string) bool {
+	if f == nil {
+		return false
+	}
+
+	f.mu.Lock()
+	if !f.refreshing && time.Since(f.refreshedAt) >= f.refreshInterval {
+		f.refreshing = true
+		go f.refresh()
+	}
+	enabled, overridden := f.overrides[name]
+	f.mu.Unlock()
+
+	if overridden {
+		return enabled
+	}
+	return f.defaults[name]
+}
+
+// refresh reloads the overrides from the metadata store, keeping the cached ones if it is unavailable
+func (f *FeatureFlags) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	overrides, err := f.metadata.GetFeatureFlagOverrides(ctx)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refreshing = false
+	f.refreshedAt = time.Now()
+	if err != nil {
+		flagsLog.Warnf("Failed to reload overrides, keeping cached ones: %v", err)
+		return
+	}
+	f.overrides = overrides
+}
+
+// ListFlags returns all known flags with their effective values
+func (f *FeatureFlags) ListFlags(_ context.Context) (*domain.FeatureFlagsResponse, error) {
+	return &domain.FeatureFlagsResponse{
+		Success: true,
+		Message: "Feature flags retrieved successfully",
+		Flags:   f.list(),
+	}, nil
+}
+
+// SetFlag overrides a flag on all instances, other instances pick it up on their next refresh
+func (f *FeatureFlags) SetFlag(ctx context.Context, name string, enabled bool) (*domain.FeatureFlagsResponse, error) {
+	if _, ok := knownFlags[name]; !ok {
+		return &domain.FeatureFlagsResponse{
+			Success: false,
+			Message: fmt.Sprintf("Unknown feature flag %q", name),
+		}, ErrUnknownFlag
+	}
+	if err := f.metadata.SetFeatureFlagOverride(ctx, name, enabled); err != nil {
+		return &domain.FeatureFlagsResponse{
+			Success: false,
+			Message: "Failed to set feature flag: " + err.Error(),
+		}, err
+	}
+
+	f.mu.Lock()
+	f.overrides[name] = enabled
+	f.mu.Unlock()
+	flagsLog.Infof("%s overridden to %t", name, enabled)
+
+	return &domain.FeatureFlagsResponse{
+		Success: true,
+		Message: "Feature flag set successfully",
+		Flags:   f.list(),
+	}, nil
+}
+
+// ResetFlag removes the override of a flag, reverting it to its default
+func (f *FeatureFlags) ResetFlag(ctx context.Context, name string) (*domain.FeatureFlagsResponse, error) {
+	if _, ok := knownFlags[name]; !ok {
+		return &domain.FeatureFlagsResponse{
+			Success: false,
+			Message: fmt.Sprintf("Unknown feature flag %q", name),
+		}, ErrUnknownFlag
+	}
+	if err := f.metadata.DeleteFeatureFlagOverride(ctx, name); err != nil {
+		return &domain.FeatureFlagsResponse{
+			Success: false,
+			Message: "Failed to reset feature flag: " + err.Error(),
+		}, err
+	}
+
+	f.mu.Lock()
+	delete(f.overrides, name)
+	f.mu.Unlock()
+	flagsLog.Infof("%s reset to its default", name)
+
+	return &domain.FeatureFlagsResponse{
+		Success: true,
+		Message: "Feature flag reset successfully",
+		Flags:   f.list(),
+	}, nil
+}
+
+func (f *FeatureFlags) list() []domain.FeatureFlag {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	flags := make([]domain.FeatureFlag, 0, len(knownFlags))
+	for name, description := range knownFlags {
+		flag := domain.FeatureFlag{
+			Name:        name,
+			Description: description,
+			Default:     f.defaults[name],
+		}
+		flag.Enabled, flag.Overridden = f.overrides[name]
+		if !flag.Overridden {
+			flag.Enabled = flag.Default
+		}
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+	"math"
+	"time"
+)
+
+// forecastSmoothing are the candidate smoothing factors of the level, trend and season,
+// the combination with the smallest one-step error on the history is used
+var forecastSmoothing = []float64{0.05, 0.1, 0.2, 0.4, 0.6, 0.8}
+
+// GetForecast fits an additive Holt-Winters model on the event counts of the last complete buckets
+// and forecasts the next ones. Hourly counts have a daily season, daily counts a weekly one.
+func (e eventService) GetForecast(ctx context.Context, request *domain.ForecastRequest) (*domain.ForecastResponse, error) {
+	season := validations.ForecastSeasons[request.GroupBy]
+	history := request.History
+	if history == 0 {
+		history = 8 * season
+	}
+	horizon := request.Horizon
+	if horizon == 0 {
+		horizon = season
+	}
+
+	// The current bucket is incomplete and left out of the history
+	step := time.Hour
+	end := time.Now().UTC().Truncate(time.Hour)
+	if request.GroupBy == "day" {
+		step = 24 * time.Hour
+		end = startOfDay(time.Now().UTC())
+	}
+	start := end.Add(-time.Duration(history) * step)
+
+	counts, err := e.bucketCounts(ctx, request, start, end, step)
+	if err != nil {
+		return &domain.ForecastResponse{
+			Success: false,
+			Message: "Failed to retrieve event counts: " + err.Error(),
+		}, err
+	}
+
+	model := fitHoltWinters(counts, season)
+	// 95% prediction interval assuming normally distributed one-step errors
+	margin := 1.96 * model.rmse
+	forecast := make([]domain.ForecastPoint, horizon)
+	for h := range forecast {
+		value := max(model.forecast(h+1), 0)
+		forecast[h] = domain.ForecastPoint{
+			Bucket:      end.Add(time.Duration(h) * step).Format(time.DateTime),
+			TotalEvents: value,
+			Lower:       max(value-margin, 0),
+			Upper:       value + margin,
+		}
+	}
+
+	return &domain.ForecastResponse{
+		Success:  true,
+		Message:  "Forecast computed successfully",
+		GroupBy:  request.GroupBy,
+		Season:   season,
+		RMSE:     model.rmse,
+		Forecast: forecast,
+	}, nil
+}
+
+// bucketCounts returns the event counts of the buckets from start to end, zero for buckets without events
+func (e eventService) bucketCounts(ctx context.Context, request *domain.ForecastRequest, start, end time.Time, step time.Duration) ([]float64, error) {
+	tenantDB, err := e.tenants.Database(ctx, request.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	from, to := start.Unix(), end.Unix()-1
+	groupBy := request.GroupBy
+	metrics, err := e.clickhouseDB.GetMetricsFrom(ctx, tenantDB, domain.MetricRequest{
+		Tenant:       request.Tenant,
+		EventName:    request.EventName,
+		Tag:          request.Tag,
+		From:         &from,
+		To:           &to,
+		GroupBy:      &groupBy,
+		ApproxUnique: true, // unique users are not used, keep them cheap
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]float64, int(end.Sub(start)/step))
+	for _, m := range metrics {
+		// Buckets are formatted by ClickHouse in the server's time zone, assumed to be UTC
+		bucket, err := time.Parse(time.DateTime, m.Bucket)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected bucket %q: %w", m.Bucket, err)
+		}
+		if i := int(bucket.Sub(start) / step); i >= 0 && i < len(counts) {
+			counts[i] = float64(m.TotalEvents)
+		}
+	}
+	return counts, nil
+}
+
+// holtWinters is an additive Holt-Winters model fitted on a series
+type holtWinters struct {
+	level, trend float64
+	seasonal     []float64 // the last season's components, oldest first
+	rmse         float64   // root mean squared error of the one-step predictions
+}
+
+// forecast returns the value predicted h steps after the end of the series
+func (m holtWinters) forecast(h int) float64 {
+	return m.level + float64(h)*m.trend + m.seasonal[(h-1)%len(m.seasonal)]
+}
+
+// fitHoltWinters fits the model with the smoothing factors minimizing the one-step error.
+// The series must hold at least two seasons.
+func fitHoltWinters(series []float64, season int) holtWinters {
+	var best holtWinters
+	bestSSE := math.Inf(1)
+	for _, alpha := range forecastSmoothing {
+		for _, beta := range forecastSmoothing {
+			for _, gamma := range forecastSmoothing {
+				model, sse := runHoltWinters(series, season, alpha, beta, gamma)
+				if sse < bestSSE {
+					best, bestSSE = model, sse
+				}
+			}
+		}
+	}
+	return best
+}
+
+// runHoltWinters smooths the series with the given factors, returning the final model and the sum of squared one-step errors
+func runHoltWinters(series []float64, season int, alpha, beta, gamma float64) (holtWinters, float64) {
+	// Initial level and trend from the means of the first two seasons, seasonal components from the first season
+	var first, second float64
+	for i := range season {
+		first += series[i]
+		second += series[season+i]
+	}
+	first /= float64(season)
+	second /= float64(season)
+	level := first
+	trend := (second - first) / float64(season)
+	seasonal := make([]float64, len(series))
+	for i := range season {
+		seasonal[i] = series[i] - first
+	}
+
+	var sse float64
+	for t := season; t < len(series); t++ {
+		predicted := level + trend + seasonal[t-season]
+		sse += (series[t] - predicted) * (series[t] - predicted)
+
+		previous := level
+		level = alpha*(series[t]-seasonal[t-season]) + (1-alpha)*(level+trend)
+		trend = beta*(level-previous) + (1-beta)*trend
+		seasonal[t] = gamma*(series[t]-level) + (1-gamma)*seasonal[t-season]
+	}
+
+	return holtWinters{
+		level:    level,
+		trend:    trend,
+		seasonal: seasonal[len(series)-season:],
+		rmse:     math.Sqrt(sse / float64(len(series)-season)),
+	}, sse
+}
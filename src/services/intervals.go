@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"time"
+)
+
+// defaultIntervalRange is the time range of interval queries without a from
+const defaultIntervalRange = 7 * 24 * time.Hour
+
+// GetIntervals returns the distribution of the time between consecutive events of the same user,
+// overall or where an event directly follows another
+func (e eventService) GetIntervals(ctx context.Context, request *domain.IntervalRequest) (*domain.IntervalResponse, error) {
+	tenantDB, err := e.tenants.Database(ctx, request.Tenant)
+	if err != nil {
+		return &domain.IntervalResponse{
+			Success: false,
+			Message: "Failed to retrieve intervals: " + err.Error(),
+		}, err
+	}
+
+	filter := database.IntervalFilter{To: time.Now(), Tenant: request.Tenant}
+	if request.To != nil {
+		// to is inclusive, as in /metrics
+		filter.To = time.Unix(*request.To+1, 0)
+	}
+	filter.From = filter.To.Add(-defaultIntervalRange)
+	if request.From != nil {
+		filter.From = time.Unix(*request.From, 0)
+	}
+	if request.FromEvent != nil {
+		filter.FromEvent = *request.FromEvent
+		filter.ToEvent = *request.ToEvent
+	}
+
+	histogram, err := e.clickhouseDB.GetIntervalHistogramFrom(ctx, tenantDB, filter)
+	if err != nil {
+		return &domain.IntervalResponse{
+			Success: false,
+			Message: "Failed to retrieve intervals: " + err.Error(),
+		}, err
+	}
+
+	resp := &domain.IntervalResponse{
+		Success:   true,
+		Message:   "Intervals retrieved successfully",
+		Total:     histogram.Total,
+		Histogram: make([]domain.IntervalBucket, len(histogram.Counts)),
+	}
+	// Quantiles of no intervals are NaN
+	if histogram.Total > 0 && len(histogram.Quantiles) == 3 {
+		resp.P50, resp.P90, resp.P99 = histogram.Quantiles[0], histogram.Quantiles[1], histogram.Quantiles[2]
+	}
+	var lower float64
+	for i, count := range histogram.Counts {
+		bucket := domain.IntervalBucket{MinSeconds: lower, Count: count}
+		if i < len(database.IntervalBounds) {
+			bucket.MaxSeconds = database.IntervalBounds[i]
+			bucket.Bucket = formatSeconds(lower) + "-" + formatSeconds(bucket.MaxSeconds)
+			lower = bucket.MaxSeconds
+		} else {
+			bucket.Bucket = ">=" + formatSeconds(lower)
+		}
+		resp.Histogram[i] = bucket
+	}
+	return resp, nil
+}
+
+// formatSeconds formats a whole number of seconds with the largest unit dividing it, e.g. 600 as 10m
+func formatSeconds(seconds float64) string {
+	s := int64(seconds)
+	switch {
+	case s == 0:
+		return "0s"
+	case s%86400 == 0:
+		return fmt.Sprintf("%dd", s/86400)
+	case s%3600 == 0:
+		return fmt.Sprintf("%dh", s/3600)
+	case s%60 == 0:
+		return fmt.Sprintf("%dm", s/60)
+	default:
+		return fmt.Sprintf("%ds", s)
+	}
+}
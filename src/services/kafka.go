@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/flush"
+	"kucukaslan/clickhouse/ingest"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/validations"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaLog logs the messages of the KafkaConsumer
+var kafkaLog = logging.Named("Kafka")
+
+const (
+	// kafkaBatchSize is the most messages sent to a partition in one request
+	kafkaBatchSize = 1000
+	// kafkaBatchTimeout is how long the last messages of a write wait for more before they are sent
+	kafkaBatchTimeout = 10 * time.Millisecond
+	// kafkaFetchWait is how long the messages fetched after the first one of a chunk wait for more
+	kafkaFetchWait = 500 * time.Millisecond
+	// kafkaRetryInterval is the wait after a failed fetch or delivery
+	kafkaRetryInterval = time.Second
+)
+
+var _ flush.Sink = &KafkaSink{}
+
+// KafkaSink publishes the stored events to a Kafka topic as an outbox for downstream consumers, a message per event
+// with the event id, batch, tenant and source of the event, keyed by user id so that the events of a user share a
+// partition. Writes are acknowledged by all in-sync replicas; a retried batch is published again with the same
+// event ids, which consumers use to drop the duplicates.
+type KafkaSink struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaSink creates a sink publishing to the configured topic, nil if no brokers are configured
+func NewKafkaSink(cfg *config.SinksConfig) (*KafkaSink, error) {
+	if cfg.KafkaBrokers == "" {
+		return nil, nil
+	}
+	if cfg.KafkaTopic == "" {
+		return nil, fmt.Errorf("SINK_KAFKA_TOPIC is required with SINK_KAFKA_BROKERS")
+	}
+	return &KafkaSink{writer: &kafka.Writer{
+		Addr:         kafka.TCP(kafkaBrokers(cfg.KafkaBrokers)...),
+		Topic:        cfg.KafkaTopic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Compression:  kafka.Zstd,
+		BatchSize:    kafkaBatchSize,
+		BatchTimeout: kafkaBatchTimeout,
+		// The sinks retry failed batches with their own backoff
+		MaxAttempts: 1,
+	}}, nil
+}
+
+// kafkaBrokers splits a comma separated list of brokers
+func kafkaBrokers(list string) []string {
+	var brokers []string
+	for _, broker := range strings.Split(list, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers
+}
+
+// Name returns the sink the events are written to
+func (k *KafkaSink) Name() string {
+	return domain.SinkKafka
+}
+
+// Write publishes the events of the batch, returning once all of them are acknowledged
+func (k *KafkaSink) Write(ctx context.Context, batch flush.Batch) error {
+	messages := make([]kafka.Message, 0, len(batch.Events))
+	for _, event := range batch.Events {
+		value, err := json.Marshal(newSinkRecord(batch, event))
+		if err != nil {
+			return err
+		}
+		messages = append(messages, kafka.Message{Key: []byte(event.UserID), Value: value})
+	}
+	return k.writer.WriteMessages(ctx, messages...)
+}
+
+// Close flushes and closes the connections to the brokers
+func (k *KafkaSink) Close() error {
+	return k.writer.Close()
+}
+
+// KafkaConsumer ingests the messages of a Kafka topic, each an event in the format of POST /events. Instances share the
+// partitions of the topic through a consumer group; the messages of each partition are delivered in chunks, whose offsets
+// are committed once their events are flushed, so that the group itself keeps the progress. After a failed delivery the
+// reader is opened again and resumes from the committed offsets, the messages delivered again are dropped by deduplication.
+type KafkaConsumer struct {
+	cfg      *config.KafkaIngestConfig
+	brokers  []string
+	reader   *kafka.Reader
+	sink     ingest.Sink
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewKafkaConsumer creates a consumer of the configured topic, nil if no brokers are configured
+func NewKafkaConsumer(cfg *config.KafkaIngestConfig, sinks *config.SinksConfig) (*KafkaConsumer, error) {
+	if cfg.Brokers == "" {
+		return nil, nil
+	}
+	if cfg.Topic == "" || cfg.GroupID == "" {
+		return nil, fmt.Errorf("KAFKA_TOPIC and KAFKA_GROUP_ID are required with KAFKA_BROKERS")
+	}
+	if cfg.Topic == sinks.KafkaTopic && cfg.Brokers == sinks.KafkaBrokers {
+		return nil, fmt.Errorf("KAFKA_TOPIC %q is the outbox of the Kafka sink, its events would be ingested again", cfg.Topic)
+	}
+	if cfg.BatchSize <= 0 {
+		return nil, fmt.Errorf("Kafka batch size must be positive")
+	}
+	if err := validations.ValidateTenantID(cfg.Tenant); err != nil {
+		return nil, fmt.Errorf("invalid Kafka tenant %q", cfg.Tenant)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &KafkaConsumer{
+		cfg:     cfg,
+		brokers: kafkaBrokers(cfg.Brokers),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Name returns the source of the events of the consumer
+func (k *KafkaConsumer) Name() string {
+	return domain.SourceKafka
+}
+
+// open joins the consumer group, which assigns partitions to the reader and resumes them from their committed offsets
+func (k *KafkaConsumer) open() {
+	k.reader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     k.brokers,
+		Topic:       k.cfg.Topic,
+		GroupID:     k.cfg.GroupID,
+		StartOffset: kafka.FirstOffset,
+		// Offsets are committed synchronously, once the events of their chunk are flushed
+		CommitInterval: 0,
+	})
+}
+
+// Start consumes the topic in the background and delivers its messages to the sink, failures are logged and retried.
+// It is safe to call on a nil consumer.
+func (k *KafkaConsumer) Start(sink ingest.Sink) {
+	if k == nil {
+		return
+	}
+	k.sink = sink
+	k.open()
+	kafkaLog.Infof("Consuming topic %s as group %s", k.cfg.Topic, k.cfg.GroupID)
+	go func() {
+		defer close(k.done)
+		for k.ctx.Err() == nil {
+			if err := k.consume(k.ctx); err != nil && k.ctx.Err() == nil {
+				kafkaLog.Errorf("Failed to consume %s: %v", k.cfg.Topic, err)
+				// Messages fetched after the committed offsets are only fetched again by a new reader
+				if err := k.reader.Close(); err != nil {
+					kafkaLog.Warnf("Failed to close reader of %s: %v", k.cfg.Topic, err)
+				}
+				select {
+				case <-k.ctx.Done():
+				case <-time.After(kafkaRetryInterval):
+				}
+				k.open()
+			}
+		}
+		if err := k.reader.Close(); err != nil {
+			kafkaLog.Warnf("Failed to close reader of %s: %v", k.cfg.Topic, err)
+		}
+	}()
+}
+
+// Stop stops consuming, messages whose events are not flushed yet are consumed again. It is safe to call on a nil consumer.
+func (k *KafkaConsumer) Stop() {
+	if k == nil {
+		return
+	}
+	k.stopOnce.Do(func() {
+		k.cancel()
+		<-k.done
+		kafkaLog.Infof("Stopped")
+	})
+}
+
+// consume fetches the next messages, delivers them as a chunk per partition and commits the offsets of the delivered ones
+func (k *KafkaConsumer) consume(ctx context.Context) error {
+	messages, err := k.fetch(ctx)
+	if err != nil || len(messages) == 0 {
+		return err
+	}
+
+	partitions := make(map[int][]kafka.Message)
+	for _, message := range messages {
+		partitions[message.Partition] = append(partitions[message.Partition], message)
+	}
+	ids := make([]int, 0, len(partitions))
+	for partition := range partitions {
+		ids = append(ids, partition)
+	}
+	slices.Sort(ids)
+	for _, partition := range ids {
+		messages := partitions[partition]
+		last := messages[len(messages)-1]
+		chunk := ingest.Chunk{
+			StreamID:   "kafka:" + k.cfg.Topic + ":" + strconv.Itoa(partition),
+			Checkpoint: strconv.FormatInt(last.Offset, 10),
+		}
+		for _, message := range messages {
+			event, err := ingest.ParseEvent(message.Value, k.cfg.Tenant, domain.SourceKafka)
+			if err != nil {
+				kafkaLog.Warnf("Skipping invalid message %d of partition %d: %v", message.Offset, partition, err)
+				chunk.Invalid++
+				continue
+			}
+			chunk.Events = append(chunk.Events, event)
+		}
+		if err := k.sink.Deliver(ctx, chunk); err != nil {
+			return err
+		}
+		if err := k.reader.CommitMessages(ctx, last); err != nil {
+			return fmt.Errorf("failed to commit flushed messages: %w", err)
+		}
+	}
+	return nil
+}
+
+// fetch waits for a message, then fetches the ones available within kafkaFetchWait, up to the batch size of each partition
+func (k *KafkaConsumer) fetch(ctx context.Context) ([]kafka.Message, error) {
+	message, err := k.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	messages := []kafka.Message{message}
+	counts := map[int]int{message.Partition: 1}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, kafkaFetchWait)
+	defer cancel()
+	for counts[message.Partition] < k.cfg.BatchSize {
+		if message, err = k.reader.FetchMessage(fetchCtx); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return nil, err
+		}
+		messages = append(messages, message)
+		counts[message.Partition]++
+	}
+	return messages, nil
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"sync"
+	"time"
+)
+
+// metricJobLog logs the messages of the MetricJobs
+var metricJobLog = logging.Named("MetricJobs")
+
+var (
+	// ErrMetricJobNotFound is returned for unknown, expired or other tenants' metric jobs
+	ErrMetricJobNotFound = errors.New("metric job not found")
+	// ErrTooManyMetricJobs is returned when a metric job is submitted while maxMetricJobs are kept
+	ErrTooManyMetricJobs = errors.New("too many metric jobs")
+)
+
+const (
+	// maxConcurrentMetricJobs bounds the metric jobs running at once, the others wait queued
+	maxConcurrentMetricJobs = 2
+	// maxMetricJobs bounds the queued, running and finished metric jobs kept in memory
+	maxMetricJobs = 1000
+	// metricJobRetention is how long finished metric jobs and their results are kept
+	metricJobRetention = time.Hour
+	// metricJobTimeout cancels metric jobs running longer than it, including the time queued
+	metricJobTimeout = 30 * time.Minute
+)
+
+var _ domain.MetricJobService = &metricJobService{}
+
+type metricJob struct {
+	id       string
+	tenant   string
+	request  domain.MetricRequest
+	cancel   context.CancelFunc
+	status   string
+	err      string
+	created  time.Time
+	started  time.Time
+	finished time.Time
+	result   *domain.MetricResponse
+}
+
+// metricJobService runs metric queries in the background, so that expensive queries are not bound by HTTP timeouts.
+// Jobs are kept in memory: they are only known to the instance they were submitted to and lost on restart.
+type metricJobService struct {
+	eventService domain.EventService
+	running      chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*metricJob
+}
+
+// SubmitMetricJob queues a metric query and returns the id its state and result are polled with
+func (m *metricJobService) SubmitMetricJob(ctx context.Context, metricRequest *domain.MetricRequest) (*domain.MetricJobResponse, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return &domain.MetricJobResponse{
+			Success: false,
+			Message: "Failed to create metric job: " + err.Error(),
+		}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune()
+	if len(m.jobs) >= maxMetricJobs {
+		return &domain.MetricJobResponse{
+			Success: false,
+			Message: fmt.Sprintf("Too many metric jobs, at most %d are kept for %s", maxMetricJobs, metricJobRetention),
+		}, ErrTooManyMetricJobs
+	}
+
+	jobCtx, cancel := context.WithTimeout(context.Background(), metricJobTimeout)
+	job := &metricJob{
+		id:      id,
+		tenant:  metricRequest.Tenant,
+		request: *metricRequest,
+		cancel:  cancel,
+		status:  domain.MetricJobQueued,
+		created: time.Now(),
+	}
+	m.jobs[id] = job
+	go m.run(jobCtx, job)
+	return job.response("Metric job submitted"), nil
+}
+
+// GetMetricJob returns the state of a metric job of the tenant, with its result once it succeeded
+func (m *metricJobService) GetMetricJob(ctx context.Context, tenant, id string) (*domain.MetricJobResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok || job.tenant != tenant {
+		return &domain.MetricJobResponse{
+			Success: false,
+			Message: "Metric job not found",
+		}, ErrMetricJobNotFound
+	}
+	return job.response("Metric job retrieved successfully"), nil
+}
+
+// CancelMetricJob cancels a queued or running metric job of the tenant, a finished job is left as is
+func (m *metricJobService) CancelMetricJob(ctx context.Context, tenant, id string) (*domain.MetricJobResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok || job.tenant != tenant {
+		return &domain.MetricJobResponse{
+			Success: false,
+			Message: "Metric job not found",
+		}, ErrMetricJobNotFound
+	}
+	if job.status != domain.MetricJobQueued && job.status != domain.MetricJobRunning {
+		return job.response("Metric job already finished"), nil
+	}
+	// Canceling the context aborts the ClickHouse query
+	job.status = domain.MetricJobCanceled
+	job.finished = time.Now()
+	job.cancel()
+	return job.response("Metric job canceled"), nil
+}
+
+// run waits for a free slot and runs the query of a job, unless it is canceled or times out first
+func (m *metricJobService) run(ctx context.Context, job *metricJob) {
+	defer job.cancel()
+	select {
+	case m.running <- struct{}{}:
+		defer func() { <-m.running }()
+	case <-ctx.Done():
+		m.finish(ctx, job, nil, ctx.Err())
+		return
+	}
+
+	m.mu.Lock()
+	if job.status != domain.MetricJobQueued {
+		m.mu.Unlock()
+		return
+	}
+	job.status = domain.MetricJobRunning
+	job.started = time.Now()
+	m.mu.Unlock()
+
+	resp, err := m.eventService.GetMetrics(ctx, &job.request)
+	m.finish(ctx, job, resp, err)
+}
+
+// finish records the outcome of a job, a job canceled meanwhile stays canceled
+func (m *metricJobService) finish(ctx context.Context, job *metricJob, resp *domain.MetricResponse, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job.status == domain.MetricJobCanceled {
+		return
+	}
+	job.finished = time.Now()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		job.status = domain.MetricJobFailed
+		job.err = fmt.Sprintf("timed out after %s", metricJobTimeout)
+	case err != nil:
+		job.status = domain.MetricJobFailed
+		job.err = err.Error()
+	default:
+		job.status = domain.MetricJobSucceeded
+		job.result = resp
+	}
+	if job.status == domain.MetricJobFailed {
+		metricJobLog.Errorf("Job %s failed: %s", job.id, job.err)
+	}
+}
+
+// prune forgets the jobs finished longer than metricJobRetention ago, the lock must be held
+func (m *metricJobService) prune() {
+	expired := time.Now().Add(-metricJobRetention)
+	for id, job := range m.jobs {
+		if !job.finished.IsZero() && job.finished.Before(expired) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// response returns the state of a job with the given message, the lock must be held
+func (j *metricJob) response(message string) *domain.MetricJobResponse {
+	resp := &domain.MetricJobResponse{
+		Success:   true,
+		Message:   message,
+		JobID:     j.id,
+		Status:    j.status,
+		Error:     j.err,
+		CreatedAt: j.created.Unix(),
+		Result:    j.result,
+	}
+	if !j.started.IsZero() {
+		resp.StartedAt = j.started.Unix()
+	}
+	if !j.finished.IsZero() {
+		resp.FinishedAt = j.finished.Unix()
+	}
+	return resp
+}
+
+// NewMetricJobService returns a domain.MetricJobService running the metric queries of the event service in the background.
+func NewMetricJobService(eventService domain.EventService) (domain.MetricJobService, error) {
+	if eventService == nil {
+		return nil, fmt.Errorf("event service cannot be nil")
+	}
+	return &metricJobService{
+		eventService: eventService,
+		running:      make(chan struct{}, maxConcurrentMetricJobs),
+		jobs:         make(map[string]*metricJob),
+	}, nil
+}
@@ -0,0 +1,402 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/buildinfo"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// schemaLog logs the messages of the SchemaCoordinator
+var schemaLog = logging.Named("SchemaCoordinator")
+
+// ErrMigrationInProgress is returned when another instance is applying the schema migrations
+var ErrMigrationInProgress = errors.New("schema migrations are being applied by another instance")
+
+const (
+	// schemaLockTTL bounds how long a crashed instance blocks the migrations, the lock is extended while they run
+	schemaLockTTL = 5 * time.Minute
+	// schemaWaitTimeout is how long a starting instance waits for another one to apply the migrations
+	schemaWaitTimeout = 10 * time.Minute
+	// schemaPollInterval is how often the schema version is read again while waiting, and how late inserts see a rewrite
+	schemaPollInterval = 2 * time.Second
+	// rewriteGracePeriod is how long a rewrite waits once inserts are paused, for the inserts already sent to complete
+	rewriteGracePeriod = 30 * time.Second
+)
+
+var _ domain.SchemaService = &SchemaCoordinator{}
+
+// SchemaCoordinator applies the schema migrations of the events table, one instance at a time.
+// The instance holding the Redis lock adds the columns while the others wait for the schema version to catch up,
+// so a rolling update only inserts the new columns once they exist. Adding columns keeps instances of the previous
+// version working, they leave the new columns at their defaults.
+// Migrations rewriting the table are never applied on startup: POST /admin/migrations pauses the inserts of every
+// instance while they run, the events wait in the batchers until the rewrite is done.
+type SchemaCoordinator struct {
+	clickhouseDB database.ClickHouseDB
+	redisRepo    database.ClickHouseRedis
+	cfg          *config.ClickHouseConfig
+	flags        *FeatureFlags
+	owner        string
+	version      atomic.Int64 // highest applied migration, as last read
+
+	mu         sync.Mutex
+	paused     bool      // whether a rewrite pauses the inserts, as last read
+	pausedRead time.Time // when paused was read
+}
+
+// NewSchemaCoordinator creates a coordinator of the migrations of the connection's database.
+// Migrations changing the layout of the events table are only applied while the table_engines flag is enabled.
+func NewSchemaCoordinator(db database.ClickHouseDB, redisClient database.ClickHouseRedis, cfg *config.ClickHouseConfig, flags *FeatureFlags) (*SchemaCoordinator, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("ClickHouse database connection cannot be nil")
+	}
+	if redisClient.Client == nil {
+		return nil, fmt.Errorf("Redis client cannot be nil")
+	}
+	if cfg.SchemaMigrations != domain.SchemaMigrationsAuto && cfg.SchemaMigrations != domain.SchemaMigrationsManual {
+		return nil, fmt.Errorf("unknown schema migrations mode %q", cfg.SchemaMigrations)
+	}
+	if cfg.Cluster != "" && !database.DatabaseNamePattern.MatchString(cfg.Cluster) {
+		return nil, fmt.Errorf("invalid cluster name %q", cfg.Cluster)
+	}
+	token, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	return &SchemaCoordinator{
+		clickhouseDB: db,
+		redisRepo:    redisClient,
+		cfg:          cfg,
+		flags:        flags,
+		owner:        buildinfo.GetInfo().Hostname + "-" + token,
+	}, nil
+}
+
+// Start reads the schema version and, in auto mode, applies the pending migrations up to the first one rewriting the events table,
+// or waits for the instance applying them.
+// In manual mode pending migrations are only logged, /health reports them until they are applied through the admin API.
+func (s *SchemaCoordinator) Start(ctx context.Context) error {
+	version, err := s.refresh(ctx)
+	if err != nil {
+		return err
+	}
+	latest := database.LatestSchemaVersion()
+	if version >= latest {
+		return nil
+	}
+	if s.cfg.SchemaMigrations == domain.SchemaMigrationsManual {
+		schemaLog.Warnf("Schema version %d is behind %d, apply the migrations with POST /admin/migrations", version, latest)
+		return nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, schemaWaitTimeout)
+	defer cancel()
+	for {
+		_, err := s.migrate(waitCtx, false)
+		if errors.Is(err, database.ErrRewritePending) {
+			schemaLog.Warnf("Schema version %d is behind %d, %v: apply it with POST /admin/migrations, which pauses the inserts while it runs", s.version.Load(), latest, err)
+			return nil
+		}
+		if errors.Is(err, database.ErrTableEnginesDisabled) {
+			schemaLog.Warnf("Schema version %d is behind %d, %v: enable the %s flag and apply it with POST /admin/migrations", s.version.Load(), latest, err, FlagTableEngines)
+			return nil
+		}
+		if !errors.Is(err, ErrMigrationInProgress) {
+			return err
+		}
+		schemaLog.Warnf("Waiting for another instance to apply the schema migrations")
+		select {
+		case <-waitCtx.Done():
+			return fmt.Errorf("timed out waiting for schema migrations: %w", waitCtx.Err())
+		case <-time.After(schemaPollInterval):
+		}
+		// A rewrite applied meanwhile may take long, the instance only waits for the columns it inserts
+		if _, _, pending := s.Pending(waitCtx); !pending {
+			return nil
+		}
+	}
+}
+
+// Pending returns the applied and the latest schema version if migrations adding columns are pending.
+// The applied version is read again while behind, so that migrations applied by another instance are noticed.
+// Pending rewrites are not reported, the table keeps working until an operator applies them.
+func (s *SchemaCoordinator) Pending(ctx context.Context) (version, latest int, pending bool) {
+	latest = database.LatestSchemaVersion()
+	version = int(s.version.Load())
+	if version < latest {
+		if refreshed, err := s.refresh(ctx); err == nil {
+			version = refreshed
+		}
+	}
+	pending = slices.ContainsFunc(database.SchemaMigrations, func(migration database.SchemaMigration) bool {
+		return migration.Version > version && migration.Rewrite == nil
+	})
+	return version, latest, pending
+}
+
+// GetMigrations lists the migrations of the events table and whether they were applied
+func (s *SchemaCoordinator) GetMigrations(ctx context.Context) (*domain.SchemaMigrationsResponse, error) {
+	return s.migrationsResponse(ctx, "Schema migrations retrieved successfully")
+}
+
+// ApplyMigrations applies the pending migrations, unless another instance is applying them.
+// Inserts of every instance are paused while a migration rewrites the events table of the connection's or a tenant database.
+func (s *SchemaCoordinator) ApplyMigrations(ctx context.Context) (*domain.SchemaMigrationsResponse, error) {
+	migrated, err := s.migrate(ctx, true)
+	if err != nil {
+		resp, _ := s.migrationsResponse(ctx, "Failed to apply schema migrations: "+err.Error())
+		resp.Success = false
+		return resp, err
+	}
+	return s.migrationsResponse(ctx, fmt.Sprintf("Applied %d schema migration(s)", len(migrated)))
+}
+
+// migrate applies the pending migrations while holding the schema lock, extended until they are applied.
+// With rewrite set, migrations rewriting the events table are applied with the inserts paused, those of the tenant databases too.
+func (s *SchemaCoordinator) migrate(ctx context.Context, rewrite bool) ([]database.SchemaMigration, error) {
+	acquired, err := s.redisRepo.AcquireLock(ctx, database.SchemaLockKey, s.owner, schemaLockTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire schema lock: %w", err)
+	}
+	if !acquired {
+		return nil, ErrMigrationInProgress
+	}
+	ctx, stop := s.keepLock(ctx, database.SchemaLockKey)
+	defer func() {
+		stop()
+		if err := s.redisRepo.ReleaseLock(context.Background(), database.SchemaLockKey, s.owner); err != nil {
+			schemaLog.Errorf("Failed to release schema lock: %v", err)
+		}
+	}()
+
+	migrated, err := s.clickhouseDB.MigrateSchema(ctx, "", s.cfg.Cluster, buildinfo.GetInfo().Hostname, s.options(false))
+	if rewrite && (err == nil || errors.Is(err, database.ErrRewritePending)) {
+		var rewritten []database.SchemaMigration
+		rewritten, err = s.rewrite(ctx)
+		migrated = append(migrated, rewritten...)
+	}
+	for _, migration := range migrated {
+		schemaLog.Infof("Applied migration %d (%s)", migration.Version, migration.Name)
+	}
+	if _, refreshErr := s.refresh(ctx); refreshErr != nil {
+		schemaLog.Errorf("Failed to read schema version: %v", refreshErr)
+	}
+	return migrated, err
+}
+
+// options selects the migrations changing the layout of the events table by the table_engines flag
+func (s *SchemaCoordinator) options(rewrite bool) database.MigrationOptions {
+	return database.MigrationOptions{TableEngines: s.flags.Enabled(FlagTableEngines), Rewrite: rewrite}
+}
+
+// rewrite applies the migrations rewriting the events tables of the connection's and the tenant databases.
+// If one is pending, the inserts of every instance are paused first and resumed once all of them are applied.
+func (s *SchemaCoordinator) rewrite(ctx context.Context) ([]database.SchemaMigration, error) {
+	tenants, err := s.clickhouseDB.GetTenantDatabases(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenant databases: %w", err)
+	}
+	databases := []string{""}
+	for _, name := range tenants {
+		if database.DatabaseNamePattern.MatchString(name) {
+			databases = append(databases, name)
+		}
+	}
+
+	var pending []string
+	for _, name := range databases {
+		if _, err := s.clickhouseDB.MigrateSchema(ctx, name, s.cfg.Cluster, buildinfo.GetInfo().Hostname, s.options(false)); errors.Is(err, database.ErrRewritePending) {
+			pending = append(pending, name)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	resume, err := s.pauseInserts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer resume()
+	ctx, stop := s.keepLock(ctx, database.SchemaRewriteKey)
+	defer stop()
+	schemaLog.Warnf("Inserts are paused, rewriting the events tables in %d database(s)", len(pending))
+
+	var migrated []database.SchemaMigration
+	for _, name := range pending {
+		applied, err := s.clickhouseDB.MigrateSchema(ctx, name, s.cfg.Cluster, buildinfo.GetInfo().Hostname, s.options(true))
+		if name == "" {
+			migrated = append(migrated, applied...)
+		}
+		if err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// pauseInserts makes the inserts of every instance wait until the returned function is called.
+// Instances see the pause within schemaPollInterval, the inserts already sent are given rewriteGracePeriod to complete.
+func (s *SchemaCoordinator) pauseInserts(ctx context.Context) (func(), error) {
+	acquired, err := s.redisRepo.AcquireLock(ctx, database.SchemaRewriteKey, s.owner, schemaLockTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pause inserts: %w", err)
+	}
+	if !acquired {
+		return nil, fmt.Errorf("failed to pause inserts: %s is held by another instance", database.SchemaRewriteKey)
+	}
+	resume := func() {
+		if err := s.redisRepo.ReleaseLock(context.Background(), database.SchemaRewriteKey, s.owner); err != nil {
+			schemaLog.Errorf("Failed to resume inserts, they resume once %s expires: %v", database.SchemaRewriteKey, err)
+			return
+		}
+		s.mu.Lock()
+		s.paused, s.pausedRead = false, time.Time{}
+		s.mu.Unlock()
+		schemaLog.Infof("Inserts resumed")
+	}
+	s.mu.Lock()
+	s.paused, s.pausedRead = true, time.Now()
+	s.mu.Unlock()
+
+	schemaLog.Warnf("Pausing inserts, waiting %v for the inserts already sent", rewriteGracePeriod)
+	select {
+	case <-ctx.Done():
+		resume()
+		return nil, ctx.Err()
+	case <-time.After(rewriteGracePeriod):
+	}
+	return resume, nil
+}
+
+// keepLock extends a lock held by the coordinator until the returned function is called.
+// The returned context is cancelled if the lock is lost, so that migrations stop before another instance takes over.
+func (s *SchemaCoordinator) keepLock(ctx context.Context, key string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(schemaLockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			held, err := s.redisRepo.ExtendLock(ctx, key, s.owner, schemaLockTTL)
+			if err != nil {
+				// Retried on the next tick, the lock outlives a few failed extensions
+				schemaLog.Errorf("Failed to extend %s: %v", key, err)
+				continue
+			}
+			if !held {
+				schemaLog.Errorf("Lost %s, stopping the migrations", key)
+				cancel(fmt.Errorf("lost %s", key))
+				return
+			}
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+// WaitRewrite blocks inserts while a migration rewrites the events table, on any instance.
+// It returns the time spent waiting, or an error if the context is done before that. It is safe to call on a nil coordinator.
+func (s *SchemaCoordinator) WaitRewrite(ctx context.Context) (time.Duration, error) {
+	if s == nil {
+		return 0, nil
+	}
+	var waited time.Duration
+	for s.insertsPaused(ctx) {
+		start := time.Now()
+		select {
+		case <-ctx.Done():
+			return waited + time.Since(start), ctx.Err()
+		case <-time.After(schemaPollInterval):
+		}
+		waited += time.Since(start)
+	}
+	return waited, nil
+}
+
+// insertsPaused reads whether a rewrite pauses the inserts, at most once per schemaPollInterval.
+// If Redis cannot be read the last state is kept.
+func (s *SchemaCoordinator) insertsPaused(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.pausedRead) < schemaPollInterval {
+		return s.paused
+	}
+	paused, err := s.redisRepo.IsLocked(ctx, database.SchemaRewriteKey)
+	if err != nil {
+		schemaLog.Warnf("Failed to read whether inserts are paused: %v", err)
+		return s.paused
+	}
+	s.paused, s.pausedRead = paused, time.Now()
+	return paused
+}
+
+// refresh reads the highest applied migration
+func (s *SchemaCoordinator) refresh(ctx context.Context) (int, error) {
+	applied, err := s.clickhouseDB.GetAppliedMigrations(ctx, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	version := 0
+	for _, migration := range applied {
+		version = max(version, int(migration.Version))
+	}
+	s.version.Store(int64(version))
+	return version, nil
+}
+
+func (s *SchemaCoordinator) migrationsResponse(ctx context.Context, message string) (*domain.SchemaMigrationsResponse, error) {
+	applied, err := s.clickhouseDB.GetAppliedMigrations(ctx, "")
+	if err != nil {
+		return &domain.SchemaMigrationsResponse{
+			Success: false,
+			Message: "Failed to retrieve schema migrations: " + err.Error(),
+		}, err
+	}
+	byVersion := make(map[int]database.AppliedMigration, len(applied))
+	version := 0
+	for _, migration := range applied {
+		byVersion[int(migration.Version)] = migration
+		version = max(version, int(migration.Version))
+	}
+
+	migrations := make([]domain.SchemaMigrationStatus, len(database.SchemaMigrations))
+	for i, migration := range database.SchemaMigrations {
+		migrations[i] = domain.SchemaMigrationStatus{
+			Version: migration.Version,
+			Name:    migration.Name,
+			Columns: migration.Columns,
+		}
+		if record, ok := byVersion[migration.Version]; ok {
+			migrations[i].Applied = true
+			migrations[i].AppliedAt = record.AppliedAt.Unix()
+			migrations[i].AppliedBy = record.AppliedBy
+		}
+	}
+	return &domain.SchemaMigrationsResponse{
+		Success:       true,
+		Message:       message,
+		Mode:          s.cfg.SchemaMigrations,
+		Version:       version,
+		LatestVersion: database.LatestSchemaVersion(),
+		Migrations:    migrations,
+	}, nil
+}
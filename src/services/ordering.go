@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"sync"
+	"time"
+)
+
+// orderingLog logs the messages of the ShardedBatcher
+var orderingLog = logging.Named("ShardedBatcher")
+
+// Orderings of the events of a user
+const (
+	OrderingNone = "none" // batches are flushed in parallel, a user's events may be stored out of order
+	OrderingUser = "user" // a user's events are flushed in the order they were enqueued
+)
+
+// eventBuffer buffers events and flushes them to ClickHouse in batches
+type eventBuffer interface {
+	Start()
+	Enqueue(event domain.EventRequest) error
+	EnqueueWithAck(event domain.EventRequest, ack *FlushAck) error
+	Drain(ctx context.Context) error
+	Shutdown() error
+	Stats() domain.IngestionStats
+	// HasRoom reports whether the events fit in the buffers they would be enqueued to
+	HasRoom(events []domain.EventRequest) bool
+	EarliestPending(tenant string) (time.Time, int)
+}
+
+var (
+	_ eventBuffer = &EventBatcher{}
+	_ eventBuffer = &ShardedBatcher{}
+)
+
+// ShardedBatcher routes the events of each user to the same batcher shard, whose single flusher writes its batches one at a time.
+// A user's events are therefore stored in the order they were enqueued, while the shards flush in parallel.
+type ShardedBatcher struct {
+	shards []*EventBatcher
+}
+
+// NewShardedBatcher creates a batcher of the given shards, which must each have a single flusher
+func NewShardedBatcher(shards []*EventBatcher) *ShardedBatcher {
+	return &ShardedBatcher{shards: shards}
+}
+
+// shard returns the shard of the event's tenant and user
+func (s *ShardedBatcher) shard(event domain.EventRequest) *EventBatcher {
+	h := fnv.New32a()
+	h.Write([]byte(event.Ingest.Tenant))
+	h.Write([]byte{0})
+	h.Write([]byte(event.UserID))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// Start starts all shards
+func (s *ShardedBatcher) Start() {
+	for _, shard := range s.shards {
+		shard.Start()
+	}
+	orderingLog.Infof("Started with %d shard(s) ordered per user", len(s.shards))
+}
+
+// Enqueue adds an event to the buffer of its user's shard (non-blocking).
+// Returns ErrBufferFull if that shard's buffer is full.
+func (s *ShardedBatcher) Enqueue(event domain.EventRequest) error {
+	return s.shard(event).Enqueue(event)
+}
+
+// EnqueueWithAck adds an event to the buffer of its user's shard (non-blocking) and completes the ack once it is flushed
+func (s *ShardedBatcher) EnqueueWithAck(event domain.EventRequest, ack *FlushAck) error {
+	return s.shard(event).EnqueueWithAck(event, ack)
+}
+
+// HasRoom reports whether the shard of each event's user has room for the events routed to it,
+// the room of the other shards does not help
+func (s *ShardedBatcher) HasRoom(events []domain.EventRequest) bool {
+	routed := make(map[*EventBatcher]int, len(s.shards))
+	for _, event := range events {
+		routed[s.shard(event)]++
+	}
+	for shard, count := range routed {
+		if !shard.hasRoom(count) {
+			return false
+		}
+	}
+	return true
+}
+
+// Drain drains all shards in parallel, see EventBatcher.Drain
+func (s *ShardedBatcher) Drain(ctx context.Context) error {
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = shard.Drain(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Shutdown gracefully shuts down all shards, flushing their remaining events
+func (s *ShardedBatcher) Shutdown() error {
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = shard.Shutdown()
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Stats sums the buffers and pending batches of the shards. The last flush is the oldest one of the shards with
+// pending events or a failed flush, so that a single stuck shard shows up as flush lag.
+func (s *ShardedBatcher) Stats() domain.IngestionStats {
+	var stats domain.IngestionStats
+	lagging := false
+	for _, shard := range s.shards {
+		shardStats := shard.Stats()
+		stats.BufferedEvents += shardStats.BufferedEvents
+		stats.BufferCapacity += shardStats.BufferCapacity
+		stats.BatchEvents += shardStats.BatchEvents
+		stats.OverflowEvents += shardStats.OverflowEvents
+		stats.OverflowBytes += shardStats.OverflowBytes
+
+		shardLagging := shardStats.BufferedEvents+shardStats.BatchEvents+shardStats.OverflowEvents > 0 || shardStats.LastFlushFailed
+		switch {
+		case shardLagging && (!lagging || shardStats.LastFlushTime.Before(stats.LastFlushTime)):
+			stats.LastFlushTime = shardStats.LastFlushTime
+		case !shardLagging && !lagging && shardStats.LastFlushTime.After(stats.LastFlushTime):
+			stats.LastFlushTime = shardStats.LastFlushTime
+		}
+		lagging = lagging || shardLagging
+		stats.LastFlushFailed = stats.LastFlushFailed || shardStats.LastFlushFailed
+	}
+	return stats
+}
+
+// EarliestPending returns the earliest event time of a tenant's pending events over all shards and their number
+func (s *ShardedBatcher) EarliestPending(tenant string) (time.Time, int) {
+	var earliest time.Time
+	total := 0
+	for _, shard := range s.shards {
+		shardEarliest, pending := shard.EarliestPending(tenant)
+		if pending > 0 && (total == 0 || shardEarliest.Before(earliest)) {
+			earliest = shardEarliest
+		}
+		total += pending
+	}
+	return earliest, total
+}
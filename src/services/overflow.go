@@ -0,0 +1,361 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// overflowLog logs the messages of the OverflowQueue
+var overflowLog = logging.Named("OverflowQueue")
+
+const (
+	// overflowSegmentPrefix and overflowSegmentSuffix name the segment files, around their sequence number
+	overflowSegmentPrefix = "segment_"
+	overflowSegmentSuffix = ".jsonl"
+	// maxOverflowLineBytes bounds a line of a segment, a single event
+	maxOverflowLineBytes = 16 << 20
+)
+
+// overflowRecord is a line of a segment, an event with the attributes assigned at ingestion which its JSON leaves out
+type overflowRecord struct {
+	Event     domain.EventRequest `json:"event"`
+	Ingest    domain.IngestInfo   `json:"ingest"`
+	Raw       []byte              `json:"raw,omitempty"`
+	RequestID string              `json:"request_id,omitempty"`
+	Replay    bool                `json:"replay,omitempty"`
+}
+
+func newOverflowRecord(event domain.EventRequest) overflowRecord {
+	return overflowRecord{
+		Event:     event,
+		Ingest:    event.Ingest,
+		Raw:       event.Ingest.Raw,
+		RequestID: event.Ingest.RequestID,
+		Replay:    event.Ingest.Replay,
+	}
+}
+
+func (r overflowRecord) event() domain.EventRequest {
+	event := r.Event
+	event.Ingest = r.Ingest
+	event.Ingest.Raw = r.Raw
+	event.Ingest.RequestID = r.RequestID
+	event.Ingest.Replay = r.Replay
+	return event
+}
+
+// OverflowQueue spills the events of a full buffer channel to append-only segment files on disk, from which the batcher
+// drains them back into the channel once it has room again. While the queue holds events, new events are appended to it
+// as well, so that they keep their order. Segments are removed once all of their events are handed over to the channel;
+// the segments left at shutdown or after a crash are drained after the next start, replaying the events of a partly drained
+// segment, which the processed events check drops if they were flushed.
+type OverflowQueue struct {
+	dir          string
+	maxBytes     int64 // size of the segments on disk above which events are rejected
+	segmentBytes int64 // size of a segment above which the next event starts a new one
+
+	mu       sync.Mutex
+	segments []uint64 // sequence numbers of the segments on disk, oldest first
+	nextSeq  uint64
+	writer   *os.File // last segment, open for appending, nil until an event is spilled after it was sealed
+	written  int64    // size of the writer's segment
+	bytes    int64    // size of the segments on disk
+	events   int      // events in the segments, including those handed over from the segment being drained
+	notify   chan struct{}
+}
+
+// NewOverflowQueue opens the overflow queue in the configured directory, recovering the segments left there, nil when disabled.
+// Each shard of a sharded batcher has a queue of its own in the subdirectory named by shard, empty for an unsharded batcher.
+func NewOverflowQueue(cfg *config.ClickHouseConfig, shard string) (*OverflowQueue, error) {
+	if cfg.OverflowDir == "" {
+		return nil, nil
+	}
+	if cfg.OverflowMaxBytes <= 0 || cfg.OverflowSegmentBytes <= 0 {
+		return nil, fmt.Errorf("overflow max bytes and segment bytes must be positive")
+	}
+	dir := cfg.OverflowDir
+	if shard != "" {
+		dir = filepath.Join(dir, shard)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create overflow directory: %w", err)
+	}
+
+	q := &OverflowQueue{
+		dir:          dir,
+		maxBytes:     cfg.OverflowMaxBytes,
+		segmentBytes: cfg.OverflowSegmentBytes,
+		notify:       make(chan struct{}, 1),
+	}
+	if err := q.recover(); err != nil {
+		return nil, fmt.Errorf("failed to recover overflow segments: %w", err)
+	}
+	if q.events > 0 {
+		overflowLog.Infof("Recovered %d events in %d segment(s) of %s", q.events, len(q.segments), dir)
+		q.signal()
+	}
+	return q, nil
+}
+
+// recover registers the segments left by a previous run and counts their events
+func (q *OverflowQueue) recover() error {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, overflowSegmentPrefix) || !strings.HasSuffix(name, overflowSegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, overflowSegmentPrefix), overflowSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		events, err := countLines(q.path(seq))
+		if err != nil {
+			return err
+		}
+		q.segments = append(q.segments, seq)
+		q.bytes += info.Size()
+		q.events += events
+		q.nextSeq = max(q.nextSeq, seq+1)
+	}
+	slices.Sort(q.segments)
+	return nil
+}
+
+// countLines returns the number of lines of a file
+func countLines(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxOverflowLineBytes)
+	lines := 0
+	for scanner.Scan() {
+		lines++
+	}
+	return lines, scanner.Err()
+}
+
+// path returns the file of a segment, named so that the segments sort by sequence number
+func (q *OverflowQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%s%020d%s", overflowSegmentPrefix, seq, overflowSegmentSuffix))
+}
+
+// signal wakes up the drain of the queue
+func (q *OverflowQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Add hands an event over with push while the queue is empty. Otherwise, or if push fails, the event is appended to the
+// queue when spill is set, and ErrBufferFull is returned when it is not or the queue is full.
+func (q *OverflowQueue) Add(event domain.EventRequest, push func() bool, spill bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.events == 0 && push() {
+		return nil
+	}
+	if !spill {
+		return ErrBufferFull
+	}
+
+	line, err := json.Marshal(newOverflowRecord(event))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if q.bytes+int64(len(line)) > q.maxBytes {
+		overflowEventsTotal.WithLabelValues("rejected").Inc()
+		return ErrBufferFull
+	}
+	if err := q.append(line); err != nil {
+		overflowLog.Errorf("Failed to spill event to %s: %v", q.dir, err)
+		overflowEventsTotal.WithLabelValues("error").Inc()
+		return ErrBufferFull
+	}
+	if q.events == 0 {
+		overflowLog.Warnf("Buffer is full, spilling events to %s", q.dir)
+	}
+	q.events++
+	overflowEventsTotal.WithLabelValues("spilled").Inc()
+	q.signal()
+	return nil
+}
+
+// append writes a line to the last segment, starting a new one if there is none or it is full
+func (q *OverflowQueue) append(line []byte) error {
+	if q.writer != nil && q.written >= q.segmentBytes {
+		if err := q.seal(); err != nil {
+			return err
+		}
+	}
+	if q.writer == nil {
+		seq := q.nextSeq
+		file, err := os.OpenFile(q.path(seq), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		q.nextSeq++
+		q.segments = append(q.segments, seq)
+		q.writer = file
+		q.written = 0
+	}
+	n, err := q.writer.Write(line)
+	q.written += int64(n)
+	q.bytes += int64(n)
+	return err
+}
+
+// seal closes the last segment, so that it is no longer appended to
+func (q *OverflowQueue) seal() error {
+	if q.writer == nil {
+		return nil
+	}
+	err := q.writer.Close()
+	q.writer = nil
+	return err
+}
+
+// oldest returns the oldest segment, sealing it if it is still appended to, and false if there is none
+func (q *OverflowQueue) oldest() (uint64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.segments) == 0 {
+		return 0, false
+	}
+	if len(q.segments) == 1 && q.writer != nil {
+		if err := q.seal(); err != nil {
+			overflowLog.Warnf("Failed to close segment %d: %v", q.segments[0], err)
+		}
+	}
+	return q.segments[0], true
+}
+
+// release removes a drained segment whose events were handed over to the channel
+func (q *OverflowQueue) release(seq uint64, events int) {
+	path := q.path(seq)
+	info, statErr := os.Stat(path)
+	if err := os.Remove(path); err != nil {
+		overflowLog.Errorf("Failed to remove drained segment %s, its events will be replayed: %v", path, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.segments = slices.DeleteFunc(q.segments, func(s uint64) bool { return s == seq })
+	if statErr == nil {
+		q.bytes -= info.Size()
+	}
+	q.events = max(q.events-events, 0)
+	if q.events == 0 {
+		overflowLog.Infof("Drained all spilled events of %s", q.dir)
+	}
+}
+
+// Drain hands the events of the queue over with push, oldest first, until ctx is done.
+// push blocks until the channel has room and returns false if ctx is done first.
+func (q *OverflowQueue) Drain(ctx context.Context, push func(domain.EventRequest) bool) {
+	for {
+		seq, ok := q.oldest()
+		if !ok {
+			select {
+			case <-q.notify:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		events, err := q.drainSegment(seq, push)
+		if ctx.Err() != nil {
+			// The segment is kept and drained from its start after the next start
+			return
+		}
+		if err != nil {
+			overflowLog.Errorf("Failed to read segment %s, dropping its remaining events: %v", q.path(seq), err)
+		}
+		overflowEventsTotal.WithLabelValues("drained").Add(float64(events))
+		q.release(seq, events)
+	}
+}
+
+// drainSegment hands the events of a sealed segment over with push and returns their number.
+// A line that cannot be decoded is skipped.
+func (q *OverflowQueue) drainSegment(seq uint64, push func(domain.EventRequest) bool) (int, error) {
+	file, err := os.Open(q.path(seq))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxOverflowLineBytes)
+	events := 0
+	for scanner.Scan() {
+		events++
+		var record overflowRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			overflowLog.Warnf("Dropping unreadable event of segment %d: %v", seq, err)
+			overflowEventsTotal.WithLabelValues("error").Inc()
+			continue
+		}
+		if !push(record.event()) {
+			return events, nil
+		}
+	}
+	return events, scanner.Err()
+}
+
+// Len returns the number of spilled events not yet drained
+func (q *OverflowQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.events
+}
+
+// Bytes returns the size of the segments on disk
+func (q *OverflowQueue) Bytes() int64 {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.bytes
+}
+
+// Close closes the segment being appended to, the segments are kept for the next start
+func (q *OverflowQueue) Close() error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.seal()
+}
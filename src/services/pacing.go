@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetPacing reports the spend of each campaign with events in the budget period against its budget from a dimension,
+// relative to the elapsed part of the period. The spend is counted from the start of the period until now, bounded
+// like the range of GET /metrics.
+func (e eventService) GetPacing(ctx context.Context, request *domain.PacingRequest) (*domain.PacingResponse, error) {
+	attributes, err := e.clickhouseDB.GetDimensionAttributes(ctx, request.Dimension)
+	if err == nil && !slices.Contains(attributes, request.Budget) {
+		err = fmt.Errorf("%w %q with a %s attribute", ErrUnknownDimension, request.Dimension, request.Budget)
+	}
+	if err != nil {
+		return &domain.PacingResponse{
+			Success: false,
+			Message: "Failed to compute pacing: " + err.Error(),
+		}, err
+	}
+	tenantDB, err := e.tenants.Database(ctx, request.Filter.Tenant)
+	if err != nil {
+		return &domain.PacingResponse{
+			Success: false,
+			Message: "Failed to compute pacing: " + err.Error(),
+		}, err
+	}
+
+	filter := request.Filter
+	from, to := request.From, min(request.To, time.Now().Unix())
+	filter.From, filter.To = &from, &to
+	if err := e.applyMetricRange(&filter); err != nil {
+		return &domain.PacingResponse{
+			Success: false,
+			Message: "Failed to compute pacing: " + err.Error(),
+			Code:    domain.MetricCodeRangeTooLong,
+		}, err
+	}
+
+	spendKey := strings.TrimPrefix(request.SpendField, domain.MetadataFieldPrefix)
+	spends, err := e.clickhouseDB.GetCampaignSpendFrom(ctx, tenantDB, filter, spendKey, request.Dimension, request.Budget)
+	if err != nil {
+		return &domain.PacingResponse{
+			Success: false,
+			Message: "Failed to compute pacing: " + err.Error(),
+		}, err
+	}
+
+	// to is inclusive, the period lasts to - from + 1 seconds
+	elapsed := float64(to-from+1) / float64(request.To-request.From+1)
+	resp := &domain.PacingResponse{
+		Success:   true,
+		Message:   "Pacing computed successfully",
+		Elapsed:   elapsed,
+		Campaigns: make([]domain.CampaignPacing, len(spends)),
+	}
+	for i, s := range spends {
+		pacing := domain.CampaignPacing{
+			CampaignID:  s.CampaignID,
+			TotalEvents: s.TotalEvents,
+			Spend:       s.Spend,
+			Status:      domain.PacingNoBudget,
+		}
+		if s.TotalEvents > 0 {
+			pacing.SpendPerEvent = s.Spend / float64(s.TotalEvents)
+		}
+		if budget, err := strconv.ParseFloat(strings.TrimSpace(s.Budget), 64); err == nil && budget > 0 {
+			spent := s.Spend / budget
+			rate := spent / elapsed
+			projected := s.Spend / elapsed
+			pacing.Budget, pacing.Spent, pacing.Pacing, pacing.ProjectedSpend = &budget, &spent, &rate, &projected
+			switch {
+			case rate > 1+domain.PacingTolerance:
+				pacing.Status = domain.PacingOver
+			case rate < 1-domain.PacingTolerance:
+				pacing.Status = domain.PacingUnder
+			default:
+				pacing.Status = domain.PacingOnTrack
+			}
+		}
+		resp.Campaigns[i] = pacing
+	}
+	return resp, nil
+}
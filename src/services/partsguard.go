@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/telemetry"
+	"sync"
+	"time"
+)
+
+// partsLog logs the messages of the PartsGuard
+var partsLog = logging.Named("PartsGuard")
+
+// States of the PartsGuard, reported by /health and the parts_guard_state gauge as 0, 1 and 2
+const (
+	PartsHealthy = "healthy" // flushes are written right away
+	PartsSlowed  = "slowed"  // flushes wait up to PARTS_MAX_DELAY_MS, longer the closer the parts are to the pause threshold
+	PartsPaused  = "paused"  // flushes wait until the merges brought the parts below the pause threshold
+)
+
+// partsCheckTimeout bounds a read of system.parts
+const partsCheckTimeout = 5 * time.Second
+
+var (
+	activeParts = telemetry.NewGaugeVec("clickhouse_active_parts",
+		"Active parts of the partition of the events tables with the most of them")
+	partsGuardState = telemetry.NewGaugeVec("parts_guard_state",
+		"State of the parts guard: 0 healthy, 1 flushes slowed, 2 flushes paused")
+	partsGuardWaitSeconds = telemetry.NewCounterVec("parts_guard_wait_seconds_total",
+		"Seconds inserts waited for the parts guard by the state they waited in", "state")
+)
+
+// PartsGuard watches the active parts of the events tables and holds back inserts before ClickHouse rejects them
+// with "too many parts". Small inserts spread over many partitions create parts faster than the merges combine them;
+// past parts_to_throw_insert every insert into the partition fails, so inserts are slowed, then paused, before that.
+type PartsGuard struct {
+	clickhouseDB database.ClickHouseDB
+	interval     time.Duration
+	slow         uint64
+	pause        uint64
+	maxDelay     time.Duration
+	stop         chan struct{}
+	done         chan struct{}
+
+	mu      sync.Mutex
+	parts   database.PartitionParts
+	state   string
+	resumed chan struct{} // closed when a pause ends
+}
+
+// NewPartsGuard creates a guard for the configured thresholds, nil if it is disabled
+func NewPartsGuard(db database.ClickHouseDB, cfg *config.ClickHouseConfig) (*PartsGuard, error) {
+	if cfg.PartsGuardIntervalSeconds <= 0 {
+		return nil, nil
+	}
+	if db.DB == nil {
+		return nil, fmt.Errorf("ClickHouse database connection cannot be nil")
+	}
+	if cfg.PartsSlowThreshold <= 0 || cfg.PartsPauseThreshold <= cfg.PartsSlowThreshold {
+		return nil, fmt.Errorf("parts slow threshold must be positive and below the pause threshold, got %d and %d",
+			cfg.PartsSlowThreshold, cfg.PartsPauseThreshold)
+	}
+	if cfg.PartsMaxDelayMS < 0 {
+		return nil, fmt.Errorf("parts max delay cannot be negative")
+	}
+	return &PartsGuard{
+		clickhouseDB: db,
+		interval:     time.Duration(cfg.PartsGuardIntervalSeconds) * time.Second,
+		slow:         uint64(cfg.PartsSlowThreshold),
+		pause:        uint64(cfg.PartsPauseThreshold),
+		maxDelay:     time.Duration(cfg.PartsMaxDelayMS) * time.Millisecond,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+		state:        PartsHealthy,
+	}, nil
+}
+
+// Start checks the parts right away and then every interval in the background
+func (g *PartsGuard) Start() {
+	if g == nil {
+		return
+	}
+	g.check()
+	go func() {
+		defer close(g.done)
+		ticker := time.NewTicker(g.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-g.stop:
+				return
+			case <-ticker.C:
+				g.check()
+			}
+		}
+	}()
+}
+
+// Stop stops checking the parts and releases the inserts waiting for a pause to end, it must only be called after Start
+func (g *PartsGuard) Stop() {
+	if g == nil {
+		return
+	}
+	close(g.stop)
+	<-g.done
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.setState(PartsHealthy)
+}
+
+// check reads the partition with the most active parts and moves to the state of its count.
+// The state is kept if system.parts cannot be read, inserts fail on their own while ClickHouse is down.
+func (g *PartsGuard) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), partsCheckTimeout)
+	defer cancel()
+	parts, err := g.clickhouseDB.GetMaxActiveParts(ctx)
+	if err != nil {
+		partsLog.Warnf("Failed to read the active parts, keeping state %s: %v", g.State(), err)
+		return
+	}
+	activeParts.WithLabelValues().Set(float64(parts.Parts))
+
+	state := PartsHealthy
+	switch {
+	case parts.Parts >= g.pause:
+		state = PartsPaused
+	case parts.Parts >= g.slow:
+		state = PartsSlowed
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	previous := g.state
+	g.parts = parts
+	g.setState(state)
+	if state == previous {
+		return
+	}
+	switch state {
+	case PartsPaused:
+		partsLog.Errorf("Pausing flushes: partition %s of %s.events has %d active parts, at least the pause threshold of %d",
+			parts.Partition, parts.Database, parts.Parts, g.pause)
+	case PartsSlowed:
+		partsLog.Warnf("Slowing flushes: partition %s of %s.events has %d active parts, at least the slow threshold of %d",
+			parts.Partition, parts.Database, parts.Parts, g.slow)
+	default:
+		partsLog.Infof("Resuming flushes at full speed: at most %d active parts per partition", parts.Parts)
+	}
+}
+
+// setState moves to a state, ending a pause, the lock must be held
+func (g *PartsGuard) setState(state string) {
+	switch {
+	case state == PartsPaused && g.state != PartsPaused:
+		g.resumed = make(chan struct{})
+	case state != PartsPaused && g.state == PartsPaused:
+		close(g.resumed)
+	}
+	g.state = state
+	partsGuardState.WithLabelValues().Set(map[string]float64{PartsHealthy: 0, PartsSlowed: 1, PartsPaused: 2}[state])
+}
+
+// delay is the wait of an insert while flushes are slowed, growing linearly from nothing at the slow threshold
+// to the maximum delay at the pause threshold. The lock must be held.
+func (g *PartsGuard) delay() time.Duration {
+	if g.parts.Parts <= g.slow {
+		return 0
+	}
+	return time.Duration(float64(g.maxDelay) * float64(g.parts.Parts-g.slow) / float64(g.pause-g.slow))
+}
+
+// Wait blocks an insert while flushes are slowed or paused and returns the time it waited,
+// or an error if the context is done before that
+func (g *PartsGuard) Wait(ctx context.Context) (time.Duration, error) {
+	if g == nil {
+		return 0, nil
+	}
+	var waited time.Duration
+	for {
+		g.mu.Lock()
+		state, resumed, delay := g.state, g.resumed, g.delay()
+		g.mu.Unlock()
+
+		var resume <-chan struct{}
+		var timer *time.Timer
+		switch state {
+		case PartsPaused:
+			resume = resumed
+		case PartsSlowed:
+			if delay <= 0 {
+				return waited, nil
+			}
+			timer = time.NewTimer(delay)
+		default:
+			return waited, nil
+		}
+
+		start := time.Now()
+		if timer != nil {
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+			}
+		} else {
+			select {
+			case <-resume:
+			case <-ctx.Done():
+			}
+		}
+		elapsed := time.Since(start)
+		partsGuardWaitSeconds.WithLabelValues(state).Add(elapsed.Seconds())
+		waited += elapsed
+		if ctx.Err() != nil {
+			return waited, ctx.Err()
+		}
+		// A pause may end in slowed flushes, a delay is only waited once
+		if state == PartsSlowed {
+			return waited, nil
+		}
+	}
+}
+
+// State returns the state of the guard, healthy if it is disabled
+func (g *PartsGuard) State() string {
+	if g == nil {
+		return PartsHealthy
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.state
+}
+
+// Status reports the state of the guard and the partition with the most active parts, nil if it is disabled
+func (g *PartsGuard) Status() *domain.ServiceStatus {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.parts.Parts == 0 {
+		return &domain.ServiceStatus{Status: g.state}
+	}
+	return &domain.ServiceStatus{
+		Status: g.state,
+		Message: fmt.Sprintf("partition %s of %s.events has %d active parts, flushes are slowed from %d and paused from %d",
+			g.parts.Partition, g.parts.Database, g.parts.Parts, g.slow, g.pause),
+	}
+}
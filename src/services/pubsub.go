@@ -0,0 +1,222 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/ingest"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/validations"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// pubSubLog logs the messages of the PubSubConsumer
+var pubSubLog = logging.Named("PubSub")
+
+const (
+	// pubSubScope is the OAuth scope of the Pub/Sub API
+	pubSubScope = "https://www.googleapis.com/auth/pubsub"
+	// pubSubRetryInterval is the wait after a failed pull
+	pubSubRetryInterval = time.Second
+	// pubSubMaxAckDeadline is the longest ack deadline Pub/Sub accepts
+	pubSubMaxAckDeadline = 600
+)
+
+// pubSubMessage is a message of a pull response, its data is base64 encoded in JSON
+type pubSubMessage struct {
+	AckID   string `json:"ackId"`
+	Message struct {
+		Data        []byte `json:"data"`
+		MessageID   string `json:"messageId"`
+		OrderingKey string `json:"orderingKey"`
+	} `json:"message"`
+}
+
+// PubSubConsumer ingests the messages of a Google Cloud Pub/Sub subscription, each an event in the format of POST /events.
+// Messages are acknowledged once their events are flushed, otherwise they are redelivered after their ack deadline,
+// so that the subscription itself keeps the progress. Instances pull from the subscription in parallel.
+// With message ordering enabled on the subscription, Pub/Sub delivers the messages of an ordering key in order and
+// holds back the next ones until they are acknowledged; publishing them with the user id as ordering key and
+// EVENT_ORDERING=user keeps each user's events in order up to the table, as their shard of the batcher stores them in order.
+type PubSubConsumer struct {
+	client       *http.Client
+	endpoint     string
+	subscription string // full name of the subscription, projects/<project>/subscriptions/<name>
+	sink         ingest.Sink
+	cfg          *config.PubSubConfig
+	streamID     string // stream the id of the last flushed message is stored under, pubsub:<subscription name>
+	ctx          context.Context
+	cancel       context.CancelFunc
+	stopOnce     sync.Once
+	done         chan struct{}
+}
+
+// NewPubSubConsumer creates a consumer of the configured subscription, nil if no subscription is configured.
+// Credentials come from Application Default Credentials: GOOGLE_APPLICATION_CREDENTIALS, gcloud or the metadata
+// server of GCE and GKE; the emulator of PUBSUB_EMULATOR_HOST is used without credentials.
+func NewPubSubConsumer(cfg *config.PubSubConfig) (*PubSubConsumer, error) {
+	if cfg.Subscription == "" {
+		return nil, nil
+	}
+	if cfg.MaxMessages <= 0 {
+		return nil, fmt.Errorf("Pub/Sub max messages must be positive")
+	}
+	if cfg.AckDeadlineSeconds < 10 || cfg.AckDeadlineSeconds > pubSubMaxAckDeadline {
+		return nil, fmt.Errorf("Pub/Sub ack deadline must be between 10 and %d seconds, got %d", pubSubMaxAckDeadline, cfg.AckDeadlineSeconds)
+	}
+	if err := validations.ValidateTenantID(cfg.Tenant); err != nil {
+		return nil, fmt.Errorf("invalid Pub/Sub tenant %q", cfg.Tenant)
+	}
+	subscription := cfg.Subscription
+	if !strings.HasPrefix(subscription, "projects/") {
+		if cfg.Project == "" {
+			return nil, fmt.Errorf("PUBSUB_PROJECT is required unless PUBSUB_SUBSCRIPTION is a full projects/<project>/subscriptions/<name> path")
+		}
+		subscription = "projects/" + cfg.Project + "/subscriptions/" + subscription
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer := &PubSubConsumer{
+		client:       http.DefaultClient,
+		endpoint:     strings.TrimSuffix(cfg.Endpoint, "/"),
+		subscription: subscription,
+		cfg:          cfg,
+		streamID:     "pubsub:" + path.Base(subscription),
+		ctx:          ctx,
+		cancel:       cancel,
+		done:         make(chan struct{}),
+	}
+	if cfg.EmulatorHost != "" {
+		consumer.endpoint = "http://" + cfg.EmulatorHost
+		return consumer, nil
+	}
+	// Tokens are refreshed with the context of the consumer, for its lifetime
+	client, err := google.DefaultClient(ctx, pubSubScope)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to find Google Cloud credentials: %w", err)
+	}
+	consumer.client = client
+	return consumer, nil
+}
+
+// Name returns the source of the events of the consumer
+func (p *PubSubConsumer) Name() string {
+	return domain.SourcePubSub
+}
+
+// Start pulls messages in the background and delivers them to the sink, failures are logged and retried.
+// It is safe to call on a nil consumer.
+func (p *PubSubConsumer) Start(sink ingest.Sink) {
+	if p == nil {
+		return
+	}
+	p.sink = sink
+	pubSubLog.Infof("Consuming subscription %s", p.subscription)
+	go func() {
+		defer close(p.done)
+		for p.ctx.Err() == nil {
+			if err := p.pull(p.ctx); err != nil && p.ctx.Err() == nil {
+				pubSubLog.Errorf("Failed to consume %s: %v", p.subscription, err)
+				select {
+				case <-p.ctx.Done():
+				case <-time.After(pubSubRetryInterval):
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops pulling, messages whose events are not flushed yet are redelivered. It is safe to call on a nil consumer.
+func (p *PubSubConsumer) Stop() {
+	if p == nil {
+		return
+	}
+	p.stopOnce.Do(func() {
+		p.cancel()
+		<-p.done
+		pubSubLog.Infof("Stopped")
+	})
+}
+
+// pull ingests a batch of messages and acknowledges them once their events are flushed
+func (p *PubSubConsumer) pull(ctx context.Context) error {
+	var pulled struct {
+		ReceivedMessages []pubSubMessage `json:"receivedMessages"`
+	}
+	if err := p.call(ctx, "pull", map[string]any{"maxMessages": p.cfg.MaxMessages}, &pulled); err != nil {
+		return err
+	}
+	messages := pulled.ReceivedMessages
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ackIDs := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ackIDs = append(ackIDs, message.AckID)
+	}
+	// The subscription's deadline, 10 seconds by default, may expire while the events wait for their flush
+	if err := p.call(ctx, "modifyAckDeadline", map[string]any{"ackIds": ackIDs, "ackDeadlineSeconds": p.cfg.AckDeadlineSeconds}, nil); err != nil {
+		return fmt.Errorf("failed to extend the ack deadline of %d messages: %w", len(messages), err)
+	}
+
+	chunk := ingest.Chunk{StreamID: p.streamID, Checkpoint: messages[len(messages)-1].Message.MessageID}
+	for _, message := range messages {
+		event, err := ingest.ParseEvent(message.Message.Data, p.cfg.Tenant, domain.SourcePubSub)
+		if err != nil {
+			pubSubLog.Warnf("Skipping invalid message %s: %v", message.Message.MessageID, err)
+			chunk.Invalid++
+			continue
+		}
+		if event.UserID == "" && p.cfg.OrderingKeyAsUser {
+			event.UserID = message.Message.OrderingKey
+		}
+		chunk.Events = append(chunk.Events, event)
+	}
+	if err := p.sink.Deliver(ctx, chunk); err != nil {
+		return err
+	}
+
+	if err := p.call(ctx, "acknowledge", map[string]any{"ackIds": ackIDs}, nil); err != nil {
+		// They are redelivered and dropped by deduplication
+		return fmt.Errorf("failed to acknowledge %d flushed messages: %w", len(messages), err)
+	}
+	return nil
+}
+
+// call invokes a method of the subscription through the REST API of Pub/Sub, decoding the response into result if not nil
+func (p *PubSubConsumer) call(ctx context.Context, method string, body, result any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/v1/"+p.subscription+":"+method, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned %s: %s", method, resp.Status, bytes.TrimSpace(message))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
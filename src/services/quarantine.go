@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+	"time"
+)
+
+const (
+	defaultQuarantineLimit = 100
+	maxQuarantineLimit     = 1000
+)
+
+// quarantineEvents checks events against the data quality rules, stores the violating ones in the quarantine
+// table and returns the others
+func quarantineEvents(ctx context.Context, db database.ClickHouseDB, events []domain.EventRequest) ([]domain.EventRequest, int, error) {
+	var quarantined []database.QuarantinedEvent
+	var passed []domain.EventRequest
+	for i := range events {
+		violations := validations.CheckQuality(&events[i])
+		if len(violations) == 0 {
+			if quarantined != nil {
+				passed = append(passed, events[i])
+			}
+			continue
+		}
+		if quarantined == nil {
+			// Only copy the events once the first one is quarantined
+			passed = append(make([]domain.EventRequest, 0, len(events)), events[:i]...)
+		}
+		row, err := newQuarantinedEvent(events[i], violations)
+		if err != nil {
+			return nil, 0, err
+		}
+		quarantined = append(quarantined, row)
+	}
+	if quarantined == nil {
+		return events, 0, nil
+	}
+
+	if err := db.SaveQuarantined(ctx, quarantined); err != nil {
+		return nil, 0, err
+	}
+	return passed, len(quarantined), nil
+}
+
+func newQuarantinedEvent(event domain.EventRequest, violations []string) (database.QuarantinedEvent, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return database.QuarantinedEvent{}, fmt.Errorf("failed to generate quarantine id: %w", err)
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return database.QuarantinedEvent{}, fmt.Errorf("failed to encode quarantined event: %w", err)
+	}
+	now := time.Now()
+	return database.QuarantinedEvent{
+		ID:            id,
+		Tenant:        event.Ingest.Tenant,
+		Producer:      event.Ingest.Producer,
+		EventName:     event.EventName,
+		Event:         string(encoded),
+		Violations:    violations,
+		Status:        database.QuarantineStatusQuarantined,
+		QuarantinedAt: now,
+		UpdatedAt:     now,
+	}, nil
+}
+
+// decodeQuarantined restores the event request of a quarantined event, including the attributes assigned at ingestion
+func decodeQuarantined(row database.QuarantinedEvent) (domain.EventRequest, error) {
+	var event domain.EventRequest
+	if err := json.Unmarshal([]byte(row.Event), &event); err != nil {
+		return event, fmt.Errorf("failed to decode quarantined event: %w", err)
+	}
+	event.Ingest.Tenant = row.Tenant
+	event.Ingest.Producer = row.Producer
+	event.Ingest.RawBytes = len(row.Event)
+	return event, nil
+}
+
+var _ domain.QuarantineService = &quarantineService{}
+
+type quarantineService struct {
+	clickhouseDB database.ClickHouseDB
+	eventService domain.EventService
+}
+
+// ListQuarantine returns the quarantined events of a tenant, newest first
+func (q quarantineService) ListQuarantine(ctx context.Context, request *domain.QuarantineRequest) (*domain.QuarantineResponse, error) {
+	limit := request.Limit
+	if limit <= 0 {
+		limit = defaultQuarantineLimit
+	}
+	rows, err := q.clickhouseDB.GetQuarantined(ctx, database.QuarantineFilter{
+		Tenant:    request.Tenant,
+		EventName: request.EventName,
+		Status:    request.Status,
+		Limit:     min(limit, maxQuarantineLimit),
+	})
+	if err != nil {
+		return &domain.QuarantineResponse{
+			Success: false,
+			Message: "Failed to retrieve quarantined events: " + err.Error(),
+		}, err
+	}
+
+	events := make([]domain.QuarantinedEvent, 0, len(rows))
+	for _, row := range rows {
+		event, err := decodeQuarantined(row)
+		if err != nil {
+			return &domain.QuarantineResponse{
+				Success: false,
+				Message: "Failed to retrieve quarantined events: " + err.Error(),
+			}, err
+		}
+		events = append(events, domain.QuarantinedEvent{
+			ID:            row.ID,
+			Event:         event,
+			Violations:    row.Violations,
+			Status:        row.Status,
+			QuarantinedAt: row.QuarantinedAt.Unix(),
+			UpdatedAt:     row.UpdatedAt.Unix(),
+		})
+	}
+
+	return &domain.QuarantineResponse{
+		Success: true,
+		Message: "Quarantined events retrieved successfully",
+		Events:  events,
+	}, nil
+}
+
+// ReprocessQuarantine applies the request's transform to quarantined events and checks them against the current rules again.
+// Events passing them are ingested like new events and marked reprocessed, the others keep their updated violations.
+func (q quarantineService) ReprocessQuarantine(ctx context.Context, request *domain.ReprocessRequest) (*domain.ReprocessResponse, error) {
+	rows, err := q.clickhouseDB.GetQuarantinedByIDs(ctx, request.Tenant, request.IDs)
+	if err != nil {
+		return &domain.ReprocessResponse{
+			Success: false,
+			Message: "Failed to retrieve quarantined events: " + err.Error(),
+		}, err
+	}
+	byID := make(map[string]database.QuarantinedEvent, len(rows))
+	for _, row := range rows {
+		byID[row.ID] = row
+	}
+
+	results := make([]domain.ReprocessResult, 0, len(request.IDs))
+	var updates []database.QuarantinedEvent
+	for _, id := range request.IDs {
+		row, ok := byID[id]
+		if !ok || row.Status != database.QuarantineStatusQuarantined {
+			results = append(results, domain.ReprocessResult{ID: id, Status: domain.ReprocessNotFound})
+			continue
+		}
+		result := q.reprocess(ctx, &row, request.Transform)
+		results = append(results, result)
+		if result.Status != domain.ReprocessFailed {
+			row.UpdatedAt = time.Now()
+			updates = append(updates, row)
+		}
+	}
+
+	if err := q.clickhouseDB.SaveQuarantined(ctx, updates); err != nil {
+		// Reprocessed events stay quarantined and would be ingested again, deduplication keeps them from being stored twice
+		return &domain.ReprocessResponse{
+			Success: false,
+			Message: "Failed to update quarantined events: " + err.Error(),
+			Results: results,
+		}, err
+	}
+
+	return &domain.ReprocessResponse{
+		Success: true,
+		Message: "Quarantined events reprocessed",
+		Results: results,
+	}, nil
+}
+
+// reprocess transforms, validates and ingests a quarantined event, updating the row with its new status.
+// The row keeps the original event, a transformed event that is still violating the rules is not stored.
+func (q quarantineService) reprocess(ctx context.Context, row *database.QuarantinedEvent, transform *domain.EventTransform) domain.ReprocessResult {
+	result := domain.ReprocessResult{ID: row.ID}
+	event, err := decodeQuarantined(*row)
+	event.Ingest.Source = domain.SourceReprocess
+	if err == nil && transform != nil {
+		err = transform.Apply(&event)
+	}
+	if err != nil {
+		result.Status = domain.ReprocessFailed
+		result.Error = err.Error()
+		return result
+	}
+
+	var violations []string
+	if err := validations.ValidateEventRequest(&event); err != nil {
+		violations = []string{err.Error()}
+	} else {
+		violations = validations.CheckQuality(&event)
+	}
+	if len(violations) > 0 {
+		row.Violations = violations
+		result.Status = domain.ReprocessQuarantined
+		result.Violations = violations
+		return result
+	}
+
+	if _, err := q.eventService.PostEvents(ctx, &event); err != nil {
+		result.Status = domain.ReprocessFailed
+		result.Error = err.Error()
+		return result
+	}
+	row.Status = database.QuarantineStatusReprocessed
+	row.Violations = nil
+	result.Status = domain.ReprocessReprocessed
+	return result
+}
+
+// NewQuarantineService returns a domain.QuarantineService ingesting reprocessed events through the event service.
+func NewQuarantineService(db database.ClickHouseDB, eventService domain.EventService) (domain.QuarantineService, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("ClickHouse database connection cannot be nil")
+	}
+	if eventService == nil {
+		return nil, fmt.Errorf("event service cannot be nil")
+	}
+	return &quarantineService{clickhouseDB: db, eventService: eventService}, nil
+}
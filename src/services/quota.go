@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// quotaLog logs the messages of the QuotaEnforcer
+var quotaLog = logging.Named("QuotaEnforcer")
+
+// Modes for events beyond a tenant's monthly cap
+const (
+	QuotaModeReject = "reject"
+	QuotaModeSample = "sample"
+)
+
+// quotaRefreshInterval is how long cached tenant usage is trusted before it is reloaded from Redis
+const quotaRefreshInterval = 5 * time.Second
+
+// ErrQuotaExceeded is returned when a tenant exceeded its monthly cap in reject mode
+var ErrQuotaExceeded = errors.New("monthly quota exceeded")
+
+// QuotaEnforcer tracks the events stored per tenant and month and enforces the monthly caps.
+// Usage is counted when events are flushed, so events still in the buffer can overshoot the cap slightly.
+type QuotaEnforcer struct {
+	cfg          *config.QuotaConfig
+	tenantEvents map[string]int64
+	redisRepo    database.ClickHouseRedis
+
+	mu    sync.Mutex
+	usage map[string]*tenantUsage
+}
+
+// tenantUsage is the cached usage of a tenant in a month
+type tenantUsage struct {
+	month     string
+	events    int64
+	bytes     int64
+	fetchedAt time.Time
+}
+
+// NewQuotaEnforcer creates an enforcer for the configured caps
+func NewQuotaEnforcer(cfg *config.QuotaConfig, redisClient database.ClickHouseRedis) (*QuotaEnforcer, error) {
+	if redisClient.Client == nil {
+		return nil, fmt.Errorf("Redis client cannot be nil")
+	}
+	if cfg.Mode != QuotaModeReject && cfg.Mode != QuotaModeSample {
+		return nil, fmt.Errorf("unknown quota mode %q", cfg.Mode)
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, fmt.Errorf("quota sample rate must be between 0 and 1, got %v", cfg.SampleRate)
+	}
+
+	tenantEvents := make(map[string]int64, len(cfg.TenantEvents))
+	for tenant, value := range cfg.TenantEvents {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid event quota %q for tenant %q", value, tenant)
+		}
+		tenantEvents[tenant] = limit
+	}
+
+	return &QuotaEnforcer{
+		cfg:          cfg,
+		tenantEvents: tenantEvents,
+		redisRepo:    redisClient,
+		usage:        make(map[string]*tenantUsage),
+	}, nil
+}
+
+// limits returns the monthly event and byte caps of a tenant, 0 means unlimited
+func (q *QuotaEnforcer) limits(tenant string) (events, bytes int64) {
+	events = q.cfg.MonthlyEvents
+	if limit, ok := q.tenantEvents[tenant]; ok {
+		events = limit
+	}
+	return events, q.cfg.MonthlyBytes
+}
+
+// Admit returns the events of a tenant that may be stored.
+// Within the caps all events are admitted. Beyond them, reject mode returns ErrQuotaExceeded
+// and sample mode keeps a deterministic fraction of the events, so retries are sampled the same way.
+// It is safe to call on a nil enforcer, which admits everything.
+func (q *QuotaEnforcer) Admit(ctx context.Context, tenant string, events []domain.EventRequest) ([]domain.EventRequest, error) {
+	if q == nil || len(events) == 0 {
+		return events, nil
+	}
+	eventLimit, byteLimit := q.limits(tenant)
+	if eventLimit == 0 && byteLimit == 0 {
+		return events, nil
+	}
+
+	usage := q.current(ctx, tenant)
+	if !exceeded(usage.events, usage.bytes, eventLimit, byteLimit) {
+		return events, nil
+	}
+	if q.cfg.Mode == QuotaModeReject {
+		return nil, ErrQuotaExceeded
+	}
+
+	if q.cfg.SampleRate == 0 {
+		return nil, nil
+	}
+	threshold := uint32(q.cfg.SampleRate * float64(1<<32-1))
+	sampled := make([]domain.EventRequest, 0, int(float64(len(events))*q.cfg.SampleRate)+1)
+	for _, event := range events {
+		h := fnv.New32a()
+		h.Write([]byte(event.GetUniqueKey()))
+		if h.Sum32() <= threshold {
+			sampled = append(sampled, event)
+		}
+	}
+	return sampled, nil
+}
+
+// RecordStored adds events written to ClickHouse to the tenant's usage of the current month
+func (q *QuotaEnforcer) RecordStored(ctx context.Context, tenant string, events int, bytes int) {
+	if q == nil || events == 0 {
+		return
+	}
+	month := currentMonth()
+	if err := q.redisRepo.IncrementTenantUsage(ctx, tenant, month, int64(events), int64(bytes)); err != nil {
+		quotaLog.Errorf("Failed to record usage of tenant %q: %v", tenant, err)
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if usage, ok := q.usage[tenant]; ok && usage.month == month {
+		usage.events += int64(events)
+		usage.bytes += int64(bytes)
+	}
+}
+
+// GetQuota reports the usage of a tenant in the current month against its caps
+func (q *QuotaEnforcer) GetQuota(ctx context.Context, tenant string) (*domain.QuotaResponse, error) {
+	month := currentMonth()
+	events, bytes, err := q.redisRepo.GetTenantUsage(ctx, tenant, month)
+	if err != nil {
+		return &domain.QuotaResponse{
+			Success: false,
+			Message: "Failed to retrieve quota: " + err.Error(),
+			Tenant:  tenant,
+			Month:   month,
+		}, err
+	}
+
+	eventLimit, byteLimit := q.limits(tenant)
+	return &domain.QuotaResponse{
+		Success:      true,
+		Message:      "Quota retrieved successfully",
+		Tenant:       tenant,
+		Month:        month,
+		StoredEvents: events,
+		StoredBytes:  bytes,
+		EventLimit:   eventLimit,
+		ByteLimit:    byteLimit,
+		Exceeded:     exceeded(events, bytes, eventLimit, byteLimit),
+		Mode:         q.cfg.Mode,
+	}, nil
+}
+
+// current returns the cached usage of a tenant, reloading it from Redis when stale.
+// If Redis is unavailable the stale usage is kept, so ingestion is not blocked by quota bookkeeping.
+func (q *QuotaEnforcer) current(ctx context.Context, tenant string) tenantUsage {
+	month := currentMonth()
+
+	q.mu.Lock()
+	usage, ok := q.usage[tenant]
+	if ok && usage.month == month && time.Since(usage.fetchedAt) < quotaRefreshInterval {
+		defer q.mu.Unlock()
+		return *usage
+	}
+	q.mu.Unlock()
+
+	events, bytes, err := q.redisRepo.GetTenantUsage(ctx, tenant, month)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		quotaLog.Errorf("Failed to load usage of tenant %q: %v", tenant, err)
+		if ok && usage.month == month {
+			return *usage
+		}
+		return tenantUsage{month: month}
+	}
+	usage = &tenantUsage{month: month, events: events, bytes: bytes, fetchedAt: time.Now()}
+	q.usage[tenant] = usage
+	return *usage
+}
+
+func exceeded(events, bytes, eventLimit, byteLimit int64) bool {
+	return (eventLimit > 0 && events >= eventLimit) || (byteLimit > 0 && bytes >= byteLimit)
+}
+
+// currentMonth returns the quota period, months are in UTC
+func currentMonth() string {
+	return time.Now().UTC().Format("2006-01")
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// InsertRateLimiter smooths the flush rate toward ClickHouse.
+// It limits both the number of insert statements and the number of rows per second,
+// so that bursts of traffic are spread over time instead of hammering merges on the server.
+// Inserts also wait while the parts guard slows or pauses them, and while a schema migration rewrites the events table.
+type InsertRateLimiter struct {
+	inserts *tokenBucket
+	rows    *tokenBucket
+	parts   *PartsGuard
+	schema  *SchemaCoordinator
+}
+
+// NewInsertRateLimiter creates a limiter for the given rates.
+// A rate that is zero or negative disables the corresponding limit, a nil parts guard or schema coordinator never holds inserts back.
+func NewInsertRateLimiter(insertsPerSecond, rowsPerSecond float64, parts *PartsGuard, schema *SchemaCoordinator) *InsertRateLimiter {
+	return &InsertRateLimiter{
+		inserts: newTokenBucket(insertsPerSecond),
+		rows:    newTokenBucket(rowsPerSecond),
+		parts:   parts,
+		schema:  schema,
+	}
+}
+
+// Wait blocks until an insert of the given number of rows is allowed.
+// It returns the time spent waiting, or an error if the context is done before that.
+func (l *InsertRateLimiter) Wait(ctx context.Context, rows int) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+
+	// A rewrite and too many parts take precedence, the tokens are only taken once the insert is allowed
+	held, err := l.schema.WaitRewrite(ctx)
+	if err != nil {
+		return held, err
+	}
+	partsHeld, err := l.parts.Wait(ctx)
+	held += partsHeld
+	if err != nil {
+		return held, err
+	}
+
+	delay := max(l.inserts.reserve(1), l.rows.reserve(float64(rows)))
+	if delay <= 0 {
+		return held, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return held + delay, nil
+	case <-ctx.Done():
+		// The insert is abandoned, its tokens are given back so that the callers after it do not wait for them
+		l.inserts.refund(1)
+		l.rows.refund(float64(rows))
+		return held, ctx.Err()
+	}
+}
+
+// tokenBucket is a token bucket that allows callers to go into debt.
+// A caller is admitted as soon as the bucket is not empty, even if it takes more tokens
+// than the bucket holds; the callers after it wait until the debt is paid back.
+// This way a batch larger than one second worth of tokens is never blocked forever.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64 // maximum number of tokens the bucket can hold
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a bucket refilled at the given rate, or nil if the rate is not positive.
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := math.Max(rate, 1)
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n tokens from the bucket and returns how long the caller has to wait
+// before it is allowed to proceed.
+func (t *tokenBucket) reserve(n float64) time.Duration {
+	if t == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.tokens = math.Min(t.burst, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	t.last = now
+
+	var delay time.Duration
+	if t.tokens < 0 {
+		delay = time.Duration(-t.tokens / t.rate * float64(time.Second))
+	}
+	t.tokens -= n
+	return delay
+}
+
+// refund gives back n tokens reserved by a caller that did not proceed, never beyond the burst.
+func (t *tokenBucket) refund(n float64) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tokens = math.Min(t.burst, t.tokens+n)
+}
+
+// take takes n tokens if the bucket holds them, without going into debt, and reports whether it did.
+// Callers that are not allowed to wait are rejected instead.
+func (t *tokenBucket) take(n float64) bool {
+	if t == nil {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.tokens = math.Min(t.burst, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	t.last = now
+
+	if t.tokens < n {
+		return false
+	}
+	t.tokens -= n
+	return true
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"time"
+)
+
+// rawLog logs the messages of the RawPayloadStore
+var rawLog = logging.Named("RawPayloadStore")
+
+// maxConcurrentRawWrites bounds the inserts of raw payloads in flight, payloads beyond it are not kept
+const maxConcurrentRawWrites = 4
+
+// rawWriteTimeout bounds each insert of raw payloads
+const rawWriteTimeout = 30 * time.Second
+
+// RawPayloadStore keeps the JSON of stored events as received in events_raw, so that they can be replayed through
+// /admin/reprocess after fixing a mapping bug. Writes are asynchronous and best effort, they never delay or fail ingestion.
+type RawPayloadStore struct {
+	clickhouseDB database.ClickHouseDB
+	inFlight     chan struct{}
+}
+
+// NewRawPayloadStore creates a store of raw payloads, nil if raw payloads are not retained
+func NewRawPayloadStore(db database.ClickHouseDB, cfg *config.ClickHouseConfig) *RawPayloadStore {
+	if cfg.RawPayloadTTLHours <= 0 {
+		return nil
+	}
+	return &RawPayloadStore{
+		clickhouseDB: db,
+		inFlight:     make(chan struct{}, maxConcurrentRawWrites),
+	}
+}
+
+// Save stores the raw payloads of events that were stored, events without one (e.g. pixels and replays) are skipped.
+// It is safe to call on a nil store.
+func (r *RawPayloadStore) Save(events []domain.EventRequest) {
+	if r == nil {
+		return
+	}
+	receivedAt := time.Now()
+	rows := make([]database.RawEvent, 0, len(events))
+	for _, event := range events {
+		if len(event.Ingest.Raw) == 0 {
+			continue
+		}
+		rows = append(rows, database.RawEvent{
+			EventID:    event.EventID(),
+			Tenant:     event.Ingest.Tenant,
+			Producer:   event.Ingest.Producer,
+			ReceivedAt: receivedAt,
+			Payload:    string(event.Ingest.Raw),
+		})
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	select {
+	case r.inFlight <- struct{}{}:
+		go func() {
+			defer func() { <-r.inFlight }()
+			ctx, cancel := context.WithTimeout(context.Background(), rawWriteTimeout)
+			defer cancel()
+			if err := r.clickhouseDB.SaveRawEvents(ctx, rows); err != nil {
+				recordInsertError("events_raw")
+				rawLog.Errorf("Failed to store %d raw payloads: %v", len(rows), err)
+			}
+		}()
+	default:
+		rawLog.Warnf("Too many writes in flight, dropping %d raw payloads", len(rows))
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"errors"
+	"kucukaslan/clickhouse/domain"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrRealtimeDisabled is returned when realtime metrics are requested while the aggregation is disabled
+var ErrRealtimeDisabled = errors.New("realtime aggregation is disabled")
+
+// RealtimeAggregator keeps rolling per-minute event counts by tenant, event name and channel in memory,
+// so that the last minutes can be queried without waiting for the batcher to flush to ClickHouse.
+// Counts are per instance and events are counted when enqueued, before deduplication by the flush.
+type RealtimeAggregator struct {
+	window  int64     // minutes kept
+	started time.Time // counts before it were not seen by this instance
+
+	mu      sync.Mutex
+	buckets []realtimeBucket // ring indexed by minute modulo window
+}
+
+// realtimeBucket holds the counts of one minute
+type realtimeBucket struct {
+	minute int64 // unix minute
+	counts map[realtimeKey]uint64
+}
+
+type realtimeKey struct {
+	tenant    string
+	eventName string
+	channel   string
+}
+
+// NewRealtimeAggregator creates an aggregator keeping the given number of minutes
+func NewRealtimeAggregator(windowMinutes int) *RealtimeAggregator {
+	if windowMinutes < 1 {
+		windowMinutes = 1
+	}
+	return &RealtimeAggregator{
+		window:  int64(windowMinutes),
+		started: time.Now(),
+		buckets: make([]realtimeBucket, windowMinutes),
+	}
+}
+
+// WindowMinutes returns the number of minutes kept
+func (r *RealtimeAggregator) WindowMinutes() int {
+	return int(r.window)
+}
+
+// Record counts events in the minute of their event time. Events older than the window or in a future minute are ignored.
+// It is safe to call on a nil aggregator.
+func (r *RealtimeAggregator) Record(events ...domain.EventRequest) {
+	if r == nil || len(events) == 0 {
+		return
+	}
+	now := time.Now().Unix() / 60
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range events {
+		minute := event.EventTime().Unix() / 60
+		if minute > now || minute <= now-r.window {
+			continue
+		}
+		bucket := &r.buckets[minute%r.window]
+		if bucket.minute != minute || bucket.counts == nil {
+			// The slot still holds a minute that left the window
+			bucket.minute = minute
+			bucket.counts = make(map[realtimeKey]uint64)
+		}
+		bucket.counts[realtimeKey{
+			tenant:    event.Ingest.Tenant,
+			eventName: event.EventName,
+			channel:   event.Channel,
+		}]++
+	}
+}
+
+// Query returns the counts of a tenant in the last minutes, the current minute included, oldest first.
+// Nil filters match all event names or channels.
+func (r *RealtimeAggregator) Query(tenant string, eventName, channel *string, minutes int) []domain.RealtimeMetric {
+	if minutes < 1 || int64(minutes) > r.window {
+		minutes = int(r.window)
+	}
+	now := time.Now().Unix() / 60
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	metrics := make([]domain.RealtimeMetric, 0)
+	for minute := now - int64(minutes) + 1; minute <= now; minute++ {
+		bucket := r.buckets[minute%r.window]
+		if bucket.minute != minute {
+			continue
+		}
+		start := len(metrics)
+		for key, count := range bucket.counts {
+			if key.tenant != tenant ||
+				(eventName != nil && key.eventName != *eventName) ||
+				(channel != nil && key.channel != *channel) {
+				continue
+			}
+			metrics = append(metrics, domain.RealtimeMetric{
+				Minute:      time.Unix(minute*60, 0).UTC().Format(time.DateTime),
+				EventName:   key.eventName,
+				Channel:     key.channel,
+				TotalEvents: count,
+			})
+		}
+		// Map iteration order is random, keep the rows of a minute stable
+		minuteMetrics := metrics[start:]
+		sort.Slice(minuteMetrics, func(i, j int) bool {
+			if minuteMetrics[i].EventName != minuteMetrics[j].EventName {
+				return minuteMetrics[i].EventName < minuteMetrics[j].EventName
+			}
+			return minuteMetrics[i].Channel < minuteMetrics[j].Channel
+		})
+	}
+	return metrics
+}
+
+// Tail returns the start of the minutes whose counts are complete in memory, the window starting with the
+// current minute or, right after startup, the first full minute seen by this instance
+func (r *RealtimeAggregator) Tail() time.Time {
+	tail := time.Now().Truncate(time.Minute).Add(-time.Duration(r.window-1) * time.Minute)
+	// The minute of the startup is only partly counted
+	first := r.started.Truncate(time.Minute)
+	if first.Before(r.started) {
+		first = first.Add(time.Minute)
+	}
+	if first.After(tail) {
+		return first
+	}
+	return tail
+}
+
+// realtimeCount is the count of events with the same event name and channel in a minute
+type realtimeCount struct {
+	minute    time.Time
+	eventName string
+	channel   string
+	count     uint64
+}
+
+// counts returns the counts of a tenant from the minute starting at since up to the current minute
+func (r *RealtimeAggregator) counts(tenant string, eventName *string, since time.Time) []realtimeCount {
+	now := time.Now().Unix() / 60
+	first := max(since.Unix()/60, now-r.window+1)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var counts []realtimeCount
+	for minute := first; minute <= now; minute++ {
+		bucket := r.buckets[minute%r.window]
+		if bucket.minute != minute {
+			continue
+		}
+		for key, count := range bucket.counts {
+			if key.tenant != tenant || (eventName != nil && key.eventName != *eventName) {
+				continue
+			}
+			counts = append(counts, realtimeCount{
+				minute:    time.Unix(minute*60, 0).UTC(),
+				eventName: key.eventName,
+				channel:   key.channel,
+				count:     count,
+			})
+		}
+	}
+	return counts
+}
+
+// realtimeBucketFunc maps realtime counts to the buckets of a /metrics group_by, matching the bucket names ClickHouse returns.
+// It returns nil for groupings the realtime counts cannot serve, such as user_id or campaign_id.
+func realtimeBucketFunc(groupBy *string) func(c realtimeCount) string {
+	if groupBy == nil {
+		return func(realtimeCount) string { return "total" }
+	}
+	switch *groupBy {
+	case "hour":
+		return func(c realtimeCount) string { return c.minute.Truncate(time.Hour).Format(time.DateTime) }
+	case "day":
+		return func(c realtimeCount) string { return startOfDay(c.minute).Format(time.DateTime) }
+	case "week":
+		// toStartOfWeek defaults to weeks starting on Sunday and returns a Date
+		return func(c realtimeCount) string {
+			day := startOfDay(c.minute)
+			return day.AddDate(0, 0, -int(day.Weekday())).Format(time.DateOnly)
+		}
+	case "month":
+		return func(c realtimeCount) string {
+			return time.Date(c.minute.Year(), c.minute.Month(), 1, 0, 0, 0, 0, time.UTC).Format(time.DateOnly)
+		}
+	case "year":
+		return func(c realtimeCount) string {
+			return time.Date(c.minute.Year(), time.January, 1, 0, 0, 0, 0, time.UTC).Format(time.DateOnly)
+		}
+	case "channel":
+		return func(c realtimeCount) string { return c.channel }
+	case "event_name":
+		return func(c realtimeCount) string { return c.eventName }
+	case "campaign_id", "user_id":
+		return nil
+	default:
+		// Unknown groupings fall back to a single total in ClickHouse as well
+		return func(realtimeCount) string { return "total" }
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/flush"
+	"kucukaslan/clickhouse/validations"
+)
+
+// ErrUnknownReprocessSource is returned when events are reprocessed from a source that is not available
+var ErrUnknownReprocessSource = errors.New("unknown reprocess source")
+
+var _ domain.ReprocessService = &reprocessService{}
+
+type reprocessService struct {
+	quarantineService domain.QuarantineService
+	clickhouseDB      database.ClickHouseDB
+	eventService      domain.EventService
+	deadLetters       *DeadLetterQueue
+	sinks             *flush.Manager
+	rawPayloads       bool // whether raw payloads are retained in events_raw
+}
+
+// Reprocess re-ingests events from the requested source through validation and the batcher
+func (r reprocessService) Reprocess(ctx context.Context, request *domain.ReprocessRequest) (*domain.ReprocessResponse, error) {
+	switch request.Source {
+	case "", domain.ReprocessSourceQuarantine:
+		return r.quarantineService.ReprocessQuarantine(ctx, request)
+	case domain.ReprocessSourceRaw:
+		if !r.rawPayloads {
+			return &domain.ReprocessResponse{
+				Success: false,
+				Message: "Raw payloads are not retained, set RAW_PAYLOAD_TTL_HOURS",
+			}, ErrUnknownReprocessSource
+		}
+		return r.reprocessRaw(ctx, request)
+	case domain.ReprocessSourceDeadLetter:
+		if request.Sink != "" {
+			return r.rewriteDeadLetters(ctx, request)
+		}
+		return r.reprocessDeadLetters(ctx, request)
+	default:
+		return &domain.ReprocessResponse{
+			Success: false,
+			Message: fmt.Sprintf("Cannot reprocess events from %q", request.Source),
+		}, ErrUnknownReprocessSource
+	}
+}
+
+// reprocessRaw parses the raw payloads of events again and ingests them as replays of the stored events
+func (r reprocessService) reprocessRaw(ctx context.Context, request *domain.ReprocessRequest) (*domain.ReprocessResponse, error) {
+	rows, err := r.clickhouseDB.GetRawEvents(ctx, request.Tenant, request.IDs)
+	if err != nil {
+		return &domain.ReprocessResponse{
+			Success: false,
+			Message: "Failed to retrieve raw events: " + err.Error(),
+		}, err
+	}
+	byID := make(map[string]database.RawEvent, len(rows))
+	for _, row := range rows {
+		byID[row.EventID] = row
+	}
+
+	results := make([]domain.ReprocessResult, 0, len(request.IDs))
+	for _, id := range request.IDs {
+		row, ok := byID[id]
+		if !ok {
+			results = append(results, domain.ReprocessResult{ID: id, Status: domain.ReprocessNotFound})
+			continue
+		}
+		results = append(results, r.replay(ctx, row, request.Transform))
+	}
+	return &domain.ReprocessResponse{
+		Success: true,
+		Message: "Raw events reprocessed",
+		Results: results,
+	}, nil
+}
+
+// replay parses, transforms, validates and ingests a raw payload. Events violating the rules are reported, not quarantined,
+// their payload stays in events_raw until it expires.
+func (r reprocessService) replay(ctx context.Context, row database.RawEvent, transform *domain.EventTransform) domain.ReprocessResult {
+	var event domain.EventRequest
+	if err := json.Unmarshal([]byte(row.Payload), &event); err != nil {
+		return domain.ReprocessResult{ID: row.EventID, Status: domain.ReprocessFailed, Error: err.Error()}
+	}
+	event.Ingest.Tenant = row.Tenant
+	event.Ingest.Producer = row.Producer
+	event.Ingest.RawBytes = len(row.Payload)
+	event.Ingest.Replay = true
+	return r.reingest(ctx, row.EventID, event, transform)
+}
+
+// reprocessDeadLetters ingests dead-lettered events again, removing the ones that pass the rules from the dead-letter queue.
+// Events still violating the rules or failing to be enqueued stay dead-lettered.
+func (r reprocessService) reprocessDeadLetters(ctx context.Context, request *domain.ReprocessRequest) (*domain.ReprocessResponse, error) {
+	letters, err := r.deadLetters.Get(ctx, request.Tenant, request.IDs)
+	if err != nil {
+		return &domain.ReprocessResponse{
+			Success: false,
+			Message: "Failed to retrieve dead-lettered events: " + err.Error(),
+		}, err
+	}
+
+	results := make([]domain.ReprocessResult, 0, len(request.IDs))
+	var reprocessed []string
+	for _, id := range request.IDs {
+		letter, ok := letters[id]
+		if !ok {
+			results = append(results, domain.ReprocessResult{ID: id, Status: domain.ReprocessNotFound})
+			continue
+		}
+		result := r.reingest(ctx, id, letter.Restore(), request.Transform)
+		results = append(results, result)
+		if result.Status == domain.ReprocessReprocessed {
+			reprocessed = append(reprocessed, id)
+		}
+	}
+
+	if err := r.deadLetters.Remove(ctx, request.Tenant, reprocessed); err != nil {
+		// Reprocessed events stay dead-lettered and would be ingested again, deduplication keeps them from being stored twice
+		return &domain.ReprocessResponse{
+			Success: false,
+			Message: "Failed to remove reprocessed events from the dead-letter queue: " + err.Error(),
+			Results: results,
+		}, err
+	}
+	return &domain.ReprocessResponse{
+		Success: true,
+		Message: "Dead-lettered events reprocessed",
+		Results: results,
+	}, nil
+}
+
+// rewriteDeadLetters writes the events an optional sink failed to write to the sink again, as a single batch, and
+// removes them from its dead-letter queue. They were stored in ClickHouse already, so they are not ingested again.
+func (r reprocessService) rewriteDeadLetters(ctx context.Context, request *domain.ReprocessRequest) (*domain.ReprocessResponse, error) {
+	deadLetters := r.deadLetters.ForSink(request.Sink)
+	letters, err := deadLetters.Get(ctx, request.Tenant, request.IDs)
+	if err != nil {
+		return &domain.ReprocessResponse{
+			Success: false,
+			Message: "Failed to retrieve dead-lettered events: " + err.Error(),
+		}, err
+	}
+
+	results := make([]domain.ReprocessResult, 0, len(request.IDs))
+	var events []domain.EventRequest
+	var found []string
+	for _, id := range request.IDs {
+		letter, ok := letters[id]
+		if !ok {
+			results = append(results, domain.ReprocessResult{ID: id, Status: domain.ReprocessNotFound})
+			continue
+		}
+		events = append(events, letter.Restore())
+		found = append(found, id)
+	}
+	if len(events) == 0 {
+		return &domain.ReprocessResponse{Success: true, Message: "Dead-lettered events reprocessed", Results: results}, nil
+	}
+
+	batch, err := flush.NewBatch(request.Tenant, events)
+	if err == nil {
+		err = r.sinks.Rewrite(ctx, request.Sink, batch)
+	}
+	if errors.Is(err, flush.ErrUnknownSink) {
+		return &domain.ReprocessResponse{
+			Success: false,
+			Message: fmt.Sprintf("Sink %q is not configured", request.Sink),
+		}, ErrUnknownReprocessSource
+	}
+	if err != nil {
+		// The events stay dead-lettered
+		for _, id := range found {
+			results = append(results, domain.ReprocessResult{ID: id, Status: domain.ReprocessFailed, Error: err.Error()})
+		}
+		return &domain.ReprocessResponse{Success: true, Message: "Dead-lettered events reprocessed", Results: results}, nil
+	}
+	for _, id := range found {
+		results = append(results, domain.ReprocessResult{ID: id, Status: domain.ReprocessReprocessed})
+	}
+
+	if err := deadLetters.Remove(ctx, request.Tenant, found); err != nil {
+		// Events still dead-lettered would be written again, the sink's consumers drop them by their event id
+		return &domain.ReprocessResponse{
+			Success: false,
+			Message: "Failed to remove reprocessed events from the dead-letter queue: " + err.Error(),
+			Results: results,
+		}, err
+	}
+	return &domain.ReprocessResponse{
+		Success: true,
+		Message: "Dead-lettered events reprocessed",
+		Results: results,
+	}, nil
+}
+
+// reingest transforms, validates and ingests a raw or dead-lettered event
+func (r reprocessService) reingest(ctx context.Context, id string, event domain.EventRequest, transform *domain.EventTransform) domain.ReprocessResult {
+	result := domain.ReprocessResult{ID: id}
+	event.Ingest.Source = domain.SourceReprocess
+	if transform != nil {
+		if err := transform.Apply(&event); err != nil {
+			result.Status = domain.ReprocessFailed
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	var violations []string
+	if err := validations.ValidateEventRequest(&event); err != nil {
+		violations = []string{err.Error()}
+	} else {
+		violations = validations.CheckQuality(&event)
+	}
+	if len(violations) > 0 {
+		result.Status = domain.ReprocessQuarantined
+		result.Violations = violations
+		return result
+	}
+
+	if _, err := r.eventService.PostEvents(ctx, &event); err != nil {
+		result.Status = domain.ReprocessFailed
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = domain.ReprocessReprocessed
+	return result
+}
+
+// NewReprocessService returns a domain.ReprocessService reading events from the quarantine, the dead-letter queues,
+// and from events_raw if raw payloads are retained.
+func NewReprocessService(quarantineService domain.QuarantineService, db database.ClickHouseDB, eventService domain.EventService, deadLetters *DeadLetterQueue, sinks *flush.Manager, cfg *config.ClickHouseConfig) (domain.ReprocessService, error) {
+	if quarantineService == nil {
+		return nil, fmt.Errorf("quarantine service cannot be nil")
+	}
+	if db.DB == nil {
+		return nil, fmt.Errorf("ClickHouse database connection cannot be nil")
+	}
+	if eventService == nil {
+		return nil, fmt.Errorf("event service cannot be nil")
+	}
+	if deadLetters == nil {
+		return nil, fmt.Errorf("dead-letter queue cannot be nil")
+	}
+	if sinks == nil {
+		return nil, fmt.Errorf("sinks cannot be nil")
+	}
+	return &reprocessService{
+		quarantineService: quarantineService,
+		clickhouseDB:      db,
+		eventService:      eventService,
+		deadLetters:       deadLetters,
+		sinks:             sinks,
+		rawPayloads:       cfg.RawPayloadTTLHours > 0,
+	}, nil
+}
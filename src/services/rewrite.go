@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rewriteLog logs the messages of the RewriteRules
+var rewriteLog = logging.Named("RewriteRules")
+
+var (
+	// ErrRewriteRuleNotFound is returned when a rewrite rule that does not exist is deleted
+	ErrRewriteRuleNotFound = errors.New("rewrite rule not found")
+	// ErrRewriteChain is returned when a rule would rewrite a value another rule rewrites to, or the other way around
+	ErrRewriteChain = errors.New("rewrite rules cannot be chained")
+)
+
+// rewriteBackfillTimeout bounds the rewrite of the stored events of a rule
+const rewriteBackfillTimeout = 6 * time.Hour
+
+var _ domain.RewriteService = &RewriteRules{}
+
+// RewriteRules rename event names and channels of ingested events, so that taxonomy changes don't orphan the events
+// producers still send with the old values. Rules are kept in the metadata store and cached like feature flag overrides,
+// every instance picks up a change within the refresh interval.
+type RewriteRules struct {
+	clickhouseDB    database.ClickHouseDB
+	metadata        database.MetadataStore
+	tenants         *TenantRouter
+	refreshInterval time.Duration
+	// settleDelay is how long a backfill waits for every instance to apply a new rule and flush the events ingested before it
+	settleDelay time.Duration
+
+	mu          sync.Mutex
+	rules       map[string]map[string]string // rewritten value by tenant and field|value
+	refreshedAt time.Time
+	refreshing  bool
+}
+
+// NewRewriteRules creates the rewrite rules and loads the current ones
+func NewRewriteRules(db database.ClickHouseDB, metadata database.MetadataStore, cfg *config.ClickHouseConfig, flagsCfg *config.FlagsConfig, flags *FeatureFlags) (*RewriteRules, error) {
+	if db.DB == nil {
+		return nil, fmt.Errorf("ClickHouse database connection cannot be nil")
+	}
+	if metadata == nil {
+		return nil, fmt.Errorf("metadata store cannot be nil")
+	}
+	tenants, err := NewTenantRouter(db, cfg, flags)
+	if err != nil {
+		return nil, err
+	}
+	refreshInterval := time.Duration(flagsCfg.RefreshIntervalSeconds) * time.Second
+	r := &RewriteRules{
+		clickhouseDB:    db,
+		metadata:        metadata,
+		tenants:         tenants,
+		refreshInterval: refreshInterval,
+		settleDelay:     2*refreshInterval + time.Duration(cfg.FlushIntervalSeconds)*time.Second,
+		rules:           make(map[string]map[string]string),
+	}
+	r.refresh()
+	return r, nil
+}
+
+func rewriteKey(field, from string) string {
+	return field + "|" + from
+}
+
+// Apply rewrites the event names and channels of events in place. It is safe to call on nil, in which case nothing is rewritten.
+func (r *RewriteRules) Apply(events []domain.EventRequest) {
+	if r == nil || len(events) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.refreshing && time.Since(r.refreshedAt) >= r.refreshInterval {
+		r.refreshing = true
+		go r.refresh()
+	}
+	for i := range events {
+		rules := r.rules[events[i].Ingest.Tenant]
+		if rules == nil {
+			continue
+		}
+		if to, ok := rules[rewriteKey(domain.RewriteFieldEventName, events[i].EventName)]; ok {
+			events[i].EventName = to
+		}
+		if to, ok := rules[rewriteKey(domain.RewriteFieldChannel, events[i].Channel)]; ok {
+			events[i].Channel = to
+		}
+	}
+}
+
+// refresh reloads the rules from the metadata store, keeping the cached ones if it is unavailable
+func (r *RewriteRules) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	stored, err := r.metadata.GetRewriteRules(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refreshing = false
+	r.refreshedAt = time.Now()
+	if err != nil {
+		rewriteLog.Warnf("Failed to reload rules, keeping cached ones: %v", err)
+		return
+	}
+	rules := make(map[string]map[string]string)
+	for _, rule := range stored {
+		if rules[rule.Tenant] == nil {
+			rules[rule.Tenant] = make(map[string]string)
+		}
+		rules[rule.Tenant][rewriteKey(rule.Field, rule.From)] = rule.To
+	}
+	r.rules = rules
+}
+
+// ListRewriteRules returns the rewrite rules of a tenant with the status of their backfills
+func (r *RewriteRules) ListRewriteRules(ctx context.Context, tenant string) (*domain.RewriteRulesResponse, error) {
+	rules, err := r.tenantRules(ctx, tenant)
+	if err != nil {
+		return &domain.RewriteRulesResponse{
+			Success: false,
+			Message: "Failed to retrieve rewrite rules: " + err.Error(),
+		}, err
+	}
+	return &domain.RewriteRulesResponse{
+		Success: true,
+		Message: "Rewrite rules retrieved successfully",
+		Rules:   rules,
+	}, nil
+}
+
+// SetRewriteRule creates or replaces a rewrite rule of a tenant. A retroactive rule starts a backfill rewriting the stored events,
+// once every instance applies the rule to the events they ingest.
+func (r *RewriteRules) SetRewriteRule(ctx context.Context, request *domain.RewriteRuleRequest) (*domain.RewriteRulesResponse, error) {
+	rules, err := r.tenantRules(ctx, request.Tenant)
+	if err != nil {
+		return &domain.RewriteRulesResponse{
+			Success: false,
+			Message: "Failed to set rewrite rule: " + err.Error(),
+		}, err
+	}
+	// A chain would rewrite events ingested before and after the middle rule differently
+	for _, rule := range rules {
+		if rule.Field != request.Field || rule.From == request.From {
+			continue
+		}
+		if rule.From == request.To || rule.To == request.From {
+			return &domain.RewriteRulesResponse{
+				Success: false,
+				Message: fmt.Sprintf("Rule %s -> %s would be chained with %s -> %s, rewrite to the final value instead", request.From, request.To, rule.From, rule.To),
+				Rules:   rules,
+			}, ErrRewriteChain
+		}
+	}
+
+	rule := domain.RewriteRule{
+		Tenant:    request.Tenant,
+		Field:     request.Field,
+		From:      request.From,
+		To:        request.To,
+		CreatedAt: time.Now().Unix(),
+	}
+	if request.Retroactive {
+		rule.Backfill = domain.RewriteBackfillPending
+	}
+	if err := r.metadata.SetRewriteRule(ctx, rule); err != nil {
+		return &domain.RewriteRulesResponse{
+			Success: false,
+			Message: "Failed to set rewrite rule: " + err.Error(),
+		}, err
+	}
+	r.refresh()
+	rewriteLog.Infof("%s of tenant %q rewritten from %q to %q", rule.Field, rule.Tenant, rule.From, rule.To)
+	if request.Retroactive {
+		go r.backfill(rule)
+	}
+	return r.ListRewriteRules(ctx, request.Tenant)
+}
+
+// DeleteRewriteRule removes a rewrite rule of a tenant, events keep the value they were stored with
+func (r *RewriteRules) DeleteRewriteRule(ctx context.Context, tenant, field, from string) (*domain.RewriteRulesResponse, error) {
+	deleted, err := r.metadata.DeleteRewriteRule(ctx, tenant, field, from)
+	if err == nil && !deleted {
+		err = ErrRewriteRuleNotFound
+	}
+	if err != nil {
+		return &domain.RewriteRulesResponse{
+			Success: false,
+			Message: "Failed to delete rewrite rule: " + err.Error(),
+		}, err
+	}
+	r.refresh()
+	rewriteLog.Infof("Rewrite of %s %q of tenant %q deleted", field, from, tenant)
+	return r.ListRewriteRules(ctx, tenant)
+}
+
+// tenantRules reads the rules of a tenant from the metadata store, sorted by field and value
+func (r *RewriteRules) tenantRules(ctx context.Context, tenant string) ([]domain.RewriteRule, error) {
+	stored, err := r.metadata.GetRewriteRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]domain.RewriteRule, 0, len(stored))
+	for _, rule := range stored {
+		if rule.Tenant == tenant {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Field != rules[j].Field {
+			return rules[i].Field < rules[j].Field
+		}
+		return rules[i].From < rules[j].From
+	})
+	return rules, nil
+}
+
+// backfill rewrites the stored events of a rule after the settle delay, recording its progress in the rule.
+// It stops if the rule is deleted or replaced meanwhile.
+func (r *RewriteRules) backfill(rule domain.RewriteRule) {
+	time.Sleep(r.settleDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), rewriteBackfillTimeout)
+	defer cancel()
+	running := rule
+	running.Backfill = domain.RewriteBackfillRunning
+	if !r.replace(ctx, rule, running) {
+		return
+	}
+
+	finished := running
+	finished.BackfilledAt = time.Now().Unix()
+	tenantDB, err := r.tenants.Database(ctx, rule.Tenant)
+	if err == nil {
+		finished.BackfillRows, err = r.clickhouseDB.RewriteEvents(ctx, tenantDB, rule.Tenant, rule.Field, rule.From, rule.To)
+	}
+	if err != nil {
+		rewriteLog.Errorf("Failed to rewrite stored %s %q of tenant %q: %v", rule.Field, rule.From, rule.Tenant, err)
+		finished.Backfill = domain.RewriteBackfillFailed
+		finished.BackfillError = err.Error()
+	} else {
+		rewriteLog.Infof("Rewrote %d stored events from %s %q to %q for tenant %q", finished.BackfillRows, rule.Field, rule.From, rule.To, rule.Tenant)
+		finished.Backfill = domain.RewriteBackfillDone
+	}
+	r.replace(context.Background(), running, finished)
+}
+
+// replace updates the stored rule, returns false if it was deleted or replaced meanwhile
+func (r *RewriteRules) replace(ctx context.Context, old, updated domain.RewriteRule) bool {
+	replaced, err := r.metadata.ReplaceRewriteRule(ctx, old, updated)
+	if err != nil {
+		rewriteLog.Errorf("Failed to update the backfill of %s %q of tenant %q: %v", old.Field, old.From, old.Tenant, err)
+		return false
+	}
+	if !replaced {
+		rewriteLog.Infof("Rule for %s %q of tenant %q changed, backfill stopped", old.Field, old.From, old.Tenant)
+	}
+	return replaced
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"kucukaslan/clickhouse/domain"
+	"math"
+	"time"
+)
+
+// Defaults of schema drift requests
+const (
+	defaultDriftDays         = 7
+	defaultDriftBaselineDays = 28
+	defaultDriftMinChange    = 0.2
+)
+
+// GetSchemaDrift compares the metadata keys carried by the events of each name in the recent days with the baseline days before.
+// Keys only seen in one of the periods are new or disappeared, keys whose share of the events changed by at least
+// MinChange are changed. Event names without events in both periods are left out, their drift cannot be told.
+func (e eventService) GetSchemaDrift(ctx context.Context, request *domain.SchemaDriftRequest) (*domain.SchemaDriftResponse, error) {
+	tenantDB, err := e.tenants.Database(ctx, request.Tenant)
+	if err != nil {
+		return &domain.SchemaDriftResponse{
+			Success: false,
+			Message: "Failed to retrieve schema drift: " + err.Error(),
+		}, err
+	}
+
+	days, baselineDays, minChange := request.Days, request.BaselineDays, request.MinChange
+	if days == 0 {
+		days = defaultDriftDays
+	}
+	if baselineDays == 0 {
+		baselineDays = defaultDriftBaselineDays
+	}
+	if minChange == 0 {
+		minChange = defaultDriftMinChange
+	}
+	// Days are ingestion days of the ClickHouse server, assumed to be UTC
+	recent := startOfDay(time.Now().UTC()).AddDate(0, 0, 1-days)
+	baseline := recent.AddDate(0, 0, -baselineDays)
+
+	eventName := ""
+	if request.EventName != nil {
+		eventName = *request.EventName
+	}
+	counts, err := e.clickhouseDB.GetMetadataKeyCounts(ctx, tenantDB, eventName, baseline, recent)
+	if err != nil {
+		return &domain.SchemaDriftResponse{
+			Success: false,
+			Message: "Failed to retrieve schema drift: " + err.Error(),
+		}, err
+	}
+
+	// The empty key counts all events of a name
+	type totals struct{ recent, baseline uint64 }
+	events := make(map[string]totals)
+	for _, count := range counts {
+		if count.Key == "" {
+			events[count.EventName] = totals{count.RecentEvents, count.BaselineEvents}
+		}
+	}
+
+	changes := []domain.SchemaDriftChange{}
+	for _, count := range counts {
+		total := events[count.EventName]
+		if count.Key == "" || total.recent == 0 || total.baseline == 0 {
+			continue
+		}
+		change := domain.SchemaDriftChange{
+			EventName:         count.EventName,
+			Key:               count.Key,
+			RecentEvents:      count.RecentEvents,
+			BaselineEvents:    count.BaselineEvents,
+			RecentFrequency:   float64(count.RecentEvents) / float64(total.recent),
+			BaselineFrequency: float64(count.BaselineEvents) / float64(total.baseline),
+			FirstSeen:         count.FirstSeen.Format(time.DateOnly),
+			LastSeen:          count.LastSeen.Format(time.DateOnly),
+		}
+		switch {
+		case count.BaselineEvents == 0:
+			change.Status = domain.SchemaDriftNew
+		case count.RecentEvents == 0:
+			change.Status = domain.SchemaDriftDisappeared
+		case math.Abs(change.RecentFrequency-change.BaselineFrequency) >= minChange:
+			change.Status = domain.SchemaDriftChanged
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	return &domain.SchemaDriftResponse{
+		Success:       true,
+		Message:       "Schema drift retrieved successfully",
+		BaselineSince: baseline.Format(time.DateOnly),
+		RecentSince:   recent.Format(time.DateOnly),
+		Changes:       changes,
+	}, nil
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/validations"
+	"sync"
+	"time"
+)
+
+// schemasLog logs the messages of the EventSchemas
+var schemasLog = logging.Named("EventSchemas")
+
+// Sources of the JSON Schemas the metadata of events is validated against
+const (
+	SchemaSourceFile       = "file"       // EVENT_SCHEMA_FILE, a JSON file or a directory of <event_name>.json files
+	SchemaSourceClickHouse = "clickhouse" // the event_schemas table, shared by all instances and managed through /schemas
+	SchemaSourceMetadata   = "metadata"   // the metadata store, shared by all instances and managed through /schemas
+)
+
+const (
+	// schemaLoadTimeout bounds a reload of the stored schemas
+	schemaLoadTimeout = 5 * time.Second
+	// schemaVersionPollInterval is how often the version of the schemas is checked in Redis for changes of other instances
+	schemaVersionPollInterval = time.Second
+)
+
+var (
+	// ErrEventSchemaNotFound is returned when a schema that does not exist is updated or deleted
+	ErrEventSchemaNotFound = errors.New("event name has no schema")
+	// ErrEventSchemaExists is returned when a schema is created for an event name that has one
+	ErrEventSchemaExists = errors.New("event name already has a schema")
+	// ErrEventSchemasReadOnly is returned when schemas are managed while they are read from a file
+	ErrEventSchemasReadOnly = errors.New("event schemas are read from EVENT_SCHEMA_FILE, set EVENT_SCHEMA_SOURCE=metadata or clickhouse to manage them")
+)
+
+var (
+	_ domain.EventSchemaService = &EventSchemas{}
+	_ schemaStore               = clickhouseSchemaStore{}
+	_ schemaStore               = database.MetadataStore(nil)
+)
+
+// schemaStore keeps the event schemas managed through /schemas, the metadata store or the event_schemas table
+type schemaStore interface {
+	GetEventSchemas(ctx context.Context, eventName string) ([]domain.EventSchema, error)
+	SetEventSchema(ctx context.Context, schema domain.EventSchema) error
+	DeleteEventSchema(ctx context.Context, eventName string) (bool, error)
+}
+
+// clickhouseSchemaStore keeps the event schemas in the event_schemas table, as versions of their rows
+type clickhouseSchemaStore struct {
+	db database.ClickHouseDB
+}
+
+// GetEventSchemas returns the latest versions of the schemas that are not deleted
+func (c clickhouseSchemaStore) GetEventSchemas(ctx context.Context, eventName string) ([]domain.EventSchema, error) {
+	rows, err := c.db.GetEventSchemas(ctx, eventName)
+	if err != nil {
+		return nil, err
+	}
+	schemas := make([]domain.EventSchema, 0, len(rows))
+	for _, row := range rows {
+		schemas = append(schemas, domain.EventSchema{
+			EventName: row.EventName,
+			Schema:    json.RawMessage(row.Schema),
+			UpdatedAt: row.UpdatedAt,
+		})
+	}
+	return schemas, nil
+}
+
+// SetEventSchema inserts a new version of the schema
+func (c clickhouseSchemaStore) SetEventSchema(ctx context.Context, schema domain.EventSchema) error {
+	return c.db.SaveEventSchema(ctx, database.EventSchemaRow{
+		EventName: schema.EventName,
+		Schema:    string(schema.Schema),
+		UpdatedAt: schema.UpdatedAt,
+	})
+}
+
+// DeleteEventSchema inserts a version of the schema marked deleted, the caller checked that it exists
+func (c clickhouseSchemaStore) DeleteEventSchema(ctx context.Context, eventName string) (bool, error) {
+	err := c.db.SaveEventSchema(ctx, database.EventSchemaRow{
+		EventName: eventName,
+		Schema:    "{}",
+		Deleted:   1,
+		UpdatedAt: time.Now(),
+	})
+	return err == nil, err
+}
+
+// EventSchemas manages the schemas of the metadata store or the event_schemas table and keeps the schema registry in sync with them.
+// The schemas are cached in memory; a change increments their version in Redis, which the other instances poll
+// to reload them right away instead of within the refresh interval.
+type EventSchemas struct {
+	store           schemaStore
+	redisRepo       database.ClickHouseRedis
+	registry        *validations.SchemaRegistry
+	managed         bool // whether the schemas are read from the store, otherwise every call fails with ErrEventSchemasReadOnly
+	refreshInterval time.Duration
+	stop            chan struct{}
+	done            chan struct{}
+
+	mu        sync.RWMutex
+	schemas   []domain.EventSchema // cached schemas by event name
+	version   int64                // version of the cached schemas in Redis
+	refreshMu sync.Mutex           // serializes reloads, so that an older one cannot replace a newer one
+}
+
+// LoadEventSchemas creates the schema registry of the configured source, nil when schema validation is disabled.
+// With the metadata and clickhouse sources, the returned EventSchemas reload the registry in the background once started.
+func LoadEventSchemas(db database.ClickHouseDB, redisClient database.ClickHouseRedis, metadata database.MetadataStore, cfg *config.ValidationConfig) (*validations.SchemaRegistry, *EventSchemas, error) {
+	var store schemaStore
+	switch cfg.SchemaSource {
+	case SchemaSourceFile:
+		var registry *validations.SchemaRegistry
+		if cfg.SchemaFile != "" {
+			var err error
+			if registry, err = validations.LoadSchemaRegistry(cfg.SchemaFile, cfg.SchemaMismatchMode); err != nil {
+				return nil, nil, err
+			}
+		}
+		return registry, &EventSchemas{}, nil
+	case SchemaSourceMetadata:
+		if metadata == nil {
+			return nil, nil, fmt.Errorf("metadata store cannot be nil")
+		}
+		store = metadata
+	case SchemaSourceClickHouse:
+		if db.DB == nil {
+			return nil, nil, fmt.Errorf("ClickHouse database connection cannot be nil")
+		}
+		store = clickhouseSchemaStore{db: db}
+	default:
+		return nil, nil, fmt.Errorf("unknown schema source %q", cfg.SchemaSource)
+	}
+
+	if redisClient.Client == nil {
+		return nil, nil, fmt.Errorf("Redis client cannot be nil")
+	}
+	if cfg.SchemaRefreshSeconds <= 0 {
+		return nil, nil, fmt.Errorf("schema refresh interval must be positive")
+	}
+	registry, err := validations.NewSchemaRegistry(map[string]validations.EventSchema{}, cfg.SchemaMismatchMode)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &EventSchemas{
+		store:           store,
+		redisRepo:       redisClient,
+		registry:        registry,
+		managed:         true,
+		refreshInterval: time.Duration(cfg.SchemaRefreshSeconds) * time.Second,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+	if err := s.refresh(); err != nil {
+		return nil, nil, fmt.Errorf("failed to load event schemas: %w", err)
+	}
+	return registry, s, nil
+}
+
+// refresh reloads the schemas from the store. A schema that does not compile is skipped,
+// so that a single broken schema does not disable the validation of every other event name.
+func (s *EventSchemas) refresh() error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), schemaLoadTimeout)
+	defer cancel()
+	// Read before the store, so that a change made meanwhile is reloaded again at the next poll
+	version, versionErr := s.redisRepo.GetEventSchemasVersion(ctx)
+	stored, err := s.store.GetEventSchemas(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	compiled := make(map[string]validations.EventSchema, len(stored))
+	cached := make([]domain.EventSchema, 0, len(stored))
+	for _, schema := range stored {
+		compiledSchema, err := validations.CompileEventSchema(schema.EventName, schema.Schema)
+		if err != nil {
+			schemasLog.Warnf("Skipping stored schema: %v", err)
+			continue
+		}
+		compiled[schema.EventName] = compiledSchema
+		cached = append(cached, schema)
+	}
+	s.registry.Replace(compiled)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schemas = cached
+	if versionErr == nil {
+		s.version = version
+	}
+	return nil
+}
+
+// poll reloads the schemas if another instance changed them since they were loaded
+func (s *EventSchemas) poll() {
+	ctx, cancel := context.WithTimeout(context.Background(), schemaLoadTimeout)
+	version, err := s.redisRepo.GetEventSchemasVersion(ctx)
+	cancel()
+	if err != nil {
+		return
+	}
+	s.mu.RLock()
+	changed := version != s.version
+	s.mu.RUnlock()
+	if !changed {
+		return
+	}
+	if err := s.refresh(); err != nil {
+		schemasLog.Warnf("Failed to reload changed event schemas, keeping loaded ones: %v", err)
+	}
+}
+
+// Start reloads the schemas in the background when their version changes and every refresh interval,
+// keeping the loaded ones if the store is unavailable. It does nothing if the schemas are read from a file.
+func (s *EventSchemas) Start() {
+	if !s.managed {
+		return
+	}
+	go func() {
+		defer close(s.done)
+		poll := time.NewTicker(schemaVersionPollInterval)
+		defer poll.Stop()
+		refresh := time.NewTicker(s.refreshInterval)
+		defer refresh.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-poll.C:
+				s.poll()
+			case <-refresh.C:
+				if err := s.refresh(); err != nil {
+					schemasLog.Warnf("Failed to reload event schemas, keeping loaded ones: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops reloading the schemas, it must only be called after Start
+func (s *EventSchemas) Stop() {
+	if !s.managed {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// ListEventSchemas returns the cached schemas, only the one of eventName if it is not empty
+func (s *EventSchemas) ListEventSchemas(_ context.Context, eventName string) (*domain.EventSchemaResponse, error) {
+	if !s.managed {
+		return &domain.EventSchemaResponse{
+			Success: false,
+			Message: "Failed to retrieve event schemas: " + ErrEventSchemasReadOnly.Error(),
+		}, ErrEventSchemasReadOnly
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schemas := make([]domain.EventSchema, 0, len(s.schemas))
+	for _, schema := range s.schemas {
+		if eventName == "" || schema.EventName == eventName {
+			schemas = append(schemas, schema)
+		}
+	}
+	return &domain.EventSchemaResponse{
+		Success: true,
+		Message: "Event schemas retrieved successfully",
+		Schemas: schemas,
+	}, nil
+}
+
+// CreateEventSchema stores the schema of an event name that has none
+func (s *EventSchemas) CreateEventSchema(ctx context.Context, request *domain.EventSchemaRequest) (*domain.EventSchemaResponse, error) {
+	return s.save(ctx, request, false, "Event schema created successfully", "Failed to create event schema: ")
+}
+
+// UpdateEventSchema replaces the schema of an event name
+func (s *EventSchemas) UpdateEventSchema(ctx context.Context, request *domain.EventSchemaRequest) (*domain.EventSchemaResponse, error) {
+	return s.save(ctx, request, true, "Event schema updated successfully", "Failed to update event schema: ")
+}
+
+// save stores a new version of the schema of an event name, which must exist already if replace is set and must not otherwise
+func (s *EventSchemas) save(ctx context.Context, request *domain.EventSchemaRequest, replace bool, success, failure string) (*domain.EventSchemaResponse, error) {
+	schema := domain.EventSchema{
+		EventName: request.EventName,
+		Schema:    request.Schema,
+		UpdatedAt: time.Now(),
+	}
+	err := s.check(ctx, request.EventName, replace)
+	if err == nil {
+		err = s.store.SetEventSchema(ctx, schema)
+	}
+	if err != nil {
+		return &domain.EventSchemaResponse{
+			Success: false,
+			Message: failure + err.Error(),
+		}, err
+	}
+	s.invalidate(ctx)
+	return &domain.EventSchemaResponse{
+		Success: true,
+		Message: success,
+		Schemas: []domain.EventSchema{schema},
+	}, nil
+}
+
+// DeleteEventSchema removes the schema of an event name, its events are no longer validated
+func (s *EventSchemas) DeleteEventSchema(ctx context.Context, eventName string) (*domain.EventSchemaResponse, error) {
+	err := s.check(ctx, eventName, true)
+	if err == nil {
+		var deleted bool
+		if deleted, err = s.store.DeleteEventSchema(ctx, eventName); err == nil && !deleted {
+			err = ErrEventSchemaNotFound
+		}
+	}
+	if err != nil {
+		return &domain.EventSchemaResponse{
+			Success: false,
+			Message: "Failed to delete event schema: " + err.Error(),
+		}, err
+	}
+	s.invalidate(ctx)
+	return &domain.EventSchemaResponse{
+		Success: true,
+		Message: "Event schema deleted successfully",
+		Schemas: []domain.EventSchema{},
+	}, nil
+}
+
+// check reads the store, not the cache, to tell whether an event name has a schema as expected
+func (s *EventSchemas) check(ctx context.Context, eventName string, exists bool) error {
+	if !s.managed {
+		return ErrEventSchemasReadOnly
+	}
+	schemas, err := s.store.GetEventSchemas(ctx, eventName)
+	switch {
+	case err != nil:
+		return err
+	case exists && len(schemas) == 0:
+		return ErrEventSchemaNotFound
+	case !exists && len(schemas) > 0:
+		return ErrEventSchemaExists
+	}
+	return nil
+}
+
+// invalidate reloads the schemas of this instance and increments their version for the other instances,
+// which otherwise pick up the change within the refresh interval
+func (s *EventSchemas) invalidate(ctx context.Context) {
+	if _, err := s.redisRepo.IncrementEventSchemasVersion(ctx); err != nil {
+		schemasLog.Warnf("Failed to invalidate the event schemas of the other instances: %v", err)
+	}
+	if err := s.refresh(); err != nil {
+		schemasLog.Warnf("Failed to reload event schemas, keeping loaded ones: %v", err)
+	}
+}
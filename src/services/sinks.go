@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/flush"
+)
+
+var _ flush.Sink = &ClickHouseSink{}
+
+// ClickHouseSink inserts the flushed batches into the events table of the database of their tenant
+type ClickHouseSink struct {
+	name     string
+	db       database.ClickHouseDB
+	tenants  *TenantRouter
+	verifier *FlushVerifier // reads the inserted batches back, nil if disabled
+	closeDB  bool           // whether the connection belongs to the sink and is closed with it
+}
+
+// NewClickHouseSink creates the primary sink, inserting into the tenants' databases through the shared connection
+func NewClickHouseSink(db database.ClickHouseDB, tenants *TenantRouter, verifier *FlushVerifier) *ClickHouseSink {
+	return &ClickHouseSink{name: domain.SinkClickHouse, db: db, tenants: tenants, verifier: verifier}
+}
+
+// NewSecondaryClickHouseSink creates a sink copying the events to a secondary cluster, nil if none is configured.
+// Tenant databases are created there on first use, as in the primary one.
+func NewSecondaryClickHouseSink(cfg *config.SinksConfig, clickHouseCfg *config.ClickHouseConfig, verifier *FlushVerifier, flags *FeatureFlags) (*ClickHouseSink, error) {
+	if cfg.ClickHouseDSN == "" {
+		return nil, nil
+	}
+	db, err := database.ConnectSecondaryClickHouse(clickHouseCfg, cfg.ClickHouseDSN, flags.Enabled(FlagTableEngines))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the secondary ClickHouse: %w", err)
+	}
+	secondaryCfg := *clickHouseCfg
+	secondaryCfg.Cluster = ""
+	tenants, err := NewTenantRouter(db, &secondaryCfg, flags)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &ClickHouseSink{name: domain.SinkClickHouseSecondary, db: db, tenants: tenants, verifier: verifier, closeDB: true}, nil
+}
+
+// Name returns the sink the events are written to
+func (c *ClickHouseSink) Name() string {
+	return c.name
+}
+
+// Write inserts the columns of the batch, resolving the database of its tenant, and queues the batch for its verification
+func (c *ClickHouseSink) Write(ctx context.Context, batch flush.Batch) error {
+	tenantDB, err := c.tenants.Database(ctx, batch.Tenant)
+	if err != nil {
+		return fmt.Errorf("failed to resolve database of tenant %q: %w", batch.Tenant, err)
+	}
+	if err := c.db.SaveColumnarTo(ctx, tenantDB, batch.Columns); err != nil {
+		return err
+	}
+	c.verifier.Enqueue(c.name, c.db, tenantDB, batch.Columns)
+	return nil
+}
+
+// Close closes the connection of a secondary cluster, the shared one is closed on shutdown
+func (c *ClickHouseSink) Close() error {
+	if !c.closeDB {
+		return nil
+	}
+	return c.db.Close()
+}
+
+// sinkRecord is an event written to Kafka or S3, with the attributes assigned at its ingestion
+type sinkRecord struct {
+	ID       string              `json:"id"`
+	BatchID  string              `json:"batch_id"`
+	Tenant   string              `json:"tenant,omitempty"`
+	Producer string              `json:"producer,omitempty"`
+	Source   string              `json:"source,omitempty"`
+	Event    domain.EventRequest `json:"event"`
+}
+
+func newSinkRecord(batch flush.Batch, event domain.EventRequest) sinkRecord {
+	return sinkRecord{
+		ID:       event.EventID(),
+		BatchID:  batch.ID(),
+		Tenant:   event.Ingest.Tenant,
+		Producer: event.Ingest.Producer,
+		Source:   event.Ingest.Source,
+		Event:    event,
+	}
+}
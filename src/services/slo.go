@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sloWindow is a window the burn rate of the error budgets is reported over
+type sloWindow struct {
+	name    string
+	minutes int64
+}
+
+// sloWindows are the windows of the multiwindow burn rate alerts of the Google SRE workbook
+var sloWindows = []sloWindow{
+	{"5m", 5}, {"30m", 30}, {"1h", 60}, {"2h", 120}, {"6h", 360}, {"1d", 1440}, {"3d", 4320},
+}
+
+// sloAlert raises a risk when the budget burns faster than the rate over both windows: the long window shows the
+// budget is being spent, the short one that it still is, so that the risk clears soon after the burn stops.
+// The rates spend 2% of a 30 days budget in 1 hour, 5% in 6 hours, 10% in 1 day and 10% in 3 days.
+type sloAlert struct {
+	long, short string
+	rate        float64
+	risk        string
+}
+
+var sloAlerts = []sloAlert{
+	{"1h", "5m", 14.4, domain.SLORiskFastBurn},
+	{"6h", "30m", 6, domain.SLORiskFastBurn},
+	{"1d", "2h", 3, domain.SLORiskSlowBurn},
+	{"3d", "6h", 1, domain.SLORiskSlowBurn},
+}
+
+var _ domain.SLOService = &sloService{}
+
+// sloService counts the requests of the routes with an objective in per-minute buckets over the period of the
+// error budgets. The counts are kept in memory, per instance, since it started.
+type sloService struct {
+	started    time.Time
+	period     int64                    // minutes
+	objectives map[string]*sloObjective // by route pattern, not modified after creation
+	routes     []string                 // sorted
+}
+
+// sloObjective is the objective of a route with its ring of per-minute counts
+type sloObjective struct {
+	target  float64 // share of good requests, e.g. 0.999
+	latency time.Duration
+
+	mu      sync.Mutex
+	buckets []sloBucket // indexed by minute modulo the period
+}
+
+type sloBucket struct {
+	minute      int64 // unix minute
+	total, good uint64
+}
+
+// NewSLOService creates the service tracking the configured objectives, <route>=<target>@<latency>, e.g. /events=99.9@50ms
+func NewSLOService(cfg *config.SLOConfig) (domain.SLOService, error) {
+	if cfg.PeriodDays <= 0 {
+		return nil, fmt.Errorf("SLO period must be positive, got %d days", cfg.PeriodDays)
+	}
+	s := &sloService{
+		started:    time.Now(),
+		period:     int64(cfg.PeriodDays) * 24 * 60,
+		objectives: make(map[string]*sloObjective, len(cfg.Objectives)),
+	}
+	for route, value := range cfg.Objectives {
+		if !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("route %q of the SLO objectives must start with /", route)
+		}
+		targetValue, latencyValue, ok := strings.Cut(value, "@")
+		if !ok {
+			return nil, fmt.Errorf("invalid SLO objective %q of route %s, expected <target>@<latency> such as 99.9@50ms", value, route)
+		}
+		target, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(targetValue), "%"), 64)
+		if err != nil || target <= 0 || target >= 100 {
+			return nil, fmt.Errorf("invalid SLO target %q of route %s, expected a percentage between 0 and 100", targetValue, route)
+		}
+		latency, err := time.ParseDuration(strings.TrimSpace(latencyValue))
+		if err != nil || latency <= 0 {
+			return nil, fmt.Errorf("invalid SLO latency %q of route %s, expected a duration such as 50ms", latencyValue, route)
+		}
+		s.objectives[route] = &sloObjective{target: target / 100, latency: latency, buckets: make([]sloBucket, s.period)}
+		s.routes = append(s.routes, route)
+	}
+	sort.Strings(s.routes)
+	return s, nil
+}
+
+// RecordRequest counts a request, it is good if it was answered without a server error within the latency threshold
+func (s *sloService) RecordRequest(route string, status int, duration time.Duration) {
+	objective, ok := s.objectives[route]
+	if !ok {
+		return
+	}
+	minute := time.Now().Unix() / 60
+	objective.mu.Lock()
+	defer objective.mu.Unlock()
+	bucket := &objective.buckets[minute%s.period]
+	if bucket.minute != minute {
+		*bucket = sloBucket{minute: minute}
+	}
+	bucket.total++
+	if status < 500 && duration <= objective.latency {
+		bucket.good++
+	}
+}
+
+// GetSLO reports the compliance, budget and burn rates of each objective
+func (s *sloService) GetSLO(_ context.Context) (*domain.SLOResponse, error) {
+	now := time.Now().Unix() / 60
+	resp := &domain.SLOResponse{
+		Success:    true,
+		Message:    "SLO retrieved successfully",
+		Since:      s.started.Unix(),
+		Objectives: make([]domain.SLOStatus, 0, len(s.routes)),
+	}
+	if len(s.routes) == 0 {
+		resp.Message = "No SLO objectives are configured"
+	}
+	for _, route := range s.routes {
+		resp.Objectives = append(resp.Objectives, s.status(route, s.objectives[route], now))
+	}
+	return resp, nil
+}
+
+// status computes the compliance of an objective at a unix minute
+func (s *sloService) status(route string, objective *sloObjective, now int64) domain.SLOStatus {
+	budget := 1 - objective.target
+	status := domain.SLOStatus{
+		Route:           route,
+		Target:          objective.target * 100,
+		LatencyMS:       objective.latency.Milliseconds(),
+		PeriodHours:     int(s.period / 60),
+		BudgetRemaining: 1,
+		Risk:            domain.SLORiskNone,
+		BurnRates:       make([]domain.SLOBurnRate, 0, len(sloWindows)),
+	}
+
+	objective.mu.Lock()
+	status.Requests, status.GoodRequests = objective.count(now, s.period)
+	burnRates := make(map[string]float64, len(sloWindows))
+	for _, window := range sloWindows {
+		if window.minutes > s.period {
+			break
+		}
+		total, good := objective.count(now, window.minutes)
+		rate := domain.SLOBurnRate{Window: window.name, Requests: total, GoodRequests: good, SLI: 100}
+		if total > 0 {
+			rate.SLI = float64(good) / float64(total) * 100
+			rate.BurnRate = float64(total-good) / float64(total) / budget
+		}
+		burnRates[window.name] = rate.BurnRate
+		status.BurnRates = append(status.BurnRates, rate)
+	}
+	objective.mu.Unlock()
+
+	status.SLI = 100
+	if status.Requests > 0 {
+		status.SLI = float64(status.GoodRequests) / float64(status.Requests) * 100
+		status.BudgetRemaining = 1 - float64(status.Requests-status.GoodRequests)/(float64(status.Requests)*budget)
+	}
+	if status.BudgetRemaining <= 0 {
+		status.Risk = domain.SLORiskExhausted
+		return status
+	}
+	for _, alert := range sloAlerts {
+		long, okLong := burnRates[alert.long]
+		short, okShort := burnRates[alert.short]
+		if okLong && okShort && long > alert.rate && short > alert.rate {
+			status.Risk = alert.risk
+			break
+		}
+	}
+	return status
+}
+
+// count sums the requests of the last minutes up to now, the lock must be held
+func (o *sloObjective) count(now, minutes int64) (total, good uint64) {
+	for minute := now - minutes + 1; minute <= now; minute++ {
+		bucket := o.buckets[minute%int64(len(o.buckets))]
+		if bucket.minute == minute {
+			total += bucket.total
+			good += bucket.good
+		}
+	}
+	return total, good
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"strings"
+	"time"
+)
+
+// Defaults of column statistics requests
+const (
+	defaultStatsDays = 7
+	defaultStatsTop  = 10
+)
+
+// lowCardinalityLimit is the number of distinct values up to which LowCardinality dictionaries pay off
+const lowCardinalityLimit = 10_000
+
+// GetColumnStats estimates the distinct and most frequent values of the columns metrics are grouped by,
+// and compares the cardinalities with the LowCardinality types of the events table
+func (e eventService) GetColumnStats(ctx context.Context, request *domain.ColumnStatsRequest) (*domain.ColumnStatsResponse, error) {
+	tenantDB, err := e.tenants.Database(ctx, request.Tenant)
+	if err != nil {
+		return &domain.ColumnStatsResponse{
+			Success: false,
+			Message: "Failed to retrieve column statistics: " + err.Error(),
+		}, err
+	}
+
+	days, top := request.Days, request.Top
+	if days == 0 {
+		days = defaultStatsDays
+	}
+	if top == 0 {
+		top = defaultStatsTop
+	}
+	since := time.Now().UTC().AddDate(0, 0, -days)
+
+	stats, err := e.clickhouseDB.GetColumnStatsFrom(ctx, tenantDB, since, top)
+	if err != nil {
+		return &domain.ColumnStatsResponse{
+			Success: false,
+			Message: "Failed to retrieve column statistics: " + err.Error(),
+		}, err
+	}
+
+	columns := make([]domain.ColumnStats, len(database.StatsColumns))
+	for i, name := range database.StatsColumns {
+		column := domain.ColumnStats{
+			Name:           name,
+			Type:           stats.Types[i],
+			Cardinality:    stats.Cardinalities[i],
+			LowCardinality: strings.Contains(stats.Types[i], "LowCardinality"),
+			TopValues:      make([]domain.ValueCount, len(stats.TopValues[i])),
+		}
+		column.Recommendation = cardinalityRecommendation(column)
+
+		for j, value := range stats.TopValues[i] {
+			column.TopValues[j] = domain.ValueCount{Value: value, Count: stats.TopCounts[i][j]}
+			if stats.TotalEvents > 0 {
+				column.TopValues[j].Share = float64(stats.TopCounts[i][j]) / float64(stats.TotalEvents)
+			}
+		}
+		columns[i] = column
+	}
+
+	return &domain.ColumnStatsResponse{
+		Success:     true,
+		Message:     "Column statistics retrieved successfully",
+		Since:       since.Unix(),
+		TotalEvents: stats.TotalEvents,
+		Columns:     columns,
+	}, nil
+}
+
+// cardinalityRecommendation tells whether the type of a string column suits its estimated cardinality, empty if it does
+func cardinalityRecommendation(column domain.ColumnStats) string {
+	if !strings.Contains(column.Type, "String") {
+		return ""
+	}
+	switch {
+	case column.LowCardinality && column.Cardinality > lowCardinalityLimit:
+		return fmt.Sprintf("More than %d distinct values, a plain String would be cheaper than LowCardinality", lowCardinalityLimit)
+	case !column.LowCardinality && column.Cardinality > 0 && column.Cardinality <= lowCardinalityLimit:
+		return fmt.Sprintf("Fewer than %d distinct values, LowCardinality(String) would compress better", lowCardinalityLimit)
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/telemetry"
+	"time"
+)
+
+var (
+	flushDurationSeconds = telemetry.NewHistogramVec("flush_duration_seconds",
+		"Duration of the batch flushes to ClickHouse, including deduplication and rate limiting", telemetry.DefaultBuckets, "result")
+	clickhouseInsertErrorsTotal = telemetry.NewCounterVec("clickhouse_insert_errors_total",
+		"Number of failed inserts into ClickHouse", "table")
+	redisLookupsTotal = telemetry.NewCounterVec("redis_lookups_total",
+		"Number of keys looked up in Redis by cache and result (hit, miss, error)", "cache", "result")
+	flushRetriesTotal = telemetry.NewCounterVec("flush_retries_total",
+		"Number of retried inserts of the events of a tenant after a failed flush")
+	deadLetteredEventsTotal = telemetry.NewCounterVec("dead_lettered_events_total",
+		"Number of events of failed flushes by whether they were kept in the dead-letter queue (stored) or lost (error)", "result")
+	overflowEventsTotal = telemetry.NewCounterVec("overflow_events_total",
+		"Number of events of a full buffer by whether they were spilled to disk, drained back, rejected because the overflow is full, or failed (error)", "result")
+	backfillEventsTotal = telemetry.NewCounterVec("backfill_events_total",
+		"Number of events of backfill jobs by whether they were received, written to their day partition or skipped as already stored", "result")
+	apiKeyRejectionsTotal = telemetry.NewCounterVec("api_key_rejections_total",
+		"Number of requests rejected by API key authentication by reason (missing, unknown, rate_limited, quota_exceeded, read_only)", "reason")
+)
+
+// Caches looked up in Redis
+const redisCacheProcessedEvents = "processed_events"
+
+// recordFlush accounts a batch flush that started at start
+func recordFlush(start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	flushDurationSeconds.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+// recordInsertError accounts a failed insert into a ClickHouse table
+func recordInsertError(table string) {
+	clickhouseInsertErrorsTotal.WithLabelValues(table).Inc()
+}
+
+// recordProcessedLookups accounts the lookups of events in the processed events cache,
+// processed holds the result of the lookup by unique key
+func recordProcessedLookups(events []domain.EventRequest, processed map[string]bool, err error) {
+	if err != nil {
+		redisLookupsTotal.WithLabelValues(redisCacheProcessedEvents, "error").Add(float64(len(events)))
+		return
+	}
+	hits := 0
+	for _, event := range events {
+		if processed[event.GetUniqueKey()] {
+			hits++
+		}
+	}
+	redisLookupsTotal.WithLabelValues(redisCacheProcessedEvents, "hit").Add(float64(hits))
+	redisLookupsTotal.WithLabelValues(redisCacheProcessedEvents, "miss").Add(float64(len(events) - hits))
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/logging"
+	"sync"
+)
+
+// tenantLog logs the messages of the TenantRouter
+var tenantLog = logging.Named("TenantRouter")
+
+// TenantRouter maps tenants to their ClickHouse databases, creating them with the standard schema on first use.
+// All tenants share the same connection.
+type TenantRouter struct {
+	clickhouseDB database.ClickHouseDB
+	cfg          *config.ClickHouseConfig
+	flags        *FeatureFlags
+
+	mu      sync.Mutex
+	created map[string]bool // databases known to exist
+}
+
+// NewTenantRouter creates a router for the configured tenant isolation, nil if isolation is disabled.
+// The table_engines flag of the flags decides whether new databases get the migrations changing the layout of their events table.
+func NewTenantRouter(db database.ClickHouseDB, cfg *config.ClickHouseConfig, flags *FeatureFlags) (*TenantRouter, error) {
+	if !cfg.TenantIsolation {
+		return nil, nil
+	}
+	for tenant, name := range cfg.TenantDatabases {
+		if !database.DatabaseNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid database name %q for tenant %q", name, tenant)
+		}
+	}
+	if cfg.TenantDatabasePrefix != "" && !database.DatabaseNamePattern.MatchString(cfg.TenantDatabasePrefix) {
+		return nil, fmt.Errorf("invalid tenant database prefix %q", cfg.TenantDatabasePrefix)
+	}
+	return &TenantRouter{
+		clickhouseDB: db,
+		cfg:          cfg,
+		flags:        flags,
+		created:      make(map[string]bool),
+	}, nil
+}
+
+// Database returns the database of a tenant, creating it on first use.
+// The default tenant, and every tenant when the router is nil, use the connection's database ("").
+func (t *TenantRouter) Database(ctx context.Context, tenant string) (string, error) {
+	if t == nil || tenant == "" {
+		return "", nil
+	}
+
+	name, ok := t.cfg.TenantDatabases[tenant]
+	if !ok {
+		name = t.cfg.TenantDatabasePrefix + tenant
+	}
+	if !database.DatabaseNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid database name %q for tenant %q", name, tenant)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.created[name] {
+		return name, nil
+	}
+	// Creation is idempotent, holding the lock only keeps concurrent flushes from repeating it
+	if err := t.clickhouseDB.CreateTenantDatabase(ctx, name, t.cfg, t.flags.Enabled(FlagTableEngines)); err != nil {
+		return "", err
+	}
+	t.created[name] = true
+	tenantLog.Infof("Database %s ready for tenant %s", name, tenant)
+	return name, nil
+}
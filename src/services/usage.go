@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/telemetry"
+	"sort"
+)
+
+var (
+	ingestedEventsTotal = telemetry.NewCounterVec("events_ingested_total",
+		"Number of events accepted for ingestion", "channel", "event_name")
+	ingestedRawBytesTotal = telemetry.NewCounterVec("events_ingested_raw_bytes_total",
+		"Raw JSON bytes of the events accepted for ingestion", "channel", "event_name")
+	storedEventsTotal = telemetry.NewCounterVec("events_stored_total",
+		"Number of events written to ClickHouse", "channel", "event_name")
+	storedBytesTotal = telemetry.NewCounterVec("events_stored_bytes_total",
+		"Estimated uncompressed bytes of the events written to ClickHouse", "channel", "event_name")
+	flushBatchRawBytes = telemetry.NewHistogramVec("flush_batch_raw_bytes",
+		"Raw JSON bytes of the batches written to ClickHouse", telemetry.SizeBuckets)
+	flushBatchStoredBytes = telemetry.NewHistogramVec("flush_batch_stored_bytes",
+		"Estimated uncompressed bytes of the batches written to ClickHouse", telemetry.SizeBuckets)
+)
+
+// recordIngested accounts events accepted for ingestion
+func recordIngested(events []domain.EventRequest) {
+	for _, event := range events {
+		ingestedEventsTotal.WithLabelValues(event.Channel, event.EventName).Inc()
+		ingestedRawBytesTotal.WithLabelValues(event.Channel, event.EventName).Add(float64(event.Ingest.RawBytes))
+	}
+}
+
+// recordStored accounts events written to ClickHouse, columns must hold the same events in the same order
+func recordStored(events []domain.EventRequest, columns *database.EventColumnar) {
+	rawBytes, storedBytes := 0, 0
+	for i, event := range events {
+		rowSize := columns.RowSize(i)
+		storedEventsTotal.WithLabelValues(event.Channel, event.EventName).Inc()
+		storedBytesTotal.WithLabelValues(event.Channel, event.EventName).Add(float64(rowSize))
+		rawBytes += event.Ingest.RawBytes
+		storedBytes += rowSize
+	}
+	flushBatchRawBytes.WithLabelValues().Observe(float64(rawBytes))
+	flushBatchStoredBytes.WithLabelValues().Observe(float64(storedBytes))
+}
+
+var _ domain.UsageService = &usageService{}
+
+type usageService struct {
+	quotas     *QuotaEnforcer
+	duplicates *DuplicateTracker
+}
+
+// GetUsage reports the payload and storage usage per channel and event name since the service started,
+// and the duplicates received today per API key and channel
+func (u usageService) GetUsage(ctx context.Context) (*domain.UsageResponse, error) {
+	entries := make(map[[2]string]*domain.UsageEntry)
+	entry := func(labels map[string]string) *domain.UsageEntry {
+		key := [2]string{labels["channel"], labels["event_name"]}
+		if e, ok := entries[key]; ok {
+			return e
+		}
+		e := &domain.UsageEntry{Channel: key[0], EventName: key[1]}
+		entries[key] = e
+		return e
+	}
+
+	for _, sample := range ingestedEventsTotal.Snapshot() {
+		entry(sample.Labels).IngestedEvents = uint64(sample.Value)
+	}
+	for _, sample := range ingestedRawBytesTotal.Snapshot() {
+		entry(sample.Labels).IngestedRawBytes = uint64(sample.Value)
+	}
+	for _, sample := range storedEventsTotal.Snapshot() {
+		entry(sample.Labels).StoredEvents = uint64(sample.Value)
+	}
+	for _, sample := range storedBytesTotal.Snapshot() {
+		entry(sample.Labels).StoredBytes = uint64(sample.Value)
+	}
+
+	usage := make([]domain.UsageEntry, 0, len(entries))
+	for _, e := range entries {
+		usage = append(usage, *e)
+	}
+	// Biggest storage consumers first
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].StoredBytes != usage[j].StoredBytes {
+			return usage[i].StoredBytes > usage[j].StoredBytes
+		}
+		if usage[i].Channel != usage[j].Channel {
+			return usage[i].Channel < usage[j].Channel
+		}
+		return usage[i].EventName < usage[j].EventName
+	})
+
+	duplicates, err := u.duplicates.Duplicates(ctx)
+	if err != nil {
+		return &domain.UsageResponse{
+			Success: false,
+			Message: "Failed to retrieve duplicates: " + err.Error(),
+			Usage:   usage,
+		}, err
+	}
+
+	return &domain.UsageResponse{
+		Success:    true,
+		Message:    "Usage retrieved successfully",
+		Usage:      usage,
+		Duplicates: duplicates,
+	}, nil
+}
+
+// GetQuota reports the stored usage of a tenant in the current month against its caps
+func (u usageService) GetQuota(ctx context.Context, tenant string) (*domain.QuotaResponse, error) {
+	return u.quotas.GetQuota(ctx, tenant)
+}
+
+// NewUsageService returns a domain.UsageService reporting the usage accounted by this instance,
+// the duplicates received by all instances and the monthly quotas of the tenants.
+func NewUsageService(quotas *QuotaEnforcer, duplicates *DuplicateTracker) domain.UsageService {
+	return &usageService{quotas: quotas, duplicates: duplicates}
+}
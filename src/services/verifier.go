@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/telemetry"
+	"time"
+)
+
+// verifyLog logs the messages of the FlushVerifier
+var verifyLog = logging.Named("FlushVerifier")
+
+// verifyTimeout bounds the read of the rows of a batch
+const verifyTimeout = 10 * time.Second
+
+var (
+	flushVerificationsTotal = telemetry.NewCounterVec("flush_verifications_total",
+		"Flushed batches read back by batch_id, by sink and result: ok, mismatch, error or skipped when the queue was full", "sink", "result")
+	flushVerificationMissingRows = telemetry.NewCounterVec("flush_verification_missing_rows_total",
+		"Rows of verified batches that were inserted without error but not found in ClickHouse", "sink")
+)
+
+// pendingVerification is an inserted batch waiting to be read back
+type pendingVerification struct {
+	sink     string
+	db       database.ClickHouseDB
+	database string
+	batchID  string
+	expected database.BatchChecksum
+	due      time.Time
+}
+
+// FlushVerifier reads the flushed batches back from ClickHouse by their batch_id some time after their insert and compares
+// their rows and checksum with the ones sent, to detect inserts that succeeded but were silently truncated or lost.
+// Mismatches are logged and counted, the events are not written again: the raw payloads or the sources are the way back.
+//
+// A batch retried after a failed insert keeps its batch_id and is queued once, by the attempt that succeeded. Rows of the
+// failed attempts that were stored anyway share their sorting keys with the rows of that attempt and an earlier ingested_at,
+// they are replaced by them and not counted.
+type FlushVerifier struct {
+	delay time.Duration
+	queue chan pendingVerification
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewFlushVerifier creates the verifier of the flushed batches, nil if it is disabled
+func NewFlushVerifier(cfg *config.ClickHouseConfig) (*FlushVerifier, error) {
+	if cfg.FlushVerifyDelaySeconds <= 0 {
+		return nil, nil
+	}
+	if cfg.FlushVerifyQueueSize <= 0 {
+		return nil, fmt.Errorf("flush verify queue size must be positive, got %d", cfg.FlushVerifyQueueSize)
+	}
+	return &FlushVerifier{
+		delay: time.Duration(cfg.FlushVerifyDelaySeconds) * time.Second,
+		queue: make(chan pendingVerification, cfg.FlushVerifyQueueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}, nil
+}
+
+// Start verifies the queued batches in the background once their delay passed, in the order they were inserted
+func (v *FlushVerifier) Start() {
+	if v == nil {
+		return
+	}
+	go func() {
+		defer close(v.done)
+		for {
+			select {
+			case <-v.stop:
+				return
+			case pending := <-v.queue:
+				timer := time.NewTimer(time.Until(pending.due))
+				select {
+				case <-v.stop:
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+				v.verify(pending)
+			}
+		}
+	}()
+}
+
+// Stop stops verifying, the batches still queued are not verified. It must only be called after Start.
+func (v *FlushVerifier) Stop() {
+	if v == nil {
+		return
+	}
+	close(v.stop)
+	<-v.done
+	if pending := len(v.queue); pending > 0 {
+		verifyLog.Infof("Stopped with %d flushed batches not verified", pending)
+	}
+}
+
+// Enqueue queues an inserted batch for its verification, skipping it if the queue is full.
+// The checksum is computed right away, the columns may be reused once the flush is done.
+func (v *FlushVerifier) Enqueue(sink string, db database.ClickHouseDB, tenantDB string, columns *database.EventColumnar) {
+	if v == nil || columns.Len() == 0 {
+		return
+	}
+	pending := pendingVerification{
+		sink:     sink,
+		db:       db,
+		database: tenantDB,
+		batchID:  columns.Batch(),
+		expected: database.NewBatchChecksum(columns),
+		due:      time.Now().Add(v.delay),
+	}
+	select {
+	case v.queue <- pending:
+	default:
+		flushVerificationsTotal.WithLabelValues(sink, "skipped").Inc()
+	}
+}
+
+// verify compares the rows stored with the batch_id of a batch with the ones inserted
+func (v *FlushVerifier) verify(pending pendingVerification) {
+	ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+	defer cancel()
+	expected := pending.expected
+	stored, err := pending.db.GetBatchChecksum(ctx, pending.database, pending.batchID, expected.From, expected.To)
+	if err != nil {
+		flushVerificationsTotal.WithLabelValues(pending.sink, "error").Inc()
+		verifyLog.Warnf("Failed to verify batch %s of sink %s: %v", pending.batchID, pending.sink, err)
+		return
+	}
+	if stored.Rows == expected.Rows && stored.Checksum == expected.Checksum {
+		flushVerificationsTotal.WithLabelValues(pending.sink, "ok").Inc()
+		return
+	}
+	flushVerificationsTotal.WithLabelValues(pending.sink, "mismatch").Inc()
+	if stored.Rows < expected.Rows {
+		flushVerificationMissingRows.WithLabelValues(pending.sink).Add(float64(expected.Rows - stored.Rows))
+	}
+	verifyLog.Errorf("Batch %s of sink %s does not match its insert into %s: %d rows with checksum %d stored, %d rows with checksum %d inserted",
+		pending.batchID, pending.sink, tableName(pending.database), stored.Rows, stored.Checksum, expected.Rows, expected.Checksum)
+}
+
+// tableName names the events table of a database in the messages, of the connection's database if empty
+func tableName(tenantDB string) string {
+	if tenantDB == "" {
+		return "events"
+	}
+	return tenantDB + ".events"
+}
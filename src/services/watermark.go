@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"kucukaslan/clickhouse/domain"
+	"sync"
+	"time"
+)
+
+// pendingTimes counts the events held in memory per tenant and second of their event time,
+// from the moment they are enqueued until their flush completes, whether it succeeded or not
+type pendingTimes struct {
+	mu     sync.Mutex
+	counts map[string]map[int64]int
+}
+
+func newPendingTimes() *pendingTimes {
+	return &pendingTimes{counts: make(map[string]map[int64]int)}
+}
+
+func (p *pendingTimes) add(event domain.EventRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seconds := p.counts[event.Ingest.Tenant]
+	if seconds == nil {
+		seconds = make(map[int64]int)
+		p.counts[event.Ingest.Tenant] = seconds
+	}
+	seconds[event.EventTime().Unix()]++
+}
+
+func (p *pendingTimes) remove(events ...domain.EventRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, event := range events {
+		seconds := p.counts[event.Ingest.Tenant]
+		second := event.EventTime().Unix()
+		if seconds[second] <= 1 {
+			delete(seconds, second)
+		} else {
+			seconds[second]--
+		}
+		if len(seconds) == 0 {
+			delete(p.counts, event.Ingest.Tenant)
+		}
+	}
+}
+
+// earliest returns the earliest event time of a tenant's pending events and their number, the zero time if there are none
+func (p *pendingTimes) earliest(tenant string) (time.Time, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var earliest int64
+	total := 0
+	for second, count := range p.counts[tenant] {
+		if total == 0 || second < earliest {
+			earliest = second
+		}
+		total += count
+	}
+	if total == 0 {
+		return time.Time{}, 0
+	}
+	return time.Unix(earliest, 0), total
+}
+
+// GetIngestionWatermark tells how fresh the stored events of a tenant are: the latest ingested_at of its events in ClickHouse,
+// and the earliest event time of its events accepted by this instance and not yet flushed. Events of the tenant older than
+// the earliest pending one are stored, as far as this instance is concerned.
+func (e eventService) GetIngestionWatermark(ctx context.Context, tenant string) (*domain.WatermarkResponse, error) {
+	tenantDB, err := e.tenants.Database(ctx, tenant)
+	if err != nil {
+		return &domain.WatermarkResponse{
+			Success: false,
+			Message: "Failed to retrieve ingestion watermark: " + err.Error(),
+		}, err
+	}
+	flushed, err := e.clickhouseDB.GetIngestionWatermark(ctx, tenantDB)
+	if err != nil {
+		return &domain.WatermarkResponse{
+			Success: false,
+			Message: "Failed to retrieve ingestion watermark: " + err.Error(),
+		}, err
+	}
+
+	resp := &domain.WatermarkResponse{
+		Success: true,
+		Message: "Ingestion watermark retrieved successfully",
+		Tenant:  tenant,
+	}
+	if !flushed.IsZero() {
+		resp.FlushedUntil = flushed.Unix()
+	}
+	earliest, pending := e.batcher.EarliestPending(tenant)
+	resp.PendingEvents = pending
+	if pending > 0 {
+		resp.EarliestPending = earliest.Unix()
+	}
+	return resp, nil
+}
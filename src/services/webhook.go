@@ -0,0 +1,266 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"kucukaslan/clickhouse/buildinfo"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/validations"
+	"net"
+	"net/http"
+	"slices"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookTimeout = 5 * time.Second
+	// webhookAttempts is the number of delivery attempts, retried with a doubling backoff starting at one second
+	webhookAttempts = 3
+	// maxConcurrentWebhooks bounds the deliveries in flight, notifications beyond it are dropped
+	maxConcurrentWebhooks = 32
+)
+
+var _ domain.WebhookService = &webhookService{}
+
+type webhookService struct {
+	metadata database.MetadataStore
+}
+
+// RegisterWebhook registers the webhook of a producer with a new signing secret, replacing any existing one
+func (w webhookService) RegisterWebhook(ctx context.Context, producer string, request *domain.WebhookRequest) (*domain.WebhookResponse, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return &domain.WebhookResponse{
+			Success: false,
+			Message: "Failed to generate webhook secret: " + err.Error(),
+		}, err
+	}
+
+	events := request.Events
+	if len(events) == 0 {
+		events = []string{domain.WebhookBatchFlushed, domain.WebhookBatchDeadLettered}
+	}
+	webhook := domain.Webhook{URL: request.URL, Events: events, Secret: secret}
+	if err := w.metadata.SetWebhook(ctx, producer, webhook); err != nil {
+		return &domain.WebhookResponse{
+			Success: false,
+			Message: "Failed to register webhook: " + err.Error(),
+		}, err
+	}
+
+	return &domain.WebhookResponse{
+		Success: true,
+		Message: "Webhook registered successfully",
+		Webhook: &webhook,
+	}, nil
+}
+
+// GetWebhook returns the webhook of a producer, without its secret
+func (w webhookService) GetWebhook(ctx context.Context, producer string) (*domain.WebhookResponse, error) {
+	webhook, err := w.metadata.GetWebhook(ctx, producer)
+	if err != nil {
+		return &domain.WebhookResponse{
+			Success: false,
+			Message: "Failed to retrieve webhook: " + err.Error(),
+		}, err
+	}
+	if webhook == nil {
+		return &domain.WebhookResponse{
+			Success: true,
+			Message: "No webhook registered",
+		}, nil
+	}
+
+	webhook.Secret = ""
+	return &domain.WebhookResponse{
+		Success: true,
+		Message: "Webhook retrieved successfully",
+		Webhook: webhook,
+	}, nil
+}
+
+// DeleteWebhook removes the webhook of a producer
+func (w webhookService) DeleteWebhook(ctx context.Context, producer string) (*domain.WebhookResponse, error) {
+	deleted, err := w.metadata.DeleteWebhook(ctx, producer)
+	if err != nil {
+		return &domain.WebhookResponse{
+			Success: false,
+			Message: "Failed to delete webhook: " + err.Error(),
+		}, err
+	}
+	if !deleted {
+		return &domain.WebhookResponse{
+			Success: true,
+			Message: "No webhook registered",
+		}, nil
+	}
+	return &domain.WebhookResponse{
+		Success: true,
+		Message: "Webhook deleted successfully",
+	}, nil
+}
+
+// NewWebhookService returns a domain.WebhookService storing webhooks in the metadata store.
+func NewWebhookService(metadata database.MetadataStore) (domain.WebhookService, error) {
+	if metadata == nil {
+		return nil, fmt.Errorf("metadata store cannot be nil")
+	}
+	return &webhookService{metadata: metadata}, nil
+}
+
+// WebhookNotifier notifies producers when batches containing their events are flushed or dead-lettered.
+// Deliveries are asynchronous and best effort, they never delay or fail a flush.
+type WebhookNotifier struct {
+	metadata database.MetadataStore
+	client   *http.Client
+	inFlight chan struct{}
+}
+
+// NewWebhookNotifier creates a notifier looking up webhooks in the metadata store
+func NewWebhookNotifier(metadata database.MetadataStore) *WebhookNotifier {
+	// The address is checked once resolved, when connecting, so that a name resolving to a public address on registration
+	// cannot resolve to an internal one on delivery. Redirects are connected to the same way.
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !validations.IsPublicAddress(net.ParseIP(host)) {
+				return fmt.Errorf("webhook address %s is not a public address", host)
+			}
+			return nil
+		},
+	}
+	return &WebhookNotifier{
+		metadata: metadata,
+		client: &http.Client{
+			Timeout:   webhookTimeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: webhookTimeout},
+		},
+		inFlight: make(chan struct{}, maxConcurrentWebhooks),
+	}
+}
+
+// NotifyBatch notifies the producers of the events in a batch of its flush result.
+// A failed flush is reported as dead-lettered. It is safe to call on a nil notifier.
+func (n *WebhookNotifier) NotifyBatch(events []domain.EventRequest, flushErr error) {
+	if n == nil {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, event := range events {
+		if event.Ingest.Producer != "" {
+			counts[event.Ingest.Producer]++
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+
+	token, err := randomHex(16)
+	if err != nil {
+		batcherLog.Errorf("Failed to generate batch token for webhooks: %v", err)
+		return
+	}
+	notification := domain.WebhookNotification{
+		Type:       domain.WebhookBatchFlushed,
+		BatchToken: token,
+		BatchSize:  len(events),
+		Timestamp:  time.Now().Unix(),
+		Pod:        buildinfo.GetPod(),
+	}
+	if flushErr != nil {
+		notification.Type = domain.WebhookBatchDeadLettered
+		notification.Error = flushErr.Error()
+	}
+
+	for producer, count := range counts {
+		notification.Count = count
+		select {
+		case n.inFlight <- struct{}{}:
+			go func(producer string, notification domain.WebhookNotification) {
+				defer func() { <-n.inFlight }()
+				n.deliver(producer, notification)
+			}(producer, notification)
+		default:
+			batcherLog.Warnf("Too many webhooks in flight, dropping %s notification of batch %s", notification.Type, token)
+		}
+	}
+}
+
+// deliver posts a notification to the producer's webhook if it subscribed to its type
+func (n *WebhookNotifier) deliver(producer string, notification domain.WebhookNotification) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	webhook, err := n.metadata.GetWebhook(ctx, producer)
+	cancel()
+	if err != nil {
+		batcherLog.Errorf("Failed to look up webhook of producer %s: %v", producer, err)
+		return
+	}
+	if webhook == nil || !slices.Contains(webhook.Events, notification.Type) {
+		return
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		batcherLog.Errorf("Failed to encode webhook notification: %v", err)
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = n.post(webhook.URL, body, signature)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	batcherLog.Errorf("Failed to deliver %s webhook of batch %s to producer %s: %v",
+		notification.Type, notification.BatchToken, producer, err)
+}
+
+func (n *WebhookNotifier) post(url string, body []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Signature", signature)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,414 @@
+// Package telemetry provides counters, gauges and histograms for internal service telemetry,
+// rendered in the Prometheus text exposition format.
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the default histogram buckets, suited for durations in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SizeBuckets are histogram buckets suited for payload sizes in bytes
+var SizeBuckets = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+
+// DefaultRegistry is the registry metrics are registered to by the New* constructors
+var DefaultRegistry = NewRegistry()
+
+// collector is a metric family that can render itself
+type collector interface {
+	name() string
+	write(w io.Writer) error
+}
+
+// Registry holds metric families and renders them
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.collectors[c.name()]; exists {
+		panic(fmt.Sprintf("telemetry: metric %q is already registered", c.name()))
+	}
+	r.collectors[c.name()] = c
+}
+
+// WriteText renders all metrics in the Prometheus text exposition format, sorted by name
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	collectors := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+	for _, c := range collectors {
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sample is the current value of a single series
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// vec keeps one series per combination of label values
+type vec[T any] struct {
+	metricName string
+	help       string
+	labels     []string
+	newSeries  func() *T
+
+	mu     sync.RWMutex
+	series map[string]*T
+	values map[string][]string
+}
+
+func newVec[T any](name, help string, labels []string, newSeries func() *T) *vec[T] {
+	return &vec[T]{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		newSeries:  newSeries,
+		series:     make(map[string]*T),
+		values:     make(map[string][]string),
+	}
+}
+
+func (v *vec[T]) name() string {
+	return v.metricName
+}
+
+func (v *vec[T]) with(values ...string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("telemetry: metric %q expects %d label values, got %d", v.metricName, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok = v.series[key]; !ok {
+		s = v.newSeries()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each calls fn for every series, sorted by label values
+func (v *vec[T]) each(fn func(values []string, s *T) error) error {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.mu.RLock()
+		s, values := v.series[key], v.values[key]
+		v.mu.RUnlock()
+		if err := fn(values, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *vec[T]) writeHeader(w io.Writer, metricType string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.metricName, escapeHelp(v.help), v.metricName, metricType)
+	return err
+}
+
+func (v *vec[T]) labelMap(values []string) map[string]string {
+	labels := make(map[string]string, len(v.labels))
+	for i, label := range v.labels {
+		labels[label] = values[i]
+	}
+	return labels
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	bits atomic.Uint64
+}
+
+// Add increases the counter by delta, which must not be negative
+func (c *Counter) Add(delta float64) {
+	addFloat(&c.bits, delta)
+}
+
+// Inc increases the counter by one
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// NewCounterVec creates a counter family and registers it to the DefaultRegistry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labels, func() *Counter { return &Counter{} })}
+	DefaultRegistry.register(c)
+	return c
+}
+
+// WithLabelValues returns the counter for the given label values, creating it if needed
+func (c *CounterVec) WithLabelValues(values ...string) *Counter {
+	return c.with(values...)
+}
+
+// Snapshot returns the current value of every series
+func (c *CounterVec) Snapshot() []Sample {
+	var samples []Sample
+	_ = c.each(func(values []string, s *Counter) error {
+		samples = append(samples, Sample{Labels: c.labelMap(values), Value: s.Value()})
+		return nil
+	})
+	return samples
+}
+
+func (c *CounterVec) write(w io.Writer) error {
+	if err := c.writeHeader(w, "counter"); err != nil {
+		return err
+	}
+	return c.each(func(values []string, s *Counter) error {
+		return writeSample(w, c.metricName, c.labels, values, "", "", s.Value())
+	})
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+// Set sets the gauge to the given value
+func (g *Gauge) Set(value float64) {
+	g.bits.Store(math.Float64bits(value))
+}
+
+// Add adds delta, which may be negative, to the gauge
+func (g *Gauge) Add(delta float64) {
+	addFloat(&g.bits, delta)
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+// NewGaugeVec creates a gauge family and registers it to the DefaultRegistry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, labels, func() *Gauge { return &Gauge{} })}
+	DefaultRegistry.register(g)
+	return g
+}
+
+// WithLabelValues returns the gauge for the given label values, creating it if needed
+func (g *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return g.with(values...)
+}
+
+func (g *GaugeVec) write(w io.Writer) error {
+	if err := g.writeHeader(w, "gauge"); err != nil {
+		return err
+	}
+	return g.each(func(values []string, s *Gauge) error {
+		return writeSample(w, g.metricName, g.labels, values, "", "", s.Value())
+	})
+}
+
+// GaugeFunc is a gauge whose value is read from a callback at scrape time
+type GaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+// NewGaugeFunc creates a callback gauge and registers it to the DefaultRegistry
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, fn: fn}
+	DefaultRegistry.register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *GaugeFunc) write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.metricName, escapeHelp(g.help), g.metricName); err != nil {
+		return err
+	}
+	return writeSample(w, g.metricName, nil, nil, "", "", g.fn())
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sum     atomic.Uint64
+}
+
+// Observe records a single observation
+func (h *Histogram) Observe(value float64) {
+	for i, upper := range h.buckets {
+		if value <= upper {
+			h.counts[i].Add(1)
+		}
+	}
+	h.count.Add(1)
+	addFloat(&h.sum, value)
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+// NewHistogramVec creates a histogram family with the given buckets and registers it to the DefaultRegistry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{newVec(name, help, labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets))}
+	})}
+	DefaultRegistry.register(h)
+	return h
+}
+
+// WithLabelValues returns the histogram for the given label values, creating it if needed
+func (h *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return h.with(values...)
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	if err := h.writeHeader(w, "histogram"); err != nil {
+		return err
+	}
+	return h.each(func(values []string, s *Histogram) error {
+		for i, upper := range s.buckets {
+			le := strconv.FormatFloat(upper, 'f', -1, 64)
+			if err := writeSample(w, h.metricName+"_bucket", h.labels, values, "le", le, float64(s.counts[i].Load())); err != nil {
+				return err
+			}
+		}
+		count := float64(s.count.Load())
+		if err := writeSample(w, h.metricName+"_bucket", h.labels, values, "le", "+Inf", count); err != nil {
+			return err
+		}
+		sum := math.Float64frombits(s.sum.Load())
+		if err := writeSample(w, h.metricName+"_sum", h.labels, values, "", "", sum); err != nil {
+			return err
+		}
+		return writeSample(w, h.metricName+"_count", h.labels, values, "", "", count)
+	})
+}
+
+// addFloat atomically adds delta to a float64 stored as bits
+func addFloat(bits *atomic.Uint64, delta float64) {
+	for {
+		old := bits.Load()
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if bits.CompareAndSwap(old, updated) {
+			return
+		}
+	}
+}
+
+func writeSample(w io.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) error {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label)
+			b.WriteString(`="`)
+			b.WriteString(escapeLabelValue(values[i]))
+			b.WriteByte('"')
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(extraLabel)
+			b.WriteString(`="`)
+			b.WriteString(extraValue)
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(value))
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
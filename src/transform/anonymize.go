@@ -0,0 +1,63 @@
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"strings"
+)
+
+func init() {
+	Register("anonymize", newAnonymize)
+}
+
+// defaultAnonymizedKeys are the metadata keys removed by anonymize when strip is not set
+var defaultAnonymizedKeys = []string{"email", "phone"}
+
+// anonymize pseudonymizes the user ids of the events with a salted SHA-256 and removes personal metadata, before the
+// events are deduplicated, quarantined or stored. The same user id hashes the same with the same salt, so users can
+// still be counted and followed, but not looked up. The raw payloads and client addresses of the events are not retained.
+// Parameters: salt, strip, a list of metadata.<key> (default: metadata.email,metadata.phone).
+type anonymize struct {
+	salt []byte
+	keys []string
+}
+
+func newAnonymize(params map[string]string) (Transformer, error) {
+	if err := checkParams(params, "salt", "strip"); err != nil {
+		return nil, err
+	}
+	// Without a salt the hashes of guessable ids, e.g. emails, could be reversed by hashing candidates
+	if params["salt"] == "" {
+		return nil, fmt.Errorf("salt is required")
+	}
+	a := &anonymize{salt: []byte(params["salt"]), keys: defaultAnonymizedKeys}
+	if _, ok := params["strip"]; ok {
+		a.keys = nil
+		for _, field := range splitList(params["strip"]) {
+			key, ok := strings.CutPrefix(field, domain.MetadataFieldPrefix)
+			if !ok || key == "" {
+				return nil, fmt.Errorf("cannot strip %q, only metadata fields can be stripped", field)
+			}
+			a.keys = append(a.keys, key)
+		}
+	}
+	return a, nil
+}
+
+func (a *anonymize) Transform(event *domain.EventRequest) (bool, error) {
+	if event.UserID != "" {
+		h := sha256.New()
+		h.Write(a.salt)
+		h.Write([]byte(event.UserID))
+		event.UserID = hex.EncodeToString(h.Sum(nil))
+	}
+	for _, key := range a.keys {
+		delete(event.Metadata, key)
+	}
+	// The payload as received holds the personal data, the client address is personal data as well
+	event.Ingest.Raw = nil
+	event.Ingest.ClientIP = ""
+	return true, nil
+}
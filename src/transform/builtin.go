@@ -0,0 +1,234 @@
+package transform
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/expression"
+	"slices"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("drop", newDrop)
+	Register("lowercase", newLowercase)
+	Register("set_fields", newSetFields)
+	Register("set_defaults", newSetDefaults)
+	Register("remove_fields", newRemoveFields)
+	Register("received_at", newReceivedAt)
+}
+
+// splitList splits a comma-separated parameter, skipping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// checkParams rejects the parameters a plugin does not know, catching typos in EVENT_TRANSFORM_PARAMS
+func checkParams(params map[string]string, known ...string) error {
+	for param := range params {
+		if !slices.Contains(known, param) {
+			return fmt.Errorf("unknown parameter %q", param)
+		}
+	}
+	return nil
+}
+
+// drop drops the events of the listed event names or channels, or matching an expression, e.g. internal test events
+// of a deployment. Parameters: event_names, channels, expr.
+type drop struct {
+	eventNames []string
+	channels   []string
+	expr       *expression.Expression
+}
+
+func newDrop(params map[string]string) (Transformer, error) {
+	if err := checkParams(params, "event_names", "channels", "expr"); err != nil {
+		return nil, err
+	}
+	d := &drop{eventNames: splitList(params["event_names"]), channels: splitList(params["channels"])}
+	if params["expr"] != "" {
+		expr, err := expression.Compile(params["expr"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression: %w", err)
+		}
+		d.expr = expr
+	}
+	if len(d.eventNames) == 0 && len(d.channels) == 0 && d.expr == nil {
+		return nil, fmt.Errorf("event_names, channels or expr is required")
+	}
+	return d, nil
+}
+
+func (d *drop) Transform(event *domain.EventRequest) (bool, error) {
+	if slices.Contains(d.eventNames, event.EventName) || slices.Contains(d.channels, event.Channel) {
+		return false, nil
+	}
+	if d.expr == nil {
+		return true, nil
+	}
+	matched, err := d.expr.Match(event)
+	return !matched, err
+}
+
+// lowercase lowercases string fields, e.g. channels sent as Web and web by different producers.
+// Parameters: fields, a list of event_name, channel, campaign_id, user_id or metadata.<key>.
+type lowercase struct {
+	fields []string
+}
+
+func newLowercase(params map[string]string) (Transformer, error) {
+	if err := checkParams(params, "fields"); err != nil {
+		return nil, err
+	}
+	fields := splitList(params["fields"])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("fields is required")
+	}
+	for _, field := range fields {
+		if !domain.IsEventField(field) {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+	}
+	return &lowercase{fields: fields}, nil
+}
+
+func (l *lowercase) Transform(event *domain.EventRequest) (bool, error) {
+	for _, field := range l.fields {
+		value, ok := event.Field(field)
+		if !ok {
+			continue
+		}
+		// Non-string metadata values are left as they are
+		if str, ok := value.(string); ok {
+			if err := event.SetField(field, strings.ToLower(str)); err != nil {
+				return true, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// setFields sets fields to fixed values, e.g. to enrich the events with the region of the deployment.
+// Parameters: the fields to set, metadata.region=eu-west-1.
+type setFields struct {
+	values map[string]string
+}
+
+func newSetFields(params map[string]string) (Transformer, error) {
+	if len(params) == 0 {
+		return nil, fmt.Errorf("at least one field is required")
+	}
+	for field := range params {
+		if !domain.IsEventField(field) {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+	}
+	return &setFields{values: params}, nil
+}
+
+func (s *setFields) Transform(event *domain.EventRequest) (bool, error) {
+	for field, value := range s.values {
+		if err := event.SetField(field, value); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// setDefaults sets the fields an event lacks, or has empty, to fixed values, e.g. a campaign for the events of
+// producers that do not send one. Parameters: the fields to default, metadata.plan=free.
+type setDefaults struct {
+	values map[string]string
+}
+
+func newSetDefaults(params map[string]string) (Transformer, error) {
+	if len(params) == 0 {
+		return nil, fmt.Errorf("at least one field is required")
+	}
+	for field := range params {
+		if !domain.IsEventField(field) {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+	}
+	return &setDefaults{values: params}, nil
+}
+
+func (s *setDefaults) Transform(event *domain.EventRequest) (bool, error) {
+	for field, value := range s.values {
+		if _, ok := event.Field(field); ok {
+			continue
+		}
+		if err := event.SetField(field, value); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// receivedAt sets a metadata field to the time the service received the event, in UTC as RFC 3339, e.g. to measure how late
+// the clocks or the queues of producers are. Clients cannot forge it, a value they sent is replaced.
+// Parameters: field, a metadata.<key> (default: metadata.received_at).
+type receivedAt struct {
+	key string
+}
+
+func newReceivedAt(params map[string]string) (Transformer, error) {
+	if err := checkParams(params, "field"); err != nil {
+		return nil, err
+	}
+	field := params["field"]
+	if field == "" {
+		field = domain.MetadataFieldPrefix + "received_at"
+	}
+	key, ok := strings.CutPrefix(field, domain.MetadataFieldPrefix)
+	if !ok || key == "" {
+		return nil, fmt.Errorf("cannot set %q, the time is kept in a metadata field", field)
+	}
+	return &receivedAt{key: key}, nil
+}
+
+func (r *receivedAt) Transform(event *domain.EventRequest) (bool, error) {
+	if event.Metadata == nil {
+		event.Metadata = make(map[string]any)
+	}
+	event.Metadata[r.key] = time.Now().UTC().Format(time.RFC3339Nano)
+	return true, nil
+}
+
+// removeFields removes metadata fields, e.g. debugging payloads or personal data that must not be stored.
+// Parameters: fields, a list of metadata.<key>.
+type removeFields struct {
+	keys []string
+}
+
+func newRemoveFields(params map[string]string) (Transformer, error) {
+	if err := checkParams(params, "fields"); err != nil {
+		return nil, err
+	}
+	fields := splitList(params["fields"])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("fields is required")
+	}
+	keys := make([]string, 0, len(fields))
+	for _, field := range fields {
+		key, ok := strings.CutPrefix(field, domain.MetadataFieldPrefix)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("cannot remove %q, only metadata fields can be removed", field)
+		}
+		keys = append(keys, key)
+	}
+	return &removeFields{keys: keys}, nil
+}
+
+func (r *removeFields) Transform(event *domain.EventRequest) (bool, error) {
+	for _, key := range r.keys {
+		delete(event.Metadata, key)
+	}
+	return true, nil
+}
@@ -0,0 +1,68 @@
+package transform
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+func init() {
+	Register("geoip", newGeoIP)
+}
+
+// geoIP sets the country, region and city of the client address of an event from a MaxMind City database, e.g.
+// GeoLite2-City.mmdb, in metadata fields. The database is read once, updating it requires a restart. Events without
+// a client address, e.g. of the ingestion adapters, or whose address is not in the database are left as they are.
+// Parameters: database, the path of the .mmdb file; prefix of the metadata keys (default: geo_).
+type geoIP struct {
+	reader *geoip2.Reader
+	prefix string
+}
+
+func newGeoIP(params map[string]string) (Transformer, error) {
+	if err := checkParams(params, "database", "prefix"); err != nil {
+		return nil, err
+	}
+	if params["database"] == "" {
+		return nil, fmt.Errorf("database is required")
+	}
+	reader, err := geoip2.Open(params["database"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	prefix, ok := params["prefix"]
+	if !ok {
+		prefix = "geo_"
+	}
+	return &geoIP{reader: reader, prefix: prefix}, nil
+}
+
+func (g *geoIP) Transform(event *domain.EventRequest) (bool, error) {
+	ip := net.ParseIP(event.Ingest.ClientIP)
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() {
+		return true, nil
+	}
+	city, err := g.reader.City(ip)
+	if err != nil {
+		return true, err
+	}
+	values := map[string]string{
+		"country": city.Country.IsoCode,
+		"city":    city.City.Names["en"],
+	}
+	if len(city.Subdivisions) > 0 {
+		values["region"] = city.Subdivisions[0].IsoCode
+	}
+	for name, value := range values {
+		if value == "" {
+			continue
+		}
+		if event.Metadata == nil {
+			event.Metadata = make(map[string]any)
+		}
+		event.Metadata[g.prefix+name] = value
+	}
+	return true, nil
+}
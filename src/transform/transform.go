@@ -0,0 +1,176 @@
+// Package transform runs the transformation plugins configured for a deployment, which mutate, enrich or drop events
+// at ingestion without forking the service. Plugins are compiled in: a Go file of the package, or of a package
+// imported by main, registers a factory under a name from its init function, and EVENT_TRANSFORMS selects the
+// registered plugins to run and their order.
+package transform
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/logging"
+	"kucukaslan/clickhouse/telemetry"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// transformLog logs the messages of the Pipeline
+var transformLog = logging.Named("Transform")
+
+var transformedEventsTotal = telemetry.NewCounterVec("event_transform_events_total",
+	"Number of events dropped by the transformation plugins or whose transformation failed, by plugin and result (dropped, error)", "transform", "result")
+
+// Actions on events whose transformation fails
+const (
+	OnErrorKeep = "keep" // the event is ingested as it was before the failing plugin
+	OnErrorDrop = "drop" // the event is dropped, e.g. when a plugin removes personal data that must not be stored
+)
+
+// Transformer is a transformation plugin
+type Transformer interface {
+	// Transform mutates or enriches the event in place, it returns false to drop the event. The metadata map may be
+	// modified, but not the values it holds. It is called concurrently by the requests and ingestion adapters.
+	Transform(event *domain.EventRequest) (bool, error)
+}
+
+// Factory creates a plugin from its parameters, the EVENT_TRANSFORM_PARAMS prefixed with its name
+type Factory func(params map[string]string) (Transformer, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a plugin available under a name, it is called from the init function of the plugin's file
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("transform: plugin " + name + " registered twice")
+	}
+	registry[name] = factory
+}
+
+// Names returns the names of the registered plugins, sorted
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// stage is a configured plugin of the pipeline
+type stage struct {
+	name        string
+	transformer Transformer
+}
+
+// Pipeline runs the configured plugins in order on the ingested events
+type Pipeline struct {
+	stages      []stage
+	dropOnError bool
+}
+
+// NewPipeline creates the pipeline of the configured plugins, nil if none is configured
+func NewPipeline(cfg *config.TransformConfig) (*Pipeline, error) {
+	if cfg.Transforms == "" {
+		return nil, nil
+	}
+	if cfg.OnError != OnErrorKeep && cfg.OnError != OnErrorDrop {
+		return nil, fmt.Errorf("unknown transform error action %q, expected %s or %s", cfg.OnError, OnErrorKeep, OnErrorDrop)
+	}
+
+	var names []string
+	for _, name := range strings.Split(cfg.Transforms, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	// Parameters are grouped by plugin, <plugin>.<param>=<value>
+	params := make(map[string]map[string]string)
+	for key, value := range cfg.Params {
+		name, param, ok := strings.Cut(key, ".")
+		if !ok || !slices.Contains(names, name) {
+			return nil, fmt.Errorf("transform parameter %q does not belong to a configured transform", key)
+		}
+		if params[name] == nil {
+			params[name] = make(map[string]string)
+		}
+		params[name][param] = value
+	}
+
+	pipeline := &Pipeline{dropOnError: cfg.OnError == OnErrorDrop}
+	for _, name := range names {
+		registryMu.RLock()
+		factory, ok := registry[name]
+		registryMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q, registered transforms are %s", name, strings.Join(Names(), ", "))
+		}
+		transformer, err := factory(params[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform %q: %w", name, err)
+		}
+		pipeline.stages = append(pipeline.stages, stage{name: name, transformer: transformer})
+	}
+	transformLog.Infof("Transforming events with %s", strings.Join(names, ", "))
+	return pipeline, nil
+}
+
+// Apply runs the plugins on copies of the events and returns the transformed events that are kept.
+// It is safe to call on a nil pipeline, in which case all events are kept.
+func (p *Pipeline) Apply(events []domain.EventRequest) []domain.EventRequest {
+	if p == nil || len(events) == 0 {
+		return events
+	}
+	kept := events[:0:0]
+	for _, event := range events {
+		if p.transform(&event) {
+			kept = append(kept, event)
+		}
+	}
+	return kept
+}
+
+// transform runs the plugins on an event, returns whether it is kept
+func (p *Pipeline) transform(event *domain.EventRequest) bool {
+	// The metadata map is shared with the request, plugins modify a copy
+	event.Metadata = cloneMetadata(event.Metadata)
+	for _, stage := range p.stages {
+		// A failing plugin leaves the event as it was before it
+		before := *event
+		before.Metadata = cloneMetadata(event.Metadata)
+		keep, err := stage.transformer.Transform(event)
+		if err != nil {
+			transformedEventsTotal.WithLabelValues(stage.name, "error").Inc()
+			transformLog.Warnf("Transform %s failed on %s event of user %q: %v", stage.name, before.EventName, before.UserID, err)
+			if p.dropOnError {
+				return false
+			}
+			*event = before
+			continue
+		}
+		if !keep {
+			transformedEventsTotal.WithLabelValues(stage.name, "dropped").Inc()
+			return false
+		}
+	}
+	return true
+}
+
+// cloneMetadata copies the metadata map of an event, values are shared
+func cloneMetadata(metadata map[string]any) map[string]any {
+	if metadata == nil {
+		return nil
+	}
+	clone := make(map[string]any, len(metadata))
+	for key, value := range metadata {
+		clone[key] = value
+	}
+	return clone
+}
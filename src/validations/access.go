@@ -0,0 +1,104 @@
+package validations
+
+import (
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"strings"
+)
+
+// ColumnACL are the columns of the events the roles of API keys cannot group or filter metrics by
+type ColumnACL struct {
+	denied       map[string]map[string]bool // columns by role
+	roleRequired bool                       // authentication is enabled, queries without a role are rejected
+}
+
+// NewColumnACL parses the comma separated columns denied per role, e.g. consumer=user_id,campaign_id.
+// With authentication enabled, queries without the role of an API key are rejected instead of not being restricted.
+func NewColumnACL(denied map[string]string, authEnabled bool) (*ColumnACL, error) {
+	acl := &ColumnACL{denied: make(map[string]map[string]bool, len(denied)), roleRequired: authEnabled}
+	for role, value := range denied {
+		if role == "" || ValidateAPIKeyRole(role) != nil {
+			return nil, fmt.Errorf("unknown role %q of the denied columns, expected %s, %s or %s",
+				role, domain.APIKeyRoleProducer, domain.APIKeyRoleConsumer, domain.APIKeyRoleAdmin)
+		}
+		columns := make(map[string]bool)
+		for _, column := range strings.Split(value, ",") {
+			if column = strings.TrimSpace(column); column != "" {
+				columns[column] = true
+			}
+		}
+		acl.denied[role] = columns
+	}
+	return acl, nil
+}
+
+// Denied returns the first of the columns the role cannot query, empty if all of them are allowed
+func (a *ColumnACL) Denied(role string, columns ...string) string {
+	denied := a.denied[role]
+	for _, column := range columns {
+		if denied[column] {
+			return column
+		}
+	}
+	return ""
+}
+
+var columnACL = &ColumnACL{}
+
+// SetColumnACL sets the columns denied to the roles of API keys
+func SetColumnACL(acl *ColumnACL) {
+	columnACL = acl
+}
+
+// ErrMissingAPIKeyRole is returned when authentication is enabled and a query has no API key role, it is answered 401
+var ErrMissingAPIKeyRole = errors.New("an API key is required")
+
+// ColumnAccessError is returned when the role of the API key of a request cannot group or filter by a column,
+// it is answered 403 instead of 400
+type ColumnAccessError struct {
+	Role   string
+	Column string
+}
+
+func (e *ColumnAccessError) Error() string {
+	return fmt.Sprintf("API keys of role %s cannot group or filter by %s", e.Role, e.Column)
+}
+
+// validateColumnAccess checks the columns a request groups or filters by against the ones denied to its role.
+// Requests without an API key have no role: they are rejected when authentication is enabled, not restricted otherwise.
+func validateColumnAccess(role string, columns ...string) error {
+	if role == "" {
+		if columnACL.roleRequired {
+			return ErrMissingAPIKeyRole
+		}
+		return nil
+	}
+	if column := columnACL.Denied(role, columns...); column != "" {
+		return &ColumnAccessError{Role: role, Column: column}
+	}
+	return nil
+}
+
+// metricColumns returns the columns of the events a metric request groups or filters by, time buckets are not columns
+func metricColumns(request *domain.MetricRequest) []string {
+	var columns []string
+	if request.GroupBy != nil {
+		if _, ok := timeGroupings[*request.GroupBy]; !ok {
+			columns = append(columns, *request.GroupBy)
+		}
+	}
+	if request.EventName != nil {
+		columns = append(columns, "event_name")
+	}
+	if request.Tag != nil {
+		columns = append(columns, "tags")
+	}
+	if request.MaxAbuseScore != nil {
+		columns = append(columns, "abuse_score")
+	}
+	return columns
+}
+
+// timeGroupings are the groupings of metrics by time bucket
+var timeGroupings = map[string]struct{}{"hour": {}, "day": {}, "week": {}, "month": {}, "year": {}}
@@ -0,0 +1,231 @@
+package validations
+
+import (
+	"encoding/json"
+	"fmt"
+	"kucukaslan/clickhouse/config"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/telemetry"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Modes for handling channels and event names that are not allowed
+const (
+	AllowedValuesReject    = "reject"    // reject the event
+	AllowedValuesNormalize = "normalize" // replace the value by the allowed one it is a case variant or a typo of, reject otherwise
+)
+
+var allowedValuesTotal = telemetry.NewCounterVec("allowed_values_total",
+	"Number of channels and event names that were not allowed by field and whether they were normalized or rejected", "field", "result")
+
+// AllowedValues lists the channels and event names events may carry, so that typos like "webb" do not end up
+// as values of their own in the low-cardinality columns. An empty list allows any value.
+type AllowedValues struct {
+	Channels       []string          `json:"channels,omitempty"`
+	ChannelAliases map[string]string `json:"channel_aliases,omitempty"` // e.g. {"webb": "web"}, applied in both modes
+	// Event names must be listed or match the pattern, if either is set
+	EventNames       []string          `json:"event_names,omitempty"`
+	EventNamePattern string            `json:"event_name_pattern,omitempty"`
+	EventNameAliases map[string]string `json:"event_name_aliases,omitempty"`
+	Mode             string            `json:"mode,omitempty"`
+
+	channels   map[string]bool
+	eventNames map[string]bool
+	pattern    *regexp.Regexp
+}
+
+// NewAllowedValues checks the lists and compiles the event name pattern
+func NewAllowedValues(allowed AllowedValues) (*AllowedValues, error) {
+	switch allowed.Mode {
+	case "":
+		allowed.Mode = AllowedValuesReject
+	case AllowedValuesReject, AllowedValuesNormalize:
+	default:
+		return nil, fmt.Errorf("unknown allowed values mode %q, expected reject or normalize", allowed.Mode)
+	}
+	if allowed.EventNamePattern != "" {
+		pattern, err := regexp.Compile(allowed.EventNamePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event name pattern: %w", err)
+		}
+		allowed.pattern = pattern
+	}
+	allowed.Channels, allowed.channels = allowedSet(allowed.Channels)
+	allowed.EventNames, allowed.eventNames = allowedSet(allowed.EventNames)
+	for alias, channel := range allowed.ChannelAliases {
+		if !allowed.channelAllowed(channel) {
+			return nil, fmt.Errorf("channel alias %s refers to %s, which is not allowed", alias, channel)
+		}
+	}
+	for alias, eventName := range allowed.EventNameAliases {
+		if !allowed.eventNameAllowed(eventName) {
+			return nil, fmt.Errorf("event name alias %s refers to %s, which is not allowed", alias, eventName)
+		}
+	}
+	return &allowed, nil
+}
+
+// allowedSet returns the distinct values of a list without surrounding spaces, sorted, and their set
+func allowedSet(values []string) ([]string, map[string]bool) {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			set[value] = true
+		}
+	}
+	list := make([]string, 0, len(set))
+	for value := range set {
+		list = append(list, value)
+	}
+	slices.Sort(list)
+	return list, set
+}
+
+// LoadAllowedValues reads the allowed values from the file of the configuration, if any, and overrides its lists
+// with the ones set in the environment. It returns nil if no list is configured, which allows any value.
+func LoadAllowedValues(cfg *config.ValidationConfig) (*AllowedValues, error) {
+	var allowed AllowedValues
+	if cfg.AllowedValuesFile != "" {
+		data, err := os.ReadFile(cfg.AllowedValuesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read allowed values file: %w", err)
+		}
+		if err := json.Unmarshal(data, &allowed); err != nil {
+			return nil, fmt.Errorf("failed to parse allowed values file: %w", err)
+		}
+	}
+	if cfg.AllowedChannels != "" {
+		allowed.Channels = strings.Split(cfg.AllowedChannels, ",")
+	}
+	if len(cfg.ChannelAliases) > 0 {
+		allowed.ChannelAliases = cfg.ChannelAliases
+	}
+	if cfg.AllowedEventNames != "" {
+		allowed.EventNames = strings.Split(cfg.AllowedEventNames, ",")
+	}
+	if cfg.EventNamePattern != "" {
+		allowed.EventNamePattern = cfg.EventNamePattern
+	}
+	if cfg.AllowedValuesMode != "" {
+		allowed.Mode = cfg.AllowedValuesMode
+	}
+	if len(allowed.Channels) == 0 && len(allowed.ChannelAliases) == 0 && len(allowed.EventNames) == 0 &&
+		allowed.EventNamePattern == "" && len(allowed.EventNameAliases) == 0 {
+		return nil, nil
+	}
+	return NewAllowedValues(allowed)
+}
+
+// eventNameAllowed tells whether an event name is listed or matches the pattern, any is allowed if neither is set
+func (a *AllowedValues) eventNameAllowed(eventName string) bool {
+	if len(a.eventNames) == 0 && a.pattern == nil {
+		return true
+	}
+	return a.eventNames[eventName] || (a.pattern != nil && a.pattern.MatchString(eventName))
+}
+
+// channelAllowed tells whether a channel is listed, any is allowed if none is
+func (a *AllowedValues) channelAllowed(channel string) bool {
+	return len(a.channels) == 0 || a.channels[channel]
+}
+
+// normalize returns the allowed value of a value that is not allowed: the value of its alias, or in normalize mode
+// the value in lower case without surrounding spaces if that is allowed or, failing that, the single closest
+// listed value within a few typos. It returns false if there is none.
+func (a *AllowedValues) normalize(value string, aliases map[string]string, allowed func(string) bool, listed []string) (string, bool) {
+	if normalized, ok := aliases[value]; ok {
+		return normalized, true
+	}
+	if a.Mode != AllowedValuesNormalize {
+		return "", false
+	}
+	folded := strings.ToLower(strings.TrimSpace(value))
+	if normalized, ok := aliases[folded]; ok {
+		return normalized, true
+	}
+	if allowed(folded) {
+		return folded, true
+	}
+	best, bestDistance, ambiguous := "", -1, false
+	for _, candidate := range listed {
+		distance := editDistance(folded, strings.ToLower(candidate))
+		if distance > max(1, len(candidate)/4) {
+			continue
+		}
+		switch {
+		case bestDistance < 0 || distance < bestDistance:
+			best, bestDistance, ambiguous = candidate, distance, false
+		case distance == bestDistance:
+			ambiguous = true
+		}
+	}
+	return best, bestDistance >= 0 && !ambiguous
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and transpositions of adjacent characters
+// turning a into b (optimal string alignment distance)
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	beforePrevious := make([]int, len(rb)+1)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				current[j] = min(current[j], beforePrevious[j-2]+1)
+			}
+		}
+		beforePrevious, previous, current = previous, current, beforePrevious
+	}
+	return previous[len(rb)]
+}
+
+// check normalizes the channel and event name of an event in place, returning why either is not allowed
+func (a *AllowedValues) check(request *domain.EventRequest) string {
+	if _, aliased := a.ChannelAliases[request.Channel]; aliased || !a.channelAllowed(request.Channel) {
+		channel, ok := a.normalize(request.Channel, a.ChannelAliases, a.channelAllowed, a.Channels)
+		if !ok {
+			allowedValuesTotal.WithLabelValues("channel", "rejected").Inc()
+			return "channel is not allowed"
+		}
+		allowedValuesTotal.WithLabelValues("channel", "normalized").Inc()
+		request.Channel = channel
+	}
+	if _, aliased := a.EventNameAliases[request.EventName]; aliased || !a.eventNameAllowed(request.EventName) {
+		eventName, ok := a.normalize(request.EventName, a.EventNameAliases, a.eventNameAllowed, a.EventNames)
+		if !ok {
+			allowedValuesTotal.WithLabelValues("event_name", "rejected").Inc()
+			return "event_name is not allowed"
+		}
+		allowedValuesTotal.WithLabelValues("event_name", "normalized").Inc()
+		request.EventName = eventName
+	}
+	return ""
+}
+
+var allowedValues *AllowedValues
+
+// SetAllowedValues sets the channels and event names events may carry, nil allows any
+func SetAllowedValues(allowed *AllowedValues) {
+	allowedValues = allowed
+}
+
+// allowedValuesViolation normalizes the channel and event name of an event in place, returning why either is not allowed
+func allowedValuesViolation(request *domain.EventRequest) string {
+	if allowedValues == nil {
+		return ""
+	}
+	return allowedValues.check(request)
+}
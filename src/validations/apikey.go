@@ -0,0 +1,51 @@
+package validations
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"regexp"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// producerIDPattern matches the producer ids of API keys, see domain.ProducerID
+var producerIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// maxAPIKeyNameLength bounds the names of API keys
+const maxAPIKeyNameLength = 64
+
+// ValidateProducerID validates the producer id identifying an API key
+func ValidateProducerID(producer string) error {
+	if !producerIDPattern.MatchString(producer) {
+		return fiber.NewError(fiber.StatusBadRequest, "producer must be 32 lowercase hex characters")
+	}
+	return nil
+}
+
+// ValidateAPIKeyRequest validates the API key to create or allow
+func ValidateAPIKeyRequest(request *domain.APIKeyRequest) error {
+	if request.Name == "" || len(request.Name) > maxAPIKeyNameLength {
+		return fiber.NewError(fiber.StatusBadRequest, "name must be 1-64 bytes")
+	}
+	if request.Producer != "" {
+		if err := ValidateProducerID(request.Producer); err != nil {
+			return err
+		}
+	}
+	if request.RateLimit < 0 || request.DailyEvents < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "rate_limit and daily_events cannot be negative")
+	}
+	if err := ValidateAPIKeyRole(request.Role); err != nil {
+		return err
+	}
+	return ValidateTenantID(request.Tenant)
+}
+
+// ValidateAPIKeyRole checks that a role is producer, consumer or admin, empty keeps the default
+func ValidateAPIKeyRole(role string) error {
+	if role != "" && role != domain.APIKeyRoleProducer && role != domain.APIKeyRoleConsumer && role != domain.APIKeyRoleAdmin {
+		return fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("role must be %s, %s or %s", domain.APIKeyRoleProducer, domain.APIKeyRoleConsumer, domain.APIKeyRoleAdmin))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package validations
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"time"
+)
+
+// MaxBeaconBytes is the maximum size of a beacon body, beacons carry a single small event
+const MaxBeaconBytes = 8 * 1024
+
+const (
+	// DefaultBeaconChannel is the channel of beacon events that do not set one
+	DefaultBeaconChannel = "web"
+	// DefaultBeaconCampaign is the campaign of beacon events that do not set one
+	DefaultBeaconCampaign = "none"
+)
+
+// ValidateBeaconEventRequest validates an event sent by a tracking pixel or beacon.
+// Browsers cannot be trusted to send complete events or accurate clocks, so the channel, campaign, tags and metadata
+// are optional, a missing timestamp or one in the future is replaced by the time the event was received,
+// and the event is then validated like the ones of /events.
+func ValidateBeaconEventRequest(request *domain.EventRequest, received time.Time) error {
+	if request.Channel == "" {
+		request.Channel = DefaultBeaconChannel
+	}
+	if request.CampaignID == "" {
+		request.CampaignID = DefaultBeaconCampaign
+	}
+	if request.Tags == nil {
+		request.Tags = []string{}
+	}
+	if request.Metadata == nil {
+		request.Metadata = map[string]any{}
+	}
+	if request.Timestamp >= 0 && request.TimestampMS >= 0 &&
+		(request.Timestamp == 0 && request.TimestampMS == 0 || request.EventTime().After(received)) {
+		request.Timestamp = 0
+		request.TimestampMS = received.UnixMilli()
+	}
+	return ValidateEventRequest(request)
+}
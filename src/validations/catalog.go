@@ -0,0 +1,64 @@
+package validations
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits of the documentation of an event name
+const (
+	MaxCatalogDays             = 365
+	maxCatalogOwnerLength      = 256
+	maxCatalogDescriptionBytes = 4096
+	maxCatalogExpectedMetadata = 256
+)
+
+// ValidateCatalogRequest validates the event name and days the catalog is read with
+func ValidateCatalogRequest(request *domain.CatalogRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	if request.Days < 0 || request.Days > MaxCatalogDays {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d", MaxCatalogDays))
+	}
+	return nil
+}
+
+// ValidateCatalogEntryRequest validates the documentation of an event name
+func ValidateCatalogEntryRequest(request *domain.CatalogEntryRequest) error {
+	if err := ValidateCatalogEventName(request.Tenant, request.EventName); err != nil {
+		return err
+	}
+	if strings.TrimSpace(request.Owner) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "owner is required")
+	}
+	if len(request.Owner) > maxCatalogOwnerLength {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("owner must be at most %d bytes", maxCatalogOwnerLength))
+	}
+	if len(request.Description) > maxCatalogDescriptionBytes {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("description must be at most %d bytes", maxCatalogDescriptionBytes))
+	}
+	if len(request.ExpectedMetadata) > maxCatalogExpectedMetadata {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("expected_metadata can have at most %d keys", maxCatalogExpectedMetadata))
+	}
+	for _, key := range request.ExpectedMetadata {
+		if strings.TrimSpace(key) == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "expected_metadata keys cannot be empty")
+		}
+	}
+	return nil
+}
+
+// ValidateCatalogEventName validates the tenant and event name of a catalog entry
+func ValidateCatalogEventName(tenant, eventName string) error {
+	if err := ValidateTenantID(tenant); err != nil {
+		return err
+	}
+	if strings.TrimSpace(eventName) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "event_name is required")
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package validations
+
+import (
+	"kucukaslan/clickhouse/domain"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ValidateClientTokenRequest validates a client token request, the lifetime is capped by the service
+func ValidateClientTokenRequest(request *domain.ClientTokenRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	if request.TTLSeconds < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "ttl_seconds cannot be negative")
+	}
+	return nil
+}
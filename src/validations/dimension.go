@@ -0,0 +1,91 @@
+package validations
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MaxDimensionRows is the maximum number of rows of an uploaded dimension, dimensions are loaded in memory by ClickHouse
+const MaxDimensionRows = 100000
+
+// EnrichableGroupings are the /metrics groupings whose buckets can be enriched with dimension attributes
+var EnrichableGroupings = []string{"campaign_id", "channel", "event_name", "user_id"}
+
+// dimensionNamePattern restricts dimension and column names to identifier characters,
+// as they are used in table, dictionary and column names
+var dimensionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// ValidateDimensionName checks the name of a dimension or one of its columns
+func ValidateDimensionName(name string) error {
+	if !dimensionNamePattern.MatchString(name) {
+		return fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("invalid name %q, names must be 1-64 lowercase letters, digits or underscores starting with a letter", name))
+	}
+	return nil
+}
+
+// ValidateDimensionUploadRequest validates the name, columns and rows of an uploaded dimension
+func ValidateDimensionUploadRequest(request *domain.DimensionUploadRequest) error {
+	if err := ValidateDimensionName(request.Name); err != nil {
+		return err
+	}
+	if err := validateDimensionColumns(request.Columns); err != nil {
+		return err
+	}
+	if len(request.Rows) > MaxDimensionRows {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("a dimension cannot have more than %d rows", MaxDimensionRows))
+	}
+	// Rows are numbered from 2 in error messages, as in the CSV file with its header
+	for i, row := range request.Rows {
+		if len(row) != len(request.Columns) {
+			return fiber.NewError(fiber.StatusBadRequest,
+				fmt.Sprintf("row %d has %d values, expected %d", i+2, len(row), len(request.Columns)))
+		}
+	}
+	return nil
+}
+
+// MaxDimensionLifetime is the longest refresh interval in seconds of a dimension loaded from a URL
+const MaxDimensionLifetime = 7 * 24 * 3600
+
+// ValidateDimensionURLRequest validates the source and columns of a dimension loaded from a URL
+func ValidateDimensionURLRequest(request *domain.DimensionURLRequest) error {
+	if err := ValidateDimensionName(request.Name); err != nil {
+		return err
+	}
+	u, err := url.Parse(request.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "url must be an absolute http or https URL")
+	}
+	if request.Format != "" && !slices.Contains(domain.DimensionURLFormats, request.Format) {
+		return fiber.NewError(fiber.StatusBadRequest, "format must be one of "+strings.Join(domain.DimensionURLFormats, ", "))
+	}
+	if request.LifetimeSeconds != nil && (*request.LifetimeSeconds < 0 || *request.LifetimeSeconds > MaxDimensionLifetime) {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("lifetime_seconds must be between 0 and %d", MaxDimensionLifetime))
+	}
+	return validateDimensionColumns(request.Columns)
+}
+
+// validateDimensionColumns checks that there is a key and at least one attribute column with unique valid names
+func validateDimensionColumns(columns []string) error {
+	if len(columns) < 2 {
+		return fiber.NewError(fiber.StatusBadRequest, "a dimension needs a key column and at least one attribute column")
+	}
+	seen := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		if err := ValidateDimensionName(column); err != nil {
+			return err
+		}
+		if seen[column] {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("duplicate column %q", column))
+		}
+		seen[column] = true
+	}
+	return nil
+}
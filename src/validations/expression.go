@@ -0,0 +1,33 @@
+package validations
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// maxExpressionLength bounds the expressions tested by the API, rules and filters are short
+	maxExpressionLength = 4096
+	// maxExpressionTestEvents bounds the sample events an expression is tested on
+	maxExpressionTestEvents = 100
+)
+
+// ValidateExpressionTestRequest validates an expression and its sample events, the expression is compiled by the handler
+func ValidateExpressionTestRequest(request *domain.ExpressionTestRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	if strings.TrimSpace(request.Expression) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "expression is required")
+	}
+	if len(request.Expression) > maxExpressionLength {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("expression must be at most %d bytes", maxExpressionLength))
+	}
+	if len(request.Events) > maxExpressionTestEvents {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("at most %d events can be tested", maxExpressionTestEvents))
+	}
+	return nil
+}
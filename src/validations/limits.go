@@ -0,0 +1,77 @@
+package validations
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Sources of the bulk limit of a request
+const (
+	BulkLimitDefault  = "default"
+	BulkLimitTenant   = "tenant"
+	BulkLimitProducer = "producer"
+)
+
+// BulkLimits are the maximum number of events per bulk request or stream chunk.
+// A producer's limit takes precedence over its tenant's, which takes precedence over the default.
+type BulkLimits struct {
+	defaultLimit int
+	tenants      map[string]int
+	producers    map[string]int
+}
+
+// NewBulkLimits parses the limit overrides per tenant and per producer, producers are identified by the hash of their API key
+func NewBulkLimits(defaultLimit int, tenants, producers map[string]string) (*BulkLimits, error) {
+	if defaultLimit <= 0 {
+		return nil, fmt.Errorf("bulk event limit must be positive, got %d", defaultLimit)
+	}
+	limits := &BulkLimits{
+		defaultLimit: defaultLimit,
+		tenants:      make(map[string]int, len(tenants)),
+		producers:    make(map[string]int, len(producers)),
+	}
+	for tenant, value := range tenants {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid bulk event limit %q for tenant %q", value, tenant)
+		}
+		limits.tenants[tenant] = limit
+	}
+	for producer, value := range producers {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid bulk event limit %q for producer %q", value, producer)
+		}
+		limits.producers[producer] = limit
+	}
+	return limits, nil
+}
+
+// Limit returns the maximum number of events per request of a producer and tenant, and where the limit comes from
+func (l *BulkLimits) Limit(producer, tenant string) (int, string) {
+	if limit, ok := l.producers[producer]; ok && producer != "" {
+		return limit, BulkLimitProducer
+	}
+	if limit, ok := l.tenants[tenant]; ok {
+		return limit, BulkLimitTenant
+	}
+	return l.defaultLimit, BulkLimitDefault
+}
+
+var bulkLimits = &BulkLimits{defaultLimit: MaxBulkEventCount}
+
+// SetBulkLimits sets the limits bulk requests and stream chunks are checked against
+func SetBulkLimits(limits *BulkLimits) {
+	bulkLimits = limits
+}
+
+// MaxBulkEvents returns the maximum number of events per bulk request or stream chunk of a producer and tenant,
+// and where the limit comes from
+func MaxBulkEvents(producer, tenant string) (int, string) {
+	return bulkLimits.Limit(producer, tenant)
+}
+
+// MaxBulkViolations returns the number of invalid events reported per bulk request or stream chunk
+func MaxBulkViolations() int {
+	return maxBulkViolations
+}
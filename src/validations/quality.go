@@ -0,0 +1,171 @@
+package validations
+
+import (
+	"encoding/json"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/expression"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// AllEvents is the event name of the rules applied to every event
+const AllEvents = "*"
+
+// QualityRule is a data quality check on a field of an event.
+// Events violating a rule are quarantined instead of being rejected or stored.
+type QualityRule struct {
+	// Field is event_name, channel, campaign_id, user_id or metadata.<key>, optional for rules with an expression only
+	Field    string   `json:"field,omitempty"`
+	Required bool     `json:"required,omitempty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	In       []string `json:"in,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"`
+	// Expr is an expression the event must satisfy, e.g. metadata.price <= metadata.list_price, see package expression
+	Expr string `json:"expr,omitempty"`
+
+	pattern *regexp.Regexp
+	expr    *expression.Expression
+}
+
+// QualityRules maps event names to the rules checked on them, rules of AllEvents are checked on every event
+type QualityRules struct {
+	rules map[string][]QualityRule
+}
+
+// NewQualityRules checks the rules and compiles their patterns
+func NewQualityRules(rules map[string][]QualityRule) (*QualityRules, error) {
+	for eventName, eventRules := range rules {
+		for i := range eventRules {
+			rule := &eventRules[i]
+			if rule.Expr != "" {
+				expr, err := expression.Compile(rule.Expr)
+				if err != nil {
+					return nil, fmt.Errorf("invalid expression %q in the rules of %s: %w", rule.Expr, eventName, err)
+				}
+				rule.expr = expr
+				if rule.Field == "" {
+					continue
+				}
+			}
+			if !domain.IsEventField(rule.Field) {
+				return nil, fmt.Errorf("unknown field %q in the rules of %s", rule.Field, eventName)
+			}
+			if rule.Pattern != "" {
+				pattern, err := regexp.Compile(rule.Pattern)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pattern for %s.%s: %w", eventName, rule.Field, err)
+				}
+				rule.pattern = pattern
+			}
+		}
+	}
+	return &QualityRules{rules: rules}, nil
+}
+
+// LoadQualityRules reads the rules from a JSON file mapping event names to rules, e.g.
+// {"purchase": [{"field": "metadata.price", "min": 0}, {"field": "metadata.currency", "in": ["USD", "EUR"]}]}
+func LoadQualityRules(path string) (*QualityRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quality rules file: %w", err)
+	}
+	var rules map[string][]QualityRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse quality rules file: %w", err)
+	}
+	return NewQualityRules(rules)
+}
+
+// Check returns the violations of an event, none if it passes all rules
+func (q *QualityRules) Check(event *domain.EventRequest) []string {
+	var violations []string
+	for _, eventName := range []string{AllEvents, event.EventName} {
+		for _, rule := range q.rules[eventName] {
+			if violation := rule.check(event); violation != "" {
+				violations = append(violations, violation)
+			}
+		}
+	}
+	return violations
+}
+
+func (r *QualityRule) check(event *domain.EventRequest) string {
+	if r.expr != nil {
+		matched, err := r.expr.Match(event)
+		if err != nil {
+			return fmt.Sprintf("%s cannot be evaluated: %v", r.Expr, err)
+		}
+		if !matched {
+			return r.Expr + " must hold"
+		}
+		if r.Field == "" {
+			return ""
+		}
+	}
+
+	value, ok := event.Field(r.Field)
+	if !ok {
+		if r.Required {
+			return r.Field + " is required"
+		}
+		return ""
+	}
+
+	if r.Min != nil || r.Max != nil {
+		number, ok := numberValue(value)
+		if !ok {
+			return r.Field + " must be a number"
+		}
+		if r.Min != nil && number < *r.Min {
+			return fmt.Sprintf("%s must be >= %v", r.Field, *r.Min)
+		}
+		if r.Max != nil && number > *r.Max {
+			return fmt.Sprintf("%s must be <= %v", r.Field, *r.Max)
+		}
+	}
+
+	if len(r.In) > 0 || r.pattern != nil {
+		str := fmt.Sprint(value)
+		if len(r.In) > 0 && !slices.Contains(r.In, str) {
+			return fmt.Sprintf("%s must be one of %s", r.Field, strings.Join(r.In, ", "))
+		}
+		if r.pattern != nil && !r.pattern.MatchString(str) {
+			return fmt.Sprintf("%s must match %s", r.Field, r.Pattern)
+		}
+	}
+	return ""
+}
+
+func numberValue(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		number, err := v.Float64()
+		return number, err == nil
+	case string:
+		number, err := strconv.ParseFloat(v, 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+var qualityRules *QualityRules
+
+// SetQualityRules sets the rules events are checked against, nil disables the checks
+func SetQualityRules(rules *QualityRules) {
+	qualityRules = rules
+}
+
+// CheckQuality returns the data quality violations of an event, none if no rules are set
+func CheckQuality(event *domain.EventRequest) []string {
+	if qualityRules == nil {
+		return nil
+	}
+	return qualityRules.Check(event)
+}
@@ -0,0 +1,87 @@
+package validations
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MaxReprocessIDs is the maximum number of quarantined events reprocessed in one request
+const MaxReprocessIDs = 1000
+
+func ValidateQuarantineRequest(request *domain.QuarantineRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	switch request.Status {
+	case "", "quarantined", "reprocessed":
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "status must be quarantined or reprocessed")
+	}
+	if request.Limit < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "limit cannot be negative")
+	}
+	return nil
+}
+
+func ValidateDeadLetterRequest(request *domain.DeadLetterRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	if request.Limit < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "limit cannot be negative")
+	}
+	return validateSink(request.Sink)
+}
+
+// validateSink checks the name of an optional sink of a dead-letter queue, empty for the one of ClickHouse
+func validateSink(sink string) error {
+	switch sink {
+	case "", domain.SinkClickHouseSecondary, domain.SinkKafka, domain.SinkS3:
+		return nil
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("sink must be %s, %s or %s",
+			domain.SinkClickHouseSecondary, domain.SinkKafka, domain.SinkS3))
+	}
+}
+
+func ValidateReprocessRequest(request *domain.ReprocessRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	if len(request.IDs) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "ids cannot be empty")
+	}
+	if len(request.IDs) > MaxReprocessIDs {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("cannot reprocess more than %d events at once", MaxReprocessIDs))
+	}
+	for _, id := range request.IDs {
+		if strings.TrimSpace(id) == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "ids cannot be empty")
+		}
+	}
+	switch request.Source {
+	case "", domain.ReprocessSourceQuarantine, domain.ReprocessSourceDeadLetter, domain.ReprocessSourceRaw:
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "source must be quarantine, dead_letter or raw")
+	}
+	if request.Sink != "" {
+		if request.Source != domain.ReprocessSourceDeadLetter {
+			return fiber.NewError(fiber.StatusBadRequest, "sink can only be given with source dead_letter")
+		}
+		if request.Transform != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "events are written to a sink as they are, without transform")
+		}
+		if err := validateSink(request.Sink); err != nil {
+			return err
+		}
+	}
+	if request.Transform != nil {
+		if err := request.Transform.Validate(); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "transform: "+err.Error())
+		}
+	}
+	return nil
+}
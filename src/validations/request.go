@@ -1,54 +1,139 @@
 package validations
 
 import (
+	"errors"
 	"fmt"
 	"kucukaslan/clickhouse/domain"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// tenantIDPattern restricts tenant ids to identifier characters, as they are used in database names and keys
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+// ValidateTenantID checks the tenant of the X-Tenant-ID header, empty means the default tenant
+func ValidateTenantID(tenant string) error {
+	if tenant != "" && !tenantIDPattern.MatchString(tenant) {
+		return fiber.NewError(fiber.StatusBadRequest, "X-Tenant-ID must be 1-64 letters, digits or underscores")
+	}
+	return nil
+}
+
+// ValidateAck checks the acknowledgment level asked for by a producer of POST /events
+func ValidateAck(ack string) error {
+	switch ack {
+	case domain.AckNone, domain.AckBuffered, domain.AckFlushed:
+		return nil
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "expected none, buffered or flushed")
+	}
+}
+
+// ValidateEventRequest validates a single event
 func ValidateEventRequest(request *domain.EventRequest) error {
+	if err := ValidateTenantID(request.Ingest.Tenant); err != nil {
+		return err
+	}
+	if message := eventViolation(request, time.Now()); message != "" {
+		return fiber.NewError(fiber.StatusBadRequest, message)
+	}
+	return nil
+}
+
+// eventViolation returns why an event is invalid, empty if it is valid. Messages are constants so that
+// validating large batches allocates nothing for valid events. The tenant is checked by the callers.
+func eventViolation(request *domain.EventRequest, now time.Time) string {
 	if strings.TrimSpace(request.EventName) == "" {
-		return fiber.NewError(fiber.StatusBadRequest, "event_name is required")
+		return "event_name is required"
 	}
 	if strings.TrimSpace(request.Channel) == "" {
-		return fiber.NewError(fiber.StatusBadRequest, "channel is required")
+		return "channel is required"
+	}
+	if message := allowedValuesViolation(request); message != "" {
+		return message
+	}
+	if request.Timestamp < 0 || request.TimestampMS < 0 {
+		return "timestamp and timestamp_ms must be positive integers"
 	}
-	if request.Timestamp <= 0 {
-		return fiber.NewError(fiber.StatusBadRequest, "timestamp is required and must be a positive integer")
+	if request.Timestamp == 0 && request.TimestampMS == 0 {
+		return "timestamp is required and must be a positive integer"
 	}
-	// TODO: add 1 second slack to account for possible clock time differences among the clients and the backend service
-	if request.Timestamp > time.Now().UTC().Unix() {
-		return fiber.NewError(fiber.StatusBadRequest, "timestamp cannot be in the future")
+	// Clients may send both for compatibility with second precision, they must refer to the same time
+	if request.Timestamp > 0 && request.TimestampMS > 0 {
+		seconds := request.Timestamp
+		if seconds >= domain.MillisecondThreshold {
+			seconds /= 1000
+		}
+		if seconds != request.TimestampMS/1000 {
+			return "timestamp and timestamp_ms refer to different times"
+		}
+	}
+	if message := timeWindow.check(request, now); message != "" {
+		return message
 	}
 	if request.UserID == "" {
-		return fiber.NewError(fiber.StatusBadRequest, "user_id is required")
+		return "user_id is required"
 	}
 	if request.CampaignID == "" {
-		return fiber.NewError(fiber.StatusBadRequest, "campaign_id is required")
+		return "campaign_id is required"
 	}
 	if request.Tags == nil {
-		return fiber.NewError(fiber.StatusBadRequest, "tags is required")
+		return "tags is required"
 	}
 	for _, tag := range request.Tags {
 		if strings.TrimSpace(tag) == "" {
-			return fiber.NewError(fiber.StatusBadRequest, "tags cannot be empty")
+			return "tags cannot be empty"
 		}
 	}
 	if request.Metadata == nil {
-		return fiber.NewError(fiber.StatusBadRequest, "metadata is required")
+		return "metadata is required"
 	}
-	for key, _ := range request.Metadata {
+	for key := range request.Metadata {
 		if strings.TrimSpace(key) == "" {
-			return fiber.NewError(fiber.StatusBadRequest, "metadata keys cannot be empty")
+			return "metadata keys cannot be empty"
 		}
 	}
-	return nil
+	return metadataSchemaViolation(request)
+}
+
+// MaxTopK is the maximum number of buckets of a top_k query, the estimates get less accurate and slower with more
+const MaxTopK = 1000
+
+// TopKGroupings are the /metrics groupings whose top buckets can be estimated, the ones of a column
+var TopKGroupings = []string{"campaign_id", "channel", "event_name", "tags", "user_id"}
+
+// MaxBatchMetricQueries is the maximum number of queries in a metric batch
+const MaxBatchMetricQueries = 50
+
+// ValidateMetricBatchRequest validates each query of a batch, errors name the index of the invalid query
+func ValidateMetricBatchRequest(request *domain.MetricBatchRequest) error {
+	if len(request.Queries) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "queries cannot be empty")
+	}
+	if len(request.Queries) > MaxBatchMetricQueries {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("a batch holds at most %d queries", MaxBatchMetricQueries))
+	}
+	for i := range request.Queries {
+		if err := ValidateMetricRequest(&request.Queries[i]); err != nil {
+			if _, ok := err.(*ColumnAccessError); ok || errors.Is(err, ErrMissingAPIKeyRole) {
+				return fmt.Errorf("queries[%d]: %w", i, err)
+			}
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("queries[%d]: %s", i, err.Error()))
+		}
+	}
+	// The watermark of the batch is checked like the one of a query
+	return ValidateMetricRequest(&domain.MetricRequest{AsOf: request.AsOf})
 }
 
 func ValidateMetricRequest(request *domain.MetricRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
 	if request.From != nil {
 		// From timestamp must be a positive and not in the future
 		if *request.From <= 0 {
@@ -72,6 +157,15 @@ func ValidateMetricRequest(request *domain.MetricRequest) error {
 			return fiber.NewError(fiber.StatusBadRequest, "from cannot be greater than to")
 		}
 	}
+	if request.AsOf != nil {
+		// Watermarks are ingestion times, they cannot be in the future either
+		if *request.AsOf <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "as_of must be a positive integer")
+		}
+		if *request.AsOf > time.Now().UTC().Unix() {
+			return fiber.NewError(fiber.StatusBadRequest, "as_of cannot be in the future")
+		}
+	}
 
 	if request.GroupBy != nil {
 		if strings.TrimSpace(*request.GroupBy) == "" {
@@ -85,18 +179,136 @@ func ValidateMetricRequest(request *domain.MetricRequest) error {
 		}
 	}
 
-	return nil
+	if request.Tag != nil {
+		if parent, ok := domain.TagPrefix(*request.Tag); ok && strings.TrimSpace(parent) == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "tag must name a tag before "+domain.TagSeparator)
+		}
+	}
+	if request.TagDepth != nil {
+		if request.GroupBy == nil || *request.GroupBy != "tags" {
+			return fiber.NewError(fiber.StatusBadRequest, "tag_depth requires group_by=tags")
+		}
+		if *request.TagDepth < 1 {
+			return fiber.NewError(fiber.StatusBadRequest, "tag_depth must be at least 1")
+		}
+	}
+
+	if request.MaxAbuseScore != nil && (*request.MaxAbuseScore < 0 || *request.MaxAbuseScore > 100) {
+		return fiber.NewError(fiber.StatusBadRequest, "max_abuse_score must be between 0 and 100")
+	}
+
+	if request.TopK != nil {
+		if *request.TopK < 1 || *request.TopK > MaxTopK {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("top_k must be between 1 and %d", MaxTopK))
+		}
+		if request.GroupBy == nil || !slices.Contains(TopKGroupings, *request.GroupBy) {
+			return fiber.NewError(fiber.StatusBadRequest, "top_k requires group_by to be one of "+strings.Join(TopKGroupings, ", "))
+		}
+		if request.Enrich != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "top_k cannot be combined with enrich")
+		}
+	}
+
+	if request.AnnotateAnomalies {
+		// The series of other groupings have no seasons to compare a bucket to
+		if request.GroupBy == nil || (*request.GroupBy != "hour" && *request.GroupBy != "day") {
+			return fiber.NewError(fiber.StatusBadRequest, "annotate_anomalies requires group_by hour or day")
+		}
+	}
+
+	if request.Enrich != nil {
+		if err := ValidateDimensionName(*request.Enrich); err != nil {
+			return err
+		}
+		// Dimensions are keyed by the values of a field, not by time buckets
+		if request.GroupBy == nil || !slices.Contains(EnrichableGroupings, *request.GroupBy) {
+			return fiber.NewError(fiber.StatusBadRequest,
+				"enrich requires group_by to be one of "+strings.Join(EnrichableGroupings, ", "))
+		}
+	}
+
+	return validateColumnAccess(request.Role, metricColumns(request)...)
 }
 
 const (
-	// MaxBulkEventCount is the maximum number of events allowed in a single bulk request
+	// MaxBulkEventCount is the default maximum number of events allowed in a single bulk request
 	MaxBulkEventCount = 10000
 )
 
+// maxBulkViolations is the number of invalid events after which a bulk request stops being validated
+var maxBulkViolations = 10
+
+// SetMaxBulkViolations sets the number of invalid events reported per bulk request, at least one
+func SetMaxBulkViolations(n int) {
+	maxBulkViolations = max(n, 1)
+}
+
+// BulkValidationError lists the invalid events of a bulk request or stream chunk, up to the maximum number of violations
+type BulkValidationError struct {
+	Violations []domain.EventViolation
+	Truncated  bool   // more events are invalid, validation stopped at the maximum
+	position   string // "index" for bulk requests, "line" for streams and backfill chunks
+}
+
+func (e *BulkValidationError) Error() string {
+	var b strings.Builder
+	for i, violation := range e.Violations {
+		if i == 0 {
+			b.WriteString("validation failed for event at ")
+		} else {
+			b.WriteString("; ")
+		}
+		b.WriteString(e.position)
+		b.WriteByte(' ')
+		b.WriteString(strconv.Itoa(violation.Index))
+		b.WriteString(": ")
+		b.WriteString(violation.Message)
+	}
+	if e.Truncated {
+		b.WriteString("; more events are invalid")
+	}
+	return b.String()
+}
+
+// validateEvents checks each event against the same clock, collecting violations until the maximum is reached.
+// The violation slice is only allocated once an event is invalid. Indexes are reported from base, or as the line of the event in an NDJSON body.
+func validateEvents(events []domain.EventRequest, base int, position string) error {
+	if len(events) == 0 {
+		return nil
+	}
+	// Events of a request share their tenant, taken from the request headers
+	if err := ValidateTenantID(events[0].Ingest.Tenant); err != nil {
+		return err
+	}
+	now := time.Now()
+	var violations []domain.EventViolation
+	for i := range events {
+		message := eventViolation(&events[i], now)
+		if message == "" {
+			continue
+		}
+		if violations == nil {
+			violations = make([]domain.EventViolation, 0, maxBulkViolations)
+		}
+		if len(violations) == maxBulkViolations {
+			return &BulkValidationError{Violations: violations, Truncated: true, position: position}
+		}
+		index := base + i
+		if events[i].Ingest.Line > 0 {
+			index = events[i].Ingest.Line
+		}
+		violations = append(violations, domain.EventViolation{Index: index, Message: message})
+	}
+	if violations == nil {
+		return nil
+	}
+	return &BulkValidationError{Violations: violations, position: position}
+}
+
 // ValidateBulkEventRequest validates a bulk event request
 // It checks batch size limits and validates each individual event
-// Returns an error if any validation fails (all-or-nothing approach)
-func ValidateBulkEventRequest(request *domain.BulkEventRequest) error {
+// Returns an error if any validation fails (all-or-nothing approach), a *BulkValidationError for invalid events
+func ValidateBulkEventRequest(request *domain.BulkEventRequest, maxEvents int) error {
 	if request == nil {
 		return fiber.NewError(fiber.StatusBadRequest, "bulk event request is required")
 	}
@@ -106,18 +318,249 @@ func ValidateBulkEventRequest(request *domain.BulkEventRequest) error {
 	if len(request.Events) == 0 {
 		return fiber.NewError(fiber.StatusBadRequest, "events array cannot be empty")
 	}
-	if len(request.Events) > MaxBulkEventCount {
-		return fiber.NewError(fiber.StatusBadRequest, 
-			"events array exceeds maximum allowed size")
+	if len(request.Events) > maxEvents {
+		return fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("events array exceeds maximum allowed size of %d", maxEvents))
 	}
 
-	// Validate each event in the batch
-	for i, event := range request.Events {
-		if err := ValidateEventRequest(&event); err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, 
-				fmt.Sprintf("validation failed for event at index %d: %v", i, err))
+	return validateEvents(request.Events, 0, "index")
+}
+
+func ValidateExportRequest(request *domain.ExportRequest) error {
+	if request.Format != domain.ExportFormatParquet {
+		return fiber.NewError(fiber.StatusBadRequest, "format must be "+domain.ExportFormatParquet)
+	}
+	if err := ValidateMetricRequest(&request.Filter); err != nil {
+		return err
+	}
+	// The events are exported with all their columns, a role denied one of them cannot export
+	return validateColumnAccess(request.Filter.Role, domain.ExportedColumns...)
+}
+
+// ValidateMetricDiffRequest validates the windows of a diff and their filters. Time buckets differ between the windows,
+// a diff is grouped by a field or not at all.
+func ValidateMetricDiffRequest(request *domain.MetricDiffRequest) error {
+	windows := []struct {
+		name     string
+		from, to int64
+	}{{"base", request.BaseFrom, request.BaseTo}, {"compare", request.CompareFrom, request.CompareTo}}
+	now := time.Now().UTC().Unix()
+	for _, w := range windows {
+		if w.from <= 0 || w.to <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, w.name+"_from and "+w.name+"_to must be positive integers")
 		}
+		if w.from > now || w.to > now {
+			return fiber.NewError(fiber.StatusBadRequest, w.name+"_from and "+w.name+"_to cannot be in the future")
+		}
+		if w.from > w.to {
+			return fiber.NewError(fiber.StatusBadRequest, w.name+"_from cannot be greater than "+w.name+"_to")
+		}
+	}
+	if request.Filter.GroupBy != nil {
+		if _, ok := timeGroupings[*request.Filter.GroupBy]; ok {
+			return fiber.NewError(fiber.StatusBadRequest, "diffs cannot be grouped by time buckets, they differ between the windows")
+		}
+	}
+	if request.Filter.Enrich != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "diffs cannot be enriched")
+	}
+	return ValidateMetricRequest(&request.Filter)
+}
+
+// ValidatePacingRequest validates the dimension, the spend field and the budget period of a pacing query, and its filters
+func ValidatePacingRequest(request *domain.PacingRequest) error {
+	if err := ValidateDimensionName(request.Dimension); err != nil {
+		return err
+	}
+	if err := ValidateDimensionName(request.Budget); err != nil {
+		return err
+	}
+	if key, ok := strings.CutPrefix(request.SpendField, domain.MetadataFieldPrefix); !ok || key == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "spend_field must be a metadata.<key> field")
+	}
+	if request.From <= 0 || request.To <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "from and to must be positive integers")
+	}
+	if request.From > time.Now().UTC().Unix() {
+		return fiber.NewError(fiber.StatusBadRequest, "from cannot be in the future")
+	}
+	if request.From >= request.To {
+		return fiber.NewError(fiber.StatusBadRequest, "from must be before to")
+	}
+	if err := ValidateMetricRequest(&request.Filter); err != nil {
+		return err
+	}
+	// Campaigns are the buckets of the report, their spend is read from the metadata
+	return validateColumnAccess(request.Filter.Role, "campaign_id", "metadata")
+}
+
+func ValidateRealtimeMetricRequest(request *domain.RealtimeMetricRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	if request.Minutes < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "minutes cannot be negative")
+	}
+	if request.EventName != nil && strings.TrimSpace(*request.EventName) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "event_name cannot be empty if provided")
+	}
+	if request.Channel != nil && strings.TrimSpace(*request.Channel) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "channel cannot be empty if provided")
 	}
+	var columns []string
+	if request.EventName != nil {
+		columns = append(columns, "event_name")
+	}
+	if request.Channel != nil {
+		columns = append(columns, "channel")
+	}
+	return validateColumnAccess(request.Role, columns...)
+}
+
+// MaxForecastHistory is the maximum number of past buckets a forecast is fitted on
+const MaxForecastHistory = 24 * 90
+
+// ForecastSeasons are the buckets per season of the groupings a forecast can be computed for
+var ForecastSeasons = map[string]int{
+	"hour": 24, // daily season
+	"day":  7,  // weekly season
+}
+
+func ValidateForecastRequest(request *domain.ForecastRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	season, ok := ForecastSeasons[request.GroupBy]
+	if !ok {
+		return fiber.NewError(fiber.StatusBadRequest, "group_by must be hour or day")
+	}
+	if request.History != 0 && (request.History < 2*season || request.History > MaxForecastHistory) {
+		return fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("history must be between %d and %d buckets", 2*season, MaxForecastHistory))
+	}
+	if request.Horizon < 0 || request.Horizon > MaxForecastHistory {
+		return fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("horizon must be between 1 and %d buckets", MaxForecastHistory))
+	}
+	if request.EventName != nil && strings.TrimSpace(*request.EventName) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "event_name cannot be empty if provided")
+	}
+	return validateColumnAccess(request.Role, metricColumns(&domain.MetricRequest{EventName: request.EventName, Tag: request.Tag})...)
+}
+
+func ValidateIntervalRequest(request *domain.IntervalRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	if (request.FromEvent == nil) != (request.ToEvent == nil) {
+		return fiber.NewError(fiber.StatusBadRequest, "from_event and to_event must be provided together")
+	}
+	if request.FromEvent != nil && (strings.TrimSpace(*request.FromEvent) == "" || strings.TrimSpace(*request.ToEvent) == "") {
+		return fiber.NewError(fiber.StatusBadRequest, "from_event and to_event cannot be empty if provided")
+	}
+	// The time range is checked like the one of /metrics, the events paired are filtered by name
+	metricRequest := domain.MetricRequest{From: request.From, To: request.To, EventName: request.FromEvent, Role: request.Role}
+	return ValidateMetricRequest(&metricRequest)
+}
+
+// MaxDriftDays is the maximum number of recent or baseline days of a schema drift request
+const MaxDriftDays = 365
 
+func ValidateSchemaDriftRequest(request *domain.SchemaDriftRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	if request.Days < 0 || request.Days > MaxDriftDays || request.BaselineDays < 0 || request.BaselineDays > MaxDriftDays {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("days and baseline_days must be between 1 and %d", MaxDriftDays))
+	}
+	if request.MinChange < 0 || request.MinChange > 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "min_change must be between 0 and 1")
+	}
+	if request.EventName != nil && strings.TrimSpace(*request.EventName) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "event_name cannot be empty if provided")
+	}
+	return nil
+}
+
+const (
+	// MaxStatsDays is the maximum number of days of events whose column statistics are estimated
+	MaxStatsDays = 92
+	// MaxStatsTop is the maximum number of most frequent values reported per column
+	MaxStatsTop = 100
+)
+
+func ValidateColumnStatsRequest(request *domain.ColumnStatsRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	if request.Days < 0 || request.Days > MaxStatsDays {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("days must be between 1 and %d", MaxStatsDays))
+	}
+	if request.Top < 0 || request.Top > MaxStatsTop {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("top must be between 1 and %d", MaxStatsTop))
+	}
 	return nil
 }
+
+func ValidateUserSummaryRequest(request *domain.UserSummaryRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	if strings.TrimSpace(request.UserID) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "user id is required")
+	}
+	return validateColumnAccess(request.Role, "user_id")
+}
+
+// MaxStreamIDLength is the maximum length of stream ids and checkpoint tokens
+const MaxStreamIDLength = 256
+
+// ValidateStreamEventRequest validates a stream chunk of at most maxEvents and each of its events (all-or-nothing approach)
+func ValidateStreamEventRequest(request *domain.StreamEventRequest, maxEvents int) error {
+	if strings.TrimSpace(request.StreamID) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "X-Stream-ID header is required")
+	}
+	if strings.TrimSpace(request.CheckpointToken) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "X-Checkpoint-Token header is required")
+	}
+	if len(request.StreamID) > MaxStreamIDLength || len(request.CheckpointToken) > MaxStreamIDLength {
+		return fiber.NewError(fiber.StatusBadRequest,
+			fmt.Sprintf("stream id and checkpoint token cannot be longer than %d characters", MaxStreamIDLength))
+	}
+	if len(request.Events) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "stream chunk cannot be empty")
+	}
+	if len(request.Events) > maxEvents {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("stream chunk exceeds maximum allowed size of %d", maxEvents))
+	}
+
+	// Events are reported by their line in the NDJSON body, numbered from 1 and counting blank lines
+	return validateEvents(request.Events, 1, "line")
+}
+
+// backfillIDPattern restricts backfill job ids to names usable as directory names
+var backfillIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidateBackfillID validates the id of a backfill job, chosen by the client
+func ValidateBackfillID(id string) error {
+	if !backfillIDPattern.MatchString(id) {
+		return fiber.NewError(fiber.StatusBadRequest, "backfill id must be 1 to 64 letters, digits, '-' or '_'")
+	}
+	return nil
+}
+
+// ValidateBackfillRequest validates a chunk of historical events uploaded to a backfill job
+func ValidateBackfillRequest(request *domain.BackfillRequest, maxEvents int) error {
+	if err := ValidateBackfillID(request.ID); err != nil {
+		return err
+	}
+	if len(request.Events) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "backfill chunk cannot be empty")
+	}
+	if len(request.Events) > maxEvents {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("backfill chunk exceeds maximum allowed size of %d", maxEvents))
+	}
+
+	// Events are reported by their line in the NDJSON body, numbered from 1 and counting blank lines
+	return validateEvents(request.Events, 1, "line")
+}
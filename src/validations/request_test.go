@@ -0,0 +1,57 @@
+package validations
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"testing"
+	"time"
+)
+
+// benchmarkBulkSize is the size of the bulk requests of the benchmarks
+const benchmarkBulkSize = 10000
+
+func benchmarkBulkRequest(n int) *domain.BulkEventRequest {
+	now := time.Now().Unix()
+	events := make([]domain.EventRequest, n)
+	for i := range events {
+		events[i] = domain.EventRequest{
+			EventName:  "product_view",
+			Channel:    "web",
+			CampaignID: fmt.Sprintf("campaign_%d", i%50),
+			UserID:     fmt.Sprintf("user_%d", i),
+			Timestamp:  now - int64(i%3600),
+			Tags:       []string{"electronics", "homepage"},
+			Metadata:   map[string]any{"product_id": fmt.Sprintf("prod-%d", i)},
+		}
+	}
+	return &domain.BulkEventRequest{Events: events}
+}
+
+// BenchmarkValidateBulkEventRequest measures the validation of a bulk request of valid events, which reads the clock once
+// per request and allocates nothing per event (valid), and of one whose events are all invalid, which stops after
+// the first BULK_MAX_VALIDATION_ERRORS violations (invalid)
+func BenchmarkValidateBulkEventRequest(b *testing.B) {
+	b.Run("valid", func(b *testing.B) {
+		request := benchmarkBulkRequest(benchmarkBulkSize)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := ValidateBulkEventRequest(request, benchmarkBulkSize); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("invalid", func(b *testing.B) {
+		request := benchmarkBulkRequest(benchmarkBulkSize)
+		for i := range request.Events {
+			request.Events[i].UserID = ""
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := ValidateBulkEventRequest(request, benchmarkBulkSize); err == nil {
+				b.Fatal("invalid events were accepted")
+			}
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package validations
+
+import (
+	"kucukaslan/clickhouse/domain"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxRewriteValueLength bounds the values of rewrite rules, they are event names and channels
+const maxRewriteValueLength = 256
+
+// ValidateRewriteRuleRequest validates a rewrite rule
+func ValidateRewriteRuleRequest(request *domain.RewriteRuleRequest) error {
+	if err := ValidateTenantID(request.Tenant); err != nil {
+		return err
+	}
+	if err := validateRewriteField(request.Field); err != nil {
+		return err
+	}
+	if strings.TrimSpace(request.From) == "" || strings.TrimSpace(request.To) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "from and to are required")
+	}
+	if len(request.From) > maxRewriteValueLength || len(request.To) > maxRewriteValueLength {
+		return fiber.NewError(fiber.StatusBadRequest, "from and to must be at most 256 bytes")
+	}
+	if request.From == request.To {
+		return fiber.NewError(fiber.StatusBadRequest, "from and to must differ")
+	}
+	return nil
+}
+
+// ValidateRewriteRuleDeletion validates the field and value of a rewrite rule to delete
+func ValidateRewriteRuleDeletion(tenant, field, from string) error {
+	if err := ValidateTenantID(tenant); err != nil {
+		return err
+	}
+	if err := validateRewriteField(field); err != nil {
+		return err
+	}
+	if from == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "from is required")
+	}
+	return nil
+}
+
+func validateRewriteField(field string) error {
+	switch field {
+	case domain.RewriteFieldEventName, domain.RewriteFieldChannel:
+		return nil
+	}
+	return fiber.NewError(fiber.StatusBadRequest, "field must be event_name or channel")
+}
@@ -0,0 +1,308 @@
+package validations
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"math"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Metadata value types that can be declared in a schema
+const (
+	TypeNumber  = "number"
+	TypeString  = "string"
+	TypeBoolean = "boolean"
+)
+
+// Modes for handling metadata values that do not match the declared type
+const (
+	SchemaMismatchCoerce = "coerce" // convert the value to the declared type when possible, reject otherwise
+	SchemaMismatchReject = "reject" // always reject mismatching values
+)
+
+// maxSchemaViolations bounds the violations of an event reported in its validation error
+const maxSchemaViolations = 5
+
+// EventSchema is the JSON Schema of the metadata of an event name
+type EventSchema struct {
+	// Properties are the top-level metadata keys declaring a number, string or boolean type, the ones coerced in coerce mode
+	Properties map[string]PropertySchema
+
+	keys   []string // property names in a stable order, so that values are coerced deterministically
+	schema *jsonschema.Schema
+}
+
+// PropertySchema describes a single metadata key
+type PropertySchema struct {
+	Type string `json:"type"`
+}
+
+// CompileEventSchema compiles the JSON Schema of the metadata of an event name, e.g.
+// {"type": "object", "required": ["price"], "properties": {"price": {"type": "number", "minimum": 0}}}.
+// Schemas without $schema follow draft 2020-12, the bool type of earlier schema files is read as boolean.
+func CompileEventSchema(eventName string, data []byte) (EventSchema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return EventSchema{}, fmt.Errorf("invalid schema of %s: %w", eventName, err)
+	}
+	object, ok := doc.(map[string]any)
+	if !ok {
+		return EventSchema{}, fmt.Errorf("invalid schema of %s: not a JSON object", eventName)
+	}
+
+	schema := EventSchema{Properties: make(map[string]PropertySchema)}
+	properties, _ := object["properties"].(map[string]any)
+	for key, value := range properties {
+		property, ok := value.(map[string]any)
+		if !ok {
+			continue
+		}
+		if property["type"] == "bool" {
+			property["type"] = TypeBoolean
+		}
+		switch property["type"] {
+		case TypeNumber, TypeString, TypeBoolean:
+			schema.Properties[key] = PropertySchema{Type: property["type"].(string)}
+			schema.keys = append(schema.keys, key)
+		}
+	}
+	sort.Strings(schema.keys)
+
+	url := "mem:///" + neturl.PathEscape(eventName) + ".json"
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(url, doc); err != nil {
+		return EventSchema{}, fmt.Errorf("invalid schema of %s: %w", eventName, err)
+	}
+	if schema.schema, err = compiler.Compile(url); err != nil {
+		return EventSchema{}, fmt.Errorf("invalid schema of %s: %w", eventName, err)
+	}
+	return schema, nil
+}
+
+// CompileEventSchemas compiles the JSON Schemas of event names, see CompileEventSchema
+func CompileEventSchemas(documents map[string]json.RawMessage) (map[string]EventSchema, error) {
+	schemas := make(map[string]EventSchema, len(documents))
+	for eventName, data := range documents {
+		schema, err := CompileEventSchema(eventName, data)
+		if err != nil {
+			return nil, err
+		}
+		schemas[eventName] = schema
+	}
+	return schemas, nil
+}
+
+// SchemaRegistry maps event names to their schemas
+type SchemaRegistry struct {
+	mu           sync.RWMutex
+	schemas      map[string]EventSchema
+	mismatchMode string
+}
+
+// NewSchemaRegistry creates a registry with the given schemas
+func NewSchemaRegistry(schemas map[string]EventSchema, mismatchMode string) (*SchemaRegistry, error) {
+	if mismatchMode != SchemaMismatchCoerce && mismatchMode != SchemaMismatchReject {
+		return nil, fmt.Errorf("unknown schema mismatch mode %q", mismatchMode)
+	}
+	return &SchemaRegistry{schemas: schemas, mismatchMode: mismatchMode}, nil
+}
+
+// ReadSchemaFiles reads the JSON Schemas of event names from a JSON file mapping event names to schemas, e.g.
+// {"purchase": {"properties": {"price": {"type": "number"}, "currency": {"type": "string"}}}},
+// or from a directory holding a <event_name>.json file per event name
+func ReadSchemaFiles(path string) (map[string]json.RawMessage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema file: %w", err)
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema file: %w", err)
+		}
+		var documents map[string]json.RawMessage
+		if err := json.Unmarshal(data, &documents); err != nil {
+			return nil, fmt.Errorf("failed to parse schema file: %w", err)
+		}
+		return documents, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schema files: %w", err)
+	}
+	documents := make(map[string]json.RawMessage, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema file: %w", err)
+		}
+		documents[strings.TrimSuffix(filepath.Base(file), ".json")] = data
+	}
+	return documents, nil
+}
+
+// LoadSchemaRegistry creates a registry with the schemas of a file or directory, see ReadSchemaFiles
+func LoadSchemaRegistry(path string, mismatchMode string) (*SchemaRegistry, error) {
+	documents, err := ReadSchemaFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	schemas, err := CompileEventSchemas(documents)
+	if err != nil {
+		return nil, err
+	}
+	return NewSchemaRegistry(schemas, mismatchMode)
+}
+
+// Get returns the schema registered for an event name
+func (r *SchemaRegistry) Get(eventName string) (EventSchema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	schema, ok := r.schemas[eventName]
+	return schema, ok
+}
+
+// Replace replaces the schemas of the registry, e.g. once reloaded from their table
+func (r *SchemaRegistry) Replace(schemas map[string]EventSchema) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas = schemas
+}
+
+var schemaRegistry *SchemaRegistry
+
+// SetSchemaRegistry sets the registry used to validate event metadata, nil disables schema validation
+func SetSchemaRegistry(registry *SchemaRegistry) {
+	schemaRegistry = registry
+}
+
+// metadataSchemaViolation validates the metadata of an event against the JSON Schema registered for its name, returning
+// the violations if any. In coerce mode values not matching the type of a top-level property are converted in place first,
+// e.g. "129.99" to 129.99 for a number.
+func metadataSchemaViolation(request *domain.EventRequest) string {
+	if schemaRegistry == nil {
+		return ""
+	}
+	schema, ok := schemaRegistry.Get(request.EventName)
+	if !ok {
+		return ""
+	}
+
+	if schemaRegistry.mismatchMode == SchemaMismatchCoerce {
+		for _, key := range schema.keys {
+			value, exists := request.Metadata[key]
+			expected := schema.Properties[key].Type
+			if !exists || matchesType(value, expected) {
+				continue
+			}
+			if coerced, ok := coerceValue(value, expected); ok {
+				request.Metadata[key] = coerced
+			}
+		}
+	}
+
+	err := schema.schema.Validate(request.Metadata)
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return ""
+	}
+	return schemaViolations(validationErr)
+}
+
+// schemaViolations describes the violations of a validation error by metadata field, e.g.
+// metadata.price: minimum: got -1, want 0; metadata: missing property 'currency'
+func schemaViolations(err *jsonschema.ValidationError) string {
+	var violations []string
+	for _, unit := range err.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		location := "metadata" + strings.ReplaceAll(unit.InstanceLocation, "/", ".")
+		violations = append(violations, location+": "+unit.Error.String())
+	}
+	sort.Strings(violations)
+	if len(violations) > maxSchemaViolations {
+		violations = append(violations[:maxSchemaViolations], fmt.Sprintf("and %d more", len(violations)-maxSchemaViolations))
+	}
+	return strings.Join(violations, "; ")
+}
+
+func matchesType(value any, expected string) bool {
+	switch value.(type) {
+	case float64, json.Number:
+		return expected == TypeNumber
+	case string:
+		return expected == TypeString
+	case bool:
+		return expected == TypeBoolean
+	}
+	return false
+}
+
+// coerceValue converts a value to the expected type if it has an unambiguous representation in it
+func coerceValue(value any, expected string) (any, bool) {
+	switch expected {
+	case TypeNumber:
+		if str, ok := value.(string); ok {
+			// NaN and infinities cannot be stored as JSON
+			if number, err := strconv.ParseFloat(str, 64); err == nil && !math.IsNaN(number) && !math.IsInf(number, 0) {
+				return number, true
+			}
+		}
+	case TypeString:
+		switch v := value.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	case TypeBoolean:
+		if str, ok := value.(string); ok {
+			if b, err := strconv.ParseBool(str); err == nil {
+				return b, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// maxEventSchemaBytes bounds a schema managed through /schemas
+const maxEventSchemaBytes = 64 << 10
+
+// ValidateEventSchemaRequest validates a schema managed through /schemas, which must compile
+func ValidateEventSchemaRequest(request *domain.EventSchemaRequest) error {
+	if err := ValidateEventSchemaName(request.EventName); err != nil {
+		return err
+	}
+	if len(request.Schema) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "schema is required")
+	}
+	if len(request.Schema) > maxEventSchemaBytes {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("schema must be at most %d bytes", maxEventSchemaBytes))
+	}
+	if _, err := CompileEventSchema(request.EventName, request.Schema); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+// ValidateEventSchemaName validates the event name of a schema managed through /schemas
+func ValidateEventSchemaName(eventName string) error {
+	if strings.TrimSpace(eventName) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "event_name is required")
+	}
+	return nil
+}
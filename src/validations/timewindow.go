@@ -0,0 +1,89 @@
+package validations
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"kucukaslan/clickhouse/telemetry"
+	"time"
+)
+
+// Policies for event times outside the accepted window
+const (
+	TimePolicyReject = "reject" // reject the event
+	TimePolicyClamp  = "clamp"  // move the time of an event from the future to its receipt, of a too old event to the maximum age
+)
+
+var eventTimeOutOfWindowTotal = telemetry.NewCounterVec("event_time_out_of_window_total",
+	"Number of events whose time was too far in the future or too old, by bound and whether they were clamped or rejected", "bound", "result")
+
+// TimeWindow is the window event times must fall in: at most FutureSkew after the time of receipt, to tolerate clocks
+// running slightly ahead, and at most MaxAge before it, so that late events do not create parts in long-merged partitions
+type TimeWindow struct {
+	FutureSkew time.Duration
+	MaxAge     time.Duration // 0 accepts any age
+	Policy     string
+
+	// Messages are built once, so that validating valid events allocates nothing
+	futureMessage string
+	ageMessage    string
+}
+
+// NewTimeWindow checks the bounds and the policy of a window
+func NewTimeWindow(futureSkewSeconds, maxAgeDays int, policy string) (*TimeWindow, error) {
+	if futureSkewSeconds < 0 || maxAgeDays < 0 {
+		return nil, fmt.Errorf("future skew and maximum age of event times cannot be negative")
+	}
+	if policy != TimePolicyReject && policy != TimePolicyClamp {
+		return nil, fmt.Errorf("unknown event time policy %q, expected reject or clamp", policy)
+	}
+	window := &TimeWindow{
+		FutureSkew:    time.Duration(futureSkewSeconds) * time.Second,
+		MaxAge:        time.Duration(maxAgeDays) * 24 * time.Hour,
+		Policy:        policy,
+		futureMessage: "timestamp cannot be in the future",
+		ageMessage:    fmt.Sprintf("timestamp is older than the maximum age of %d days", maxAgeDays),
+	}
+	if futureSkewSeconds > 0 {
+		window.futureMessage = fmt.Sprintf("timestamp is more than %d seconds in the future", futureSkewSeconds)
+	}
+	return window, nil
+}
+
+// check clamps the time of an event into the window in place, returning why it is outside otherwise.
+// Backfilled and reprocessed events are historical by design, the maximum age does not apply to them.
+func (w *TimeWindow) check(request *domain.EventRequest, now time.Time) string {
+	eventTime := request.EventTime()
+	if eventTime.After(now.Add(w.FutureSkew)) {
+		return w.apply(request, "future", now, w.futureMessage)
+	}
+	if w.MaxAge > 0 && request.Ingest.Source != domain.SourceBackfill && request.Ingest.Source != domain.SourceReprocess {
+		if oldest := now.Add(-w.MaxAge); eventTime.Before(oldest) {
+			return w.apply(request, "max_age", oldest, w.ageMessage)
+		}
+	}
+	return ""
+}
+
+// apply moves the time of an event to a bound in clamp mode, returning the message of the violation otherwise.
+// The time sent is kept for the deduplication key, the bound depends on the time of receipt.
+func (w *TimeWindow) apply(request *domain.EventRequest, bound string, to time.Time, message string) string {
+	if w.Policy != TimePolicyClamp {
+		eventTimeOutOfWindowTotal.WithLabelValues(bound, "rejected").Inc()
+		return message
+	}
+	eventTimeOutOfWindowTotal.WithLabelValues(bound, "clamped").Inc()
+	if request.Ingest.ClampedFromMS == 0 {
+		request.Ingest.ClampedFromMS = request.EventTime().UnixMilli()
+	}
+	request.TimestampMS = 0
+	request.SetTime(to.Truncate(time.Millisecond))
+	return ""
+}
+
+// timeWindow rejects events from the future until configured, as before the window was configurable
+var timeWindow = &TimeWindow{Policy: TimePolicyReject, futureMessage: "timestamp cannot be in the future"}
+
+// SetTimeWindow sets the window event times must fall in
+func SetTimeWindow(window *TimeWindow) {
+	timeWindow = window
+}
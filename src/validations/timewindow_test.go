@@ -0,0 +1,48 @@
+package validations
+
+import (
+	"context"
+	"kucukaslan/clickhouse/database"
+	"kucukaslan/clickhouse/domain"
+	"testing"
+	"time"
+)
+
+// TestClampKeepsDeduplicationKey submits the same too old event twice, a minute apart, and expects the second submission
+// to be dropped as a duplicate although both were clamped to different times
+func TestClampKeepsDeduplicationKey(t *testing.T) {
+	window, err := NewTimeWindow(5, 30, TimePolicyClamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	received := sent.Add(60 * 24 * time.Hour)
+	submit := func(now time.Time) domain.EventRequest {
+		event := domain.EventRequest{EventName: "purchase", Channel: "web", UserID: "user123", Timestamp: sent.Unix()}
+		if message := window.check(&event, now); message != "" {
+			t.Fatalf("clamped event rejected: %s", message)
+		}
+		if got, want := event.EventTime(), now.Add(-window.MaxAge).Truncate(time.Millisecond); !got.Equal(want) {
+			t.Fatalf("event time %v, expected to be clamped to %v", got, want)
+		}
+		return event
+	}
+	first := submit(received)
+	retry := submit(received.Add(time.Minute))
+	if first.GetUniqueKey() != retry.GetUniqueKey() {
+		t.Fatalf("deduplication keys differ: %q and %q", first.GetUniqueKey(), retry.GetUniqueKey())
+	}
+
+	ctx := context.Background()
+	dedup := database.NewMemoryDeduplicator(10, time.Hour)
+	if err := dedup.SetMultipleEventsProcessed(ctx, []domain.EventRequest{first}); err != nil {
+		t.Fatal(err)
+	}
+	processed, err := dedup.IsEventProcessed(ctx, retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !processed {
+		t.Fatal("retry of a clamped event is not a duplicate, it would be stored as a second row")
+	}
+}
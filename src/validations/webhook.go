@@ -0,0 +1,50 @@
+package validations
+
+import (
+	"fmt"
+	"kucukaslan/clickhouse/domain"
+	"net"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, also used by cloud metadata endpoints
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// IsPublicAddress reports whether webhooks may be delivered to an address. Private, loopback, link-local, multicast and
+// unspecified addresses reach the service's own network, e.g. ClickHouse on localhost:8123 or the cloud metadata
+// endpoint on 169.254.169.254, and are refused.
+func IsPublicAddress(ip net.IP) bool {
+	return ip != nil && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// ValidateWebhookRequest validates a webhook registration. The host of the URL must only resolve to public addresses,
+// deliveries check the address they connect to again, as the name may resolve differently by then.
+func ValidateWebhookRequest(request *domain.WebhookRequest) error {
+	if request.URL == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "url is required")
+	}
+	u, err := url.Parse(request.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "url must be an absolute http or https URL")
+	}
+	addresses, err := net.LookupIP(u.Hostname())
+	if err != nil || len(addresses) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("url host %q cannot be resolved", u.Hostname()))
+	}
+	for _, address := range addresses {
+		if !IsPublicAddress(address) {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("url host %q resolves to %s, which is not a public address", u.Hostname(), address))
+		}
+	}
+	for _, event := range request.Events {
+		switch event {
+		case domain.WebhookBatchFlushed, domain.WebhookBatchDeadLettered:
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unknown webhook event %q", event))
+		}
+	}
+	return nil
+}